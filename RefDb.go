@@ -3,13 +3,15 @@ package git4go
 import (
 	"bytes"
 	"errors"
-	"io/ioutil"
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
 	"time"
+	"unsafe"
 )
 
 const (
@@ -33,6 +35,7 @@ type PackRef struct {
 
 type PackRefSortedCache struct {
 	lock           sync.RWMutex
+	fs             FileSystem
 	itemPathOffset int
 	pool           bool
 	items          []*PackRef
@@ -41,6 +44,13 @@ type PackRefSortedCache struct {
 	path           string
 	peelingMode    byte
 	notExist       bool
+	// loaded is set by the first reload; later reloads report the change
+	// of the file to notify
+	loaded bool
+	// evicted is set when the references were dropped to free the memory,
+	// so they must be read again even if the file was not changed
+	evicted bool
+	notify  func(event *RepositoryEvent)
 }
 
 func (c *PackRefSortedCache) clear(lock bool) {
@@ -73,10 +83,13 @@ func (c *PackRefSortedCache) upsert(key string) *PackRef {
 	return item
 }
 
+// Lookup returns the packed reference. packed-refs is read again first if
+// it was changed on the disk.
 func (c *PackRefSortedCache) Lookup(key string) *PackRef {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
+	c.reloadIfChanged(false)
 	return c.cacheMap[key]
 }
 
@@ -117,18 +130,40 @@ func (c *PackRefSortedCache) reloadIfChanged(lock bool) error {
 		defer c.lock.Unlock()
 	}
 
-	stat, err := os.Stat(c.path)
+	if c.path == "" {
+		// references of the in-memory repository are never packed
+		c.notExist = true
+		return nil
+	}
+	loaded := c.loaded
+	c.loaded = true
+	stat, err := c.fs.Stat(c.path)
 	if err != nil {
+		if loaded && !c.notExist {
+			c.changed()
+		}
 		c.notExist = true
 		return nil
 	}
+	wasMissing := c.notExist
 	c.notExist = false
-	if !c.stamp.Before(stat.ModTime()) {
+	modified := c.stamp.Before(stat.ModTime())
+	if !modified && !c.evicted {
 		// not changed
+		if loaded && wasMissing {
+			c.changed()
+		}
 		return nil
 	}
+	c.evicted = false
+	if loaded && (modified || wasMissing) {
+		c.changed()
+	}
 	c.stamp = stat.ModTime()
-	buffer, err := ioutil.ReadFile(c.path)
+	trace(TraceDebug, TraceCategoryRefs, "reload packed references", 0, map[string]interface{}{
+		"path": c.path,
+	})
+	buffer, err := c.fs.ReadFile(c.path)
 
 	if err != nil {
 		c.clear(false)
@@ -210,26 +245,44 @@ func (c *PackRefSortedCache) reloadIfChanged(lock bool) error {
 	return nil
 }
 
+// changed tells the subscribers of the repository that packed-refs was
+// changed on the disk.
+func (c *PackRefSortedCache) changed() {
+	if c.notify != nil {
+		c.notify(&RepositoryEvent{Type: RepositoryEventPackedRefsChanged})
+	}
+}
+
 type RefDb struct {
 	ignoreCase        bool
 	precomposeUnicode bool
-	repo              *Repository
-	path              string
-	cache             *PackRefSortedCache
+	// the listings of the directories for the case checks of ignoreCase
+	listings     dirListingCache
+	repo         *Repository
+	path         string
+	worktreePath string
+	cache        *PackRefSortedCache
+	// refs holds the references of an in-memory repository. It is nil for
+	// the references that are stored on the disk.
+	refsLock sync.RWMutex
+	refs     map[string][]byte
 }
 
 func (r *Repository) NewRefDb() *RefDb {
+	config := r.Config()
+
+	r.refDbLock.Lock()
+	defer r.refDbLock.Unlock()
+
 	if r.refDb != nil {
 		return r.refDb
 	}
-
 	if r.pathRepository == "" {
 		return nil
 	}
 
-	config := r.Config()
 	ignoreCase, _ := config.LookupBool("core.ignorecase")
-	precomposeUnicode, _ := config.LookupBool("core.precomposeunicode")
+	precomposeUnicode, _ := config.LookupBooleanWithDefaultValue("core.precomposeunicode")
 
 	r.refDb = &RefDb{
 		ignoreCase:        ignoreCase,
@@ -238,7 +291,7 @@ func (r *Repository) NewRefDb() *RefDb {
 	}
 
 	if r.namespace != "" {
-		buffer := bytes.NewBufferString(r.pathRepository)
+		buffer := bytes.NewBufferString(r.pathCommon)
 		for _, namespace := range strings.Split(r.namespace, "/") {
 			buffer.WriteString("refs/namespaces/")
 			buffer.WriteString(namespace)
@@ -247,18 +300,36 @@ func (r *Repository) NewRefDb() *RefDb {
 		buffer.WriteString("refs")
 		r.refDb.path = buffer.String()
 	} else {
-		r.refDb.path = r.pathRepository
+		r.refDb.path = r.pathCommon
+	}
+	if r.pathCommon != r.pathRepository {
+		r.refDb.worktreePath = r.pathRepository
 	}
 	r.refDb.cache = &PackRefSortedCache{
+		fs:       r.fs,
 		cacheMap: make(map[string]*PackRef),
 		path:     filepath.Join(r.refDb.path, GitPackedRefsFile),
 		stamp:    time.Unix(0, 0),
+		notify:   r.notify,
 	}
 	r.refDb.cache.reloadIfChanged(true)
 
 	return r.refDb
 }
 
+func newInMemoryRefDb(r *Repository) *RefDb {
+	return &RefDb{
+		repo: r,
+		cache: &PackRefSortedCache{
+			cacheMap: make(map[string]*PackRef),
+			notExist: true,
+		},
+		refs: map[string][]byte{
+			GitHeadFile: []byte(GitSymbolReference + "refs/heads/master\n"),
+		},
+	}
+}
+
 func searchEndLine(buffer []byte, start int) int {
 	eof := len(buffer)
 	for i := start; i < eof; i++ {
@@ -269,43 +340,296 @@ func searchEndLine(buffer []byte, start int) int {
 	return 0
 }
 
+// hasExactCase checks that every component of the name exists on the disk
+// with the same case. On case insensitive file systems "refs/heads/Master"
+// opens the file of "refs/heads/master", and that must not be treated as
+// the same reference. Names on the disk are compared after precomposition
+// because macOS returns decomposed names. The listings of the directories
+// are kept in the cache, if it is not nil, until their mtime changes.
+func hasExactCase(fsys FileSystem, base, name string, precompose bool, cache *dirListingCache) bool {
+	dir := base
+	for _, component := range strings.Split(name, "/") {
+		names, err := cache.names(fsys, dir, precompose)
+		if err != nil || !names[component] {
+			return false
+		}
+		dir = filepath.Join(dir, component)
+	}
+	return true
+}
+
+// dirListingCacheSize is how many directories a dirListingCache keeps. It
+// is emptied when it is full, like the string pool.
+const dirListingCacheSize = 1024
+
+// dirListingCache keeps the names of the entries of directories.
+type dirListingCache struct {
+	lock sync.Mutex
+	dirs map[string]*dirListing
+}
+
+type dirListing struct {
+	mtime int64
+	names map[string]bool
+}
+
+func (c *dirListingCache) names(fsys FileSystem, dir string, precompose bool) (map[string]bool, error) {
+	var mtime int64
+	if c != nil {
+		stat, err := fsys.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		mtime = stat.ModTime().UnixNano()
+		c.lock.Lock()
+		listing := c.dirs[dir]
+		c.lock.Unlock()
+		if listing != nil && listing.mtime == mtime {
+			return listing.names, nil
+		}
+	}
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		names[precomposePath(entry.Name(), precompose)] = true
+	}
+	if c != nil {
+		c.lock.Lock()
+		if c.dirs == nil || len(c.dirs) >= dirListingCacheSize {
+			c.dirs = make(map[string]*dirListing)
+		}
+		c.dirs[dir] = &dirListing{mtime: mtime, names: names}
+		c.lock.Unlock()
+	}
+	return names, nil
+}
+
+func (c *dirListingCache) size() (int, uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var size uint64
+	for dir, listing := range c.dirs {
+		size += uint64(len(dir)) + uint64(unsafe.Sizeof(*listing))
+		for name := range listing.names {
+			size += uint64(len(name)) + uint64(unsafe.Sizeof(name)) + 1
+		}
+	}
+	return len(c.dirs), size
+}
+
+func (c *dirListingCache) clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.dirs = nil
+}
+
 func (r *RefDb) Lookup(name string) (*Reference, error) {
-	refFile, err := ioutil.ReadFile(filepath.Join(r.path, name))
+	if traceEnabled(TraceTrace) {
+		trace(TraceTrace, TraceCategoryRefs, "lookup reference", 0, map[string]interface{}{
+			"name": name,
+		})
+	}
+	refFile, err := r.readLoose(name)
 	if err == nil {
-		refString := string(refFile)
-		if strings.HasPrefix(refString, GitSymbolReference) {
-			ref := &Reference{
-				refType:        ReferenceSymbolic,
-				targetSymbolic: strings.TrimSpace(refString[len(GitSymbolReference):]),
-				repo:           r.repo,
+		return r.parseLoose(name, refFile)
+	}
+	return r.packedReference(name, r.cache.Lookup(name))
+}
+
+// readLoose returns the content of the loose reference, or of the reference
+// of an in-memory repository.
+func (r *RefDb) readLoose(name string) ([]byte, error) {
+	if r.refs != nil {
+		return r.lookupInMemory(name)
+	}
+	dir := r.refDir(name)
+	refFile, err := r.repo.fs.ReadFile(filepath.Join(dir, name))
+	if err == nil && r.ignoreCase && !hasExactCase(r.repo.fs, dir, name, r.precomposeUnicode, &r.listings) {
+		err = os.ErrNotExist
+	}
+	return refFile, err
+}
+
+func (r *RefDb) parseLoose(name string, refFile []byte) (*Reference, error) {
+	refString := string(refFile)
+	if strings.HasPrefix(refString, GitSymbolReference) {
+		ref := &Reference{
+			refType:        ReferenceSymbolic,
+			targetSymbolic: strings.TrimSpace(refString[len(GitSymbolReference):]),
+			repo:           r.repo,
+			name:           name,
+		}
+		return ref, nil
+	}
+	oid, err := NewOid(strings.TrimSpace(refString))
+	if err != nil {
+		return nil, err
+	}
+	ref := &Reference{
+		refType:   ReferenceOid,
+		targetOid: oid,
+		repo:      r.repo,
+		name:      name,
+	}
+	return ref, nil
+}
+
+func (r *RefDb) packedReference(name string, item *PackRef) (*Reference, error) {
+	if item == nil {
+		return nil, MakeGitError(fmt.Sprintf("reference '%s' not found", name), ErrNotFound)
+	}
+	ref := &Reference{
+		refType:   ReferenceOid,
+		targetOid: item.oid,
+		repo:      r.repo,
+		name:      name,
+	}
+	return ref, nil
+}
+
+// refDir returns the directory that stores the loose reference. HEAD,
+// pseudo refs like ORIG_HEAD and refs/bisect, refs/worktree and
+// refs/rewritten belong to each linked worktree.
+func (r *RefDb) refDir(name string) string {
+	if r.worktreePath == "" {
+		return r.path
+	}
+	if !strings.HasPrefix(name, GitRefsDir) {
+		return r.worktreePath
+	}
+	for _, prefix := range []string{"refs/bisect/", "refs/worktree/", "refs/rewritten/"} {
+		if strings.HasPrefix(name, prefix) {
+			return r.worktreePath
+		}
+	}
+	return r.path
+}
+
+func (r *RefDb) lookupInMemory(name string) ([]byte, error) {
+	r.refsLock.RLock()
+	defer r.refsLock.RUnlock()
+
+	content, ok := r.refs[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return content, nil
+}
+
+// forEachLooseName calls the callback with the names of the references that
+// are not packed, in "refs/heads/master" form.
+func (r *RefDb) forEachLooseName(callback func(name string) error) error {
+	if r.refs != nil {
+		r.refsLock.RLock()
+		var names []string
+		for name := range r.refs {
+			if strings.HasPrefix(name, GitRefsDir) {
+				names = append(names, name)
 			}
-			return ref, nil
-		} else {
-			oid, err := NewOid(strings.TrimSpace(refString))
+		}
+		r.refsLock.RUnlock()
+		sort.Strings(names)
+		for _, name := range names {
+			err := callback(name)
 			if err != nil {
-				return nil, err
-			}
-			ref := &Reference{
-				refType:   ReferenceOid,
-				targetOid: oid,
-				repo:      r.repo,
-				name:      name,
+				return err
 			}
-			return ref, nil
 		}
-	} else {
-		item := r.cache.Lookup(name)
-		if item == nil {
-			return nil, errors.New("not found")
+		return nil
+	}
+	rootDir := filepath.Join(r.repo.pathCommon, GitRefsDir)
+	offset := len(r.repo.pathCommon)
+	return fs.WalkDir(r.repo.fs, rootDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
 		}
-		ref := &Reference{
-			refType:   ReferenceOid,
-			targetOid: item.oid,
-			repo:      r.repo,
-			name:      name,
+		return callback(precomposePath(filepath.ToSlash(path[offset:]), r.precomposeUnicode))
+	})
+}
+
+// write stores the content of the reference. On the disk the file is
+// replaced through a lockfile, so readers never see a partial reference.
+func (r *RefDb) write(name string, content []byte, force bool) error {
+	return r.writeChecked(name, content, func(current *Reference) error {
+		if current != nil && !force {
+			return MakeGitError(fmt.Sprintf("reference '%s' already exists", name), ErrExists)
 		}
-		return ref, nil
+		return nil
+	})
+}
+
+// update stores the content of the reference only if the reference still
+// points to expected, or does not exist if expected is nil. It fails with
+// ErrModified if the reference has moved.
+func (r *RefDb) update(name string, content []byte, expected *Oid) error {
+	return r.writeChecked(name, content, func(current *Reference) error {
+		var target *Oid
+		if current != nil {
+			target = current.Target()
+		}
+		if (target == nil) != (expected == nil) || (target != nil && !target.Equal(expected)) {
+			return MakeGitError(fmt.Sprintf("reference '%s' has moved", name), ErrModified)
+		}
+		return nil
+	})
+}
+
+// writeChecked stores the content of the reference if check accepts the
+// current reference (nil if it does not exist). The lock of the reference
+// is held from the check to the write, so no other writer can move the
+// reference in between.
+func (r *RefDb) writeChecked(name string, content []byte, check func(current *Reference) error) error {
+	if r.repo.readOnly {
+		return errReadOnly("RefDb.write")
+	}
+	if r.refs != nil {
+		r.refsLock.Lock()
+		defer r.refsLock.Unlock()
+
+		var current *Reference
+		if refFile, ok := r.refs[name]; ok {
+			current, _ = r.parseLoose(name, refFile)
+		}
+		if err := check(current); err != nil {
+			return err
+		}
+		r.refs[name] = content
+		r.repo.notify(&RepositoryEvent{Type: RepositoryEventReferenceChanged, ReferenceName: name})
+		return nil
+	}
+	path := filepath.Join(r.refDir(name), name)
+	err := r.repo.fs.MkdirAll(filepath.Dir(path), os.FileMode(GitObjectDirMode))
+	if err != nil {
+		return err
+	}
+	lock, err := newLockfile(r.repo.fs, path, 0666, DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	current, err := r.Lookup(name)
+	if err != nil && !IsErrorCode(err, ErrNotFound) && !os.IsNotExist(err) {
+		lock.Rollback()
+		return err
+	}
+	if err = check(current); err != nil {
+		lock.Rollback()
+		return err
+	}
+	_, err = lock.Write(content)
+	if err != nil {
+		lock.Rollback()
+		return err
+	}
+	err = lock.Commit()
+	if err == nil {
+		r.repo.notify(&RepositoryEvent{Type: RepositoryEventReferenceChanged, ReferenceName: name})
 	}
+	return err
 }
 
 func (r *RefDb) GetPackedReferences() ([]*Reference, error) {
@@ -0,0 +1,94 @@
+package git4go
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// AbbrevLength returns the length of abbreviated object ids. core.abbrev
+// sets it from 4 to 40, and "no" turns the abbreviation off. If it is not
+// set or it is "auto", the length grows with the number of objects like git
+// does: one more hex digit for each 4 times as many packed objects, e.g. 12
+// digits for 2^23 objects, but at least GitOidDefaultAbbrevLength.
+func (r *Repository) AbbrevLength() (int, error) {
+	if config := r.Config(); config != nil {
+		value, err := config.LookupString("core.abbrev")
+		if err == nil && !strings.EqualFold(value, "auto") {
+			switch strings.ToLower(value) {
+			case "no", "false", "off":
+				return GitOidHexSize, nil
+			}
+			length, err := strconv.Atoi(value)
+			if err != nil || length < GitOidMinimumPrefixLength || GitOidHexSize < length {
+				return 0, MakeGitError(fmt.Sprintf("invalid value for core.abbrev: '%s'", value), ErrInvalid)
+			}
+			return length, nil
+		}
+	}
+	odb, err := r.Odb()
+	if err != nil {
+		return 0, err
+	}
+	return autoAbbrevLength(odb.approximateObjectCount()), nil
+}
+
+// ShortId abbreviates the id to AbbrevLength, or longer if it is needed to
+// make the id unique in the object database.
+func (r *Repository) ShortId(oid *Oid) (string, error) {
+	length, err := r.AbbrevLength()
+	if err != nil {
+		return "", err
+	}
+	odb, err := r.Odb()
+	if err != nil {
+		return "", err
+	}
+	return odb.ShortId(oid, length)
+}
+
+// internal functions and methods
+
+func autoAbbrevLength(count uint64) int {
+	// a collision of 2^n objects is expected at n/2 bits; like git, n/2
+	// hex digits are used to leave a margin
+	length := (bits.Len64(count) + 1) / 2
+	if length < GitOidDefaultAbbrevLength {
+		length = GitOidDefaultAbbrevLength
+	}
+	return length
+}
+
+// approximateObjectCount returns the number of the packed objects, like
+// approximate_object_count of git. Loose objects are not counted, because
+// listing them costs much more and they are few after gc.
+func (o *Odb) approximateObjectCount() uint64 {
+	var count uint64
+	for _, backend := range o.backendList() {
+		packed, ok := backend.(*OdbBackendPacked)
+		if !ok {
+			continue
+		}
+		packed.lock.Lock()
+		packs := packed.packs
+		packed.lock.Unlock()
+		for _, pack := range packs {
+			if pack.openIndex() == nil {
+				count += uint64(pack.numObjects)
+			}
+		}
+	}
+	return count
+}
+
+// shortIdForMessage abbreviates the id for messages like "could not apply
+// 1a2b3c4... summary". The plain prefix is used if the object database
+// cannot be read.
+func (r *Repository) shortIdForMessage(oid *Oid) string {
+	shortId, err := r.ShortId(oid)
+	if err != nil {
+		return oid.String()[:GitOidDefaultAbbrevLength]
+	}
+	return shortId
+}
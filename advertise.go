@@ -0,0 +1,222 @@
+package git4go
+
+import (
+	"sort"
+	"strings"
+)
+
+// AdvertisementService is the service whose references are advertised. It
+// selects the hideRefs config of the service.
+type AdvertisementService string
+
+const (
+	// git-upload-pack (fetch and clone), hidden by uploadpack.hideRefs
+	ServiceUploadPack AdvertisementService = "upload-pack"
+	// git-receive-pack (push), hidden by receive.hideRefs
+	ServiceReceivePack AdvertisementService = "receive-pack"
+)
+
+// RefAdvertisementOptions controls which references a server advertises.
+type RefAdvertisementOptions struct {
+	Service AdvertisementService
+	// Namespace is GIT_NAMESPACE: only the references under
+	// refs/namespaces/<namespace>/ are advertised, without that prefix.
+	// Nested namespaces are separated by slashes.
+	Namespace string
+	// HideRefs are more hideRefs patterns after those of transfer.hideRefs
+	// and of the service, e.g. per user rules of a hosting platform
+	HideRefs []string
+	// Filter is called for each reference that is not hidden; returning
+	// false hides it too. The name of the head is the advertised one.
+	Filter func(head *RemoteHead) bool
+	// VirtualRefs are advertised like references of the repository, e.g.
+	// the refs/pull/<n>/head of a hosting platform that are stored
+	// elsewhere. They replace the references with the same names and are
+	// neither hidden nor filtered.
+	VirtualRefs []RemoteHead
+}
+
+// RefAdvertisement is the list of references that a server sends first.
+type RefAdvertisement struct {
+	// Heads are in the advertised order: HEAD first for upload-pack, then
+	// the references by name. For upload-pack each annotated tag is
+	// followed by the object that it peels to, named "<tag>^{}".
+	Heads []RemoteHead
+	// HeadTarget is the branch of the symref=HEAD:<branch> capability, or
+	// "" if HEAD is detached or not advertised
+	HeadTarget string
+
+	namespace string
+	hideRefs  []string
+}
+
+// AdvertiseRefs returns the references that upload-pack or receive-pack
+// advertises. References are hidden by the patterns of transfer.hideRefs,
+// of uploadpack.hideRefs or receive.hideRefs, and of opts.HideRefs, like
+// git: a pattern hides the reference with that name and the references
+// below it, a pattern with a leading "!" shows them again, the last
+// matching pattern wins, and a pattern with a leading "^" is compared with
+// the name that includes the namespace.
+func (r *Repository) AdvertiseRefs(opts *RefAdvertisementOptions) (*RefAdvertisement, error) {
+	if opts == nil {
+		opts = &RefAdvertisementOptions{}
+	}
+	adv := &RefAdvertisement{
+		namespace: namespacePrefix(opts.Namespace),
+		hideRefs:  r.hideRefsPatterns(opts.Service),
+	}
+	for _, pattern := range opts.HideRefs {
+		adv.hideRefs = append(adv.hideRefs, strings.TrimRight(pattern, "/"))
+	}
+
+	virtual := make(map[string]bool)
+	for _, head := range opts.VirtualRefs {
+		virtual[head.Name] = true
+	}
+	var heads []RemoteHead
+	err := r.ForEachReference(func(ref *Reference) error {
+		if !strings.HasPrefix(ref.Name(), adv.namespace) {
+			return nil
+		}
+		name := ref.Name()[len(adv.namespace):]
+		if virtual[name] || !strings.HasPrefix(name, GitRefsDir) {
+			return nil
+		}
+		resolved, err := ref.Resolve()
+		if err != nil {
+			// dangling symbolic references are not advertised
+			return nil
+		}
+		head := RemoteHead{Id: resolved.Target(), Name: name}
+		if adv.IsHidden(name) || (opts.Filter != nil && !opts.Filter(&head)) {
+			return nil
+		}
+		heads = append(heads, head)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	heads = append(heads, opts.VirtualRefs...)
+	sort.Slice(heads, func(i, j int) bool {
+		return heads[i].Name < heads[j].Name
+	})
+
+	if opts.Service != ServiceReceivePack {
+		if head, ok := r.advertisedHead(adv, opts.Filter); ok {
+			adv.Heads = append(adv.Heads, head)
+		}
+	}
+	for _, head := range heads {
+		adv.Heads = append(adv.Heads, head)
+		if opts.Service == ServiceReceivePack {
+			continue
+		}
+		if peeled := r.peeledTag(head.Id); peeled != nil {
+			adv.Heads = append(adv.Heads, RemoteHead{Id: peeled, Name: head.Name + "^{}"})
+		}
+	}
+	return adv, nil
+}
+
+// IsHidden tells if the reference is hidden by the hideRefs patterns, e.g.
+// to reject pushes to hidden references like receive-pack. name is the
+// advertised name, without the namespace.
+func (a *RefAdvertisement) IsHidden(name string) bool {
+	fullName := a.namespace + name
+	for i := len(a.hideRefs) - 1; i >= 0; i-- {
+		pattern := a.hideRefs[i]
+		negated := strings.HasPrefix(pattern, "!")
+		if negated {
+			pattern = pattern[1:]
+		}
+		subject := name
+		if strings.HasPrefix(pattern, "^") {
+			pattern = pattern[1:]
+			subject = fullName
+		}
+		if subject == pattern || strings.HasPrefix(subject, pattern+"/") {
+			return !negated
+		}
+	}
+	return false
+}
+
+// internal functions and methods
+
+// namespacePrefix returns the prefix of the references of the namespace,
+// e.g. "refs/namespaces/a/refs/namespaces/b/" for "a/b".
+func namespacePrefix(namespace string) string {
+	prefix := ""
+	for _, component := range strings.Split(namespace, "/") {
+		if component != "" {
+			prefix += "refs/namespaces/" + component + "/"
+		}
+	}
+	return prefix
+}
+
+// hideRefsPatterns returns the hideRefs patterns of the config in the order
+// that git reads them: the lower levels first.
+func (r *Repository) hideRefsPatterns(service AdvertisementService) []string {
+	config := r.Config()
+	if config == nil {
+		return nil
+	}
+	keys := []string{"transfer.hiderefs"}
+	switch service {
+	case ServiceUploadPack:
+		keys = append(keys, "uploadpack.hiderefs")
+	case ServiceReceivePack:
+		keys = append(keys, "receive.hiderefs")
+	}
+	entries := config.Entries()
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Level < entries[j].Level
+	})
+	var patterns []string
+	for _, entry := range entries {
+		for _, key := range keys {
+			if strings.EqualFold(entry.Name, key) && entry.Value != "" {
+				patterns = append(patterns, strings.TrimRight(entry.Value, "/"))
+			}
+		}
+	}
+	return patterns
+}
+
+// advertisedHead returns HEAD of the namespace if it resolves and is not
+// hidden, and sets the target of the symref capability.
+func (r *Repository) advertisedHead(adv *RefAdvertisement, filter func(head *RemoteHead) bool) (RemoteHead, bool) {
+	ref, err := r.LookupReference(adv.namespace + GitHeadFile)
+	if err != nil {
+		return RemoteHead{}, false
+	}
+	resolved, err := ref.Resolve()
+	if err != nil {
+		return RemoteHead{}, false
+	}
+	head := RemoteHead{Id: resolved.Target(), Name: GitHeadFile}
+	if adv.IsHidden(GitHeadFile) || (filter != nil && !filter(&head)) {
+		return RemoteHead{}, false
+	}
+	if ref.Type() == ReferenceSymbolic && strings.HasPrefix(resolved.Name(), adv.namespace) {
+		adv.HeadTarget = resolved.Name()[len(adv.namespace):]
+		head.SymrefTarget = adv.HeadTarget
+	}
+	return head, true
+}
+
+// peeledTag returns the object that the annotated tag peels to, or nil if
+// the id is not a tag.
+func (r *Repository) peeledTag(oid *Oid) *Oid {
+	object, err := r.Lookup(oid)
+	if err != nil || object.Type() != ObjectTag {
+		return nil
+	}
+	peeled, err := object.Peel(ObjectAny)
+	if err != nil {
+		return nil
+	}
+	return peeled.Id()
+}
@@ -0,0 +1,104 @@
+package git4go
+
+import (
+	"./testutil"
+	"testing"
+)
+
+func headNames(heads []RemoteHead) []string {
+	var names []string
+	for _, head := range heads {
+		names = append(names, head.Name)
+	}
+	return names
+}
+
+func Test_Repository_AdvertiseRefs(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	adv, err := repo.AdvertiseRefs(&RefAdvertisementOptions{Service: ServiceUploadPack})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	names := headNames(adv.Heads)
+	if len(names) != 28 || names[0] != "HEAD" || adv.HeadTarget != "refs/heads/master" || adv.Heads[0].SymrefTarget != "refs/heads/master" {
+		t.Error("it should advertise HEAD first with its symref:", names, adv.HeadTarget)
+	}
+	tagIndex := -1
+	for i, name := range names {
+		if name == "refs/tags/hard_tag" {
+			tagIndex = i
+		}
+	}
+	if tagIndex == -1 || names[tagIndex+1] != "refs/tags/hard_tag^{}" ||
+		adv.Heads[tagIndex+1].Id.String() != "a65fedf39aefe402d3bb6e24df4d4f5fe4547750" {
+		t.Error("it should follow the tag with its peeled object:", names)
+	}
+
+	repo.Config().SetString("transfer.hideRefs", "refs/tags")
+	repo.Config().SetString("uploadpack.hideRefs", "refs/heads/packed")
+	virtualId, _ := NewOid(commitHead)
+	adv, _ = repo.AdvertiseRefs(&RefAdvertisementOptions{
+		Service:  ServiceUploadPack,
+		HideRefs: []string{"!refs/tags/test", "refs/notes/"},
+		Filter: func(head *RemoteHead) bool {
+			return head.Name != "refs/heads/br2"
+		},
+		VirtualRefs: []RemoteHead{{Id: virtualId, Name: "refs/pull/1/head"}},
+	})
+	names = headNames(adv.Heads)
+	expected := []string{"HEAD", "refs/heads/cannot-fetch", "refs/heads/chomped", "refs/heads/haacked",
+		"refs/heads/master", "refs/heads/not-good", "refs/heads/packed-test", "refs/heads/subtrees",
+		"refs/heads/test", "refs/heads/track-local", "refs/heads/trailing", "refs/pull/1/head",
+		"refs/remotes/test/master", "refs/tags/test", "refs/tags/test^{}"}
+	if len(names) != len(expected) {
+		t.Fatal("it should hide and inject the references:", names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Error("it should hide and inject the references:", names)
+			break
+		}
+	}
+	if !adv.IsHidden("refs/tags/v1") || adv.IsHidden("refs/tags/test") || adv.IsHidden("refs/heads/packed-test") {
+		t.Error("it should match the patterns like git")
+	}
+
+	adv, _ = repo.AdvertiseRefs(&RefAdvertisementOptions{Service: ServiceReceivePack})
+	names = headNames(adv.Heads)
+	if names[0] == "HEAD" || adv.HeadTarget != "" || !adv.IsHidden("refs/tags/v1") || adv.IsHidden("refs/heads/packed") {
+		t.Error("it should use the config of receive-pack without HEAD:", names)
+	}
+	for _, name := range names {
+		if name[len(name)-1] == '}' {
+			t.Error("it should not advertise peeled tags for receive-pack:", name)
+		}
+	}
+}
+
+func Test_Repository_AdvertiseRefs_Namespace(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	oid, _ := NewOid(commitHead)
+	repo.CreateReference("refs/namespaces/foo/refs/heads/main", oid, true)
+	repo.CreateReference("refs/namespaces/foo/refs/heads/secret", oid, true)
+	repo.CreateSymbolicReference("refs/namespaces/foo/HEAD", "refs/namespaces/foo/refs/heads/main", true)
+	repo.CreateReference("refs/namespaces/bar/refs/heads/other", oid, true)
+
+	adv, err := repo.AdvertiseRefs(&RefAdvertisementOptions{
+		Service:   ServiceUploadPack,
+		Namespace: "foo",
+		HideRefs:  []string{"^refs/namespaces/foo/refs/heads/secret"},
+	})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	names := headNames(adv.Heads)
+	if len(names) != 2 || names[0] != "HEAD" || names[1] != "refs/heads/main" || adv.HeadTarget != "refs/heads/main" {
+		t.Error("it should advertise the references of the namespace without the prefix:", names, adv.HeadTarget)
+	}
+}
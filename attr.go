@@ -0,0 +1,250 @@
+package git4go
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	GitAttributesFile     = ".gitattributes"
+	GitInfoAttributesFile = "info/attributes"
+)
+
+type AttrValueType int
+
+const (
+	// No pattern sets the attribute, or it is reset with "!attr"
+	AttrValueUnspecified AttrValueType = iota
+	// "attr"
+	AttrValueTrue
+	// "-attr"
+	AttrValueFalse
+	// "attr=value"
+	AttrValueString
+)
+
+func (t AttrValueType) String() string {
+	switch t {
+	case AttrValueUnspecified:
+		return "unspecified"
+	case AttrValueTrue:
+		return "set"
+	case AttrValueFalse:
+		return "unset"
+	case AttrValueString:
+		return "string"
+	}
+	return ""
+}
+
+// AttrMatch is the value of an attribute for a path and the line that
+// decided it, like "git check-attr" with the source of the value. Source
+// is empty if no line matches.
+type AttrMatch struct {
+	Name  string
+	Type  AttrValueType
+	Value string
+	// The file of the line: a path relative to the working directory for
+	// .gitattributes files, or the full path for the other files
+	Source  string
+	Line    int
+	Pattern string
+}
+
+// GetAttr returns the value of an attribute for the path.
+func (r *Repository) GetAttr(path, name string) (AttrValueType, string, error) {
+	matches, err := r.CheckAttrVerbose(path, name)
+	if err != nil {
+		return AttrValueUnspecified, "", err
+	}
+	return matches[0].Type, matches[0].Value, nil
+}
+
+// CheckAttrVerbose returns the attributes of the path with the lines that
+// set them. Without names, all attributes that are specified for the path
+// are returned in the order of names. Lines of $GIT_DIR/info/attributes
+// win over deeper .gitattributes files, which win over upper ones and
+// core.attributesFile. Macros like "binary" are expanded where they are
+// set.
+func (r *Repository) CheckAttrVerbose(path string, names ...string) ([]*AttrMatch, error) {
+	if r.IsBare() {
+		return nil, MakeGitError("cannot check attributes of a bare repository", ErrBareRepository)
+	}
+	path = strings.Trim(filepath.ToSlash(path), "/")
+	files, err := r.attrFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	ignoreCase := r.ignoreCase()
+	isDir := r.isDirInWorkdir(path)
+
+	macros := map[string][]attrAssignment{
+		"binary": {{"diff", AttrValueFalse, ""}, {"merge", AttrValueFalse, ""}, {"text", AttrValueFalse, ""}},
+	}
+	var rules []*attrRule
+	for _, file := range files {
+		for i, line := range file.lines {
+			rule := parseAttrRule(line)
+			if rule == nil {
+				continue
+			}
+			if rule.macro != "" {
+				// like git, macros are only defined in the top level files
+				if file.base == "" {
+					macros[rule.macro] = rule.assignments
+				}
+				continue
+			}
+			relPath, ok := file.relativePath(path)
+			if !ok || !rule.pattern.matches(relPath, isDir, ignoreCase) {
+				continue
+			}
+			rule.source = file.source
+			rule.line = i + 1
+			rules = append(rules, rule)
+		}
+	}
+
+	// the highest priority comes first: later lines and later attributes
+	// of a line, and the first value of an attribute wins
+	filled := make(map[string]*AttrMatch)
+	var fill func(assignment attrAssignment, rule *attrRule)
+	fill = func(assignment attrAssignment, rule *attrRule) {
+		if _, ok := filled[assignment.name]; ok {
+			return
+		}
+		filled[assignment.name] = &AttrMatch{
+			Name:    assignment.name,
+			Type:    assignment.valueType,
+			Value:   assignment.value,
+			Source:  rule.source,
+			Line:    rule.line,
+			Pattern: rule.patternText,
+		}
+		if macro, ok := macros[assignment.name]; ok && assignment.valueType == AttrValueTrue {
+			for i := len(macro) - 1; i >= 0; i-- {
+				fill(macro[i], rule)
+			}
+		}
+	}
+	for i := len(rules) - 1; i >= 0; i-- {
+		rule := rules[i]
+		for j := len(rule.assignments) - 1; j >= 0; j-- {
+			fill(rule.assignments[j], rule)
+		}
+	}
+
+	var result []*AttrMatch
+	if len(names) == 0 {
+		for _, match := range filled {
+			if match.Type != AttrValueUnspecified {
+				result = append(result, match)
+			}
+		}
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].Name < result[j].Name
+		})
+		return result, nil
+	}
+	for _, name := range names {
+		match, ok := filled[name]
+		if !ok {
+			match = &AttrMatch{Name: name}
+		}
+		result = append(result, match)
+	}
+	return result, nil
+}
+
+// internal functions and methods
+
+type attrAssignment struct {
+	name      string
+	valueType AttrValueType
+	value     string
+}
+
+// attrRule is a line of .gitattributes: a pattern and its attributes, or
+// a macro definition ("[attr]name attributes").
+type attrRule struct {
+	pattern     *attrPattern
+	patternText string
+	macro       string
+	assignments []attrAssignment
+	source      string
+	line        int
+}
+
+func parseAttrRule(line string) *attrRule {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return nil
+	}
+	rule := &attrRule{patternText: fields[0]}
+	if strings.HasPrefix(fields[0], "[attr]") {
+		rule.macro = fields[0][len("[attr]"):]
+		if rule.macro == "" {
+			return nil
+		}
+	} else {
+		// negative patterns are not allowed in .gitattributes
+		if strings.HasPrefix(fields[0], "!") {
+			return nil
+		}
+		rule.pattern = parseAttrPattern(fields[0], false)
+		if rule.pattern == nil {
+			return nil
+		}
+	}
+	for _, field := range fields[1:] {
+		assignment := attrAssignment{valueType: AttrValueTrue}
+		switch field[0] {
+		case '-':
+			assignment.valueType = AttrValueFalse
+			field = field[1:]
+		case '!':
+			assignment.valueType = AttrValueUnspecified
+			field = field[1:]
+		default:
+			if equal := strings.IndexByte(field, '='); equal != -1 {
+				assignment.valueType = AttrValueString
+				assignment.value = field[equal+1:]
+				field = field[:equal]
+			}
+		}
+		if field == "" {
+			continue
+		}
+		assignment.name = field
+		rule.assignments = append(rule.assignments, assignment)
+	}
+	return rule
+}
+
+// attrFiles returns the files of attributes in the order of priority from
+// low to high.
+func (r *Repository) attrFiles(path string) ([]*attrFile, error) {
+	var files []*attrFile
+	global, err := r.globalAttrFile("core.attributesFile", "attributes")
+	if err != nil {
+		return nil, err
+	}
+	if global != nil {
+		files = append(files, global)
+	}
+	workdirFiles, err := r.workdirAttrFiles(path, GitAttributesFile)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, workdirFiles...)
+	infoPath := filepath.Join(r.pathCommon, filepath.FromSlash(GitInfoAttributesFile))
+	info, err := readAttrFile(r.fs, infoPath, infoPath, "")
+	if err != nil {
+		return nil, err
+	}
+	if info != nil {
+		files = append(files, info)
+	}
+	return files, nil
+}
@@ -0,0 +1,151 @@
+package git4go
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// attrPattern is the path pattern of a line of .gitignore or
+// .gitattributes. It follows the rules of gitignore: a pattern without a
+// slash matches the name in any directory, other patterns match the path
+// relative to the directory of the file, and "**" matches any number of
+// directories. The patterns are matched with fnMatch like git's wildmatch.
+type attrPattern struct {
+	text     string
+	negative bool
+	dirOnly  bool
+	anchored bool
+	pattern  string
+}
+
+func parseAttrPattern(text string, allowNegative bool) *attrPattern {
+	p := &attrPattern{text: text}
+	if allowNegative && strings.HasPrefix(text, "!") {
+		p.negative = true
+		text = text[1:]
+	}
+	if strings.HasSuffix(text, "/") {
+		p.dirOnly = true
+		text = strings.TrimRight(text, "/")
+	}
+	if text == "" {
+		return nil
+	}
+	if strings.Contains(text, "/") {
+		p.anchored = true
+		text = strings.TrimLeft(text, "/")
+	}
+	p.pattern = text
+	return p
+}
+
+// matches checks the path that is relative to the directory of the file
+// that the pattern is in. With ignoreCase, which is core.ignorecase, the
+// case of the letters is not compared.
+func (p *attrPattern) matches(relPath string, isDir, ignoreCase bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	flags := FNMPathName
+	if ignoreCase {
+		flags |= FNMCaseFold
+	}
+	if !p.anchored {
+		return fnMatch(p.pattern, path.Base(relPath), flags)
+	}
+	return fnMatch(p.pattern, relPath, flags)
+}
+
+// attrFile is a .gitignore or .gitattributes file. base is the directory
+// of the file relative to the working directory with a trailing slash, or
+// empty for the root and for the files outside of the working directory.
+type attrFile struct {
+	source string
+	base   string
+	lines  []string
+}
+
+// readAttrFile reads the file, a missing file is returned as nil.
+func readAttrFile(fsys FileSystem, filePath, source, base string) (*attrFile, error) {
+	data, err := fsys.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &attrFile{
+		source: source,
+		base:   base,
+		lines:  strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n"),
+	}, nil
+}
+
+// relativePath returns the path relative to the directory of the file,
+// or false if the path is not under it.
+func (f *attrFile) relativePath(path string) (string, bool) {
+	if !strings.HasPrefix(path, f.base) {
+		return "", false
+	}
+	return path[len(f.base):], true
+}
+
+// workdirAttrFiles reads the files with the name from the root of the
+// working directory down to the directory of the path.
+func (r *Repository) workdirAttrFiles(path, name string) ([]*attrFile, error) {
+	var files []*attrFile
+	base := ""
+	fragments := strings.Split(path, "/")
+	for _, fragment := range fragments {
+		file, err := readAttrFile(r.fs, filepath.Join(r.Workdir(), filepath.FromSlash(base), name), base+name, base)
+		if err != nil {
+			return nil, err
+		}
+		if file != nil {
+			files = append(files, file)
+		}
+		base += fragment + "/"
+	}
+	return files, nil
+}
+
+// globalAttrFile reads the file that the config variable names, or the
+// file of the name in the XDG config directory of git.
+func (r *Repository) globalAttrFile(configName, xdgName string) (*attrFile, error) {
+	var filePath string
+	if config := r.Config(); config != nil {
+		if value, err := config.LookupString(configName); err == nil {
+			filePath = value
+		}
+	}
+	if strings.HasPrefix(filePath, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		filePath = filepath.Join(home, filePath[2:])
+	}
+	if filePath == "" {
+		var err error
+		filePath, err = findInDirList(xdgName, "global/xdg")
+		if err != nil {
+			return nil, nil
+		}
+	}
+	return readAttrFile(OSFileSystem, filePath, filePath, "")
+}
+
+func (r *Repository) isDirInWorkdir(path string) bool {
+	stat, err := r.fs.Stat(filepath.Join(r.Workdir(), filepath.FromSlash(path)))
+	return err == nil && stat.IsDir()
+}
+
+func (r *Repository) ignoreCase() bool {
+	config := r.Config()
+	if config == nil {
+		return false
+	}
+	ignoreCase, _ := config.LookupBooleanWithDefaultValue("core.ignorecase")
+	return ignoreCase
+}
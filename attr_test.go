@@ -0,0 +1,109 @@
+package git4go
+
+import (
+	"./testutil"
+	"os"
+	"testing"
+)
+
+func prepareAttrWorkspace() {
+	testutil.PrepareWorkspace("test_resources/attr")
+	os.Rename("test_resources/attr/gitattributes", "test_resources/attr/.gitattributes")
+	os.Rename("test_resources/attr/gitignore", "test_resources/attr/.gitignore")
+}
+
+func Test_GetAttr(t *testing.T) {
+	prepareAttrWorkspace()
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/attr")
+	expected := []struct {
+		path      string
+		name      string
+		valueType AttrValueType
+		value     string
+	}{
+		{"root_test1", "rootattr", AttrValueTrue, ""},
+		{"root_test2", "rootattr", AttrValueFalse, ""},
+		{"root_test2", "multiattr", AttrValueFalse, ""},
+		{"root_test3", "multiattr", AttrValueString, "3"},
+		{"root_test3", "rootattr", AttrValueUnspecified, ""},
+		{"sub/subdir_test2.txt", "another", AttrValueString, "zero"},
+		{"sub/sub/subsub.txt", "another", AttrValueString, "one"},
+		{"sub/abc", "merge", AttrValueString, "filfre"},
+		{"does-not-exist", "foo", AttrValueString, "yes"},
+		{"attr0", "foo", AttrValueTrue, ""},
+		{"attr0", "baz", AttrValueFalse, ""},
+		{"attr0", "bar", AttrValueUnspecified, ""},
+	}
+	for _, e := range expected {
+		valueType, value, err := repo.GetAttr(e.path, e.name)
+		if err != nil {
+			t.Error("err should be nil:", e.path, err)
+			continue
+		}
+		if valueType != e.valueType || value != e.value {
+			t.Errorf("wrong attribute %s of %s. expected: %s %s actual: %s %s", e.name, e.path, e.valueType, e.value, valueType, value)
+		}
+	}
+}
+
+func Test_CheckAttrVerboseMacro(t *testing.T) {
+	prepareAttrWorkspace()
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/attr")
+	matches, err := repo.CheckAttrVerbose("binfile", "binary", "diff", "merge", "text")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if matches[0].Type != AttrValueTrue {
+		t.Error("it should set binary")
+	}
+	for _, match := range matches[1:] {
+		if match.Type != AttrValueFalse {
+			t.Error("binary should unset", match.Name)
+		}
+		if match.Source != ".gitattributes" || match.Pattern != "binfile" {
+			t.Error("it should report the line of binary:", match.Source, match.Pattern)
+		}
+	}
+
+	matches, _ = repo.CheckAttrVerbose("macro_test", "mymacro", "positive", "negative", "another")
+	expected := []AttrValueType{AttrValueTrue, AttrValueTrue, AttrValueFalse, AttrValueString}
+	for i, match := range matches {
+		if match.Type != expected[i] {
+			t.Errorf("wrong type of %s. expected: %s actual: %s", match.Name, expected[i], match.Type)
+		}
+	}
+	if matches[3].Value != "77" {
+		t.Error("it should expand another=77:", matches[3].Value)
+	}
+}
+
+func Test_CheckAttrVerboseAll(t *testing.T) {
+	prepareAttrWorkspace()
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/attr")
+	matches, err := repo.CheckAttrVerbose("sub/abc")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	names := []string{"baz", "foo", "merge", "negattr", "repoattr", "rootattr", "subattr"}
+	if len(matches) != len(names) {
+		t.Fatal("wrong count of attributes:", len(matches))
+	}
+	for i, match := range matches {
+		if match.Name != names[i] {
+			t.Errorf("attributes should be sorted. expected: %s actual: %s", names[i], match.Name)
+		}
+	}
+	// info/attributes wins over sub/.gitattributes
+	if matches[1].Type != AttrValueTrue || matches[1].Line != 2 || matches[1].Pattern != "a*" {
+		t.Error("foo should come from info/attributes:", matches[1].Source, matches[1].Line, matches[1].Pattern)
+	}
+	if matches[6].Source != "sub/.gitattributes" {
+		t.Error("subattr should come from sub/.gitattributes:", matches[6].Source)
+	}
+}
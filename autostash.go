@@ -0,0 +1,269 @@
+package git4go
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	GitStashRef = "refs/stash"
+
+	gitRebaseAutostashFile = "autostash"
+)
+
+type AutoStashMode int
+
+const (
+	// The configuration decides, like rebase.autoStash for a rebase
+	AutoStashDefault AutoStashMode = iota
+	// Local changes are stashed before the operation and applied after it
+	AutoStashEnabled
+	// Local changes are left as they are
+	AutoStashDisabled
+)
+
+// autoStashEnabled tells whether the mode, or the configuration if it is
+// the default, enables the autostash.
+func (r *Repository) autoStashEnabled(mode AutoStashMode, configName string) bool {
+	switch mode {
+	case AutoStashEnabled:
+		return true
+	case AutoStashDisabled:
+		return false
+	}
+	if configName == "" {
+		return false
+	}
+	config := r.Config()
+	if config == nil {
+		return false
+	}
+	enabled, _ := config.LookupBool(configName)
+	return enabled
+}
+
+// autostashCreate records the local changes of the tracked files in a
+// stash commit like "git stash create autostash", and resets the index and
+// the working directory to HEAD. It returns nil if there are no changes.
+func (r *Repository) autostashCreate() (*Oid, error) {
+	head, err := r.headCommit()
+	if err != nil {
+		return nil, err
+	}
+	index, err := r.Index()
+	if err != nil {
+		return nil, err
+	}
+	if index.HasConflicts() {
+		return nil, MakeGitError("cannot autostash the conflicts of the index", ErrUnmerged)
+	}
+	indexTreeId, err := index.WriteTreeTo(r)
+	if err != nil {
+		return nil, err
+	}
+	workdir, err := NewIndex()
+	if err != nil {
+		return nil, err
+	}
+	perfdata := &CheckoutPerfdata{}
+	for _, entry := range index.Entries {
+		if entry.Mode == FilemodeCommit {
+			workdir.Add(&IndexEntry{Path: entry.Path, Mode: entry.Mode, Id: entry.Id})
+			continue
+		}
+		exists, matches, err := r.workdirMatches(entry.Path, entry, true, perfdata)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		id := entry.Id
+		if !matches {
+			content, err := r.workdirContent(entry.Path)
+			if err != nil {
+				return nil, err
+			}
+			id, err = r.CreateBlobFromBuffer(content)
+			if err != nil {
+				return nil, err
+			}
+		}
+		workdir.Add(&IndexEntry{Path: entry.Path, Mode: entry.Mode, Id: id})
+	}
+	workdirTreeId, err := workdir.WriteTreeTo(r)
+	if err != nil {
+		return nil, err
+	}
+	headTree, err := head.Tree()
+	if err != nil {
+		return nil, err
+	}
+	if headTree.Id().Equal(indexTreeId) && headTree.Id().Equal(workdirTreeId) {
+		return nil, nil
+	}
+
+	signature, err := r.DefaultSignature()
+	if err != nil {
+		return nil, err
+	}
+	branch := "(no branch)"
+	if ref, err := r.LookupReference(GitHeadFile); err == nil && ref.Type() == ReferenceSymbolic {
+		branch = strings.TrimPrefix(ref.SymbolicTarget(), "refs/heads/")
+	}
+	indexTree, err := r.LookupTree(indexTreeId)
+	if err != nil {
+		return nil, err
+	}
+	message := fmt.Sprintf("index on %s: %s %s\n", branch, r.shortIdForMessage(head.Id()), head.Summary())
+	indexCommitId, err := r.CreateCommit("", signature, signature, message, indexTree, head)
+	if err != nil {
+		return nil, err
+	}
+	indexCommit, err := r.LookupCommit(indexCommitId)
+	if err != nil {
+		return nil, err
+	}
+	workdirTree, err := r.LookupTree(workdirTreeId)
+	if err != nil {
+		return nil, err
+	}
+	stash, err := r.CreateCommit("", signature, signature, fmt.Sprintf("On %s: autostash\n", branch), workdirTree, head, indexCommit)
+	if err != nil {
+		return nil, err
+	}
+	return stash, r.checkoutReset(headTree)
+}
+
+// autostashApply applies the changes of the stash commit to the working
+// directory that has the tree checked out, leaving them unstaged except
+// for the new files. If they conflict, nothing is changed; the stash is
+// kept in refs/stash and ErrMergeConflict is returned, like git does.
+func (r *Repository) autostashApply(stash *Oid, onto *Tree) error {
+	stashCommit, err := r.LookupCommit(stash)
+	if err != nil {
+		return err
+	}
+	base, err := r.commitTree(stashCommit.ParentId(0))
+	if err != nil {
+		return err
+	}
+	stashTree, err := stashCommit.Tree()
+	if err != nil {
+		return err
+	}
+	merged, err := r.MergeTrees(base, onto, stashTree, nil)
+	if err != nil {
+		return err
+	}
+	if merged.HasConflicts() {
+		err = r.stashStore(stash, "autostash")
+		if err != nil {
+			return err
+		}
+		return MakeGitError("applying the autostash resulted in conflicts; the changes are kept in "+GitStashRef, ErrMergeConflict)
+	}
+	treeId, err := merged.WriteTreeTo(r)
+	if err != nil {
+		return err
+	}
+	tree, err := r.LookupTree(treeId)
+	if err != nil {
+		return err
+	}
+	err = r.CheckoutTree(tree, nil)
+	if err != nil {
+		return err
+	}
+
+	index, err := r.Index()
+	if err != nil {
+		return err
+	}
+	entries, err := flattenTree(onto)
+	if err != nil {
+		return err
+	}
+	var added []*IndexEntry
+	for _, entry := range index.Entries {
+		if _, ok := entries[entry.Path]; !ok {
+			added = append(added, &IndexEntry{Path: entry.Path, Mode: entry.Mode, Id: entry.Id})
+		}
+	}
+	err = index.ReadTree(onto)
+	if err != nil {
+		return err
+	}
+	for _, entry := range added {
+		index.Add(entry)
+	}
+	if index.filePath == "" {
+		return nil
+	}
+	return index.Write()
+}
+
+// stashStore records the stash commit in refs/stash like "git stash store":
+// the entry is appended to logs/refs/stash, so the stash that was on top
+// stays in the log as the next entry instead of being lost.
+func (r *Repository) stashStore(stash *Oid, message string) error {
+	var old *Oid
+	ref, err := r.LookupReference(GitStashRef)
+	if err == nil {
+		old = ref.Target()
+	} else if !IsErrorCode(err, ErrNotFound) {
+		return err
+	}
+	signature, err := r.DefaultSignature()
+	if err != nil {
+		return err
+	}
+	refDb, name, err := r.refDbForWrite(GitStashRef)
+	if err != nil {
+		return err
+	}
+	if err = refDb.update(name, []byte(stash.String()+"\n"), old); err != nil {
+		return err
+	}
+	if r.pathCommon == "" {
+		// an in-memory repository has no logs
+		return nil
+	}
+	oldId := &Oid{}
+	if old != nil {
+		oldId = old
+	}
+	var line bytes.Buffer
+	fmt.Fprintf(&line, "%s %s ", oldId.String(), stash.String())
+	writeSignature(&line, "", signature)
+	line.Truncate(line.Len() - 1)
+	fmt.Fprintf(&line, "\t%s\n", message)
+	logPath := filepath.Join(r.pathCommon, "logs", filepath.FromSlash(GitStashRef))
+	if err = r.fs.MkdirAll(filepath.Dir(logPath), 0777); err != nil {
+		return err
+	}
+	file, err := r.fs.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(line.Bytes())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readAutostash reads the stash that the file of the state directory
+// keeps. It returns nil if there is none.
+func (r *Repository) readAutostash(path string) (*Oid, error) {
+	data, err := r.fs.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return NewOid(strings.TrimSpace(string(data)))
+}
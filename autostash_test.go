@@ -0,0 +1,118 @@
+package git4go
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func prepareAutostashRepository(t *testing.T) (*Repository, *Commit, []*Commit) {
+	repo, head, commits := prepareSequencerRepository(t)
+	repo.Config().SetString("user.name", "S")
+	repo.Config().SetString("user.email", "s@example.com")
+	return repo, head, commits
+}
+
+func Test_CheckoutTree_AutoStash(t *testing.T) {
+	repo, _, commits := prepareAutostashRepository(t)
+	defer os.RemoveAll(repo.Workdir())
+	ioutil.WriteFile(filepath.Join(repo.Workdir(), "a.txt"), []byte("one\n2\nthree\nfour\n"), 0644)
+	tree, _ := commits[1].Tree()
+
+	if err := repo.CheckoutTree(tree, nil); !IsErrorCode(err, ErrUncommitted) {
+		t.Fatal("it should not overwrite the local changes without autostash:", err)
+	}
+	err := repo.CheckoutTree(tree, &CheckoutOptions{AutoStash: AutoStashEnabled})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if readWorkdirFile(repo, "a.txt") != "one\ntwo\nthree\nfour\n" || readWorkdirFile(repo, "c.txt") != "c\n" {
+		t.Error("it should apply the local changes to the checked out files")
+	}
+	index, _ := repo.Index()
+	entry, _ := index.EntryByPath("a.txt", 0)
+	blob, _ := repo.LookupBlob(entry.Id)
+	if string(blob.Contents()) != "one\ntwo\nthree\n" {
+		t.Error("it should leave the applied changes unstaged")
+	}
+
+	// a stash of the user that the autostash must not replace
+	if err = repo.stashStore(commits[0].Id(), "On master: earlier"); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	ioutil.WriteFile(filepath.Join(repo.Workdir(), "b.txt"), []byte("local\n"), 0644)
+	tree, _ = commits[3].Tree()
+	err = repo.CheckoutTree(tree, &CheckoutOptions{AutoStash: AutoStashEnabled})
+	if !IsErrorCode(err, ErrMergeConflict) {
+		t.Fatal("it should report the conflict of the autostash:", err)
+	}
+	if readWorkdirFile(repo, "b.txt") != "B\n" {
+		t.Error("it should check out the tree")
+	}
+	stash, err := repo.LookupReference(GitStashRef)
+	if err != nil {
+		t.Fatal("it should keep the changes in the stash:", err)
+	}
+	commit, _ := repo.LookupCommit(stash.Target())
+	if commit.ParentCount() != 2 || commit.Message() != "On master: autostash\n" {
+		t.Errorf("it should make a stash commit like git: %q", commit.Message())
+	}
+	log, _ := ioutil.ReadFile(filepath.Join(repo.Path(), "logs", "refs", "stash"))
+	lines := strings.Split(strings.TrimSuffix(string(log), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], commits[0].Id().String()+" "+stash.Target().String()+" S <s@example.com> ") || !strings.HasSuffix(lines[1], "\tautostash") {
+		t.Errorf("it should append the autostash to the stash log: %q", lines)
+	}
+}
+
+func Test_Rebase_AutoStash(t *testing.T) {
+	repo, head, commits := prepareAutostashRepository(t)
+	defer os.RemoveAll(repo.Workdir())
+	repo.CreateReference("refs/heads/topic", commits[3].Id(), true)
+	repo.CreateSymbolicReference(GitHeadFile, "refs/heads/topic", true)
+	tree, _ := commits[3].Tree()
+	repo.CheckoutTree(tree, &CheckoutOptions{Force: true})
+	ioutil.WriteFile(filepath.Join(repo.Workdir(), "c.txt"), []byte("c\nlocal\n"), 0644)
+	repo.Config().SetBool("rebase.autoStash", true)
+	sig := &Signature{"C", "c@example.com", repo.now()}
+
+	rebase, err := repo.InitRebase("", head, nil, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if _, err := os.Stat(filepath.Join(repo.Workdir(), "c.txt")); !os.IsNotExist(err) {
+		t.Error("it should stash the local changes")
+	}
+	rebase.Todo().Operations[1].Type = RebaseOperationDrop
+	for {
+		_, err = rebase.Next()
+		if IsErrorCode(err, ErrIterOver) {
+			break
+		} else if err != nil {
+			t.Fatal("err should be nil:", err)
+		}
+		rebase.Commit(nil, sig, "")
+	}
+	err = rebase.Finish()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if readWorkdirFile(repo, "c.txt") != "c\nlocal\n" || readWorkdirFile(repo, "b.txt") != "B\n" {
+		t.Error("it should apply the local changes after the rebase")
+	}
+
+	repo.Config().SetBool("rebase.autoStash", false)
+	_, err = repo.InitRebase("", head, nil, &RebaseOptions{AutoStash: AutoStashEnabled})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	rebase, _ = repo.OpenRebase(nil)
+	err = rebase.Abort()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if readWorkdirFile(repo, "c.txt") != "c\nlocal\n" {
+		t.Error("it should apply the local changes when the rebase is aborted")
+	}
+}
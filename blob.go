@@ -3,7 +3,6 @@ import (
 	"os"
 	"errors"
 	"path/filepath"
-	"io/ioutil"
 )
 
 func (r *Repository) LookupBlob(oid *Oid) (*Blob, error) {
@@ -77,7 +76,7 @@ func createBlobCreateFromPaths(repo *Repository, contentPath, hintPath string, h
 		}
 		contentPath = filepath.Join(repo.Workdir(), hintPath)
 	}
-	stat, err := os.Lstat(contentPath)
+	stat, err := repo.fs.Lstat(contentPath)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -95,15 +94,15 @@ func createBlobCreateFromPaths(repo *Repository, contentPath, hintPath string, h
 		mode = Filemode(stat.Mode())
 	}
 	var oid *Oid
-	if stat.Mode()&os.ModeSymlink == os.ModeSymlink {
-		targetPath, err := os.Readlink(contentPath)
+	if isSymlinkMode(stat.Mode()) {
+		targetPath, err := repo.fs.Readlink(contentPath)
 		if err != nil {
 			return nil, nil, err
 		}
 		oid, err = repo.CreateBlobFromBuffer([]byte(targetPath))
 	} else {
 		// todo: filter
-		content, err := ioutil.ReadFile(contentPath)
+		content, err := repo.fs.ReadFile(contentPath)
 		if err != nil {
 			return nil, nil, err
 		}
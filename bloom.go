@@ -0,0 +1,161 @@
+package git4go
+
+import (
+	"math/bits"
+	"strings"
+)
+
+// The settings of the changed-path Bloom filters that git writes
+const (
+	bloomFilterNumHashes       = 7
+	bloomFilterBitsPerEntry    = 10
+	bloomFilterMaxChangedPaths = 512
+	bloomFilterSeed0           = 0x293ae76f
+	bloomFilterSeed1           = 0x7e646e2c
+)
+
+// internal functions and methods
+
+// bloomSettings is the header of the BDAT chunk. Version 1 hashes the bytes
+// of non-ASCII paths as signed chars like git did first; version 2 fixed
+// it.
+type bloomSettings struct {
+	version      int
+	numHashes    int
+	bitsPerEntry int
+}
+
+var defaultBloomSettings = bloomSettings{
+	version:      1,
+	numHashes:    bloomFilterNumHashes,
+	bitsPerEntry: bloomFilterBitsPerEntry,
+}
+
+type bloomKey []uint32
+
+func newBloomKey(path string, settings bloomSettings) bloomKey {
+	hash0 := murmur3Seeded(bloomFilterSeed0, []byte(path), settings.version == 1)
+	hash1 := murmur3Seeded(bloomFilterSeed1, []byte(path), settings.version == 1)
+	key := make(bloomKey, settings.numHashes)
+	for i := range key {
+		key[i] = hash0 + uint32(i)*hash1
+	}
+	return key
+}
+
+// bloomKeysForPath returns the keys of the path and its leading
+// directories, which are all in the filter of a commit that changes it.
+func bloomKeysForPath(path string, settings bloomSettings) []bloomKey {
+	var keys []bloomKey
+	for {
+		keys = append(keys, newBloomKey(path, settings))
+		slash := strings.LastIndexByte(path, '/')
+		if slash == -1 {
+			return keys
+		}
+		path = path[:slash]
+	}
+}
+
+// bloomFilter is the filter of the paths that a commit changes compared to
+// its first parent. A filter of one 0xff byte matches all paths; git writes
+// it for commits that change too many paths.
+type bloomFilter []byte
+
+func newBloomFilter(pathCount int, settings bloomSettings) bloomFilter {
+	length := (pathCount*settings.bitsPerEntry + 7) / 8
+	if length == 0 {
+		length = 1
+	}
+	return make(bloomFilter, length)
+}
+
+func (f bloomFilter) add(key bloomKey) {
+	mod := uint64(len(f)) * 8
+	for _, hash := range key {
+		position := uint64(hash) % mod
+		f[position/8] |= 1 << (position % 8)
+	}
+}
+
+// contains tells if the key may be in the filter. An empty filter has no
+// information, so everything may be in it.
+func (f bloomFilter) contains(key bloomKey) bool {
+	mod := uint64(len(f)) * 8
+	if mod == 0 {
+		return true
+	}
+	for _, hash := range key {
+		position := uint64(hash) % mod
+		if f[position/8]&(1<<(position%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// mayChange tells if the commit of the filter may change one of the paths.
+// A path may be changed only if the filter has the keys of all of its
+// leading directories too.
+func (f bloomFilter) mayChange(paths [][]bloomKey) bool {
+	for _, keys := range paths {
+		found := true
+		for _, key := range keys {
+			if !f.contains(key) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// murmur3Seeded is the 32 bit MurmurHash3 of git. signedChars reproduces
+// version 1 of the filters, where the bytes above 0x7f were sign extended.
+func murmur3Seeded(seed uint32, data []byte, signedChars bool) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+	byteAt := func(i int) uint32 {
+		if signedChars {
+			return uint32(int32(int8(data[i])))
+		}
+		return uint32(data[i])
+	}
+	length := len(data)
+	for i := 0; i+4 <= length; i += 4 {
+		k := byteAt(i) | byteAt(i+1)<<8 | byteAt(i+2)<<16 | byteAt(i+3)<<24
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		seed ^= k
+		seed = bits.RotateLeft32(seed, 13)*5 + 0xe6546b64
+	}
+	tail := length &^ 3
+	var k1 uint32
+	switch length & 3 {
+	case 3:
+		k1 ^= byteAt(tail+2) << 16
+		fallthrough
+	case 2:
+		k1 ^= byteAt(tail+1) << 8
+		fallthrough
+	case 1:
+		k1 ^= byteAt(tail)
+		k1 *= c1
+		k1 = bits.RotateLeft32(k1, 15)
+		k1 *= c2
+		seed ^= k1
+	}
+	seed ^= uint32(length)
+	seed ^= seed >> 16
+	seed *= 0x85ebca6b
+	seed ^= seed >> 13
+	seed *= 0xc2b2ae35
+	seed ^= seed >> 16
+	return seed
+}
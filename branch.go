@@ -0,0 +1,105 @@
+package git4go
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BranchUpstreamName returns the reference that the local branch tracks,
+// like "refs/remotes/origin/master" for "refs/heads/master". It is read from
+// branch.<name>.remote and branch.<name>.merge, and the merge reference is
+// mapped through the fetch refspecs of the remote.
+func (r *Repository) BranchUpstreamName(refname string) (string, error) {
+	remoteName, mergeName, err := r.branchUpstreamConfig(refname)
+	if err != nil {
+		return "", err
+	}
+	if remoteName == "." {
+		return mergeName, nil
+	}
+	remote, err := r.LookupRemote(remoteName)
+	if err != nil {
+		return "", err
+	}
+	for _, fetch := range remote.fetch {
+		spec, err := ParseRefspec(fetch, true)
+		if err != nil || spec.Dst() == "" || !spec.SrcMatches(mergeName) {
+			continue
+		}
+		return spec.Transform(mergeName)
+	}
+	return "", MakeGitError(fmt.Sprintf("upstream of '%s' is not fetched by remote '%s'", refname, remoteName), ErrNotFound)
+}
+
+// BranchUpstreamRemote returns the name of the remote that the local branch
+// tracks. It is "." when the branch tracks another local branch.
+func (r *Repository) BranchUpstreamRemote(refname string) (string, error) {
+	remoteName, _, err := r.branchUpstreamConfig(refname)
+	return remoteName, err
+}
+
+// BranchRemoteName returns the name of the remote whose fetch refspecs
+// write the remote tracking branch, like "origin" for
+// "refs/remotes/origin/master".
+func (r *Repository) BranchRemoteName(refname string) (string, error) {
+	if !strings.HasPrefix(refname, GitRefsRemotesDir) {
+		return "", MakeGitError(fmt.Sprintf("reference '%s' is not a remote branch", refname), ErrInvalid)
+	}
+	found := ""
+	for _, name := range r.ListRemotes() {
+		remote, err := r.LookupRemote(name)
+		if err != nil {
+			return "", err
+		}
+		for _, fetch := range remote.fetch {
+			spec, err := ParseRefspec(fetch, true)
+			if err != nil || !spec.DstMatches(refname) {
+				continue
+			}
+			if found != "" && found != name {
+				return "", MakeGitError(fmt.Sprintf("reference '%s' is ambiguous between remotes '%s' and '%s'", refname, found, name), ErrAmbiguous)
+			}
+			found = name
+		}
+	}
+	if found == "" {
+		return "", MakeGitError(fmt.Sprintf("no remote fetches reference '%s'", refname), ErrNotFound)
+	}
+	return found, nil
+}
+
+// BranchUpstreamAheadBehind counts the commits of the local branch that are
+// not in its upstream (ahead) and the commits of the upstream that are not
+// in the local branch (behind).
+func (r *Repository) BranchUpstreamAheadBehind(refname string) (ahead, behind int, err error) {
+	upstreamName, err := r.BranchUpstreamName(refname)
+	if err != nil {
+		return 0, 0, err
+	}
+	local, err := referenceLookupResolved(r, refname, -1)
+	if err != nil {
+		return 0, 0, err
+	}
+	upstream, err := referenceLookupResolved(r, upstreamName, -1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return r.AheadBehind(local.Target(), upstream.Target())
+}
+
+// internal functions and methods
+
+func (r *Repository) branchUpstreamConfig(refname string) (remoteName, mergeName string, err error) {
+	if !strings.HasPrefix(refname, GitRefsHeadsDir) {
+		return "", "", MakeGitError(fmt.Sprintf("reference '%s' is not a local branch", refname), ErrInvalid)
+	}
+	shortName := refname[len(GitRefsHeadsDir):]
+	if config := r.Config(); config != nil {
+		remoteName, _ = config.LookupString("branch." + shortName + ".remote")
+		mergeName, _ = config.LookupString("branch." + shortName + ".merge")
+	}
+	if remoteName == "" || mergeName == "" {
+		return "", "", MakeGitError(fmt.Sprintf("branch '%s' has no upstream configuration", shortName), ErrNotFound)
+	}
+	return remoteName, mergeName, nil
+}
@@ -0,0 +1,65 @@
+package git4go
+
+import (
+	"./testutil"
+	"testing"
+)
+
+func Test_BranchUpstreamName(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	name, err := repo.BranchUpstreamName("refs/heads/master")
+	if err != nil || name != "refs/remotes/test/master" {
+		t.Error("it should map merge ref through fetch refspec:", name, err)
+	}
+	name, err = repo.BranchUpstreamName("refs/heads/track-local")
+	if err != nil || name != "refs/heads/master" {
+		t.Error("it should return local upstream as is:", name, err)
+	}
+	remote, err := repo.BranchUpstreamRemote("refs/heads/track-local")
+	if err != nil || remote != "." {
+		t.Error("it should return '.' for local upstream:", remote, err)
+	}
+	for _, branch := range []string{"refs/heads/cannot-fetch", "refs/heads/remoteless", "refs/heads/mergeless", "refs/heads/mergeandremoteless", "refs/heads/subtrees"} {
+		_, err = repo.BranchUpstreamName(branch)
+		if !IsErrorCode(err, ErrNotFound) {
+			t.Error("it should not find upstream:", branch, err)
+		}
+	}
+	_, err = repo.BranchUpstreamName("refs/remotes/test/master")
+	if !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should reject non local branch:", err)
+	}
+}
+
+func Test_BranchRemoteName(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	name, err := repo.BranchRemoteName("refs/remotes/test/master")
+	if err != nil || name != "test" {
+		t.Error("it should find remote of tracking branch:", name, err)
+	}
+	_, err = repo.BranchRemoteName("refs/remotes/missing/master")
+	if !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should not find remote:", err)
+	}
+	_, err = repo.BranchRemoteName("refs/heads/master")
+	if !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should reject local branch:", err)
+	}
+}
+
+func Test_BranchUpstreamAheadBehind(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	ahead, behind, err := repo.BranchUpstreamAheadBehind("refs/heads/master")
+	if err != nil || ahead != 1 || behind != 0 {
+		t.Error("it should count commits against upstream:", ahead, behind, err)
+	}
+}
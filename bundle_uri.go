@@ -0,0 +1,370 @@
+package git4go
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	GitRefsBundlesDir = "refs/bundles/"
+
+	gitBundleV2Signature = "# v2 git bundle\n"
+	gitBundleV3Signature = "# v3 git bundle\n"
+)
+
+// UriDownloadCallback downloads the uri of a bundle or a packfile, usually
+// over HTTPS from a CDN. The application provides it with its own HTTP
+// client and credentials.
+type UriDownloadCallback func(uri string) (io.ReadCloser, error)
+
+type BundleListMode int
+
+const (
+	// all bundles are needed, e.g. a base bundle and incremental ones
+	BundleListAll BundleListMode = iota
+	// any bundle is enough, e.g. copies of the same bundle in several
+	// regions
+	BundleListAny
+)
+
+// BundleListEntry is a bundle of a bundle list.
+type BundleListEntry struct {
+	Id  string
+	Uri string
+	// bundle.<id>.creationToken, 0 if it is not set
+	CreationToken uint64
+}
+
+// BundleList is the list of bundles that a server advertises with the
+// bundle-uri command of protocol v2, or that a bundle uri points to.
+// Clients download the bundles first and then fetch the rest from the
+// server, which has less to send.
+type BundleList struct {
+	Version int
+	Mode    BundleListMode
+	// "creationToken" if the bundles should be applied in the order of
+	// their creation tokens, or ""
+	Heuristic string
+	Bundles   []*BundleListEntry
+}
+
+// BundleHeader is the header of a bundle file.
+type BundleHeader struct {
+	Version int
+	// The commits that the receiving repository must have
+	Prerequisites []*Oid
+	// The references of the bundle, by their names in the bundled
+	// repository
+	References []RemoteHead
+}
+
+// UseBundleUris tells if transfer.bundleURI allows clients to download the
+// bundles that servers advertise. It is false by default, like in git.
+func (r *Repository) UseBundleUris() bool {
+	if config := r.Config(); config != nil {
+		if value, err := config.LookupBool("transfer.bundleURI"); err == nil {
+			return value
+		}
+	}
+	return false
+}
+
+// ParseBundleList parses the "key=value" lines of the response to the
+// bundle-uri command. Relative uris are resolved against baseUrl, the url
+// of the remote. Unknown keys are ignored like git does.
+func ParseBundleList(lines []string, baseUrl string) (*BundleList, error) {
+	var pairs [][2]string
+	for _, line := range lines {
+		line = strings.TrimSuffix(line, "\n")
+		equal := strings.IndexByte(line, '=')
+		if equal <= 0 {
+			return nil, MakeGitError(fmt.Sprintf("malformed bundle list line '%s'", line), ErrInvalid)
+		}
+		pairs = append(pairs, [2]string{line[:equal], line[equal+1:]})
+	}
+	return parseBundleList(pairs, baseUrl)
+}
+
+// ParseBundleListConfig parses a bundle list in the config format, which
+// a bundle uri can point to instead of a bundle. Relative uris are
+// resolved against baseUrl, the uri of the list.
+func ParseBundleListConfig(data []byte, baseUrl string) (*BundleList, error) {
+	config, err := NewConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err = config.addData(data, ConfigLevelApp); err != nil {
+		return nil, err
+	}
+	var pairs [][2]string
+	for _, entry := range config.Entries() {
+		pairs = append(pairs, [2]string{entry.Name, entry.Value})
+	}
+	return parseBundleList(pairs, baseUrl)
+}
+
+// ReadBundleHeader reads the header of a v2 or v3 bundle. The reader is
+// left at the start of the pack.
+func ReadBundleHeader(reader *bufio.Reader) (*BundleHeader, error) {
+	signature, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	header := &BundleHeader{}
+	switch signature {
+	case gitBundleV2Signature:
+		header.Version = 2
+	case gitBundleV3Signature:
+		header.Version = 3
+	default:
+		return nil, MakeGitError("not a v2 or v3 git bundle", ErrInvalid)
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, MakeGitError("truncated bundle header", ErrInvalid)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return header, nil
+		case header.Version == 3 && strings.HasPrefix(line, "@"):
+			capability := line[1:]
+			if capability != "object-format=sha1" && !strings.HasPrefix(capability, "filter=") {
+				return nil, MakeGitError(fmt.Sprintf("unsupported bundle capability '%s'", capability), ErrInvalid)
+			}
+		case strings.HasPrefix(line, "-"):
+			// the comment after the id is ignored
+			fields := strings.SplitN(line[1:], " ", 2)
+			oid, err := NewOid(fields[0])
+			if err != nil {
+				return nil, MakeGitError(fmt.Sprintf("invalid bundle prerequisite '%s'", line), ErrInvalid)
+			}
+			header.Prerequisites = append(header.Prerequisites, oid)
+		default:
+			fields := strings.SplitN(line, " ", 2)
+			oid, err := NewOid(fields[0])
+			if err != nil || len(fields) != 2 {
+				return nil, MakeGitError(fmt.Sprintf("invalid bundle reference '%s'", line), ErrInvalid)
+			}
+			header.References = append(header.References, RemoteHead{Id: oid, Name: fields[1]})
+		}
+	}
+}
+
+// Unbundle stores the pack of the bundle in the object database and
+// returns the header. The references of the bundle are not written. It
+// fails with ErrNotFound if a prerequisite is missing.
+func (r *Repository) Unbundle(reader io.Reader, progress IndexerProgressCallback) (*BundleHeader, error) {
+	buffered := bufio.NewReader(reader)
+	header, err := ReadBundleHeader(buffered)
+	if err != nil {
+		return nil, err
+	}
+	odb, err := r.Odb()
+	if err != nil {
+		return nil, err
+	}
+	for _, prerequisite := range header.Prerequisites {
+		if !odb.Exists(prerequisite) {
+			return nil, MakeGitError(fmt.Sprintf("repository lacks the prerequisite commit %s", prerequisite.String()), ErrNotFound)
+		}
+	}
+	writepack, err := odb.WritePack(progress)
+	if err != nil {
+		return nil, err
+	}
+	defer writepack.Close()
+	if _, err = io.Copy(writepack, buffered); err != nil {
+		return nil, err
+	}
+	if _, err = writepack.Commit(); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// FetchBundles downloads and unbundles the bundles of the list, like git
+// does before it fetches from a server that advertises bundle uris. The
+// references of each bundle are written below refs/bundles/, e.g.
+// refs/heads/main as refs/bundles/heads/main, so that the following fetch
+// tells the server that their commits are here. In BundleListAll mode the
+// bundles are applied in the order of their creation tokens, and a bundle
+// whose prerequisites are missing is tried again after the others; in
+// BundleListAny mode the first bundle that can be applied is enough. A
+// uri that points to a bundle list in the config format is followed once.
+// It returns the references that were written.
+func (r *Repository) FetchBundles(list *BundleList, download UriDownloadCallback) ([]RemoteHead, error) {
+	return r.fetchBundles(list, download, true)
+}
+
+// internal functions and methods
+
+func parseBundleList(pairs [][2]string, baseUrl string) (*BundleList, error) {
+	list := &BundleList{}
+	bundles := make(map[string]*BundleListEntry)
+	for _, pair := range pairs {
+		key, value := pair[0], pair[1]
+		if !strings.HasPrefix(strings.ToLower(key), "bundle.") {
+			continue
+		}
+		key = key[len("bundle."):]
+		dot := strings.LastIndexByte(key, '.')
+		if dot == -1 {
+			switch strings.ToLower(key) {
+			case "version":
+				version, err := strconv.Atoi(value)
+				if err != nil || version != 1 {
+					return nil, MakeGitError(fmt.Sprintf("unsupported bundle list version '%s'", value), ErrInvalid)
+				}
+				list.Version = version
+			case "mode":
+				switch value {
+				case "all":
+					list.Mode = BundleListAll
+				case "any":
+					list.Mode = BundleListAny
+				default:
+					return nil, MakeGitError(fmt.Sprintf("unknown bundle list mode '%s'", value), ErrInvalid)
+				}
+			case "heuristic":
+				list.Heuristic = value
+			}
+			continue
+		}
+		id := key[:dot]
+		bundle := bundles[id]
+		if bundle == nil {
+			bundle = &BundleListEntry{Id: id}
+			bundles[id] = bundle
+			list.Bundles = append(list.Bundles, bundle)
+		}
+		switch strings.ToLower(key[dot+1:]) {
+		case "uri":
+			uri, err := resolveBundleUri(baseUrl, value)
+			if err != nil {
+				return nil, err
+			}
+			bundle.Uri = uri
+		case "creationtoken":
+			token, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, MakeGitError(fmt.Sprintf("invalid creation token '%s' of bundle '%s'", value, id), ErrInvalid)
+			}
+			bundle.CreationToken = token
+		}
+	}
+	var result []*BundleListEntry
+	for _, bundle := range list.Bundles {
+		if bundle.Uri != "" {
+			result = append(result, bundle)
+		}
+	}
+	list.Bundles = result
+	return list, nil
+}
+
+func resolveBundleUri(baseUrl, uri string) (string, error) {
+	if baseUrl == "" {
+		return uri, nil
+	}
+	base, err := url.Parse(baseUrl)
+	if err != nil {
+		return "", MakeGitError(fmt.Sprintf("invalid base url '%s'", baseUrl), ErrInvalid)
+	}
+	reference, err := url.Parse(uri)
+	if err != nil {
+		return "", MakeGitError(fmt.Sprintf("invalid bundle uri '%s'", uri), ErrInvalid)
+	}
+	return base.ResolveReference(reference).String(), nil
+}
+
+func (r *Repository) fetchBundles(list *BundleList, download UriDownloadCallback, followLists bool) ([]RemoteHead, error) {
+	bundles := append([]*BundleListEntry(nil), list.Bundles...)
+	if list.Heuristic == "creationToken" {
+		sort.SliceStable(bundles, func(i, j int) bool {
+			return bundles[i].CreationToken < bundles[j].CreationToken
+		})
+	}
+	var written []RemoteHead
+	var lastErr error
+	for len(bundles) > 0 {
+		var pending []*BundleListEntry
+		for _, bundle := range bundles {
+			heads, err := r.fetchBundle(bundle.Uri, download, followLists)
+			if IsErrorCode(err, ErrNotFound) && list.Mode == BundleListAll {
+				// a later bundle may bring the prerequisites
+				pending = append(pending, bundle)
+				lastErr = err
+				continue
+			} else if err != nil && list.Mode == BundleListAll {
+				return written, err
+			} else if err != nil {
+				lastErr = err
+				continue
+			}
+			written = append(written, heads...)
+			if list.Mode == BundleListAny {
+				return written, nil
+			}
+		}
+		if len(pending) == len(bundles) {
+			return written, lastErr
+		}
+		bundles = pending
+	}
+	return written, nil
+}
+
+func (r *Repository) fetchBundle(uri string, download UriDownloadCallback, followLists bool) (heads []RemoteHead, err error) {
+	start := time.Now()
+	defer func() {
+		traceNet("download bundle", start, err, map[string]interface{}{
+			"uri":        uri,
+			"references": len(heads),
+		})
+	}()
+	body, err := download(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	reader := bufio.NewReader(body)
+	if signature, _ := reader.Peek(len(gitBundleV2Signature)); !strings.HasPrefix(string(signature), "# v") {
+		if !followLists {
+			return nil, MakeGitError(fmt.Sprintf("'%s' is not a bundle", uri), ErrInvalid)
+		}
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		list, err := ParseBundleListConfig(data, uri)
+		if err != nil {
+			return nil, err
+		}
+		return r.fetchBundles(list, download, false)
+	}
+	header, err := r.Unbundle(reader, nil)
+	if err != nil {
+		return nil, err
+	}
+	var written []RemoteHead
+	for _, head := range header.References {
+		if !strings.HasPrefix(head.Name, GitRefsDir) {
+			continue
+		}
+		name := GitRefsBundlesDir + head.Name[len(GitRefsDir):]
+		if _, err := r.CreateReference(name, head.Id, true); err != nil {
+			return nil, err
+		}
+		written = append(written, RemoteHead{Id: head.Id, Name: name})
+	}
+	return written, nil
+}
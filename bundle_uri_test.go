@@ -0,0 +1,125 @@
+package git4go
+
+import (
+	"./testutil"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// writeTestBundle writes a bundle of the commits that are reachable from
+// tip but not from prerequisite, which may be nil.
+func writeTestBundle(repo *Repository, tip, prerequisite *Oid, refname string) []byte {
+	var bundle bytes.Buffer
+	bundle.WriteString("# v2 git bundle\n")
+	walk, _ := repo.Walk()
+	walk.Push(tip)
+	if prerequisite != nil {
+		fmt.Fprintf(&bundle, "-%s a comment\n", prerequisite.String())
+		walk.Hide(prerequisite)
+	}
+	fmt.Fprintf(&bundle, "%s %s\n\n", tip.String(), refname)
+	builder, _ := repo.NewPackBuilder()
+	walk.Iterate(func(commit *Commit) bool {
+		builder.InsertCommit(commit.Id())
+		return true
+	})
+	builder.Write(&bundle)
+	return bundle.Bytes()
+}
+
+func Test_ParseBundleList(t *testing.T) {
+	list, err := ParseBundleList([]string{
+		"bundle.version=1",
+		"bundle.mode=any",
+		"bundle.heuristic=creationToken",
+		"bundle.eu.uri=/bundles/eu.bundle",
+		"bundle.eu.creationToken=2",
+		"bundle.us.uri=https://us.example.com/us.bundle",
+		"bundle.noUri.creationToken=3",
+		"unknown.key=ignored",
+	}, "https://example.com/repo.git")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if list.Version != 1 || list.Mode != BundleListAny || list.Heuristic != "creationToken" || len(list.Bundles) != 2 {
+		t.Fatal("it should parse the list:", list)
+	}
+	if list.Bundles[0].Uri != "https://example.com/bundles/eu.bundle" || list.Bundles[0].CreationToken != 2 {
+		t.Error("it should resolve relative uris:", list.Bundles[0])
+	}
+	if _, err = ParseBundleList([]string{"bundle.version=2"}, ""); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should reject unknown versions:", err)
+	}
+
+	list, err = ParseBundleListConfig([]byte("[bundle]\n\tversion = 1\n\tmode = all\n[bundle \"base\"]\n\turi = base.bundle\n"), "https://cdn.example.com/list")
+	if err != nil || len(list.Bundles) != 1 || list.Bundles[0].Uri != "https://cdn.example.com/base.bundle" {
+		t.Error("it should parse lists in the config format:", list, err)
+	}
+}
+
+func Test_FetchBundles(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	source, _ := OpenRepository("test_resources/testrepo.git")
+	base, _ := NewOid("5b5b025afb0b4c913b4c338a42934a3863bf3644")
+	tip, _ := NewOid("c47800c7266a2be04c571c04d5a6614691ea99bd")
+	files := map[string][]byte{
+		"https://cdn.example.com/base.bundle":        writeTestBundle(source, base, nil, "refs/heads/base"),
+		"https://cdn.example.com/incremental.bundle": writeTestBundle(source, tip, base, "refs/heads/master"),
+		"https://cdn.example.com/list":               []byte("[bundle]\n\tversion = 1\n\tmode = all\n[bundle \"base\"]\n\turi = base.bundle\n"),
+	}
+	var downloads []string
+	download := func(uri string) (io.ReadCloser, error) {
+		downloads = append(downloads, uri)
+		data, ok := files[uri]
+		if !ok {
+			return nil, errors.New("404 Not Found")
+		}
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	dir, _ := ioutil.TempDir("", "git4go_bundle")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	if _, err := repo.Unbundle(bytes.NewReader(files["https://cdn.example.com/incremental.bundle"]), nil); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should check the prerequisites:", err)
+	}
+
+	// the incremental bundle is listed first, but needs the base
+	list, _ := ParseBundleList([]string{
+		"bundle.version=1",
+		"bundle.mode=all",
+		"bundle.incremental.uri=https://cdn.example.com/incremental.bundle",
+		"bundle.base.uri=https://cdn.example.com/base.bundle",
+	}, "")
+	heads, err := repo.FetchBundles(list, download)
+	if err != nil || len(heads) != 2 || heads[0].Name != "refs/bundles/heads/base" || heads[1].Name != "refs/bundles/heads/master" {
+		t.Fatal("it should apply the bundles:", heads, err)
+	}
+	if ref, err := repo.LookupReference("refs/bundles/heads/master"); err != nil || !ref.Target().Equal(tip) {
+		t.Error("it should write the references below refs/bundles/:", err)
+	}
+	if _, err = repo.LookupCommit(tip); err != nil {
+		t.Error("it should store the objects:", err)
+	}
+
+	// the first bundle that works is enough, and lists are followed
+	downloads = nil
+	list, _ = ParseBundleList([]string{
+		"bundle.version=1",
+		"bundle.mode=any",
+		"bundle.missing.uri=https://cdn.example.com/missing.bundle",
+		"bundle.list.uri=https://cdn.example.com/list",
+		"bundle.base.uri=https://cdn.example.com/base.bundle",
+	}, "")
+	heads, err = repo.FetchBundles(list, download)
+	if err != nil || len(heads) != 1 || len(downloads) != 3 {
+		t.Error("it should stop at the first bundle that works:", heads, downloads, err)
+	}
+}
@@ -0,0 +1,207 @@
+package git4go
+
+import (
+	"container/list"
+	"sync"
+)
+
+const (
+	DefaultCommitCacheSize = 4096
+	// the number of the cached results of MergeBase, AheadBehind and
+	// DescendantOf
+	DefaultGraphCacheSize = 4096
+	// the number of the strings that the names of tree entries are
+	// interned in
+	DefaultStringPoolSize = 65536
+)
+
+// CommitCache keeps recently parsed commits so that walking the history
+// doesn't parse the same commit twice. It is bounded and evicts the least
+// recently used entries.
+type CommitCache struct {
+	lock       sync.Mutex
+	maxEntries int
+	entries    map[Oid]*list.Element
+	lru        *list.List
+}
+
+func NewCommitCache(maxEntries int) *CommitCache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCommitCacheSize
+	}
+	return &CommitCache{
+		maxEntries: maxEntries,
+		entries:    make(map[Oid]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (c *CommitCache) Get(oid *Oid) *Commit {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[*oid]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(element)
+	return element.Value.(*Commit)
+}
+
+func (c *CommitCache) Add(commit *Commit) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok := c.entries[*commit.oid]; ok {
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[*commit.oid] = c.lru.PushFront(commit)
+	for c.lru.Len() > c.maxEntries {
+		last := c.lru.Back()
+		c.lru.Remove(last)
+		delete(c.entries, *last.Value.(*Commit).oid)
+	}
+}
+
+func (c *CommitCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.lru.Len()
+}
+
+func (c *CommitCache) Clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries = make(map[Oid]*list.Element)
+	c.lru.Init()
+}
+
+// graphCache keeps the results of MergeBase, AheadBehind and DescendantOf,
+// which servers ask for again and again for the same branches. Commits
+// never change, so only replacements and shallow roots make the results
+// stale; they clear the cache. It evicts the least recently used entries.
+type graphCache struct {
+	lock       sync.Mutex
+	maxEntries int
+	entries    map[graphCacheKey]*list.Element
+	lru        *list.List
+}
+
+type graphQuery byte
+
+const (
+	graphQueryMergeBase graphQuery = iota
+	graphQueryAheadBehind
+	graphQueryDescendantOf
+)
+
+type graphCacheKey struct {
+	query    graphQuery
+	one, two Oid
+}
+
+type graphCacheEntry struct {
+	key graphCacheKey
+	// the merge base (nil if there is none), the ahead and behind counts
+	// or the descendant flag
+	base          *Oid
+	ahead, behind int
+	descendant    bool
+}
+
+func newGraphCache(maxEntries int) *graphCache {
+	return &graphCache{
+		maxEntries: maxEntries,
+		entries:    make(map[graphCacheKey]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (c *graphCache) get(key graphCacheKey) *graphCacheEntry {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		addCounter(MetricGraphCacheMisses, 1)
+		return nil
+	}
+	addCounter(MetricGraphCacheHits, 1)
+	c.lru.MoveToFront(element)
+	return element.Value.(*graphCacheEntry)
+}
+
+func (c *graphCache) add(entry *graphCacheEntry) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok := c.entries[entry.key]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.maxEntries {
+		last := c.lru.Back()
+		c.lru.Remove(last)
+		delete(c.entries, last.Value.(*graphCacheEntry).key)
+	}
+}
+
+func (c *graphCache) clear() {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries = make(map[graphCacheKey]*list.Element)
+	c.lru.Init()
+}
+
+// stringPool interns strings like tree entry names that appear again and
+// again in the history, so that each of them is allocated only once. It is
+// bounded: when it is full, it is emptied and the names that are still in
+// use come back.
+type stringPool struct {
+	lock       sync.Mutex
+	maxEntries int
+	strings    map[string]string
+}
+
+func newStringPool(maxEntries int) *stringPool {
+	if maxEntries <= 0 {
+		maxEntries = DefaultStringPoolSize
+	}
+	return &stringPool{
+		maxEntries: maxEntries,
+		strings:    make(map[string]string),
+	}
+}
+
+func (p *stringPool) intern(value []byte) string {
+	if p == nil {
+		return string(value)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if result, ok := p.strings[string(value)]; ok {
+		return result
+	}
+	if len(p.strings) >= p.maxEntries {
+		p.strings = make(map[string]string)
+	}
+	result := string(value)
+	p.strings[result] = result
+	return result
+}
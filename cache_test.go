@@ -0,0 +1,112 @@
+package git4go
+
+import (
+	"./testutil"
+	"testing"
+)
+
+func Test_CommitCache_Evict(t *testing.T) {
+	cache := NewCommitCache(2)
+	commits := []*Commit{}
+	for _, commitId := range commitIds[:3] {
+		oid, _ := NewOid(commitId)
+		commits = append(commits, &Commit{gitObject: gitObject{oid: oid}})
+	}
+	cache.Add(commits[0])
+	cache.Add(commits[1])
+	if cache.Get(commits[0].oid) != commits[0] {
+		t.Error("it should return cached commit")
+	}
+	cache.Add(commits[2])
+	if cache.Len() != 2 {
+		t.Error("it should keep only max entries:", cache.Len())
+	}
+	if cache.Get(commits[1].oid) != nil {
+		t.Error("it should evict least recently used commit")
+	}
+	if cache.Get(commits[0].oid) == nil || cache.Get(commits[2].oid) == nil {
+		t.Error("it should keep recently used commits")
+	}
+	cache.Clear()
+	if cache.Len() != 0 {
+		t.Error("it should be empty after clear")
+	}
+}
+
+func Test_LookupCommit_Cached(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	oid, _ := NewOid(commitHead)
+	commit1, err := repo.LookupCommit(oid)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	commit2, _ := repo.LookupCommit(oid)
+	if commit1 != commit2 {
+		t.Error("it should return cached commit")
+	}
+	if commit1.ParentCount() != 2 {
+		t.Error("merge commit should have two parents:", commit1.ParentCount())
+	}
+}
+
+func Test_RevWalk_CommitCache(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	for _, commitId := range commitIds {
+		oid, _ := NewOid(commitId)
+		repo.LookupCommit(oid)
+	}
+	walk, _ := repo.Walk()
+	oid, _ := NewOid(commitHead)
+	if !checkWalk(walk, oid, SortTime, commitSortingTime, t) {
+		t.Error("sort result error with cached commits")
+	}
+}
+
+func Test_StringPool(t *testing.T) {
+	pool := newStringPool(DefaultStringPoolSize)
+	name1 := pool.intern([]byte("README"))
+	name2 := pool.intern([]byte("README"))
+	if name1 != "README" || name2 != "README" {
+		t.Error("it should return same content")
+	}
+	if len(pool.strings) != 1 {
+		t.Error("it should store string once")
+	}
+}
+
+func Test_StringPool_Bounded(t *testing.T) {
+	pool := newStringPool(2)
+	for _, name := range []string{"a", "b", "c", "d", "c"} {
+		if pool.intern([]byte(name)) != name {
+			t.Error("it should return same content:", name)
+		}
+		if len(pool.strings) > 2 {
+			t.Fatal("it should keep only max entries:", len(pool.strings))
+		}
+	}
+}
+
+func Test_RevWalk_FillsCommitCache(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	repo.CommitCache().Clear()
+	walk, _ := repo.Walk()
+	oid, _ := NewOid(commitHead)
+	if !checkWalk(walk, oid, SortTime, commitSortingTime, t) {
+		t.Error("sort result error without cached commits")
+	}
+	for _, commitId := range commitIds {
+		oid, _ := NewOid(commitId)
+		if repo.CommitCache().Get(oid) == nil {
+			t.Error("it should cache the walked commits:", commitId)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+package git4go
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type CheckoutAction int
+
+const (
+	// The file does not exist in the working directory and is created
+	CheckoutActionCreate CheckoutAction = iota + 1
+	// The file is replaced with the content of the target tree
+	CheckoutActionUpdate
+	// The file is not in the target tree and is deleted
+	CheckoutActionDelete
+	// The file has local changes that the checkout would overwrite
+	CheckoutActionConflict
+)
+
+func (a CheckoutAction) String() string {
+	switch a {
+	case CheckoutActionCreate:
+		return "create"
+	case CheckoutActionUpdate:
+		return "update"
+	case CheckoutActionDelete:
+		return "delete"
+	case CheckoutActionConflict:
+		return "conflict"
+	}
+	return ""
+}
+
+// CheckoutNotifyCallback is called for every file that the checkout
+// changes. Returning an error stops the checkout.
+type CheckoutNotifyCallback func(action CheckoutAction, path string, baseline, target *IndexEntry) error
+
+// CheckoutProgressCallback is called after each file is processed.
+type CheckoutProgressCallback func(path string, completed, total uint)
+
+// CheckoutPerfdata counts the file system calls of a checkout.
+type CheckoutPerfdata struct {
+	MkdirCalls uint
+	StatCalls  uint
+	ChmodCalls uint
+}
+
+// add sums up the counts of the goroutines of a checkout.
+func (p *CheckoutPerfdata) add(others []CheckoutPerfdata) {
+	for _, other := range others {
+		p.MkdirCalls += other.MkdirCalls
+		p.StatCalls += other.StatCalls
+		p.ChmodCalls += other.ChmodCalls
+	}
+}
+
+// CheckoutPerfdataCallback is called when the checkout is finished.
+type CheckoutPerfdataCallback func(perfdata *CheckoutPerfdata)
+
+type CheckoutOptions struct {
+	// Only report what the checkout would do, without touching the working
+	// directory or the index
+	DryRun bool
+	// Files with local changes are overwritten instead of being reported
+	// as conflicts
+	Force bool
+	// Local changes are stashed before the checkout and applied to the new
+	// files after it, instead of being reported as conflicts. It is off by
+	// default.
+	AutoStash AutoStashMode
+	// The number of goroutines that check and write the files. 0 reads
+	// checkout.workers, and uses the number of CPUs if it is not set.
+	// Fewer files than checkout.thresholdForParallelism (100 by default)
+	// are done on one goroutine.
+	Workers          int
+	NotifyCallback   CheckoutNotifyCallback
+	ProgressCallback CheckoutProgressCallback
+	PerfdataCallback CheckoutPerfdataCallback
+}
+
+// CheckoutTree makes the index and the working directory match the tree.
+// Each file that is created, updated, deleted or that conflicts with local
+// changes is reported to the callbacks first. Nothing is changed if a file
+// conflicts, the checkout fails with ErrUncommitted then; Force overwrites
+// the local changes instead. With DryRun, only the report is made.
+func (r *Repository) CheckoutTree(tree *Tree, opts *CheckoutOptions) error {
+	if opts == nil {
+		opts = &CheckoutOptions{}
+	}
+	if r.IsBare() {
+		return MakeGitError("cannot checkout to a bare repository", ErrBareRepository)
+	}
+	if !opts.DryRun && r.readOnly {
+		return errReadOnly("Repository.CheckoutTree")
+	}
+	if !opts.DryRun && !opts.Force && r.autoStashEnabled(opts.AutoStash, "") {
+		return r.checkoutAutostash(tree, opts)
+	}
+	index, err := r.Index()
+	if err != nil {
+		return err
+	}
+
+	targets := make(map[string]*IndexEntry)
+	err = tree.WalkPost(func(root string, treeEntry *TreeEntry) int {
+		if treeEntry.Type == ObjectTree {
+			return 0
+		}
+		path := filepath.ToSlash(filepath.Join(root, treeEntry.Name))
+		targets[path] = &IndexEntry{
+			Path: path,
+			Mode: treeEntry.Filemode,
+			Id:   treeEntry.Id,
+		}
+		return 0
+	})
+	if err != nil {
+		return err
+	}
+	baselines := make(map[string]*IndexEntry)
+	for _, entry := range index.Entries {
+		if entry.Stage() == 0 {
+			baselines[entry.Path] = entry
+		}
+	}
+	paths := make([]string, 0, len(targets)+len(baselines))
+	for path := range targets {
+		paths = append(paths, path)
+	}
+	for path := range baselines {
+		if _, ok := targets[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	// the files are checked in parallel, and reported in order
+	workers := r.parallelWorkers(opts.Workers, "checkout.workers", len(paths))
+	perfdatas := make([]CheckoutPerfdata, workers)
+	pathActions := make([]CheckoutAction, len(paths))
+	err = parallelFor(workers, len(paths), func(worker, i int) error {
+		var err error
+		pathActions[i], err = r.checkoutAction(baselines[paths[i]], targets[paths[i]], opts.Force, &perfdatas[worker])
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	perfdata := &CheckoutPerfdata{}
+	perfdata.add(perfdatas)
+	actions := make(map[string]CheckoutAction)
+	conflicts := 0
+	for i, path := range paths {
+		action := pathActions[i]
+		if action != 0 && opts.NotifyCallback != nil {
+			err = opts.NotifyCallback(action, path, baselines[path], targets[path])
+			if err != nil {
+				return err
+			}
+		}
+		if action == CheckoutActionConflict {
+			conflicts++
+		} else if action != 0 {
+			actions[path] = action
+		}
+		if opts.ProgressCallback != nil {
+			opts.ProgressCallback(path, uint(i+1), uint(len(paths)))
+		}
+	}
+	if !opts.DryRun {
+		if conflicts > 0 {
+			return MakeGitError(fmt.Sprintf("%d conflicts prevent checkout", conflicts), ErrUncommitted)
+		}
+		err = r.checkoutApply(index, tree, targets, actions, opts.Workers, perfdata)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.PerfdataCallback != nil {
+		opts.PerfdataCallback(perfdata)
+	}
+	return nil
+}
+
+// internal functions and methods
+
+// checkoutAutostash stashes the local changes, checks out the tree and
+// applies the changes again. The changes are applied back even if the
+// checkout fails.
+func (r *Repository) checkoutAutostash(tree *Tree, opts *CheckoutOptions) error {
+	stash, err := r.autostashCreate()
+	if err != nil {
+		return err
+	}
+	withoutStash := *opts
+	withoutStash.AutoStash = AutoStashDisabled
+	err = r.CheckoutTree(tree, &withoutStash)
+	if stash == nil {
+		return err
+	}
+	applied := tree
+	if err != nil {
+		head, headErr := r.headCommit()
+		if headErr != nil {
+			return err
+		}
+		if applied, headErr = head.Tree(); headErr != nil {
+			return err
+		}
+	}
+	if applyErr := r.autostashApply(stash, applied); err == nil {
+		err = applyErr
+	}
+	return err
+}
+
+// checkoutApply writes the files of the actions to the working directory and
+// replaces the index with the tree. The entries of the written files get
+// their new stat data, so the files are not read again to find changes.
+func (r *Repository) checkoutApply(index *Index, tree *Tree, targets map[string]*IndexEntry, actions map[string]CheckoutAction, workers int, perfdata *CheckoutPerfdata) error {
+	paths := make([]string, 0, len(actions))
+	for path := range actions {
+		paths = append(paths, path)
+	}
+	// files are deleted before the directories that replace them are made
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	for _, path := range paths {
+		if actions[path] == CheckoutActionDelete {
+			err := r.checkoutRemove(path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	var writes []string
+	for i := len(paths) - 1; i >= 0; i-- {
+		if actions[paths[i]] != CheckoutActionDelete {
+			writes = append(writes, paths[i])
+		}
+	}
+	workers = r.parallelWorkers(workers, "checkout.workers", len(writes))
+	perfdatas := make([]CheckoutPerfdata, workers)
+	stats := make([]os.FileInfo, len(writes))
+	err := parallelFor(workers, len(writes), func(worker, i int) error {
+		var err error
+		stats[i], err = r.checkoutWrite(targets[writes[i]], &perfdatas[worker])
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	perfdata.add(perfdatas)
+	written := make(map[string]os.FileInfo)
+	for i, path := range writes {
+		written[path] = stats[i]
+	}
+
+	err = index.ReadTree(tree)
+	if err != nil {
+		return err
+	}
+	for _, entry := range index.Entries {
+		if stat := written[entry.Path]; stat != nil {
+			entry.Mtime = stat.ModTime()
+			entry.Dev, entry.Ino = fileDevIno(stat)
+			entry.Size = uint32(stat.Size())
+		}
+	}
+	if index.filePath == "" {
+		return nil
+	}
+	return index.Write()
+}
+
+// checkoutWrite writes the file of the entry. A file in the way is
+// replaced, so the mode of the new file is the one of the entry.
+func (r *Repository) checkoutWrite(entry *IndexEntry, perfdata *CheckoutPerfdata) (os.FileInfo, error) {
+	fullPath := filepath.Join(r.Workdir(), filepath.FromSlash(entry.Path))
+	perfdata.MkdirCalls++
+	err := r.fs.MkdirAll(filepath.Dir(fullPath), os.FileMode(GitObjectDirMode))
+	if err != nil {
+		return nil, err
+	}
+	if entry.Mode == FilemodeCommit {
+		// the working directory of a submodule is checked out by itself
+		perfdata.MkdirCalls++
+		return nil, r.fs.MkdirAll(fullPath, os.FileMode(GitObjectDirMode))
+	}
+	err = r.fs.Remove(fullPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	blob, err := r.LookupBlob(entry.Id)
+	if err != nil {
+		return nil, err
+	}
+	content := blob.Contents()
+	if entry.Mode == FilemodeLink {
+		err = r.fs.Symlink(string(content), fullPath)
+	} else {
+		if entry.Mode != FilemodeLink {
+			content, err = r.ConvertToWorkdir(entry.Path, content)
+			if err != nil {
+				return nil, err
+			}
+		}
+		perm := os.FileMode(0666)
+		if entry.Mode == FilemodeBlobExecutable {
+			perm = 0777
+		}
+		var file WritableFile
+		file, err = r.fs.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if err != nil {
+			return nil, err
+		}
+		_, err = file.Write(content)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	perfdata.StatCalls++
+	return r.fs.Lstat(fullPath)
+}
+
+// checkoutRemove deletes the file and the directories that it leaves
+// empty.
+func (r *Repository) checkoutRemove(path string) error {
+	workdir := filepath.Clean(r.Workdir())
+	fullPath := filepath.Join(workdir, filepath.FromSlash(path))
+	err := r.fs.Remove(fullPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := filepath.Dir(fullPath); dir != workdir && strings.HasPrefix(dir, workdir); dir = filepath.Dir(dir) {
+		if r.fs.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (r *Repository) checkoutAction(baseline, target *IndexEntry, force bool, perfdata *CheckoutPerfdata) (CheckoutAction, error) {
+	if baseline == nil {
+		// untracked files in the way are only kept when they already have
+		// the content of the target
+		exists, matches, err := r.workdirMatches(target.Path, target, false, perfdata)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case !exists:
+			return CheckoutActionCreate, nil
+		case matches:
+			return 0, nil
+		case force:
+			return CheckoutActionUpdate, nil
+		}
+		return CheckoutActionConflict, nil
+	}
+
+	exists, matches, err := r.workdirMatches(baseline.Path, baseline, true, perfdata)
+	if err != nil {
+		return 0, err
+	}
+	dirty := exists && !matches
+	switch {
+	case target == nil:
+		if !exists {
+			return 0, nil
+		}
+		if dirty && !force {
+			return CheckoutActionConflict, nil
+		}
+		return CheckoutActionDelete, nil
+	case baseline.Mode == target.Mode && baseline.Id.Equal(target.Id):
+		if !exists {
+			return CheckoutActionCreate, nil
+		}
+		if dirty && force {
+			return CheckoutActionUpdate, nil
+		}
+		return 0, nil
+	case dirty && !force:
+		return CheckoutActionConflict, nil
+	case !exists:
+		return CheckoutActionCreate, nil
+	}
+	return CheckoutActionUpdate, nil
+}
+
+// workdirMatches checks whether the file in the working directory has the
+// content of the entry. If useStat is true, the file is not read when the
+// size and the mtime are the ones that the index recorded.
+func (r *Repository) workdirMatches(path string, entry *IndexEntry, useStat bool, perfdata *CheckoutPerfdata) (exists, matches bool, err error) {
+	fullPath := filepath.Join(r.Workdir(), filepath.FromSlash(path))
+	perfdata.StatCalls++
+	stat, err := r.fs.Lstat(fullPath)
+	if os.IsNotExist(err) {
+		return false, false, nil
+	} else if err != nil {
+		return false, false, err
+	}
+	if stat.IsDir() {
+		return true, false, nil
+	}
+	if useStat && uint32(stat.Size()) == entry.Size && stat.ModTime().Equal(entry.Mtime) {
+		return true, true, nil
+	}
+	var content []byte
+	if isSymlinkMode(stat.Mode()) {
+		var target string
+		target, err = r.fs.Readlink(fullPath)
+		content = []byte(target)
+	} else {
+		content, err = r.fs.ReadFile(fullPath)
+		if err == nil {
+			// the file is compared in the form that git stores it
+			content, err = r.ConvertToOdb(path, content)
+		}
+	}
+	if err != nil {
+		return true, false, err
+	}
+	oid, err := hash(content, ObjectBlob)
+	if err != nil {
+		return true, false, err
+	}
+	return true, oid.Equal(entry.Id), nil
+}
+
+// workdirContent reads the file in the working directory in the form that
+// git stores it.
+func (r *Repository) workdirContent(path string) ([]byte, error) {
+	fullPath := filepath.Join(r.Workdir(), filepath.FromSlash(path))
+	stat, err := r.fs.Lstat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	if isSymlinkMode(stat.Mode()) {
+		target, err := r.fs.Readlink(fullPath)
+		return []byte(target), err
+	}
+	content, err := r.fs.ReadFile(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	return r.ConvertToOdb(path, content)
+}
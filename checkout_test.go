@@ -0,0 +1,185 @@
+package git4go
+
+import (
+	"./testutil"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func Test_CheckoutTree_DryRun(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/status")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/status/.git")
+	head, _ := repo.Head()
+	commit, _ := repo.LookupCommit(head.Target())
+	tree, _ := commit.Tree()
+
+	actions := make(map[string]string)
+	var progress uint
+	var perfdata *CheckoutPerfdata
+	err := repo.CheckoutTree(tree, &CheckoutOptions{
+		DryRun: true,
+		NotifyCallback: func(action CheckoutAction, path string, baseline, target *IndexEntry) error {
+			actions[path] = action.String()
+			return nil
+		},
+		ProgressCallback: func(path string, completed, total uint) {
+			progress = completed
+		},
+		PerfdataCallback: func(data *CheckoutPerfdata) {
+			perfdata = data
+		},
+	})
+	if err != nil {
+		t.Fatal("it should plan checkout:", err)
+	}
+	expected := map[string]string{
+		"file_deleted":                  "create",
+		"staged_changes":                "update",
+		"staged_changes_file_deleted":   "create",
+		"staged_changes_modified_file":  "conflict",
+		"staged_delete_file_deleted":    "create",
+		"staged_new_file":               "delete",
+		"staged_new_file_modified_file": "conflict",
+		"subdir/deleted_file":           "create",
+	}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Error("it should report checkout actions:", actions)
+	}
+	if progress != 15 {
+		t.Error("it should report progress of each file:", progress)
+	}
+	if perfdata == nil || perfdata.StatCalls != 15 || perfdata.MkdirCalls != 0 {
+		t.Error("it should report perfdata:", perfdata)
+	}
+
+	actions = make(map[string]string)
+	repo.CheckoutTree(tree, &CheckoutOptions{
+		DryRun: true,
+		Force:  true,
+		NotifyCallback: func(action CheckoutAction, path string, baseline, target *IndexEntry) error {
+			actions[path] = action.String()
+			return nil
+		},
+	})
+	if actions["modified_file"] != "update" || actions["staged_new_file_modified_file"] != "delete" {
+		t.Error("it should overwrite local changes with force:", actions)
+	}
+
+	err = repo.CheckoutTree(tree, nil)
+	if !IsErrorCode(err, ErrUncommitted) {
+		t.Error("it should not overwrite local changes:", err)
+	}
+	if _, err := os.Stat("test_resources/status/file_deleted"); !os.IsNotExist(err) {
+		t.Error("it should not change anything when files conflict")
+	}
+}
+
+func Test_CheckoutTree(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/status")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/status/.git")
+	head, _ := repo.Head()
+	commit, _ := repo.LookupCommit(head.Target())
+	tree, _ := commit.Tree()
+
+	var perfdata *CheckoutPerfdata
+	err := repo.CheckoutTree(tree, &CheckoutOptions{
+		Force: true,
+		PerfdataCallback: func(data *CheckoutPerfdata) {
+			perfdata = data
+		},
+	})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if perfdata == nil || perfdata.MkdirCalls == 0 {
+		t.Error("it should report the directories that it made:", perfdata)
+	}
+	for _, path := range []string{"file_deleted", "subdir/deleted_file", "modified_file"} {
+		entry, _ := tree.EntryByPath(path)
+		blob, _ := repo.LookupBlob(entry.Id)
+		content, err := ioutil.ReadFile(filepath.Join("test_resources/status", path))
+		if err != nil || !bytes.Equal(content, blob.Contents()) {
+			t.Error("it should write the file of the tree:", path, err)
+		}
+	}
+	if _, err := os.Stat("test_resources/status/staged_new_file"); !os.IsNotExist(err) {
+		t.Error("it should delete the file that is not in the tree")
+	}
+	if _, err := os.Stat("test_resources/status/new_file"); err != nil {
+		t.Error("it should keep untracked files:", err)
+	}
+
+	repo, _ = OpenRepository("test_resources/status/.git")
+	index, _ := repo.Index()
+	files, _ := flattenTree(tree)
+	if index.EntryCount() != uint(len(files)) {
+		t.Error("it should write the index of the tree:", index.EntryCount())
+	}
+	if _, err := index.EntryByPath("staged_new_file", 0); err == nil {
+		t.Error("it should remove the deleted file from the index")
+	}
+	actions := 0
+	repo.CheckoutTree(tree, &CheckoutOptions{
+		DryRun: true,
+		NotifyCallback: func(action CheckoutAction, path string, baseline, target *IndexEntry) error {
+			actions++
+			return nil
+		},
+	})
+	if actions != 0 {
+		t.Error("it should leave nothing to check out:", actions)
+	}
+}
+
+func Test_CheckoutTree_Parallel(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_checkout")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	files := make(map[string]string)
+	for i := 0; i < 150; i++ {
+		files[fmt.Sprintf("dir%d/file%03d.txt", i%5, i)] = fmt.Sprintf("%d\n", i)
+	}
+	commit := writeMergeCommit(repo, files)
+	tree, _ := commit.Tree()
+
+	var paths []string
+	var perfdata *CheckoutPerfdata
+	err := repo.CheckoutTree(tree, &CheckoutOptions{
+		Workers: 4,
+		NotifyCallback: func(action CheckoutAction, path string, baseline, target *IndexEntry) error {
+			paths = append(paths, path)
+			return nil
+		},
+		PerfdataCallback: func(data *CheckoutPerfdata) {
+			perfdata = data
+		},
+	})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if len(paths) != 150 || !sort.StringsAreSorted(paths) {
+		t.Error("it should report the files in order:", len(paths))
+	}
+	if perfdata.StatCalls < 300 || perfdata.MkdirCalls != 150 {
+		t.Error("it should sum up the calls of all goroutines:", perfdata)
+	}
+	for path, content := range files {
+		if readWorkdirFile(repo, path) != content {
+			t.Error("it should write the file:", path)
+		}
+	}
+	index, _ := repo.Index()
+	if index.EntryCount() != 150 {
+		t.Error("it should write the index of the tree:", index.EntryCount())
+	}
+}
@@ -3,12 +3,39 @@ package git4go
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"strings"
 )
 
+const gitSignatureField = "gpgsig"
+
+// SigningCallback signs the payload, which is the content of the unsigned
+// commit or tag. It returns the armored signature and, for a commit, the
+// name of the header that holds it ("gpgsig" if it is empty, or e.g.
+// "gpgsig-sha256"). The signer is up to the application: gpg-agent, SSH
+// keys, a KMS and so on.
+type SigningCallback func(payload string) (signature, signatureField string, err error)
+
+type CreateCommitOpts struct {
+	// The commit is signed with it if it is not nil
+	SigningCallback SigningCallback
+}
+
 func (r *Repository) LookupCommit(oid *Oid) (*Commit, error) {
+	if r.commitCache != nil {
+		if commit := r.commitCache.Get(oid); commit != nil {
+			addCounter(MetricCommitCacheHits, 1)
+			return commit, nil
+		}
+		addCounter(MetricCommitCacheMisses, 1)
+	}
 	obj, err := objectLookupPrefix(r, oid, GitOidHexSize, ObjectCommit)
 	if obj != nil {
-		return obj.(*Commit), err
+		commit := obj.(*Commit)
+		if r.commitCache != nil {
+			r.commitCache.Add(commit)
+		}
+		return commit, err
 	}
 	return nil, err
 }
@@ -21,6 +48,76 @@ func (r *Repository) LookupPrefixCommit(oid *Oid, length int) (*Commit, error) {
 	return nil, err
 }
 
+// CreateCommit writes the commit object. If refname is not empty, the
+// reference (or the branch that it points to, like HEAD) is updated to the
+// new commit; its current value must be the first parent, otherwise it
+// fails with ErrModified.
+func (r *Repository) CreateCommit(refname string, author, committer *Signature, message string, tree *Tree, parents ...*Commit) (*Oid, error) {
+	return r.CreateCommitExtended(refname, author, committer, message, tree, parents, nil)
+}
+
+// CreateCommitExtended is CreateCommit with options, like the callback
+// that signs the commit.
+func (r *Repository) CreateCommitExtended(refname string, author, committer *Signature, message string, tree *Tree, parents []*Commit, opts *CreateCommitOpts) (*Oid, error) {
+	if tree == nil || author == nil || committer == nil {
+		return nil, errors.New("tree, author and committer should not be nil")
+	}
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "tree %s\n", tree.Id().String())
+	for _, parent := range parents {
+		fmt.Fprintf(&buffer, "parent %s\n", parent.Id().String())
+	}
+	writeSignature(&buffer, "author ", author)
+	writeSignature(&buffer, "committer ", committer)
+	header := buffer.Len()
+	buffer.WriteByte('\n')
+	buffer.WriteString(message)
+
+	data := buffer.Bytes()
+	if opts != nil && opts.SigningCallback != nil {
+		signature, field, err := opts.SigningCallback(string(data))
+		if err != nil {
+			return nil, err
+		}
+		if field == "" {
+			field = gitSignatureField
+		}
+		if strings.ContainsAny(field, " \n") {
+			return nil, MakeGitError(fmt.Sprintf("invalid signature field '%s'", field), ErrInvalid)
+		}
+		var signed bytes.Buffer
+		signed.Write(data[:header])
+		signed.WriteString(field + " ")
+		signed.WriteString(strings.Replace(strings.TrimRight(signature, "\n"), "\n", "\n ", -1))
+		signed.WriteByte('\n')
+		signed.Write(data[header:])
+		data = signed.Bytes()
+	}
+
+	odb, err := r.Odb()
+	if err != nil {
+		return nil, err
+	}
+	if err = odb.checkNewObject(data, ObjectCommit); err != nil {
+		return nil, err
+	}
+	oid, err := odb.Write(data, ObjectCommit)
+	if err != nil {
+		return nil, err
+	}
+	if refname != "" {
+		var parent *Oid
+		if len(parents) > 0 {
+			parent = parents[0].Id()
+		}
+		err = r.updateCommitRef(refname, parent, oid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return oid, nil
+}
+
 type Commit struct {
 	gitObject
 	message   string
@@ -28,6 +125,7 @@ type Commit struct {
 	treeId    *Oid
 	author    *Signature
 	committer *Signature
+	rawHeader string
 	Parents   []*Oid
 }
 
@@ -43,7 +141,16 @@ func (c *Commit) Message() string {
 	return c.message
 }
 
+// Summary returns the first paragraph of the message in one line, like
+// the subject of "git log --oneline".
 func (c *Commit) Summary() string {
+	if c.summary == "" {
+		paragraph := strings.TrimLeft(c.message, " \t\r\n")
+		if end := strings.Index(paragraph, "\n\n"); end != -1 {
+			paragraph = paragraph[:end]
+		}
+		c.summary = strings.TrimSpace(strings.Replace(paragraph, "\n", " ", -1))
+	}
 	return c.summary
 }
 
@@ -63,6 +170,13 @@ func (c Commit) Committer() *Signature {
 	return c.committer
 }
 
+// RawHeader returns the header lines of the commit as they are stored,
+// including the author and committer lines that Author and Committer read
+// leniently when they are broken.
+func (c *Commit) RawHeader() string {
+	return c.rawHeader
+}
+
 func (c *Commit) Parent(n int) *Commit {
 	parent, _ := c.repo.LookupCommit(c.Parents[n])
 	return parent
@@ -98,11 +212,39 @@ func (c *Commit) Amend(refname string, author, committer *Signature, message str
 	return nil, nil
 }
 
+// updateCommitRef moves the reference, or the reference that it points to,
+// from the parent to the new commit. A reference that does not exist yet,
+// like the branch of an unborn HEAD, is created if the commit has no
+// parents. The reference is locked while it is compared with the parent
+// and written, so it fails with ErrModified if another writer has moved it.
+func (r *Repository) updateCommitRef(refname string, parent, oid *Oid) error {
+	name := refname
+	for i := 0; i < MaxNestingLevel; i++ {
+		ref, err := r.LookupReference(name)
+		if err != nil || ref.Type() != ReferenceSymbolic {
+			break
+		}
+		name = ref.SymbolicTarget()
+	}
+	refDb, name, err := r.refDbForWrite(name)
+	if err != nil {
+		return err
+	}
+	err = refDb.update(name, []byte(oid.String()+"\n"), parent)
+	if IsErrorCode(err, ErrModified) {
+		return MakeGitError(fmt.Sprintf("failed to create commit: current tip of '%s' is not the first parent", name), ErrModified)
+	}
+	return err
+}
+
 func newCommit(repo *Repository, oid *Oid, contents []byte) (*Commit, error) {
 	offset := 0
 	var tree *Oid
 	var parents []*Oid
 	tree, offset = parseOidWithPrefix(contents, offset, []byte("tree "))
+	if tree == nil {
+		return nil, errors.New("Commit parse error: tree field is invalid")
+	}
 	for {
 		var parent *Oid
 		parent, offset = parseOidWithPrefix(contents, offset, []byte("parent "))
@@ -127,7 +269,7 @@ func newCommit(repo *Repository, oid *Oid, contents []byte) (*Commit, error) {
 		for eol < len(contents) && contents[eol] != '\n' {
 			eol++
 		}
-		if bytes.Compare([]byte("encoding "), contents[offset:offset+len("encoding ")]) == 0 {
+		if bytes.HasPrefix(contents[offset:], []byte("encoding ")) {
 			offset += len("encoding ")
 			// messageEncoding := contents[offset+len("encoding "):eol]
 		}
@@ -136,12 +278,17 @@ func newCommit(repo *Repository, oid *Oid, contents []byte) (*Commit, error) {
 		}
 		offset = eol
 	}
-	// rawHeader := contents[:offset]
+	rawHeader := string(contents[:offset])
+	if offset < len(contents) && contents[offset] == '\n' {
+		offset++
+	}
 	return &Commit{
 		message:   string(contents[offset:]),
 		treeId:    tree,
 		author:    author,
 		committer: committer,
+		rawHeader: rawHeader,
+		Parents:   parents,
 		gitObject: gitObject{
 			repo: repo,
 			oid:  oid,
@@ -0,0 +1,226 @@
+package git4go
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	GitCommitGraphFile = "info/commit-graph"
+
+	commitGraphSignature   = "CGPH"
+	commitGraphVersion     = 1
+	commitGraphHashVersion = 1
+	commitGraphHeaderSize  = 8
+	commitGraphChunkSize   = 12
+	commitGraphDataSize    = GitOidRawSize + 16
+	commitGraphBloomHeader = 12
+
+	commitGraphChunkFanout     = 0x4f494446 // "OIDF"
+	commitGraphChunkOids       = 0x4f49444c // "OIDL"
+	commitGraphChunkData       = 0x43444154 // "CDAT"
+	commitGraphChunkExtraEdges = 0x45444745 // "EDGE"
+	commitGraphChunkBloomIndex = 0x42494458 // "BIDX"
+	commitGraphChunkBloomData  = 0x42444154 // "BDAT"
+
+	commitGraphParentNone    = 0x70000000
+	commitGraphExtraEdges    = 0x80000000
+	commitGraphLastEdge      = 0x80000000
+	commitGraphMaxGeneration = 0x3fffffff
+)
+
+// CommitGraph is the commit-graph file of the object database
+// (objects/info/commit-graph), which git writes with "git commit-graph
+// write". Its changed-path Bloom filters tell which commits cannot change a
+// path, so walks that are limited to paths skip their tree comparisons.
+// Split commit-graph chains are not read.
+type CommitGraph struct {
+	count      int
+	fanout     []byte
+	oids       []byte
+	commitData []byte
+	bloomIndex []byte
+	bloomData  []byte
+	bloom      bloomSettings
+
+	modTime time.Time
+	size    int64
+}
+
+// CommitGraph returns the commit-graph of the repository. It is read again
+// when the file was changed. It fails with ErrNotFound if the repository
+// has no commit-graph file.
+func (r *Repository) CommitGraph() (*CommitGraph, error) {
+	if r.pathRepository == "" {
+		return nil, MakeGitError("in-memory repositories have no commit-graph", ErrNotFound)
+	}
+	path := filepath.Join(r.pathCommon, GitObjectsDir, GitCommitGraphFile)
+
+	r.commitGraphLock.Lock()
+	defer r.commitGraphLock.Unlock()
+
+	info, err := r.fs.Stat(path)
+	if os.IsNotExist(err) {
+		r.commitGraph = nil
+		return nil, MakeGitError(fmt.Sprintf("commit-graph '%s' does not exist", path), ErrNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+	if graph := r.commitGraph; graph != nil && graph.modTime.Equal(info.ModTime()) && graph.size == info.Size() {
+		return graph, nil
+	}
+	data, err := r.fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	graph, err := parseCommitGraph(data)
+	if err != nil {
+		return nil, err
+	}
+	graph.modTime = info.ModTime()
+	graph.size = info.Size()
+	r.commitGraph = graph
+	return graph, nil
+}
+
+// Count returns the number of the commits in the commit-graph.
+func (g *CommitGraph) Count() int {
+	return g.count
+}
+
+// Contains tells if the commit is in the commit-graph.
+func (g *CommitGraph) Contains(oid *Oid) bool {
+	_, ok := g.position(oid)
+	return ok
+}
+
+// HasChangedPaths tells if the commit-graph has changed-path Bloom filters.
+func (g *CommitGraph) HasChangedPaths() bool {
+	return g.bloomIndex != nil
+}
+
+// internal functions and methods
+
+func parseCommitGraph(data []byte) (*CommitGraph, error) {
+	corrupted := func(reason string) error {
+		return MakeGitError("commit-graph is corrupted: "+reason, ErrInvalid)
+	}
+	if len(data) < commitGraphHeaderSize+GitOidRawSize || string(data[:4]) != commitGraphSignature {
+		return nil, corrupted("wrong signature")
+	}
+	if data[4] != commitGraphVersion {
+		return nil, MakeGitError(fmt.Sprintf("unsupported commit-graph version %d", data[4]), ErrInvalid)
+	}
+	if data[5] != commitGraphHashVersion {
+		return nil, MakeGitError(fmt.Sprintf("unsupported commit-graph hash version %d", data[5]), ErrInvalid)
+	}
+	if data[7] != 0 {
+		return nil, MakeGitError("commit-graph chains are not supported", ErrInvalid)
+	}
+	chunkCount := int(data[6])
+	end := len(data) - GitOidRawSize
+	if commitGraphHeaderSize+(chunkCount+1)*commitGraphChunkSize > end {
+		return nil, corrupted("truncated chunk table")
+	}
+	chunks := make(map[uint32][]byte, chunkCount)
+	for i := 0; i < chunkCount; i++ {
+		entry := data[commitGraphHeaderSize+i*commitGraphChunkSize:]
+		id := binary.BigEndian.Uint32(entry)
+		start := binary.BigEndian.Uint64(entry[4:])
+		next := binary.BigEndian.Uint64(entry[4+commitGraphChunkSize:])
+		if start > next || next > uint64(end) {
+			return nil, corrupted(fmt.Sprintf("invalid offset of chunk %08x", id))
+		}
+		chunks[id] = data[start:next]
+	}
+
+	g := &CommitGraph{
+		fanout:     chunks[commitGraphChunkFanout],
+		oids:       chunks[commitGraphChunkOids],
+		commitData: chunks[commitGraphChunkData],
+	}
+	if len(g.fanout) != 256*4 {
+		return nil, corrupted("missing fanout")
+	}
+	g.count = int(binary.BigEndian.Uint32(g.fanout[255*4:]))
+	if len(g.oids) != g.count*GitOidRawSize || len(g.commitData) != g.count*commitGraphDataSize {
+		return nil, corrupted("wrong size of the commits")
+	}
+
+	// like git, broken filters are ignored rather than failing the reads
+	bloomIndex := chunks[commitGraphChunkBloomIndex]
+	bloomData := chunks[commitGraphChunkBloomData]
+	if len(bloomIndex) == g.count*4 && len(bloomData) >= commitGraphBloomHeader {
+		g.bloom = bloomSettings{
+			version:      int(binary.BigEndian.Uint32(bloomData)),
+			numHashes:    int(binary.BigEndian.Uint32(bloomData[4:])),
+			bitsPerEntry: int(binary.BigEndian.Uint32(bloomData[8:])),
+		}
+		if (g.bloom.version == 1 || g.bloom.version == 2) && g.bloom.numHashes > 0 {
+			g.bloomIndex = bloomIndex
+			g.bloomData = bloomData[commitGraphBloomHeader:]
+		}
+	}
+	return g, nil
+}
+
+// position returns the position of the commit in the sorted ids.
+func (g *CommitGraph) position(oid *Oid) (int, bool) {
+	first := 0
+	if oid[0] > 0 {
+		first = int(binary.BigEndian.Uint32(g.fanout[(int(oid[0])-1)*4:]))
+	}
+	last := int(binary.BigEndian.Uint32(g.fanout[int(oid[0])*4:]))
+	if last > g.count {
+		last = g.count
+	}
+	for first < last {
+		middle := (first + last) / 2
+		cmp := bytes.Compare(g.oids[middle*GitOidRawSize:(middle+1)*GitOidRawSize], oid[:])
+		if cmp == 0 {
+			return middle, true
+		} else if cmp < 0 {
+			first = middle + 1
+		} else {
+			last = middle
+		}
+	}
+	return 0, false
+}
+
+// generation returns the topological level of the commit, which is larger
+// than the levels of its parents. Levels of 0 were written by old versions
+// of git and are not known.
+func (g *CommitGraph) generation(oid *Oid) (uint32, bool) {
+	position, ok := g.position(oid)
+	if !ok {
+		return 0, false
+	}
+	generation := binary.BigEndian.Uint32(g.commitData[position*commitGraphDataSize+GitOidRawSize+8:]) >> 2
+	return generation, generation != 0
+}
+
+// changedPathsFilter returns the Bloom filter of the commit, or nil if the
+// commit-graph doesn't have it.
+func (g *CommitGraph) changedPathsFilter(oid *Oid) bloomFilter {
+	if g.bloomIndex == nil {
+		return nil
+	}
+	position, ok := g.position(oid)
+	if !ok {
+		return nil
+	}
+	start := uint32(0)
+	if position > 0 {
+		start = binary.BigEndian.Uint32(g.bloomIndex[(position-1)*4:])
+	}
+	end := binary.BigEndian.Uint32(g.bloomIndex[position*4:])
+	if start > end || int(end) > len(g.bloomData) {
+		return nil
+	}
+	return bloomFilter(g.bloomData[start:end])
+}
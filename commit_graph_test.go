@@ -0,0 +1,129 @@
+package git4go
+
+import (
+	"./testutil"
+	"testing"
+)
+
+func Test_Murmur3Seeded(t *testing.T) {
+	testcases := []struct {
+		data     string
+		expected uint32
+	}{
+		{"", 0},
+		{"Hello world!", 0x627b0c2c},
+		{"The quick brown fox jumps over the lazy dog", 0x2e4ff723},
+	}
+	for _, testcase := range testcases {
+		if hash := murmur3Seeded(0, []byte(testcase.data), false); hash != testcase.expected {
+			t.Errorf("it should hash %q like git: %08x", testcase.data, hash)
+		}
+	}
+	if murmur3Seeded(0, []byte("d\xe9"), true) == murmur3Seeded(0, []byte("d\xe9"), false) {
+		t.Error("it should sign extend the bytes above 0x7f for version 1")
+	}
+}
+
+func Test_BloomFilter(t *testing.T) {
+	filter := newBloomFilter(2, defaultBloomSettings)
+	for _, path := range []string{"src", "src/main.go"} {
+		filter.add(newBloomKey(path, defaultBloomSettings))
+	}
+	if !filter.mayChange([][]bloomKey{bloomKeysForPath("src/main.go", defaultBloomSettings)}) {
+		t.Error("it should match the added path")
+	}
+	if filter.mayChange([][]bloomKey{bloomKeysForPath("doc/readme.txt", defaultBloomSettings)}) {
+		t.Error("it should not match the other path")
+	}
+	all := bloomFilter{0xff}
+	if !all.mayChange([][]bloomKey{bloomKeysForPath("doc/readme.txt", defaultBloomSettings)}) {
+		t.Error("it should match all paths for too many changes")
+	}
+}
+
+func Test_Repository_WriteCommitGraph(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	if _, err := repo.CommitGraph(); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should return ErrNotFound without the file:", err)
+	}
+	err := repo.WriteCommitGraph(&CommitGraphWriteOptions{ChangedPaths: true})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	graph, err := repo.CommitGraph()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	walk, _ := repo.Walk()
+	walk.PushGlob("*")
+	walk.PushHead()
+	count := 0
+	oid := new(Oid)
+	for walk.Next(oid) == nil {
+		if !graph.Contains(oid) {
+			t.Error("it should contain the reachable commit:", oid)
+		}
+		count++
+	}
+	if graph.Count() != count {
+		t.Error("it should contain all reachable commits:", graph.Count(), count)
+	}
+	if !graph.HasChangedPaths() {
+		t.Error("it should have the changed-path filters")
+	}
+	missing, _ := NewOid("0000000000000000000000000000000000000001")
+	if graph.Contains(missing) {
+		t.Error("it should not contain the unknown commit")
+	}
+	if repo.CacheStatistics().CommitGraphSize == 0 {
+		t.Error("it should report the size of the commit-graph")
+	}
+}
+
+func Test_RevWalk_FilterPaths(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	filtered := func(paths ...string) []string {
+		walk, _ := repo.Walk()
+		walk.Sorting(SortTime)
+		walk.PushHead()
+		walk.FilterPaths(paths...)
+		var ids []string
+		oid := new(Oid)
+		for walk.Next(oid) == nil {
+			ids = append(ids, oid.String())
+		}
+		return ids
+	}
+	paths := []string{"README", "new.txt", "branch_file.txt", "missing"}
+	expected := make(map[string][]string)
+	for _, path := range paths {
+		expected[path] = filtered(path)
+	}
+	if len(expected["README"]) != 2 || len(expected["new.txt"]) != 2 || len(expected["missing"]) != 0 {
+		t.Error("it should return the commits that change the path:", expected)
+	}
+	if len(filtered()) != 7 {
+		t.Error("it should not filter without paths")
+	}
+
+	repo.WriteCommitGraph(&CommitGraphWriteOptions{ChangedPaths: true})
+	for _, path := range paths {
+		ids := filtered(path)
+		if len(ids) != len(expected[path]) {
+			t.Error("it should return the same commits with the commit-graph:", path, ids, expected[path])
+			continue
+		}
+		for i := range ids {
+			if ids[i] != expected[path][i] {
+				t.Error("it should return the same commits with the commit-graph:", path, ids, expected[path])
+				break
+			}
+		}
+	}
+}
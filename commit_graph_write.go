@@ -0,0 +1,311 @@
+package git4go
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type CommitGraphWriteOptions struct {
+	// Changed-path Bloom filters are written, like "git commit-graph write
+	// --changed-paths"
+	ChangedPaths bool
+	// The commits that change more paths get a filter that matches all
+	// paths. 0 means 512 like git.
+	MaxChangedPaths int
+}
+
+// WriteCommitGraph writes the commit-graph of the commits that the
+// references and HEAD reach, like "git commit-graph write --reachable".
+// The file replaces the existing one; it fails in shallow repositories,
+// whose commits miss their parents.
+func (r *Repository) WriteCommitGraph(opts *CommitGraphWriteOptions) error {
+	if r.readOnly {
+		return errReadOnly("Repository.WriteCommitGraph")
+	}
+	if r.pathRepository == "" {
+		return MakeGitError("cannot write the commit-graph of an in-memory repository", ErrInvalid)
+	}
+	if opts == nil {
+		opts = &CommitGraphWriteOptions{}
+	}
+	if roots, err := r.shallowRoots(); err != nil {
+		return err
+	} else if len(roots) > 0 {
+		return MakeGitError("cannot write the commit-graph of a shallow repository", ErrInvalid)
+	}
+	commits, err := r.commitGraphCommits()
+	if err != nil {
+		return err
+	}
+	data, err := r.buildCommitGraph(commits, opts)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(r.pathCommon, GitObjectsDir, GitCommitGraphFile)
+	err = r.fs.MkdirAll(filepath.Dir(path), os.FileMode(GitObjectDirMode))
+	if err != nil {
+		return err
+	}
+	lock, err := newLockfile(r.fs, path, 0444, DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	_, err = lock.Write(data)
+	if err != nil {
+		lock.Rollback()
+		return err
+	}
+	return lock.Commit()
+}
+
+// internal functions and methods
+
+// commitGraphCommits returns the commits that the references and HEAD
+// reach, sorted by id.
+func (r *Repository) commitGraphCommits() ([]*Commit, error) {
+	var tips []*Oid
+	addTip := func(oid *Oid) {
+		object, err := r.Lookup(oid)
+		if err != nil {
+			return
+		}
+		commit, err := object.Peel(ObjectCommit)
+		if err != nil {
+			return
+		}
+		tips = append(tips, commit.Id())
+	}
+	err := r.ForEachReference(func(ref *Reference) error {
+		resolved, err := ref.Resolve()
+		if err == nil {
+			addTip(resolved.Target())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if head, err := r.LookupReference(GitHeadFile); err == nil {
+		if resolved, err := head.Resolve(); err == nil {
+			addTip(resolved.Target())
+		}
+	}
+
+	found := make(map[Oid]*Commit)
+	for len(tips) > 0 {
+		oid := tips[len(tips)-1]
+		tips = tips[:len(tips)-1]
+		if _, ok := found[*oid]; ok {
+			continue
+		}
+		commit, err := r.LookupCommit(oid)
+		if err != nil {
+			return nil, err
+		}
+		found[*oid] = commit
+		tips = append(tips, commit.Parents...)
+	}
+	commits := make([]*Commit, 0, len(found))
+	for _, commit := range found {
+		commits = append(commits, commit)
+	}
+	sort.Slice(commits, func(i, j int) bool {
+		return commits[i].Id().Cmp(commits[j].Id()) < 0
+	})
+	return commits, nil
+}
+
+func (r *Repository) buildCommitGraph(commits []*Commit, opts *CommitGraphWriteOptions) ([]byte, error) {
+	positions := make(map[Oid]uint32, len(commits))
+	for i, commit := range commits {
+		positions[*commit.Id()] = uint32(i)
+	}
+	generations := commitGraphGenerations(commits, positions)
+
+	var fanout, oids, commitData, extraEdges bytes.Buffer
+	counts := make([]uint32, 256)
+	for _, commit := range commits {
+		counts[commit.Id()[0]]++
+	}
+	total := uint32(0)
+	for _, count := range counts {
+		total += count
+		binary.Write(&fanout, binary.BigEndian, total)
+	}
+	for i, commit := range commits {
+		oids.Write(commit.Id()[:])
+		commitData.Write(commit.TreeId()[:])
+		parents := []uint32{commitGraphParentNone, commitGraphParentNone}
+		for j, parent := range commit.Parents {
+			if j < 2 {
+				parents[j] = positions[*parent]
+			}
+		}
+		if len(commit.Parents) > 2 {
+			parents[1] = commitGraphExtraEdges | uint32(extraEdges.Len()/4)
+			for j, parent := range commit.Parents[1:] {
+				edge := positions[*parent]
+				if j == len(commit.Parents)-2 {
+					edge |= commitGraphLastEdge
+				}
+				binary.Write(&extraEdges, binary.BigEndian, edge)
+			}
+		}
+		binary.Write(&commitData, binary.BigEndian, parents)
+		when := uint64(commit.Committer().When.Unix())
+		binary.Write(&commitData, binary.BigEndian, generations[i]<<2|uint32(when>>32)&3)
+		binary.Write(&commitData, binary.BigEndian, uint32(when))
+	}
+
+	chunkIds := []uint32{commitGraphChunkFanout, commitGraphChunkOids, commitGraphChunkData}
+	chunks := [][]byte{fanout.Bytes(), oids.Bytes(), commitData.Bytes()}
+	if extraEdges.Len() > 0 {
+		chunkIds = append(chunkIds, commitGraphChunkExtraEdges)
+		chunks = append(chunks, extraEdges.Bytes())
+	}
+	if opts.ChangedPaths {
+		bloomIndex, bloomData, err := r.buildBloomFilters(commits, opts)
+		if err != nil {
+			return nil, err
+		}
+		chunkIds = append(chunkIds, commitGraphChunkBloomIndex, commitGraphChunkBloomData)
+		chunks = append(chunks, bloomIndex, bloomData)
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString(commitGraphSignature)
+	buffer.Write([]byte{commitGraphVersion, commitGraphHashVersion, byte(len(chunks)), 0})
+	offset := uint64(commitGraphHeaderSize + (len(chunks)+1)*commitGraphChunkSize)
+	for i, chunk := range chunks {
+		binary.Write(&buffer, binary.BigEndian, chunkIds[i])
+		binary.Write(&buffer, binary.BigEndian, offset)
+		offset += uint64(len(chunk))
+	}
+	binary.Write(&buffer, binary.BigEndian, uint32(0))
+	binary.Write(&buffer, binary.BigEndian, offset)
+	for _, chunk := range chunks {
+		buffer.Write(chunk)
+	}
+	checksum := sha1.Sum(buffer.Bytes())
+	buffer.Write(checksum[:])
+	return buffer.Bytes(), nil
+}
+
+// commitGraphGenerations computes the topological levels of the commits:
+// 1 for root commits and one more than the highest parent for the others.
+func commitGraphGenerations(commits []*Commit, positions map[Oid]uint32) []uint32 {
+	generations := make([]uint32, len(commits))
+	for i := range commits {
+		if generations[i] != 0 {
+			continue
+		}
+		stack := []int{i}
+		for len(stack) > 0 {
+			current := stack[len(stack)-1]
+			generation := uint32(1)
+			pending := false
+			for _, parent := range commits[current].Parents {
+				position := positions[*parent]
+				if generations[position] == 0 {
+					stack = append(stack, int(position))
+					pending = true
+				} else if generations[position] >= generation {
+					generation = generations[position] + 1
+				}
+			}
+			if pending {
+				continue
+			}
+			if generation > commitGraphMaxGeneration {
+				generation = commitGraphMaxGeneration
+			}
+			generations[current] = generation
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return generations
+}
+
+// buildBloomFilters returns the BIDX and BDAT chunks. The filter of a
+// commit has the paths that differ from its first parent and their leading
+// directories.
+func (r *Repository) buildBloomFilters(commits []*Commit, opts *CommitGraphWriteOptions) ([]byte, []byte, error) {
+	maxChanges := opts.MaxChangedPaths
+	if maxChanges <= 0 {
+		maxChanges = bloomFilterMaxChangedPaths
+	}
+	settings := defaultBloomSettings
+	var index, data bytes.Buffer
+	binary.Write(&data, binary.BigEndian, []uint32{
+		uint32(settings.version), uint32(settings.numHashes), uint32(settings.bitsPerEntry),
+	})
+	for _, commit := range commits {
+		paths, err := r.changedPaths(commit, maxChanges)
+		if err != nil {
+			return nil, nil, err
+		}
+		var filter bloomFilter
+		if paths == nil {
+			// too many changes
+			filter = bloomFilter{0xff}
+		} else {
+			filter = newBloomFilter(len(paths), settings)
+			for path := range paths {
+				filter.add(newBloomKey(path, settings))
+			}
+		}
+		data.Write(filter)
+		binary.Write(&index, binary.BigEndian, uint32(data.Len()-commitGraphBloomHeader))
+	}
+	return index.Bytes(), data.Bytes(), nil
+}
+
+// changedPaths returns the paths that the commit changes compared to its
+// first parent with their leading directories, or nil if more than
+// maxChanges files are changed.
+func (r *Repository) changedPaths(commit *Commit, maxChanges int) (map[string]bool, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	var parentTree *Tree
+	if commit.ParentCount() > 0 {
+		parentTree, err = r.commitTree(commit.ParentId(0))
+		if err != nil {
+			return nil, err
+		}
+	}
+	iter, err := r.NewTreeDiffIterator(parentTree, tree)
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[string]bool)
+	changes := 0
+	for {
+		entry, err := iter.Next()
+		if IsErrorCode(err, ErrIterOver) {
+			return paths, nil
+		} else if err != nil {
+			return nil, err
+		}
+		changes++
+		if changes > maxChanges {
+			return nil, nil
+		}
+		path := entry.Path
+		for {
+			paths[path] = true
+			slash := strings.LastIndexByte(path, '/')
+			if slash == -1 {
+				break
+			}
+			path = path[:slash]
+		}
+	}
+}
@@ -2,7 +2,13 @@ package git4go
 
 import (
 	"./testutil"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 /*
@@ -49,3 +55,129 @@ func Test_LookupCommit(t *testing.T) {
 		}
 	}
 }
+
+const testSignature = "-----BEGIN PGP SIGNATURE-----\n\nabc\n-----END PGP SIGNATURE-----\n"
+
+func Test_CreateCommit(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	head, _ := repo.Head()
+	parent, _ := repo.LookupCommit(head.Target())
+	tree, _ := parent.Tree()
+	sig := &Signature{"Test User", "test@example.com", time.Unix(1400000000, 0).In(time.FixedZone("", -7*60*60))}
+
+	var payload string
+	oid, err := repo.CreateCommitExtended("HEAD", sig, sig, "signed commit\n", tree, []*Commit{parent}, &CreateCommitOpts{
+		SigningCallback: func(data string) (string, string, error) {
+			payload = data
+			return testSignature, "", nil
+		},
+	})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if !strings.HasPrefix(payload, "tree "+tree.Id().String()) || !strings.HasSuffix(payload, "\n\nsigned commit\n") {
+		t.Error("the payload should be the unsigned commit:", payload)
+	}
+	if !strings.Contains(payload, "committer Test User <test@example.com> 1400000000 -0700\n") {
+		t.Error("it should write the committer:", payload)
+	}
+	odb, _ := repo.Odb()
+	obj, _ := odb.Read(oid)
+	signed := "gpgsig -----BEGIN PGP SIGNATURE-----\n \n abc\n -----END PGP SIGNATURE-----\n\nsigned commit\n"
+	if !strings.HasSuffix(string(obj.Data), signed) {
+		t.Error("it should write the signature as a header:", string(obj.Data))
+	}
+	commit, err := repo.LookupCommit(oid)
+	if err != nil || commit.Message() != "signed commit\n" || !commit.ParentId(0).Equal(parent.Id()) {
+		t.Error("it should create a readable commit:", err)
+	}
+	head, _ = repo.Head()
+	if !head.Target().Equal(oid) {
+		t.Error("it should update the branch of HEAD")
+	}
+
+	_, err = repo.CreateCommit("HEAD", sig, sig, "stale\n", tree, parent)
+	if !IsErrorCode(err, ErrModified) {
+		t.Error("it should refuse the commit whose parent is not the tip:", err)
+	}
+	_, err = repo.CreateCommitExtended("", sig, sig, "failed\n", tree, nil, &CreateCommitOpts{
+		SigningCallback: func(data string) (string, string, error) {
+			return "", "", errors.New("no key")
+		},
+	})
+	if err == nil || err.Error() != "no key" {
+		t.Error("it should return the error of the callback:", err)
+	}
+}
+
+// racingFileSystem runs the callback once before the lock file whose name
+// ends with lockPath is created, like another writer that updates the file just before.
+type racingFileSystem struct {
+	FileSystem
+	lockPath string
+	callback func()
+}
+
+func (r *racingFileSystem) OpenFile(name string, flag int, perm fs.FileMode) (WritableFile, error) {
+	if r.callback != nil && strings.HasSuffix(name, r.lockPath) {
+		r.callback()
+		r.callback = nil
+	}
+	return r.FileSystem.OpenFile(name, flag, perm)
+}
+
+func Test_CreateCommit_RefMoved(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	refPath := filepath.Join("test_resources/testrepo.git", "refs", "heads", "master")
+	other := "e90810b8df3e80c413d903f631643c716887138d\n"
+	fsys := &racingFileSystem{FileSystem: OSFileSystem, lockPath: filepath.Join("refs", "heads", "master") + GitLockFileSuffix}
+	repo, _ := OpenRepositoryWithFileSystem("test_resources/testrepo.git", fsys, GIT_REPOSITORY_OPEN_NO_SEARCH)
+	head, _ := repo.Head()
+	parent, _ := repo.LookupCommit(head.Target())
+	tree, _ := parent.Tree()
+	sig := &Signature{"Test User", "test@example.com", time.Unix(1400000000, 0)}
+
+	fsys.callback = func() {
+		ioutil.WriteFile(refPath, []byte(other), 0666)
+	}
+	_, err := repo.CreateCommit("HEAD", sig, sig, "racing commit\n", tree, parent)
+	if !IsErrorCode(err, ErrModified) {
+		t.Error("it should refuse the commit when the branch moves before it is locked:", err)
+	}
+	if content, _ := ioutil.ReadFile(refPath); string(content) != other {
+		t.Error("it should keep the update of the other writer:", string(content))
+	}
+	if _, err := repo.CreateCommit("HEAD", sig, sig, "next commit\n", tree, parent); !IsErrorCode(err, ErrModified) {
+		t.Error("it should release the lock of the branch:", err)
+	}
+}
+
+func Test_LookupCommit_MalformedIdent(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	odb, _ := repo.Odb()
+	header := "tree 181037049a54a1eb5fab404658a3a250b44335d7\n" +
+		"author Broken author@example.com 1225475778\n" +
+		"committer C O Mitter <committer@example.com> 1225475778 +2500\n"
+	oid, _ := odb.Write([]byte(header+"\nmessage\n"), ObjectCommit)
+	commit, err := repo.LookupCommit(oid)
+	if err != nil {
+		t.Fatal("it should read the commit with broken idents:", err)
+	}
+	if commit.Author().Name != "Broken author@example.com 1225475778" || commit.Author().Email != "" {
+		t.Error("it should use the line without brackets as the name:", commit.Author())
+	}
+	if _, zone := commit.Committer().When.Zone(); commit.Committer().When.Unix() != 1225475778 || zone != 0 {
+		t.Error("it should read the invalid timezone as UTC:", commit.Committer().When)
+	}
+	if commit.RawHeader() != header || commit.Message() != "message\n" {
+		t.Errorf("it should keep the raw header: %q", commit.RawHeader())
+	}
+}
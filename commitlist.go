@@ -13,6 +13,9 @@ const (
 	Stale   CommitListFlag = 1 << iota
 )
 
+// the generation of the commits that are not in the commit-graph
+const generationInfinity = 0xffffffff
+
 type commitListNode struct {
 	oid           *Oid
 	time          uint64
@@ -22,6 +25,10 @@ type commitListNode struct {
 	parsed        bool
 	inDegree      int
 	flags         CommitListFlag
+	// a hidden parent of a walked commit, see RevWalk.Boundary
+	boundary bool
+	// the generation number of the commit-graph, see RevWalk.generations
+	generation uint32
 
 	parents []*commitListNode
 }
@@ -70,3 +77,19 @@ func (q commitListNodes) insertByTime(commit *commitListNode) commitListNodes {
 	sort.Sort(result)
 	return result
 }
+
+// insertByGeneration keeps the queue ordered by generation and then by
+// time, newest first. Ancestors have lower generations, so no commit is
+// taken before a descendant that is in the queue, whatever the clocks say.
+func (q commitListNodes) insertByGeneration(commit *commitListNode) commitListNodes {
+	i := sort.Search(len(q), func(i int) bool {
+		if q[i].generation != commit.generation {
+			return q[i].generation < commit.generation
+		}
+		return q[i].time < commit.time
+	})
+	q = append(q, nil)
+	copy(q[i+1:], q[i:])
+	q[i] = commit
+	return q
+}
@@ -5,6 +5,7 @@ package git4go
 import (
 	"os"
 	"path/filepath"
+	"syscall"
 )
 
 func guessSystemFile() []string {
@@ -33,6 +34,27 @@ func guessTemplateFile() []string {
 	return []string{"/usr/share/git-core/templates"}
 }
 
+func longPath(path string) string {
+	return path
+}
+
+func isSymlinkMode(mode os.FileMode) bool {
+	return mode&os.ModeSymlink != 0
+}
+
+func readLink(path string) (string, error) {
+	return os.Readlink(path)
+}
+
+// fileDevIno returns the device and the inode of the file for the stat data
+// of the index. Other file systems than the os package have none.
+func fileDevIno(stat os.FileInfo) (uint32, uint32) {
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
+		return uint32(sys.Dev), uint32(sys.Ino)
+	}
+	return 0, 0
+}
+
 var defaultBoolConfig map[string]bool = map[string]bool{
 	"core.symlinks":          true,
 	"core.ignorecase":        false,
@@ -40,7 +62,7 @@ var defaultBoolConfig map[string]bool = map[string]bool{
 	"core.ignorestat":        false,
 	"core.trustctime":        true,
 	"core.abbrev":            true,
-	"core.precomposeunicode": true,
+	"core.precomposeunicode": false,
 	"core.logallrefupdates":  true,
 	"core.protectHFS":        false,
 	"core.protectNTFS":       false,
@@ -48,5 +70,8 @@ var defaultBoolConfig map[string]bool = map[string]bool{
 
 var defaultStringConfig map[string]string = map[string]string{
 	"core.autocrlf": "false",
-	"core.eol":      "crlf",
+	"core.eol":      "native",
 }
+
+// the line ending of core.eol=native
+const nativeEolIsCrlf = false
@@ -2,36 +2,112 @@
 
 package git4go
 
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Paths that are longer than MAX_PATH need the "\\?\" prefix to be opened
+// with the wide character APIs.
+const windowsMaxPath = 260
+
 func guessSystemFile() []string {
-	return []string{}
+	var result []string
+	for _, env := range []string{"PROGRAMFILES", "PROGRAMFILES(X86)"} {
+		dir := os.Getenv(env)
+		if dir != "" {
+			result = append(result, filepath.Join(dir, "Git", "etc"), filepath.Join(dir, "Git", "mingw64", "etc"))
+		}
+	}
+	return result
 }
 
 func guessGlobalFile() []string {
+	if home := os.Getenv("HOME"); home != "" {
+		return []string{home}
+	}
+	if home := os.Getenv("USERPROFILE"); home != "" {
+		return []string{home}
+	}
+	drive, path := os.Getenv("HOMEDRIVE"), os.Getenv("HOMEPATH")
+	if drive != "" && path != "" {
+		return []string{drive + path}
+	}
 	return []string{}
 }
 
 func guessXDGFile() []string {
+	if env := os.Getenv("XDG_CONFIG_HOME"); env != "" {
+		return []string{filepath.Join(env, "git")}
+	}
+	for _, home := range guessGlobalFile() {
+		return []string{filepath.Join(home, ".config", "git")}
+	}
 	return []string{}
 }
 
 func guessTemplateFile() []string {
-	return []string{}
+	var result []string
+	for _, dir := range guessSystemFile() {
+		result = append(result, filepath.Join(filepath.Dir(dir), "share", "git-core", "templates"))
+	}
+	return result
+}
+
+// longPath converts the path to the extended-length form when it is too
+// long for the Win32 APIs.
+func longPath(path string) string {
+	if len(path) < windowsMaxPath || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(absPath, `\\`) {
+		return `\\?\UNC\` + absPath[2:]
+	}
+	return `\\?\` + absPath
+}
+
+// isSymlinkMode treats junctions and other reparse points as symbolic
+// links like git for windows does.
+func isSymlinkMode(mode os.FileMode) bool {
+	return mode&(os.ModeSymlink|os.ModeIrregular) != 0
+}
+
+func readLink(path string) (string, error) {
+	target, err := os.Readlink(longPath(path))
+	if err != nil {
+		return "", err
+	}
+	target = strings.TrimPrefix(target, `\??\`)
+	return filepath.ToSlash(target), nil
+}
+
+// fileDevIno returns the device and the inode of the file for the stat data
+// of the index. Windows has none, like with git for Windows.
+func fileDevIno(stat os.FileInfo) (uint32, uint32) {
+	return 0, 0
 }
 
 var defaultBoolConfig map[string]bool = map[string]bool{
-	"core.symlinks":          true,
+	"core.symlinks":          false,
 	"core.ignorecase":        true,
-	"core.filemode":          true,
+	"core.filemode":          false,
 	"core.ignorestat":        false,
 	"core.trustctime":        true,
 	"core.abbrev":            true,
-	"core.precomposeunicode": true,
+	"core.precomposeunicode": false,
 	"core.logallrefupdates":  true,
 	"core.protectHFS":        false,
 	"core.protectNTFS":       true,
 }
 
 var defaultStringConfig map[string]string = map[string]string{
-	"core.autocrlf": "false",
-	"core.eol":      "crlf",
+	"core.eol":      "native",
 }
+
+// the line ending of core.eol=native
+const nativeEolIsCrlf = true
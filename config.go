@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 	"strings"
 	"strconv"
+	"sync"
+	"time"
 )
 
 type ConfigLevel int
@@ -28,46 +30,34 @@ const (
 
 // todo: implement cache
 
-/*type ConfigEntry struct {
+// ConfigEntry is a variable of a config file. Name is in the git syntax,
+// e.g. "remote.origin.url".
+type ConfigEntry struct {
 	Name  string
 	Value string
 	Level ConfigLevel
-}*/
+}
 
 // Repository method related to Config
 
 func (repo *Repository) Config() *Config {
+	repo.configLock.Lock()
+	defer repo.configLock.Unlock()
+
 	if repo.config == nil {
 		config, _ := NewConfig()
-		path := filepath.Join(repo.pathRepository, ConfigFileNameInrepo)
-		_, err := os.Stat(path)
+		path := filepath.Join(repo.pathCommon, ConfigFileNameInrepo)
+		_, err := repo.fs.Stat(path)
 		if !os.IsNotExist(err) {
-			err = config.AddFile(path, ConfigLevelLocal, false)
-			if err != nil {
-				return nil
-			}
-		}
-		path, err = ConfigFindGlobal()
-		if err == nil {
-			err = config.AddFile(path, ConfigLevelGlobal, false)
+			err = config.addFile(repo.fs, path, ConfigLevelLocal, false)
 			if err != nil {
 				return nil
 			}
 		}
-		path, err = ConfigFindXDG()
-		if err == nil {
-			err = config.AddFile(path, ConfigLevelXDG, false)
-			if err != nil {
-				return nil
-			}
-		}
-		path, err = ConfigFindSystem()
-		if err == nil {
-			err = config.AddFile(path, ConfigLevelSystem, false)
-			if err != nil {
-				return nil
-			}
+		if !repo.hermetic && config.addGlobalFiles() != nil {
+			return nil
 		}
+		config.readOnly = repo.readOnly
 		repo.config = config
 	}
 	return repo.config
@@ -76,13 +66,20 @@ func (repo *Repository) Config() *Config {
 // Config type and its methods
 
 type configFile struct {
+	fs    FileSystem
+	path  string
 	force bool
 	level ConfigLevel
 	file  *goconfig.ConfigFile
+	// the file that was read, for Refresh
+	modTime time.Time
+	size    int64
 }
 
 type Config struct {
-	files []*configFile
+	lock     sync.RWMutex
+	files    []*configFile
+	readOnly bool
 }
 
 func NewConfig() (*Config, error) {
@@ -91,23 +88,122 @@ func NewConfig() (*Config, error) {
 }
 
 func (c *Config) AddFile(path string, level ConfigLevel, force bool) error {
-	file, err := goconfig.LoadConfigFile(path)
+	return c.addFile(OSFileSystem, path, level, force)
+}
+
+func (c *Config) addFile(fsys FileSystem, path string, level ConfigLevel, force bool) error {
+	entry, err := loadConfigFile(fsys, path, level, force)
 	if err != nil {
 		return err
 	}
-	entry := &configFile{
-		force: force,
+	c.addEntry(entry)
+	return nil
+}
+
+func loadConfigFile(fsys FileSystem, path string, level ConfigLevel, force bool) (*configFile, error) {
+	info, err := fsys.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := goconfig.LoadFromData(data)
+	if err != nil {
+		return nil, err
+	}
+	return &configFile{
+		fs:      fsys,
+		path:    path,
+		force:   force,
+		level:   level,
+		file:    file,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}, nil
+}
+
+// rewrite sets or deletes the variable in the file on the disk. The file
+// is read again under the lock, so the changes of other writers and the
+// formatting of the other lines are kept.
+func (f *configFile) rewrite(name string, value *string) (bool, error) {
+	if f.path == "" {
+		return false, nil
+	}
+	lock, err := newLockfile(f.fs, f.path, 0666, DefaultLockTimeout)
+	if err != nil {
+		return false, err
+	}
+	data, err := f.fs.ReadFile(f.path)
+	if err != nil && !os.IsNotExist(err) {
+		lock.Rollback()
+		return false, err
+	}
+	data, found := rewriteConfigData(data, name, value)
+	if value == nil && !found {
+		lock.Rollback()
+		return false, nil
+	}
+	_, err = lock.Write(data)
+	if err != nil {
+		lock.Rollback()
+		return false, err
+	}
+	return found, lock.Commit()
+}
+
+// addGlobalFiles adds the global, XDG and system config files that exist.
+func (c *Config) addGlobalFiles() error {
+	if path, err := ConfigFindGlobal(); err == nil {
+		if err := c.AddFile(path, ConfigLevelGlobal, false); err != nil {
+			return err
+		}
+	}
+	if path, err := ConfigFindXDG(); err == nil {
+		if err := c.AddFile(path, ConfigLevelXDG, false); err != nil {
+			return err
+		}
+	}
+	if path, err := ConfigFindSystem(); err == nil {
+		if err := c.AddFile(path, ConfigLevelSystem, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addData adds the config that is not backed by a file. SetString updates
+// it in memory.
+func (c *Config) addData(data []byte, level ConfigLevel) error {
+	file, err := goconfig.LoadFromData(data)
+	if err != nil {
+		return err
+	}
+	c.addEntry(&configFile{
 		level: level,
 		file:  file,
-	}
-	c.files = append(c.files, entry)
+	})
 	return nil
 }
 
+func (c *Config) addEntry(entry *configFile) {
+	c.lock.Lock()
+	c.files = append(c.files, entry)
+	c.lock.Unlock()
+}
+
+func (c *Config) fileList() []*configFile {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.files
+}
+
 func (c *Config) LookupInt32(name string) (int32, error) {
-	keys := strings.SplitN(name, ".", 2)
-	for _, file := range c.files {
-		value, err := file.file.Int(keys[0], keys[1])
+	for _, file := range c.fileList() {
+		section, key := file.resolve(name)
+		value, err := file.file.Int(section, key)
 		if err == nil {
 			return int32(value), nil
 		}
@@ -116,9 +212,9 @@ func (c *Config) LookupInt32(name string) (int32, error) {
 }
 
 func (c *Config) LookupInt64(name string) (int64, error) {
-	keys := strings.SplitN(name, ".", 2)
-	for _, file := range c.files {
-		value, err := file.file.Int64(keys[0], keys[1])
+	for _, file := range c.fileList() {
+		section, key := file.resolve(name)
+		value, err := file.file.Int64(section, key)
 		if err == nil {
 			return value, nil
 		}
@@ -127,9 +223,9 @@ func (c *Config) LookupInt64(name string) (int64, error) {
 }
 
 func (c *Config) LookupString(name string) (string, error) {
-	keys := strings.SplitN(name, ".", 2)
-	for _, file := range c.files {
-		value, err := file.file.GetValue(keys[0], keys[1])
+	for _, file := range c.fileList() {
+		section, key := file.resolve(name)
+		value, err := file.file.GetValue(section, key)
 		if err == nil {
 			return value, nil
 		}
@@ -142,17 +238,18 @@ func (c *Config) LookupStringWithDefaultValue(name string) (string, error) {
 	if err == nil {
 		return result, nil
 	}
-	result, ok := defaultStringConfig[name]
-	if ok {
-		return result, nil
+	for defaultName, result := range defaultStringConfig {
+		if configNameEqual(defaultName, name) {
+			return result, nil
+		}
 	}
 	return "", err
 }
 
 func (c *Config) LookupBool(name string) (bool, error) {
-	keys := strings.SplitN(name, ".", 2)
-	for _, file := range c.files {
-		value, err := file.file.Bool(keys[0], keys[1])
+	for _, file := range c.fileList() {
+		section, key := file.resolve(name)
+		value, err := file.file.Bool(section, key)
 		if err == nil {
 			return value, nil
 		}
@@ -165,23 +262,48 @@ func (c *Config) LookupBooleanWithDefaultValue(name string) (bool, error) {
 	if err == nil {
 		return result, nil
 	}
-	result, ok := defaultBoolConfig[name]
-	if ok {
-		return result, nil
+	for defaultName, result := range defaultBoolConfig {
+		if configNameEqual(defaultName, name) {
+			return result, nil
+		}
 	}
 	return false, err
 }
 
 func (c *Config) SetString(name, value string) (err error) {
-	if len(c.files) > 0 && c.files[0].level == ConfigLevelLocal {
-		file := c.files[0].file
-		keys := strings.SplitN(name, ".", 2)
-		file.SetValue(keys[0], keys[1], value)
-		path, err := ConfigFindGlobal()
+	if c.readOnly {
+		return errReadOnly("Config.SetString")
+	}
+	files := c.fileList()
+	if len(files) > 0 && files[0].level == ConfigLevelLocal {
+		_, err = files[0].rewrite(name, &value)
 		if err != nil {
 			return err
 		}
-		goconfig.SaveConfigFile(file, path)
+		section, key := files[0].resolve(name)
+		files[0].file.SetValue(section, key, value)
+	}
+	return nil
+}
+
+// Delete removes the variable from the local config file. It fails with
+// ErrNotFound if the file does not have it.
+func (c *Config) Delete(name string) error {
+	if c.readOnly {
+		return errReadOnly("Config.Delete")
+	}
+	files := c.fileList()
+	if len(files) == 0 || files[0].level != ConfigLevelLocal {
+		return MakeGitError(fmt.Sprintf("Config value '%s' was not found", name), ErrNotFound)
+	}
+	found, err := files[0].rewrite(name, nil)
+	if err != nil {
+		return err
+	}
+	section, key := files[0].resolve(name)
+	deleted := files[0].file.DeleteKey(section, key)
+	if !deleted && !found {
+		return MakeGitError(fmt.Sprintf("Config value '%s' was not found", name), ErrNotFound)
 	}
 	return nil
 }
@@ -202,6 +324,98 @@ func (c *Config) SetBool(name string, value bool) (err error) {
 	}
 }
 
+// subsections returns the names of the subsections of the section, like
+// the remote names for "remote", in the order of the config files.
+func (c *Config) subsections(section string) []string {
+	prefix := section + " \""
+	seen := make(map[string]bool)
+	var names []string
+	for _, file := range c.fileList() {
+		for _, name := range file.file.GetSectionList() {
+			if len(name) <= len(prefix) || !strings.EqualFold(name[:len(prefix)], prefix) || !strings.HasSuffix(name, "\"") {
+				continue
+			}
+			subsection := name[len(prefix) : len(name)-1]
+			if !seen[subsection] {
+				seen[subsection] = true
+				names = append(names, subsection)
+			}
+		}
+	}
+	return names
+}
+
+// parseConfigBool parses a boolean value like git: "true", "yes", "on" and
+// "1" are true, and "false", "no", "off", "0" and "" are false.
+func parseConfigBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0", "":
+		return false, nil
+	}
+	return false, MakeGitError(fmt.Sprintf("invalid boolean config value '%s'", value), ErrInvalid)
+}
+
+// resolve returns the goconfig section and key that the file stores the
+// variable under. Section and key names are case-insensitive like in git,
+// so "core.ignorecase" finds "ignoreCase" in [Core]; subsection names are
+// not.
+func (f *configFile) resolve(name string) (string, string) {
+	section, key := splitConfigName(name)
+	if _, err := f.file.GetValue(section, key); err == nil {
+		return section, key
+	}
+	for _, candidate := range f.file.GetSectionList() {
+		if !configSectionEqual(candidate, section) {
+			continue
+		}
+		for _, candidateKey := range f.file.GetKeyList(candidate) {
+			if strings.EqualFold(candidateKey, key) {
+				return candidate, candidateKey
+			}
+		}
+	}
+	return section, key
+}
+
+// configSectionEqual compares goconfig sections like `remote "origin"`:
+// the section name ignores case and the subsection does not.
+func configSectionEqual(a, b string) bool {
+	aName, aSubsection := a, ""
+	if space := strings.IndexByte(a, ' '); space != -1 {
+		aName, aSubsection = a[:space], a[space:]
+	}
+	bName, bSubsection := b, ""
+	if space := strings.IndexByte(b, ' '); space != -1 {
+		bName, bSubsection = b[:space], b[space:]
+	}
+	return strings.EqualFold(aName, bName) && aSubsection == bSubsection
+}
+
+// configNameEqual compares variable names like "remote.origin.url" in the
+// same way.
+func configNameEqual(a, b string) bool {
+	aSection, aKey := splitConfigName(a)
+	bSection, bKey := splitConfigName(b)
+	return configSectionEqual(aSection, bSection) && strings.EqualFold(aKey, bKey)
+}
+
+// splitConfigName converts the variable name to the goconfig section and
+// key. Subsections are kept in the git syntax, so "remote.origin.url" is
+// the key "url" in the section `remote "origin"`.
+func splitConfigName(name string) (string, string) {
+	first := strings.IndexByte(name, '.')
+	last := strings.LastIndexByte(name, '.')
+	if first == -1 {
+		return name, ""
+	}
+	if first == last {
+		return name[:first], name[first+1:]
+	}
+	return fmt.Sprintf("%s \"%s\"", name[:first], name[first+1:last]), name[last+1:]
+}
+
 func ConfigFindGlobal() (string, error) {
 	return findInDirList(ConfigFileNameGlobal, "global")
 }
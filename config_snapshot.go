@@ -0,0 +1,208 @@
+package git4go
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Unknwon/goconfig"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ConfigChange is a variable whose value was changed. Old is nil for a new
+// variable and New is nil for a removed one.
+type ConfigChange struct {
+	Name string
+	Old  *ConfigEntry
+	New  *ConfigEntry
+}
+
+// Entries returns the variables of all files. The files of the higher
+// levels come first, so the first entry of a name is the value that the
+// Lookup methods return.
+func (c *Config) Entries() []*ConfigEntry {
+	var entries []*ConfigEntry
+	for _, file := range c.fileList() {
+		entries = append(entries, file.entries()...)
+	}
+	return entries
+}
+
+// OpenLevel returns a config with the files of one level only, like
+// git_config_open_level of libgit2. ConfigLevelHighest selects the highest
+// level that has a file. The files are shared, so SetString on the local
+// level writes the repository config.
+func (c *Config) OpenLevel(level ConfigLevel) (*Config, error) {
+	files := c.fileList()
+	if level == ConfigLevelHighest {
+		for _, file := range files {
+			if file.level > level {
+				level = file.level
+			}
+		}
+	}
+	result := &Config{readOnly: c.readOnly}
+	for _, file := range files {
+		if file.level == level {
+			result.files = append(result.files, file)
+		}
+	}
+	if len(result.files) == 0 {
+		return nil, MakeGitError(fmt.Sprintf("no config file exists for the level %d", level), ErrNotFound)
+	}
+	return result, nil
+}
+
+// Snapshot returns a read-only copy of the current values. It is not
+// changed by Refresh or SetString, so a long-running process can keep the
+// snapshot that it used and compare it with DiffConfig later.
+func (c *Config) Snapshot() (*Config, error) {
+	result := &Config{readOnly: true}
+	for _, file := range c.fileList() {
+		var buffer bytes.Buffer
+		err := goconfig.SaveConfigData(file.file, &buffer)
+		if err != nil {
+			return nil, err
+		}
+		copied, err := goconfig.LoadFromData(buffer.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		result.files = append(result.files, &configFile{
+			level: file.level,
+			file:  copied,
+		})
+	}
+	return result, nil
+}
+
+// Refresh reads the config files again that were changed on the disk since
+// they were read, e.g. when another process added a remote, and returns
+// the variables whose values are different now. A removed file is read as
+// an empty one. Long-running servers can call Refresh on Repository.Config
+// instead of opening the repository again.
+func (c *Config) Refresh() ([]*ConfigChange, error) {
+	files := c.fileList()
+	refreshed := make([]*configFile, len(files))
+	changed := false
+	for i, file := range files {
+		refreshed[i] = file
+		if file.path == "" {
+			continue
+		}
+		info, err := file.fs.Stat(file.path)
+		if os.IsNotExist(err) {
+			if len(file.file.GetSectionList()) == 0 {
+				continue
+			}
+			empty, err := goconfig.LoadFromData(nil)
+			if err != nil {
+				return nil, err
+			}
+			refreshed[i] = &configFile{fs: file.fs, path: file.path, force: file.force, level: file.level, file: empty}
+			changed = true
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if info.ModTime().Equal(file.modTime) && info.Size() == file.size {
+			continue
+		}
+		reloaded, err := loadConfigFile(file.fs, file.path, file.level, file.force)
+		if err != nil {
+			return nil, err
+		}
+		refreshed[i] = reloaded
+		changed = true
+	}
+	if !changed {
+		return nil, nil
+	}
+
+	c.lock.Lock()
+	if len(c.files) != len(files) {
+		// a file was added meanwhile
+		refreshed = append(refreshed, c.files[len(files):]...)
+	}
+	c.files = refreshed
+	c.lock.Unlock()
+	return diffConfigFiles(files, refreshed), nil
+}
+
+// DiffConfig compares the values that the Lookup methods return from the
+// two configs, e.g. a Snapshot and the current config. The changes are
+// sorted by name.
+func DiffConfig(oldConfig, newConfig *Config) []*ConfigChange {
+	return diffConfigFiles(oldConfig.fileList(), newConfig.fileList())
+}
+
+// internal functions and methods
+
+func (f *configFile) entries() []*ConfigEntry {
+	var entries []*ConfigEntry
+	for _, section := range f.file.GetSectionList() {
+		if section == goconfig.DEFAULT_SECTION {
+			// keys before the first section, which git does not allow
+			continue
+		}
+		prefix := configSectionName(section)
+		for _, key := range f.file.GetKeyList(section) {
+			value, err := f.file.GetValue(section, key)
+			if err != nil {
+				continue
+			}
+			entries = append(entries, &ConfigEntry{
+				Name:  prefix + "." + key,
+				Value: value,
+				Level: f.level,
+			})
+		}
+	}
+	return entries
+}
+
+// configSectionName converts the goconfig section back to the git syntax,
+// the reverse of splitConfigName.
+func configSectionName(section string) string {
+	space := strings.IndexByte(section, ' ')
+	if space == -1 || !strings.HasSuffix(section, "\"") || section[space+1] != '"' {
+		return section
+	}
+	return section[:space] + "." + section[space+2:len(section)-1]
+}
+
+// effectiveConfigEntries returns the entries that the Lookup methods find.
+func effectiveConfigEntries(files []*configFile) map[string]*ConfigEntry {
+	result := make(map[string]*ConfigEntry)
+	for _, file := range files {
+		for _, entry := range file.entries() {
+			if _, ok := result[entry.Name]; !ok {
+				result[entry.Name] = entry
+			}
+		}
+	}
+	return result
+}
+
+func diffConfigFiles(oldFiles, newFiles []*configFile) []*ConfigChange {
+	oldEntries := effectiveConfigEntries(oldFiles)
+	newEntries := effectiveConfigEntries(newFiles)
+	var changes []*ConfigChange
+	for name, oldEntry := range oldEntries {
+		newEntry, ok := newEntries[name]
+		if !ok {
+			changes = append(changes, &ConfigChange{Name: name, Old: oldEntry})
+		} else if oldEntry.Value != newEntry.Value {
+			changes = append(changes, &ConfigChange{Name: name, Old: oldEntry, New: newEntry})
+		}
+	}
+	for name, newEntry := range newEntries {
+		if _, ok := oldEntries[name]; !ok {
+			changes = append(changes, &ConfigChange{Name: name, New: newEntry})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
@@ -2,6 +2,9 @@ package git4go
 
 import (
 	"./testutil"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -36,3 +39,174 @@ func Test_ReadConfig(t *testing.T) {
 		t.Error("It should return dotGitOnly", err, strValue)
 	}
 }
+
+func Test_WriteConfig_Locked(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/empty_standard_repo/")
+	defer testutil.CleanupWorkspace()
+
+	path := "test_resources/empty_standard_repo/.git/config"
+	config, _ := NewConfig()
+	config.AddFile(path, ConfigLevelLocal, false)
+
+	lock, _ := NewLockfile(path, 0666, 0)
+	timeout := DefaultLockTimeout
+	DefaultLockTimeout = 0
+	defer func() {
+		DefaultLockTimeout = timeout
+	}()
+	err := config.SetString("core.editor", "vi")
+	if !IsErrorCode(err, ErrLocked) {
+		t.Error("it should fail while config is locked:", err)
+	}
+	lock.Rollback()
+
+	err = config.SetString("core.editor", "vi")
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	config2, _ := NewConfig()
+	config2.AddFile(path, ConfigLevelLocal, false)
+	value, err := config2.LookupString("core.editor")
+	if err != nil || value != "vi" {
+		t.Error("it should write value to config file:", err, value)
+	}
+}
+
+func Test_Config_Refresh(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_config")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	config := repo.Config()
+	snapshot, err := config.Snapshot()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	changes, err := config.Refresh()
+	if err != nil || len(changes) != 0 {
+		t.Error("it should not find changes in the file that is not changed:", changes, err)
+	}
+
+	path := filepath.Join(repo.Path(), "config")
+	data, _ := ioutil.ReadFile(path)
+	data = append(data, "[remote \"origin\"]\n\turl = https://example.com/repo.git\n"...)
+	ioutil.WriteFile(path, data, 0644)
+
+	changes, err = config.Refresh()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if len(changes) != 1 || changes[0].Name != "remote.origin.url" || changes[0].Old != nil || changes[0].New.Value != "https://example.com/repo.git" {
+		t.Error("it should report the added variable:", changes)
+	}
+	if url, _ := repo.Config().LookupString("remote.origin.url"); url != "https://example.com/repo.git" {
+		t.Error("it should read the new value:", url)
+	}
+	if _, err := snapshot.LookupString("remote.origin.url"); err == nil {
+		t.Error("it should not change the snapshot")
+	}
+	if changes := DiffConfig(snapshot, config); len(changes) != 1 || changes[0].Name != "remote.origin.url" {
+		t.Error("it should compare the snapshot with the config:", changes)
+	}
+}
+
+func Test_Config_OpenLevel(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_config")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+
+	local, err := repo.Config().OpenLevel(ConfigLevelLocal)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	found := false
+	for _, entry := range local.Entries() {
+		if entry.Name == "core.bare" && entry.Level == ConfigLevelLocal {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("it should list the variables of the level")
+	}
+	if _, err := repo.Config().OpenLevel(ConfigLevelSystem); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should fail for a level without files:", err)
+	}
+}
+
+func Test_rewriteConfigData(t *testing.T) {
+	original := "# my settings\n[core]\n    bare = false ; keep\n\n    editor = vim\n[remote \"origin\"]\n\turl = a\n\tfetch = +refs/heads/*:refs/remotes/origin/*\n# end\n"
+
+	value := "emacs"
+	data, found := rewriteConfigData([]byte(original), "core.editor", &value)
+	expected := "# my settings\n[core]\n    bare = false ; keep\n\n    editor = emacs\n[remote \"origin\"]\n\turl = a\n\tfetch = +refs/heads/*:refs/remotes/origin/*\n# end\n"
+	if !found || string(data) != expected {
+		t.Error("it should replace only the line of the variable:", string(data))
+	}
+
+	value = "a # b"
+	data, found = rewriteConfigData([]byte(original), "core.pager", &value)
+	expected = "# my settings\n[core]\n    bare = false ; keep\n\n    editor = vim\n    pager = \"a # b\"\n[remote \"origin\"]\n\turl = a\n\tfetch = +refs/heads/*:refs/remotes/origin/*\n# end\n"
+	if found || string(data) != expected {
+		t.Error("it should add the variable to the end of the section:", string(data))
+	}
+
+	value = "b"
+	data, _ = rewriteConfigData([]byte(original), "remote.upstream.url", &value)
+	if string(data) != original+"[remote \"upstream\"]\n\turl = b\n" {
+		t.Error("it should add the section to the end of the file:", string(data))
+	}
+
+	data, found = rewriteConfigData([]byte(original), "remote.origin.fetch", nil)
+	expected = "# my settings\n[core]\n    bare = false ; keep\n\n    editor = vim\n[remote \"origin\"]\n\turl = a\n# end\n"
+	if !found || string(data) != expected {
+		t.Error("it should delete the variable:", string(data))
+	}
+}
+
+func Test_Config_Delete(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_config")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	path := filepath.Join(repo.Path(), "config")
+	data, _ := ioutil.ReadFile(path)
+	ioutil.WriteFile(path, append(data, "# hand-written\n[user]\n\tname = A\n\temail = a@example.com\n"...), 0644)
+
+	config, _ := NewConfig()
+	config.AddFile(path, ConfigLevelLocal, false)
+	if err := config.Delete("user.name"); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if _, err := config.LookupString("user.name"); err == nil {
+		t.Error("it should remove the value")
+	}
+	if err := config.Delete("user.name"); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should fail for a missing variable:", err)
+	}
+	config.SetString("user.email", "b@example.com")
+	written, _ := ioutil.ReadFile(path)
+	if string(written) != string(data)+"# hand-written\n[user]\n\temail = b@example.com\n" {
+		t.Error("it should keep the rest of the file:", string(written))
+	}
+}
+
+func Test_Config_CaseInsensitive(t *testing.T) {
+	config, _ := NewConfig()
+	config.addData([]byte("[Core]\n\tcommentChar = ;\n[remote \"Origin\"]\n\tURL = https://example.com/repo.git\n"), ConfigLevelApp)
+
+	for _, name := range []string{"core.commentChar", "core.commentchar", "CORE.COMMENTCHAR"} {
+		if value, err := config.LookupString(name); err != nil || value != ";" {
+			t.Error("it should ignore the case of the section and the key:", name, err)
+		}
+	}
+	if value, err := config.LookupString("remote.Origin.url"); err != nil || value != "https://example.com/repo.git" {
+		t.Error("it should find the key in the subsection:", err)
+	}
+	if _, err := config.LookupString("remote.origin.url"); err == nil {
+		t.Error("it should not ignore the case of the subsection")
+	}
+	if value, err := config.LookupBooleanWithDefaultValue("core.protecthfs"); err != nil || value != defaultBoolConfig["core.protectHFS"] {
+		t.Error("it should ignore the case of the default values:", err)
+	}
+	if remotes := config.subsections("REMOTE"); len(remotes) != 1 || remotes[0] != "Origin" {
+		t.Error("it should ignore the case of the section of subsections:", remotes)
+	}
+}
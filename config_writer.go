@@ -0,0 +1,203 @@
+package git4go
+
+import (
+	"strings"
+)
+
+// internal functions and methods
+
+// configLine is a line of a config file, with the continuation lines of a
+// variable.
+type configLine struct {
+	text string
+	// the section that the line is in matches the variable
+	inSection bool
+	// the line is a section header
+	header bool
+	// the line sets the variable
+	matches bool
+	indent  string
+}
+
+// rewriteConfigData sets the variable in the text of a config file, or
+// removes all of its values when value is nil. Only the lines of the
+// variable are rewritten; comments, blank lines, the order and the
+// indentation of the other lines are kept like git config keeps them. The
+// result tells if the variable was found.
+func rewriteConfigData(data []byte, name string, value *string) ([]byte, bool) {
+	section, subsection, key := splitConfigVariable(name)
+	lines := parseConfigLines(string(data), section, subsection, key)
+
+	lastMatch := -1
+	insertAt := -1
+	indent := "\t"
+	for i, line := range lines {
+		if line.matches {
+			lastMatch = i
+		}
+		// new values go after the last variable of the last matching section
+		if line.inSection && !isConfigComment(line.text) {
+			insertAt = i
+			if !line.header {
+				indent = line.indent
+			}
+		}
+	}
+
+	var result []string
+	for i, line := range lines {
+		switch {
+		case line.matches && value == nil:
+			continue
+		case i == lastMatch:
+			result = append(result, line.indent+key+" = "+formatConfigValue(*value)+"\n")
+		default:
+			result = append(result, line.text)
+		}
+		if lastMatch == -1 && value != nil && i == insertAt {
+			if !strings.HasSuffix(line.text, "\n") {
+				result[len(result)-1] += "\n"
+			}
+			result = append(result, indent+key+" = "+formatConfigValue(*value)+"\n")
+		}
+	}
+	if lastMatch == -1 && insertAt == -1 && value != nil {
+		if len(result) > 0 && !strings.HasSuffix(result[len(result)-1], "\n") {
+			result[len(result)-1] += "\n"
+		}
+		header := "[" + section
+		if subsection != "" {
+			header += " \"" + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(subsection) + "\""
+		}
+		result = append(result, header+"]\n", "\t"+key+" = "+formatConfigValue(*value)+"\n")
+	}
+	return []byte(strings.Join(result, "")), lastMatch != -1
+}
+
+// splitConfigVariable splits "remote.origin.url" to the section, the
+// subsection and the key.
+func splitConfigVariable(name string) (string, string, string) {
+	first := strings.IndexByte(name, '.')
+	last := strings.LastIndexByte(name, '.')
+	if first == -1 {
+		return name, "", ""
+	}
+	if first == last {
+		return name[:first], "", name[first+1:]
+	}
+	return name[:first], name[first+1 : last], name[last+1:]
+}
+
+func parseConfigLines(data, section, subsection, key string) []*configLine {
+	rawLines := strings.SplitAfter(data, "\n")
+	if len(rawLines) > 0 && rawLines[len(rawLines)-1] == "" {
+		rawLines = rawLines[:len(rawLines)-1]
+	}
+	var lines []*configLine
+	inSection := false
+	for i := 0; i < len(rawLines); i++ {
+		text := rawLines[i]
+		trimmed := strings.TrimLeft(text, " \t")
+		line := &configLine{
+			text:   text,
+			indent: text[:len(text)-len(trimmed)],
+		}
+		if strings.HasPrefix(trimmed, "[") {
+			headerSection, headerSubsection, ok := parseConfigSectionHeader(trimmed)
+			inSection = ok && strings.EqualFold(headerSection, section) && headerSubsection == subsection
+			line.header = true
+		} else if lineKey := configLineKey(trimmed); lineKey != "" {
+			for configLineContinues(rawLines[i]) && i+1 < len(rawLines) {
+				i++
+				line.text += rawLines[i]
+			}
+			line.matches = inSection && strings.EqualFold(lineKey, key)
+		}
+		line.inSection = inSection
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// parseConfigSectionHeader parses `[section "subsection"]` and the old
+// syntax `[section.subsection]`, whose subsection is not case sensitive.
+func parseConfigSectionHeader(text string) (string, string, bool) {
+	text = text[1:]
+	end := strings.IndexAny(text, " \t]")
+	if end == -1 {
+		return "", "", false
+	}
+	section := text[:end]
+	if text[end] == ']' {
+		if dot := strings.IndexByte(section, '.'); dot != -1 {
+			return section[:dot], strings.ToLower(section[dot+1:]), true
+		}
+		return section, "", true
+	}
+	rest := strings.TrimLeft(text[end:], " \t")
+	if !strings.HasPrefix(rest, "\"") {
+		return "", "", false
+	}
+	var subsection []byte
+	for i := 1; i < len(rest); i++ {
+		switch rest[i] {
+		case '\\':
+			if i+1 < len(rest) {
+				i++
+				subsection = append(subsection, rest[i])
+			}
+		case '"':
+			if i+1 < len(rest) && rest[i+1] == ']' {
+				return section, string(subsection), true
+			}
+			return "", "", false
+		default:
+			subsection = append(subsection, rest[i])
+		}
+	}
+	return "", "", false
+}
+
+// configLineKey returns the name of the variable that the line sets, or ""
+// for comments and blank lines.
+func configLineKey(trimmed string) string {
+	end := 0
+	for end < len(trimmed) {
+		c := trimmed[end]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || end > 0 && ('0' <= c && c <= '9' || c == '-')) {
+			break
+		}
+		end++
+	}
+	if end == 0 {
+		return ""
+	}
+	if end < len(trimmed) && strings.IndexByte(" \t=\r\n#;", trimmed[end]) == -1 {
+		return ""
+	}
+	return trimmed[:end]
+}
+
+// configLineContinues tells if the value goes on in the next line.
+func configLineContinues(text string) bool {
+	text = strings.TrimRight(text, "\r\n")
+	backslashes := 0
+	for i := len(text) - 1; i >= 0 && text[i] == '\\'; i-- {
+		backslashes++
+	}
+	return backslashes%2 == 1
+}
+
+func isConfigComment(text string) bool {
+	trimmed := strings.TrimSpace(text)
+	return trimmed == "" || trimmed[0] == '#' || trimmed[0] == ';'
+}
+
+// formatConfigValue quotes and escapes the value like git config writes it.
+func formatConfigValue(value string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(value)
+	if value != strings.TrimSpace(value) || strings.ContainsAny(value, ";#") {
+		return "\"" + escaped + "\""
+	}
+	return escaped
+}
@@ -0,0 +1,105 @@
+package git4go
+
+import (
+	"fmt"
+)
+
+// ConnectivityOptions controls Repository.CheckConnectivity.
+type ConnectivityOptions struct {
+	// Commits that are known to be complete, like the old tips of the
+	// refs. The walk does not go below them.
+	Haves []*Oid
+	// Treat the commits that the references point to as complete. This is
+	// the check that is done before the refs are moved after a fetch.
+	HaveRefs bool
+}
+
+// CheckConnectivity verifies that all objects that are reachable from the
+// tips are in the object database: the history of the commits with their
+// trees and blobs, and the targets of tags. It returns an ErrNotFound error
+// that names the missing object and the object that refers to it. Shallow
+// roots end the history, and submodule commits are not checked.
+func (r *Repository) CheckConnectivity(tips []*Oid, opts *ConnectivityOptions) error {
+	odb, err := r.Odb()
+	if err != nil {
+		return err
+	}
+	if opts == nil {
+		opts = &ConnectivityOptions{}
+	}
+	seen := make(map[Oid]bool)
+	for _, have := range opts.Haves {
+		seen[*have] = true
+	}
+	if opts.HaveRefs {
+		err = r.ForEachReference(func(ref *Reference) error {
+			if ref.Type() == ReferenceOid {
+				seen[*ref.Target()] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	type pending struct {
+		oid      *Oid
+		objType  ObjectType
+		referrer *Oid
+	}
+	var queue []pending
+	for _, tip := range tips {
+		queue = append(queue, pending{oid: tip, objType: ObjectAny})
+	}
+	for len(queue) > 0 {
+		next := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if seen[*next.oid] {
+			continue
+		}
+		seen[*next.oid] = true
+
+		if next.objType == ObjectBlob {
+			if !odb.Exists(next.oid) {
+				return connectivityError(next.oid, next.referrer)
+			}
+			continue
+		}
+		obj, err := r.Lookup(next.oid)
+		if IsErrorCode(err, ErrNotFound) {
+			return connectivityError(next.oid, next.referrer)
+		} else if err != nil {
+			return err
+		}
+		if next.objType != ObjectAny && obj.Type() != next.objType {
+			return MakeGitError(fmt.Sprintf("object %s is a %s, not a %s", next.oid.String(), obj.Type().String(), next.objType.String()), ErrObjectCorrupt)
+		}
+		switch obj := obj.(type) {
+		case *Commit:
+			queue = append(queue, pending{oid: obj.TreeId(), objType: ObjectTree, referrer: next.oid})
+			if !r.isShallowRoot(next.oid) {
+				for _, parent := range obj.Parents {
+					queue = append(queue, pending{oid: parent, objType: ObjectCommit, referrer: next.oid})
+				}
+			}
+		case *Tree:
+			for _, entry := range obj.Entries {
+				if entry.Type == ObjectCommit {
+					continue
+				}
+				queue = append(queue, pending{oid: entry.Id, objType: entry.Type, referrer: next.oid})
+			}
+		case *Tag:
+			queue = append(queue, pending{oid: obj.TargetId(), objType: obj.TargetType(), referrer: next.oid})
+		}
+	}
+	return nil
+}
+
+func connectivityError(missing, referrer *Oid) error {
+	if referrer == nil {
+		return MakeGitError(fmt.Sprintf("object %s is missing", missing.String()), ErrNotFound)
+	}
+	return MakeGitError(fmt.Sprintf("object %s is missing, referenced from %s", missing.String(), referrer.String()), ErrNotFound)
+}
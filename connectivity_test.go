@@ -0,0 +1,71 @@
+package git4go
+
+import (
+	"./testutil"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func writeConnectivityHistory(repo *Repository, blob []byte, parent *Oid) (commit, tree, blobId *Oid) {
+	odb, _ := repo.Odb()
+	blobId, _ = odb.Hash(blob, ObjectBlob)
+	tree, _ = odb.Write(append([]byte("100644 file.txt\x00"), blobId[:]...), ObjectTree)
+	content := "tree " + tree.String() + "\n"
+	if parent != nil {
+		content += "parent " + parent.String() + "\n"
+	}
+	content += "author A <a@example.com> 1400000000 +0000\ncommitter A <a@example.com> 1400000000 +0000\n\nmessage\n"
+	commit, _ = odb.Write([]byte(content), ObjectCommit)
+	return
+}
+
+func Test_CheckConnectivity(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	odb, _ := repo.Odb()
+	first, _, _ := writeConnectivityHistory(repo, []byte("first\n"), nil)
+	odb.Write([]byte("first\n"), ObjectBlob)
+	second, _, secondBlob := writeConnectivityHistory(repo, []byte("second\n"), first)
+
+	err := repo.CheckConnectivity([]*Oid{first}, nil)
+	if err != nil {
+		t.Error("it should accept complete history:", err)
+	}
+	err = repo.CheckConnectivity([]*Oid{second}, nil)
+	if !IsErrorCode(err, ErrNotFound) {
+		t.Fatal("it should report missing blob:", err)
+	}
+	expected := fmt.Sprintf("object %s is missing", secondBlob.String())
+	if !strings.HasPrefix(err.Error(), expected) {
+		t.Error("it should name missing object:", err)
+	}
+
+	odb.Write([]byte("second\n"), ObjectBlob)
+	if err = repo.CheckConnectivity([]*Oid{second}, nil); err != nil {
+		t.Error("it should accept fetched blob:", err)
+	}
+	missingParent, _ := NewOid("0123456789012345678901234567890123456789")
+	third, _, _ := writeConnectivityHistory(repo, []byte("first\n"), missingParent)
+	if err = repo.CheckConnectivity([]*Oid{third}, nil); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should report missing parent:", err)
+	}
+	if err = repo.CheckConnectivity([]*Oid{third}, &ConnectivityOptions{Haves: []*Oid{missingParent}}); err != nil {
+		t.Error("it should stop at haves:", err)
+	}
+}
+
+func Test_CheckConnectivity_Refs(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	tip, _ := NewOid(commitHead)
+	err := repo.CheckConnectivity([]*Oid{tip}, nil)
+	if err != nil {
+		t.Error("it should accept repository history:", err)
+	}
+	err = repo.CheckConnectivity([]*Oid{tip}, &ConnectivityOptions{HaveRefs: true})
+	if err != nil {
+		t.Error("it should accept tips that refs point to:", err)
+	}
+}
@@ -0,0 +1,417 @@
+package git4go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/encoding/unicode/utf32"
+	"strings"
+)
+
+// ConvertToWorkdir converts the content of the blob at the path to the
+// content of the file in the working directory, like git does on checkout:
+// "$Id$" is expanded if the ident attribute is set, line endings follow
+// the text and eol attributes or core.autocrlf and core.eol, and the file
+// is encoded with the working-tree-encoding attribute.
+func (r *Repository) ConvertToWorkdir(path string, content []byte) ([]byte, error) {
+	attrs, err := r.convertAttrs(path)
+	if err != nil {
+		return nil, err
+	}
+	if attrs.ident {
+		oid, err := hash(content, ObjectBlob)
+		if err != nil {
+			return nil, err
+		}
+		content = identToWorkdir(content, oid)
+	}
+	if attrs.outputCrlf() {
+		content = crlfToWorkdir(content, attrs.crlf)
+	}
+	if attrs.encoding != "" {
+		enc, err := findWorktreeEncoding(attrs.encoding)
+		if err != nil {
+			return nil, err
+		}
+		converted, err := enc.NewEncoder().Bytes(content)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("failed to encode '%s' from UTF-8 to %s: %s", path, attrs.encoding, err.Error()))
+		}
+		content = converted
+	}
+	return content, nil
+}
+
+// ConvertToOdb is the reverse of ConvertToWorkdir. It converts the file in
+// the working directory to the content of the blob that git would store.
+func (r *Repository) ConvertToOdb(path string, content []byte) ([]byte, error) {
+	attrs, err := r.convertAttrs(path)
+	if err != nil {
+		return nil, err
+	}
+	if attrs.encoding != "" && len(content) > 0 {
+		enc, err := findWorktreeEncoding(attrs.encoding)
+		if err != nil {
+			return nil, err
+		}
+		if hasProhibitedBom(attrs.encoding, content) {
+			return nil, errors.New(fmt.Sprintf("BOM is prohibited in '%s' if encoded as %s", path, attrs.encoding))
+		}
+		converted, err := enc.NewDecoder().Bytes(content)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("failed to encode '%s' from %s to UTF-8: %s", path, attrs.encoding, err.Error()))
+		}
+		content = converted
+	}
+	if attrs.crlf != crlfBinary {
+		content = crlfToOdb(content, attrs.crlf, func() bool {
+			return r.hasCrInIndex(path)
+		})
+	}
+	if attrs.ident {
+		content = identToOdb(content)
+	}
+	return content, nil
+}
+
+// internal functions and methods
+
+type crlfAction int
+
+const (
+	crlfUndefined crlfAction = iota
+	crlfBinary
+	// text: the line ending is decided by core.eol and core.autocrlf
+	crlfText
+	crlfTextInput
+	crlfTextCrlf
+	// text=auto: like text, but files that look binary are not converted
+	crlfAuto
+	crlfAutoInput
+	crlfAutoCrlf
+)
+
+type convertAttrs struct {
+	crlf          crlfAction
+	textEolIsCrlf bool
+	ident         bool
+	encoding      string
+}
+
+// convertAttrs decides the conversions of the path in the same way as
+// convert_attrs() of git.
+func (r *Repository) convertAttrs(path string) (*convertAttrs, error) {
+	matches, err := r.CheckAttrVerbose(path, "text", "crlf", "eol", "ident", "working-tree-encoding")
+	if err != nil {
+		return nil, err
+	}
+	autocrlf, eol := "false", "native"
+	if config := r.Config(); config != nil {
+		if value, err := config.LookupStringWithDefaultValue("core.autocrlf"); err == nil {
+			autocrlf = strings.ToLower(value)
+		}
+		if value, err := config.LookupStringWithDefaultValue("core.eol"); err == nil {
+			eol = strings.ToLower(value)
+		}
+	}
+	switch autocrlf {
+	case "true", "yes", "on", "1":
+		autocrlf = "true"
+	case "input":
+	default:
+		autocrlf = "false"
+	}
+
+	attrs := &convertAttrs{}
+	switch {
+	case autocrlf == "true":
+		attrs.textEolIsCrlf = true
+	case autocrlf == "input":
+		attrs.textEolIsCrlf = false
+	case eol == "crlf":
+		attrs.textEolIsCrlf = true
+	case eol == "native":
+		attrs.textEolIsCrlf = nativeEolIsCrlf
+	}
+
+	// the crlf attribute is the old name of text
+	attrs.crlf = crlfActionFromAttr(matches[0])
+	if attrs.crlf == crlfUndefined {
+		attrs.crlf = crlfActionFromAttr(matches[1])
+	}
+	if attrs.crlf != crlfBinary {
+		eolAttr := ""
+		if matches[2].Type == AttrValueString {
+			eolAttr = matches[2].Value
+		}
+		switch {
+		case attrs.crlf == crlfAuto && eolAttr == "lf":
+			attrs.crlf = crlfAutoInput
+		case attrs.crlf == crlfAuto && eolAttr == "crlf":
+			attrs.crlf = crlfAutoCrlf
+		case eolAttr == "lf":
+			attrs.crlf = crlfTextInput
+		case eolAttr == "crlf":
+			attrs.crlf = crlfTextCrlf
+		}
+	}
+	switch {
+	case attrs.crlf == crlfText && attrs.textEolIsCrlf:
+		attrs.crlf = crlfTextCrlf
+	case attrs.crlf == crlfText:
+		attrs.crlf = crlfTextInput
+	case attrs.crlf == crlfUndefined && autocrlf == "true":
+		attrs.crlf = crlfAutoCrlf
+	case attrs.crlf == crlfUndefined && autocrlf == "input":
+		attrs.crlf = crlfAutoInput
+	case attrs.crlf == crlfUndefined:
+		attrs.crlf = crlfBinary
+	}
+
+	attrs.ident = matches[3].Type == AttrValueTrue
+	if matches[4].Type == AttrValueString {
+		switch strings.ToUpper(matches[4].Value) {
+		case "UTF-8", "UTF8":
+		default:
+			attrs.encoding = matches[4].Value
+		}
+	}
+	return attrs, nil
+}
+
+func crlfActionFromAttr(match *AttrMatch) crlfAction {
+	switch match.Type {
+	case AttrValueTrue:
+		return crlfText
+	case AttrValueFalse:
+		return crlfBinary
+	case AttrValueString:
+		switch match.Value {
+		case "input":
+			return crlfTextInput
+		case "auto":
+			return crlfAuto
+		}
+	}
+	return crlfUndefined
+}
+
+// outputCrlf returns true if LF is converted to CRLF on checkout.
+func (a *convertAttrs) outputCrlf() bool {
+	switch a.crlf {
+	case crlfTextCrlf, crlfAutoCrlf:
+		return true
+	case crlfAuto:
+		return a.textEolIsCrlf
+	}
+	return false
+}
+
+func (a crlfAction) isAuto() bool {
+	return a == crlfAuto || a == crlfAutoInput || a == crlfAutoCrlf
+}
+
+type textStats struct {
+	nul, loneCr, loneLf, crlf int
+	printable, nonPrintable   int
+}
+
+func gatherTextStats(content []byte) *textStats {
+	stats := &textStats{}
+	for i, c := range content {
+		switch {
+		case c == '\r':
+			if i+1 < len(content) && content[i+1] == '\n' {
+				stats.crlf++
+			} else {
+				stats.loneCr++
+			}
+		case c == '\n':
+			if i == 0 || content[i-1] != '\r' {
+				stats.loneLf++
+			}
+		case c == 0:
+			stats.nul++
+			stats.nonPrintable++
+		case c == 127:
+			stats.nonPrintable++
+		case c < 32:
+			switch c {
+			case '\b', '\t', '\033', '\014':
+				stats.printable++
+			default:
+				stats.nonPrintable++
+			}
+		default:
+			stats.printable++
+		}
+	}
+	// git ignores DOS EOF at the end of the file
+	if len(content) > 0 && content[len(content)-1] == '\032' {
+		stats.nonPrintable--
+	}
+	return stats
+}
+
+func (s *textStats) isBinary() bool {
+	return s.loneCr > 0 || s.nul > 0 || (s.printable>>7) < s.nonPrintable
+}
+
+func crlfToWorkdir(content []byte, action crlfAction) []byte {
+	stats := gatherTextStats(content)
+	if stats.loneLf == 0 {
+		return content
+	}
+	// files with CR are left as they are by text=auto
+	if action.isAuto() && (stats.loneCr > 0 || stats.crlf > 0 || stats.isBinary()) {
+		return content
+	}
+	result := make([]byte, 0, len(content)+stats.loneLf)
+	for i, c := range content {
+		if c == '\n' && (i == 0 || content[i-1] != '\r') {
+			result = append(result, '\r')
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// crlfToOdb is crlf_to_git() of git. Like git, the auto actions do not
+// convert a file whose blob in the index has CRs, so that files committed
+// with CRLF don't look modified after a checkout; crInIndex is only called
+// when it matters.
+func crlfToOdb(content []byte, action crlfAction, crInIndex func() bool) []byte {
+	stats := gatherTextStats(content)
+	if stats.crlf == 0 {
+		return content
+	}
+	if action.isAuto() && (stats.isBinary() || crInIndex()) {
+		return content
+	}
+	result := make([]byte, 0, len(content)-stats.crlf)
+	for i, c := range content {
+		if c == '\r' && i+1 < len(content) && content[i+1] == '\n' {
+			continue
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// hasCrInIndex tells if the blob of the stage 0 entry of the path in the
+// index has a CR, like has_crlf_in_index() of git.
+func (r *Repository) hasCrInIndex(path string) bool {
+	if r.IsBare() {
+		return false
+	}
+	index, err := r.Index()
+	if err != nil {
+		return false
+	}
+	entry, err := index.EntryByPath(path, 0)
+	if err != nil || entry.Mode == FilemodeCommit || entry.Mode == FilemodeLink {
+		return false
+	}
+	blob, err := r.LookupBlob(entry.Id)
+	if err != nil {
+		return false
+	}
+	return bytes.IndexByte(blob.Contents(), '\r') != -1
+}
+
+// identToWorkdir replaces "$Id$" and "$Id: anything$" in a line with
+// "$Id: <blob id> $".
+func identToWorkdir(content []byte, oid *Oid) []byte {
+	var result bytes.Buffer
+	for {
+		start, end := findIdent(content)
+		if start == -1 {
+			result.Write(content)
+			return result.Bytes()
+		}
+		result.Write(content[:start])
+		result.WriteString("$Id: " + oid.String() + " $")
+		content = content[end:]
+	}
+}
+
+// identToOdb collapses the expanded idents to "$Id$".
+func identToOdb(content []byte) []byte {
+	var result bytes.Buffer
+	for {
+		start, end := findIdent(content)
+		if start == -1 {
+			result.Write(content)
+			return result.Bytes()
+		}
+		result.Write(content[:start])
+		result.WriteString("$Id$")
+		content = content[end:]
+	}
+}
+
+// findIdent returns the range of the first "$Id$" or "$Id:...$" that
+// does not span lines.
+func findIdent(content []byte) (int, int) {
+	offset := 0
+	for {
+		index := bytes.Index(content[offset:], []byte("$Id"))
+		if index == -1 {
+			return -1, -1
+		}
+		start := offset + index
+		rest := content[start+3:]
+		if len(rest) > 0 && rest[0] == '$' {
+			return start, start + 4
+		}
+		if len(rest) > 0 && rest[0] == ':' {
+			dollar := bytes.IndexByte(rest, '$')
+			if dollar != -1 && bytes.IndexByte(rest[:dollar], '\n') == -1 {
+				return start, start + 3 + dollar + 1
+			}
+		}
+		offset = start + 3
+	}
+}
+
+// findWorktreeEncoding returns the encoding of the name. "UTF-16" and
+// "UTF-32" need a BOM and are written in little endian with a BOM, like
+// git does.
+func findWorktreeEncoding(name string) (encoding.Encoding, error) {
+	switch strings.ToUpper(name) {
+	case "UTF-16", "UTF16":
+		return unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM), nil
+	case "UTF-16LE", "UTF16LE":
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), nil
+	case "UTF-16BE", "UTF16BE":
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), nil
+	case "UTF-16LE-BOM":
+		return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM), nil
+	case "UTF-32", "UTF32":
+		return utf32.UTF32(utf32.LittleEndian, utf32.ExpectBOM), nil
+	case "UTF-32LE", "UTF32LE":
+		return utf32.UTF32(utf32.LittleEndian, utf32.IgnoreBOM), nil
+	case "UTF-32BE", "UTF32BE":
+		return utf32.UTF32(utf32.BigEndian, utf32.IgnoreBOM), nil
+	}
+	enc, err := ianaindex.IANA.Encoding(name)
+	if err != nil || enc == nil {
+		return nil, errors.New(fmt.Sprintf("unsupported working-tree-encoding '%s'", name))
+	}
+	return enc, nil
+}
+
+// hasProhibitedBom checks the BOM of the encodings that name the byte order,
+// which git rejects because the BOM would be kept as a character.
+func hasProhibitedBom(name string, content []byte) bool {
+	switch strings.ToUpper(name) {
+	case "UTF-16LE", "UTF16LE", "UTF-16BE", "UTF16BE":
+		return bytes.HasPrefix(content, []byte{0xff, 0xfe}) || bytes.HasPrefix(content, []byte{0xfe, 0xff})
+	case "UTF-32LE", "UTF32LE", "UTF-32BE", "UTF32BE":
+		return bytes.HasPrefix(content, []byte{0xff, 0xfe, 0, 0}) || bytes.HasPrefix(content, []byte{0, 0, 0xfe, 0xff})
+	}
+	return false
+}
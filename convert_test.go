@@ -0,0 +1,163 @@
+package git4go
+
+import (
+	"./testutil"
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func readCrlfBlob(repo *Repository, name string) []byte {
+	oid, _ := NewOid(map[string]string{
+		"all-lf":    "799770d1cff46753a57db7a066159b5610da6e3a",
+		"more-crlf": "0ff5a53f19bfd2b5eea1ba550295c47515678987",
+		"more-lf":   "04de00b358f13389948756732158eaaaefa1448c",
+	}[name])
+	blob, _ := repo.LookupBlob(oid)
+	return blob.Contents()
+}
+
+func Test_ConvertToWorkdirEol(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/crlf")
+	defer testutil.CleanupWorkspace()
+
+	ioutil.WriteFile("test_resources/crlf/.gitattributes", []byte("* text eol=crlf\n"), 0644)
+	repo, _ := OpenRepository("test_resources/crlf")
+	expected := map[string]string{
+		"all-lf":    "lf\r\nlf\r\nlf\r\nlf\r\nlf\r\n",
+		"more-crlf": "crlf\r\ncrlf\r\nlf\r\ncrlf\r\ncrlf\r\n",
+		"more-lf":   "lf\r\nlf\r\ncrlf\r\nlf\r\nlf\r\n",
+	}
+	for name, content := range expected {
+		blob := readCrlfBlob(repo, name)
+		converted, err := repo.ConvertToWorkdir(name, blob)
+		if err != nil {
+			t.Error("err should be nil:", name, err)
+			continue
+		}
+		if string(converted) != content {
+			t.Errorf("wrong content of %s: %q", name, converted)
+		}
+		// CRLF in the blob is not restored
+		back, _ := repo.ConvertToOdb(name, converted)
+		if name == "all-lf" && string(back) != string(blob) {
+			t.Errorf("it should convert %s back: %q", name, back)
+		}
+	}
+}
+
+func Test_ConvertToWorkdirAutoCrlf(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/crlf")
+	defer testutil.CleanupWorkspace()
+
+	ioutil.WriteFile("test_resources/crlf/.gitattributes", []byte("* text=auto\n"), 0644)
+	repo, _ := OpenRepository("test_resources/crlf")
+	repo.Config().SetString("core.autocrlf", "true")
+	expected := map[string]string{
+		"all-lf": "lf\r\nlf\r\nlf\r\nlf\r\nlf\r\n",
+		// text=auto doesn't touch files with CRLF
+		"more-crlf": "crlf\r\ncrlf\r\nlf\ncrlf\r\ncrlf\r\n",
+		"more-lf":   "lf\nlf\ncrlf\r\nlf\nlf\n",
+	}
+	for name, content := range expected {
+		converted, err := repo.ConvertToWorkdir(name, readCrlfBlob(repo, name))
+		if err != nil {
+			t.Error("err should be nil:", name, err)
+			continue
+		}
+		if string(converted) != content {
+			t.Errorf("wrong content of %s: %q", name, converted)
+		}
+	}
+	binary := []byte("a\nb\x00\n")
+	converted, _ := repo.ConvertToWorkdir("binary", binary)
+	if !bytes.Equal(converted, binary) {
+		t.Errorf("it should not convert binary content: %q", converted)
+	}
+}
+
+func Test_ConvertToOdbCrlfInIndex(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/crlf")
+	defer testutil.CleanupWorkspace()
+
+	ioutil.WriteFile("test_resources/crlf/.gitattributes", []byte("* text=auto\n"), 0644)
+	repo, _ := OpenRepository("test_resources/crlf")
+	repo.Config().SetString("core.autocrlf", "true")
+	crlf := "crlf\r\ncrlf\r\n"
+	converted, _ := repo.ConvertToOdb("new-crlf", []byte(crlf))
+	if string(converted) != "crlf\ncrlf\n" {
+		t.Errorf("it should normalize files that are not in the index: %q", converted)
+	}
+
+	index, _ := repo.Index()
+	oid, _ := NewOid("0ff5a53f19bfd2b5eea1ba550295c47515678987")
+	index.Add(&IndexEntry{Path: "new-crlf", Mode: FilemodeBlob, Id: oid})
+	converted, _ = repo.ConvertToOdb("new-crlf", []byte(crlf))
+	if string(converted) != crlf {
+		t.Errorf("it should not normalize files that have CRs in the index: %q", converted)
+	}
+	ioutil.WriteFile("test_resources/crlf/.gitattributes", []byte("* text\n"), 0644)
+	repo, _ = OpenRepository("test_resources/crlf")
+	index, _ = repo.Index()
+	index.Add(&IndexEntry{Path: "new-crlf", Mode: FilemodeBlob, Id: oid})
+	converted, _ = repo.ConvertToOdb("new-crlf", []byte(crlf))
+	if string(converted) != "crlf\ncrlf\n" {
+		t.Errorf("it should normalize text files regardless of the index: %q", converted)
+	}
+}
+
+func Test_ConvertIdent(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/crlf")
+	defer testutil.CleanupWorkspace()
+
+	ioutil.WriteFile("test_resources/crlf/.gitattributes", []byte("*.c ident eol=crlf\n"), 0644)
+	repo, _ := OpenRepository("test_resources/crlf")
+	converted, err := repo.ConvertToWorkdir("file.c", []byte("x $Id$\ny\n"))
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	expected := "x $Id: 9c7aaafc8a40a636176c49b61146f7ce65a852f1 $\r\ny\r\n"
+	if string(converted) != expected {
+		t.Errorf("wrong ident expansion: %q", converted)
+	}
+	back, _ := repo.ConvertToOdb("file.c", converted)
+	if string(back) != "x $Id$\ny\n" {
+		t.Errorf("it should collapse the ident: %q", back)
+	}
+	converted, _ = repo.ConvertToWorkdir("file.c", []byte("$Id: not\nident$\n"))
+	if string(converted) != "$Id: not\r\nident$\r\n" {
+		t.Errorf("it should not expand an ident that spans lines: %q", converted)
+	}
+}
+
+func Test_ConvertWorkingTreeEncoding(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/crlf")
+	defer testutil.CleanupWorkspace()
+
+	ioutil.WriteFile("test_resources/crlf/.gitattributes", []byte("*.txt working-tree-encoding=UTF-16\n*.le working-tree-encoding=UTF-16LE\n"), 0644)
+	repo, _ := OpenRepository("test_resources/crlf")
+	converted, err := repo.ConvertToWorkdir("a.txt", []byte("hi\n"))
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if !bytes.Equal(converted, []byte{0xff, 0xfe, 'h', 0, 'i', 0, '\n', 0}) {
+		t.Errorf("it should be encoded in UTF-16 with BOM: %v", converted)
+	}
+	back, err := repo.ConvertToOdb("a.txt", []byte{0xfe, 0xff, 0, 'h', 0, 'i', 0, '\n'})
+	if err != nil || string(back) != "hi\n" {
+		t.Error("it should decode big endian UTF-16:", err, back)
+	}
+	_, err = repo.ConvertToOdb("a.txt", []byte{'h', 0, 'i', 0})
+	if err == nil {
+		t.Error("UTF-16 without BOM should be an error")
+	}
+
+	converted, _ = repo.ConvertToWorkdir("b.le", []byte("hi\n"))
+	if !bytes.Equal(converted, []byte{'h', 0, 'i', 0, '\n', 0}) {
+		t.Errorf("it should be encoded in UTF-16LE without BOM: %v", converted)
+	}
+	_, err = repo.ConvertToOdb("b.le", []byte{0xff, 0xfe, 'h', 0})
+	if err == nil {
+		t.Error("UTF-16LE with BOM should be an error")
+	}
+}
@@ -0,0 +1,490 @@
+package git4go
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// the helper supports AuthType and Credential, e.g. bearer tokens
+	CredentialCapabilityAuthType = "authtype"
+	// the helper supports State and Continue for multistage authentication
+	CredentialCapabilityState = "state"
+)
+
+// Credential is the description of a credential in the protocol of the git
+// credential helpers.
+type Credential struct {
+	// the capabilities that the caller supports, and after Fill the ones
+	// that the helper supported too
+	Capabilities []string
+	// the scheme and the credential of the Authorization header, e.g.
+	// "Bearer" and a token, instead of the username and the password
+	AuthType   string
+	Credential string
+	// the credential should not be stored
+	Ephemeral bool
+	State     []string
+	// another round of multistage authentication follows
+	Continue bool
+
+	Protocol string
+	// the host with the port, if it is not the default one
+	Host string
+	// empty unless credential.useHttpPath is set for http urls
+	Path     string
+	Username string
+	Password string
+	// the zero time if the password does not expire
+	PasswordExpiryUtc time.Time
+	OauthRefreshToken string
+	// the WWW-Authenticate headers of the server
+	WwwAuth []string
+	// the helper asked to stop looking for a credential
+	Quit bool
+}
+
+// NewCredential returns the description of the url, e.g.
+// "https://user@example.com/repo.git".
+func NewCredential(rawurl string) (*Credential, error) {
+	c := &Credential{}
+	if err := c.setUrl(rawurl); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ReadCredential reads the key=value lines of the protocol until an empty
+// line or the end of the input. Unknown keys are ignored.
+func ReadCredential(reader io.Reader) (*Credential, error) {
+	c := &Credential{}
+	if err := c.read(bufio.NewReader(reader)); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Write writes the credential in the protocol, without the final empty
+// line. Values with a newline or a NUL byte are rejected.
+func (c *Credential) Write(writer io.Writer) error {
+	var buffer bytes.Buffer
+	item := func(key, value string) error {
+		if value == "" {
+			return nil
+		}
+		if strings.ContainsAny(value, "\n\x00") {
+			return MakeGitError(fmt.Sprintf("credential value for %s contains a newline or a NUL byte", key), ErrInvalid)
+		}
+		buffer.WriteString(key + "=" + value + "\n")
+		return nil
+	}
+	items := [][2]string{}
+	for _, capability := range c.Capabilities {
+		items = append(items, [2]string{"capability[]", capability})
+	}
+	if c.HasCapability(CredentialCapabilityAuthType) {
+		items = append(items, [2]string{"authtype", c.AuthType}, [2]string{"credential", c.Credential})
+	}
+	if c.HasCapability(CredentialCapabilityState) {
+		if c.Ephemeral {
+			items = append(items, [2]string{"ephemeral", "1"})
+		}
+		for _, state := range c.State {
+			items = append(items, [2]string{"state[]", state})
+		}
+		if c.Continue {
+			items = append(items, [2]string{"continue", "1"})
+		}
+	}
+	items = append(items,
+		[2]string{"protocol", c.Protocol},
+		[2]string{"host", c.Host},
+		[2]string{"path", c.Path},
+		[2]string{"username", c.Username},
+		[2]string{"password", c.Password})
+	if !c.PasswordExpiryUtc.IsZero() {
+		items = append(items, [2]string{"password_expiry_utc", strconv.FormatInt(c.PasswordExpiryUtc.Unix(), 10)})
+	}
+	items = append(items, [2]string{"oauth_refresh_token", c.OauthRefreshToken})
+	for _, header := range c.WwwAuth {
+		items = append(items, [2]string{"wwwauth[]", header})
+	}
+	for _, pair := range items {
+		if err := item(pair[0], pair[1]); err != nil {
+			return err
+		}
+	}
+	_, err := writer.Write(buffer.Bytes())
+	return err
+}
+
+// HasCapability tells if the capability is in Capabilities.
+func (c *Credential) HasCapability(capability string) bool {
+	for _, candidate := range c.Capabilities {
+		if candidate == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Complete tells if the credential can be used: it has a username and a
+// password, or a credential of an authtype.
+func (c *Credential) Complete() bool {
+	return (c.Username != "" && c.Password != "") || (c.AuthType != "" && c.Credential != "")
+}
+
+// CredentialHelpers returns the credential.helper and
+// credential.<url>.helper values that match the credential, in the order
+// that they are asked. An empty value clears the helpers before it.
+func (r *Repository) CredentialHelpers(c *Credential) []string {
+	var helpers []string
+	for _, entry := range r.credentialConfig(c, "helper") {
+		if entry.Value == "" {
+			helpers = nil
+		} else {
+			helpers = append(helpers, entry.Value)
+		}
+	}
+	return helpers
+}
+
+// FillCredential asks the credential helpers for the missing parts of the
+// credential, like "git credential fill": the first helper that completes
+// it wins. credential.username is used if the credential has no username.
+// Helpers that fail are skipped, like in git. It fails with ErrNotFound if
+// no helper knows the credential, and with ErrAuth if a helper says quit.
+func (r *Repository) FillCredential(c *Credential) error {
+	r.prepareCredential(c)
+	if err := c.Write(ioutil.Discard); err != nil {
+		return err
+	}
+	capabilities := c.Capabilities
+	for _, helper := range r.CredentialHelpers(c) {
+		if RunCredentialHelper(helper, "get", c) != nil {
+			continue
+		}
+		if c.Quit {
+			return MakeGitError(fmt.Sprintf("credential helper '%s' told us to quit", helper), ErrAuth)
+		}
+		if !c.PasswordExpiryUtc.IsZero() && c.PasswordExpiryUtc.Before(time.Now()) {
+			c.Password = ""
+			c.PasswordExpiryUtc = time.Time{}
+		}
+		if c.Complete() {
+			return nil
+		}
+		c.Capabilities = capabilities
+	}
+	return MakeGitError(fmt.Sprintf("no credential for '%s'", c.description()), ErrNotFound)
+}
+
+// ApproveCredential asks the credential helpers to store the credential
+// after it was accepted, like "git credential approve". Incomplete,
+// ephemeral and expired credentials are not stored.
+func (r *Repository) ApproveCredential(c *Credential) error {
+	r.prepareCredential(c)
+	if !c.Complete() || c.Ephemeral || (!c.PasswordExpiryUtc.IsZero() && c.PasswordExpiryUtc.Before(time.Now())) {
+		return nil
+	}
+	if err := c.Write(ioutil.Discard); err != nil {
+		return err
+	}
+	for _, helper := range r.CredentialHelpers(c) {
+		RunCredentialHelper(helper, "store", c)
+	}
+	return nil
+}
+
+// RejectCredential asks the credential helpers to erase the credential
+// after it was refused, like "git credential reject", and clears its
+// secrets.
+func (r *Repository) RejectCredential(c *Credential) error {
+	r.prepareCredential(c)
+	if err := c.Write(ioutil.Discard); err != nil {
+		return err
+	}
+	for _, helper := range r.CredentialHelpers(c) {
+		RunCredentialHelper(helper, "erase", c)
+	}
+	c.Password = ""
+	c.PasswordExpiryUtc = time.Time{}
+	c.OauthRefreshToken = ""
+	c.Credential = ""
+	return nil
+}
+
+// RunCredentialHelper runs the helper for the operation ("get", "store" or
+// "erase") with the credential on its standard input, like git: "!command"
+// is a shell command, an absolute path is a program and another name runs
+// "git credential-<name>". The output of "get" is read into the
+// credential; the authtype and the credential are kept only if the helper
+// announced the capability.
+func RunCredentialHelper(helper, operation string, c *Credential) error {
+	var command string
+	switch {
+	case strings.HasPrefix(helper, "!"):
+		command = helper[1:]
+	case filepath.IsAbs(helper):
+		command = helper
+	default:
+		command = "git credential-" + helper
+	}
+	var input bytes.Buffer
+	if err := c.Write(&input); err != nil {
+		return err
+	}
+	input.WriteString("\n")
+	cmd := exec.Command("sh", "-c", command+" "+operation)
+	cmd.Stdin = &input
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return MakeGitError(fmt.Sprintf("credential helper '%s' failed: %s", helper, err), ErrAuth)
+	}
+	if operation != "get" {
+		return nil
+	}
+	response := &Credential{}
+	if err = response.read(bufio.NewReader(bytes.NewReader(output))); err != nil {
+		return err
+	}
+	c.merge(response)
+	return nil
+}
+
+// internal functions and methods
+
+func (c *Credential) setUrl(rawurl string) error {
+	parsed, err := url.Parse(rawurl)
+	if err != nil || parsed.Scheme == "" {
+		return MakeGitError(fmt.Sprintf("invalid credential url '%s'", rawurl), ErrInvalid)
+	}
+	c.Protocol = parsed.Scheme
+	c.Host = parsed.Host
+	c.Path = strings.TrimPrefix(parsed.Path, "/")
+	c.Username = ""
+	c.Password = ""
+	if parsed.User != nil {
+		c.Username = parsed.User.Username()
+		c.Password, _ = parsed.User.Password()
+	}
+	return nil
+}
+
+func (c *Credential) read(reader *bufio.Reader) error {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return nil
+		}
+		equal := strings.IndexByte(line, '=')
+		if equal == -1 {
+			return MakeGitError(fmt.Sprintf("invalid credential line: %s", line), ErrInvalid)
+		}
+		key, value := line[:equal], line[equal+1:]
+		switch key {
+		case "capability[]":
+			if value == CredentialCapabilityAuthType || value == CredentialCapabilityState {
+				c.Capabilities = append(c.Capabilities, value)
+			}
+		case "authtype":
+			c.AuthType = value
+		case "credential":
+			c.Credential = value
+		case "ephemeral":
+			c.Ephemeral = parseCredentialBool(value)
+		case "state[]":
+			if value == "" {
+				c.State = nil
+			} else {
+				c.State = append(c.State, value)
+			}
+		case "continue":
+			c.Continue = parseCredentialBool(value)
+		case "protocol":
+			c.Protocol = value
+		case "host":
+			c.Host = value
+		case "path":
+			c.Path = value
+		case "username":
+			c.Username = value
+		case "password":
+			c.Password = value
+		case "password_expiry_utc":
+			if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+				c.PasswordExpiryUtc = time.Unix(seconds, 0).UTC()
+			}
+		case "oauth_refresh_token":
+			c.OauthRefreshToken = value
+		case "url":
+			if err := c.setUrl(value); err != nil {
+				return err
+			}
+		case "wwwauth[]":
+			if value == "" {
+				c.WwwAuth = nil
+			} else {
+				c.WwwAuth = append(c.WwwAuth, value)
+			}
+		case "quit":
+			c.Quit = parseCredentialBool(value)
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+func parseCredentialBool(value string) bool {
+	result, _ := parseConfigBool(value)
+	return result
+}
+
+// merge copies the fields that the helper answered.
+func (c *Credential) merge(response *Credential) {
+	capabilities := c.Capabilities
+	c.Capabilities = nil
+	for _, capability := range response.Capabilities {
+		for _, supported := range capabilities {
+			if capability == supported {
+				c.Capabilities = append(c.Capabilities, capability)
+			}
+		}
+	}
+	if c.HasCapability(CredentialCapabilityAuthType) {
+		if response.AuthType != "" {
+			c.AuthType = response.AuthType
+		}
+		if response.Credential != "" {
+			c.Credential = response.Credential
+		}
+	}
+	if c.HasCapability(CredentialCapabilityState) {
+		c.Ephemeral = c.Ephemeral || response.Ephemeral
+		c.State = append(c.State, response.State...)
+		c.Continue = response.Continue
+	}
+	for _, field := range []struct {
+		target *string
+		value  string
+	}{
+		{&c.Protocol, response.Protocol},
+		{&c.Host, response.Host},
+		{&c.Path, response.Path},
+		{&c.Username, response.Username},
+		{&c.Password, response.Password},
+		{&c.OauthRefreshToken, response.OauthRefreshToken},
+	} {
+		if field.value != "" {
+			*field.target = field.value
+		}
+	}
+	if !response.PasswordExpiryUtc.IsZero() {
+		c.PasswordExpiryUtc = response.PasswordExpiryUtc
+	}
+	c.Quit = c.Quit || response.Quit
+}
+
+// prepareCredential applies credential.useHttpPath and credential.username.
+func (r *Repository) prepareCredential(c *Credential) {
+	if c.Protocol == "http" || c.Protocol == "https" {
+		useHttpPath := false
+		for _, entry := range r.credentialConfig(c, "useHttpPath") {
+			useHttpPath = parseCredentialBool(entry.Value)
+		}
+		if !useHttpPath {
+			c.Path = ""
+		}
+	}
+	if c.Username == "" {
+		for _, entry := range r.credentialConfig(c, "username") {
+			c.Username = entry.Value
+		}
+	}
+}
+
+// credentialConfig returns the credential.<key> and credential.<url>.<key>
+// entries that match the credential, in the order that git reads them.
+func (r *Repository) credentialConfig(c *Credential, key string) []*ConfigEntry {
+	config := r.Config()
+	if config == nil {
+		return nil
+	}
+	entries := config.Entries()
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Level < entries[j].Level
+	})
+	var result []*ConfigEntry
+	for _, entry := range entries {
+		if !strings.HasPrefix(strings.ToLower(entry.Name), "credential.") {
+			continue
+		}
+		name := entry.Name[len("credential."):]
+		last := strings.LastIndexByte(name, '.')
+		if !strings.EqualFold(name[last+1:], key) {
+			continue
+		}
+		if last == -1 || c.matchUrl(name[:last]) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// matchUrl tells if the url of a credential.<url> section matches: the
+// protocol and the host must be equal, where "*" matches one part of the
+// host name, and the username and the path, if the url has them, too.
+func (c *Credential) matchUrl(pattern string) bool {
+	parsed, err := url.Parse(pattern)
+	if err != nil || parsed.Scheme == "" {
+		// "credential.example.com.helper" is not a url and never matches
+		return false
+	}
+	if !strings.EqualFold(parsed.Scheme, c.Protocol) || !matchCredentialHost(parsed.Host, c.Host) {
+		return false
+	}
+	if parsed.User != nil && parsed.User.Username() != c.Username {
+		return false
+	}
+	path := strings.Trim(parsed.Path, "/")
+	return path == "" || c.Path == path || strings.HasPrefix(c.Path, path+"/")
+}
+
+func matchCredentialHost(pattern, host string) bool {
+	patternParts := strings.Split(strings.ToLower(pattern), ".")
+	hostParts := strings.Split(strings.ToLower(host), ".")
+	if len(patternParts) != len(hostParts) {
+		return false
+	}
+	for i, part := range patternParts {
+		if part != "*" && part != hostParts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Credential) description() string {
+	description := c.Protocol + "://" + c.Host
+	if c.Path != "" {
+		description += "/" + c.Path
+	}
+	return description
+}
@@ -0,0 +1,97 @@
+package git4go
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_CredentialProtocol(t *testing.T) {
+	c, err := NewCredential("https://alice@example.com:8443/org/repo.git")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if c.Protocol != "https" || c.Host != "example.com:8443" || c.Path != "org/repo.git" || c.Username != "alice" {
+		t.Error("it should describe the url:", c)
+	}
+	c.Capabilities = []string{CredentialCapabilityAuthType}
+	c.AuthType = "Bearer"
+	c.Credential = "token"
+	c.PasswordExpiryUtc = time.Unix(1700000000, 0)
+	c.WwwAuth = []string{"Basic realm=\"example\""}
+	var buffer bytes.Buffer
+	if err = c.Write(&buffer); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	expected := "capability[]=authtype\nauthtype=Bearer\ncredential=token\nprotocol=https\nhost=example.com:8443\npath=org/repo.git\nusername=alice\npassword_expiry_utc=1700000000\nwwwauth[]=Basic realm=\"example\"\n"
+	if buffer.String() != expected {
+		t.Error("it should write the key=value lines:", buffer.String())
+	}
+	read, err := ReadCredential(strings.NewReader(buffer.String() + "\nusername=ignored\n"))
+	if err != nil || read.Username != "alice" || read.Credential != "token" || !read.PasswordExpiryUtc.Equal(c.PasswordExpiryUtc) || len(read.WwwAuth) != 1 {
+		t.Error("it should read the lines until the empty line:", read, err)
+	}
+
+	c.Password = "with\nnewline"
+	if err = c.Write(&buffer); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should not write a value with a newline:", err)
+	}
+}
+
+func Test_CredentialHelpers(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_credential")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	log := filepath.Join(dir, "helper.log")
+	config := repo.Config()
+	config.SetString("credential.helper", "!f() { echo \"$1\" >> "+log+"; cat >> "+log+"; }; f")
+	config.SetString("credential.https://*.example.com.helper",
+		"!f() { cat > /dev/null; test \"$1\" = get && printf 'username=alice\\npassword=s3cret\\n'; }; f")
+	config.SetString("credential.https://token.example.org.helper",
+		"!f() { cat > /dev/null; printf 'capability[]=authtype\\nauthtype=Bearer\\ncredential=t0ken\\n'; }; f")
+	config.SetString("credential.https://quit.example.org.helper", "!f() { cat > /dev/null; echo quit=1; }; f")
+
+	c, _ := NewCredential("https://git.example.com/repo.git")
+	if helpers := repo.CredentialHelpers(c); len(helpers) != 2 {
+		t.Error("it should select the helpers of the url:", helpers)
+	}
+	if err := repo.FillCredential(c); err != nil || c.Username != "alice" || c.Password != "s3cret" {
+		t.Error("it should fill the credential from the helpers:", c, err)
+	}
+	if c.Path != "" {
+		t.Error("it should not send the path without credential.useHttpPath:", c.Path)
+	}
+	repo.ApproveCredential(c)
+	repo.RejectCredential(c)
+	if c.Password != "" {
+		t.Error("it should clear the password after reject")
+	}
+	output, _ := ioutil.ReadFile(log)
+	expected := "get\nprotocol=https\nhost=git.example.com\n\nstore\nprotocol=https\nhost=git.example.com\nusername=alice\npassword=s3cret\n\nerase\nprotocol=https\nhost=git.example.com\nusername=alice\npassword=s3cret\n\n"
+	if string(output) != expected {
+		t.Error("it should run get, store and erase:", string(output))
+	}
+
+	c, _ = NewCredential("https://token.example.org/repo.git")
+	if err := repo.FillCredential(c); !IsErrorCode(err, ErrNotFound) || c.AuthType != "" {
+		t.Error("it should ignore the authtype unless the caller supports it:", c, err)
+	}
+	c, _ = NewCredential("https://token.example.org/repo.git")
+	c.Capabilities = []string{CredentialCapabilityAuthType}
+	if err := repo.FillCredential(c); err != nil || c.AuthType != "Bearer" || c.Credential != "t0ken" {
+		t.Error("it should accept the authtype of the helper:", c, err)
+	}
+
+	c, _ = NewCredential("https://quit.example.org/repo.git")
+	if err := repo.FillCredential(c); !IsErrorCode(err, ErrAuth) {
+		t.Error("it should stop when the helper says quit:", err)
+	}
+	c, _ = NewCredential("ssh://example.org/repo.git")
+	if err := repo.FillCredential(c); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should fail without a credential:", err)
+	}
+}
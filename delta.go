@@ -80,17 +80,35 @@ func CreateDelta(source, target []byte, maxDeltaSize uint64) ([]byte, error) {
 }
 
 func ApplyDelta(base, delta []byte) ([]byte, error) {
-	baseSize, targetSize, offset := decodeHeader(delta)
+	baseSize, targetSize, offset, err := decodeHeader(delta)
+	if err != nil {
+		return nil, err
+	}
 	if baseSize != uint64(len(base)) {
 		return nil, errors.New(fmt.Sprintf("invalid base buffer length in header: %d, %d\n", baseSize, len(base)))
 	}
+	deltaSize := uint64(len(delta))
+	// each instruction byte can produce 64KiB at most, so bigger sizes in
+	// the header are broken and must not be allocated
+	if targetSize > (deltaSize-offset)*0x10000 {
+		return nil, errors.New(fmt.Sprintf("delta target size is too big: %d", targetSize))
+	}
 	rv := make([]byte, targetSize)
 	var rvOffset uint64
 
-	for offset < uint64(len(delta)) {
+	for offset < deltaSize {
 		opcode := delta[offset]
 		offset++
 		if (opcode & 0x80) != 0 {
+			argCount := uint64(0)
+			for bit := uint(0); bit < 7; bit++ {
+				if (opcode & (1 << bit)) != 0 {
+					argCount++
+				}
+			}
+			if deltaSize-offset < argCount {
+				return nil, errors.New("delta copy instruction is truncated")
+			}
 			var baseOffset uint64
 			var copyLength uint64
 			if (opcode & 0x01) != 0 {
@@ -124,10 +142,16 @@ func ApplyDelta(base, delta []byte) ([]byte, error) {
 			if copyLength == 0 {
 				copyLength = 0x10000
 			}
+			if baseOffset+copyLength > baseSize || rvOffset+copyLength > targetSize {
+				return nil, errors.New("delta copy instruction is out of range")
+			}
 			copy(rv[rvOffset:], base[baseOffset:baseOffset+copyLength])
 			rvOffset += copyLength
 		} else if opcode != 0 {
 			copyLength := uint64(opcode)
+			if deltaSize-offset < copyLength || rvOffset+copyLength > targetSize {
+				return nil, errors.New("delta insert instruction is out of range")
+			}
 			copy(rv[rvOffset:], delta[offset:offset+copyLength])
 			offset += copyLength
 			rvOffset += copyLength
@@ -264,24 +288,32 @@ func emitCopy(opcodes *bytes.Buffer, source []byte, offset, length int) {
 	opcodes.Write(ops)
 }
 
-func nextSize(buffer []byte, offset uint64) (uint64, uint64) {
-	b := buffer[offset]
-	offset++
-	rv := uint64(b & 0x7f)
-	var shift uint = 7
-
-	for (b & 0x80) != 0 {
-		b = buffer[offset]
-		rv |= uint64(b&0x7f) << shift
+func nextSize(buffer []byte, offset uint64) (uint64, uint64, error) {
+	var rv uint64
+	var shift uint
+	for {
+		if uint64(len(buffer)) <= offset {
+			return 0, offset, errors.New("delta header is truncated")
+		}
+		if 64 <= shift {
+			return 0, offset, errors.New("delta header size is too big")
+		}
+		b := buffer[offset]
 		offset++
+		rv |= uint64(b&0x7f) << shift
 		shift += 7
+		if (b & 0x80) == 0 {
+			return rv, offset, nil
+		}
 	}
-	return rv, offset
 }
 
-func decodeHeader(buffer []byte) (sourceLength, targetLength, offset uint64) {
-	sourceLength, offset = nextSize(buffer, offset)
-	targetLength, offset = nextSize(buffer, offset)
+func decodeHeader(buffer []byte) (sourceLength, targetLength, offset uint64, err error) {
+	sourceLength, offset, err = nextSize(buffer, offset)
+	if err != nil {
+		return
+	}
+	targetLength, offset, err = nextSize(buffer, offset)
 	return
 }
 
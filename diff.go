@@ -0,0 +1,46 @@
+package git4go
+
+// Delta is the kind of change of a file between two sides of a diff.
+type Delta int
+
+const (
+	DeltaUnmodified Delta = iota
+	DeltaAdded
+	DeltaDeleted
+	DeltaModified
+	DeltaRenamed
+	DeltaCopied
+	DeltaIgnored
+	DeltaUntracked
+	DeltaTypeChange
+	DeltaUnreadable
+	DeltaConflicted
+)
+
+func (d Delta) String() string {
+	switch d {
+	case DeltaUnmodified:
+		return "unmodified"
+	case DeltaAdded:
+		return "added"
+	case DeltaDeleted:
+		return "deleted"
+	case DeltaModified:
+		return "modified"
+	case DeltaRenamed:
+		return "renamed"
+	case DeltaCopied:
+		return "copied"
+	case DeltaIgnored:
+		return "ignored"
+	case DeltaUntracked:
+		return "untracked"
+	case DeltaTypeChange:
+		return "typechange"
+	case DeltaUnreadable:
+		return "unreadable"
+	case DeltaConflicted:
+		return "conflicted"
+	}
+	return ""
+}
@@ -0,0 +1,324 @@
+package git4go
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+type DiffBinaryType int
+
+const (
+	// There is no binary data for the side
+	DiffBinaryNone DiffBinaryType = iota
+	// The whole content of the file
+	DiffBinaryLiteral
+	// A delta against the content of the other side
+	DiffBinaryDelta
+)
+
+// DiffBinaryFile is a hunk of a "GIT binary patch". Data is the inflated
+// content or delta, and InflatedLen the size of the file that it makes.
+type DiffBinaryFile struct {
+	Type        DiffBinaryType
+	Data        []byte
+	InflatedLen uint64
+}
+
+// DiffBinary is the binary patch of a file. NewFile makes the new content
+// from the old one, OldFile makes the old content back for reverse apply.
+type DiffBinary struct {
+	OldFile DiffBinaryFile
+	NewFile DiffBinaryFile
+}
+
+const diffBinaryHeader = "GIT binary patch"
+
+// NewDiffBinary creates the binary patch between two contents. Like git,
+// each direction uses a delta if it deflates smaller than the literal.
+func NewDiffBinary(oldContent, newContent []byte) (*DiffBinary, error) {
+	newFile, err := newDiffBinaryFile(oldContent, newContent)
+	if err != nil {
+		return nil, err
+	}
+	oldFile, err := newDiffBinaryFile(newContent, oldContent)
+	if err != nil {
+		return nil, err
+	}
+	return &DiffBinary{OldFile: *oldFile, NewFile: *newFile}, nil
+}
+
+// ParseDiffBinary reads the "GIT binary patch" part of a patch. The hunk
+// for the reverse direction is optional.
+func ParseDiffBinary(patch []byte) (*DiffBinary, error) {
+	lines := strings.Split(string(patch), "\n")
+	if len(lines) == 0 || lines[0] != diffBinaryHeader {
+		return nil, errors.New("binary patch does not start with '" + diffBinaryHeader + "'")
+	}
+	binary := &DiffBinary{}
+	rest, err := parseDiffBinaryFile(lines[1:], &binary.NewFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 && rest[0] != "" {
+		_, err = parseDiffBinaryFile(rest, &binary.OldFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return binary, nil
+}
+
+// Format writes the patch in the format of "git diff --binary".
+func (b *DiffBinary) Format(buffer *bytes.Buffer) error {
+	buffer.WriteString(diffBinaryHeader)
+	buffer.WriteByte('\n')
+	err := b.NewFile.format(buffer)
+	if err != nil {
+		return err
+	}
+	if b.OldFile.Type != DiffBinaryNone {
+		err = b.OldFile.format(buffer)
+	}
+	return err
+}
+
+// Apply makes the new content from the old content.
+func (b *DiffBinary) Apply(oldContent []byte) ([]byte, error) {
+	return b.NewFile.apply(oldContent)
+}
+
+// ApplyReverse makes the old content from the new content.
+func (b *DiffBinary) ApplyReverse(newContent []byte) ([]byte, error) {
+	if b.OldFile.Type == DiffBinaryNone {
+		return nil, MakeGitError("binary patch cannot be reversed", ErrInvalid)
+	}
+	return b.OldFile.apply(newContent)
+}
+
+// internal functions and methods
+
+func newDiffBinaryFile(source, target []byte) (*DiffBinaryFile, error) {
+	file := &DiffBinaryFile{
+		Type:        DiffBinaryLiteral,
+		Data:        target,
+		InflatedLen: uint64(len(target)),
+	}
+	if len(source) == 0 || len(target) == 0 {
+		return file, nil
+	}
+	delta, err := CreateDelta(source, target, 0)
+	if err != nil {
+		return nil, err
+	}
+	literalSize, err := deflatedSize(target)
+	if err != nil {
+		return nil, err
+	}
+	deltaSize, err := deflatedSize(delta)
+	if err != nil {
+		return nil, err
+	}
+	if deltaSize < literalSize {
+		file.Type = DiffBinaryDelta
+		file.Data = delta
+	}
+	return file, nil
+}
+
+func deflatedSize(data []byte) (int, error) {
+	compressed, err := deflate(data)
+	return len(compressed), err
+}
+
+func deflate(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := zlib.NewWriter(&buffer)
+	_, err := writer.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (f *DiffBinaryFile) format(buffer *bytes.Buffer) error {
+	switch f.Type {
+	case DiffBinaryLiteral:
+		fmt.Fprintf(buffer, "literal %d\n", f.InflatedLen)
+	case DiffBinaryDelta:
+		fmt.Fprintf(buffer, "delta %d\n", len(f.Data))
+	default:
+		return errors.New("unknown binary patch type")
+	}
+	compressed, err := deflate(f.Data)
+	if err != nil {
+		return err
+	}
+	for len(compressed) > 0 {
+		length := len(compressed)
+		if length > 52 {
+			length = 52
+		}
+		if length <= 26 {
+			buffer.WriteByte(byte('A' + length - 1))
+		} else {
+			buffer.WriteByte(byte('a' + length - 27))
+		}
+		buffer.Write(encodeBase85(compressed[:length]))
+		buffer.WriteByte('\n')
+		compressed = compressed[length:]
+	}
+	buffer.WriteByte('\n')
+	return nil
+}
+
+func parseDiffBinaryFile(lines []string, file *DiffBinaryFile) ([]string, error) {
+	if len(lines) == 0 {
+		return nil, errors.New("binary patch is truncated")
+	}
+	var size string
+	switch {
+	case strings.HasPrefix(lines[0], "literal "):
+		file.Type = DiffBinaryLiteral
+		size = lines[0][len("literal "):]
+	case strings.HasPrefix(lines[0], "delta "):
+		file.Type = DiffBinaryDelta
+		size = lines[0][len("delta "):]
+	default:
+		return nil, errors.New("unknown binary patch hunk: " + lines[0])
+	}
+	inflatedLen, err := strconv.ParseUint(size, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid size of binary patch hunk: " + lines[0])
+	}
+	var compressed []byte
+	i := 1
+	for ; i < len(lines) && lines[i] != ""; i++ {
+		line := lines[i]
+		var length int
+		switch c := line[0]; {
+		case 'A' <= c && c <= 'Z':
+			length = int(c-'A') + 1
+		case 'a' <= c && c <= 'z':
+			length = int(c-'a') + 27
+		default:
+			return nil, errors.New(fmt.Sprintf("invalid length of binary patch line %d", i+1))
+		}
+		decoded, err := decodeBase85(line[1:], length)
+		if err != nil {
+			return nil, err
+		}
+		compressed = append(compressed, decoded...)
+	}
+	if i == len(lines) {
+		return nil, errors.New("binary patch hunk is not terminated")
+	}
+	reader, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) != inflatedLen {
+		return nil, errors.New("binary patch hunk has wrong size")
+	}
+	file.Data = data
+	if file.Type == DiffBinaryDelta {
+		_, targetLength, _, err := decodeHeader(data)
+		if err != nil {
+			return nil, err
+		}
+		file.InflatedLen = targetLength
+	} else {
+		file.InflatedLen = inflatedLen
+	}
+	return lines[i+1:], nil
+}
+
+func (f *DiffBinaryFile) apply(source []byte) ([]byte, error) {
+	var result []byte
+	switch f.Type {
+	case DiffBinaryLiteral:
+		result = f.Data
+	case DiffBinaryDelta:
+		var err error
+		result, err = ApplyDelta(source, f.Data)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, MakeGitError("binary patch has no data", ErrInvalid)
+	}
+	if uint64(len(result)) != f.InflatedLen {
+		return nil, errors.New("binary patch applied to the wrong content")
+	}
+	return result, nil
+}
+
+const base85Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz!#$%&()*+-;<=>?@^_`{|}~"
+
+var base85Decode [256]byte
+
+func init() {
+	for i := 0; i < len(base85Alphabet); i++ {
+		base85Decode[base85Alphabet[i]] = byte(i + 1)
+	}
+}
+
+// encodeBase85 encodes every 4 bytes (zero padded) to 5 characters in the
+// big endian order of git, which is not the one of encoding/ascii85.
+func encodeBase85(data []byte) []byte {
+	result := make([]byte, 0, (len(data)+3)/4*5)
+	for len(data) > 0 {
+		var value uint32
+		for i := 0; i < 4; i++ {
+			value <<= 8
+			if i < len(data) {
+				value |= uint32(data[i])
+			}
+		}
+		var chunk [5]byte
+		for i := 4; i >= 0; i-- {
+			chunk[i] = base85Alphabet[value%85]
+			value /= 85
+		}
+		result = append(result, chunk[:]...)
+		if len(data) < 4 {
+			break
+		}
+		data = data[4:]
+	}
+	return result
+}
+
+func decodeBase85(line string, length int) ([]byte, error) {
+	if len(line) != (length+3)/4*5 {
+		return nil, errors.New("corrupt base85 line")
+	}
+	result := make([]byte, 0, len(line)/5*4)
+	for offset := 0; offset < len(line); offset += 5 {
+		var value uint64
+		for i := 0; i < 5; i++ {
+			digit := base85Decode[line[offset+i]]
+			if digit == 0 {
+				return nil, errors.New(fmt.Sprintf("invalid base85 character '%c'", line[offset+i]))
+			}
+			value = value*85 + uint64(digit-1)
+		}
+		if value > 0xffffffff {
+			return nil, errors.New("base85 overflow")
+		}
+		result = append(result, byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+	}
+	return result[:length], nil
+}
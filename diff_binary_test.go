@@ -0,0 +1,90 @@
+package git4go
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func Test_ParseDiffBinary(t *testing.T) {
+	// made by "git diff --binary"
+	patch := "GIT binary patch\n" +
+		"literal 13\n" +
+		"UcmYew%wtF_s$@z@EJ;)Z03NsmLjV8(\n" +
+		"\n" +
+		"literal 12\n" +
+		"TcmYew%wtF_s$@(_EJ*|a8gK+5\n" +
+		"\n"
+	oldContent := []byte("bin\000ary\001data")
+	newContent := []byte("bin\000ary\002data!")
+
+	binary, err := ParseDiffBinary([]byte(patch))
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if binary.NewFile.Type != DiffBinaryLiteral || binary.NewFile.InflatedLen != 13 {
+		t.Error("it should read literal hunk")
+	}
+	result, err := binary.Apply(oldContent)
+	if err != nil || !bytes.Equal(result, newContent) {
+		t.Error("it should apply patch:", err, result)
+	}
+	result, err = binary.ApplyReverse(newContent)
+	if err != nil || !bytes.Equal(result, oldContent) {
+		t.Error("it should apply reverse patch:", err, result)
+	}
+}
+
+func Test_DiffBinaryDelta(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	oldContent := make([]byte, 8192)
+	random.Read(oldContent)
+	newContent := append([]byte("header"), oldContent[:4096]...)
+	newContent = append(newContent, oldContent[4100:]...)
+
+	binary, err := NewDiffBinary(oldContent, newContent)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if binary.NewFile.Type != DiffBinaryDelta || binary.OldFile.Type != DiffBinaryDelta {
+		t.Error("it should use delta for similar contents")
+	}
+	var buffer bytes.Buffer
+	err = binary.Format(&buffer)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	parsed, err := ParseDiffBinary(buffer.Bytes())
+	if err != nil {
+		t.Fatal("it should parse formatted patch:", err)
+	}
+	result, err := parsed.Apply(oldContent)
+	if err != nil || !bytes.Equal(result, newContent) {
+		t.Error("it should apply delta:", err)
+	}
+	result, err = parsed.ApplyReverse(newContent)
+	if err != nil || !bytes.Equal(result, oldContent) {
+		t.Error("it should apply reverse delta:", err)
+	}
+	_, err = parsed.Apply(newContent)
+	if err == nil {
+		t.Error("it should not apply delta to other content")
+	}
+}
+
+func Test_Base85(t *testing.T) {
+	for length := 1; length <= 52; length++ {
+		data := make([]byte, length)
+		for i := range data {
+			data[i] = byte(i*37 + length)
+		}
+		decoded, err := decodeBase85(string(encodeBase85(data)), length)
+		if err != nil || !bytes.Equal(data, decoded) {
+			t.Error("it should decode encoded data of length", length)
+		}
+	}
+	_, err := decodeBase85("0000\"", 4)
+	if err == nil {
+		t.Error("it should reject invalid character")
+	}
+}
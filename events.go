@@ -0,0 +1,88 @@
+package git4go
+
+type RepositoryEventType int
+
+const (
+	// A reference was written through this Repository
+	RepositoryEventReferenceChanged RepositoryEventType = iota + 1
+	// packed-refs was found to be changed on the disk, by this process or
+	// by another one
+	RepositoryEventPackedRefsChanged
+	// An object that was not in the object database was written through
+	// this Repository
+	RepositoryEventObjectWritten
+	// New packfiles were found in the object database, from WritePack or
+	// from another process
+	RepositoryEventPacksChanged
+)
+
+func (t RepositoryEventType) String() string {
+	switch t {
+	case RepositoryEventReferenceChanged:
+		return "reference changed"
+	case RepositoryEventPackedRefsChanged:
+		return "packed references changed"
+	case RepositoryEventObjectWritten:
+		return "object written"
+	case RepositoryEventPacksChanged:
+		return "packs changed"
+	}
+	return ""
+}
+
+// RepositoryEvent tells that refs or objects changed, so caches that are
+// built above the repository can be invalidated.
+type RepositoryEvent struct {
+	Type RepositoryEventType
+	// The reference of RepositoryEventReferenceChanged
+	ReferenceName string
+	// The object of RepositoryEventObjectWritten
+	Id *Oid
+}
+
+// RepositoryEventCallback receives the events. It is called on the
+// goroutine that made or found the change, sometimes while the repository
+// holds its internal locks, so it must not call the Repository; it should
+// only mark caches as stale or pass the event on, e.g. to a channel.
+type RepositoryEventCallback func(event *RepositoryEvent)
+
+// Subscribe registers the callback for the events of the repository. The
+// returned function removes it again.
+func (r *Repository) Subscribe(callback RepositoryEventCallback) (unsubscribe func()) {
+	r.eventLock.Lock()
+	defer r.eventLock.Unlock()
+
+	id := r.nextSubscriber
+	r.nextSubscriber++
+	r.subscribers = append(r.subscribers, repositorySubscriber{id, callback})
+	return func() {
+		r.eventLock.Lock()
+		defer r.eventLock.Unlock()
+		for i, subscriber := range r.subscribers {
+			if subscriber.id == id {
+				// copy on write: notify may still be iterating over the old slice
+				subscribers := make([]repositorySubscriber, 0, len(r.subscribers)-1)
+				subscribers = append(subscribers, r.subscribers[:i]...)
+				r.subscribers = append(subscribers, r.subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// internal functions and methods
+
+type repositorySubscriber struct {
+	id       int
+	callback RepositoryEventCallback
+}
+
+func (r *Repository) notify(event *RepositoryEvent) {
+	r.eventLock.RLock()
+	subscribers := r.subscribers
+	r.eventLock.RUnlock()
+
+	for _, subscriber := range subscribers {
+		subscriber.callback(event)
+	}
+}
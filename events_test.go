@@ -0,0 +1,54 @@
+package git4go
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_Repository_Subscribe(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_events")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+
+	var events []*RepositoryEvent
+	unsubscribe := repo.Subscribe(func(event *RepositoryEvent) {
+		events = append(events, event)
+	})
+	blob, _ := repo.CreateBlobFromBuffer([]byte("hello\n"))
+	if len(events) != 1 || events[0].Type != RepositoryEventObjectWritten || !events[0].Id.Equal(blob) {
+		t.Fatal("it should report the written object:", events)
+	}
+	repo.CreateBlobFromBuffer([]byte("hello\n"))
+	if len(events) != 1 {
+		t.Error("it should not report the object that already exists")
+	}
+
+	commit := writeMergeCommit(repo, map[string]string{"a.txt": "a\n"})
+	events = nil
+	repo.CreateReference("refs/heads/topic", commit.Id(), true)
+	if len(events) != 1 || events[0].Type != RepositoryEventReferenceChanged || events[0].ReferenceName != "refs/heads/topic" {
+		t.Fatal("it should report the written reference:", events)
+	}
+
+	events = nil
+	packedRefs := filepath.Join(repo.Path(), GitPackedRefsFile)
+	ioutil.WriteFile(packedRefs, []byte(commit.Id().String()+" refs/tags/v1\n"), 0644)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(packedRefs, future, future)
+	if _, err := repo.LookupReference("refs/tags/v1"); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if len(events) != 1 || events[0].Type != RepositoryEventPackedRefsChanged {
+		t.Error("it should report packed-refs that was changed on the disk:", events)
+	}
+
+	events = nil
+	unsubscribe()
+	repo.CreateBlobFromBuffer([]byte("bye\n"))
+	if len(events) != 0 {
+		t.Error("it should not call the removed callback")
+	}
+}
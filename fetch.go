@@ -0,0 +1,443 @@
+package git4go
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FetchOptions controls Remote.Fetch.
+type FetchOptions struct {
+	// The refspecs to fetch, like the ones of "git fetch <remote>
+	// <refspec>...". The fetch refspecs of the remote are used if it is
+	// empty.
+	Refspecs []string
+	// Cut the history at this many commits from the fetched references,
+	// like --depth
+	Depth int
+	// Cut the history at the commits older than this, like --shallow-since
+	DeepenSince time.Time
+	// Cut the history at the commits of these references of the remote,
+	// like --shallow-exclude
+	DeepenNot []string
+	// Which tags are fetched besides the ones of the refspecs.
+	// DownloadTagsUnspecified uses remote.<name>.tagOpt.
+	DownloadTags DownloadTags
+	// Leave out the objects that the filter omits, like --filter. The
+	// remote is recorded as the promisor remote of the partial clone, and
+	// its filter is used again when it is nil.
+	Filter *FilterSpec
+	// Downloads the bundles that the server advertises, if
+	// transfer.bundleURI is set and the transport is a BundleUriTransport,
+	// and the packs of the packfile-uris section, if fetch.uriProtocols is
+	// set. Neither is used if it is nil.
+	Download UriDownloadCallback
+}
+
+// FetchRequest is what Remote.Fetch asks a FetchTransport for.
+type FetchRequest struct {
+	// The objects to download with their history, the "want" lines
+	Wants []*Oid
+	// The shallow roots of the repository, the "shallow" lines
+	Shallow []*Oid
+	// The "deepen", "deepen-since" and "deepen-not" lines
+	Depth       int
+	DeepenSince time.Time
+	DeepenNot   []string
+	// The "filter" line
+	Filter *FilterSpec
+	// "include-tag": the server also sends the annotated tags that point to
+	// the objects of the pack
+	IncludeTag bool
+	// The protocols of the "packfile-uris" line: the server may send
+	// parts of the pack as uris of these protocols
+	PackfileUris []string
+}
+
+// FetchResponse is what the server sent with the pack.
+type FetchResponse struct {
+	// The "shallow" and "unshallow" lines of a fetch with a depth limit
+	Shallow   []*Oid
+	Unshallow []*Oid
+	// The packfile-uris section: the packs that were not sent with the
+	// response and are downloaded after it
+	PackfileUris []*PackfileUri
+}
+
+// Fetch downloads the objects of the references of the remote that the
+// refspecs match, like "git fetch", and updates the local references of
+// the refspecs and FETCH_HEAD. The remote is connected if it is not, and
+// its transport must be a FetchTransport. The haves are chosen by
+// fetch.negotiationAlgorithm, and the pack is stored by an Indexer. Tags
+// are fetched by DownloadTags: with DownloadTagsAuto, the tags that point
+// to the fetched history are stored.
+//
+// With a filter, the objects that it omits are promised by the remote and
+// are fetched by the promised object callback of the Odb when they are
+// read. With a depth limit, the roots that the server cuts the history at
+// are recorded in .git/shallow. A reference that would not be fast-forwarded
+// is not updated unless its refspec forces it; the other references are
+// updated and the fetch fails with ErrNonFastForward then.
+//
+// With a download callback, the bundles that the server advertises are
+// applied before the negotiation, so the server only sends what they miss;
+// a bundle that fails is skipped, like git does. The packs of the
+// packfile-uris section are downloaded after the pack of the response.
+func (r *Remote) Fetch(opts *FetchOptions) error {
+	_, err := r.fetchRefs(opts)
+	return err
+}
+
+// internal functions and methods
+
+// fetchUpdate is a reference of the remote that a fetch stores.
+type fetchUpdate struct {
+	head RemoteHead
+	// the local reference, empty if it is only written to FETCH_HEAD
+	dst   string
+	force bool
+	merge bool
+}
+
+// fetchRefs fetches and returns the entries that it wrote to FETCH_HEAD.
+func (r *Remote) fetchRefs(opts *FetchOptions) ([]*FetchHead, error) {
+	repo := r.repo
+	if repo.readOnly {
+		return nil, errReadOnly("Remote.Fetch")
+	}
+	if opts == nil {
+		opts = &FetchOptions{}
+	}
+	explicit := len(opts.Refspecs) > 0
+	refspecs := opts.Refspecs
+	if !explicit {
+		refspecs = r.fetch
+	}
+	specs := make([]*Refspec, len(refspecs))
+	for i, refspec := range refspecs {
+		spec, err := ParseRefspec(refspec, true)
+		if err != nil {
+			return nil, err
+		}
+		specs[i] = spec
+	}
+
+	if err := r.Connect(ConnectDirectionFetch); err != nil {
+		return nil, err
+	}
+	r.lock.Lock()
+	fetcher, ok := r.transport.(FetchTransport)
+	r.lock.Unlock()
+	if !ok {
+		return nil, MakeGitError(fmt.Sprintf("the transport of remote '%s' cannot fetch", r.name), ErrInvalid)
+	}
+	heads, err := r.Ls()
+	if err != nil {
+		return nil, err
+	}
+	updates, err := r.fetchUpdates(specs, heads, explicit)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Download != nil && repo.UseBundleUris() {
+		if bundler, ok := fetcher.(BundleUriTransport); ok {
+			// the bundles only save work, the fetch gets the objects anyway
+			if list, err := bundler.BundleUris(); err == nil && list != nil {
+				repo.FetchBundles(list, opts.Download)
+			}
+		}
+	}
+
+	downloadTags := opts.DownloadTags
+	if downloadTags == DownloadTagsUnspecified {
+		downloadTags = r.DownloadTags()
+	}
+	if downloadTags == DownloadTagsAll {
+		tags, err := r.TagsToFetch(heads, DownloadTagsAll)
+		if err != nil {
+			return nil, err
+		}
+		updates = appendTagUpdates(updates, tags)
+	}
+
+	odb, err := repo.Odb()
+	if err != nil {
+		return nil, err
+	}
+	request := &FetchRequest{
+		Depth:       opts.Depth,
+		DeepenSince: opts.DeepenSince,
+		DeepenNot:   opts.DeepenNot,
+		Filter:      opts.Filter,
+		IncludeTag:  downloadTags == DownloadTagsAuto,
+	}
+	if opts.Download != nil {
+		request.PackfileUris = repo.PackfileUriProtocols()
+	}
+	if request.Filter == nil {
+		if promisor, filter, err := repo.PromisorRemote(); err == nil && promisor == r.name {
+			request.Filter = filter
+		}
+	}
+	// the server deepens the history of the wants, so they are sent even
+	// if they exist
+	deepen := opts.Depth > 0 || !opts.DeepenSince.IsZero() || len(opts.DeepenNot) > 0
+	wanted := make(map[Oid]bool)
+	for _, update := range updates {
+		id := update.head.Id
+		if !wanted[*id] && (deepen || !odb.Exists(id)) {
+			wanted[*id] = true
+			request.Wants = append(request.Wants, id)
+		}
+	}
+	if len(request.Wants) > 0 {
+		if err = r.download(fetcher, request, heads, opts.Download); err != nil {
+			return nil, err
+		}
+	}
+
+	if downloadTags == DownloadTagsAuto {
+		tags, err := r.TagsToFetch(heads, DownloadTagsAuto)
+		if err != nil {
+			return nil, err
+		}
+		// the tag objects that the server did not include are asked for
+		// again, like git does
+		tagRequest := &FetchRequest{Filter: request.Filter, PackfileUris: request.PackfileUris}
+		for _, tag := range tags {
+			if !odb.Exists(tag.Id) {
+				tagRequest.Wants = append(tagRequest.Wants, tag.Id)
+			}
+		}
+		if len(tagRequest.Wants) > 0 {
+			if err = r.download(fetcher, tagRequest, heads, opts.Download); err != nil {
+				return nil, err
+			}
+		}
+		updates = appendTagUpdates(updates, tags)
+	}
+	return r.storeUpdates(updates)
+}
+
+// appendTagUpdates adds the tags that no refspec stores already.
+func appendTagUpdates(updates []*fetchUpdate, tags []RemoteHead) []*fetchUpdate {
+	stored := make(map[string]bool)
+	for _, update := range updates {
+		stored[update.dst] = true
+	}
+	for _, tag := range tags {
+		if !stored[tag.Name] {
+			updates = append(updates, &fetchUpdate{head: tag, dst: tag.Name})
+		}
+	}
+	return updates
+}
+
+// fetchUpdates matches the advertised heads to the refspecs. The heads of
+// the refspecs that are given to the fetch are for merge, otherwise the
+// upstream of the current branch is, like "git fetch" marks them in
+// FETCH_HEAD.
+func (r *Remote) fetchUpdates(specs []*Refspec, heads []RemoteHead, explicit bool) ([]*fetchUpdate, error) {
+	upstream := ""
+	if !explicit {
+		if head, err := r.repo.LookupReference(GitHeadFile); err == nil && head.Type() == ReferenceSymbolic {
+			remoteName, mergeName, err := r.repo.branchUpstreamConfig(head.SymbolicTarget())
+			if err == nil && remoteName == r.name {
+				upstream = mergeName
+			}
+		}
+	}
+	var updates []*fetchUpdate
+	for _, spec := range specs {
+		matched := false
+		for _, head := range heads {
+			if strings.HasSuffix(head.Name, "^{}") || !fetchRefspecMatches(spec, head.Name) {
+				continue
+			}
+			matched = true
+			update := &fetchUpdate{head: head, force: spec.Force()}
+			if spec.Dst() != "" {
+				update.dst = refspecReplace(spec.Src(), spec.Dst(), head.Name, strings.Contains(spec.Src(), "*"))
+			}
+			if explicit {
+				update.merge = !strings.Contains(spec.Src(), "*")
+			} else {
+				update.merge = head.Name == upstream
+			}
+			updates = append(updates, update)
+		}
+		if !matched && explicit && !strings.Contains(spec.Src(), "*") {
+			return nil, MakeGitError(fmt.Sprintf("couldn't find remote ref %s", spec.Src()), ErrNotFound)
+		}
+	}
+	return updates, nil
+}
+
+// fetchRefspecMatches matches the source of the refspec like git: a name
+// without "refs/" also matches the branch or the tag of that name.
+func fetchRefspecMatches(spec *Refspec, name string) bool {
+	if spec.SrcMatches(name) {
+		return true
+	}
+	src := spec.Src()
+	if strings.HasPrefix(src, "refs/") || strings.Contains(src, "*") {
+		return false
+	}
+	return name == GitRefsHeadsDir+src || name == GitRefsTagsDir+"/"+src
+}
+
+// download negotiates the common commits with the server and stores the
+// pack that it sends, and the packs of its packfile uris. The references
+// that bundles wrote are haves too.
+func (r *Remote) download(fetcher FetchTransport, request *FetchRequest, heads []RemoteHead, downloadUri UriDownloadCallback) error {
+	repo := r.repo
+	odb, err := repo.Odb()
+	if err != nil {
+		return err
+	}
+	request.Shallow, err = repo.ShallowRoots()
+	if err != nil {
+		return err
+	}
+	negotiator, err := repo.NewFetchNegotiator(repo.NegotiationAlgorithm())
+	if err != nil {
+		return err
+	}
+	err = repo.ForEachReference(func(ref *Reference) error {
+		name := ref.Name()
+		if ref.Type() != ReferenceOid || !(strings.HasPrefix(name, GitRefsHeadsDir) || strings.HasPrefix(name, GitRefsRemotesDir) || strings.HasPrefix(name, GitRefsTagsDir+"/") || strings.HasPrefix(name, GitRefsBundlesDir)) {
+			return nil
+		}
+		return negotiator.AddTip(ref.Target())
+	})
+	if err != nil {
+		return err
+	}
+	for _, head := range heads {
+		if odb.Exists(head.Id) {
+			if err = negotiator.KnownCommon(head.Id); err != nil {
+				return err
+			}
+		}
+	}
+	r.lock.Lock()
+	policy := r.retryPolicy
+	r.lock.Unlock()
+	common, err := repo.Negotiate(negotiator, func(haves []*Oid) ([]*Oid, bool, error) {
+		var acks []*Oid
+		var ready bool
+		err := policy.Do(func() error {
+			start := time.Now()
+			var err error
+			acks, ready, err = fetcher.Negotiate(request, haves)
+			traceNet("negotiate", start, err, map[string]interface{}{
+				"remote": r.name,
+				"haves":  len(haves),
+				"acks":   len(acks),
+				"ready":  ready,
+			})
+			return err
+		})
+		return acks, ready, err
+	})
+	if err != nil {
+		return err
+	}
+
+	var indexer *Indexer
+	var response *FetchResponse
+	err = policy.Do(func() error {
+		// a pack that broke off is received again from the start
+		indexer = NewIndexer(filepath.Join(repo.pathCommon, GitObjectsDir, "pack"), odb)
+		start := time.Now()
+		var err error
+		response, err = fetcher.Download(request, common, indexer)
+		traceNet("download pack", start, err, map[string]interface{}{
+			"remote":  r.name,
+			"wants":   len(request.Wants),
+			"objects": indexer.Stats().TotalObjects,
+			"bytes":   indexer.Stats().ReceivedBytes,
+		})
+		addCounter(MetricBytesTransferred, int64(indexer.Stats().ReceivedBytes))
+		if err != nil {
+			indexer.Close()
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = indexer.Commit(); err != nil {
+		return err
+	}
+	if response != nil && len(response.PackfileUris) > 0 {
+		if downloadUri == nil {
+			return MakeGitError("the server sent packfile uris that cannot be downloaded", ErrInvalid)
+		}
+		if err = repo.DownloadPackfileUris(response.PackfileUris, downloadUri, nil); err != nil {
+			return err
+		}
+	}
+	if request.Filter != nil {
+		if err = repo.SetPromisorRemote(r.name, request.Filter); err != nil {
+			return err
+		}
+	}
+	if response != nil && (len(response.Shallow) > 0 || len(response.Unshallow) > 0) {
+		return repo.UpdateShallow(response.Shallow, response.Unshallow)
+	}
+	return nil
+}
+
+// storeUpdates updates the local references and writes FETCH_HEAD.
+func (r *Remote) storeUpdates(updates []*fetchUpdate) ([]*FetchHead, error) {
+	repo := r.repo
+	var rejected []string
+	var fetchHeads []*FetchHead
+	for _, update := range updates {
+		id := update.head.Id
+		if update.dst != "" {
+			ok, err := r.canUpdate(update)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				rejected = append(rejected, update.dst)
+			} else if _, err = repo.CreateReference(update.dst, id, true); err != nil {
+				return nil, err
+			}
+		}
+		refName := update.head.Name
+		if refName == GitHeadFile {
+			refName = ""
+		}
+		fetchHeads = append(fetchHeads, &FetchHead{RefName: refName, RemoteUrl: r.url, Id: id, IsMerge: update.merge})
+	}
+	if err := repo.WriteFetchHead(fetchHeads); err != nil {
+		return nil, err
+	}
+	if len(rejected) > 0 {
+		return fetchHeads, MakeGitError(fmt.Sprintf("rejected non-fast-forward update of '%s'", strings.Join(rejected, "', '")), ErrNonFastForward)
+	}
+	return fetchHeads, nil
+}
+
+// canUpdate tells if the local reference of the update may be moved: it is
+// new, forced, or fast-forwarded. Tags are never moved without force.
+func (r *Remote) canUpdate(update *fetchUpdate) (bool, error) {
+	ref, err := r.repo.LookupReference(update.dst)
+	if IsErrorCode(err, ErrNotFound) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	current := ref.Target()
+	if update.force || current == nil || current.Equal(update.head.Id) {
+		return true, nil
+	}
+	if strings.HasPrefix(update.dst, GitRefsTagsDir+"/") {
+		return false, nil
+	}
+	return r.repo.DescendantOf(update.head.Id, current)
+}
@@ -0,0 +1,513 @@
+package git4go
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFetchTransport serves the references and the objects of a
+// repository.
+type testFetchTransport struct {
+	server   *Repository
+	requests []*FetchRequest
+	// the objects of the last pack
+	sent int
+	// include-tag is ignored
+	noIncludeTag bool
+	// the bundle list that BundleUris returns
+	bundles *BundleList
+	// the blobs are sent as packfile uris, whose packs are stored here
+	uris map[string][]byte
+	// how many negotiation rounds fail with a transient error
+	failures int
+	// how many downloads fail with a transient error after a part of the
+	// pack
+	downloadFailures int
+}
+
+func (t *testFetchTransport) Connect(url string, direction ConnectDirection) error {
+	return nil
+}
+
+func (t *testFetchTransport) Ls() ([]RemoteHead, error) {
+	var heads []RemoteHead
+	if head, err := t.server.Head(); err == nil {
+		heads = append(heads, RemoteHead{Id: head.Target(), Name: GitHeadFile})
+		if head.Name() != GitHeadFile {
+			heads[0].SymrefTarget = head.Name()
+		}
+	}
+	err := t.server.ForEachReference(func(ref *Reference) error {
+		if ref.Type() != ReferenceOid {
+			return nil
+		}
+		heads = append(heads, RemoteHead{Id: ref.Target(), Name: ref.Name()})
+		if tag, err := t.server.LookupTag(ref.Target()); err == nil {
+			heads = append(heads, RemoteHead{Id: tag.TargetId(), Name: ref.Name() + "^{}"})
+		}
+		return nil
+	})
+	return heads, err
+}
+
+func (t *testFetchTransport) Close() error {
+	return nil
+}
+
+func (t *testFetchTransport) BundleUris() (*BundleList, error) {
+	return t.bundles, nil
+}
+
+func (t *testFetchTransport) Negotiate(request *FetchRequest, haves []*Oid) ([]*Oid, bool, error) {
+	t.requests = append(t.requests, request)
+	if t.failures > 0 {
+		t.failures--
+		return nil, false, &TransientError{Err: errors.New("503 Service Unavailable")}
+	}
+	odb, _ := t.server.Odb()
+	var acks []*Oid
+	for _, have := range haves {
+		if odb.Exists(have) {
+			acks = append(acks, have)
+		}
+	}
+	return acks, len(acks) > 0, nil
+}
+
+func (t *testFetchTransport) Download(request *FetchRequest, common []*Oid, pack io.Writer) (*FetchResponse, error) {
+	t.requests = append(t.requests, request)
+	if t.downloadFailures > 0 {
+		t.downloadFailures--
+		pack.Write([]byte("PACK\x00\x00\x00\x02"))
+		return nil, &TransientError{Err: io.ErrUnexpectedEOF}
+	}
+	response := &FetchResponse{}
+	if request.Depth > 0 {
+		// the commits up to the depth, and the roots that cut the history
+		included := make(map[Oid]bool)
+		level := request.Wants
+		for depth := 1; depth <= request.Depth && len(level) > 0; depth++ {
+			var next []*Oid
+			for _, id := range level {
+				if included[*id] {
+					continue
+				}
+				included[*id] = true
+				commit, err := t.server.LookupCommit(id)
+				if err != nil {
+					return nil, err
+				}
+				if depth == request.Depth && commit.ParentCount() > 0 {
+					response.Shallow = append(response.Shallow, id)
+				}
+				for i := 0; i < commit.ParentCount(); i++ {
+					next = append(next, commit.ParentId(i))
+				}
+			}
+			level = next
+		}
+		for _, root := range request.Shallow {
+			if included[*root] && !containsOid(response.Shallow, root) {
+				response.Unshallow = append(response.Unshallow, root)
+			}
+		}
+		pb, _ := t.server.NewPackBuilder()
+		for id := range included {
+			pb.InsertCommit(id.Copy())
+		}
+		t.sent = pb.ObjectCount()
+		_, err := pb.Write(pack)
+		return response, err
+	}
+	objects, err := t.server.EnumerateObjects(request.Wants, common)
+	if err != nil {
+		return nil, err
+	}
+	pb, err := t.server.NewPackBuilder()
+	if err != nil {
+		return nil, err
+	}
+	if request.Filter != nil && request.Filter.Type == FilterBlobNone {
+		var filtered []*EnumeratedObject
+		for _, object := range objects {
+			if object.Type != ObjectBlob {
+				filtered = append(filtered, object)
+			}
+		}
+		objects = filtered
+	}
+	if request.IncludeTag && !t.noIncludeTag {
+		sent := make(map[Oid]bool)
+		for _, object := range objects {
+			sent[*object.Id] = true
+		}
+		t.server.ForEachGlobReference(GitRefsTagsDir+"/*", func(ref *Reference) error {
+			if tag, err := t.server.LookupTag(ref.Target()); err == nil && sent[*tag.TargetId()] {
+				objects = append(objects, &EnumeratedObject{Id: tag.Id(), Type: ObjectTag})
+			}
+			return nil
+		})
+	}
+	if t.uris != nil && len(request.PackfileUris) > 0 {
+		var blobs, others []*EnumeratedObject
+		for _, object := range objects {
+			if object.Type == ObjectBlob {
+				blobs = append(blobs, object)
+			} else {
+				others = append(others, object)
+			}
+		}
+		if len(blobs) > 0 {
+			blobPack, _ := t.server.NewPackBuilder()
+			blobPack.InsertObjects(blobs)
+			var data bytes.Buffer
+			checksum, err := blobPack.Write(&data)
+			if err != nil {
+				return nil, err
+			}
+			uri := "https://cdn.example.com/" + checksum.String() + ".pack"
+			t.uris[uri] = data.Bytes()
+			response.PackfileUris = append(response.PackfileUris, &PackfileUri{Hash: checksum, Uri: uri})
+			objects = others
+		}
+	}
+	pb.InsertObjects(objects)
+	t.sent = pb.ObjectCount()
+	_, err = pb.Write(pack)
+	return response, err
+}
+
+func (t *testFetchTransport) download(uri string) (io.ReadCloser, error) {
+	data, ok := t.uris[uri]
+	if !ok {
+		return nil, errors.New("404 Not Found")
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func containsOid(oids []*Oid, oid *Oid) bool {
+	for _, candidate := range oids {
+		if candidate.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+func prepareFetch(t *testing.T) (*Repository, *Repository, *testFetchTransport, func()) {
+	dir, _ := ioutil.TempDir("", "git4go_fetch")
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	repo.Config().SetString("remote.origin.url", "test://server")
+	repo.Config().SetString("remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*")
+	server, _ := NewInMemoryRepository()
+	transport := &testFetchTransport{server: server}
+	err := RegisterTransport("test://", func(remote *Remote) (Transport, error) {
+		return transport, nil
+	})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	return repo, server, transport, func() {
+		UnregisterTransport("test://")
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_RemoteFetch(t *testing.T) {
+	repo, server, transport, cleanup := prepareFetch(t)
+	defer cleanup()
+	first := writeMergeCommit(server, map[string]string{"a.txt": "a\n"})
+	second := writeMergeCommit(server, map[string]string{"a.txt": "a\n", "b.txt": "b\n"}, first)
+	server.CreateReference("refs/heads/master", second.Id(), true)
+	server.CreateSymbolicReference(GitHeadFile, "refs/heads/master", true)
+
+	remote, _ := repo.LookupRemote("origin")
+	if err := remote.Fetch(nil); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	ref, err := repo.LookupReference("refs/remotes/origin/master")
+	if err != nil || !ref.Target().Equal(second.Id()) {
+		t.Fatal("it should update the references of the refspecs:", err)
+	}
+	if _, err = repo.LookupCommit(first.Id()); err != nil {
+		t.Error("it should store the history of the references:", err)
+	}
+	var fetchHeads []*FetchHead
+	repo.ForEachFetchHead(func(head *FetchHead) error {
+		fetchHeads = append(fetchHeads, head)
+		return nil
+	})
+	if len(fetchHeads) != 1 || fetchHeads[0].RefName != "refs/heads/master" || fetchHeads[0].IsMerge {
+		t.Error("it should write FETCH_HEAD:", fetchHeads)
+	}
+
+	third := writeMergeCommit(server, map[string]string{"a.txt": "a\n", "b.txt": "b\n", "c.txt": "c\n"}, second)
+	server.CreateReference("refs/heads/master", third.Id(), true)
+	transport.requests = nil
+	if err = remote.Fetch(nil); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if ref, _ = repo.LookupReference("refs/remotes/origin/master"); !ref.Target().Equal(third.Id()) {
+		t.Error("it should fast-forward the references")
+	}
+	// the commit, its tree and the new blob
+	if transport.sent != 3 || len(transport.requests) == 0 || len(transport.requests[0].Wants) != 1 {
+		t.Error("it should only download the objects that are new:", transport.sent)
+	}
+
+	other := writeMergeCommit(server, map[string]string{"d.txt": "d\n"})
+	server.CreateReference("refs/heads/master", other.Id(), true)
+	err = remote.Fetch(&FetchOptions{Refspecs: []string{"refs/heads/master:refs/remotes/origin/master"}})
+	if !IsErrorCode(err, ErrNonFastForward) {
+		t.Error("it should reject the updates that are not fast-forwards:", err)
+	}
+	if ref, _ = repo.LookupReference("refs/remotes/origin/master"); !ref.Target().Equal(third.Id()) {
+		t.Error("it should keep the rejected reference")
+	}
+	fetchHeads = nil
+	repo.ForEachFetchHead(func(head *FetchHead) error {
+		fetchHeads = append(fetchHeads, head)
+		return nil
+	})
+	if len(fetchHeads) != 1 || !fetchHeads[0].Id.Equal(other.Id()) || !fetchHeads[0].IsMerge {
+		t.Error("it should mark the heads of the given refspecs for merge:", fetchHeads)
+	}
+	if err = remote.Fetch(&FetchOptions{Refspecs: []string{"missing"}}); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should fail for references that the remote does not have:", err)
+	}
+}
+
+func Test_RemoteFetch_Depth(t *testing.T) {
+	repo, server, transport, cleanup := prepareFetch(t)
+	defer cleanup()
+	first := writeMergeCommit(server, map[string]string{"a.txt": "a\n"})
+	second := writeMergeCommit(server, map[string]string{"b.txt": "b\n"}, first)
+	third := writeMergeCommit(server, map[string]string{"c.txt": "c\n"}, second)
+	server.CreateReference("refs/heads/master", third.Id(), true)
+
+	remote, _ := repo.LookupRemote("origin")
+	if err := remote.Fetch(&FetchOptions{Depth: 2}); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	last := transport.requests[len(transport.requests)-1]
+	if last.Depth != 2 {
+		t.Error("it should send the depth:", last.Depth)
+	}
+	roots, _ := repo.ShallowRoots()
+	if len(roots) != 1 || !roots[0].Equal(second.Id()) {
+		t.Error("it should record the shallow roots that the server sent:", roots)
+	}
+	if _, err := repo.LookupCommit(first.Id()); err == nil {
+		t.Error("it should not download the history below the depth")
+	}
+
+	if err := remote.Fetch(&FetchOptions{Depth: 3}); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	last = transport.requests[len(transport.requests)-1]
+	if len(last.Shallow) != 1 || !last.Shallow[0].Equal(second.Id()) {
+		t.Error("it should send the shallow roots of the repository:", last.Shallow)
+	}
+	if repo.IsShallow() {
+		t.Error("it should remove the roots that the server unshallowed")
+	}
+	if _, err := repo.LookupCommit(first.Id()); err != nil {
+		t.Error("it should deepen the history:", err)
+	}
+
+	remote.Fetch(&FetchOptions{DeepenNot: []string{"refs/tags/v1"}, DeepenSince: first.Committer().When})
+	last = transport.requests[len(transport.requests)-1]
+	if len(last.DeepenNot) != 1 || !last.DeepenSince.Equal(first.Committer().When) || !strings.HasPrefix(last.DeepenNot[0], "refs/tags/") {
+		t.Error("it should send the deepen options:", last.DeepenNot, last.DeepenSince)
+	}
+}
+
+func Test_RemoteFetch_Filter(t *testing.T) {
+	repo, server, transport, cleanup := prepareFetch(t)
+	defer cleanup()
+	first := writeMergeCommit(server, map[string]string{"a.txt": "a\n"})
+	server.CreateReference("refs/heads/master", first.Id(), true)
+
+	remote, _ := repo.LookupRemote("origin")
+	filter, _ := ParseFilterSpec("blob:none")
+	if err := remote.Fetch(&FetchOptions{Filter: filter}); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	last := transport.requests[len(transport.requests)-1]
+	if last.Filter == nil || last.Filter.String() != "blob:none" {
+		t.Error("it should send the filter:", last.Filter)
+	}
+	blobId, _ := hash([]byte("a\n"), ObjectBlob)
+	odb, _ := repo.Odb()
+	if _, err := repo.LookupCommit(first.Id()); err != nil || odb.Exists(blobId) {
+		t.Error("it should store the objects that the filter keeps:", err)
+	}
+	name, recorded, err := repo.PromisorRemote()
+	if err != nil || name != "origin" || recorded.String() != "blob:none" {
+		t.Error("it should record the promisor remote:", name, err)
+	}
+
+	second := writeMergeCommit(server, map[string]string{"b.txt": "b\n"}, first)
+	server.CreateReference("refs/heads/master", second.Id(), true)
+	if err = remote.Fetch(nil); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	last = transport.requests[len(transport.requests)-1]
+	if last.Filter == nil || last.Filter.String() != "blob:none" {
+		t.Error("it should fetch from the promisor remote with its filter:", last.Filter)
+	}
+}
+
+func Test_RemoteFetch_Tags(t *testing.T) {
+	repo, server, transport, cleanup := prepareFetch(t)
+	defer cleanup()
+	first := writeMergeCommit(server, map[string]string{"a.txt": "a\n"})
+	dangling := writeMergeCommit(server, map[string]string{"b.txt": "b\n"})
+	server.CreateReference("refs/heads/master", first.Id(), true)
+	sig := &Signature{"A", "a@example.com", time.Unix(1400000000, 0)}
+	annotated, _ := server.CreateTag("v1", first, sig, "v1\n", false)
+	server.CreateReference("refs/tags/v2", dangling.Id(), true)
+
+	remote, _ := repo.LookupRemote("origin")
+	if err := remote.Fetch(&FetchOptions{DownloadTags: DownloadTagsNone}); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if _, err := repo.LookupReference("refs/tags/v1"); err == nil {
+		t.Error("it should not fetch tags with DownloadTagsNone")
+	}
+
+	transport.noIncludeTag = true
+	if err := remote.Fetch(nil); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	last := transport.requests[len(transport.requests)-1]
+	if ref, err := repo.LookupReference("refs/tags/v1"); err != nil || !ref.Target().Equal(annotated) {
+		t.Fatal("it should follow the tags that point to the fetched history:", err)
+	}
+	if _, err := repo.LookupTag(annotated); err != nil || len(last.Wants) != 1 || !last.Wants[0].Equal(annotated) {
+		t.Error("it should fetch the tag objects that the server did not include:", err)
+	}
+	if _, err := repo.LookupReference("refs/tags/v2"); err == nil {
+		t.Error("it should not follow the tags of other history")
+	}
+
+	repo.Config().SetString("remote.origin.tagOpt", "--tags")
+	if err := remote.Fetch(nil); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if ref, err := repo.LookupReference("refs/tags/v2"); err != nil || !ref.Target().Equal(dangling.Id()) {
+		t.Error("it should fetch all tags with remote.<name>.tagOpt:", err)
+	}
+}
+
+func Test_RemoteFetch_IncludeTag(t *testing.T) {
+	repo, server, transport, cleanup := prepareFetch(t)
+	defer cleanup()
+	first := writeMergeCommit(server, map[string]string{"a.txt": "a\n"})
+	server.CreateReference("refs/heads/master", first.Id(), true)
+	sig := &Signature{"A", "a@example.com", time.Unix(1400000000, 0)}
+	annotated, _ := server.CreateTag("v1", first, sig, "v1\n", false)
+
+	remote, _ := repo.LookupRemote("origin")
+	if err := remote.Fetch(&FetchOptions{DownloadTags: DownloadTagsAuto}); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if len(transport.requests) != 1 || !transport.requests[0].IncludeTag {
+		t.Error("it should ask for the tags in the same request:", len(transport.requests))
+	}
+	if ref, err := repo.LookupReference("refs/tags/v1"); err != nil || !ref.Target().Equal(annotated) {
+		t.Error("it should store the included tags:", err)
+	}
+}
+
+func Test_RemoteFetch_Uris(t *testing.T) {
+	repo, server, transport, cleanup := prepareFetch(t)
+	defer cleanup()
+	first := writeMergeCommit(server, map[string]string{"a.txt": "a\n"})
+	second := writeMergeCommit(server, map[string]string{"a.txt": "a\n", "b.txt": "b\n"}, first)
+	server.CreateReference("refs/heads/master", second.Id(), true)
+	transport.uris = map[string][]byte{
+		"https://cdn.example.com/base.bundle": writeTestBundle(server, first.Id(), nil, "refs/heads/master"),
+	}
+	transport.bundles, _ = ParseBundleList([]string{
+		"bundle.version=1",
+		"bundle.mode=all",
+		"bundle.base.uri=https://cdn.example.com/base.bundle",
+	}, "")
+
+	remote, _ := repo.LookupRemote("origin")
+	if err := remote.Fetch(nil); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if _, err := repo.LookupReference("refs/bundles/heads/master"); err == nil {
+		t.Error("it should not use bundles without a download callback")
+	}
+	if request := transport.requests[0]; len(request.PackfileUris) != 0 {
+		t.Error("it should not ask for packfile uris without a download callback:", request.PackfileUris)
+	}
+
+	dir, _ := ioutil.TempDir("", "git4go_fetch_uris")
+	defer os.RemoveAll(dir)
+	repo, _ = InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	repo.Config().SetString("remote.origin.url", "test://server")
+	repo.Config().SetString("remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*")
+	repo.Config().SetBool("transfer.bundleURI", true)
+	repo.Config().SetString("fetch.uriProtocols", "https")
+	remote, _ = repo.LookupRemote("origin")
+	transport.requests = nil
+	if err := remote.Fetch(&FetchOptions{Download: transport.download}); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if ref, err := repo.LookupReference("refs/bundles/heads/master"); err != nil || !ref.Target().Equal(first.Id()) {
+		t.Error("it should apply the bundles of the server:", err)
+	}
+	if request := transport.requests[0]; len(request.PackfileUris) != 1 || request.PackfileUris[0] != "https" {
+		t.Error("it should ask for packfile uris of fetch.uriProtocols:", request.PackfileUris)
+	}
+	// the commit and its tree, the blob is in the packfile uri
+	if transport.sent != 2 {
+		t.Error("it should not download the objects of the bundles again:", transport.sent)
+	}
+	if ref, err := repo.LookupReference("refs/remotes/origin/master"); err != nil || !ref.Target().Equal(second.Id()) {
+		t.Fatal("it should update the references of the refspecs:", err)
+	}
+	tree, _ := second.Tree()
+	if _, err := repo.LookupBlob(tree.EntryByName("b.txt").Id); err != nil {
+		t.Error("it should download the packs of the packfile uris:", err)
+	}
+}
+
+func Test_RemoteFetch_Retry(t *testing.T) {
+	repo, server, transport, cleanup := prepareFetch(t)
+	defer cleanup()
+	first := writeMergeCommit(server, map[string]string{"a.txt": "a\n"})
+	server.CreateReference("refs/heads/master", first.Id(), true)
+	remote, _ := repo.LookupRemote("origin")
+	remote.Fetch(nil)
+	second := writeMergeCommit(server, map[string]string{"a.txt": "b\n"}, first)
+	server.CreateReference("refs/heads/master", second.Id(), true)
+
+	// one negotiation round fails, then one download
+	transport.failures = 1
+	if err := remote.Fetch(nil); err == nil {
+		t.Error("it should fail without retries")
+	}
+	transport.failures, transport.downloadFailures = 1, 1
+	remote.SetRetryPolicy(&RetryPolicy{MaxRetries: 2, InitialBackoff: time.Millisecond})
+	transport.requests = nil
+	if err := remote.Fetch(nil); err != nil {
+		t.Fatal("it should retry the transient errors:", err)
+	}
+	if len(transport.requests) != 4 {
+		t.Error("it should retry the negotiation and the download:", len(transport.requests))
+	}
+	if _, err := repo.LookupCommit(second.Id()); err != nil {
+		t.Error("it should store the pack of the retried download:", err)
+	}
+}
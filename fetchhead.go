@@ -0,0 +1,170 @@
+package git4go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const GitFetchHeadFile = "FETCH_HEAD"
+
+// FetchHead is an entry of FETCH_HEAD. RefName is empty for the HEAD of
+// the remote.
+type FetchHead struct {
+	RefName   string
+	RemoteUrl string
+	Id        *Oid
+	IsMerge   bool
+}
+
+type FetchHeadForEachCallback func(head *FetchHead) error
+
+// WriteFetchHead replaces FETCH_HEAD with the entries. Entries that are
+// for merge are written first, as git does, so "git pull" style tools
+// merge the first lines.
+func (r *Repository) WriteFetchHead(heads []*FetchHead) error {
+	if r.readOnly {
+		return errReadOnly("Repository.WriteFetchHead")
+	}
+	sorted := make([]*FetchHead, len(heads))
+	copy(sorted, heads)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].IsMerge && !sorted[j].IsMerge
+	})
+	var buffer bytes.Buffer
+	for _, head := range sorted {
+		head.write(&buffer)
+	}
+
+	r.fetchHeadLock.Lock()
+	defer r.fetchHeadLock.Unlock()
+	if r.pathRepository == "" {
+		r.fetchHead = buffer.Bytes()
+		return nil
+	}
+	lock, err := newLockfile(r.fs, filepath.Join(r.pathRepository, GitFetchHeadFile), 0644, DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	_, err = lock.Write(buffer.Bytes())
+	if err != nil {
+		lock.Rollback()
+		return err
+	}
+	return lock.Commit()
+}
+
+// ForEachFetchHead calls the callback for each entry of FETCH_HEAD in the
+// order of the file. It fails with ErrNotFound if nothing was fetched.
+// Returning StopIteration from the callback stops without an error.
+func (r *Repository) ForEachFetchHead(callback FetchHeadForEachCallback) error {
+	data, err := r.readFetchHead()
+	if err != nil {
+		return err
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		head, err := parseFetchHead(line)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid FETCH_HEAD at line %d: %s", i+1, err.Error()))
+		}
+		err = callback(head)
+		if err != nil {
+			return iterationResult(err)
+		}
+	}
+	return nil
+}
+
+// internal functions and methods
+
+func (r *Repository) readFetchHead() ([]byte, error) {
+	r.fetchHeadLock.Lock()
+	defer r.fetchHeadLock.Unlock()
+
+	var data []byte
+	var err error
+	if r.pathRepository == "" {
+		data = r.fetchHead
+	} else {
+		data, err = r.fs.ReadFile(filepath.Join(r.pathRepository, GitFetchHeadFile))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if data == nil {
+		return nil, MakeGitError("could not find FETCH_HEAD", ErrNotFound)
+	}
+	return data, nil
+}
+
+var fetchHeadTypes = []struct {
+	description string
+	prefix      string
+}{
+	{"branch ", GitRefsHeadsDir},
+	{"tag ", GitRefsTagsDir + "/"},
+	{"remote-tracking branch ", GitRefsRemotesDir},
+	{"", ""},
+}
+
+func (h *FetchHead) write(buffer *bytes.Buffer) {
+	buffer.WriteString(h.Id.String())
+	buffer.WriteByte('\t')
+	if !h.IsMerge {
+		buffer.WriteString("not-for-merge")
+	}
+	buffer.WriteByte('\t')
+	if h.RefName == "" || h.RefName == GitHeadFile {
+		buffer.WriteString(h.RemoteUrl)
+	} else {
+		for _, refType := range fetchHeadTypes {
+			if strings.HasPrefix(h.RefName, refType.prefix) {
+				fmt.Fprintf(buffer, "%s'%s' of %s", refType.description, h.RefName[len(refType.prefix):], h.RemoteUrl)
+				break
+			}
+		}
+	}
+	buffer.WriteByte('\n')
+}
+
+func parseFetchHead(line string) (*FetchHead, error) {
+	fields := strings.SplitN(line, "\t", 3)
+	if len(fields) != 3 {
+		return nil, errors.New("missing fields")
+	}
+	oid, err := NewOid(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	head := &FetchHead{Id: oid}
+	switch fields[1] {
+	case "":
+		head.IsMerge = true
+	case "not-for-merge":
+	default:
+		return nil, errors.New("unknown merge flag: " + fields[1])
+	}
+	description := fields[2]
+	for _, refType := range fetchHeadTypes {
+		if !strings.HasPrefix(description, refType.description+"'") {
+			continue
+		}
+		rest := description[len(refType.description)+1:]
+		end := strings.Index(rest, "' of ")
+		if end == -1 {
+			return nil, errors.New("invalid description: " + description)
+		}
+		head.RefName = refType.prefix + rest[:end]
+		head.RemoteUrl = rest[end+len("' of "):]
+		return head, nil
+	}
+	head.RemoteUrl = description
+	return head, nil
+}
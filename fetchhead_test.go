@@ -0,0 +1,74 @@
+package git4go
+
+import (
+	"./testutil"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func Test_WriteFetchHead(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	// the fixture has spaces instead of a tab on the second line
+	if err := repo.ForEachFetchHead(func(*FetchHead) error { return nil }); err == nil {
+		t.Error("it should reject malformed FETCH_HEAD")
+	}
+	master, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	tag, _ := NewOid("b25fa35b38051e4ae45d4222e795f9df2e43f1d1")
+	url := "git://github.com/libgit2/TestGitRepository"
+	err := repo.WriteFetchHead([]*FetchHead{
+		{RefName: "refs/tags/annotated_tag", RemoteUrl: url, Id: tag},
+		{RefName: "refs/heads/master", RemoteUrl: url, Id: master, IsMerge: true},
+		{RefName: "refs/pull/1/head", RemoteUrl: url, Id: master},
+		{RefName: "HEAD", RemoteUrl: url, Id: master},
+	})
+	if err != nil {
+		t.Fatal("it should write FETCH_HEAD:", err)
+	}
+	expected := "a65fedf39aefe402d3bb6e24df4d4f5fe4547750\t\tbranch 'master' of " + url + "\n" +
+		"b25fa35b38051e4ae45d4222e795f9df2e43f1d1\tnot-for-merge\ttag 'annotated_tag' of " + url + "\n" +
+		"a65fedf39aefe402d3bb6e24df4d4f5fe4547750\tnot-for-merge\t'refs/pull/1/head' of " + url + "\n" +
+		"a65fedf39aefe402d3bb6e24df4d4f5fe4547750\tnot-for-merge\t" + url + "\n"
+	data, _ := ioutil.ReadFile(filepath.Join("test_resources/testrepo.git", GitFetchHeadFile))
+	if string(data) != expected {
+		t.Error("it should write entries that are for merge first:", string(data))
+	}
+
+	var heads []*FetchHead
+	err = repo.ForEachFetchHead(func(head *FetchHead) error {
+		heads = append(heads, head)
+		return nil
+	})
+	if err != nil || len(heads) != 4 {
+		t.Fatal("it should read FETCH_HEAD:", len(heads), err)
+	}
+	if heads[0].RefName != "refs/heads/master" || !heads[0].IsMerge || heads[0].RemoteUrl != url || !heads[0].Id.Equal(master) {
+		t.Error("it should read merge entry:", heads[0])
+	}
+	if heads[1].RefName != "refs/tags/annotated_tag" || heads[1].IsMerge {
+		t.Error("it should read tag entry:", heads[1])
+	}
+	if heads[2].RefName != "refs/pull/1/head" || heads[3].RefName != "" || heads[3].RemoteUrl != url {
+		t.Error("it should read other entries:", heads[2], heads[3])
+	}
+}
+
+func Test_ForEachFetchHead_RemoteTrackingBranch(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	if err := repo.ForEachFetchHead(func(*FetchHead) error { return nil }); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should report missing FETCH_HEAD:", err)
+	}
+	oid, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	repo.WriteFetchHead([]*FetchHead{{RefName: "refs/remotes/origin/main", RemoteUrl: "../other", Id: oid}})
+	var name string
+	repo.ForEachFetchHead(func(head *FetchHead) error {
+		name = head.RefName
+		return nil
+	})
+	if name != "refs/remotes/origin/main" {
+		t.Error("it should keep FETCH_HEAD of in-memory repository:", name)
+	}
+}
@@ -1,7 +1,6 @@
 package git4go
 
 import (
-	"bytes"
 	"errors"
 	"strings"
 )
@@ -21,15 +20,23 @@ const (
 	RangeError   RangeMatchResult = -1
 )
 
+// fnMatchX is p_fnmatchx of libgit2. "**" between slashes, or at the end,
+// matches any number of directories even with FNMPathName, like git's
+// wildmatch.
 func fnMatchX(pattern, str string, patternOffset, strOffset int, flags FnMatchFlag, recurs int) (bool, error) {
 	recurs--
 	if recurs == 0 {
 		return false, errors.New("too deep recursion")
 	}
+	recursFlags := flags &^ FNMPeriod
 	initialStrOffset := strOffset
+	period := func() bool {
+		return strOffset < len(str) && str[strOffset] == '.' && (flags&FNMPeriod != 0) &&
+			(strOffset == initialStrOffset || ((flags&FNMPathName != 0) && str[strOffset-1] == '/'))
+	}
 	for {
 		if patternOffset == len(pattern) {
-			if (flags&FNMLeadingDir != 0) && str[0] == '/' {
+			if (flags&FNMLeadingDir != 0) && strOffset < len(str) && str[strOffset] == '/' {
 				return true, nil
 			}
 			return strOffset == len(str), nil
@@ -41,41 +48,47 @@ func fnMatchX(pattern, str string, patternOffset, strOffset int, flags FnMatchFl
 			if strOffset == len(str) {
 				return false, nil
 			}
-			s := str[strOffset]
-			if s == '/' && (flags&FNMPathName != 0) {
+			if str[strOffset] == '/' && (flags&FNMPathName != 0) {
 				return false, nil
 			}
-			if s == '.' && (flags&FNMPeriod != 0) && ((initialStrOffset == strOffset) || ((flags&FNMPathName != 0) && (strOffset != 0) && (str[strOffset-1] == '/'))) {
+			if period() {
 				return false, nil
 			}
 			strOffset++
 		case '*':
-			if patternOffset < len(pattern) {
-				c = pattern[patternOffset]
-				if c == '*' {
-					flags &= FNMPathName
-					for c == '*' && patternOffset < len(pattern) {
-						patternOffset++
-						c = pattern[patternOffset]
+			if patternOffset < len(pattern) && pattern[patternOffset] == '*' {
+				patternOffset++
+				// "**" at the end matches everything
+				if patternOffset == len(pattern) {
+					return true, nil
+				}
+				// "**" must be between slashes
+				if pattern[patternOffset] != '/' {
+					return false, nil
+				}
+				patternOffset++
+				for {
+					matched, err := fnMatchX(pattern, str, patternOffset, strOffset, recursFlags, recurs)
+					if err != nil || matched {
+						return matched, err
 					}
-					if c == '/' {
-						patternOffset++
+					i := strings.IndexByte(str[strOffset:], '/')
+					if i == -1 {
+						return false, nil
 					}
+					strOffset += i + 1
 				}
 			}
-			s := str[strOffset]
-			if s == '.' && (flags&FNMPeriod != 0) && ((initialStrOffset == strOffset) || ((flags&FNMPathName != 0) && (strOffset != 0) && (str[strOffset-1] == '/'))) {
+			if period() {
 				return false, nil
 			}
 			if patternOffset == len(pattern) {
 				if flags&FNMPathName != 0 {
-					return ((flags&FNMLeadingDir != 0) || strings.IndexByte(str[strOffset:], '/') == -1), nil
-				} else {
-					return true, nil
+					return (flags&FNMLeadingDir != 0) || strings.IndexByte(str[strOffset:], '/') == -1, nil
 				}
+				return true, nil
 			}
-			c = pattern[patternOffset]
-			if c == '/' && (flags&FNMPathName != 0) {
+			if pattern[patternOffset] == '/' && (flags&FNMPathName != 0) {
 				i := strings.IndexByte(str[strOffset:], '/')
 				if i == -1 {
 					return false, nil
@@ -85,12 +98,9 @@ func fnMatchX(pattern, str string, patternOffset, strOffset int, flags FnMatchFl
 			}
 			for strOffset < len(str) {
 				test := str[strOffset]
-				r, err := fnMatchX(pattern, str, patternOffset, strOffset, flags, recurs)
-				if err != nil {
-					return false, err
-				}
-				if err == nil && r {
-					return true, nil
+				matched, err := fnMatchX(pattern, str, patternOffset, strOffset, recursFlags, recurs)
+				if err != nil || matched {
+					return matched, err
 				}
 				if test == '/' && (flags&FNMPathName != 0) {
 					break
@@ -102,43 +112,38 @@ func fnMatchX(pattern, str string, patternOffset, strOffset int, flags FnMatchFl
 			if strOffset == len(str) {
 				return false, nil
 			}
-			s := str[strOffset]
-			if s == '/' && (flags&FNMPathName != 0) {
+			if str[strOffset] == '/' && (flags&FNMPathName != 0) {
 				return false, nil
 			}
-			if s == '.' && (flags&FNMPeriod != 0) && (initialStrOffset == strOffset) || ((flags&FNMPathName != 0) && (strOffset != 0) && (str[strOffset-1] == '/')) {
+			if period() {
 				return false, nil
 			}
-			switch rangeMatch(pattern, s, &patternOffset, flags) {
+			switch rangeMatch(pattern, str[strOffset], &patternOffset, flags) {
 			case RangeMatch:
-				break
+				strOffset++
 			case RangeNoMatch:
 				return false, nil
 			case RangeError:
-				if caseInsensitiveMatch(c, str, strOffset, flags) {
-					strOffset++
-				} else {
+				// not a valid range, "[" is a normal character
+				if !caseInsensitiveMatch(c, str, strOffset, flags) {
 					return false, nil
 				}
+				strOffset++
 			}
 		case '\\':
-			if flags&FNMNoEscape == 0 {
-				if patternOffset == len(pattern) {
-					patternOffset--
-					c = '\\'
-				}
+			if flags&FNMNoEscape == 0 && patternOffset < len(pattern) {
+				c = pattern[patternOffset]
+				patternOffset++
 			}
-			if caseInsensitiveMatch(c, str, strOffset, flags) {
-				strOffset++
-			} else {
+			if !caseInsensitiveMatch(c, str, strOffset, flags) {
 				return false, nil
 			}
+			strOffset++
 		default:
-			if caseInsensitiveMatch(c, str, strOffset, flags) {
-				strOffset++
-			} else {
+			if !caseInsensitiveMatch(c, str, strOffset, flags) {
 				return false, nil
 			}
+			strOffset++
 		}
 	}
 }
@@ -154,90 +159,77 @@ func caseInsensitiveMatch(c byte, str string, strOffset int, flags FnMatchFlag)
 	if flags&FNMCaseFold == 0 {
 		return false
 	}
-	in := []byte{c, s}
-	out := bytes.ToLower(in)
-	return out[0] == out[1]
+	return toLower(c) == toLower(s)
 }
 
 func toLower(c byte) byte {
-	in := []byte{c}
-	out := bytes.ToLower(in)
-	return out[0]
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
 }
 
+// rangeMatch matches the bracket expression after the "[" at the offset,
+// which is moved after the "]" if the expression is valid.
 func rangeMatch(pattern string, test byte, originalPatternOffset *int, flags FnMatchFlag) RangeMatchResult {
 	patternOffset := *originalPatternOffset
-	c := pattern[patternOffset]
-
-	negate := (c == '!' || c == '^')
+	at := func(i int) byte {
+		if i < len(pattern) {
+			return pattern[i]
+		}
+		return 0
+	}
+	negate := at(patternOffset) == '!' || at(patternOffset) == '^'
 	if negate {
 		patternOffset++
-		if patternOffset == len(pattern) {
-			return RangeError
-		}
-		c = pattern[patternOffset]
 	}
 	if flags&FNMCaseFold != 0 {
 		test = toLower(test)
 	}
 	ok := false
 	for {
-		if c == '\\' && (flags&FNMNoEscape == 0) {
+		c := at(patternOffset)
+		patternOffset++
+		if c == ']' {
+			break
+		}
+		if c == '\\' && flags&FNMNoEscape == 0 {
+			c = at(patternOffset)
 			patternOffset++
-			if patternOffset == len(pattern) {
-				return RangeError
-			}
-			c = pattern[patternOffset]
 		}
-		if c == '/' && (flags&FNMPathName != 0) {
+		if patternOffset > len(pattern) {
+			return RangeError
+		}
+		if c == '/' && flags&FNMPathName != 0 {
 			return RangeNoMatch
 		}
 		if flags&FNMCaseFold != 0 {
 			c = toLower(c)
 		}
-		if c == '-' {
-			if patternOffset == len(pattern)-2 {
+		if c2 := at(patternOffset + 1); at(patternOffset) == '-' && patternOffset+1 < len(pattern) && c2 != ']' {
+			patternOffset += 2
+			if c2 == '\\' && flags&FNMNoEscape == 0 {
+				c2 = at(patternOffset)
+				patternOffset++
+			}
+			if patternOffset > len(pattern) {
 				return RangeError
 			}
-			c2 := pattern[patternOffset+1]
-			if c2 != ']' {
-				patternOffset += 2
-				if c2 == '\\' && (flags&FNMNoEscape == 0) {
-					patternOffset++
-					if patternOffset == len(pattern) {
-						return RangeError
-					}
-					c2 = pattern[patternOffset]
-				}
-				if flags&FNMCaseFold != 0 {
-					c2 = toLower(c2)
-				}
-				if c <= test && test <= c2 {
-					ok = true
-				}
-			} else if c == test {
+			if flags&FNMCaseFold != 0 {
+				c2 = toLower(c2)
+			}
+			if c <= test && test <= c2 {
 				ok = true
 			}
-
 		} else if c == test {
 			ok = true
 		}
-
-		c = pattern[patternOffset]
-		patternOffset++
-		if c == ']' {
-			break
-		}
-		if patternOffset == len(pattern) {
-			return RangeError
-		}
 	}
 	*originalPatternOffset = patternOffset
 	if ok == negate {
 		return RangeNoMatch
-	} else {
-		return RangeMatch
 	}
+	return RangeMatch
 }
 
 func fnMatch(pattern, str string, flags FnMatchFlag) bool {
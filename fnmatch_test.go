@@ -30,14 +30,20 @@ func TestFnMatch_NoMetaCharacters(t *testing.T) {
 }
 
 func TestFnMatch_Escape(t *testing.T) {
-	// escape
-	if !fnMatch("\\test", "\\test", 0) {
+	// escape: the backslash quotes the next character
+	if !fnMatch("\\test", "test", 0) {
 		t.Error("match error")
 	}
-	if fnMatch("\\test", "test", 0) {
+	if fnMatch("\\test", "\\test", 0) {
 		t.Error("match error")
 	}
-	if fnMatch("\\test", "\\test2", 0) {
+	if !fnMatch("\\*", "*", 0) {
+		t.Error("match error")
+	}
+	if fnMatch("\\*", "a", 0) {
+		t.Error("match error")
+	}
+	if !fnMatch("a\\[b]", "a[b]", 0) {
 		t.Error("match error")
 	}
 
@@ -45,7 +51,7 @@ func TestFnMatch_Escape(t *testing.T) {
 	if !fnMatch("\\test", "\\test", FNMNoEscape) {
 		t.Error("match error")
 	}
-	if !fnMatch("\\test", "\\test", FNMNoEscape) {
+	if fnMatch("\\test", "test", FNMNoEscape) {
 		t.Error("match error")
 	}
 	if fnMatch("\\test", "\\test2", FNMNoEscape) {
@@ -72,8 +78,31 @@ func TestFnMatch_Star(t *testing.T) {
 	if fnMatch("refs/*/awesome", "refs/heads/feature/awesome", FNMPathName) {
 		t.Error("match error")
 	}
-	// todo: bug?
-	//if !fnMatch("refs/**/awesome", "refs/heads/feature/awesome", FNMPathName) {
-	//	t.Error("match error")
-	//}
+	if !fnMatch("refs/**/awesome", "refs/heads/feature/awesome", FNMPathName) {
+		t.Error("match error")
+	}
+	if !fnMatch("refs/**/awesome", "refs/awesome", FNMPathName) {
+		t.Error("match error")
+	}
+	if !fnMatch("**/awesome", "awesome", FNMPathName) || !fnMatch("**/awesome", "a/b/awesome", FNMPathName) {
+		t.Error("match error")
+	}
+	if !fnMatch("refs/**", "refs/heads/master", FNMPathName) || fnMatch("refs/**", "ref", FNMPathName) {
+		t.Error("match error")
+	}
+	if fnMatch("refs/*", "refs", FNMPathName) || !fnMatch("ref*", "ref", FNMPathName) {
+		t.Error("match error")
+	}
+}
+
+func TestFnMatch_Range(t *testing.T) {
+	if !fnMatch("[a-c]x", "bx", 0) || fnMatch("[a-c]x", "dx", 0) {
+		t.Error("match error")
+	}
+	if !fnMatch("[!a-c]x", "dx", 0) || !fnMatch("[A-C]x", "bx", FNMCaseFold) {
+		t.Error("match error")
+	}
+	if !fnMatch("[ab", "[ab", 0) {
+		t.Error("it should match an invalid range as text")
+	}
 }
@@ -0,0 +1,135 @@
+package git4go
+
+import (
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// FileSystem is used to access the git directory and the working directory.
+// The read side has the same methods as io/fs (fs.StatFS, fs.ReadDirFS and
+// fs.ReadFileFS), so it can be passed to fs.WalkDir and friends. Names are
+// the paths that git4go builds with path/filepath, not the slash separated
+// names of io/fs, because repositories are opened by their OS path.
+//
+// Implementations can wrap a virtual file system, restrict access to a
+// chroot, or record calls in tests. Checkout and status call the methods
+// from several goroutines, so implementations must be safe for concurrent
+// use.
+//
+// The pack directory is the exception: packs are memory mapped, so the
+// packs, their indexes, bitmaps and .keep files are listed, read and
+// written with the os package whatever the file system is. Indexer and
+// PackBuilder.WriteToDir write to the disk for the same reason.
+type FileSystem interface {
+	fs.StatFS
+	fs.ReadDirFS
+	fs.ReadFileFS
+	Lstat(name string) (fs.FileInfo, error)
+	Readlink(name string) (string, error)
+	OpenFile(name string, flag int, perm fs.FileMode) (WritableFile, error)
+	MkdirAll(name string, perm fs.FileMode) error
+	Rename(oldName, newName string) error
+	Remove(name string) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Chmod(name string, mode fs.FileMode) error
+	Symlink(oldName, newName string) error
+	// TempFile creates a new file in the directory like ioutil.TempFile.
+	TempFile(dir, pattern string) (TempFile, error)
+}
+
+// WritableFile is returned from FileSystem.OpenFile.
+type WritableFile interface {
+	io.Writer
+	io.Closer
+	Sync() error
+}
+
+// TempFile is returned from FileSystem.TempFile.
+type TempFile interface {
+	WritableFile
+	Name() string
+}
+
+// OSFileSystem is the FileSystem that calls the os package directly. It is
+// used when repositories are opened without a file system.
+var OSFileSystem FileSystem = osFileSystem{}
+
+type osFileSystem struct{}
+
+func (osFileSystem) Open(name string) (fs.File, error) {
+	file, err := os.Open(longPath(name))
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (osFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(longPath(name))
+}
+
+func (osFileSystem) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(longPath(name))
+}
+
+func (osFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(longPath(name))
+}
+
+func (osFileSystem) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(longPath(name))
+}
+
+func (osFileSystem) Readlink(name string) (string, error) {
+	return readLink(name)
+}
+
+func (osFileSystem) OpenFile(name string, flag int, perm fs.FileMode) (WritableFile, error) {
+	file, err := os.OpenFile(longPath(name), flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (osFileSystem) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(longPath(name), perm)
+}
+
+func (osFileSystem) Rename(oldName, newName string) error {
+	return os.Rename(longPath(oldName), longPath(newName))
+}
+
+func (osFileSystem) Remove(name string) error {
+	return os.Remove(longPath(name))
+}
+
+func (osFileSystem) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(longPath(name), atime, mtime)
+}
+
+func (osFileSystem) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(longPath(name), mode)
+}
+
+func (osFileSystem) Symlink(oldName, newName string) error {
+	return os.Symlink(oldName, longPath(newName))
+}
+
+func (osFileSystem) TempFile(dir, pattern string) (TempFile, error) {
+	file, err := ioutil.TempFile(longPath(dir), pattern)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func fileSystemOrDefault(fsys FileSystem) FileSystem {
+	if fsys == nil {
+		return OSFileSystem
+	}
+	return fsys
+}
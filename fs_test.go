@@ -0,0 +1,368 @@
+package git4go
+
+import (
+	"./testutil"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// recordingFileSystem records the names that git4go accesses.
+type recordingFileSystem struct {
+	FileSystem
+	lock  sync.Mutex
+	names []string
+}
+
+func (r *recordingFileSystem) record(name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.names = append(r.names, name)
+}
+
+func (r *recordingFileSystem) accessed(suffix string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, name := range r.names {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *recordingFileSystem) Open(name string) (fs.File, error) {
+	r.record(name)
+	return r.FileSystem.Open(name)
+}
+
+func (r *recordingFileSystem) ReadFile(name string) ([]byte, error) {
+	r.record(name)
+	return r.FileSystem.ReadFile(name)
+}
+
+func Test_OpenRepositoryWithFileSystem(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	fsys := &recordingFileSystem{FileSystem: OSFileSystem}
+	repo, err := OpenRepositoryWithFileSystem("test_resources/testrepo.git", fsys, GIT_REPOSITORY_OPEN_NO_SEARCH)
+	if err != nil {
+		t.Fatal("it should open repository:", err)
+	}
+	if repo.FileSystem() != fsys {
+		t.Error("it should keep the file system")
+	}
+	if !fsys.accessed("config") {
+		t.Error("it should read config through the file system")
+	}
+	_, err = repo.LookupReference("refs/heads/master")
+	if err != nil {
+		t.Fatal("it should read reference:", err)
+	}
+	if !fsys.accessed(filepath.Join("refs", "heads", "master")) {
+		t.Error("it should read reference through the file system")
+	}
+	oid, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	_, err = repo.LookupCommit(oid)
+	if err != nil {
+		t.Error("it should read loose object:", err)
+	}
+	if !fsys.accessed(filepath.Join("a6", "5fedf39aefe402d3bb6e24df4d4f5fe4547750")) {
+		t.Error("it should read loose object through the file system")
+	}
+}
+
+// memFileSystem keeps the files in memory. The names are the OS paths, so
+// it can stand in for a directory on the disk.
+type memFileSystem struct {
+	lock  sync.Mutex
+	files fstest.MapFS
+	temps int
+}
+
+// newMemFileSystem copies the files under root into memory.
+func newMemFileSystem(root string) (*memFileSystem, error) {
+	m := &memFileSystem{files: make(fstest.MapFS)}
+	err := filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		file := &fstest.MapFile{Mode: info.Mode(), ModTime: info.ModTime()}
+		if info.Mode().IsRegular() {
+			if file.Data, err = ioutil.ReadFile(name); err != nil {
+				return err
+			}
+		}
+		m.files[m.key(name)] = file
+		return nil
+	})
+	return m, err
+}
+
+func (m *memFileSystem) key(name string) string {
+	name, _ = filepath.Abs(name)
+	return strings.TrimPrefix(filepath.ToSlash(name), "/")
+}
+
+func (m *memFileSystem) Open(name string) (fs.File, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.files.Open(m.key(name))
+}
+
+func (m *memFileSystem) Stat(name string) (fs.FileInfo, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.files.Stat(m.key(name))
+}
+
+func (m *memFileSystem) Lstat(name string) (fs.FileInfo, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.files.Lstat(m.key(name))
+}
+
+func (m *memFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.files.ReadDir(m.key(name))
+}
+
+func (m *memFileSystem) ReadFile(name string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.files.ReadFile(m.key(name))
+}
+
+func (m *memFileSystem) Readlink(name string) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.files.ReadLink(m.key(name))
+}
+
+func (m *memFileSystem) OpenFile(name string, flag int, perm fs.FileMode) (WritableFile, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := m.key(name)
+	if _, ok := m.files[key]; ok && flag&os.O_EXCL != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	}
+	if dir, ok := m.files[path.Dir(key)]; !ok || !dir.Mode.IsDir() {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	file := &memFile{fsys: m, name: name, mode: perm}
+	if flag&os.O_APPEND != 0 && m.files[key] != nil {
+		file.Write(m.files[key].Data)
+	}
+	return file, nil
+}
+
+func (m *memFileSystem) MkdirAll(name string, perm fs.FileMode) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for key := m.key(name); key != "." && key != ""; key = path.Dir(key) {
+		if file, ok := m.files[key]; ok {
+			if !file.Mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+			}
+			break
+		}
+		m.files[key] = &fstest.MapFile{Mode: fs.ModeDir | perm, ModTime: time.Now()}
+	}
+	return nil
+}
+
+func (m *memFileSystem) Rename(oldName, newName string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	oldKey, newKey := m.key(oldName), m.key(newName)
+	file, ok := m.files[oldKey]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+	}
+	for key, child := range m.files {
+		if strings.HasPrefix(key, oldKey+"/") {
+			delete(m.files, key)
+			m.files[newKey+key[len(oldKey):]] = child
+		}
+	}
+	delete(m.files, oldKey)
+	m.files[newKey] = file
+	return nil
+}
+
+func (m *memFileSystem) Remove(name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := m.key(name)
+	if _, ok := m.files[key]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, key)
+	return nil
+}
+
+func (m *memFileSystem) Chtimes(name string, atime, mtime time.Time) error {
+	return m.update(name, "chtimes", func(file *fstest.MapFile) { file.ModTime = mtime })
+}
+
+func (m *memFileSystem) Chmod(name string, mode fs.FileMode) error {
+	return m.update(name, "chmod", func(file *fstest.MapFile) { file.Mode = file.Mode&fs.ModeType | mode.Perm() })
+}
+
+func (m *memFileSystem) Symlink(oldName, newName string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := m.key(newName)
+	if _, ok := m.files[key]; ok {
+		return &fs.PathError{Op: "symlink", Path: newName, Err: fs.ErrExist}
+	}
+	m.files[key] = &fstest.MapFile{Data: []byte(oldName), Mode: fs.ModeSymlink | 0777, ModTime: time.Now()}
+	return nil
+}
+
+func (m *memFileSystem) TempFile(dir, pattern string) (TempFile, error) {
+	m.lock.Lock()
+	m.temps++
+	name := filepath.Join(dir, fmt.Sprintf("%s%d", pattern, m.temps))
+	m.lock.Unlock()
+	file, err := m.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	// the file exists from now on like with the os package
+	file.Close()
+	return file.(*memFile), nil
+}
+
+// update replaces the file, so the files that are open keep the old one.
+func (m *memFileSystem) update(name, op string, change func(file *fstest.MapFile)) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := m.key(name)
+	file, ok := m.files[key]
+	if !ok {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	copied := *file
+	change(&copied)
+	m.files[key] = &copied
+	return nil
+}
+
+// memFile is written to the file system when it is closed.
+type memFile struct {
+	bytes.Buffer
+	fsys *memFileSystem
+	name string
+	mode fs.FileMode
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Sync() error {
+	return nil
+}
+
+func (f *memFile) Close() error {
+	f.fsys.lock.Lock()
+	defer f.fsys.lock.Unlock()
+	key := f.fsys.key(f.name)
+	mode := f.mode
+	if file, ok := f.fsys.files[key]; ok {
+		mode = file.Mode
+	}
+	f.fsys.files[key] = &fstest.MapFile{Data: append([]byte(nil), f.Bytes()...), Mode: mode, ModTime: time.Now()}
+	return nil
+}
+
+func Test_RepositoryWithMemFileSystem(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_memfs")
+	defer os.RemoveAll(dir)
+	InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	fsys, err := newMemFileSystem(dir)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	repo, err := OpenRepositoryWithFileSystem(dir, fsys, GIT_REPOSITORY_OPEN_NO_SEARCH|GIT_REPOSITORY_OPEN_HERMETIC)
+	if err != nil {
+		t.Fatal("it should open repository:", err)
+	}
+
+	odb, _ := repo.Odb()
+	target, err := odb.Write([]byte("file.txt"), ObjectBlob)
+	if err != nil {
+		t.Fatal("it should write object:", err)
+	}
+	dirName, fileName := target.PathFormat()
+	objectPath := filepath.Join(dir, ".git", "objects", dirName, fileName)
+	if stat, err := fsys.Stat(objectPath); err != nil || stat.Mode().Perm() != os.FileMode(GitObjectFileMode) {
+		t.Error("it should write loose object through the file system:", err)
+	}
+	if _, err := os.Stat(objectPath); !os.IsNotExist(err) {
+		t.Error("it should not write loose object to the disk:", err)
+	}
+	if blob, err := repo.LookupBlob(target); err != nil || string(blob.Contents()) != "file.txt" {
+		t.Error("it should read the object back:", err)
+	}
+
+	file, _ := odb.Write([]byte("content\n"), ObjectBlob)
+	index, _ := NewIndex()
+	index.Add(&IndexEntry{Path: "file.txt", Mode: FilemodeBlob, Id: file})
+	index.Add(&IndexEntry{Path: "link", Mode: FilemodeLink, Id: target})
+	treeId, _ := index.WriteTreeTo(repo)
+	tree, _ := repo.LookupTree(treeId)
+	if err = repo.CheckoutTree(tree, &CheckoutOptions{Force: true}); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	linkPath := filepath.Join(dir, "link")
+	if stat, err := fsys.Lstat(linkPath); err != nil || stat.Mode()&os.ModeSymlink == 0 {
+		t.Error("it should check out symlink through the file system:", err)
+	}
+	if link, _ := fsys.Readlink(linkPath); link != "file.txt" {
+		t.Error("it should check out the target of the link:", link)
+	}
+	if _, err := os.Lstat(linkPath); !os.IsNotExist(err) {
+		t.Error("it should not check out symlink to the disk:", err)
+	}
+}
+
+func Test_InitRepositoryWithMemFileSystem(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_memfs")
+	defer os.RemoveAll(dir)
+	fsys, _ := newMemFileSystem(dir)
+	path := filepath.Join(dir, "repo")
+	repo, err := InitRepositoryExtended(path, &RepositoryInitOptions{Hermetic: true, FileSystem: fsys})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Error("it should not create the repository on the disk:", err)
+	}
+	if data, err := fsys.ReadFile(filepath.Join(path, ".git", "HEAD")); err != nil || string(data) != "ref: refs/heads/master\n" {
+		t.Error("it should write HEAD through the file system:", string(data), err)
+	}
+	if _, err = fsys.Stat(filepath.Join(path, ".git", "description")); err != nil {
+		t.Error("it should write the default templates through the file system:", err)
+	}
+	if repo.FileSystem() != fsys || repo.IsBare() {
+		t.Error("it should open the repository with the file system")
+	}
+	if bare, err := repo.Config().LookupBool("core.bare"); err != nil || bare {
+		t.Error("it should read the config through the file system:", err)
+	}
+}
@@ -0,0 +1,66 @@
+package git4go
+
+import (
+	"testing"
+)
+
+// These targets are run with "go test -fuzz FuzzXxx". They only check that
+// broken input is reported as an error instead of a panic.
+
+func FuzzParseObjectHeader(f *testing.F) {
+	f.Add([]byte("blob 5\x00hello"))
+	f.Add([]byte("commit 0\x00"))
+	f.Add([]byte("tree"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		objType, _, offset, err := parseObjectHeader(data)
+		if err == nil && (objType == ObjectBad || offset > len(data)) {
+			t.Error("it should return error for invalid header:", data)
+		}
+	})
+}
+
+func FuzzParseBinaryObjectHeader(f *testing.F) {
+	f.Add([]byte{0x95, 0x01})
+	f.Add([]byte{0x30})
+	f.Add([]byte{0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _, offset, err := parseBinaryObjectHeader(data)
+		if err == nil && offset > len(data) {
+			t.Error("offset should be in input:", data)
+		}
+	})
+}
+
+func FuzzApplyDelta(f *testing.F) {
+	base := []byte("hello world\n")
+	delta, _ := CreateDelta(base, []byte("hello git world\n"), 0)
+	f.Add(base, delta)
+	f.Add(base, []byte{0x0c, 0x10, 0x91, 0x00, 0x20})
+	f.Fuzz(func(t *testing.T, base, delta []byte) {
+		ApplyDelta(base, delta)
+	})
+}
+
+func FuzzNewCommit(f *testing.F) {
+	f.Add([]byte("tree 50330c02bd4fd95c9db1fcf2f97f4218e42b7226\nparent b51eb250ed0cbda59d3108d04569fab9413909fd\nauthor A U Thor <author@example.com> 1225475778 -0700\ncommitter A U Thor <author@example.com> 1225476305 -0700\nencoding ISO-8859-1\n\nmessage\n"))
+	f.Add([]byte("tree 50330c02bd4fd95c9db1fcf2f97f4218e42b7226\nauthor <> 0 +0000\ncommitter x <y> 1\n\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		newCommit(nil, new(Oid), data)
+	})
+}
+
+func FuzzNewTree(f *testing.F) {
+	oid, _ := NewOid("1a039633309bdb88eb5e6c46d1f8c2ade51f09e6")
+	f.Add(append([]byte("100644 README\x00"), oid[:]...))
+	f.Add([]byte("40000 \x00"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		newTree(nil, new(Oid), data)
+	})
+}
+
+func FuzzNewTag(f *testing.F) {
+	f.Add([]byte("object e90810b8df3e80c413d903f631643c716887138d\ntype commit\ntag v1.0\ntagger A U Thor <author@example.com> 1225475778 -0700\n\nmessage\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		newTag(nil, new(Oid), data)
+	})
+}
@@ -1,16 +1,53 @@
 package git4go
 
+import (
+	"fmt"
+)
+
 type ErrorCode int
 
 const (
 	// Requested object could not be found
 	ErrNotFound ErrorCode = -3
+	// Object exists preventing operation
+	ErrExists ErrorCode = -4
+	// More than one object matches
+	ErrAmbiguous ErrorCode = -5
 	// Operation not allowed on bare repository
 	ErrBareRepository ErrorCode = -8
+	// Merge in progress prevented operation
+	ErrUnmerged ErrorCode = -10
+	// Reference was not fast-forwardable
+	ErrNonFastForward ErrorCode = -11
+	// Name/ref spec was not in a valid format
+	ErrInvalidSpec ErrorCode = -12
+	// Write operation on a repository opened as read-only
+	ErrReadOnly ErrorCode = -13
+	// Lock file prevented operation
+	ErrLocked ErrorCode = -14
+	// Reference value does not match expected
+	ErrModified ErrorCode = -15
+	// Authentication error
+	ErrAuth ErrorCode = -16
+	// Patch/merge has already been applied
+	ErrApplied ErrorCode = -18
+	// Invalid operation or input
+	ErrInvalid ErrorCode = -21
+	// Uncommitted changes in the index or the working directory prevented
+	// operation
+	ErrUncommitted ErrorCode = -22
 	// The operation is not valid for a directory
 	ErrDirectory ErrorCode = -23
+	// A merge conflict exists and cannot continue
+	ErrMergeConflict ErrorCode = -24
 	// Signals end of iteration with iterator
 	ErrIterOver ErrorCode = -31
+	// Input data has a SHA-1 collision attack pattern
+	ErrHashCollision ErrorCode = -40
+	// Object data is truncated or malformed
+	ErrObjectCorrupt ErrorCode = -41
+	// A hook rejected the operation
+	ErrHookFailed ErrorCode = -42
 )
 
 type GitError struct {
@@ -29,9 +66,36 @@ func IsErrorCode(err error, c ErrorCode) bool {
 	if gitError, ok := err.(*GitError); ok {
 		return gitError.Code == c
 	}
+	if corruptError, ok := err.(*ObjectCorruptError); ok {
+		return corruptError.Code == c
+	}
+	if hookError, ok := err.(*HookError); ok {
+		return hookError.Code == c
+	}
 	return false
 }
 
+// ObjectCorruptError is returned when the object is found in the storage
+// but its content can't be decoded.
+type ObjectCorruptError struct {
+	GitError
+	Id   *Oid
+	Path string
+	Err  error
+}
+
+func newObjectCorruptError(oid *Oid, path string, err error) error {
+	return &ObjectCorruptError{
+		GitError: GitError{
+			Message: fmt.Sprintf("object %s is corrupted in '%s': %s", oid.String(), path, err.Error()),
+			Code:    ErrObjectCorrupt,
+		},
+		Id:   oid,
+		Path: path,
+		Err:  err,
+	}
+}
+
 func MakeGitError(message string, errorCode ErrorCode) error {
 	return &GitError{
 		Message: message,
@@ -39,10 +103,26 @@ func MakeGitError(message string, errorCode ErrorCode) error {
 	}
 }
 
+// StopIteration can be returned from the callbacks of the ForEach methods
+// to stop early. The methods return nil then, so stopping is told apart
+// from a real error. To stop when a context is canceled, return ctx.Err()
+// from the callback instead; it is returned as is.
+var StopIteration error = &GitError{Message: "the iteration is stopped by the callback", Code: ErrIterOver}
+
+// iterationResult turns the error of a callback that stops the iteration
+// into nil.
+func iterationResult(err error) error {
+	if IsErrorCode(err, ErrIterOver) {
+		return nil
+	}
+	return err
+}
+
 const (
 	GitOidRawSize                    = 20
 	GitOidHexSize                    = 40
 	GitOidMinimumPrefixLength        = 4
+	GitOidDefaultAbbrevLength        = 7
 	GitObjectDirMode          uint32 = 0777
 	GitObjectFileMode         uint32 = 0444
 )
@@ -52,6 +132,6 @@ func Discover(start string, acrossFs bool, ceilingDirs []string) (string, error)
 	if acrossFs {
 		flags = GIT_REPOSITORY_OPEN_CROSS_FS
 	}
-	repoPath, _, _, err := findRepo(start, flags, ceilingDirs)
+	repoPath, _, _, err := findRepo(OSFileSystem, start, flags, ceilingDirs)
 	return repoPath, err
 }
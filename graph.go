@@ -0,0 +1,353 @@
+package git4go
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MergeBase returns the best common ancestor of the two commits. If there
+// are several, the most recent one is returned. Results are cached, and
+// the generation numbers of the commit-graph order the walk when the
+// repository has one.
+func (r *Repository) MergeBase(one, two *Oid) (*Oid, error) {
+	key := graphCacheKey{query: graphQueryMergeBase, one: *one, two: *two}
+	if bytes.Compare(one[:], two[:]) > 0 {
+		// the merge base of two and one is the same
+		key.one, key.two = *two, *one
+	}
+	if cached := r.graphCache.get(key); cached != nil {
+		return mergeBaseResult(one, two, cached.base)
+	}
+	walk, err := r.graphWalk()
+	if err != nil {
+		return nil, err
+	}
+	bases, err := walk.paintDown(walk.commitLookup(one.Copy()), walk.commitLookup(two.Copy()), 0)
+	if err != nil {
+		return nil, err
+	}
+	entry := &graphCacheEntry{key: key}
+	if len(bases) > 0 {
+		entry.base = bases[0].oid.Copy()
+	}
+	r.graphCache.add(entry)
+	return mergeBaseResult(one, two, entry.base)
+}
+
+// MergeBases returns all best common ancestors of the two commits, newest
+// first. Criss-cross merges leave several of them; none of them can reach
+// another one.
+func (r *Repository) MergeBases(one, two *Oid) ([]*Oid, error) {
+	walk, err := r.graphWalk()
+	if err != nil {
+		return nil, err
+	}
+	bases, err := walk.paintDown(walk.commitLookup(one.Copy()), walk.commitLookup(two.Copy()), 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(bases) == 0 {
+		_, err = mergeBaseResult(one, two, nil)
+		return nil, err
+	}
+	var result []*Oid
+	for i, base := range bases {
+		redundant := false
+		for j, other := range bases {
+			if i == j {
+				continue
+			}
+			if redundant, err = r.DescendantOf(other.oid, base.oid); err != nil {
+				return nil, err
+			} else if redundant {
+				break
+			}
+		}
+		if !redundant {
+			result = append(result, base.oid.Copy())
+		}
+	}
+	return result, nil
+}
+
+// AheadBehind counts the commits that are reachable from local but not
+// from upstream (ahead), and the ones reachable from upstream but not from
+// local (behind). Results are cached like those of MergeBase.
+func (r *Repository) AheadBehind(local, upstream *Oid) (ahead, behind int, err error) {
+	key := graphCacheKey{query: graphQueryAheadBehind, one: *local, two: *upstream}
+	if cached := r.graphCache.get(key); cached != nil {
+		return cached.ahead, cached.behind, nil
+	}
+	walk, err := r.graphWalk()
+	if err != nil {
+		return 0, 0, err
+	}
+	one := walk.commitLookup(local.Copy())
+	two := walk.commitLookup(upstream.Copy())
+	if _, err = walk.paintDown(one, two, 0); err != nil {
+		return 0, 0, err
+	}
+
+	// paintDown parsed and flagged every commit above the merge bases, so
+	// the count stops at the commits that both sides can reach
+	var q commitListNodes
+	q = q.insertByGeneration(one)
+	q = q.insertByGeneration(two)
+	visited := make(map[*commitListNode]bool)
+	for len(q) > 0 {
+		commit := q[0]
+		q = q[1:]
+		if visited[commit] || (commit.flags&(Parent1|Parent2)) == (Parent1|Parent2) {
+			continue
+		}
+		visited[commit] = true
+		if (commit.flags & Parent1) != 0 {
+			ahead++
+		} else if (commit.flags & Parent2) != 0 {
+			behind++
+		}
+		for _, parent := range commit.parents {
+			q = q.insertByGeneration(parent)
+		}
+	}
+	r.graphCache.add(&graphCacheEntry{key: key, ahead: ahead, behind: behind})
+	return ahead, behind, nil
+}
+
+// DescendantOf tells if ancestor can be reached from commit. A commit is
+// not its own descendant. With a commit-graph the walk stops at the
+// commits whose generation numbers are lower than that of ancestor.
+// Results are cached like those of MergeBase.
+func (r *Repository) DescendantOf(commit, ancestor *Oid) (bool, error) {
+	if commit.Equal(ancestor) {
+		return false, nil
+	}
+	key := graphCacheKey{query: graphQueryDescendantOf, one: *commit, two: *ancestor}
+	if cached := r.graphCache.get(key); cached != nil {
+		return cached.descendant, nil
+	}
+	walk, err := r.graphWalk()
+	if err != nil {
+		return false, err
+	}
+	one := walk.commitLookup(commit.Copy())
+	two := walk.commitLookup(ancestor.Copy())
+	if err = walk.commitListParse(two); err != nil {
+		return false, err
+	}
+	if _, err = walk.paintDown(one, two, two.generation); err != nil {
+		return false, err
+	}
+	descendant := (two.flags & Parent1) != 0
+	r.graphCache.add(&graphCacheEntry{key: key, descendant: descendant})
+	return descendant, nil
+}
+
+// CommitChildren is a reverse parent index of the commits that a RevWalk
+// visited. Git stores only the parents of commits, so the children are
+// found by walking from the tips that may contain them.
+type CommitChildren struct {
+	children map[Oid][]*Oid
+	walked   map[Oid]bool
+}
+
+// ChildrenIndex walks the rest of the walk and returns the children of the
+// commits it visited. Push and Hide select the part of the history, e.g.
+// PushGlob("heads") for the local branches. The walk is reset afterwards.
+func (v *RevWalk) ChildrenIndex() (*CommitChildren, error) {
+	index := &CommitChildren{
+		children: make(map[Oid][]*Oid),
+		walked:   make(map[Oid]bool),
+	}
+	for {
+		oid := new(Oid)
+		err := v.Next(oid)
+		if IsErrorCode(err, ErrIterOver) {
+			return index, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		index.walked[*oid] = true
+		for _, parent := range v.commits[*oid].parents {
+			index.children[*parent.oid] = append(index.children[*parent.oid], oid)
+		}
+	}
+}
+
+// Children returns the commits whose parents include the commit, in the
+// order of the walk.
+func (c *CommitChildren) Children(id *Oid) []*Oid {
+	return c.children[*id]
+}
+
+// Descendants returns the commits that can reach the commit, nearest first.
+// The commit itself is not included.
+func (c *CommitChildren) Descendants(id *Oid) []*Oid {
+	var result []*Oid
+	seen := map[Oid]bool{*id: true}
+	queue := []*Oid{id}
+	for len(queue) > 0 {
+		for _, child := range c.children[*queue[0]] {
+			if !seen[*child] {
+				seen[*child] = true
+				result = append(result, child)
+				queue = append(queue, child)
+			}
+		}
+		queue = queue[1:]
+	}
+	return result
+}
+
+// Contains tells if the walk visited the commit.
+func (c *CommitChildren) Contains(id *Oid) bool {
+	return c.walked[*id]
+}
+
+// BranchesContaining returns the names of the local branches whose tips
+// are the commit or its descendants, like "git branch --contains", in the
+// order of the references.
+func (r *Repository) BranchesContaining(id *Oid) ([]string, error) {
+	walk, err := r.Walk()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	var tips []*Oid
+	err = r.ForEachReference(func(ref *Reference) error {
+		if !strings.HasPrefix(ref.Name(), GitRefsHeadsDir) {
+			return nil
+		}
+		resolved, err := ref.Resolve()
+		if err != nil {
+			return nil
+		}
+		object, err := r.Lookup(resolved.Target())
+		if err != nil {
+			return nil
+		}
+		commit, err := object.Peel(ObjectCommit)
+		if err != nil {
+			return nil
+		}
+		names = append(names, ref.Name())
+		tips = append(tips, commit.Id())
+		return walk.Push(commit.Id())
+	})
+	if err != nil || len(tips) == 0 {
+		return nil, err
+	}
+	index, err := walk.ChildrenIndex()
+	if err != nil {
+		return nil, err
+	}
+	containing := map[Oid]bool{*id: true}
+	for _, descendant := range index.Descendants(id) {
+		containing[*descendant] = true
+	}
+	var result []string
+	for i, tip := range tips {
+		if containing[*tip] {
+			result = append(result, names[i])
+		}
+	}
+	return result, nil
+}
+
+// internal functions and methods
+
+// mergeBaseResult returns the merge base, or an ErrNotFound error if there
+// is none.
+func mergeBaseResult(one, two, base *Oid) (*Oid, error) {
+	if base == nil {
+		return nil, MakeGitError(fmt.Sprintf("no merge base found between %s and %s", one.String(), two.String()), ErrNotFound)
+	}
+	return base.Copy(), nil
+}
+
+// graphWalk returns a walk whose paintDown uses the generation numbers of
+// the commit-graph, if it can be trusted.
+func (r *Repository) graphWalk() (*RevWalk, error) {
+	walk, err := r.Walk()
+	if err != nil {
+		return nil, err
+	}
+	walk.generations = r.generationGraph()
+	return walk, nil
+}
+
+// generationGraph returns the commit-graph if its generation numbers hold
+// for the history that walks see: replacements and shallow roots change
+// the parents of commits, but not the commit-graph.
+func (r *Repository) generationGraph() *CommitGraph {
+	if r.IsShallow() {
+		return nil
+	}
+	if odb, err := r.Odb(); err != nil || odb.hasReplacements() {
+		return nil
+	}
+	graph, err := r.CommitGraph()
+	if err != nil {
+		return nil
+	}
+	return graph
+}
+
+// paintDown flags the ancestors of one with Parent1 and the ancestors of
+// two with Parent2 until only commits that both can reach are left, and
+// returns the merge bases that it found, newest first. The commits are
+// taken by generation number; it stops at the commits whose generations
+// are lower than minGeneration, whose ancestors are not needed.
+func (v *RevWalk) paintDown(one, two *commitListNode, minGeneration uint32) (commitListNodes, error) {
+	if one == two {
+		one.flags |= Parent1 | Parent2 | Result
+		return commitListNodes{one}, nil
+	}
+	var q commitListNodes
+	for _, commit := range []*commitListNode{one, two} {
+		err := v.commitListParse(commit)
+		if err != nil {
+			return nil, err
+		}
+		q = q.insertByGeneration(commit)
+	}
+	one.flags |= Parent1
+	two.flags |= Parent2
+
+	var result commitListNodes
+	for q.interesting() {
+		commit := q[0]
+		if commit.generation < minGeneration {
+			break
+		}
+		q = q[1:]
+		flags := commit.flags & (Parent1 | Parent2 | Stale)
+		if flags == (Parent1 | Parent2) {
+			if (commit.flags & Result) == 0 {
+				commit.flags |= Result
+				result = append(result, commit)
+			}
+			// parents of a merge base are reachable from it
+			flags |= Stale
+		}
+		for _, parent := range commit.parents {
+			if (parent.flags & flags) == flags {
+				continue
+			}
+			err := v.commitListParse(parent)
+			if err != nil {
+				return nil, err
+			}
+			parent.flags |= flags
+			q = q.insertByGeneration(parent)
+		}
+	}
+	// the generations may find an older base first
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].time > result[j].time
+	})
+	return result, nil
+}
@@ -0,0 +1,170 @@
+package git4go
+
+import (
+	"./testutil"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_MergeBase(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	one, _ := NewOid("9fd738e8f7967c078dceed8190330fc8648ee56a")
+	two, _ := NewOid("c47800c7266a2be04c571c04d5a6614691ea99bd")
+	base, err := repo.MergeBase(one, two)
+	if err != nil || base.String() != "5b5b025afb0b4c913b4c338a42934a3863bf3644" {
+		t.Error("it should find merge base:", base, err)
+	}
+	base, err = repo.MergeBase(one, one)
+	if err != nil || !base.Equal(one) {
+		t.Error("it should return the commit itself:", base, err)
+	}
+
+	unrelated, _ := NewOid("41bc8c69075bbdb46c5c6f0566cc8cc5b46e8bd9")
+	_, err = repo.MergeBase(one, unrelated)
+	if !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should not find merge base of unrelated histories:", err)
+	}
+}
+
+func Test_AheadBehind(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	local, _ := NewOid("a4a7dce85cf63874e984719f4fdd239f5145052f")
+	upstream, _ := NewOid("c47800c7266a2be04c571c04d5a6614691ea99bd")
+	ahead, behind, err := repo.AheadBehind(local, upstream)
+	if err != nil || ahead != 3 || behind != 0 {
+		t.Error("it should count ahead commits:", ahead, behind, err)
+	}
+	ahead, behind, _ = repo.AheadBehind(upstream, local)
+	if ahead != 0 || behind != 3 {
+		t.Error("it should count behind commits:", ahead, behind)
+	}
+	unrelated, _ := NewOid("41bc8c69075bbdb46c5c6f0566cc8cc5b46e8bd9")
+	ahead, behind, _ = repo.AheadBehind(local, unrelated)
+	if ahead != 6 || behind != 2 {
+		t.Error("it should count unrelated histories:", ahead, behind)
+	}
+}
+
+func Test_MergeBase_Cache(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+	defer SetMetrics(nil)
+
+	metrics := &testMetrics{
+		counters:  make(map[string]int64),
+		durations: make(map[string]int),
+	}
+	SetMetrics(metrics)
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	one, _ := NewOid("9fd738e8f7967c078dceed8190330fc8648ee56a")
+	two, _ := NewOid("c47800c7266a2be04c571c04d5a6614691ea99bd")
+	repo.MergeBase(one, two)
+	base, err := repo.MergeBase(two, one)
+	if err != nil || base.String() != "5b5b025afb0b4c913b4c338a42934a3863bf3644" {
+		t.Error("it should find the cached merge base:", base, err)
+	}
+	unrelated, _ := NewOid("41bc8c69075bbdb46c5c6f0566cc8cc5b46e8bd9")
+	repo.MergeBase(one, unrelated)
+	if _, err = repo.MergeBase(one, unrelated); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should cache that there is no merge base:", err)
+	}
+	if metrics.counters[MetricGraphCacheHits] != 2 || metrics.counters[MetricGraphCacheMisses] != 2 {
+		t.Error("it should count the cache hits and misses:", metrics.counters)
+	}
+	if stats := repo.CacheStatistics(); stats.GraphResults != 2 || stats.GraphResultsSize == 0 {
+		t.Error("it should report the cached results:", stats.GraphResults, stats.GraphResultsSize)
+	}
+	repo.ClearCaches()
+	if stats := repo.CacheStatistics(); stats.GraphResults != 0 {
+		t.Error("it should clear the cached results:", stats.GraphResults)
+	}
+}
+
+func Test_DescendantOf(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	local, _ := NewOid("a4a7dce85cf63874e984719f4fdd239f5145052f")
+	upstream, _ := NewOid("c47800c7266a2be04c571c04d5a6614691ea99bd")
+	unrelated, _ := NewOid("41bc8c69075bbdb46c5c6f0566cc8cc5b46e8bd9")
+	check := func() {
+		if descendant, err := repo.DescendantOf(local, upstream); err != nil || !descendant {
+			t.Error("it should find the ancestor:", descendant, err)
+		}
+		if descendant, _ := repo.DescendantOf(upstream, local); descendant {
+			t.Error("it should not find a descendant in the ancestors")
+		}
+		if descendant, _ := repo.DescendantOf(local, local); descendant {
+			t.Error("it should not be a descendant of itself")
+		}
+		if descendant, _ := repo.DescendantOf(local, unrelated); descendant {
+			t.Error("it should not find unrelated commits")
+		}
+		if ahead, behind, _ := repo.AheadBehind(local, unrelated); ahead != 6 || behind != 2 {
+			t.Error("it should count the same commits:", ahead, behind)
+		}
+	}
+	check()
+
+	// the generation numbers of the commit-graph give the same results
+	if err := repo.WriteCommitGraph(nil); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	repo.ClearCaches()
+	walk, _ := repo.graphWalk()
+	if walk.generations == nil {
+		t.Fatal("it should use the commit-graph")
+	}
+	check()
+	base, err := repo.MergeBase(local, upstream)
+	if err != nil || !base.Equal(upstream) {
+		t.Error("it should find the merge base with the commit-graph:", base, err)
+	}
+}
+
+func Test_ChildrenIndex(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_graph")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	base := writeMergeCommit(repo, map[string]string{"a.txt": "base\n"})
+	one := writeMergeCommit(repo, map[string]string{"a.txt": "one\n"}, base)
+	two := writeMergeCommit(repo, map[string]string{"a.txt": "two\n"}, base)
+	merge := writeMergeCommit(repo, map[string]string{"a.txt": "merge\n"}, one, two)
+	repo.CreateReference("refs/heads/master", merge.Id(), true)
+	repo.CreateReference("refs/heads/one", one.Id(), true)
+	repo.CreateReference("refs/heads/two", two.Id(), true)
+
+	walk, _ := repo.Walk()
+	walk.Push(merge.Id())
+	index, err := walk.ChildrenIndex()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if children := index.Children(base.Id()); len(children) != 2 {
+		t.Error("it should find the children of the commit:", children)
+	}
+	if children := index.Children(merge.Id()); len(children) != 0 || !index.Contains(merge.Id()) {
+		t.Error("it should find no children of the tip")
+	}
+	if descendants := index.Descendants(base.Id()); len(descendants) != 3 || !descendants[2].Equal(merge.Id()) {
+		t.Error("it should find the descendants, nearest first:", descendants)
+	}
+
+	branches, err := repo.BranchesContaining(one.Id())
+	if err != nil || len(branches) != 2 || branches[0] != "refs/heads/master" || branches[1] != "refs/heads/one" {
+		t.Error("it should find the branches that contain the commit:", branches, err)
+	}
+	branches, _ = repo.BranchesContaining(base.Id())
+	if len(branches) != 3 {
+		t.Error("it should find all branches:", branches)
+	}
+}
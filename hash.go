@@ -0,0 +1,52 @@
+package git4go
+
+import (
+	"crypto/sha1"
+	"github.com/pjbgf/sha1cd"
+	gohash "hash"
+	"sync/atomic"
+)
+
+type HashAlgorithm int32
+
+const (
+	// Plain SHA-1 (crypto/sha1)
+	HashSha1 HashAlgorithm = 0
+	// SHA-1 with collision detection (same as git's sha1dc)
+	HashSha1CollisionDetection HashAlgorithm = 1
+)
+
+var hashAlgorithm int32 = int32(HashSha1)
+
+// SetHashAlgorithm selects the SHA-1 implementation that is used to compute
+// object ids. With HashSha1CollisionDetection, hashing input that carries a
+// known collision attack pattern fails with ErrHashCollision instead of
+// returning an id.
+func SetHashAlgorithm(algorithm HashAlgorithm) {
+	atomic.StoreInt32(&hashAlgorithm, int32(algorithm))
+}
+
+func GetHashAlgorithm() HashAlgorithm {
+	return HashAlgorithm(atomic.LoadInt32(&hashAlgorithm))
+}
+
+func newHasher() gohash.Hash {
+	if GetHashAlgorithm() == HashSha1CollisionDetection {
+		return sha1cd.New()
+	}
+	return sha1.New()
+}
+
+func sumHasher(h gohash.Hash) (*Oid, error) {
+	oid := new(Oid)
+	if cd, ok := h.(sha1cd.CollisionResistantHash); ok {
+		sum, collision := cd.CollisionResistantSum(nil)
+		if collision {
+			return nil, MakeGitError("SHA-1 collision attack detected", ErrHashCollision)
+		}
+		copy(oid[:], sum)
+		return oid, nil
+	}
+	copy(oid[:], h.Sum(nil))
+	return oid, nil
+}
@@ -0,0 +1,137 @@
+package git4go
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// HashObjectsOptions controls Repository.HashObjects.
+type HashObjectsOptions struct {
+	// The type of the objects. Zero hashes blobs.
+	Type ObjectType
+	// Write the objects to the object database like "git hash-object -w"
+	Write bool
+	// Hash the content as it is, without the conversions of .gitattributes
+	// and core.autocrlf for its path, like --no-filters
+	NoFilters bool
+	// Skip the format checks of trees, commits and tags, like --literally
+	Literally bool
+	// The number of goroutines. 0 uses the number of CPUs.
+	Workers int
+}
+
+// HashSource is an input of HashObjects: the content of the reader, or of
+// the file of the path if the reader is nil. The path selects the filters
+// of blobs, and is relative to the working directory if it is not absolute.
+type HashSource struct {
+	Path   string
+	Reader io.Reader
+}
+
+// HashObjects returns the ids of the sources like "git hash-object", in the
+// order of the sources. The sources are read and hashed by a pool of
+// goroutines that reuse their buffers, so a whole tree can be hashed
+// without an allocation per file. Each reader is read by one goroutine.
+// The first error in the order of the sources is returned.
+func (r *Repository) HashObjects(sources []*HashSource, opts *HashObjectsOptions) ([]*Oid, error) {
+	if opts == nil {
+		opts = &HashObjectsOptions{}
+	}
+	objType := opts.Type
+	if objType == 0 {
+		objType = ObjectBlob
+	}
+	if objType != ObjectBlob && objType != ObjectTree && objType != ObjectCommit && objType != ObjectTag {
+		return nil, MakeGitError(fmt.Sprintf("invalid object type %d", objType), ErrInvalid)
+	}
+	odb, err := r.Odb()
+	if err != nil {
+		return nil, err
+	}
+	workers := r.parallelWorkers(opts.Workers, "", len(sources))
+	buffers := make([]bytes.Buffer, workers)
+	oids := make([]*Oid, len(sources))
+	err = parallelFor(workers, len(sources), func(worker, i int) error {
+		buffer := &buffers[worker]
+		buffer.Reset()
+		content, err := r.hashSourceContent(sources[i], objType, opts.NoFilters, buffer)
+		if err != nil {
+			return err
+		}
+		if objType != ObjectBlob && !opts.Literally {
+			if err = odb.checkNewObject(content, objType); err != nil {
+				return err
+			}
+		}
+		if opts.Write {
+			oids[i], err = odb.Write(content, objType)
+		} else {
+			oids[i], err = hash(content, objType)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return oids, nil
+}
+
+// HashPaths hashes the files of the paths like "git hash-object
+// --stdin-paths".
+func (r *Repository) HashPaths(paths []string, opts *HashObjectsOptions) ([]*Oid, error) {
+	sources := make([]*HashSource, len(paths))
+	for i, path := range paths {
+		sources[i] = &HashSource{Path: path}
+	}
+	return r.HashObjects(sources, opts)
+}
+
+// internal functions and methods
+
+// hashSourceContent reads the source into the buffer and returns the
+// content to hash.
+func (r *Repository) hashSourceContent(source *HashSource, objType ObjectType, noFilters bool, buffer *bytes.Buffer) ([]byte, error) {
+	fullPath := source.Path
+	relPath := filepath.ToSlash(source.Path)
+	if source.Path != "" && !filepath.IsAbs(source.Path) {
+		if r.IsBare() {
+			if source.Reader == nil {
+				return nil, MakeGitError("Repository should not be bare", ErrBareRepository)
+			}
+		} else {
+			fullPath = filepath.Join(r.Workdir(), source.Path)
+		}
+	} else if source.Path != "" {
+		// absolute paths out of the working directory have no filters
+		relPath = ""
+		if !r.IsBare() {
+			if rel, err := filepath.Rel(r.Workdir(), source.Path); err == nil && !strings.HasPrefix(rel, "..") {
+				relPath = filepath.ToSlash(rel)
+			}
+		}
+	}
+
+	reader := source.Reader
+	if reader == nil {
+		if source.Path == "" {
+			return nil, MakeGitError("a source needs a reader or a path", ErrInvalid)
+		}
+		file, err := r.fs.Open(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+	if _, err := buffer.ReadFrom(reader); err != nil {
+		return nil, err
+	}
+	content := buffer.Bytes()
+	if objType == ObjectBlob && !noFilters && relPath != "" {
+		return r.ConvertToOdb(relPath, content)
+	}
+	return content, nil
+}
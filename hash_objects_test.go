@@ -0,0 +1,94 @@
+package git4go
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_HashObjects(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_hash_objects")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	repo.Config().SetString("core.autocrlf", "true")
+	ioutil.WriteFile(filepath.Join(dir, "crlf.txt"), []byte("a\r\nb\r\n"), 0644)
+
+	lf, _ := hash([]byte("a\nb\n"), ObjectBlob)
+	crlf, _ := hash([]byte("a\r\nb\r\n"), ObjectBlob)
+	oids, err := repo.HashPaths([]string{"crlf.txt", filepath.Join(dir, "crlf.txt")}, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if !oids[0].Equal(lf) || !oids[1].Equal(lf) {
+		t.Error("it should hash the files in the form that git stores them:", oids)
+	}
+	odb, _ := repo.Odb()
+	if odb.Exists(lf) {
+		t.Error("it should not write the objects by default")
+	}
+	oids, err = repo.HashObjects([]*HashSource{
+		{Path: "crlf.txt", Reader: strings.NewReader("c\r\n")},
+		{Reader: strings.NewReader("a\r\nb\r\n")},
+		{Path: "crlf.txt"},
+	}, &HashObjectsOptions{Write: true})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	c, _ := hash([]byte("c\n"), ObjectBlob)
+	if !oids[0].Equal(c) || !oids[1].Equal(crlf) || !oids[2].Equal(lf) {
+		t.Error("it should hash the readers with the filters of their path:", oids)
+	}
+	if !odb.Exists(c) || !odb.Exists(crlf) || !odb.Exists(lf) {
+		t.Error("it should write the objects")
+	}
+	oids, _ = repo.HashPaths([]string{"crlf.txt"}, &HashObjectsOptions{NoFilters: true})
+	if !oids[0].Equal(crlf) {
+		t.Error("it should hash the file as it is without the filters:", oids)
+	}
+
+	if _, err = repo.HashPaths([]string{"crlf.txt", "missing.txt"}, nil); !os.IsNotExist(err) {
+		t.Error("it should fail for missing files:", err)
+	}
+	tree := []*HashSource{{Reader: strings.NewReader("not a tree")}}
+	if _, err = repo.HashObjects(tree, &HashObjectsOptions{Type: ObjectTree}); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should check the format of the objects:", err)
+	}
+	tree = []*HashSource{{Reader: strings.NewReader("not a tree")}}
+	if _, err = repo.HashObjects(tree, &HashObjectsOptions{Type: ObjectTree, Literally: true}); err != nil {
+		t.Error("it should not check the format with Literally:", err)
+	}
+}
+
+func Test_HashObjects_Parallel(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_hash_objects")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	var paths []string
+	for i := 0; i < 500; i++ {
+		path := fmt.Sprintf("dir%d/file%d.txt", i%10, i)
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0755)
+		ioutil.WriteFile(filepath.Join(dir, path), []byte(strings.Repeat(fmt.Sprintf("line %d\n", i), i)), 0644)
+		paths = append(paths, path)
+	}
+	sequential, err := repo.HashPaths(paths, &HashObjectsOptions{Workers: 1})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	parallel, err := repo.HashPaths(paths, &HashObjectsOptions{Workers: 8, Write: true})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	for i := range paths {
+		expected, _ := hash([]byte(strings.Repeat(fmt.Sprintf("line %d\n", i), i)), ObjectBlob)
+		if !sequential[i].Equal(expected) || !parallel[i].Equal(expected) {
+			t.Fatal("it should hash each file in the order of the paths:", paths[i])
+		}
+	}
+	blob, err := repo.LookupBlob(parallel[499])
+	if err != nil || len(blob.Contents()) != len("line 499\n")*499 {
+		t.Error("it should write the objects from the shared buffers:", err)
+	}
+}
@@ -0,0 +1,54 @@
+package git4go
+
+import (
+	"./testutil"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_HashCollisionDetection_SameId(t *testing.T) {
+	defer SetHashAlgorithm(HashSha1)
+
+	for _, entry := range []*testutil.ObjectData{&testutil.One, &testutil.Commit, &testutil.Tree} {
+		SetHashAlgorithm(HashSha1)
+		oid1, err := hash(entry.Data, TypeString2Type(entry.Type))
+		if err != nil {
+			t.Error("err should be nil:", err)
+		}
+		SetHashAlgorithm(HashSha1CollisionDetection)
+		oid2, err := hash(entry.Data, TypeString2Type(entry.Type))
+		if err != nil {
+			t.Error("err should be nil:", err)
+		}
+		if oid1 == nil || oid2 == nil || !oid1.Equal(oid2) || oid2.String() != entry.Id {
+			t.Error("both algorithms should compute same id:", entry.Name)
+		}
+	}
+}
+
+func Test_HashCollisionDetection_Detect(t *testing.T) {
+	defer SetHashAlgorithm(HashSha1)
+	data, err := ioutil.ReadFile("test_resources/collision/sha-mbles-1.bin")
+	if err != nil {
+		t.Fatal("can't read test data:", err)
+	}
+
+	SetHashAlgorithm(HashSha1)
+	h := newHasher()
+	h.Write(data)
+	_, err = sumHasher(h)
+	if err != nil {
+		t.Error("plain SHA-1 should not detect collision:", err)
+	}
+
+	SetHashAlgorithm(HashSha1CollisionDetection)
+	h = newHasher()
+	h.Write(data)
+	oid, err := sumHasher(h)
+	if oid != nil {
+		t.Error("oid should be nil")
+	}
+	if !IsErrorCode(err, ErrHashCollision) {
+		t.Error("err should be ErrHashCollision:", err)
+	}
+}
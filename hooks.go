@@ -0,0 +1,231 @@
+package git4go
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+const gitHookSampleSuffix = ".sample"
+
+// HooksPath returns the directory of the hooks. It is core.hooksPath if it
+// is set, otherwise the hooks directory of the git directory. A relative
+// core.hooksPath is relative to the working directory, or to the git
+// directory of a bare repository, where git runs the hooks.
+func (r *Repository) HooksPath() string {
+	if config := r.Config(); config != nil {
+		if path, err := config.LookupString("core.hooksPath"); err == nil && path != "" {
+			path = expandHomeDir(path)
+			if !filepath.IsAbs(path) {
+				base := r.Workdir()
+				if base == "" {
+					base = r.pathCommon
+				}
+				path = filepath.Join(base, path)
+			}
+			return filepath.Clean(path)
+		}
+	}
+	return filepath.Join(r.pathCommon, GitHooksDir)
+}
+
+// InstallHook writes the script as the hook, like "pre-receive", and makes
+// it executable. An installed hook is replaced; the new script is written
+// to a lockfile first, so a hook never runs half written.
+func (r *Repository) InstallHook(name string, script []byte) error {
+	if r.readOnly {
+		return errReadOnly("Repository.InstallHook")
+	}
+	if err := checkHookName(name); err != nil {
+		return err
+	}
+	dir := r.HooksPath()
+	err := r.fs.MkdirAll(dir, os.FileMode(GitObjectDirMode))
+	if err != nil {
+		return err
+	}
+	lock, err := newLockfile(r.fs, filepath.Join(dir, name), 0777, DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	_, err = lock.Write(script)
+	if err != nil {
+		lock.Rollback()
+		return err
+	}
+	return lock.Commit()
+}
+
+// RemoveHook deletes the hook. It fails with ErrNotFound if the hook is not
+// installed.
+func (r *Repository) RemoveHook(name string) error {
+	if r.readOnly {
+		return errReadOnly("Repository.RemoveHook")
+	}
+	if err := checkHookName(name); err != nil {
+		return err
+	}
+	err := r.fs.Remove(filepath.Join(r.HooksPath(), name))
+	if os.IsNotExist(err) {
+		return MakeGitError(fmt.Sprintf("hook '%s' is not installed", name), ErrNotFound)
+	}
+	return err
+}
+
+// Hooks is the access to the hooks of a repository.
+type Hooks struct {
+	repo *Repository
+}
+
+// HookOptions are the inputs of a hook.
+type HookOptions struct {
+	Args []string
+	// The standard input, e.g. the "<old> <new> <ref>" lines of pre-receive
+	Stdin []byte
+	// Variables that are added to the environment of the process, like
+	// "GIT_PUSH_CERT=..."
+	Env []string
+}
+
+// HookResult is the outcome of a hook that was run.
+type HookResult struct {
+	Name     string
+	Path     string
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+}
+
+// HookError is returned when a hook exits with a non-zero code. Its code is
+// ErrHookFailed, and the result has the output of the hook for the user.
+type HookError struct {
+	GitError
+	Result *HookResult
+}
+
+// Hooks returns the hooks of the repository.
+func (r *Repository) Hooks() *Hooks {
+	return &Hooks{repo: r}
+}
+
+// Path returns the directory of the hooks, like Repository.HooksPath.
+func (h *Hooks) Path() string {
+	return h.repo.HooksPath()
+}
+
+// List returns the names of the installed hooks in sorted order. Samples
+// (*.sample) that the templates bring are not hooks.
+func (h *Hooks) List() ([]string, error) {
+	r := h.repo
+	entries, err := r.fs.ReadDir(r.HooksPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), gitHookSampleSuffix) {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Exists tells if the hook is installed and can be run. Like git, a hook
+// that is not executable is ignored.
+func (h *Hooks) Exists(name string) bool {
+	if checkHookName(name) != nil {
+		return false
+	}
+	info, err := h.repo.fs.Stat(filepath.Join(h.repo.HooksPath(), name))
+	if err != nil || info.IsDir() {
+		return false
+	}
+	return runtime.GOOS == "windows" || info.Mode()&0111 != 0
+}
+
+// Run runs the hook like git: in the working directory, or in the git
+// directory of a bare repository, with GIT_DIR in the environment. The
+// output is captured. The result is nil if the hook is not installed, and
+// a non-zero exit code fails with a *HookError that has the result. Hooks
+// can only be run in repositories on the OS file system.
+func (h *Hooks) Run(name string, opts *HookOptions) (*HookResult, error) {
+	r := h.repo
+	if !h.Exists(name) {
+		return nil, nil
+	}
+	if r.fs != OSFileSystem {
+		return nil, MakeGitError(fmt.Sprintf("cannot run the hook '%s' on a virtual file system", name), ErrInvalid)
+	}
+	if opts == nil {
+		opts = &HookOptions{}
+	}
+	path := filepath.Join(r.HooksPath(), name)
+	var stdout, stderr bytes.Buffer
+	command := exec.Command(path, opts.Args...)
+	command.Dir = r.Workdir()
+	if command.Dir == "" {
+		command.Dir = r.Path()
+	}
+	command.Env = append(os.Environ(), "GIT_DIR="+r.Path())
+	command.Env = append(command.Env, opts.Env...)
+	command.Stdin = bytes.NewReader(opts.Stdin)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	err := command.Run()
+	result := &HookResult{
+		Name:   name,
+		Path:   path,
+		Stdout: stdout.Bytes(),
+		Stderr: stderr.Bytes(),
+	}
+	if exitError, ok := err.(*exec.ExitError); ok {
+		result.ExitCode = exitError.ExitCode()
+		return result, newHookError(result)
+	} else if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// internal functions
+
+func newHookError(result *HookResult) error {
+	message := fmt.Sprintf("the %s hook failed with exit code %d", result.Name, result.ExitCode)
+	if stderr := strings.TrimSpace(string(result.Stderr)); stderr != "" {
+		message += ": " + stderr
+	}
+	return &HookError{
+		GitError: GitError{
+			Message: message,
+			Code:    ErrHookFailed,
+		},
+		Result: result,
+	}
+}
+
+// runHook runs the hook unless noVerify is set, for operations that have an
+// option like --no-verify.
+func (r *Repository) runHook(name string, noVerify bool, opts *HookOptions) error {
+	if noVerify {
+		return nil
+	}
+	_, err := r.Hooks().Run(name, opts)
+	return err
+}
+
+func checkHookName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") ||
+		strings.HasSuffix(name, gitHookSampleSuffix) || strings.HasSuffix(name, GitLockFileSuffix) {
+		return MakeGitError(fmt.Sprintf("invalid hook name '%s'", name), ErrInvalid)
+	}
+	return nil
+}
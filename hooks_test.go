@@ -0,0 +1,127 @@
+package git4go
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_InstallHook(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_hooks")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{Bare: true, NoTemplate: true})
+
+	err := repo.InstallHook("post-receive", []byte("#!/bin/sh\necho one\n"))
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	err = repo.InstallHook("post-receive", []byte("#!/bin/sh\necho two\n"))
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	path := filepath.Join(dir, "hooks", "post-receive")
+	script, _ := ioutil.ReadFile(path)
+	if string(script) != "#!/bin/sh\necho two\n" {
+		t.Errorf("the hook should be replaced: %q", string(script))
+	}
+	stat, _ := os.Stat(path)
+	if stat.Mode()&0100 == 0 {
+		t.Error("the hook should be executable:", stat.Mode())
+	}
+	ioutil.WriteFile(filepath.Join(dir, "hooks", "update.sample"), []byte("#!/bin/sh\n"), 0755)
+	repo.InstallHook("pre-receive", []byte("#!/bin/sh\n"))
+	hooks, _ := repo.Hooks().List()
+	if len(hooks) != 2 || hooks[0] != "post-receive" || hooks[1] != "pre-receive" {
+		t.Error("wrong hooks:", hooks)
+	}
+
+	if err := repo.RemoveHook("pre-receive"); err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if err := repo.RemoveHook("pre-receive"); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should fail when the hook is not installed:", err)
+	}
+	for _, name := range []string{"", "..", "../config", "update.sample"} {
+		if err := repo.InstallHook(name, nil); !IsErrorCode(err, ErrInvalid) {
+			t.Errorf("it should reject %q: %v", name, err)
+		}
+	}
+}
+
+func Test_HooksPath(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_hooks")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true})
+
+	if repo.HooksPath() != filepath.Join(dir, ".git", "hooks") {
+		t.Error("wrong default hooks path:", repo.HooksPath())
+	}
+	repo.Config().SetString("core.hooksPath", "githooks")
+	if repo.HooksPath() != filepath.Join(dir, "githooks") {
+		t.Error("a relative core.hooksPath should be in the working directory:", repo.HooksPath())
+	}
+	repo.InstallHook("pre-commit", []byte("#!/bin/sh\n"))
+	if _, err := os.Stat(filepath.Join(dir, "githooks", "pre-commit")); err != nil {
+		t.Error("the hook should be installed to core.hooksPath:", err)
+	}
+}
+
+func Test_Hooks_Run(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_hooks")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	hooks := repo.Hooks()
+
+	result, err := hooks.Run("post-merge", nil)
+	if result != nil || err != nil {
+		t.Error("it should not run a hook that is not installed:", err)
+	}
+	repo.InstallHook("post-merge", []byte("#!/bin/sh\necho \"$1 $FOO $(pwd) $GIT_DIR\"\ncat\n"))
+	result, err = hooks.Run("post-merge", &HookOptions{Args: []string{"0"}, Stdin: []byte("input\n"), Env: []string{"FOO=bar"}})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	workdir, _ := filepath.EvalSymlinks(dir)
+	expected := "0 bar " + workdir + " " + repo.Path() + "\ninput\n"
+	if result.ExitCode != 0 || string(result.Stdout) != expected {
+		t.Errorf("it should pass the arguments, the environment and the input: %q", result.Stdout)
+	}
+
+	repo.InstallHook("pre-rebase", []byte("#!/bin/sh\necho \"not on $1\" >&2\nexit 3\n"))
+	result, err = hooks.Run("pre-rebase", &HookOptions{Args: []string{"master"}})
+	hookError, ok := err.(*HookError)
+	if !ok || !IsErrorCode(err, ErrHookFailed) {
+		t.Fatal("it should fail with a HookError:", err)
+	}
+	if hookError.Result != result || result.ExitCode != 3 || string(result.Stderr) != "not on master\n" {
+		t.Errorf("it should capture the exit code and the error output: %d %q", result.ExitCode, result.Stderr)
+	}
+	if hookError.Error() != "the pre-rebase hook failed with exit code 3: not on master" {
+		t.Error("wrong message:", hookError.Error())
+	}
+
+	os.Chmod(filepath.Join(dir, ".git", "hooks", "pre-rebase"), 0644)
+	if hooks.Exists("pre-rebase") {
+		t.Error("it should ignore a hook that is not executable")
+	}
+}
+
+func Test_Rebase_NoVerify(t *testing.T) {
+	repo, head, commits := prepareSequencerRepository(t)
+	defer os.RemoveAll(repo.Workdir())
+	repo.CreateReference("refs/heads/topic", commits[3].Id(), true)
+	repo.InstallHook("pre-rebase", []byte("#!/bin/sh\nexit 1\n"))
+
+	_, err := repo.InitRebase("topic", head, nil, nil)
+	if !IsErrorCode(err, ErrHookFailed) {
+		t.Fatal("the pre-rebase hook should reject the rebase:", err)
+	}
+	if _, err := os.Stat(repo.rebasePath("")); !os.IsNotExist(err) {
+		t.Error("it should not start the rebase")
+	}
+	_, err = repo.InitRebase("topic", head, nil, &RebaseOptions{NoVerify: true})
+	if err != nil {
+		t.Error("it should skip the hook with NoVerify:", err)
+	}
+}
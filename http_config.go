@@ -0,0 +1,360 @@
+package git4go
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultHttpUserAgent = "git/2.0 (git4go)"
+
+// HttpConfig is the configuration of the HTTP transport for a url.
+type HttpConfig struct {
+	SslVerify  bool
+	SslCAInfo  string
+	SslCAPath  string
+	SslCert    string
+	SslKey     string
+	SslVersion string
+	// "Name: value" headers that are sent with every request
+	ExtraHeaders []string
+	Proxy        string
+	UserAgent    string
+	// the transfer is aborted if it is slower than LowSpeedLimit bytes
+	// per second for LowSpeedTime
+	LowSpeedLimit int
+	LowSpeedTime  time.Duration
+	PostBuffer    int
+	// "true", "false" or "initial" (only for the initial request)
+	FollowRedirects string
+	// "HTTP/1.1" or "HTTP/2", or empty for the default
+	Version    string
+	CookieFile string
+	EmptyAuth  bool
+	// the proxy of the environment is not used, as in a hermetic
+	// repository
+	Hermetic bool
+}
+
+// HttpConfig returns the http.* configuration for the url. The
+// http.<url>.* variables apply to the urls that they match, with the
+// precedence of git: the longest matching host wins, then the longest
+// matching path, then a matching user name, then the variable that comes
+// later; http.* applies to all urls. The GIT_SSL_* and GIT_HTTP_*
+// variables of the environment override the config unless the repository
+// is hermetic.
+func (r *Repository) HttpConfig(rawurl string) (*HttpConfig, error) {
+	target, err := url.Parse(rawurl)
+	if err != nil || target.Scheme == "" {
+		return nil, MakeGitError(fmt.Sprintf("invalid url '%s'", rawurl), ErrInvalid)
+	}
+	result := &HttpConfig{
+		SslVerify:       true,
+		UserAgent:       DefaultHttpUserAgent,
+		PostBuffer:      1024 * 1024,
+		FollowRedirects: "initial",
+		Hermetic:        r.hermetic,
+	}
+	if config := r.Config(); config != nil {
+		entries := config.Entries()
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Level < entries[j].Level
+		})
+		best := make(map[string]urlMatch)
+		for _, entry := range entries {
+			if !strings.HasPrefix(strings.ToLower(entry.Name), "http.") {
+				continue
+			}
+			name := entry.Name[len("http."):]
+			last := strings.LastIndexByte(name, '.')
+			key := strings.ToLower(name[last+1:])
+			var match urlMatch
+			if last != -1 {
+				var ok bool
+				if match, ok = matchConfigUrl(name[:last], target); !ok {
+					continue
+				}
+			}
+			if previous, ok := best[key]; ok && match.less(previous) {
+				continue
+			}
+			best[key] = match
+			if err = result.set(key, entry.Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !r.hermetic {
+		result.setFromEnv()
+	}
+	return result, nil
+}
+
+// HttpConfig returns the HTTP configuration of the url of the remote (the
+// push url for ConnectDirectionPush); remote.<name>.proxy overrides the
+// proxy.
+func (r *Remote) HttpConfig(direction ConnectDirection) (*HttpConfig, error) {
+	result, err := r.repo.HttpConfig(r.connectUrl(direction))
+	if err != nil {
+		return nil, err
+	}
+	if config := r.repo.Config(); config != nil {
+		if proxy, err := config.LookupString("remote." + r.name + ".proxy"); err == nil {
+			result.Proxy = proxy
+		}
+	}
+	return result, nil
+}
+
+// Header returns the User-Agent and the extra headers.
+func (c *HttpConfig) Header() http.Header {
+	header := make(http.Header)
+	header.Set("User-Agent", c.UserAgent)
+	for _, line := range c.ExtraHeaders {
+		colon := strings.IndexByte(line, ':')
+		if colon == -1 {
+			continue
+		}
+		header.Add(strings.TrimSpace(line[:colon]), strings.TrimSpace(line[colon+1:]))
+	}
+	return header
+}
+
+// Transport returns an HTTP transport with the TLS settings, the proxy and
+// the HTTP version of the configuration. Without a proxy, the proxy of the
+// environment is used unless the configuration is hermetic.
+func (c *HttpConfig) Transport() (*http.Transport, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: !c.SslVerify}
+	switch strings.ToLower(c.SslVersion) {
+	case "", "default", "tlsv1":
+	case "tlsv1.0":
+		tlsConfig.MinVersion = tls.VersionTLS10
+	case "tlsv1.1":
+		tlsConfig.MinVersion = tls.VersionTLS11
+	case "tlsv1.2":
+		tlsConfig.MinVersion = tls.VersionTLS12
+	case "tlsv1.3":
+		tlsConfig.MinVersion = tls.VersionTLS13
+	default:
+		return nil, MakeGitError(fmt.Sprintf("unsupported SSL version '%s'", c.SslVersion), ErrInvalid)
+	}
+	if c.SslCAInfo != "" || c.SslCAPath != "" {
+		pool := x509.NewCertPool()
+		files := []string{}
+		if c.SslCAInfo != "" {
+			files = append(files, c.SslCAInfo)
+		}
+		if c.SslCAPath != "" {
+			names, err := ioutil.ReadDir(c.SslCAPath)
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range names {
+				if !name.IsDir() {
+					files = append(files, filepath.Join(c.SslCAPath, name.Name()))
+				}
+			}
+		}
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			pool.AppendCertsFromPEM(data)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.SslCert != "" {
+		key := c.SslKey
+		if key == "" {
+			key = c.SslCert
+		}
+		certificate, err := tls.LoadX509KeyPair(c.SslCert, key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig:   tlsConfig,
+		ForceAttemptHTTP2: c.Version != "HTTP/1.1",
+	}
+	if !c.Hermetic {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+	if c.Version == "HTTP/1.1" {
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	if c.Proxy != "" {
+		proxy := c.Proxy
+		// like curl, a proxy without a protocol is an HTTP proxy
+		if !strings.Contains(proxy, "://") {
+			proxy = "http://" + proxy
+		}
+		proxyUrl, err := url.Parse(proxy)
+		if err != nil {
+			return nil, MakeGitError(fmt.Sprintf("invalid proxy '%s'", c.Proxy), ErrInvalid)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+	return transport, nil
+}
+
+// internal functions and methods
+
+func (c *HttpConfig) set(key, value string) error {
+	var err error
+	switch key {
+	case "sslverify":
+		c.SslVerify, err = parseConfigBool(value)
+	case "sslcainfo":
+		c.SslCAInfo = value
+	case "sslcapath":
+		c.SslCAPath = value
+	case "sslcert":
+		c.SslCert = value
+	case "sslkey":
+		c.SslKey = value
+	case "sslversion":
+		c.SslVersion = value
+	case "extraheader":
+		// an empty value clears the headers before it
+		if value == "" {
+			c.ExtraHeaders = nil
+		} else {
+			c.ExtraHeaders = append(c.ExtraHeaders, value)
+		}
+	case "proxy":
+		c.Proxy = value
+	case "useragent":
+		c.UserAgent = value
+	case "lowspeedlimit":
+		c.LowSpeedLimit, err = strconv.Atoi(value)
+	case "lowspeedtime":
+		var seconds int
+		seconds, err = strconv.Atoi(value)
+		c.LowSpeedTime = time.Duration(seconds) * time.Second
+	case "postbuffer":
+		c.PostBuffer, err = strconv.Atoi(value)
+	case "followredirects":
+		if value != "initial" {
+			var follow bool
+			if follow, err = parseConfigBool(value); follow {
+				value = "true"
+			} else {
+				value = "false"
+			}
+		}
+		c.FollowRedirects = value
+	case "version":
+		c.Version = value
+	case "cookiefile":
+		c.CookieFile = value
+	case "emptyauth":
+		c.EmptyAuth, err = parseConfigBool(value)
+	}
+	if err != nil {
+		return MakeGitError(fmt.Sprintf("invalid value '%s' of http.%s", value, key), ErrInvalid)
+	}
+	return nil
+}
+
+func (c *HttpConfig) setFromEnv() {
+	if os.Getenv("GIT_SSL_NO_VERIFY") != "" {
+		c.SslVerify = false
+	}
+	for name, key := range map[string]string{
+		"GIT_SSL_CAINFO":           "sslcainfo",
+		"GIT_SSL_CAPATH":           "sslcapath",
+		"GIT_SSL_CERT":             "sslcert",
+		"GIT_SSL_KEY":              "sslkey",
+		"GIT_SSL_VERSION":          "sslversion",
+		"GIT_HTTP_USER_AGENT":      "useragent",
+		"GIT_HTTP_LOW_SPEED_LIMIT": "lowspeedlimit",
+		"GIT_HTTP_LOW_SPEED_TIME":  "lowspeedtime",
+	} {
+		if value := os.Getenv(name); value != "" {
+			c.set(key, value)
+		}
+	}
+}
+
+// urlMatch is how specifically the url of a config section matched.
+type urlMatch struct {
+	hostLength  int
+	pathLength  int
+	userMatched bool
+}
+
+func (m urlMatch) less(other urlMatch) bool {
+	if m.hostLength != other.hostLength {
+		return m.hostLength < other.hostLength
+	}
+	if m.pathLength != other.pathLength {
+		return m.pathLength < other.pathLength
+	}
+	return !m.userMatched && other.userMatched
+}
+
+// matchConfigUrl matches the url of a config section like git's urlmatch:
+// the schemes and the ports must be equal, "*" matches within one part of
+// the host name, the path must be a prefix of the path of the url at a
+// slash, and the user, if it is given, must be equal.
+func matchConfigUrl(pattern string, target *url.URL) (urlMatch, bool) {
+	parsed, err := url.Parse(pattern)
+	if err != nil || parsed.Scheme == "" || !strings.EqualFold(parsed.Scheme, target.Scheme) {
+		return urlMatch{}, false
+	}
+	if urlPort(parsed) != urlPort(target) {
+		return urlMatch{}, false
+	}
+	patternParts := strings.Split(strings.ToLower(parsed.Hostname()), ".")
+	hostParts := strings.Split(strings.ToLower(target.Hostname()), ".")
+	if len(patternParts) != len(hostParts) {
+		return urlMatch{}, false
+	}
+	for i, part := range patternParts {
+		if matched, _ := path.Match(part, hostParts[i]); !matched {
+			return urlMatch{}, false
+		}
+	}
+	match := urlMatch{hostLength: len(parsed.Hostname())}
+	if prefix := strings.TrimRight(parsed.Path, "/"); prefix != "" {
+		targetPath := target.Path
+		if targetPath != prefix && !strings.HasPrefix(targetPath, prefix+"/") {
+			return urlMatch{}, false
+		}
+		match.pathLength = len(prefix)
+	}
+	if parsed.User != nil {
+		if target.User == nil || target.User.Username() != parsed.User.Username() {
+			return urlMatch{}, false
+		}
+		match.userMatched = true
+	}
+	return match, true
+}
+
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package git4go
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_HttpConfig(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_http_config")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	config := repo.Config()
+	config.addData([]byte(`[http]
+	sslVerify = true
+	extraHeader = X-Global: 1
+	postBuffer = 524288000
+[http "https://*.example.com"]
+	proxy = http://wildcard-proxy:3128
+	sslVerify = false
+[http "https://git.example.com"]
+	proxy = socks5://exact-proxy:1080
+[http "https://git.example.com/internal"]
+	sslVerify = true
+	extraHeader = Authorization: Bearer internal
+[http "https://bot@git.example.com"]
+	userAgent = bot
+[http "https://git.example.com:8443"]
+	proxy = http://other-port:3128
+[remote "origin"]
+	url = https://git.example.com/internal/repo.git
+	proxy = http://remote-proxy:3128
+`), ConfigLevelApp)
+
+	result, err := repo.HttpConfig("https://git.example.com/public/repo.git")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if result.Proxy != "socks5://exact-proxy:1080" {
+		t.Error("it should prefer the exact host to the wildcard:", result.Proxy)
+	}
+	if result.SslVerify {
+		t.Error("it should apply the matching wildcard section")
+	}
+	if result.PostBuffer != 524288000 || len(result.ExtraHeaders) != 1 {
+		t.Error("it should apply the http section to all urls:", result.PostBuffer, result.ExtraHeaders)
+	}
+	if result.UserAgent != DefaultHttpUserAgent {
+		t.Error("it should not apply a section with another user:", result.UserAgent)
+	}
+
+	result, _ = repo.HttpConfig("https://bot@git.example.com/internal/repo.git")
+	if !result.SslVerify || len(result.ExtraHeaders) != 2 || result.ExtraHeaders[1] != "Authorization: Bearer internal" {
+		t.Error("it should prefer the longer path:", result.SslVerify, result.ExtraHeaders)
+	}
+	if result.UserAgent != "bot" {
+		t.Error("it should apply the section of the user:", result.UserAgent)
+	}
+	if header := result.Header(); header.Get("Authorization") != "Bearer internal" || header.Get("User-Agent") != "bot" {
+		t.Error("it should send the extra headers:", header)
+	}
+
+	result, _ = repo.HttpConfig("https://git.example.com/internal-tools/repo.git")
+	if result.SslVerify {
+		t.Error("it should match the path at a slash only")
+	}
+	result, _ = repo.HttpConfig("https://git.example.com:8443/repo.git")
+	if result.Proxy != "http://other-port:3128" {
+		t.Error("it should match the port:", result.Proxy)
+	}
+	result, _ = repo.HttpConfig("https://example.com/repo.git")
+	if result.Proxy != "" || !result.SslVerify {
+		t.Error("it should not match the wildcard without a subdomain:", result.Proxy)
+	}
+
+	remote, _ := repo.LookupRemote("origin")
+	result, err = remote.HttpConfig(ConnectDirectionFetch)
+	if err != nil || result.Proxy != "http://remote-proxy:3128" || len(result.ExtraHeaders) != 2 {
+		t.Error("it should use the proxy of the remote:", result, err)
+	}
+	transport, err := result.Transport()
+	if err != nil || transport.TLSClientConfig.InsecureSkipVerify {
+		t.Fatal("it should configure the transport:", err)
+	}
+
+	config.SetString("http.lowSpeedLimit", "fast")
+	if _, err = repo.HttpConfig("https://git.example.com/"); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should reject invalid values:", err)
+	}
+}
+
+func Test_HttpConfig_Hermetic(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_http_config")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	result, _ := repo.HttpConfig("https://git.example.com/repo.git")
+	transport, err := result.Transport()
+	if err != nil || !result.Hermetic || transport.Proxy != nil {
+		t.Error("it should not use the proxy of the environment in a hermetic repository:", err)
+	}
+
+	opened, _ := OpenRepository(dir)
+	result, _ = opened.HttpConfig("https://git.example.com/repo.git")
+	transport, _ = result.Transport()
+	if result.Hermetic || transport.Proxy == nil {
+		t.Error("it should use the proxy of the environment otherwise")
+	}
+}
@@ -0,0 +1,126 @@
+package git4go
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+const (
+	GitIgnoreFile      = ".gitignore"
+	GitInfoExcludeFile = "info/exclude"
+)
+
+// IgnoreMatch tells which pattern decided whether a path is ignored, like
+// "git check-ignore -v". A negative pattern ("!pattern") makes the path
+// not ignored.
+type IgnoreMatch struct {
+	Ignored bool
+	// The file of the pattern: a path relative to the working directory
+	// for .gitignore files, or the full path for the other files
+	Source  string
+	Line    int
+	Pattern string
+}
+
+// IsPathIgnored returns true if the path is ignored by .gitignore,
+// $GIT_DIR/info/exclude or core.excludesFile.
+func (r *Repository) IsPathIgnored(path string) (bool, error) {
+	match, err := r.CheckIgnoreVerbose(path)
+	if err != nil || match == nil {
+		return false, err
+	}
+	return match.Ignored, nil
+}
+
+// CheckIgnoreVerbose returns the pattern that decides whether the path is
+// ignored, or nil if no pattern matches. Patterns of deeper .gitignore
+// files win over the ones of upper directories, info/exclude and
+// core.excludesFile, and the last matching line of a file wins. If a
+// parent directory is ignored, its match is returned, because git never
+// looks into ignored directories.
+func (r *Repository) CheckIgnoreVerbose(path string) (*IgnoreMatch, error) {
+	if r.IsBare() {
+		return nil, MakeGitError("cannot check ignores of a bare repository", ErrBareRepository)
+	}
+	path = strings.Trim(filepath.ToSlash(path), "/")
+	files, err := r.ignoreFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	ignoreCase := r.ignoreCase()
+	fragments := strings.Split(path, "/")
+	for i := range fragments {
+		current := strings.Join(fragments[:i+1], "/")
+		isDir := i < len(fragments)-1 || r.isDirInWorkdir(current)
+		match := matchIgnoreFiles(files, current, isDir, ignoreCase)
+		if i == len(fragments)-1 || (match != nil && match.Ignored) {
+			return match, nil
+		}
+	}
+	return nil, nil
+}
+
+// internal functions and methods
+
+// ignoreFiles returns the files of ignore patterns in the order of
+// priority from low to high.
+func (r *Repository) ignoreFiles(path string) ([]*attrFile, error) {
+	files, err := r.repositoryIgnoreFiles()
+	if err != nil {
+		return nil, err
+	}
+	workdirFiles, err := r.workdirAttrFiles(path, GitIgnoreFile)
+	if err != nil {
+		return nil, err
+	}
+	return append(files, workdirFiles...), nil
+}
+
+// repositoryIgnoreFiles returns core.excludesFile and info/exclude, the
+// files that apply to the whole working directory.
+func (r *Repository) repositoryIgnoreFiles() ([]*attrFile, error) {
+	var files []*attrFile
+	global, err := r.globalAttrFile("core.excludesFile", "ignore")
+	if err != nil {
+		return nil, err
+	}
+	if global != nil {
+		files = append(files, global)
+	}
+	infoPath := filepath.Join(r.pathCommon, filepath.FromSlash(GitInfoExcludeFile))
+	exclude, err := readAttrFile(r.fs, infoPath, infoPath, "")
+	if err != nil {
+		return nil, err
+	}
+	if exclude != nil {
+		files = append(files, exclude)
+	}
+	return files, nil
+}
+
+func matchIgnoreFiles(files []*attrFile, path string, isDir, ignoreCase bool) *IgnoreMatch {
+	for i := len(files) - 1; i >= 0; i-- {
+		file := files[i]
+		relPath, ok := file.relativePath(path)
+		if !ok {
+			continue
+		}
+		for j := len(file.lines) - 1; j >= 0; j-- {
+			line := strings.TrimRight(file.lines[j], " \t")
+			if line == "" || line[0] == '#' {
+				continue
+			}
+			pattern := parseAttrPattern(line, true)
+			if pattern == nil || !pattern.matches(relPath, isDir, ignoreCase) {
+				continue
+			}
+			return &IgnoreMatch{
+				Ignored: !pattern.negative,
+				Source:  file.source,
+				Line:    j + 1,
+				Pattern: line,
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package git4go
+
+import (
+	"./testutil"
+	"testing"
+)
+
+func Test_IsPathIgnored(t *testing.T) {
+	prepareAttrWorkspace()
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/attr")
+	expected := map[string]bool{
+		"ign":              true,
+		"sub/ign":          true,
+		"sub/ign/file":     true,
+		"dir":              true,
+		"dir/file":         true,
+		"sub/dir/file":     true,
+		"file":             false,
+		"sub/file":         false,
+		"root_test1":       false,
+		"does-not-exist":   false,
+		"does-not-exist/x": false,
+	}
+	for path, ignored := range expected {
+		actual, err := repo.IsPathIgnored(path)
+		if err != nil {
+			t.Error("err should be nil:", path, err)
+			continue
+		}
+		if actual != ignored {
+			t.Errorf("wrong result of %s. expected: %v actual: %v", path, ignored, actual)
+		}
+	}
+}
+
+func Test_CheckIgnoreVerbose(t *testing.T) {
+	prepareAttrWorkspace()
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/attr")
+	match, err := repo.CheckIgnoreVerbose("sub/dir/file")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if match == nil || !match.Ignored {
+		t.Fatal("it should be ignored")
+	}
+	if match.Source != ".gitignore" || match.Line != 2 || match.Pattern != "dir/" {
+		t.Error("it should report the line of the parent directory:", match.Source, match.Line, match.Pattern)
+	}
+
+	match, _ = repo.CheckIgnoreVerbose("file")
+	if match != nil {
+		t.Error("it should return nil for a path without patterns")
+	}
+}
+
+func Test_AttrPattern(t *testing.T) {
+	cases := []struct {
+		pattern    string
+		path       string
+		ignoreCase bool
+		matches    bool
+	}{
+		{"**/foo", "foo", false, true},
+		{"**/foo", "a/b/foo", false, true},
+		{"**/foo", "a/foobar", false, false},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"a/**/b", "x/a/b", false, false},
+		{"/a/*", "a/b", false, true},
+		{"/a/*", "a/b/c", false, false},
+		{"\\*", "*", false, true},
+		{"\\*", "a", false, false},
+		{"*.O", "dir/x.o", false, false},
+		{"*.O", "dir/x.o", true, true},
+		{"A/**", "a/b/c", true, true},
+	}
+	for _, c := range cases {
+		pattern := parseAttrPattern(c.pattern, true)
+		if pattern.matches(c.path, false, c.ignoreCase) != c.matches {
+			t.Errorf("wrong result of %s for %s. expected: %v", c.pattern, c.path, c.matches)
+		}
+	}
+}
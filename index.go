@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"github.com/shibukawa/bsearch"
 	"github.com/shibukawa/extstat"
-	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -38,6 +37,9 @@ const (
 	IndexEntryStageMask  IndexEntryFlag = 0x3000
 	IndexEntryStageShift int            = 12
 	IndexEntryExtended   uint16         = 0x4000
+	// "assume unchanged": the file is not compared with the working
+	// directory
+	IndexEntryValid uint16 = 0x8000
 
 	IndexEntryIntentToAdd     IndexEntryExtendedFlag = 1 << 13
 	IndexEntrySkipWorkTree    IndexEntryExtendedFlag = 1 << 14
@@ -57,8 +59,9 @@ const (
 	IndexHeaderSize = 12
 	IndexFooterSize = 20
 
-	IndexVersionNumber    = 2
-	IndexVersionNumberExt = 3
+	IndexVersionNumber     = 2
+	IndexVersionNumberExt  = 3
+	IndexVersionNumberComp = 4
 
 	IndexHeaderSig uint32 = 0x44495243
 
@@ -81,30 +84,39 @@ const (
 var IndexExtTreeCacheSig []byte = []byte("TREE")
 var IndexExtUnmergedSig []byte = []byte("REUC")
 var IndexExtConflictNameSig []byte = []byte("NAME")
+var IndexExtLinkSig []byte = []byte("link")
 
 type Index struct {
-	repo             *Repository
-	filePath         string
-	stamp            int64
-	Entries          []*IndexEntry
-	entriesSorted    bool
-	lock             sync.Mutex
-	deleted          []*IndexEntry
-	readers          int
-	onDisk           bool
-	ignoreCase       bool
-	distrustFilemode bool
-	noSymlinks       bool
+	repo              *Repository
+	fs                FileSystem
+	filePath          string
+	stamp             int64
+	size              int64
+	checksum          *Oid
+	version           uint32
+	Entries           []*IndexEntry
+	entriesSorted     bool
+	lock              sync.Mutex
+	deleted           []*IndexEntry
+	readers           int
+	onDisk            bool
+	ignoreCase        bool
+	distrustFilemode  bool
+	noSymlinks        bool
+	precomposeUnicode bool
 
 	tree       *TreeCache
 	names      []*IndexNameEntry
 	reuc       []*IndexReucEntry
 	reucSorted bool
+	link       *indexLink
 }
 
 type IndexEntry struct {
 	Ctime         time.Time
 	Mtime         time.Time
+	Dev           uint32
+	Ino           uint32
 	Mode          Filemode
 	Uid           uint32
 	Gid           uint32
@@ -115,12 +127,27 @@ type IndexEntry struct {
 	flagsExtended uint16
 }
 
+// IndexNameEntry records the paths of a file that a merge renamed, like
+// the NAME extension of git. An empty path is a side that does not have
+// the file.
 type IndexNameEntry struct {
 	ancestor string
 	ours     string
 	theirs   string
 }
 
+func (e *IndexNameEntry) Ancestor() string {
+	return e.ancestor
+}
+
+func (e *IndexNameEntry) Ours() string {
+	return e.ours
+}
+
+func (e *IndexNameEntry) Theirs() string {
+	return e.theirs
+}
+
 type IndexReucEntry struct {
 	mode [3]Filemode
 	oid  [3]*Oid
@@ -128,22 +155,28 @@ type IndexReucEntry struct {
 }
 
 func (r *Repository) Index() (*Index, error) {
+	r.indexLock.Lock()
+	defer r.indexLock.Unlock()
+
 	if r.index == nil {
-		index, err := OpenIndex(filepath.Join(r.pathRepository, GitIndexFile))
+		index, err := openIndex(r.fs, filepath.Join(r.pathRepository, GitIndexFile))
 		if err != nil {
 			return nil, err
 		}
 		index.repo = r
-		r.index = index
 		err = index.SetCaps(IndexCapFromOwner)
 		if err != nil {
 			return nil, err
 		}
+		r.index = index
 	}
 	return r.index, nil
 }
 
 func (r *Repository) SetIndex(index *Index) {
+	r.indexLock.Lock()
+	defer r.indexLock.Unlock()
+
 	if r.index != nil {
 		r.index.repo = nil
 	}
@@ -160,7 +193,12 @@ func NewIndex() (*Index, error) {
 // OpenIndex creates a new index at the given path. If the file does
 // not exist it will be created when Write() is called.
 func OpenIndex(path string) (*Index, error) {
+	return openIndex(OSFileSystem, path)
+}
+
+func openIndex(fsys FileSystem, path string) (*Index, error) {
 	index := &Index{
+		fs:       fsys,
 		filePath: path,
 		Entries:  make([]*IndexEntry, 0, 32),
 		names:    make([]*IndexNameEntry, 0, 8),
@@ -187,7 +225,7 @@ func (v *Index) Read(force bool) error {
 	if v.filePath == "" {
 		return errors.New("Failed to read index: The index is in-memory only")
 	}
-	stat, err := os.Stat(v.filePath)
+	stat, err := v.fs.Stat(v.filePath)
 	if os.IsNotExist(err) {
 		v.onDisk = false
 		if force {
@@ -196,11 +234,11 @@ func (v *Index) Read(force bool) error {
 		return nil
 	}
 	v.onDisk = true
-	stamp := stat.ModTime().Unix()
+	stamp := stat.ModTime().UnixNano()
 	if v.stamp >= stamp && !force {
 		return nil
 	}
-	buffer, err := ioutil.ReadFile(v.filePath)
+	buffer, err := v.fs.ReadFile(v.filePath)
 	if err != nil {
 		return err
 	}
@@ -224,7 +262,7 @@ func (v *Index) Read(force bool) error {
 		return errors.New("Index.Read(): incorrect header signature")
 	}
 	version := ntohlFromBytes(buffer, 4)
-	if version != IndexVersionNumber && version != IndexVersionNumberExt {
+	if version < IndexVersionNumber || IndexVersionNumberComp < version {
 		return errors.New("Index.Read(): incorrect header version")
 	}
 	entryCount := int(ntohlFromBytes(buffer, 8))
@@ -234,13 +272,16 @@ func (v *Index) Read(force bool) error {
 
 	bound := len(buffer) - IndexFooterSize
 	offset := IndexHeaderSize
+	previousPath := ""
 	var i int
 	for i = 0; i < entryCount && offset < bound; i++ {
 		var entry *IndexEntry
-		offset, entry = readEntry(buffer, offset)
-		if entry != nil {
-			v.Entries = append(v.Entries, entry)
+		offset, entry = readEntry(buffer, offset, version, previousPath)
+		if entry == nil {
+			break
 		}
+		v.Entries = append(v.Entries, entry)
+		previousPath = entry.Path
 	}
 	if i != entryCount {
 		return errors.New("Index.Read(): header entries changed while parsing")
@@ -255,34 +296,148 @@ func (v *Index) Read(force bool) error {
 	if offset != bound {
 		return errors.New("buffer size does not match index footer size")
 	}
+	if v.link != nil {
+		err = v.mergeSharedIndex()
+		if err != nil {
+			return err
+		}
+	}
+	v.version = version
 	v.entriesSorted = !v.ignoreCase
 	if !v.entriesSorted {
 		v.sortEntriesIfNeeded(v.ignoreCase, false)
 		v.entriesSorted = true
 	}
 	v.stamp = stamp
+	v.size = stat.Size()
+	v.checksum = expectedChecksum
 	return nil
 }
 
+// IndexRefreshOptions controls Index.RefreshWithOptions.
+type IndexRefreshOptions struct {
+	// Read the file even if it looks unchanged
+	Force bool
+	// How long to wait while another process holds index.lock. Zero fails
+	// at once with ErrLocked.
+	LockTimeout time.Duration
+	// The first wait between the checks of index.lock. It doubles after each
+	// check up to one second. Zero uses 10ms.
+	Backoff time.Duration
+}
+
+// Refresh re-reads the index if another process changed the file since it
+// was read or written. Unlike Read, it does not fail in the middle of an
+// update of another process: while index.lock exists, it waits up to
+// DefaultLockTimeout and then fails with ErrLocked.
+func (v *Index) Refresh(force bool) error {
+	return v.RefreshWithOptions(&IndexRefreshOptions{
+		Force:       force,
+		LockTimeout: DefaultLockTimeout,
+	})
+}
+
+// RefreshWithOptions is Refresh with the control of the lock waiting. A
+// changed file is detected by its mtime and size first, and the trailing
+// checksum decides whether the entries have to be parsed again. The
+// entries in memory are replaced, so changes that were not written are
+// lost when the file has changed.
+func (v *Index) RefreshWithOptions(opts *IndexRefreshOptions) error {
+	if v.filePath == "" {
+		return errors.New("Failed to refresh index: The index is in-memory only")
+	}
+	if opts == nil {
+		opts = &IndexRefreshOptions{}
+	}
+	err := v.waitForIndexLock(opts.LockTimeout, opts.Backoff)
+	if err != nil {
+		return err
+	}
+	stat, err := v.fs.Stat(v.filePath)
+	if os.IsNotExist(err) {
+		if v.onDisk || opts.Force {
+			v.onDisk = false
+			return v.Clear()
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !opts.Force && v.onDisk && stat.ModTime().UnixNano() == v.stamp && stat.Size() == v.size {
+		return nil
+	}
+	if !opts.Force && v.checksum != nil && stat.Size() >= IndexFooterSize {
+		buffer, err := v.fs.ReadFile(v.filePath)
+		if err != nil {
+			return err
+		}
+		checksum := NewOidFromBytes(buffer[len(buffer)-IndexFooterSize:])
+		if checksum.Equal(v.checksum) {
+			// only touched, the content is the same
+			v.onDisk = true
+			v.stamp = stat.ModTime().UnixNano()
+			v.size = stat.Size()
+			return nil
+		}
+	}
+	return v.Read(true)
+}
+
 func (v *Index) Clear() error {
 	v.lock.Lock()
 	defer v.lock.Unlock()
 
 	v.tree = nil
+	v.link = nil
 	v.Entries = make([]*IndexEntry, 0, 32)
 	v.names = make([]*IndexNameEntry, 0, 8)
 	v.reuc = make([]*IndexReucEntry, 0, 8)
 	v.deleted = make([]*IndexEntry, 0, 8)
 	v.stamp = 0
+	v.size = 0
+	v.checksum = nil
 	return nil
 }
 
+// waitForIndexLock waits until nobody holds index.lock. Locks that are old
+// enough to be stale are ignored, they are removed by the next writer.
+func (v *Index) waitForIndexLock(timeout, backoff time.Duration) error {
+	lockPath := v.filePath + GitLockFileSuffix
+	if backoff <= 0 {
+		backoff = 10 * time.Millisecond
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		stat, err := v.fs.Stat(lockPath)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if LockfileStaleAge > 0 && time.Since(stat.ModTime()) >= LockfileStaleAge {
+			return nil
+		}
+		wait := deadline.Sub(time.Now())
+		if wait <= 0 {
+			return MakeGitError(fmt.Sprintf("index '%s' is locked by another process", v.filePath), ErrLocked)
+		}
+		if backoff < wait {
+			wait = backoff
+		}
+		time.Sleep(wait)
+		if backoff < time.Second {
+			backoff *= 2
+		}
+	}
+}
+
 // Add adds or replaces the given entry to the index, making a copy of
 // the data
 func (v *Index) Add(entry *IndexEntry) error {
 	if !validFilemode(entry.Mode) {
 		return errors.New("invalid filemode")
 	}
+	entry.Path = precomposePath(entry.Path, v.precomposeUnicode)
 	v.Entries = append(v.Entries, entry)
 	v.entriesSorted = false
 	v.tree.invalidatePath(entry.Path)
@@ -294,6 +449,7 @@ func (v *Index) AddByPath(path string) error {
 	if v.Owner() == nil {
 		errors.New("Could not initialize index entry. Index is not backed up by an existing repository.")
 	}
+	path = precomposePath(path, v.precomposeUnicode)
 	entry, err := indexEntryCreate(v.repo, path)
 	if err != nil {
 		return err
@@ -319,7 +475,7 @@ func indexEntryInitFromStat(entry *IndexEntry, stat os.FileInfo, mode Filemode)
 	extStat := extstat.New(stat)
 	entry.Mtime = stat.ModTime()
 	entry.Ctime = extStat.ChangeTime
-	//entry.Dev = stat.Dev
+	entry.Dev, entry.Ino = fileDevIno(stat)
 	//entry.Uid = stat.Uid
 	//entry.Gid = stat.Gid
 	entry.Size = stat.Size()
@@ -417,6 +573,7 @@ func (v *Index) SetCaps(caps IndexCapFlag) error {
 		v.distrustFilemode = !filemode
 		symlinks, _ := conf.LookupBooleanWithDefaultValue("core.symlinks")
 		v.noSymlinks = !symlinks
+		v.precomposeUnicode, _ = conf.LookupBooleanWithDefaultValue("core.precomposeunicode")
 	} else {
 		v.ignoreCase = caps&IndexCapIgnoreCase != 0
 		v.distrustFilemode = caps&IndexCapNoFilemode != 0
@@ -484,9 +641,49 @@ func (v *Index) Remove(path string, stage IndexStage) error {
 	return v.removeEntry(pos)
 }
 
-// todo
+// WriteTreeTo writes the trees of the index entries to the repository and
+// returns the id of the root tree. The ids of the trees are kept in the
+// TREE extension, so only the directories that have changed since the last
+// call are written again.
 func (v *Index) WriteTreeTo(repo *Repository) (*Oid, error) {
-	return nil, nil
+	if repo == nil {
+		return nil, errors.New("Failed to write tree. The index file is not backed up by an existing repository")
+	}
+	odb, err := repo.Odb()
+	if err != nil {
+		return nil, err
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for _, entry := range v.Entries {
+		if entry.Stage() != 0 {
+			return nil, MakeGitError("cannot create a tree from a not fully merged index", ErrUnmerged)
+		}
+	}
+	v.sortEntriesIfNeeded(v.ignoreCase, false)
+	v.entriesSorted = true
+	entries := v.Entries
+	if v.ignoreCase {
+		// trees are always sorted case sensitively
+		var sorted indexEntriesCaseSensitive = make([]*IndexEntry, len(entries))
+		copy(sorted, entries)
+		sort.Sort(sorted)
+		entries = sorted
+	}
+	cache := v.tree
+	if cache == nil || repo != v.repo {
+		// the cached trees may not exist in the other repository
+		cache = &TreeCache{entryCount: -1}
+	}
+	oid, err := cache.update(odb, entries, "")
+	if err != nil {
+		return nil, err
+	}
+	if repo == v.repo {
+		v.tree = cache
+	}
+	return oid, nil
 }
 
 // ReadTree replaces the contents of the index with those of the given
@@ -514,6 +711,8 @@ func (v *Index) ReadTree(tree *Tree) error {
 			if oldEntry.Mode == entry.Mode && oldEntry.Id.Equal(entry.Id) {
 				entry.Ctime = oldEntry.Ctime
 				entry.Mtime = oldEntry.Mtime
+				entry.Dev = oldEntry.Dev
+				entry.Ino = oldEntry.Ino
 				entry.Uid = oldEntry.Uid
 				entry.Gid = oldEntry.Gid
 				entry.Size = oldEntry.Size
@@ -532,6 +731,10 @@ func (v *Index) ReadTree(tree *Tree) error {
 	if err != nil {
 		return err
 	}
+	cache, err := createTreeCacheFromTree(tree)
+	if err != nil {
+		return err
+	}
 	if v.ignoreCase {
 		var entries indexEntriesCaseInSensitive = newEntries
 		sort.Sort(entries)
@@ -540,16 +743,67 @@ func (v *Index) ReadTree(tree *Tree) error {
 		sort.Sort(entries)
 	}
 	v.Entries = newEntries
+	v.tree = cache
 	return nil
 }
 
-// todo
+// WriteTree writes the index as a tree to the repository of the index.
 func (v *Index) WriteTree() (*Oid, error) {
 	return v.WriteTreeTo(v.repo)
 }
 
-// todo
+// Write writes the index to its file under index.lock. A split index is
+// written as a whole index that has all entries of its shared index.
 func (v *Index) Write() error {
+	if v.repo != nil && v.repo.readOnly {
+		return errReadOnly("Index.Write")
+	}
+	if v.filePath == "" {
+		return errors.New("Failed to write index: The index is in-memory only")
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.sortEntriesIfNeeded(v.ignoreCase, false)
+	v.entriesSorted = true
+	lock, err := newLockfile(v.fs, v.filePath, GitIndexFileMode, DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	data := v.serialize()
+	_, err = lock.Write(data)
+	if err != nil {
+		lock.Rollback()
+		return err
+	}
+	err = lock.Commit()
+	if err != nil {
+		return err
+	}
+	v.onDisk = true
+	v.checksum = NewOidFromBytes(data[len(data)-IndexFooterSize:])
+	if stat, err := v.fs.Stat(v.filePath); err == nil {
+		v.stamp = stat.ModTime().UnixNano()
+		v.size = stat.Size()
+	}
+	return nil
+}
+
+// Version returns the file format version of the index.
+func (v *Index) Version() uint {
+	if v.version == 0 {
+		return IndexVersionNumber
+	}
+	return uint(v.version)
+}
+
+// SetVersion sets the file format version that Write uses. Version 4
+// compresses the paths, which makes indexes of large trees much smaller.
+func (v *Index) SetVersion(version uint) error {
+	if version < IndexVersionNumber || IndexVersionNumberComp < version {
+		return errors.New(fmt.Sprintf("invalid index version: %d", version))
+	}
+	v.version = uint32(version)
 	return nil
 }
 
@@ -569,10 +823,46 @@ func (v *Index) EntryByIndex(index int) (*IndexEntry, error) {
 	return nil, errors.New("out of index")
 }
 
+// EntryByPath returns the entry of the path at the stage. It fails with
+// ErrNotFound if the index has no such entry.
+func (v *Index) EntryByPath(path string, stage IndexStage) (*IndexEntry, error) {
+	pos := v.sortAndFindInEntries(path, stage, true)
+	if pos == -1 {
+		return nil, MakeGitError(fmt.Sprintf("Index does not contain %s at stage %d", path, stage), ErrNotFound)
+	}
+	return v.Entries[pos], nil
+}
+
+// SetAssumeUnchanged sets or clears the assume-unchanged flag of the file
+// like "git update-index --[no-]assume-unchanged": the status does not check
+// the file in the working directory while it is set. It fails with
+// ErrNotFound if the index has no entry for the path.
+func (v *Index) SetAssumeUnchanged(path string, assume bool) error {
+	entry, err := v.EntryByPath(path, 0)
+	if err != nil {
+		return err
+	}
+	entry.SetAssumeUnchanged(assume)
+	return nil
+}
+
+// SetSkipWorktree sets or clears the skip-worktree flag of the file like
+// "git update-index --[no-]skip-worktree". The index is written in version 3
+// or later while an entry has the flag.
+func (v *Index) SetSkipWorktree(path string, skip bool) error {
+	entry, err := v.EntryByPath(path, 0)
+	if err != nil {
+		return err
+	}
+	entry.SetSkipWorktree(skip)
+	return nil
+}
+
 func (v *Index) Find(path string) int {
 	v.lock.Lock()
 	defer v.lock.Unlock()
 
+	path = precomposePath(path, v.precomposeUnicode)
 	var pos int
 	if v.ignoreCase {
 		path = strings.ToLower(path)
@@ -596,7 +886,7 @@ func (v *Index) Find(path string) int {
 			}
 		}
 	} else {
-		pos = bsearch.Search(len(v.reuc), func(i int) bsearch.CompareResult {
+		pos = bsearch.Search(len(v.Entries), func(i int) bsearch.CompareResult {
 			pathInList := v.Entries[i].Path
 			if path > pathInList {
 				return bsearch.Smaller
@@ -633,7 +923,7 @@ func (v *Index) findInEntries(entries []*IndexEntry, path string, stage IndexSta
 			}
 		})
 	} else {
-		return bsearch.Search(len(v.reuc), func(i int) bsearch.CompareResult {
+		return bsearch.Search(len(entries), func(i int) bsearch.CompareResult {
 			pathInList := entries[i].Path
 			if path > pathInList {
 				return bsearch.Smaller
@@ -647,6 +937,7 @@ func (v *Index) findInEntries(entries []*IndexEntry, path string, stage IndexSta
 }
 
 func (v *Index) sortAndFindInEntries(path string, stage IndexStage, needLock bool) int {
+	path = precomposePath(path, v.precomposeUnicode)
 	v.sortEntriesIfNeeded(v.ignoreCase, needLock)
 	return v.findInEntries(v.Entries, path, stage, v.ignoreCase)
 }
@@ -654,18 +945,53 @@ func (v *Index) sortAndFindInEntries(path string, stage IndexStage, needLock boo
 func (v *Index) HasConflicts() bool {
 	for _, entry := range v.Entries {
 		if entry.Stage() != 0 {
-			return false
+			return true
 		}
 	}
-	return true
+	return false
 }
 
 // FIXME: this might return an error
 func (v *Index) CleanupConflicts() {
 }
 
-// todo: not implemented yet
+// AddConflict adds the entries as the stages of a conflict. The entry of
+// the path and its old stages are replaced. A nil entry is a side that
+// does not have the path.
 func (v *Index) AddConflict(ancestor *IndexEntry, our *IndexEntry, their *IndexEntry) error {
+	sides := []*IndexEntry{ancestor, our, their}
+	path := ""
+	for _, entry := range sides {
+		if entry == nil {
+			continue
+		}
+		if !validFilemode(entry.Mode) {
+			return errors.New("invalid filemode")
+		}
+		path = entry.Path
+	}
+	if path == "" {
+		return MakeGitError("a conflict needs at least one side", ErrInvalid)
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for stage := IndexStage(0); stage <= StageTheirs; stage++ {
+		if pos := v.sortAndFindInEntries(path, stage, false); pos != -1 {
+			v.removeEntry(pos)
+		}
+	}
+	for i, entry := range sides {
+		if entry == nil {
+			continue
+		}
+		conflict := *entry
+		conflict.Path = precomposePath(entry.Path, v.precomposeUnicode)
+		conflict.SetStage(StageAncestor + IndexStage(i))
+		v.Entries = append(v.Entries, &conflict)
+	}
+	v.entriesSorted = false
+	v.tree.invalidatePath(path)
 	return nil
 }
 
@@ -687,11 +1013,45 @@ func (v *Index) GetConflict(path string) (IndexConflict, error) {
 	return conflict, nil
 }
 
-// todo: not implemented yet
+// RemoveConflict removes the conflict stages of the path. The resolution
+// is added as a normal entry by the caller.
 func (v *Index) RemoveConflict(path string) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for stage := StageAncestor; stage <= StageTheirs; stage++ {
+		if pos := v.sortAndFindInEntries(path, stage, false); pos != -1 {
+			v.removeEntry(pos)
+		}
+	}
+	v.tree.invalidatePath(path)
 	return nil
 }
 
+// AddNameEntry records the paths of a renamed file whose merge has
+// conflicts.
+func (v *Index) AddNameEntry(ancestor, ours, theirs string) error {
+	if ancestor == "" && ours == "" && theirs == "" {
+		return MakeGitError("a name entry needs at least one path", ErrInvalid)
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.names = append(v.names, &IndexNameEntry{ancestor: ancestor, ours: ours, theirs: theirs})
+	return nil
+}
+
+func (v *Index) NameEntryCount() uint {
+	return uint(len(v.names))
+}
+
+func (v *Index) NameEntryByIndex(index int) (*IndexNameEntry, error) {
+	if -1 < index && index < len(v.names) {
+		return v.names[index], nil
+	}
+	return nil, errors.New("out of index")
+}
+
 func (v IndexEntry) Stage() IndexStage {
 	return IndexStage((v.flags & uint16(IndexEntryStageMask)) >> uint16(IndexEntryStageShift))
 }
@@ -704,6 +1064,39 @@ func (v IndexEntry) IsConflict() bool {
 	return v.Stage() != 0
 }
 
+// SkipWorktree tells if the file is left out of the working directory by a
+// sparse checkout ("git update-index --skip-worktree").
+func (v IndexEntry) SkipWorktree() bool {
+	return v.flagsExtended&uint16(IndexEntrySkipWorkTree) != 0
+}
+
+func (v *IndexEntry) SetSkipWorktree(skip bool) {
+	if skip {
+		v.flagsExtended |= uint16(IndexEntrySkipWorkTree)
+	} else {
+		v.flagsExtended &^= uint16(IndexEntrySkipWorkTree)
+	}
+}
+
+// AssumeUnchanged tells if changes of the file in the working directory are
+// ignored ("git update-index --assume-unchanged").
+func (v IndexEntry) AssumeUnchanged() bool {
+	return v.flags&IndexEntryValid != 0
+}
+
+func (v *IndexEntry) SetAssumeUnchanged(assume bool) {
+	if assume {
+		v.flags |= IndexEntryValid
+	} else {
+		v.flags &^= IndexEntryValid
+	}
+}
+
+// IntentToAdd tells if the entry was added with "git add -N".
+func (v IndexEntry) IntentToAdd() bool {
+	return v.flagsExtended&uint16(IndexEntryIntentToAdd) != 0
+}
+
 type IndexConflictIterator struct {
 	index  *Index
 	cursor int
@@ -872,7 +1265,7 @@ func (a indexNameEntries) Less(i, j int) bool {
 	return e1.ours < e2.ours
 }
 
-func readEntry(buffer []byte, offset int) (int, *IndexEntry) {
+func readEntry(buffer []byte, offset int, version uint32, previousPath string) (int, *IndexEntry) {
 	bound := len(buffer) - IndexFooterSize
 	if offset+IndexMinimumEntrySize > bound {
 		return offset, nil
@@ -880,8 +1273,8 @@ func readEntry(buffer []byte, offset int) (int, *IndexEntry) {
 	entry := &IndexEntry{
 		Ctime: time.Unix(int64(ntohlFromBytes(buffer, offset)), int64(ntohlFromBytes(buffer, offset+4))),
 		Mtime: time.Unix(int64(ntohlFromBytes(buffer, offset+8)), int64(ntohlFromBytes(buffer, offset+12))),
-		//Dev: ntohlFromBytes(buffer, offset+16),
-		//Ino: ntohlFromBytes(buffer, offset+20),
+		Dev:   ntohlFromBytes(buffer, offset+16),
+		Ino:   ntohlFromBytes(buffer, offset+20),
 		Mode:  Filemode(ntohlFromBytes(buffer, offset+24)),
 		Uid:   ntohlFromBytes(buffer, offset+28),
 		Gid:   ntohlFromBytes(buffer, offset+32),
@@ -891,31 +1284,156 @@ func readEntry(buffer []byte, offset int) (int, *IndexEntry) {
 	}
 	var pathStart int
 	if entry.flags&IndexEntryExtended != 0 {
+		if offset+IndexMinimumEntrySize+2 > bound {
+			return offset, nil
+		}
 		entry.flagsExtended = ntohsFromBytes(buffer, offset+62)
 		pathStart = offset + 64
 	} else {
 		pathStart = offset + 62
 	}
+	if version >= IndexVersionNumberComp {
+		// the path is the previous path without its last "strip" bytes
+		// followed by the NUL terminated suffix, entries are not padded
+		strip, suffixStart := decodeVarint(buffer, pathStart, bound)
+		if suffixStart == -1 || strip > uint64(len(previousPath)) {
+			return offset, nil
+		}
+		suffixEnd := findChar(buffer, 0, suffixStart, bound)
+		if suffixEnd == -1 {
+			return offset, nil
+		}
+		entry.Path = previousPath[:len(previousPath)-int(strip)] + string(buffer[suffixStart:suffixEnd])
+		return suffixEnd + 1, entry
+	}
 	pathLength := int(entry.flags & uint16(IndexEntryNameMask))
 	if pathLength == int(IndexEntryNameMask) {
-		pathEnd := pathStart
-		found := false
-		for pathEnd < bound {
-			if buffer[pathEnd] == 0 {
-				found = true
-				break
-			}
-		}
-		if !found {
+		pathEnd := findChar(buffer, 0, pathStart, bound)
+		if pathEnd == -1 {
 			return offset, nil
 		}
 		pathLength = pathEnd - pathStart
+	} else if pathStart+pathLength > bound {
+		return offset, nil
 	}
 	entry.Path = string(buffer[pathStart : pathStart+pathLength])
 	offset = ((pathStart + pathLength + 8 - offset) & ^7) + offset
 	return offset, entry
 }
 
+func (v *Index) serialize() []byte {
+	version := uint32(v.Version())
+	if version == IndexVersionNumber {
+		for _, entry := range v.Entries {
+			if entry.flagsExtended&uint16(IndexEntryExtendedFlags) != 0 {
+				version = IndexVersionNumberExt
+				break
+			}
+		}
+	}
+	var buffer bytes.Buffer
+	writeUint32(&buffer, IndexHeaderSig)
+	writeUint32(&buffer, version)
+	writeUint32(&buffer, uint32(len(v.Entries)))
+	previousPath := ""
+	for _, entry := range v.Entries {
+		writeEntry(&buffer, entry, version, previousPath)
+		previousPath = entry.Path
+	}
+	if v.tree != nil {
+		var extension bytes.Buffer
+		v.tree.write(&extension)
+		writeExtension(&buffer, IndexExtTreeCacheSig, extension.Bytes())
+	}
+	if len(v.reuc) > 0 {
+		var extension bytes.Buffer
+		for _, reuc := range v.reuc {
+			extension.WriteString(reuc.path)
+			extension.WriteByte(0)
+			for _, mode := range reuc.mode {
+				fmt.Fprintf(&extension, "%o", mode)
+				extension.WriteByte(0)
+			}
+			for i, oid := range reuc.oid {
+				if reuc.mode[i] != 0 {
+					extension.Write(oid[:])
+				}
+			}
+		}
+		writeExtension(&buffer, IndexExtUnmergedSig, extension.Bytes())
+	}
+	if len(v.names) > 0 {
+		var extension bytes.Buffer
+		for _, name := range v.names {
+			for _, path := range []string{name.ancestor, name.ours, name.theirs} {
+				extension.WriteString(path)
+				extension.WriteByte(0)
+			}
+		}
+		writeExtension(&buffer, IndexExtConflictNameSig, extension.Bytes())
+	}
+	checksum := calcHash(buffer.Bytes())
+	buffer.Write(checksum[:])
+	return buffer.Bytes()
+}
+
+func writeEntry(buffer *bytes.Buffer, entry *IndexEntry, version uint32, previousPath string) {
+	start := buffer.Len()
+	writeUint32(buffer, uint32(entry.Ctime.Unix()))
+	writeUint32(buffer, uint32(entry.Ctime.Nanosecond()))
+	writeUint32(buffer, uint32(entry.Mtime.Unix()))
+	writeUint32(buffer, uint32(entry.Mtime.Nanosecond()))
+	writeUint32(buffer, entry.Dev)
+	writeUint32(buffer, entry.Ino)
+	writeUint32(buffer, uint32(entry.Mode))
+	writeUint32(buffer, entry.Uid)
+	writeUint32(buffer, entry.Gid)
+	writeUint32(buffer, entry.Size)
+	buffer.Write(entry.Id[:])
+
+	flags := entry.flags &^ (uint16(IndexEntryNameMask) | IndexEntryExtended)
+	if len(entry.Path) < int(IndexEntryNameMask) {
+		flags |= uint16(len(entry.Path))
+	} else {
+		flags |= uint16(IndexEntryNameMask)
+	}
+	flagsExtended := entry.flagsExtended & uint16(IndexEntryExtendedFlags)
+	if flagsExtended != 0 {
+		flags |= IndexEntryExtended
+	}
+	buffer.WriteByte(byte(flags >> 8))
+	buffer.WriteByte(byte(flags))
+	if flagsExtended != 0 {
+		buffer.WriteByte(byte(flagsExtended >> 8))
+		buffer.WriteByte(byte(flagsExtended))
+	}
+
+	if version >= IndexVersionNumberComp {
+		common := 0
+		for common < len(previousPath) && common < len(entry.Path) && previousPath[common] == entry.Path[common] {
+			common++
+		}
+		buffer.Write(encodeVarint(uint64(len(previousPath) - common)))
+		buffer.WriteString(entry.Path[common:])
+		buffer.WriteByte(0)
+		return
+	}
+	buffer.WriteString(entry.Path)
+	// entries are padded with 1-8 NULs to a multiple of 8 bytes
+	padding := 8 - (buffer.Len()-start)%8
+	buffer.Write(make([]byte, padding))
+}
+
+func writeExtension(buffer *bytes.Buffer, signature, data []byte) {
+	buffer.Write(signature)
+	writeUint32(buffer, uint32(len(data)))
+	buffer.Write(data)
+}
+
+func writeUint32(buffer *bytes.Buffer, value uint32) {
+	buffer.Write([]byte{byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)})
+}
+
 func readReuc(index *Index, buffer []byte, offset, size int) error {
 	for size > 0 {
 		pathEnd := findChar(buffer, 0, offset, offset+size)
@@ -1008,6 +1526,12 @@ func readExtension(index *Index, buffer []byte, offset int) int {
 				return 0
 			}
 		}
+	} else if bytes.Equal(buffer[offset:offset+4], IndexExtLinkSig) {
+		link, err := readIndexLink(buffer, offset+8, extensionSize)
+		if err != nil {
+			return 0
+		}
+		index.link = link
 	} else {
 		return 0
 	}
@@ -0,0 +1,205 @@
+package git4go
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// AddEntriesFromReader adds the entries of the records of the reader like
+// "git update-index --index-info". Each line is a record in one of the
+// formats:
+//
+//	mode SP sha1 TAB path             (the stage is 0)
+//	mode SP type SP sha1 TAB path     (like "git ls-tree")
+//	mode SP sha1 SP stage TAB path    (like "git ls-files -s")
+//
+// Paths can be quoted like git quotes them. A mode of 0 removes all stages
+// of the path. A stage 0 entry replaces the conflict of its path, but the
+// stages of a conflict do not replace the stage 0 entry, so a conflict is
+// recorded after a mode 0 record like with git. An entry replaces the files
+// and directories that it conflicts with; a later record wins over an
+// earlier one.
+//
+// The files are not read from the working directory, so the entries have
+// no stat data and the objects do not have to exist. The records are
+// parsed before the index is changed: it is left unchanged if one is
+// invalid.
+func (v *Index) AddEntriesFromReader(reader io.Reader) error {
+	batch := &indexInfoBatch{
+		added:   make(map[indexInfoKey]*indexInfoEntry),
+		removed: make(map[string]bool),
+	}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+		entry, err := parseIndexInfo(text)
+		if err != nil {
+			return MakeGitError(fmt.Sprintf("index-info: %s on line %d", err.Error(), line), ErrInvalid)
+		}
+		entry.Path = precomposePath(entry.Path, v.precomposeUnicode)
+		batch.add(entry, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.Entries = batch.apply(v)
+	v.entriesSorted = false
+	v.sortEntriesIfNeeded(v.ignoreCase, false)
+	v.entriesSorted = true
+	return nil
+}
+
+// internal functions and methods
+
+type indexInfoKey struct {
+	path  string
+	stage IndexStage
+}
+
+type indexInfoEntry struct {
+	entry *IndexEntry
+	// the line of the record; the entries of the index have 0
+	seq int
+}
+
+// indexInfoBatch collects the records, so the index is changed once for
+// all of them.
+type indexInfoBatch struct {
+	added map[indexInfoKey]*indexInfoEntry
+	// the paths whose entries in the index are removed
+	removed map[string]bool
+	// the stages of the paths whose entries in the index are replaced
+	replaced map[indexInfoKey]bool
+}
+
+func (b *indexInfoBatch) add(entry *IndexEntry, seq int) {
+	stage := entry.Stage()
+	if entry.Mode == 0 || stage == 0 {
+		for s := IndexStage(0); s <= StageTheirs; s++ {
+			delete(b.added, indexInfoKey{entry.Path, s})
+		}
+		b.removed[entry.Path] = true
+		if entry.Mode == 0 {
+			return
+		}
+	} else {
+		if b.replaced == nil {
+			b.replaced = make(map[indexInfoKey]bool)
+		}
+		b.replaced[indexInfoKey{entry.Path, stage}] = true
+	}
+	b.added[indexInfoKey{entry.Path, stage}] = &indexInfoEntry{entry: entry, seq: seq}
+}
+
+// apply returns the entries of the index with the records of the batch.
+func (b *indexInfoBatch) apply(v *Index) []*IndexEntry {
+	var candidates []*indexInfoEntry
+	for _, entry := range v.Entries {
+		if b.removed[entry.Path] || b.replaced[indexInfoKey{entry.Path, entry.Stage()}] {
+			v.dropEntry(entry)
+			continue
+		}
+		candidates = append(candidates, &indexInfoEntry{entry: entry})
+	}
+	for _, added := range b.added {
+		v.tree.invalidatePath(added.entry.Path)
+		candidates = append(candidates, added)
+	}
+
+	// a file and a directory of the same path: the later one wins
+	files := make(map[string]int)
+	for _, candidate := range candidates {
+		if seq, ok := files[candidate.entry.Path]; !ok || seq < candidate.seq {
+			files[candidate.entry.Path] = candidate.seq
+		}
+	}
+	droppedFiles := make(map[string]bool)
+	droppedEntries := make(map[*indexInfoEntry]bool)
+	for _, candidate := range candidates {
+		for dir := filepath.ToSlash(filepath.Dir(candidate.entry.Path)); dir != "."; dir = filepath.ToSlash(filepath.Dir(dir)) {
+			seq, ok := files[dir]
+			if !ok {
+				continue
+			}
+			if candidate.seq > seq {
+				droppedFiles[dir] = true
+			} else {
+				droppedEntries[candidate] = true
+			}
+		}
+	}
+	entries := make([]*IndexEntry, 0, len(candidates))
+	for _, candidate := range candidates {
+		if droppedEntries[candidate] || droppedFiles[candidate.entry.Path] {
+			v.dropEntry(candidate.entry)
+			continue
+		}
+		entries = append(entries, candidate.entry)
+	}
+	return entries
+}
+
+// dropEntry forgets the entry like removeEntry, for entries that are
+// removed in bulk.
+func (v *Index) dropEntry(entry *IndexEntry) {
+	v.tree.invalidatePath(entry.Path)
+	if v.readers > 0 {
+		v.deleted = append(v.deleted, entry)
+	}
+}
+
+// parseIndexInfo parses a record of AddEntriesFromReader.
+func parseIndexInfo(line string) (*IndexEntry, error) {
+	tab := strings.IndexByte(line, '\t')
+	if tab == -1 {
+		return nil, fmt.Errorf("malformed record '%s'", line)
+	}
+	fields := strings.Split(line[:tab], " ")
+	path := line[tab+1:]
+	if strings.HasPrefix(path, "\"") {
+		unquoted, err := strconv.Unquote(path)
+		if err != nil {
+			return nil, fmt.Errorf("malformed quoted path %s", path)
+		}
+		path = unquoted
+	}
+	stage := 0
+	switch {
+	case len(fields) == 3 && len(fields[2]) == 1 && '0' <= fields[2][0] && fields[2][0] <= '3':
+		stage = int(fields[2][0] - '0')
+		fields = fields[:2]
+	case len(fields) == 3:
+		// the type is not used
+		fields = []string{fields[0], fields[2]}
+	case len(fields) != 2:
+		return nil, fmt.Errorf("malformed record '%s'", line)
+	}
+	mode, err := strconv.ParseUint(fields[0], 8, 32)
+	if err != nil || (mode != 0 && !validFilemode(Filemode(mode))) || Filemode(mode) == FilemodeTree {
+		return nil, fmt.Errorf("invalid mode '%s'", fields[0])
+	}
+	oid, err := NewOid(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid object id '%s'", fields[1])
+	}
+	for _, component := range strings.Split(path, "/") {
+		if !isValidPathComponent(component, false) {
+			return nil, fmt.Errorf("invalid path '%s'", path)
+		}
+	}
+	entry := &IndexEntry{Path: path, Mode: Filemode(mode), Id: oid}
+	entry.SetStage(IndexStage(stage))
+	return entry, nil
+}
@@ -0,0 +1,96 @@
+package git4go
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_Index_AddEntriesFromReader(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	blob, _ := repo.CreateBlobFromBuffer([]byte("blob\n"))
+	other, _ := NewOid("1111111111111111111111111111111111111111")
+	index, _ := NewIndex()
+	index.Add(&IndexEntry{Path: "a.txt", Mode: FilemodeBlob, Id: blob})
+	index.Add(&IndexEntry{Path: "conflict.txt", Mode: FilemodeBlob, Id: blob})
+	index.Add(&IndexEntry{Path: "dir", Mode: FilemodeBlob, Id: blob})
+	index.Add(&IndexEntry{Path: "file/x.txt", Mode: FilemodeBlob, Id: blob})
+	index.Add(&IndexEntry{Path: "removed.txt", Mode: FilemodeBlob, Id: blob})
+
+	records := []string{
+		fmt.Sprintf("100755 %s\ta.txt", other),
+		fmt.Sprintf("100644 blob %s\tdir/b.txt", blob),
+		fmt.Sprintf("0 %s\tremoved.txt", other),
+		fmt.Sprintf("0 %s\tconflict.txt", other),
+		fmt.Sprintf("100644 %s 1\tconflict.txt", blob),
+		fmt.Sprintf("100644 %s 2\tconflict.txt", other),
+		fmt.Sprintf("120000 %s\tfile", blob),
+		fmt.Sprintf("100644 %s\t\"quoted\\tname\\303\\251.txt\"", blob),
+		fmt.Sprintf("100644 %s\tlater.txt", blob),
+		fmt.Sprintf("100755 %s\tlater.txt", other),
+	}
+	if err := index.AddEntriesFromReader(strings.NewReader(strings.Join(records, "\n") + "\n")); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	expected := []string{
+		"100755 " + other.String() + " 0 a.txt",
+		"100644 " + blob.String() + " 1 conflict.txt",
+		"100644 " + other.String() + " 2 conflict.txt",
+		"100644 " + blob.String() + " 0 dir/b.txt",
+		"120000 " + blob.String() + " 0 file",
+		"100755 " + other.String() + " 0 later.txt",
+		"100644 " + blob.String() + " 0 quoted\tnameé.txt",
+	}
+	if len(index.Entries) != len(expected) {
+		t.Fatal("it should replace and remove the entries:", len(index.Entries))
+	}
+	for i, entry := range index.Entries {
+		if actual := fmt.Sprintf("%o %s %d %s", entry.Mode, entry.Id, entry.Stage(), entry.Path); actual != expected[i] {
+			t.Error("it should add the entry:", actual)
+		}
+	}
+
+	index.AddEntriesFromReader(strings.NewReader(fmt.Sprintf("100644 %s\tconflict.txt\n", blob)))
+	if entry, err := index.EntryByPath("conflict.txt", 0); err != nil || index.HasConflicts() || !entry.Id.Equal(blob) {
+		t.Error("it should resolve the conflict with a stage 0 entry:", err)
+	}
+
+	for _, record := range []string{
+		"100644 " + blob.String() + " a.txt",
+		"100644 xyz\ta.txt",
+		"40000 " + blob.String() + "\ta.txt",
+		"100644 " + blob.String() + "\t../a.txt",
+		"100644 " + blob.String() + "\t.git/config",
+	} {
+		err := index.AddEntriesFromReader(strings.NewReader(fmt.Sprintf("0 %s\ta.txt\n%s\n", blob, record)))
+		if !IsErrorCode(err, ErrInvalid) || !strings.Contains(err.Error(), "line 2") {
+			t.Error("it should reject the record:", record, err)
+		}
+	}
+	if _, err := index.EntryByPath("a.txt", 0); err != nil {
+		t.Error("it should not change the index if a record is invalid:", err)
+	}
+}
+
+func Test_Index_AddEntriesFromReader_Bulk(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	blob, _ := repo.CreateBlobFromBuffer([]byte("blob\n"))
+	var records strings.Builder
+	for i := 0; i < 50000; i++ {
+		fmt.Fprintf(&records, "100644 %s\tdir%d/file%d.txt\n", blob, i%100, i)
+	}
+	index, _ := NewIndex()
+	if err := index.AddEntriesFromReader(strings.NewReader(records.String())); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if index.EntryCount() != 50000 {
+		t.Error("it should add all entries:", index.EntryCount())
+	}
+	if _, err := index.EntryByPath("dir42/file4242.txt", 0); err != nil {
+		t.Error("it should find the entries:", err)
+	}
+	treeId, err := index.WriteTreeTo(repo)
+	if err != nil || treeId == nil {
+		t.Error("it should write the tree:", err)
+	}
+}
@@ -0,0 +1,142 @@
+package git4go
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+const GitSharedIndexPrefix = "sharedindex."
+
+// indexLink is the "link" extension of a split index. The entries of the
+// index file are applied to the shared index that it names: entries of the
+// shared index are deleted or replaced, and the rest are added.
+type indexLink struct {
+	baseId  *Oid
+	deleted []int
+	replace []int
+}
+
+func readIndexLink(buffer []byte, offset, size int) (*indexLink, error) {
+	if size < GitOidRawSize {
+		return nil, errors.New("corrupt link extension (too short)")
+	}
+	bound := offset + size
+	link := &indexLink{
+		baseId: NewOidFromBytes(buffer[offset : offset+GitOidRawSize]),
+	}
+	offset += GitOidRawSize
+	if offset == bound {
+		return link, nil
+	}
+	var err error
+	link.deleted, offset, err = readEwahBitmap(buffer, offset, bound)
+	if err != nil {
+		return nil, err
+	}
+	link.replace, offset, err = readEwahBitmap(buffer, offset, bound)
+	if err != nil {
+		return nil, err
+	}
+	if offset != bound {
+		return nil, errors.New("garbage at the end of link extension")
+	}
+	return link, nil
+}
+
+// readEwahBitmap reads the bitmap that git writes for split indexes and
+// returns the positions of the set bits.
+func readEwahBitmap(buffer []byte, offset, bound int) ([]int, int, error) {
+	if bound-offset < 8 {
+		return nil, offset, errors.New("corrupt ewah bitmap (too short)")
+	}
+	wordCount := int(ntohlFromBytes(buffer, offset+4))
+	offset += 8
+	if (bound-offset)/8 < wordCount || bound-offset-wordCount*8 < 4 {
+		return nil, offset, errors.New("corrupt ewah bitmap (truncated)")
+	}
+	var bits []int
+	position := 0
+	for i := 0; i < wordCount; {
+		// running length word: bit 0 is the bit of the run, bits 1-32 the
+		// number of run words and bits 33-63 the number of literal words
+		rlw := binary.BigEndian.Uint64(buffer[offset+i*8:])
+		i++
+		runLength := int((rlw >> 1) & 0xffffffff)
+		literalCount := int(rlw >> 33)
+		if rlw&1 != 0 {
+			for bit := 0; bit < runLength*64; bit++ {
+				bits = append(bits, position+bit)
+			}
+		}
+		position += runLength * 64
+		if wordCount-i < literalCount {
+			return nil, offset, errors.New("corrupt ewah bitmap (literal words)")
+		}
+		for ; literalCount > 0; literalCount-- {
+			word := binary.BigEndian.Uint64(buffer[offset+i*8:])
+			i++
+			for bit := 0; bit < 64; bit++ {
+				if word&(1<<uint(bit)) != 0 {
+					bits = append(bits, position+bit)
+				}
+			}
+			position += 64
+		}
+	}
+	// the position of the last running length word follows the words
+	return bits, offset + wordCount*8 + 4, nil
+}
+
+// mergeSharedIndex reads the shared index and applies the entries of the
+// split index to it, so the index can be used and written like a normal
+// one. Index.Write writes the merged entries without the link extension.
+func (v *Index) mergeSharedIndex() error {
+	link := v.link
+	v.link = nil
+	path := filepath.Join(filepath.Dir(v.filePath), GitSharedIndexPrefix+link.baseId.String())
+	shared, err := openIndex(v.fs, path)
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to read shared index '%s': %s", path, err.Error()))
+	}
+	if shared.link != nil {
+		return errors.New("shared index must not be split again")
+	}
+	entries := shared.Entries
+	removed := make([]bool, len(entries))
+	for _, pos := range link.deleted {
+		if pos >= len(entries) {
+			return errors.New("corrupt link extension (deleted entry is out of range)")
+		}
+		removed[pos] = true
+	}
+	splitEntries := v.Entries
+	if len(splitEntries) < len(link.replace) {
+		return errors.New("corrupt link extension (too many replaced entries)")
+	}
+	for i, pos := range link.replace {
+		if pos >= len(entries) || removed[pos] {
+			return errors.New("corrupt link extension (replaced entry is out of range)")
+		}
+		if splitEntries[i].Path != "" {
+			return errors.New(fmt.Sprintf("corrupt link extension, entry %d should have zero length name", i))
+		}
+		replacement := *splitEntries[i]
+		replacement.Path = entries[pos].Path
+		replacement.flags = (replacement.flags &^ uint16(IndexEntryNameMask)) | (entries[pos].flags & uint16(IndexEntryNameMask))
+		entries[pos] = &replacement
+	}
+	merged := make([]*IndexEntry, 0, len(entries)+len(splitEntries)-len(link.replace))
+	for i, entry := range entries {
+		if !removed[i] {
+			merged = append(merged, entry)
+		}
+	}
+	merged = append(merged, splitEntries[len(link.replace):]...)
+	var sorted indexEntriesCaseSensitive = merged
+	sort.Stable(sorted)
+	v.Entries = merged
+	return nil
+}
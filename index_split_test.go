@@ -0,0 +1,57 @@
+package git4go
+
+import (
+	"./testutil"
+	"os"
+	"testing"
+)
+
+func checkSplitIndex(index *Index, t *testing.T) {
+	expected := []struct {
+		path string
+		id   string
+	}{
+		{"README", "8178c76d627cade75005b40711b92f4177bc6cfc"},
+		{"src/main.go", "5ea2ed416fbd4a4cbe227b75fe255dd7fa6bd4d6"},
+		{"src/new.txt", "3e757656cf36eca53338e520d134963a44f793f8"},
+		{"src/util/helper.go", "f44d1e3cfdd89d6741e19a2566840a4fba2eb54a"},
+		{"src/util/zzz.txt", "b1a17ba136936531b72571844a773fe938b85ad4"},
+	}
+	if index.EntryCount() != uint(len(expected)) {
+		t.Fatal("entry count should be 5, but", index.EntryCount())
+	}
+	for i, entry := range expected {
+		checkConflict(index.Entries[i], entry.path, entry.id, t)
+	}
+	if index.Find("docs/a.md") != -1 {
+		t.Error("it should not have deleted entry")
+	}
+}
+
+func Test_IndexReadSplitIndex(t *testing.T) {
+	testutil.PrepareFixture("test_resources/splitindex")
+	defer testutil.CleanupFixture()
+
+	index, err := OpenIndex("test_resources/splitindex/index")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	checkSplitIndex(index, t)
+}
+
+func Test_IndexWriteSplitIndex(t *testing.T) {
+	testutil.PrepareFixture("test_resources/splitindex")
+	defer testutil.CleanupFixture()
+
+	index, _ := OpenIndex("test_resources/splitindex/index")
+	err := index.Write()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	os.Remove("test_resources/splitindex/sharedindex.a98ea95b06baf4a46b611dd1f4ccdb84a4f068e5")
+	written, err := OpenIndex("test_resources/splitindex/index")
+	if err != nil {
+		t.Fatal("it should read written index without shared index:", err)
+	}
+	checkSplitIndex(written, t)
+}
@@ -3,6 +3,7 @@ package git4go
 import (
 	"./testutil"
 	"testing"
+	"time"
 )
 
 func Test_ReadIndex(t *testing.T) {
@@ -112,3 +113,236 @@ func Test_IndexConflictIterator(t *testing.T) {
 		t.Error("it should be nil")
 	}
 }
+
+func Test_IndexPrecomposeUnicode(t *testing.T) {
+	index, _ := NewIndex()
+	index.precomposeUnicode = true
+	oid, _ := NewOid("1a039633309bdb88eb5e6c46d1f8c2ade51f09e6")
+	decomposed := "café.txt"
+	precomposed := "café.txt"
+
+	err := index.Add(&IndexEntry{Path: decomposed, Mode: FilemodeBlob, Id: oid})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if index.Entries[0].Path != precomposed {
+		t.Error("it should store precomposed path:", index.Entries[0].Path)
+	}
+	if index.Find(decomposed) != 0 || index.Find(precomposed) != 0 {
+		t.Error("it should find entry by both forms")
+	}
+}
+
+func Test_IndexReadVersion4(t *testing.T) {
+	testutil.PrepareFixture("test_resources/indexv4")
+	defer testutil.CleanupFixture()
+
+	index, err := OpenIndex("test_resources/indexv4/index")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if index.Version() != 4 {
+		t.Error("it should be version 4:", index.Version())
+	}
+	expected := []string{"README", "docs/a.md", "src/main.go", "src/util/helper.go", "src/util/zzz.txt"}
+	if index.EntryCount() != uint(len(expected)) {
+		t.Fatal("entry count should be 5, but", index.EntryCount())
+	}
+	for i, path := range expected {
+		if index.Entries[i].Path != path {
+			t.Error("it should restore compressed path. expected:", path, "actual:", index.Entries[i].Path)
+		}
+	}
+}
+
+func Test_IndexWriteVersion4(t *testing.T) {
+	testutil.PrepareFixture("test_resources/indexv4")
+	defer testutil.CleanupFixture()
+
+	index, _ := OpenIndex("test_resources/indexv4/index")
+	for _, version := range []uint{2, 4} {
+		err := index.SetVersion(version)
+		if err != nil {
+			t.Fatal("err should be nil:", err)
+		}
+		err = index.Write()
+		if err != nil {
+			t.Fatal("err should be nil:", err)
+		}
+		written, err := OpenIndex("test_resources/indexv4/index")
+		if err != nil {
+			t.Fatal("it should read written index:", err)
+		}
+		if written.Version() != version {
+			t.Error("it should write version", version, "but", written.Version())
+		}
+		if written.EntryCount() != index.EntryCount() {
+			t.Fatal("it should write all entries:", written.EntryCount())
+		}
+		for i, entry := range written.Entries {
+			if entry.Path != index.Entries[i].Path || !entry.Id.Equal(index.Entries[i].Id) {
+				t.Error("it should write same entry:", entry.Path, index.Entries[i].Path)
+			}
+		}
+	}
+	if index.SetVersion(5) == nil {
+		t.Error("it should reject unknown version")
+	}
+}
+
+func Test_IndexWriteStatData(t *testing.T) {
+	testutil.PrepareFixture("test_resources/indexv4")
+	defer testutil.CleanupFixture()
+
+	index, _ := OpenIndex("test_resources/indexv4/index")
+	for i, entry := range index.Entries {
+		entry.Dev = uint32(i + 1)
+		entry.Ino = uint32(1000 + i)
+	}
+	err := index.Write()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	written, _ := OpenIndex("test_resources/indexv4/index")
+	for i, entry := range written.Entries {
+		expected := index.Entries[i]
+		if entry.Dev != expected.Dev || entry.Ino != expected.Ino || entry.Uid != expected.Uid ||
+			entry.Gid != expected.Gid || !entry.Mtime.Equal(expected.Mtime) || !entry.Ctime.Equal(expected.Ctime) {
+			t.Error("it should write the stat data of the entry:", entry.Path, entry.Dev, entry.Ino)
+		}
+	}
+}
+
+func Test_IndexRefresh(t *testing.T) {
+	testutil.PrepareFixture("test_resources/indexv4")
+	defer testutil.CleanupFixture()
+
+	path := "test_resources/indexv4/index"
+	index, _ := OpenIndex(path)
+	other, _ := OpenIndex(path)
+	oid, _ := NewOid("1a039633309bdb88eb5e6c46d1f8c2ade51f09e6")
+
+	index.Add(&IndexEntry{Path: "unsaved.txt", Mode: FilemodeBlob, Id: oid})
+	err := index.Refresh(false)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if index.Find("unsaved.txt") == -1 {
+		t.Error("it should not re-read unchanged file")
+	}
+
+	other.Add(&IndexEntry{Path: "saved.txt", Mode: FilemodeBlob, Id: oid})
+	err = other.Write()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	err = index.Refresh(false)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if index.Find("unsaved.txt") != -1 || index.Find("saved.txt") == -1 {
+		t.Error("it should re-read file that another index wrote")
+	}
+	if index.EntryCount() != 6 {
+		t.Error("entry count should be 6, but", index.EntryCount())
+	}
+}
+
+func Test_IndexRefreshLocked(t *testing.T) {
+	testutil.PrepareFixture("test_resources/indexv4")
+	defer testutil.CleanupFixture()
+
+	path := "test_resources/indexv4/index"
+	index, _ := OpenIndex(path)
+	lock, err := NewLockfile(path, GitIndexFileMode, 0)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	err = index.RefreshWithOptions(&IndexRefreshOptions{})
+	if !IsErrorCode(err, ErrLocked) {
+		t.Error("it should fail while index.lock exists:", err)
+	}
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		lock.Rollback()
+	}()
+	err = index.RefreshWithOptions(&IndexRefreshOptions{
+		Force:       true,
+		LockTimeout: time.Second,
+		Backoff:     5 * time.Millisecond,
+	})
+	if err != nil {
+		t.Error("it should wait until index.lock is released:", err)
+	}
+	if index.EntryCount() != 5 {
+		t.Error("entry count should be 5, but", index.EntryCount())
+	}
+}
+
+func Test_IndexWriteTreeWithCache(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo")
+	index, _ := repo.Index()
+	treeId, _ := NewOid("ae90f12eea699729ed24555e40b9fd669da12a12")
+	tree, err := repo.LookupTree(treeId)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	err = index.ReadTree(tree)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if cache := index.tree.get("ab/de"); cache == nil || cache.oid.String() != "b6361fc6a97178d8fc8639fdeed71c775ab52593" || cache.entryCount != 2 {
+		t.Error("it should cache trees that are read")
+	}
+	oid, err := index.WriteTree()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if !oid.Equal(treeId) {
+		t.Error("it should write same tree:", oid.String())
+	}
+
+	blobId, _ := NewOid("270b8ea76056d5cad83af921837702d3e3c2924d")
+	index.Add(&IndexEntry{Path: "ab/c/5.txt", Mode: FilemodeBlob, Id: blobId})
+	if index.tree.entryCount != -1 || index.tree.get("ab/c").entryCount != -1 {
+		t.Error("it should invalidate trees that contain added path")
+	}
+	if index.tree.get("ab/de").entryCount != 2 {
+		t.Error("it should keep other trees valid")
+	}
+	oid, err = index.WriteTree()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if oid.String() != "15a27bf463f78c96f25274f771d8cdda45d69374" {
+		t.Error("it should write changed tree:", oid.String())
+	}
+
+	err = index.Write()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	written, _ := OpenIndex("test_resources/testrepo/.git/index")
+	if written.tree == nil || !written.tree.oid.Equal(oid) || written.tree.entryCount != 8 {
+		t.Error("it should write TREE extension")
+	}
+	if cache := written.tree.get("ab/c"); cache == nil || cache.entryCount != 2 {
+		t.Error("it should write subtrees of TREE extension")
+	}
+}
+
+func Test_IndexWriteTreeUnmerged(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/mergedrepo")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/mergedrepo")
+	index, _ := repo.Index()
+	_, err := index.WriteTree()
+	if !IsErrorCode(err, ErrUnmerged) {
+		t.Error("it should not write tree with conflicts:", err)
+	}
+}
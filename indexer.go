@@ -0,0 +1,537 @@
+package git4go
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	gohash "hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// resolved objects are kept in memory up to this size while deltas are
+// resolved
+const indexerCacheSize = 32 * 1024 * 1024
+
+// IndexerStats counts the objects of the pack that an Indexer received.
+type IndexerStats struct {
+	TotalObjects   uint
+	IndexedObjects uint
+	ReceivedBytes  uint
+	// Bases that were missing from a thin pack and were appended from the
+	// local object database
+	LocalObjects  uint
+	TotalDeltas   uint
+	IndexedDeltas uint
+}
+
+// IndexerProgressCallback is called while the pack is received and
+// indexed. Returning an error stops the indexer.
+type IndexerProgressCallback func(stats IndexerStats) error
+
+// Indexer stores a pack that was received from another repository and
+// writes its index, like "git index-pack --stdin --fix-thin". The pack is
+// written to the Indexer, which streams it to a temporary file in the
+// directory, and Commit checks it and stores it. Objects are read back
+// from the file to resolve deltas, so the pack is not held in memory.
+//
+// If the object database is given, the bases of REF_DELTAs that are not in
+// the pack are read from it and are appended to the pack, which makes a
+// thin pack usable.
+//
+// The temporary file and the pack are on the disk and not on the
+// FileSystem of the repository, because packs are memory mapped.
+type Indexer struct {
+	dir      string
+	odb      *Odb
+	file     *os.File
+	header   []byte
+	stats    IndexerStats
+	progress IndexerProgressCallback
+}
+
+// indexerEntry is an object of the received pack.
+type indexerEntry struct {
+	offset uint64
+	// where the compressed data starts
+	dataOffset uint64
+	end        uint64
+	crc        uint32
+	objType    ObjectType
+	baseOffset uint64
+	baseId     *Oid
+	// filled when the delta is resolved
+	base     *indexerEntry
+	id       *Oid
+	realType ObjectType
+	// only the bases that are appended from the object database keep
+	// their data
+	realData []byte
+}
+
+// NewIndexer makes an indexer that stores packs in the directory, which is
+// usually objects/pack. The odb can be nil if thin packs are not expected.
+func NewIndexer(dir string, odb *Odb) *Indexer {
+	return &Indexer{
+		dir: dir,
+		odb: odb,
+	}
+}
+
+func (ix *Indexer) SetProgressCallback(callback IndexerProgressCallback) {
+	ix.progress = callback
+}
+
+func (ix *Indexer) Write(data []byte) (int, error) {
+	if ix.file == nil {
+		file, err := ioutil.TempFile(ix.dir, "tmp_pack_")
+		if err != nil {
+			return 0, err
+		}
+		ix.file = file
+	}
+	n, err := ix.file.Write(data)
+	ix.stats.ReceivedBytes += uint(n)
+	if err != nil {
+		return n, err
+	}
+	if len(ix.header) < 12 {
+		rest := 12 - len(ix.header)
+		if rest > n {
+			rest = n
+		}
+		ix.header = append(ix.header, data[:rest]...)
+		if len(ix.header) == 12 {
+			ix.stats.TotalObjects = uint(binary.BigEndian.Uint32(ix.header[8:]))
+		}
+	}
+	return n, ix.reportProgress()
+}
+
+func (ix *Indexer) Stats() IndexerStats {
+	return ix.stats
+}
+
+// Commit parses the received pack, resolves its deltas and stores the pack
+// and its index. It returns the checksum that names the stored pack, which
+// differs from the received one if bases were appended.
+func (ix *Indexer) Commit() (*Oid, error) {
+	if ix.file == nil {
+		return nil, errors.New("invalid pack: bad header")
+	}
+	checksum, err := ix.commit()
+	if err != nil {
+		ix.Close()
+		return nil, err
+	}
+	return checksum, nil
+}
+
+// Close removes the received data if the pack was not committed.
+func (ix *Indexer) Close() {
+	if ix.file != nil {
+		ix.file.Close()
+		os.Remove(ix.file.Name())
+		ix.file = nil
+	}
+}
+
+// internal functions and methods
+
+func (ix *Indexer) reportProgress() error {
+	if ix.progress == nil {
+		return nil
+	}
+	return ix.progress(ix.stats)
+}
+
+func (ix *Indexer) commit() (*Oid, error) {
+	stat, err := ix.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := uint64(stat.Size())
+	if size < 12+GitOidRawSize || string(ix.header[:4]) != "PACK" {
+		return nil, errors.New("invalid pack: bad header")
+	}
+	version := binary.BigEndian.Uint32(ix.header[4:])
+	if version != 2 && version != 3 {
+		return nil, errors.New(fmt.Sprintf("unsupported pack version %d", version))
+	}
+	trailer := size - GitOidRawSize
+	received := make([]byte, GitOidRawSize)
+	_, err = ix.file.ReadAt(received, int64(trailer))
+	if err != nil {
+		return nil, err
+	}
+	sum, err := ix.checksum(trailer)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(sum, received) {
+		return nil, errors.New("invalid pack: checksum mismatch")
+	}
+
+	count := int(ix.stats.TotalObjects)
+	entries := make([]*indexerEntry, 0, count)
+	byOffset := make(map[uint64]*indexerEntry)
+	reader := &indexerReader{
+		reader: bufio.NewReader(io.NewSectionReader(ix.file, 12, int64(trailer-12))),
+		offset: 12,
+		crc:    crc32.NewIEEE(),
+	}
+	for i := 0; i < count; i++ {
+		entry, err := ix.parseEntry(reader)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+		byOffset[entry.offset] = entry
+	}
+	if reader.offset != trailer {
+		return nil, errors.New("invalid pack: garbage at the end")
+	}
+
+	external, err := ix.resolveEntries(entries, byOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	indexEntries := make([]*packIndexEntry, 0, len(entries)+len(external))
+	for _, entry := range entries {
+		indexEntries = append(indexEntries, &packIndexEntry{
+			id:     entry.id,
+			offset: entry.offset,
+			crc:    entry.crc,
+		})
+	}
+	checksum := NewOidFromBytes(received)
+	if len(external) > 0 {
+		// the bases replace the trailer, and the count and the checksum
+		// are updated
+		offset := trailer
+		for _, entry := range external {
+			var buffer bytes.Buffer
+			buffer.Write(packEntryHeader(entry.realType, uint64(len(entry.realData))))
+			writer := zlib.NewWriter(&buffer)
+			writer.Write(entry.realData)
+			writer.Close()
+			_, err = ix.file.WriteAt(buffer.Bytes(), int64(offset))
+			if err != nil {
+				return nil, err
+			}
+			indexEntries = append(indexEntries, &packIndexEntry{
+				id:     entry.id,
+				offset: offset,
+				crc:    crc32.ChecksumIEEE(buffer.Bytes()),
+			})
+			offset += uint64(buffer.Len())
+		}
+		var count [4]byte
+		binary.BigEndian.PutUint32(count[:], uint32(len(indexEntries)))
+		_, err = ix.file.WriteAt(count[:], 8)
+		if err != nil {
+			return nil, err
+		}
+		sum, err := ix.checksum(offset)
+		if err != nil {
+			return nil, err
+		}
+		checksum = NewOidFromBytes(sum)
+		_, err = ix.file.WriteAt(sum, int64(offset))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tempName := ix.file.Name()
+	err = ix.file.Close()
+	ix.file = nil
+	if err == nil {
+		err = os.Rename(tempName, filepath.Join(ix.dir, "pack-"+checksum.String()+".pack"))
+	}
+	if err != nil {
+		os.Remove(tempName)
+		return nil, err
+	}
+	var index bytes.Buffer
+	writePackIndex(&index, indexEntries, checksum)
+	err = writePackFile(ix.dir, "pack-"+checksum.String()+".idx", index.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return checksum, nil
+}
+
+// checksum computes the SHA1 of the first bytes of the file. It fails with
+// ErrHashCollision if the collision detection of SetHashAlgorithm finds an
+// attack.
+func (ix *Indexer) checksum(size uint64) ([]byte, error) {
+	hasher := newHasher()
+	_, err := io.Copy(hasher, io.NewSectionReader(ix.file, 0, int64(size)))
+	if err != nil {
+		return nil, err
+	}
+	sum, err := sumHasher(hasher)
+	if err != nil {
+		return nil, err
+	}
+	return sum[:], nil
+}
+
+// indexerReader reads the pack sequentially and counts the offset and the
+// CRC32 of the current entry. It is a flate.Reader, so zlib does not read
+// ahead and the offset tells where the next entry starts.
+type indexerReader struct {
+	reader *bufio.Reader
+	offset uint64
+	crc    gohash.Hash32
+}
+
+func (r *indexerReader) Read(buffer []byte) (int, error) {
+	n, err := r.reader.Read(buffer)
+	r.crc.Write(buffer[:n])
+	r.offset += uint64(n)
+	return n, err
+}
+
+func (r *indexerReader) ReadByte() (byte, error) {
+	c, err := r.reader.ReadByte()
+	if err == nil {
+		r.crc.Write([]byte{c})
+		r.offset++
+	}
+	return c, err
+}
+
+// parseEntry reads an entry. The ids of whole objects are computed while
+// they are inflated, and deltas are resolved later.
+func (ix *Indexer) parseEntry(reader *indexerReader) (*indexerEntry, error) {
+	offset := reader.offset
+	reader.crc.Reset()
+	truncated := errors.New(fmt.Sprintf("invalid pack: truncated object at %d", offset))
+	c, err := reader.ReadByte()
+	if err != nil {
+		return nil, truncated
+	}
+	entry := &indexerEntry{
+		offset:  offset,
+		objType: ObjectType((c >> 4) & 7),
+	}
+	size := uint64(c & 15)
+	for shift := uint(4); c&0x80 != 0; shift += 7 {
+		if c, err = reader.ReadByte(); err != nil {
+			return nil, truncated
+		}
+		size += uint64(c&0x7f) << shift
+	}
+	var hasher gohash.Hash
+	switch entry.objType {
+	case ObjectCommit, ObjectTree, ObjectBlob, ObjectTag:
+		hasher = newHasher()
+		fmt.Fprintf(hasher, "%s %d\x00", entry.objType.String(), size)
+	case ObjectOfsDelta:
+		if c, err = reader.ReadByte(); err != nil {
+			return nil, truncated
+		}
+		distance := uint64(c & 127)
+		for c&128 != 0 {
+			if c, err = reader.ReadByte(); err != nil {
+				return nil, truncated
+			}
+			distance = ((distance + 1) << 7) + uint64(c&127)
+		}
+		if distance == 0 || distance > offset {
+			return nil, errors.New(fmt.Sprintf("invalid pack: delta base out of bound at %d", offset))
+		}
+		entry.baseOffset = offset - distance
+	case ObjectRefDelta:
+		var id [GitOidRawSize]byte
+		if _, err = io.ReadFull(reader, id[:]); err != nil {
+			return nil, truncated
+		}
+		entry.baseId = NewOidFromBytes(id[:])
+	default:
+		return nil, errors.New(fmt.Sprintf("invalid pack: bad object type at %d", offset))
+	}
+
+	entry.dataOffset = reader.offset
+	inflater, err := zlib.NewReader(reader)
+	var inflated int64
+	if err == nil {
+		var writer io.Writer = ioutil.Discard
+		if hasher != nil {
+			writer = hasher
+		}
+		inflated, err = io.Copy(writer, inflater)
+	}
+	if err == nil {
+		err = inflater.Close()
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid pack: bad compressed data at %d: %s", offset, err.Error()))
+	}
+	if uint64(inflated) != size {
+		return nil, errors.New(fmt.Sprintf("invalid pack: size mismatch at %d", offset))
+	}
+	entry.end = reader.offset
+	entry.crc = reader.crc.Sum32()
+	if hasher != nil {
+		if entry.id, err = sumHasher(hasher); err != nil {
+			return nil, err
+		}
+		entry.realType = entry.objType
+		ix.stats.IndexedObjects++
+		if err := ix.reportProgress(); err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+// readEntryData inflates the data of the entry from the file.
+func (ix *Indexer) readEntryData(entry *indexerEntry) ([]byte, error) {
+	inflater, err := zlib.NewReader(io.NewSectionReader(ix.file, int64(entry.dataOffset), int64(entry.end-entry.dataOffset)))
+	if err != nil {
+		return nil, err
+	}
+	defer inflater.Close()
+	return ioutil.ReadAll(inflater)
+}
+
+// indexerCache keeps the data of resolved objects by their offsets. It is
+// emptied when it gets too large.
+type indexerCache struct {
+	objects map[uint64][]byte
+	size    int
+}
+
+func (ix *Indexer) objectData(entry *indexerEntry, cache *indexerCache) ([]byte, error) {
+	if entry.realData != nil {
+		return entry.realData, nil
+	}
+	if data, ok := cache.objects[entry.offset]; ok {
+		return data, nil
+	}
+	data, err := ix.readEntryData(entry)
+	if err != nil {
+		return nil, err
+	}
+	if entry.objType == ObjectOfsDelta || entry.objType == ObjectRefDelta {
+		base, err := ix.objectData(entry.base, cache)
+		if err != nil {
+			return nil, err
+		}
+		data, err = ApplyDelta(base, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cache.size+len(data) > indexerCacheSize {
+		cache.objects = make(map[uint64][]byte)
+		cache.size = 0
+	}
+	cache.objects[entry.offset] = data
+	cache.size += len(data)
+	return data, nil
+}
+
+// resolveEntries computes the ids of the deltas. Deltas are resolved when
+// their bases are. Bases of REF_DELTAs that are not in the pack are read
+// from the object database and are returned to be appended.
+func (ix *Indexer) resolveEntries(entries []*indexerEntry, byOffset map[uint64]*indexerEntry) ([]*indexerEntry, error) {
+	byId := make(map[Oid]*indexerEntry)
+	var pending []*indexerEntry
+	for _, entry := range entries {
+		if entry.id != nil {
+			byId[*entry.id] = entry
+			continue
+		}
+		ix.stats.TotalDeltas++
+		pending = append(pending, entry)
+	}
+
+	cache := &indexerCache{objects: make(map[uint64][]byte)}
+	var external []*indexerEntry
+	for len(pending) > 0 {
+		var next []*indexerEntry
+		for _, entry := range pending {
+			var base *indexerEntry
+			if entry.objType == ObjectOfsDelta {
+				base = byOffset[entry.baseOffset]
+				if base == nil {
+					return nil, errors.New(fmt.Sprintf("invalid pack: no object at %d", entry.baseOffset))
+				}
+			} else {
+				base = byId[*entry.baseId]
+			}
+			if base == nil || base.id == nil {
+				next = append(next, entry)
+				continue
+			}
+			entry.base = base
+			data, err := ix.objectData(entry, cache)
+			if err != nil {
+				return nil, err
+			}
+			entry.realType = base.realType
+			entry.id, err = hash(data, entry.realType)
+			if err != nil {
+				return nil, err
+			}
+			byId[*entry.id] = entry
+			ix.stats.IndexedObjects++
+			ix.stats.IndexedDeltas++
+			if err := ix.reportProgress(); err != nil {
+				return nil, err
+			}
+		}
+		if len(next) == len(pending) {
+			// the pack is thin: the rest depends on objects that it does
+			// not have
+			added := false
+			for _, entry := range next {
+				if entry.objType != ObjectRefDelta || byId[*entry.baseId] != nil {
+					continue
+				}
+				base, err := ix.readLocalBase(entry.baseId)
+				if err != nil {
+					return nil, err
+				}
+				byId[*base.id] = base
+				external = append(external, base)
+				ix.stats.LocalObjects++
+				added = true
+			}
+			if !added {
+				return nil, errors.New("invalid pack: unresolved deltas")
+			}
+		}
+		pending = next
+	}
+	return external, nil
+}
+
+func (ix *Indexer) readLocalBase(id *Oid) (*indexerEntry, error) {
+	if ix.odb == nil {
+		return nil, MakeGitError(fmt.Sprintf("missing delta base %s of a thin pack", id.String()), ErrNotFound)
+	}
+	obj, err := ix.odb.readNoReplace(id)
+	if err != nil {
+		return nil, MakeGitError(fmt.Sprintf("missing delta base %s of a thin pack", id.String()), ErrNotFound)
+	}
+	defer obj.Release()
+	data := make([]byte, len(obj.Data))
+	copy(data, obj.Data)
+	return &indexerEntry{
+		id:       id,
+		realType: obj.Type,
+		realData: data,
+	}, nil
+}
@@ -0,0 +1,117 @@
+package git4go
+
+import (
+	"./testutil"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func buildThinPack(repo *Repository) []byte {
+	third, _ := NewOid("4a202b346bb0fb0db7eff3cffeb3c70babbd2045")
+	base, _ := NewOid("c47800c7266a2be04c571c04d5a6614691ea99bd")
+	second, _ := NewOid("5b5b025afb0b4c913b4c338a42934a3863bf3644")
+	objects, _ := repo.EnumerateObjects([]*Oid{third}, []*Oid{second})
+	builder, _ := repo.NewPackBuilder()
+	builder.InsertObjects(objects)
+	builder.SetThinBases([]*Oid{base, second})
+	var buffer bytes.Buffer
+	builder.Write(&buffer)
+	return buffer.Bytes()
+}
+
+func Test_IndexerFixThinPack(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/bitmap.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/bitmap.git")
+	odb, _ := repo.Odb()
+	os.MkdirAll("test_resources/bitmap.git/new/pack", 0777)
+	indexer := NewIndexer("test_resources/bitmap.git/new/pack", odb)
+	indexer.Write(buildThinPack(repo))
+	_, err := indexer.Commit()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	stats := indexer.Stats()
+	if stats.TotalObjects != 3 || stats.IndexedDeltas != 1 || stats.LocalObjects != 1 {
+		t.Errorf("wrong stats: %+v", stats)
+	}
+
+	backend := NewOdbBackendPacked("test_resources/bitmap.git/new")
+	for _, hex := range []string{
+		"4a202b346bb0fb0db7eff3cffeb3c70babbd2045",
+		"c47800c7266a2be04c571c04d5a6614691ea99bd",
+		"fd093bff70906175335656e6ce6ae05783708765",
+	} {
+		id, _ := NewOid(hex)
+		written, err := backend.Read(id)
+		if err != nil {
+			t.Error("err should be nil:", hex, err)
+			continue
+		}
+		original, _ := odb.Read(id)
+		if written.Type != original.Type || !bytes.Equal(written.Data, original.Data) {
+			t.Error("indexed object is different:", hex)
+		}
+	}
+}
+
+func Test_IndexerThinPackWithoutOdb(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/bitmap.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/bitmap.git")
+	os.MkdirAll("test_resources/bitmap.git/new/pack", 0777)
+	indexer := NewIndexer("test_resources/bitmap.git/new/pack", nil)
+	indexer.Write(buildThinPack(repo))
+	_, err := indexer.Commit()
+	if !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should fail because the base is missing:", err)
+	}
+}
+
+func Test_IndexerChecksumMismatch(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/bitmap.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/bitmap.git")
+	pack := buildThinPack(repo)
+	pack[len(pack)-1] ^= 0xff
+	indexer := NewIndexer("test_resources/bitmap.git/new/pack", nil)
+	indexer.Write(pack)
+	_, err := indexer.Commit()
+	if err == nil {
+		t.Error("it should detect the broken checksum")
+	}
+}
+
+func Test_IndexerHashCollision(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/bitmap.git")
+	defer testutil.CleanupWorkspace()
+	defer SetHashAlgorithm(HashSha1)
+	SetHashAlgorithm(HashSha1CollisionDetection)
+
+	repo, _ := OpenRepository("test_resources/bitmap.git")
+	odb, _ := repo.Odb()
+	os.MkdirAll("test_resources/bitmap.git/new/pack", 0777)
+	indexer := NewIndexer("test_resources/bitmap.git/new/pack", odb)
+	indexer.Write(buildThinPack(repo))
+	if _, err := indexer.Commit(); err != nil {
+		t.Error("it should index packs without collisions:", err)
+	}
+
+	// the attack blocks only collide from the start of the hashed data,
+	// which is the "PACK" header for a real pack
+	data, err := ioutil.ReadFile("test_resources/collision/sha-mbles-1.bin")
+	if err != nil {
+		t.Fatal("can't read test data:", err)
+	}
+	indexer = NewIndexer("test_resources/bitmap.git/new/pack", odb)
+	defer indexer.Close()
+	indexer.Write(data)
+	if _, err = indexer.checksum(uint64(len(data))); !IsErrorCode(err, ErrHashCollision) {
+		t.Error("it should detect the collision attack in the pack data:", err)
+	}
+}
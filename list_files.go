@@ -0,0 +1,324 @@
+package git4go
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type ListFilesFlag uint
+
+const (
+	// the files of the index; the default if no other kind is selected
+	ListFilesCached ListFilesFlag = 1 << iota
+	// the files of the index that are missing in the working directory
+	ListFilesDeleted
+	// the files of the index that changed in the working directory,
+	// deleted ones included
+	ListFilesModified
+	// the files of the working directory that are not in the index
+	ListFilesOthers
+	// only the ignored files, with ListFilesOthers or ListFilesCached
+	ListFilesIgnored
+	// only the conflicted entries of the index
+	ListFilesUnmerged
+	// untracked directories are listed as "dir/" with ListFilesOthers
+	ListFilesDirectory
+	// the ignore rules of .gitignore, info/exclude and core.excludesFile
+	// apply, like --exclude-standard
+	ListFilesExcludeStandard
+)
+
+// ListFilesStatus is the tag of "git ls-files -t".
+type ListFilesStatus byte
+
+const (
+	ListFilesStatusCached       ListFilesStatus = 'H'
+	ListFilesStatusSkipWorktree ListFilesStatus = 'S'
+	ListFilesStatusUnmerged     ListFilesStatus = 'M'
+	ListFilesStatusRemoved      ListFilesStatus = 'R'
+	ListFilesStatusModified     ListFilesStatus = 'C'
+	ListFilesStatusOther        ListFilesStatus = '?'
+)
+
+type ListFilesOptions struct {
+	Flags ListFilesFlag
+	// Pathspecs limit the files to the ones that they match: a pathspec
+	// matches the path and the files below it, and "*", "?" and "[...]"
+	// match any characters, slashes included, like git.
+	Pathspecs []string
+}
+
+// ListedFile is a file of ListFiles. The fields of the index entry are
+// empty for other files.
+type ListedFile struct {
+	Path   string
+	Status ListFilesStatus
+	Mode   Filemode
+	Id     *Oid
+	Stage  IndexStage
+	// the flags of the index entry
+	SkipWorktree    bool
+	AssumeUnchanged bool
+	IntentToAdd     bool
+}
+
+// ListFiles lists the files of the index and of the working directory like
+// "git ls-files": the other files first, then for each index entry the
+// cached, deleted and modified ones in the order of the index, so a file can
+// be listed more than once. Files with the skip-worktree flag are not
+// deleted or modified, and files that are assumed unchanged are not
+// modified unless they are deleted.
+func (r *Repository) ListFiles(opts *ListFilesOptions) ([]*ListedFile, error) {
+	if opts == nil {
+		opts = &ListFilesOptions{}
+	}
+	flags := opts.Flags
+	if flags&(ListFilesCached|ListFilesDeleted|ListFilesModified|ListFilesOthers|ListFilesUnmerged) == 0 {
+		flags |= ListFilesCached
+	}
+	if flags&ListFilesIgnored != 0 && flags&ListFilesExcludeStandard == 0 {
+		return nil, MakeGitError("ls-files: ignored files need the standard exclude rules", ErrInvalid)
+	}
+	if r.IsBare() && flags&(ListFilesDeleted|ListFilesModified|ListFilesOthers) != 0 {
+		return nil, MakeGitError("cannot compare the index with the working directory of a bare repository", ErrBareRepository)
+	}
+	pathspecs, err := compilePathspecs(opts.Pathspecs)
+	if err != nil {
+		return nil, err
+	}
+	index, err := r.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*ListedFile
+	if flags&ListFilesOthers != 0 {
+		tracked := make(map[string]bool)
+		for _, entry := range index.Entries {
+			tracked[entry.Path] = true
+			for dir := filepath.ToSlash(filepath.Dir(entry.Path)); dir != "."; dir = filepath.ToSlash(filepath.Dir(dir)) {
+				tracked[dir+"/"] = true
+			}
+		}
+		others, err := r.listOtherFiles("", tracked, flags)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range others {
+			if pathspecs.match(path) {
+				result = append(result, &ListedFile{Path: path, Status: ListFilesStatusOther})
+			}
+		}
+	}
+
+	filemode := true
+	if config := r.Config(); config != nil {
+		filemode, _ = config.LookupBooleanWithDefaultValue("core.filemode")
+	}
+	var perfdata CheckoutPerfdata
+	for _, entry := range index.Entries {
+		if !pathspecs.match(entry.Path) {
+			continue
+		}
+		if flags&ListFilesIgnored != 0 {
+			// tracked files that the rules would ignore
+			if ignored, err := r.IsPathIgnored(entry.Path); err != nil || !ignored {
+				continue
+			}
+		}
+		file := func(status ListFilesStatus) *ListedFile {
+			return &ListedFile{
+				Path:            entry.Path,
+				Status:          status,
+				Mode:            entry.Mode,
+				Id:              entry.Id,
+				Stage:           entry.Stage(),
+				SkipWorktree:    entry.SkipWorktree(),
+				AssumeUnchanged: entry.AssumeUnchanged(),
+				IntentToAdd:     entry.IntentToAdd(),
+			}
+		}
+		if flags&(ListFilesCached|ListFilesUnmerged) != 0 && (flags&ListFilesUnmerged == 0 || entry.Stage() != 0) {
+			switch {
+			case entry.Stage() != 0:
+				result = append(result, file(ListFilesStatusUnmerged))
+			case entry.SkipWorktree():
+				result = append(result, file(ListFilesStatusSkipWorktree))
+			default:
+				result = append(result, file(ListFilesStatusCached))
+			}
+		}
+		if flags&(ListFilesDeleted|ListFilesModified) == 0 || entry.SkipWorktree() {
+			continue
+		}
+		var delta *StatusDelta
+		if entry.AssumeUnchanged() || entry.Mode == FilemodeCommit {
+			// only the existence is checked
+			if exists, _, err := r.workdirMatches(entry.Path, entry, true, &perfdata); err != nil {
+				return nil, err
+			} else if !exists {
+				delta = &StatusDelta{Status: DeltaDeleted}
+			}
+		} else if delta, err = r.statusWorkdirDelta(entry, filemode, &perfdata); err != nil {
+			return nil, err
+		}
+		if delta == nil {
+			continue
+		}
+		if delta.Status == DeltaDeleted && flags&ListFilesDeleted != 0 {
+			result = append(result, file(ListFilesStatusRemoved))
+		}
+		if flags&ListFilesModified != 0 {
+			result = append(result, file(ListFilesStatusModified))
+		}
+	}
+	return result, nil
+}
+
+// internal functions and methods
+
+// listOtherFiles walks the directory of the working directory for the files
+// that are not in the index. Other repositories are listed as "dir/".
+func (r *Repository) listOtherFiles(dir string, tracked map[string]bool, flags ListFilesFlag) ([]string, error) {
+	entries, err := r.fs.ReadDir(filepath.Join(r.Workdir(), filepath.FromSlash(dir)))
+	if err != nil {
+		return nil, err
+	}
+	precompose := r.precomposeUnicode()
+	var paths []string
+	for _, entry := range entries {
+		if entry.Name() == GitDirName {
+			continue
+		}
+		path := dir + precomposePath(entry.Name(), precompose)
+		if tracked[path] {
+			continue
+		}
+		isDir := entry.IsDir()
+		if isDir && !tracked[path+"/"] {
+			if _, err := r.fs.Lstat(filepath.Join(r.Workdir(), filepath.FromSlash(path), GitDirName)); err == nil {
+				if ok, err := r.listOtherIgnored(path, flags); err != nil {
+					return nil, err
+				} else if ok {
+					paths = append(paths, path+"/")
+				}
+				continue
+			}
+		}
+		if isDir {
+			sub, err := r.listOtherFiles(path+"/", tracked, flags)
+			if err != nil {
+				return nil, err
+			}
+			if len(sub) > 0 && flags&ListFilesDirectory != 0 && !tracked[path+"/"] {
+				sub = []string{path + "/"}
+			}
+			paths = append(paths, sub...)
+			continue
+		}
+		if ok, err := r.listOtherIgnored(path, flags); err != nil {
+			return nil, err
+		} else if ok {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// listOtherIgnored tells if the untracked path is listed: without the
+// exclude rules all of them are, with them the ignored ones if
+// ListFilesIgnored is set and the others if it is not.
+func (r *Repository) listOtherIgnored(path string, flags ListFilesFlag) (bool, error) {
+	if flags&ListFilesExcludeStandard == 0 {
+		return true, nil
+	}
+	ignored, err := r.IsPathIgnored(path)
+	if err != nil {
+		return false, err
+	}
+	return ignored == (flags&ListFilesIgnored != 0), nil
+}
+
+type pathspecs []*pathspec
+
+type pathspec struct {
+	pattern *regexp.Regexp
+	// the part before the first wildcard
+	prefix string
+}
+
+// compilePathspecs compiles the pathspecs. No pathspec matches everything.
+func compilePathspecs(specs []string) (pathspecs, error) {
+	var result pathspecs
+	for _, spec := range specs {
+		spec = strings.Trim(filepath.ToSlash(spec), "/")
+		if spec == "" || spec == "." {
+			return nil, nil
+		}
+		var pattern strings.Builder
+		pattern.WriteString("^")
+		for i := 0; i < len(spec); i++ {
+			switch c := spec[i]; c {
+			case '*':
+				pattern.WriteString(".*")
+			case '?':
+				pattern.WriteString(".")
+			case '[':
+				end := strings.IndexByte(spec[i+1:], ']')
+				if end == -1 {
+					pattern.WriteString(`\[`)
+					continue
+				}
+				class := spec[i+1 : i+1+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				pattern.WriteString("[" + class + "]")
+				i += end + 1
+			default:
+				pattern.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		// the pathspec of a directory matches the files below it
+		pattern.WriteString("(/.*)?$")
+		compiled, err := regexp.Compile(pattern.String())
+		if err != nil {
+			return nil, MakeGitError("invalid pathspec '"+spec+"'", ErrInvalidSpec)
+		}
+		prefix := spec
+		if wildcard := strings.IndexAny(spec, "*?["); wildcard != -1 {
+			prefix = spec[:wildcard]
+		}
+		result = append(result, &pathspec{pattern: compiled, prefix: prefix})
+	}
+	return result, nil
+}
+
+func (p pathspecs) match(path string) bool {
+	if len(p) == 0 {
+		return true
+	}
+	path = strings.TrimSuffix(path, "/")
+	for _, spec := range p {
+		if spec.pattern.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// mayMatchBelow tells if the pathspecs can match paths in the directory,
+// so a walk can skip the other directories.
+func (p pathspecs) mayMatchBelow(dir string) bool {
+	if len(p) == 0 {
+		return true
+	}
+	dir = strings.TrimSuffix(dir, "/") + "/"
+	for _, spec := range p {
+		if strings.HasPrefix(spec.prefix, dir) || strings.HasPrefix(dir, spec.prefix) {
+			return true
+		}
+	}
+	return false
+}
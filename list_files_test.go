@@ -0,0 +1,78 @@
+package git4go
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func listedFiles(files []*ListedFile) []string {
+	var result []string
+	for _, file := range files {
+		result = append(result, string(file.Status)+" "+file.Path)
+	}
+	return result
+}
+
+func Test_ListFiles(t *testing.T) {
+	repo := prepareStatusRepository(t)
+	defer os.RemoveAll(repo.Workdir())
+	workdir := repo.Workdir()
+	ioutil.WriteFile(filepath.Join(workdir, "b.txt"), []byte("B\n"), 0644)
+	os.Remove(filepath.Join(workdir, "dir", "c.txt"))
+	os.Remove(filepath.Join(workdir, "a.txt"))
+	os.MkdirAll(filepath.Join(workdir, "new", "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(workdir, "new", "sub", "d.txt"), []byte("d\n"), 0644)
+	ioutil.WriteFile(filepath.Join(workdir, ".gitignore"), []byte("*.o\n"), 0644)
+	ioutil.WriteFile(filepath.Join(workdir, "e.o"), []byte("e\n"), 0644)
+
+	index, _ := repo.Index()
+	entry, _ := index.EntryByPath("a.txt", 0)
+	entry.SetSkipWorktree(true)
+	if err := index.Write(); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+
+	check := func(opts *ListFilesOptions, expected ...string) {
+		files, err := repo.ListFiles(opts)
+		if err != nil {
+			t.Fatal("err should be nil:", err)
+		}
+		listed := listedFiles(files)
+		if len(listed) != len(expected) {
+			t.Error("it should list the files:", listed)
+			return
+		}
+		for i := range expected {
+			if listed[i] != expected[i] {
+				t.Error("it should list the files:", listed)
+				return
+			}
+		}
+	}
+	check(nil, "S a.txt", "H b.txt", "H dir/c.txt")
+	check(&ListFilesOptions{Flags: ListFilesDeleted | ListFilesModified},
+		"C b.txt", "R dir/c.txt", "C dir/c.txt")
+	check(&ListFilesOptions{Flags: ListFilesOthers},
+		"? .gitignore", "? e.o", "? new/sub/d.txt")
+	check(&ListFilesOptions{Flags: ListFilesOthers | ListFilesExcludeStandard | ListFilesDirectory},
+		"? .gitignore", "? new/")
+	check(&ListFilesOptions{Flags: ListFilesOthers | ListFilesIgnored | ListFilesExcludeStandard}, "? e.o")
+	check(&ListFilesOptions{Pathspecs: []string{"dir"}}, "H dir/c.txt")
+	check(&ListFilesOptions{Flags: ListFilesCached | ListFilesOthers, Pathspecs: []string{"*.txt"}},
+		"? new/sub/d.txt", "S a.txt", "H b.txt", "H dir/c.txt")
+
+	files, _ := repo.ListFiles(nil)
+	if !files[0].SkipWorktree || files[0].Mode != FilemodeBlob || files[0].Id == nil {
+		t.Error("it should return the index entry:", files[0])
+	}
+	if _, err := repo.ListFiles(&ListFilesOptions{Flags: ListFilesOthers | ListFilesIgnored}); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should need the exclude rules for ignored files:", err)
+	}
+
+	entry, _ = index.EntryByPath("b.txt", 0)
+	entry.SetAssumeUnchanged(true)
+	index.Write()
+	check(&ListFilesOptions{Flags: ListFilesModified}, "C dir/c.txt")
+}
@@ -0,0 +1,136 @@
+package git4go
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const GitLockFileSuffix = ".lock"
+
+// Locks that are older than this are treated as left over from a crashed
+// process and are removed. Zero disables stale lock detection.
+var LockfileStaleAge = 10 * time.Minute
+
+// How long writers wait for a lock that is held by another process.
+var DefaultLockTimeout = time.Second
+
+var lockfileRetryInterval = 50 * time.Millisecond
+
+// Lockfile updates a file in the same way as git does. The new content is
+// written to "<path>.lock", which is created exclusively, and it replaces
+// the original file by renaming on Commit. Other git implementations that
+// follow the same protocol never see a half written file.
+type Lockfile struct {
+	fs       FileSystem
+	path     string
+	lockPath string
+	file     WritableFile
+}
+
+// NewLockfile takes the lock for the path. If the lock is held by someone
+// else, it retries until the timeout expires and then fails with ErrLocked.
+func NewLockfile(path string, mode os.FileMode, timeout time.Duration) (*Lockfile, error) {
+	return newLockfile(OSFileSystem, path, mode, timeout)
+}
+
+func newLockfile(fsys FileSystem, path string, mode os.FileMode, timeout time.Duration) (*Lockfile, error) {
+	lockPath := path + GitLockFileSuffix
+	start := time.Now()
+	deadline := start.Add(timeout)
+	for {
+		file, err := fsys.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+		if err == nil {
+			trace(TraceDebug, TraceCategoryLock, "acquired lock", time.Since(start), map[string]interface{}{
+				"path": lockPath,
+			})
+			return &Lockfile{
+				fs:       fsys,
+				path:     path,
+				lockPath: lockPath,
+				file:     file,
+			}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if removeStaleLock(fsys, lockPath) {
+			continue
+		}
+		if !time.Now().Before(deadline) {
+			trace(TraceWarn, TraceCategoryLock, "lock is held by another process", time.Since(start), map[string]interface{}{
+				"path": lockPath,
+			})
+			return nil, MakeGitError(fmt.Sprintf("failed to lock file '%s' for writing", path), ErrLocked)
+		}
+		time.Sleep(lockfileRetryInterval)
+	}
+}
+
+func removeStaleLock(fsys FileSystem, lockPath string) bool {
+	if LockfileStaleAge <= 0 {
+		return false
+	}
+	stat, err := fsys.Stat(lockPath)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	if time.Since(stat.ModTime()) < LockfileStaleAge {
+		return false
+	}
+	if fsys.Remove(lockPath) != nil {
+		return false
+	}
+	trace(TraceWarn, TraceCategoryLock, "removed stale lock", 0, map[string]interface{}{
+		"path": lockPath,
+		"age":  time.Since(stat.ModTime()),
+	})
+	return true
+}
+
+func (l *Lockfile) Path() string {
+	return l.path
+}
+
+func (l *Lockfile) Write(data []byte) (int, error) {
+	return l.file.Write(data)
+}
+
+// Commit flushes the written content to the disk and replaces the target
+// file with it.
+func (l *Lockfile) Commit() error {
+	if l.file == nil {
+		return MakeGitError("lockfile is already closed", ErrLocked)
+	}
+	err := l.file.Sync()
+	if err == nil {
+		err = l.file.Close()
+	} else {
+		l.file.Close()
+	}
+	l.file = nil
+	if err != nil {
+		l.fs.Remove(l.lockPath)
+		return err
+	}
+	err = l.fs.Rename(l.lockPath, l.path)
+	if err != nil {
+		l.fs.Remove(l.lockPath)
+		return err
+	}
+	trace(TraceDebug, TraceCategoryLock, "committed lock", 0, map[string]interface{}{
+		"path": l.path,
+	})
+	return nil
+}
+
+// Rollback discards the written content and releases the lock. It does
+// nothing after Commit.
+func (l *Lockfile) Rollback() {
+	if l.file == nil {
+		return
+	}
+	l.file.Close()
+	l.file = nil
+	l.fs.Remove(l.lockPath)
+}
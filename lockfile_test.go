@@ -0,0 +1,81 @@
+package git4go
+
+import (
+	"./testutil"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_Lockfile_Commit(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-lock")
+	defer testutil.CleanupEmptyWorkDir()
+	path := filepath.Join("test-lock", "config")
+	ioutil.WriteFile(path, []byte("old"), 0666)
+
+	lock, err := NewLockfile(path, 0666, 0)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	_, err = NewLockfile(path, 0666, 0)
+	if !IsErrorCode(err, ErrLocked) {
+		t.Error("it should fail while the lock is held:", err)
+	}
+	lock.Write([]byte("new"))
+	content, _ := ioutil.ReadFile(path)
+	if string(content) != "old" {
+		t.Error("it should not modify target file before commit")
+	}
+	err = lock.Commit()
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	content, _ = ioutil.ReadFile(path)
+	if string(content) != "new" {
+		t.Error("it should replace target file:", string(content))
+	}
+	_, err = os.Stat(path + GitLockFileSuffix)
+	if !os.IsNotExist(err) {
+		t.Error("it should remove lock file")
+	}
+}
+
+func Test_Lockfile_Rollback(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-lock")
+	defer testutil.CleanupEmptyWorkDir()
+	path := filepath.Join("test-lock", "index")
+
+	lock, _ := NewLockfile(path, 0666, 0)
+	lock.Write([]byte("new"))
+	lock.Rollback()
+	_, err := os.Stat(path)
+	if !os.IsNotExist(err) {
+		t.Error("it should not create target file")
+	}
+	_, err = NewLockfile(path, 0666, 0)
+	if err != nil {
+		t.Error("it should be able to lock again:", err)
+	}
+}
+
+func Test_Lockfile_Stale(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-lock")
+	defer testutil.CleanupEmptyWorkDir()
+	path := filepath.Join("test-lock", "packed-refs")
+	ioutil.WriteFile(path+GitLockFileSuffix, []byte{}, 0666)
+
+	_, err := NewLockfile(path, 0666, 0)
+	if !IsErrorCode(err, ErrLocked) {
+		t.Error("it should respect fresh lock:", err)
+	}
+	old := time.Now().Add(-2 * LockfileStaleAge)
+	os.Chtimes(path+GitLockFileSuffix, old, old)
+	lock, err := NewLockfile(path, 0666, 0)
+	if err != nil {
+		t.Error("it should remove stale lock:", err)
+	} else {
+		lock.Rollback()
+	}
+}
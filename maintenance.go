@@ -0,0 +1,104 @@
+package git4go
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	gitGcAutoDefault          = 6700
+	gitGcAutoPackLimitDefault = 50
+	// the fan-out directory that git samples to estimate the loose objects
+	gitGcSampleDir = "17"
+)
+
+// NeedsMaintenance tells if "git gc --auto" would run: if there are more
+// than gc.autoPackLimit (50 by default) packfiles without .keep files, or
+// if the loose objects are estimated to be more than gc.auto (6700 by
+// default). Like git, the loose objects are estimated from objects/17
+// only, which has 1/256 of them. gc.auto 0 disables it. The pre-auto-gc
+// hook, which git runs before gc, is not run.
+func (r *Repository) NeedsMaintenance() (bool, error) {
+	if r.pathRepository == "" {
+		return false, nil
+	}
+	threshold := r.gcConfigInt("gc.auto", gitGcAutoDefault)
+	if threshold <= 0 {
+		return false, nil
+	}
+	objectsDir := filepath.Join(r.pathCommon, GitObjectsDir)
+	packLimit := r.gcConfigInt("gc.autoPackLimit", gitGcAutoPackLimitDefault)
+	if packLimit > 0 {
+		packs, err := r.countGcPacks(filepath.Join(objectsDir, "pack"))
+		if err != nil {
+			return false, err
+		}
+		if packs > packLimit {
+			return true, nil
+		}
+	}
+	return r.tooManyLooseObjects(filepath.Join(objectsDir, gitGcSampleDir), (threshold+255)/256)
+}
+
+// internal functions and methods
+
+func (r *Repository) gcConfigInt(name string, value int) int {
+	if config := r.Config(); config != nil {
+		for _, key := range []string{name, strings.ToLower(name)} {
+			if configValue, err := config.LookupInt32(key); err == nil {
+				return int(configValue)
+			}
+		}
+	}
+	return value
+}
+
+// countGcPacks counts the packfiles that gc would repack: those without
+// .keep files, and not the cruft packs of unreachable objects.
+func (r *Repository) countGcPacks(packDir string) (int, error) {
+	entries, err := r.fs.ReadDir(packDir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	names := make(map[string]bool)
+	for _, entry := range entries {
+		names[entry.Name()] = true
+	}
+	count := 0
+	for name := range names {
+		if !strings.HasSuffix(name, ".idx") {
+			continue
+		}
+		baseName := strings.TrimSuffix(name, ".idx")
+		if names[baseName+".pack"] && !names[baseName+".keep"] && !names[baseName+".mtimes"] {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// tooManyLooseObjects tells if the sample directory has more loose objects
+// than the limit.
+func (r *Repository) tooManyLooseObjects(sampleDir string, limit int) (bool, error) {
+	entries, err := r.fs.ReadDir(sampleDir)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	count := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if len(name) != GitOidHexSize-2 || strings.Trim(name, "0123456789abcdef") != "" {
+			continue
+		}
+		count++
+		if count > limit {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,56 @@
+package git4go
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Repository_NeedsMaintenance(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_maintenance")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	config := repo.Config()
+
+	needed, err := repo.NeedsMaintenance()
+	if err != nil || needed {
+		t.Error("it should not need maintenance in a new repository:", err)
+	}
+
+	// 512 allows two objects in the sample directory
+	config.SetInt32("gc.auto", 512)
+	sampleDir := filepath.Join(dir, ".git", "objects", "17")
+	os.MkdirAll(sampleDir, 0755)
+	for i := 0; i < 2; i++ {
+		ioutil.WriteFile(filepath.Join(sampleDir, fmt.Sprintf("%038x", i)), nil, 0444)
+	}
+	ioutil.WriteFile(filepath.Join(sampleDir, "tmp_obj_123"), nil, 0444)
+	if needed, _ := repo.NeedsMaintenance(); needed {
+		t.Error("it should not count up to the limit")
+	}
+	ioutil.WriteFile(filepath.Join(sampleDir, fmt.Sprintf("%038x", 2)), nil, 0444)
+	if needed, _ := repo.NeedsMaintenance(); !needed {
+		t.Error("it should need maintenance for too many loose objects")
+	}
+	config.SetInt32("gc.auto", 0)
+	if needed, _ := repo.NeedsMaintenance(); needed {
+		t.Error("it should be disabled by gc.auto 0")
+	}
+
+	config.SetInt32("gc.auto", 6700)
+	config.SetInt32("gc.autoPackLimit", 1)
+	packDir := filepath.Join(dir, ".git", "objects", "pack")
+	for _, name := range []string{"pack-1", "pack-2"} {
+		ioutil.WriteFile(filepath.Join(packDir, name+".idx"), nil, 0444)
+		ioutil.WriteFile(filepath.Join(packDir, name+".pack"), nil, 0444)
+	}
+	if needed, _ := repo.NeedsMaintenance(); !needed {
+		t.Error("it should need maintenance for too many packfiles")
+	}
+	ioutil.WriteFile(filepath.Join(packDir, "pack-2.keep"), nil, 0444)
+	if needed, _ := repo.NeedsMaintenance(); needed {
+		t.Error("it should not count the kept packfiles")
+	}
+}
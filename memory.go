@@ -0,0 +1,291 @@
+package git4go
+
+import (
+	"strconv"
+	"unsafe"
+)
+
+// CacheStatistics is the memory that a repository holds in its caches. The
+// sizes are in bytes; those of parsed commits, names and references are
+// estimated from the lengths of their strings and ids.
+type CacheStatistics struct {
+	// Parsed commits of the CommitCache
+	Commits     int
+	CommitsSize uint64
+	// Interned names of tree entries
+	Names     int
+	NamesSize uint64
+	// The packed references that were read from packed-refs
+	PackedRefs     int
+	PackedRefsSize uint64
+	ShallowRoots   int
+	ShallowSize    uint64
+	// The mapped windows of the packfiles, including those in use. Packfiles
+	// are shared by the repositories that open the same path, so their
+	// windows are counted by each of them.
+	PackWindows     int
+	PackWindowsSize uint64
+	// The mapped pack indexes
+	PackIndexSize uint64
+	// The reverse indexes of the packfiles that were built for offset
+	// ordered reads
+	ReverseIndexSize uint64
+	// The commit-graph file that was read
+	CommitGraphSize uint64
+	// The cached names of the loose object directories
+	LooseNames     int
+	LooseNamesSize uint64
+	// The cached results of MergeBase, AheadBehind and DescendantOf
+	GraphResults     int
+	GraphResultsSize uint64
+	// The cached listings of the reference directories, for the case
+	// checks of core.ignoreCase
+	RefDirListings     int
+	RefDirListingsSize uint64
+}
+
+// TotalSize returns the sum of the sizes.
+func (s *CacheStatistics) TotalSize() uint64 {
+	return s.CommitsSize + s.NamesSize + s.PackedRefsSize + s.ShallowSize +
+		s.PackWindowsSize + s.PackIndexSize + s.ReverseIndexSize + s.CommitGraphSize + s.LooseNamesSize +
+		s.GraphResultsSize + s.RefDirListingsSize
+}
+
+// CacheStatistics reports the memory that the caches of the repository
+// hold, e.g. to decide which of the repositories of a server should call
+// FreeUnusedMemory. Caches that were not created yet are not created.
+func (r *Repository) CacheStatistics() *CacheStatistics {
+	stats := &CacheStatistics{}
+	stats.Commits, stats.CommitsSize = r.commitCache.size()
+	stats.Names, stats.NamesSize = r.names.size()
+	stats.GraphResults, stats.GraphResultsSize = r.graphCache.size()
+
+	r.refDbLock.Lock()
+	refDb := r.refDb
+	r.refDbLock.Unlock()
+	if refDb != nil {
+		stats.PackedRefs, stats.PackedRefsSize = refDb.cache.size()
+		stats.RefDirListings, stats.RefDirListingsSize = refDb.listings.size()
+	}
+
+	r.shallowLock.Lock()
+	stats.ShallowRoots = len(r.shallow)
+	stats.ShallowSize = uint64(len(r.shallow)) * GitOidRawSize
+	r.shallowLock.Unlock()
+
+	r.commitGraphLock.Lock()
+	if r.commitGraph != nil {
+		stats.CommitGraphSize = uint64(r.commitGraph.size)
+	}
+	r.commitGraphLock.Unlock()
+
+	for _, pack := range r.openedPacks() {
+		pack.addCacheStatistics(stats)
+	}
+	for _, loose := range r.openedLooseBackends() {
+		names, size := loose.dirCacheSize()
+		stats.LooseNames += names
+		stats.LooseNamesSize += size
+	}
+	return stats
+}
+
+// ClearCaches empties the caches of parsed data: commits, names, packed
+// references, the listings of the reference directories, shallow roots,
+// the commit-graph, the names of the loose object directories and the
+// results of MergeBase, AheadBehind and DescendantOf. They are read again when they are needed.
+// Objects that were returned before are not changed.
+func (r *Repository) ClearCaches() {
+	r.commitCache.Clear()
+	r.names.clear()
+	r.graphCache.clear()
+
+	r.refDbLock.Lock()
+	refDb := r.refDb
+	r.refDbLock.Unlock()
+	if refDb != nil {
+		refDb.cache.evict()
+		refDb.listings.clear()
+	}
+
+	if r.pathRepository != "" {
+		// the shallow roots of an in-memory repository are not cached but
+		// stored there
+		r.shallowLock.Lock()
+		r.shallow = nil
+		r.shallowLock.Unlock()
+	}
+
+	r.commitGraphLock.Lock()
+	r.commitGraph = nil
+	r.commitGraphLock.Unlock()
+
+	for _, loose := range r.openedLooseBackends() {
+		loose.ClearDirectoryCache()
+	}
+}
+
+// FreeUnusedMemory clears the caches like ClearCaches and unmaps the
+// windows of the packfiles that are not in use, for long-running processes
+// that host many repositories and keep the idle ones open. It returns the
+// number of bytes that were released, which are estimated for the caches.
+func (r *Repository) FreeUnusedMemory() uint64 {
+	before := r.CacheStatistics()
+	r.ClearCaches()
+	var unmapped uint64
+	for _, pack := range r.openedPacks() {
+		unmapped += pack.mwf.freeUnused()
+	}
+	return before.CommitsSize + before.NamesSize + before.PackedRefsSize + before.ShallowSize +
+		before.CommitGraphSize + before.LooseNamesSize + before.GraphResultsSize + before.RefDirListingsSize + unmapped
+}
+
+// internal functions and methods
+
+// openedPacks returns the packfiles of the packed backends, if the object
+// database was opened.
+func (r *Repository) openedPacks() []*PackFile {
+	r.odbLock.Lock()
+	odb := r.odb
+	r.odbLock.Unlock()
+	if odb == nil {
+		return nil
+	}
+	var packs []*PackFile
+	for _, backend := range odb.backendList() {
+		packed, ok := backend.(*OdbBackendPacked)
+		if !ok {
+			continue
+		}
+		packed.lock.Lock()
+		packs = append(packs, packed.packs...)
+		packed.lock.Unlock()
+	}
+	return packs
+}
+
+// openedLooseBackends returns the loose backends, if the object database
+// was opened.
+func (r *Repository) openedLooseBackends() []*OdbBackendLoose {
+	r.odbLock.Lock()
+	odb := r.odb
+	r.odbLock.Unlock()
+	if odb == nil {
+		return nil
+	}
+	var backends []*OdbBackendLoose
+	for _, backend := range odb.backendList() {
+		if loose, ok := backend.(*OdbBackendLoose); ok {
+			backends = append(backends, loose)
+		}
+	}
+	return backends
+}
+
+// the estimated size of a parsed commit without its strings and parents
+var commitOverhead = uint64(unsafe.Sizeof(Commit{}) + 2*unsafe.Sizeof(Signature{}) + 2*unsafe.Sizeof(Oid{}))
+
+func (c *CommitCache) size() (int, uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var size uint64
+	for element := c.lru.Front(); element != nil; element = element.Next() {
+		commit := element.Value.(*Commit)
+		size += commitOverhead + uint64(len(commit.message)+len(commit.summary)+len(commit.rawHeader))
+		size += uint64(len(commit.Parents)) * uint64(unsafe.Sizeof(Oid{})+unsafe.Sizeof(commit.oid))
+		for _, signature := range []*Signature{commit.author, commit.committer} {
+			if signature != nil {
+				size += uint64(len(signature.Name) + len(signature.Email))
+			}
+		}
+	}
+	return c.lru.Len(), size
+}
+
+func (p *stringPool) size() (int, uint64) {
+	if p == nil {
+		return 0, 0
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var size uint64
+	for value := range p.strings {
+		// the key and the value share the bytes
+		size += uint64(len(value)) + 2*uint64(unsafe.Sizeof(value))
+	}
+	return len(p.strings), size
+}
+
+func (p *stringPool) clear() {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.strings = make(map[string]string)
+}
+
+func (c *graphCache) size() (int, uint64) {
+	if c == nil {
+		return 0, 0
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var size uint64
+	for element := c.lru.Front(); element != nil; element = element.Next() {
+		size += uint64(unsafe.Sizeof(graphCacheEntry{}))
+		if element.Value.(*graphCacheEntry).base != nil {
+			size += uint64(unsafe.Sizeof(Oid{}))
+		}
+	}
+	return c.lru.Len(), size
+}
+
+func (c *PackRefSortedCache) size() (int, uint64) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	var size uint64
+	for _, item := range c.items {
+		size += uint64(unsafe.Sizeof(*item)) + uint64(len(item.name))
+		if item.oid != nil {
+			size += uint64(unsafe.Sizeof(Oid{}))
+		}
+		if item.peel != nil {
+			size += uint64(unsafe.Sizeof(Oid{}))
+		}
+	}
+	return len(c.items), size
+}
+
+// evict drops the packed references; the next lookup reads packed-refs
+// again. Subscribers are only notified if the file was changed meanwhile.
+func (c *PackRefSortedCache) evict() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.path == "" {
+		// nothing is read from the disk for an in-memory repository
+		return
+	}
+	c.clear(false)
+	c.evicted = true
+}
+
+func (p *PackFile) addCacheStatistics(stats *CacheStatistics) {
+	p.lock.RLock()
+	stats.PackIndexSize += uint64(len(p.indexMap))
+	stats.ReverseIndexSize += uint64(len(p.revOffsets))*8 + uint64(len(p.revPositions))*strconv.IntSize/8
+	p.lock.RUnlock()
+
+	mwindowMutex.Lock()
+	defer mwindowMutex.Unlock()
+	for _, window := range p.mwf.windows {
+		stats.PackWindows++
+		stats.PackWindowsSize += uint64(len(window.windowMap))
+	}
+}
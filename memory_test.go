@@ -0,0 +1,88 @@
+package git4go
+
+import (
+	"./testutil"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Repository_FreeUnusedMemory(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+	ioutil.WriteFile(filepath.Join("test_resources/testrepo.git", "packed-refs"),
+		[]byte(commitHead+" refs/heads/packed\n"), 0644)
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	oid, _ := NewOid(commitHead)
+	repo.LookupCommit(oid)
+	odb, _ := repo.Odb()
+	packedId, _ := NewOid(testutil.PackedObjects[0])
+	if _, err := odb.Read(packedId); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if _, err := repo.LookupReference("refs/heads/packed"); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+
+	stats := repo.CacheStatistics()
+	if stats.Commits != 1 || stats.CommitsSize == 0 {
+		t.Error("it should report the cached commits:", stats.Commits, stats.CommitsSize)
+	}
+	if stats.PackedRefs != 1 || stats.PackedRefsSize == 0 {
+		t.Error("it should report the packed references:", stats.PackedRefs)
+	}
+	if stats.PackWindows == 0 || stats.PackWindowsSize == 0 || stats.PackIndexSize == 0 {
+		t.Error("it should report the mapped packfiles:", stats.PackWindows, stats.PackIndexSize)
+	}
+	if stats.TotalSize() < stats.PackWindowsSize+stats.CommitsSize {
+		t.Error("it should sum the sizes:", stats.TotalSize())
+	}
+
+	freed := repo.FreeUnusedMemory()
+	if freed < stats.PackWindowsSize+stats.CommitsSize {
+		t.Error("it should report the released memory:", freed)
+	}
+	stats = repo.CacheStatistics()
+	if stats.Commits != 0 || stats.PackedRefs != 0 || stats.PackWindows != 0 {
+		t.Error("it should empty the caches and unmap the windows:", stats.Commits, stats.PackedRefs, stats.PackWindows)
+	}
+	if stats.PackIndexSize == 0 {
+		t.Error("it should keep the pack indexes")
+	}
+
+	if _, err := repo.LookupReference("refs/heads/packed"); err != nil {
+		t.Error("it should read packed-refs again:", err)
+	}
+	if _, err := odb.Read(packedId); err != nil {
+		t.Error("it should map the packfile again:", err)
+	}
+	if _, err := repo.LookupCommit(oid); err != nil {
+		t.Error("it should parse the commit again:", err)
+	}
+}
+
+func Test_Repository_ClearCaches_RefDirListings(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	config := repo.Config()
+	config.SetBool("core.ignorecase", true)
+	if _, err := repo.LookupReference("refs/heads/master"); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+
+	stats := repo.CacheStatistics()
+	if stats.RefDirListings == 0 || stats.RefDirListingsSize == 0 {
+		t.Error("it should report the directory listings:", stats.RefDirListings, stats.RefDirListingsSize)
+	}
+	if stats.TotalSize() < stats.RefDirListingsSize {
+		t.Error("it should sum the size of the listings:", stats.TotalSize())
+	}
+
+	repo.ClearCaches()
+	if stats = repo.CacheStatistics(); stats.RefDirListings != 0 {
+		t.Error("it should clear the directory listings:", stats.RefDirListings)
+	}
+}
@@ -0,0 +1,662 @@
+package git4go
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+type MergeAnalysis int
+
+const (
+	// No merge is possible
+	MergeAnalysisNone MergeAnalysis = 0
+	// A normal merge, both HEAD and the given head have diverged
+	MergeAnalysisNormal MergeAnalysis = 1 << 0
+	// The given head is reachable from HEAD, there is nothing to merge
+	MergeAnalysisUpToDate MergeAnalysis = 1 << 1
+	// HEAD is reachable from the given head, it can be fast-forwarded
+	MergeAnalysisFastForward MergeAnalysis = 1 << 2
+	// HEAD is unborn and can be pointed to the given head
+	MergeAnalysisUnborn MergeAnalysis = 1 << 3
+)
+
+type MergePreference int
+
+const (
+	// No configuration was found
+	MergePreferenceNone MergePreference = 0
+	// merge.ff is false, a merge commit is always created
+	MergePreferenceNoFastForward MergePreference = 1 << 0
+	// merge.ff is "only", only fast-forwards are allowed
+	MergePreferenceFastForwardOnly MergePreference = 1 << 1
+)
+
+// MergeAnalysis tells how the head (usually from FETCH_HEAD or an
+// upstream branch) can be merged into HEAD, and which kind of merge the
+// user prefers from merge.ff.
+func (r *Repository) MergeAnalysis(theirHeads []*Oid) (MergeAnalysis, MergePreference, error) {
+	if len(theirHeads) != 1 {
+		return MergeAnalysisNone, MergePreferenceNone, MakeGitError("can only merge a single branch", ErrInvalid)
+	}
+	preference := r.mergePreference()
+	head, err := r.LookupReference(GitHeadFile)
+	if err != nil {
+		return MergeAnalysisNone, preference, err
+	}
+	if head.Type() == ReferenceSymbolic {
+		head, err = head.Resolve()
+		if IsErrorCode(err, ErrNotFound) {
+			return MergeAnalysisFastForward | MergeAnalysisUnborn, preference, nil
+		} else if err != nil {
+			return MergeAnalysisNone, preference, err
+		}
+	}
+	ours := head.Target()
+	theirs := theirHeads[0]
+	base, err := r.MergeBase(ours, theirs)
+	if IsErrorCode(err, ErrNotFound) {
+		return MergeAnalysisNormal, preference, nil
+	} else if err != nil {
+		return MergeAnalysisNone, preference, err
+	}
+	switch {
+	case base.Equal(theirs):
+		return MergeAnalysisUpToDate, preference, nil
+	case base.Equal(ours):
+		return MergeAnalysisFastForward | MergeAnalysisNormal, preference, nil
+	}
+	return MergeAnalysisNormal, preference, nil
+}
+
+type MergeOptions struct {
+	// The options of the files that both sides changed. The labels are the
+	// paths of the files if they are not set.
+	FileOptions *MergeFileOptions
+	// The merge stops with ErrMergeConflict at the first conflict instead
+	// of returning an index that has the conflicts
+	FailOnConflict bool
+	// Don't detect the files that the sides renamed. Otherwise they are
+	// detected unless merge.renames is false.
+	NoRenames bool
+	// The similarity in percent from which a deleted and an added file are
+	// a rename. 0 means 50 like git.
+	RenameThreshold int
+}
+
+// MergeTrees merges the changes that ours and theirs made to the ancestor.
+// A nil ancestor is an empty tree. The result is an index that is not
+// written anywhere; the paths that could not be merged are conflicts in it.
+//
+// Renamed files are merged at their new paths, and the files that one side
+// added to a directory that the other side renamed follow the rename like
+// with the ort strategy of git. A rename that conflicts is recorded as a
+// name entry of the index with the paths of the file on each side.
+func (r *Repository) MergeTrees(ancestor, ours, theirs *Tree, opts *MergeOptions) (*Index, error) {
+	index, _, err := r.mergeTrees(ancestor, ours, theirs, opts)
+	return index, err
+}
+
+// MergeCommits merges the trees of the commits with the tree of their
+// merge base. Unrelated histories are merged with an empty tree. If there
+// are several merge bases, like after criss-cross merges, they are merged
+// into a virtual ancestor first like the recursive strategy of git; the
+// conflicts of that merge stay in the ancestor with their markers.
+func (r *Repository) MergeCommits(ours, theirs *Commit, opts *MergeOptions) (*Index, error) {
+	ancestor, ourTree, theirTree, err := r.mergeCommitTrees(ours, theirs)
+	if err != nil {
+		return nil, err
+	}
+	return r.MergeTrees(ancestor, ourTree, theirTree, opts)
+}
+
+type MergeConflictType int
+
+const (
+	// Both sides changed the file. The tree has it with conflict markers.
+	MergeConflictContent MergeConflictType = iota + 1
+	// One side changed the file and the other one deleted it. The tree has
+	// the changed file.
+	MergeConflictModifyDelete
+	// A file of one side is a directory of the other one. The tree has the
+	// directory.
+	MergeConflictFileDirectory
+	// Both sides changed a symlink or a submodule, or changed the type of
+	// the file differently. The tree has ours.
+	MergeConflictUnmergeable
+	// One side renamed the file and the other one deleted it. The tree has
+	// the renamed file.
+	MergeConflictRenameDelete
+	// The sides renamed the file to different paths. Each of the paths is
+	// a conflict, and the tree has the file of the side at it.
+	MergeConflictRenameRename
+	// One side added the file to a directory that the other one renamed,
+	// and merge.directoryRenames is not true. The tree has the file in the
+	// renamed directory.
+	MergeConflictFileLocation
+	// The sides renamed different files to the same path. The tree has
+	// ours at it, and the file that the other side changed at its old
+	// path.
+	MergeConflictRenameRename2to1
+)
+
+func (t MergeConflictType) String() string {
+	switch t {
+	case MergeConflictContent:
+		return "content"
+	case MergeConflictModifyDelete:
+		return "modify/delete"
+	case MergeConflictFileDirectory:
+		return "file/directory"
+	case MergeConflictUnmergeable:
+		return "unmergeable"
+	case MergeConflictRenameDelete:
+		return "rename/delete"
+	case MergeConflictRenameRename:
+		return "rename/rename"
+	case MergeConflictFileLocation:
+		return "file location"
+	case MergeConflictRenameRename2to1:
+		return "rename/rename(2to1)"
+	}
+	return ""
+}
+
+// MergeConflict is a path that could not be merged. Ancestor, Ours and
+// Theirs are the entries of the stages 1 to 3, nil if the side does not
+// have the file.
+type MergeConflict struct {
+	Path string
+	Type MergeConflictType
+	// The path of the file in the ancestor if a side renamed it
+	AncestorPath string
+	Ancestor     *IndexEntry
+	Ours         *IndexEntry
+	Theirs       *IndexEntry
+}
+
+// MergePreview is the result of a merge that is only written to the object
+// database, like "git merge-tree --write-tree".
+type MergePreview struct {
+	// The merged tree. The conflicts are in it as described by their types.
+	Tree *Oid
+	// The conflicts sorted by their paths. The merge is clean if it is
+	// empty.
+	Conflicts []*MergeConflict
+}
+
+// MergeTreesPreview merges the trees like MergeTrees, but writes the
+// result as a tree with the conflicts resolved like git merge-tree does,
+// and lists the conflicts. The index and the working directory are never
+// used, so it suits merge previews on servers and bare repositories. The
+// conflict markers are labeled "ours" and "theirs" unless the file options
+// set the labels. FailOnConflict is ignored.
+func (r *Repository) MergeTreesPreview(ancestor, ours, theirs *Tree, opts *MergeOptions) (*MergePreview, error) {
+	var mergeOpts MergeOptions
+	if opts != nil {
+		mergeOpts = *opts
+	}
+	mergeOpts.FailOnConflict = false
+	merged, renames, err := r.mergeTrees(ancestor, ours, theirs, &mergeOpts)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := NewIndex()
+	if err != nil {
+		return nil, err
+	}
+	dirs := make(map[string]bool)
+	for _, entry := range merged.Entries {
+		if entry.Stage() == 0 {
+			resolved.Add(&IndexEntry{Path: entry.Path, Mode: entry.Mode, Id: entry.Id})
+		}
+		// the files of conflicts in a directory are kept too
+		for dir := filepath.Dir(entry.Path); dir != "."; dir = filepath.Dir(dir) {
+			dirs[filepath.ToSlash(dir)] = true
+		}
+	}
+	iterator, err := merged.ConflictIterator()
+	if err != nil {
+		return nil, err
+	}
+	preview := &MergePreview{}
+	for {
+		conflict, err := iterator.Next()
+		if IsErrorCode(err, ErrIterOver) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		result := &MergeConflict{
+			Path:     conflictPath(conflict),
+			Ancestor: copyStage(conflict.Ancestor),
+			Ours:     copyStage(conflict.Our),
+			Theirs:   copyStage(conflict.Their),
+		}
+		if name := renames.names[result.Path]; name != nil {
+			result.AncestorPath = name.ancestor
+		}
+		var kept *IndexEntry
+		renameType, renamed := renames.conflicts[result.Path]
+		switch {
+		case dirs[result.Path]:
+			result.Type = MergeConflictFileDirectory
+		case renamed:
+			result.Type = renameType
+			kept = conflict.Our
+			if kept == nil {
+				kept = conflict.Their
+			}
+		case conflict.Our == nil || conflict.Their == nil:
+			result.Type = MergeConflictModifyDelete
+			kept = conflict.Our
+			if kept == nil {
+				kept = conflict.Their
+			}
+		case isMergeableEntry(conflict.Our) && isMergeableEntry(conflict.Their):
+			result.Type = MergeConflictContent
+			contents, err := r.mergeConflictContents(conflict, "ours", "theirs", opts)
+			if err != nil {
+				return nil, err
+			}
+			oid, err := r.CreateBlobFromBuffer(contents.Contents)
+			if err != nil {
+				return nil, err
+			}
+			mode := contents.Mode
+			if mode == 0 {
+				mode = conflict.Our.Mode
+			}
+			kept = &IndexEntry{Mode: mode, Id: oid}
+		default:
+			result.Type = MergeConflictUnmergeable
+			kept = conflict.Our
+		}
+		if kept != nil {
+			resolved.Add(&IndexEntry{Path: result.Path, Mode: kept.Mode, Id: kept.Id})
+		}
+		preview.Conflicts = append(preview.Conflicts, result)
+	}
+	sort.Slice(preview.Conflicts, func(i, j int) bool {
+		return preview.Conflicts[i].Path < preview.Conflicts[j].Path
+	})
+	preview.Tree, err = resolved.WriteTreeTo(r)
+	if err != nil {
+		return nil, err
+	}
+	return preview, nil
+}
+
+// MergeCommitsPreview merges the commits with MergeTreesPreview and the
+// tree of their merge base.
+func (r *Repository) MergeCommitsPreview(ours, theirs *Commit, opts *MergeOptions) (*MergePreview, error) {
+	ancestor, ourTree, theirTree, err := r.mergeCommitTrees(ours, theirs)
+	if err != nil {
+		return nil, err
+	}
+	return r.MergeTreesPreview(ancestor, ourTree, theirTree, opts)
+}
+
+// MergeOctopus merges the heads into the first one, one after another like
+// the octopus strategy of git. The heads that are already merged are
+// skipped, and the first heads are fast-forwarded while possible. Like
+// git, it gives up with ErrMergeConflict if a head other than the last one
+// conflicts; the conflicts of the last head are left in the index for the
+// user to resolve, unless FailOnConflict is set.
+func (r *Repository) MergeOctopus(heads []*Commit, opts *MergeOptions) (*Index, error) {
+	if len(heads) < 2 {
+		return nil, MakeGitError("an octopus merge needs at least two heads", ErrInvalid)
+	}
+	if opts == nil {
+		opts = &MergeOptions{}
+	}
+	merged := []*Oid{heads[0].Id()}
+	tree, err := heads[0].Tree()
+	if err != nil {
+		return nil, err
+	}
+	var index *Index
+	fastForward := true
+	for i, head := range heads[1:] {
+		base, err := r.octopusMergeBase(merged, head.Id())
+		if err != nil && !IsErrorCode(err, ErrNotFound) {
+			return nil, err
+		}
+		if base != nil && base.Equal(head.Id()) {
+			// already up to date
+			continue
+		}
+		if fastForward && len(merged) == 1 && base != nil && base.Equal(merged[0]) {
+			merged[0] = head.Id()
+			tree, err = head.Tree()
+			if err != nil {
+				return nil, err
+			}
+			index = nil
+			continue
+		}
+		fastForward = false
+		var ancestor *Tree
+		if base != nil {
+			ancestor, err = r.commitTree(base)
+			if err != nil {
+				return nil, err
+			}
+		}
+		theirTree, err := head.Tree()
+		if err != nil {
+			return nil, err
+		}
+		index, err = r.MergeTrees(ancestor, tree, theirTree, opts)
+		if err != nil {
+			return nil, err
+		}
+		if index.HasConflicts() {
+			if i+2 < len(heads) {
+				return nil, MakeGitError(fmt.Sprintf("merging %s did not work, it should not be an octopus merge", head.Id().String()), ErrMergeConflict)
+			}
+			return index, nil
+		}
+		treeId, err := index.WriteTreeTo(r)
+		if err != nil {
+			return nil, err
+		}
+		tree, err = r.LookupTree(treeId)
+		if err != nil {
+			return nil, err
+		}
+		merged = append(merged, head.Id())
+	}
+	if index == nil {
+		index, err = NewIndex()
+		if err == nil {
+			err = index.ReadTree(tree)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return index, nil
+}
+
+// internal functions and methods
+
+// mergeTrees merges the trees like MergeTrees and returns the renames that
+// it found too.
+func (r *Repository) mergeTrees(ancestor, ours, theirs *Tree, opts *MergeOptions) (*Index, *mergeRenames, error) {
+	if opts == nil {
+		opts = &MergeOptions{}
+	}
+	sides := make([]map[string]*TreeEntry, 3)
+	for i, tree := range []*Tree{ancestor, ours, theirs} {
+		entries, err := flattenTree(tree)
+		if err != nil {
+			return nil, nil, err
+		}
+		sides[i] = entries
+	}
+	renames, err := r.detectMergeRenames(sides, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	var paths []string
+	for _, side := range sides {
+		for path := range side {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	index, err := NewIndex()
+	if err != nil {
+		return nil, nil, err
+	}
+	merged := make(map[string]bool)
+	var conflicts []string
+	for i, path := range paths {
+		if i > 0 && paths[i-1] == path {
+			continue
+		}
+		if _, ok := renames.conflicts[path]; ok {
+			conflicts = append(conflicts, path)
+			continue
+		}
+		entry, err := r.mergeTreeEntry(path, sides[0][path], sides[1][path], sides[2][path], opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		if entry == nil {
+			conflicts = append(conflicts, path)
+			continue
+		}
+		if entry.Id != nil {
+			index.Add(entry)
+			merged[path] = true
+		}
+	}
+	// a file of one side can not be merged with a directory of the other,
+	// whether the files in the directory are merged or conflicts
+	var files []string
+	for path := range merged {
+		files = append(files, path)
+	}
+	files = append(files, conflicts...)
+	for _, path := range files {
+		for dir := filepath.ToSlash(filepath.Dir(path)); dir != "."; dir = filepath.ToSlash(filepath.Dir(dir)) {
+			if merged[dir] {
+				index.Remove(dir, 0)
+				delete(merged, dir)
+				conflicts = append(conflicts, dir)
+			}
+		}
+	}
+	if len(conflicts) > 0 && opts.FailOnConflict {
+		sort.Strings(conflicts)
+		return nil, nil, MakeGitError(fmt.Sprintf("merge conflict in '%s'", conflicts[0]), ErrMergeConflict)
+	}
+	sort.Strings(conflicts)
+	added := make(map[*IndexNameEntry]bool)
+	for _, path := range conflicts {
+		var stages []*IndexEntry
+		for _, side := range sides {
+			var stage *IndexEntry
+			if entry := side[path]; entry != nil {
+				stage = &IndexEntry{Path: path, Mode: entry.Filemode, Id: entry.Id}
+			}
+			stages = append(stages, stage)
+		}
+		err = index.AddConflict(stages[0], stages[1], stages[2])
+		if err != nil {
+			return nil, nil, err
+		}
+		if name := renames.names[path]; name != nil && !added[name] {
+			added[name] = true
+			index.names = append(index.names, name)
+		}
+	}
+	return index, renames, nil
+}
+
+// mergeTreeEntry merges one path. A nil entry with no error is a conflict,
+// or a path that no side has any more; an entry without an id is a path
+// that is deleted.
+func (r *Repository) mergeTreeEntry(path string, ancestor, ours, theirs *TreeEntry, opts *MergeOptions) (*IndexEntry, error) {
+	var result *TreeEntry
+	switch {
+	case sameTreeEntry(ours, theirs):
+		result = ours
+	case sameTreeEntry(ancestor, ours):
+		result = theirs
+	case sameTreeEntry(ancestor, theirs):
+		result = ours
+	case ours == nil || theirs == nil || !isMergeableFile(ours) || !isMergeableFile(theirs) ||
+		(ancestor != nil && !isMergeableFile(ancestor)):
+		return nil, nil
+	default:
+		var inputs []*MergeFileInput
+		for _, entry := range []*TreeEntry{ancestor, ours, theirs} {
+			input := &MergeFileInput{}
+			if entry != nil {
+				blob, err := r.LookupBlob(entry.Id)
+				if err != nil {
+					return nil, err
+				}
+				input.Path = path
+				input.Mode = entry.Filemode
+				input.Contents = blob.Contents()
+			}
+			inputs = append(inputs, input)
+		}
+		merged, err := r.MergeFile(inputs[0], inputs[1], inputs[2], opts.FileOptions)
+		if err != nil || !merged.Automergeable {
+			return nil, err
+		}
+		oid, err := r.CreateBlobFromBuffer(merged.Contents)
+		if err != nil {
+			return nil, err
+		}
+		return &IndexEntry{Path: path, Mode: merged.Mode, Id: oid}, nil
+	}
+	if result == nil {
+		return &IndexEntry{Path: path}, nil
+	}
+	return &IndexEntry{Path: path, Mode: result.Filemode, Id: result.Id}, nil
+}
+
+func sameTreeEntry(a, b *TreeEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Filemode == b.Filemode && a.Id.Equal(b.Id)
+}
+
+func isMergeableFile(entry *TreeEntry) bool {
+	return entry.Filemode == FilemodeBlob || entry.Filemode == FilemodeBlobExecutable
+}
+
+// flattenTree returns the files of the tree and its subtrees by their
+// paths.
+func flattenTree(tree *Tree) (map[string]*TreeEntry, error) {
+	entries := make(map[string]*TreeEntry)
+	if tree == nil {
+		return entries, nil
+	}
+	err := tree.Walk(func(root string, entry *TreeEntry) int {
+		if entry.Type != ObjectTree {
+			entries[filepath.ToSlash(filepath.Join(root, entry.Name))] = entry
+		}
+		return 0
+	})
+	return entries, err
+}
+
+// mergeCommitTrees returns the trees of the merge base and the commits. The
+// merge base of unrelated histories is nil.
+func (r *Repository) mergeCommitTrees(ours, theirs *Commit) (ancestor, ourTree, theirTree *Tree, err error) {
+	ancestor, err = r.mergeBaseTree(ours.Id(), theirs.Id())
+	if err != nil && !IsErrorCode(err, ErrNotFound) {
+		return nil, nil, nil, err
+	}
+	ourTree, err = ours.Tree()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	theirTree, err = theirs.Tree()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return ancestor, ourTree, theirTree, nil
+}
+
+// mergeBaseTree returns the tree of the merge base of the commits. Several
+// merge bases are merged one after another into a virtual ancestor. The
+// ancestor of each of these merges is the merge base of the first base and
+// the next one, found the same way.
+func (r *Repository) mergeBaseTree(one, two *Oid) (*Tree, error) {
+	bases, err := r.MergeBases(one, two)
+	if err != nil {
+		return nil, err
+	}
+	virtual, err := r.commitTree(bases[0])
+	if err != nil {
+		return nil, err
+	}
+	opts := &MergeOptions{FileOptions: &MergeFileOptions{
+		Style:      MergeFileStyleMerge,
+		OurLabel:   "Temporary merge branch 1",
+		TheirLabel: "Temporary merge branch 2",
+	}}
+	for _, base := range bases[1:] {
+		ancestor, err := r.mergeBaseTree(bases[0], base)
+		if err != nil && !IsErrorCode(err, ErrNotFound) {
+			return nil, err
+		}
+		tree, err := r.commitTree(base)
+		if err != nil {
+			return nil, err
+		}
+		preview, err := r.MergeTreesPreview(ancestor, virtual, tree, opts)
+		if err != nil {
+			return nil, err
+		}
+		if virtual, err = r.LookupTree(preview.Tree); err != nil {
+			return nil, err
+		}
+	}
+	return virtual, nil
+}
+
+func (r *Repository) commitTree(oid *Oid) (*Tree, error) {
+	commit, err := r.LookupCommit(oid)
+	if err != nil {
+		return nil, err
+	}
+	return commit.Tree()
+}
+
+// octopusMergeBase finds the merge base of the head and the commits that
+// are merged so far. The base that is the nearest to the head wins.
+func (r *Repository) octopusMergeBase(merged []*Oid, head *Oid) (*Oid, error) {
+	var best *Oid
+	for _, oid := range merged {
+		base, err := r.MergeBase(oid, head)
+		if IsErrorCode(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if best == nil {
+			best = base
+			continue
+		}
+		common, err := r.MergeBase(best, base)
+		if err != nil {
+			return nil, err
+		}
+		if common.Equal(best) {
+			best = base
+		}
+	}
+	if best == nil {
+		return nil, MakeGitError(fmt.Sprintf("no merge base found for %s", head.String()), ErrNotFound)
+	}
+	return best, nil
+}
+
+func (r *Repository) mergePreference() MergePreference {
+	config := r.Config()
+	if config == nil {
+		return MergePreferenceNone
+	}
+	value, err := config.LookupString("merge.ff")
+	if err != nil {
+		return MergePreferenceNone
+	}
+	switch value {
+	case "only":
+		return MergePreferenceFastForwardOnly
+	case "false", "no", "off", "0":
+		return MergePreferenceNoFastForward
+	}
+	return MergePreferenceNone
+}
@@ -0,0 +1,505 @@
+package git4go
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// MergeFileStyle is the way conflicts are written to the merged file.
+type MergeFileStyle int
+
+const (
+	// merge.conflictStyle of the repository, or MergeFileStyleMerge
+	MergeFileStyleDefault MergeFileStyle = iota
+	// "<<<<<<< ours", "=======" and ">>>>>>> theirs"
+	MergeFileStyleMerge
+	// The lines of the ancestor are written after "||||||| base" too
+	MergeFileStyleDiff3
+	// Like diff3, but the lines that both sides share at the start and the
+	// end of the conflict are moved out of it
+	MergeFileStyleZdiff3
+)
+
+// MergeFileDriver selects how the contents of the files are merged.
+type MergeFileDriver int
+
+const (
+	// The merge attribute of the path, or MergeFileDriverBinary if any
+	// input has a NUL byte in its first 8000 bytes and MergeFileDriverText
+	// otherwise
+	MergeFileDriverDefault MergeFileDriver = iota
+	// The lines are merged like diff3
+	MergeFileDriverText
+	// The contents are not merged: the result is a conflict that keeps ours
+	MergeFileDriverBinary
+)
+
+const (
+	GitMergeConflictMarkerSize = 7
+	gitConflictMarkerSizeAttr  = "conflict-marker-size"
+	gitMergeAttr               = "merge"
+)
+
+// MergeFileInput is one side of a file merge. A nil Contents with an empty
+// Path means that the file does not exist on the side.
+type MergeFileInput struct {
+	Path     string
+	Mode     Filemode
+	Contents []byte
+}
+
+type MergeFileOptions struct {
+	// The labels of the conflict markers. The path of the input is used if
+	// a label is empty.
+	AncestorLabel string
+	OurLabel      string
+	TheirLabel    string
+	Style         MergeFileStyle
+	// The length of the conflict markers. 0 means the conflict-marker-size
+	// attribute of the path, or GitMergeConflictMarkerSize.
+	MarkerSize int
+	Driver     MergeFileDriver
+}
+
+type MergeFileResult struct {
+	// false if the contents have conflict markers, or if the path or the
+	// mode could not be merged
+	Automergeable bool
+	// empty if both sides renamed the file differently
+	Path string
+	// 0 if both sides changed the mode differently
+	Mode     Filemode
+	Contents []byte
+}
+
+// MergeFile merges the changes that ours and theirs made to the ancestor,
+// like "git merge-file". Conflicting changes are written with conflict
+// markers in the style of the options.
+func MergeFile(ancestor, ours, theirs *MergeFileInput, opts *MergeFileOptions) (*MergeFileResult, error) {
+	if ours == nil || theirs == nil {
+		return nil, MakeGitError("both sides of a file merge are needed", ErrInvalid)
+	}
+	if ancestor == nil {
+		ancestor = &MergeFileInput{}
+	}
+	options := MergeFileOptions{}
+	if opts != nil {
+		options = *opts
+	}
+	if options.MarkerSize == 0 {
+		options.MarkerSize = GitMergeConflictMarkerSize
+	}
+	if options.MarkerSize < 0 {
+		return nil, MakeGitError(fmt.Sprintf("invalid conflict marker size %d", options.MarkerSize), ErrInvalid)
+	}
+	if options.Style == MergeFileStyleDefault {
+		options.Style = MergeFileStyleMerge
+	}
+	options.AncestorLabel = mergeFileLabel(options.AncestorLabel, ancestor)
+	options.OurLabel = mergeFileLabel(options.OurLabel, ours)
+	options.TheirLabel = mergeFileLabel(options.TheirLabel, theirs)
+
+	result := &MergeFileResult{
+		Path: mergeFileBestPath(ancestor, ours, theirs),
+		Mode: mergeFileBestMode(ancestor, ours, theirs),
+	}
+	if options.Driver == MergeFileDriverDefault {
+		options.Driver = MergeFileDriverText
+		for _, input := range []*MergeFileInput{ancestor, ours, theirs} {
+			if isBinaryContent(input.Contents) {
+				options.Driver = MergeFileDriverBinary
+			}
+		}
+	}
+	var conflicted bool
+	if options.Driver == MergeFileDriverBinary {
+		// like the binary driver of git, unless the sides are the same
+		result.Contents = ours.Contents
+		conflicted = !bytes.Equal(ours.Contents, theirs.Contents)
+	} else {
+		result.Contents, conflicted = mergeLines(ancestor.Contents, ours.Contents, theirs.Contents, &options)
+	}
+	result.Automergeable = !conflicted && result.Path != "" && result.Mode != 0
+	return result, nil
+}
+
+// MergeFile merges the file like MergeFile, but the default style, marker
+// size and driver come from merge.conflictStyle and the
+// conflict-marker-size and merge attributes of the path. "-merge" and
+// "merge=binary" merge the file as binary, "merge" and "merge=text" as text.
+func (r *Repository) MergeFile(ancestor, ours, theirs *MergeFileInput, opts *MergeFileOptions) (*MergeFileResult, error) {
+	options := MergeFileOptions{}
+	if opts != nil {
+		options = *opts
+	}
+	if options.Style == MergeFileStyleDefault {
+		style, err := r.mergeConflictStyle()
+		if err != nil {
+			return nil, err
+		}
+		options.Style = style
+	}
+	if options.MarkerSize == 0 && ours != nil {
+		size, err := r.conflictMarkerSize(ours.Path)
+		if err != nil {
+			return nil, err
+		}
+		options.MarkerSize = size
+	}
+	if options.Driver == MergeFileDriverDefault && ours != nil {
+		driver, err := r.mergeDriver(ours.Path)
+		if err != nil {
+			return nil, err
+		}
+		options.Driver = driver
+	}
+	return MergeFile(ancestor, ours, theirs, &options)
+}
+
+// internal functions
+
+func (r *Repository) mergeConflictStyle() (MergeFileStyle, error) {
+	config := r.Config()
+	if config == nil {
+		return MergeFileStyleMerge, nil
+	}
+	value, err := config.LookupString("merge.conflictStyle")
+	if err != nil || value == "" {
+		return MergeFileStyleMerge, nil
+	}
+	switch value {
+	case "merge":
+		return MergeFileStyleMerge, nil
+	case "diff3":
+		return MergeFileStyleDiff3, nil
+	case "zdiff3":
+		return MergeFileStyleZdiff3, nil
+	}
+	return MergeFileStyleDefault, MakeGitError(fmt.Sprintf("unknown merge.conflictStyle '%s'", value), ErrInvalid)
+}
+
+func (r *Repository) conflictMarkerSize(path string) (int, error) {
+	if path == "" || r.IsBare() {
+		return GitMergeConflictMarkerSize, nil
+	}
+	valueType, value, err := r.GetAttr(path, gitConflictMarkerSizeAttr)
+	if err != nil {
+		return 0, err
+	}
+	if valueType != AttrValueString {
+		return GitMergeConflictMarkerSize, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size <= 0 {
+		return GitMergeConflictMarkerSize, nil
+	}
+	return size, nil
+}
+
+// mergeDriver returns the driver of the merge attribute of the path. Other
+// drivers are not supported; their files are merged like without one.
+func (r *Repository) mergeDriver(path string) (MergeFileDriver, error) {
+	if path == "" || r.IsBare() {
+		return MergeFileDriverDefault, nil
+	}
+	valueType, value, err := r.GetAttr(path, gitMergeAttr)
+	if err != nil {
+		return MergeFileDriverDefault, err
+	}
+	switch {
+	case valueType == AttrValueFalse || (valueType == AttrValueString && value == "binary"):
+		return MergeFileDriverBinary, nil
+	case valueType == AttrValueTrue || (valueType == AttrValueString && value == "text"):
+		return MergeFileDriverText, nil
+	}
+	return MergeFileDriverDefault, nil
+}
+
+func mergeFileLabel(label string, input *MergeFileInput) string {
+	if label != "" {
+		return label
+	}
+	return input.Path
+}
+
+func mergeFileBestPath(ancestor, ours, theirs *MergeFileInput) string {
+	switch {
+	case ancestor.Path == "":
+		if ours.Path == theirs.Path {
+			return ours.Path
+		}
+		return ""
+	case ours.Path == theirs.Path:
+		return ours.Path
+	case ours.Path == ancestor.Path:
+		return theirs.Path
+	case theirs.Path == ancestor.Path:
+		return ours.Path
+	}
+	return ""
+}
+
+func mergeFileBestMode(ancestor, ours, theirs *MergeFileInput) Filemode {
+	switch {
+	case ancestor.Mode == 0:
+		// an executable file wins if both sides added the file
+		if ours.Mode == FilemodeBlobExecutable || theirs.Mode == FilemodeBlobExecutable {
+			return FilemodeBlobExecutable
+		}
+		return FilemodeBlob
+	case ours.Mode == theirs.Mode:
+		return ours.Mode
+	case ours.Mode == ancestor.Mode:
+		return theirs.Mode
+	case theirs.Mode == ancestor.Mode:
+		return ours.Mode
+	}
+	return 0
+}
+
+// mergeLines merges the lines like diff3. The changes of the sides that
+// overlap or touch each other on the ancestor are one conflict, unless
+// both sides made the same change.
+func mergeLines(ancestor, ours, theirs []byte, opts *MergeFileOptions) ([]byte, bool) {
+	ids := make(map[string]int)
+	base, baseIds := splitLines(ancestor, ids)
+	our, ourIds := splitLines(ours, ids)
+	their, theirIds := splitLines(theirs, ids)
+	ourHunks := diffLines(baseIds, ourIds)
+	theirHunks := diffLines(baseIds, theirIds)
+
+	var buffer bytes.Buffer
+	conflicted := false
+	basePos := 0
+	i, j := 0, 0
+	for i < len(ourHunks) || j < len(theirHunks) {
+		// the first hunk and the hunks that overlap it make a region
+		var start int
+		if j >= len(theirHunks) || (i < len(ourHunks) && ourHunks[i].aStart <= theirHunks[j].aStart) {
+			start = ourHunks[i].aStart
+		} else {
+			start = theirHunks[j].aStart
+		}
+		end := start
+		oi, tj := i, j
+		for {
+			if i < len(ourHunks) && ourHunks[i].aStart <= end {
+				if ourHunks[i].aEnd > end {
+					end = ourHunks[i].aEnd
+				}
+				i++
+			} else if j < len(theirHunks) && theirHunks[j].aStart <= end {
+				if theirHunks[j].aEnd > end {
+					end = theirHunks[j].aEnd
+				}
+				j++
+			} else {
+				break
+			}
+		}
+		writeLines(&buffer, base[basePos:start])
+		basePos = end
+		ourLines := regionLines(base, our, ourHunks[oi:i], start, end)
+		theirLines := regionLines(base, their, theirHunks[tj:j], start, end)
+		switch {
+		case oi == i:
+			writeLines(&buffer, theirLines)
+		case tj == j || equalLines(ourLines, theirLines):
+			writeLines(&buffer, ourLines)
+		default:
+			conflicted = true
+			writeConflict(&buffer, base[start:end], ourLines, theirLines, opts)
+		}
+	}
+	writeLines(&buffer, base[basePos:])
+	return buffer.Bytes(), conflicted
+}
+
+func writeConflict(buffer *bytes.Buffer, base, ours, theirs [][]byte, opts *MergeFileOptions) {
+	if opts.Style != MergeFileStyleDiff3 {
+		// the shared lines at the ends are not a part of the conflict
+		prefix := 0
+		for prefix < len(ours) && prefix < len(theirs) && bytes.Equal(ours[prefix], theirs[prefix]) {
+			prefix++
+		}
+		suffix := 0
+		for suffix < len(ours)-prefix && suffix < len(theirs)-prefix &&
+			bytes.Equal(ours[len(ours)-1-suffix], theirs[len(theirs)-1-suffix]) {
+			suffix++
+		}
+		writeLines(buffer, ours[:prefix])
+		defer writeLines(buffer, ours[len(ours)-suffix:])
+		ours = ours[prefix : len(ours)-suffix]
+		theirs = theirs[prefix : len(theirs)-suffix]
+	}
+	writeConflictMarker(buffer, '<', opts.MarkerSize, opts.OurLabel)
+	writeConflictLines(buffer, ours)
+	if opts.Style != MergeFileStyleMerge {
+		writeConflictMarker(buffer, '|', opts.MarkerSize, opts.AncestorLabel)
+		writeConflictLines(buffer, base)
+	}
+	writeConflictMarker(buffer, '=', opts.MarkerSize, "")
+	writeConflictLines(buffer, theirs)
+	writeConflictMarker(buffer, '>', opts.MarkerSize, opts.TheirLabel)
+}
+
+func writeConflictMarker(buffer *bytes.Buffer, marker byte, size int, label string) {
+	buffer.Write(bytes.Repeat([]byte{marker}, size))
+	if label != "" {
+		buffer.WriteByte(' ')
+		buffer.WriteString(label)
+	}
+	buffer.WriteByte('\n')
+}
+
+// writeConflictLines writes the lines in a conflict. The marker after them
+// needs its own line, so a newline is added to the last line if it does
+// not have one.
+func writeConflictLines(buffer *bytes.Buffer, lines [][]byte) {
+	writeLines(buffer, lines)
+	if len(lines) > 0 && !bytes.HasSuffix(lines[len(lines)-1], []byte{'\n'}) {
+		buffer.WriteByte('\n')
+	}
+}
+
+func writeLines(buffer *bytes.Buffer, lines [][]byte) {
+	for _, line := range lines {
+		buffer.Write(line)
+	}
+}
+
+func equalLines(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// regionLines returns the lines of the side that replace the ancestor's
+// lines from start to end. The hunks are the side's hunks in the region.
+func regionLines(base, side [][]byte, hunks []lineHunk, start, end int) [][]byte {
+	if len(hunks) == 0 {
+		return base[start:end]
+	}
+	first := hunks[0]
+	last := hunks[len(hunks)-1]
+	return side[first.bStart-(first.aStart-start) : last.bEnd+(end-last.aEnd)]
+}
+
+// splitLines splits the contents into lines that keep their newlines, and
+// numbers the lines so that equal lines have the same id.
+func splitLines(contents []byte, ids map[string]int) ([][]byte, []int) {
+	var lines [][]byte
+	var lineIds []int
+	for len(contents) > 0 {
+		n := bytes.IndexByte(contents, '\n') + 1
+		if n == 0 {
+			n = len(contents)
+		}
+		line := contents[:n]
+		id, ok := ids[string(line)]
+		if !ok {
+			id = len(ids)
+			ids[string(line)] = id
+		}
+		lines = append(lines, line)
+		lineIds = append(lineIds, id)
+		contents = contents[n:]
+	}
+	return lines, lineIds
+}
+
+// lineHunk is a change of the lines: a[aStart:aEnd] are replaced by
+// b[bStart:bEnd].
+type lineHunk struct {
+	aStart, aEnd int
+	bStart, bEnd int
+}
+
+// diffLines finds the shortest edit script from a to b with Myers'
+// algorithm and returns the changed regions in order.
+func diffLines(a, b []int) []lineHunk {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		snapshot := make([]int, len(v))
+		copy(snapshot, v)
+		trace = append(trace, snapshot)
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrackHunks(trace, offset, n, m, d)
+			}
+		}
+	}
+	return nil
+}
+
+func backtrackHunks(trace [][]int, offset, n, m, d int) []lineHunk {
+	// the edits from the end to the start, merged into hunks
+	var hunks []lineHunk
+	addEdit := func(aPos, bPos int, deletion bool) {
+		aEnd, bEnd := aPos, bPos
+		if deletion {
+			aEnd++
+		} else {
+			bEnd++
+		}
+		if len(hunks) > 0 {
+			last := &hunks[len(hunks)-1]
+			if last.aStart == aEnd && last.bStart == bEnd {
+				last.aStart = aPos
+				last.bStart = bPos
+				return
+			}
+		}
+		hunks = append(hunks, lineHunk{aPos, aEnd, bPos, bEnd})
+	}
+	x, y := n, m
+	for ; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+		}
+		if prevK == k+1 {
+			addEdit(prevX, prevY, false)
+		} else {
+			addEdit(prevX, prevY, true)
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(hunks)-1; i < j; i, j = i+1, j-1 {
+		hunks[i], hunks[j] = hunks[j], hunks[i]
+	}
+	return hunks
+}
@@ -0,0 +1,169 @@
+package git4go
+
+import (
+	"./testutil"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const mergeFileBase = "one\ntwo\nthree\nfour\nfive\n"
+
+func mergeFileInputs(ours, theirs string) (*MergeFileInput, *MergeFileInput, *MergeFileInput) {
+	return &MergeFileInput{Path: "file.txt", Mode: FilemodeBlob, Contents: []byte(mergeFileBase)},
+		&MergeFileInput{Path: "file.txt", Mode: FilemodeBlob, Contents: []byte(ours)},
+		&MergeFileInput{Path: "file.txt", Mode: FilemodeBlob, Contents: []byte(theirs)}
+}
+
+func Test_MergeFile_Clean(t *testing.T) {
+	ancestor, ours, theirs := mergeFileInputs("ONE\ntwo\nthree\nfour\nfive\n", "one\ntwo\nthree\nfour\nFIVE\n")
+	result, err := MergeFile(ancestor, ours, theirs, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if !result.Automergeable {
+		t.Error("it should merge the changes to the different lines")
+	}
+	if string(result.Contents) != "ONE\ntwo\nthree\nfour\nFIVE\n" {
+		t.Errorf("it should have both changes: %q", result.Contents)
+	}
+	if result.Path != "file.txt" || result.Mode != FilemodeBlob {
+		t.Error("it should keep the path and the mode:", result.Path, result.Mode)
+	}
+
+	ancestor, ours, theirs = mergeFileInputs("one\nTWO\nthree\nfour\nfive\n", "one\nTWO\nthree\nfour\nfive\n")
+	result, _ = MergeFile(ancestor, ours, theirs, nil)
+	if !result.Automergeable || string(result.Contents) != "one\nTWO\nthree\nfour\nfive\n" {
+		t.Errorf("it should take the same change of both sides once: %q", result.Contents)
+	}
+}
+
+func Test_MergeFile_ConflictStyles(t *testing.T) {
+	ancestor, ours, theirs := mergeFileInputs("one\ntwo\nTHREE\nfour\nfive\n", "one\ntwo\nthree!\nfour\nfive\n")
+	ours.Contents = []byte("one\nX\nTHREE\nfour\nfive\n")
+	theirs.Contents = []byte("one\nX\nthree!\nfour\nfive\n")
+
+	result, _ := MergeFile(ancestor, ours, theirs, &MergeFileOptions{OurLabel: "ours", TheirLabel: "theirs"})
+	if result.Automergeable {
+		t.Error("it should not merge the different changes to the same lines")
+	}
+	expected := "one\nX\n<<<<<<< ours\nTHREE\n=======\nthree!\n>>>>>>> theirs\nfour\nfive\n"
+	if string(result.Contents) != expected {
+		t.Errorf("it should move the shared lines out of the conflict: %q", result.Contents)
+	}
+
+	result, _ = MergeFile(ancestor, ours, theirs, &MergeFileOptions{Style: MergeFileStyleDiff3, MarkerSize: 3})
+	expected = "one\n<<< file.txt\nX\nTHREE\n||| file.txt\ntwo\nthree\n===\nX\nthree!\n>>> file.txt\nfour\nfive\n"
+	if string(result.Contents) != expected {
+		t.Errorf("it should write the ancestor and the whole conflict with diff3: %q", result.Contents)
+	}
+
+	result, _ = MergeFile(ancestor, ours, theirs, &MergeFileOptions{Style: MergeFileStyleZdiff3, AncestorLabel: "base", OurLabel: "ours", TheirLabel: "theirs"})
+	expected = "one\nX\n<<<<<<< ours\nTHREE\n||||||| base\ntwo\nthree\n=======\nthree!\n>>>>>>> theirs\nfour\nfive\n"
+	if string(result.Contents) != expected {
+		t.Errorf("it should move the shared lines out of the conflict with zdiff3: %q", result.Contents)
+	}
+}
+
+func Test_MergeFile_NoNewlineAtEnd(t *testing.T) {
+	ancestor, ours, theirs := mergeFileInputs("one\ntwo\nthree\nfour\nfive", "one\ntwo\nthree\nfour\n5")
+	result, _ := MergeFile(ancestor, ours, theirs, &MergeFileOptions{OurLabel: "a", TheirLabel: "b"})
+	if string(result.Contents) != "one\ntwo\nthree\nfour\n<<<<<<< a\nfive\n=======\n5\n>>>>>>> b\n" {
+		t.Errorf("it should end the lines before the markers: %q", result.Contents)
+	}
+}
+
+func Test_MergeFile_PathAndMode(t *testing.T) {
+	ancestor, ours, theirs := mergeFileInputs(mergeFileBase, mergeFileBase)
+	theirs.Path = "renamed.txt"
+	ours.Mode = FilemodeBlobExecutable
+	result, _ := MergeFile(ancestor, ours, theirs, nil)
+	if !result.Automergeable || result.Path != "renamed.txt" || result.Mode != FilemodeBlobExecutable {
+		t.Error("it should take the changed path and mode:", result.Path, result.Mode)
+	}
+
+	ours.Path = "moved.txt"
+	theirs.Mode = FilemodeLink
+	result, _ = MergeFile(ancestor, ours, theirs, nil)
+	if result.Automergeable || result.Path != "" || result.Mode != 0 {
+		t.Error("it should not merge the different renames and modes:", result.Path, result.Mode)
+	}
+}
+
+func Test_RepositoryMergeFile_Config(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/attr")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/attr")
+	ioutil.WriteFile(filepath.Join(repo.Workdir(), ".gitattributes"), []byte("*.txt conflict-marker-size=10\n"), 0644)
+	repo.Config().SetString("merge.conflictStyle", "diff3")
+
+	ancestor, ours, theirs := mergeFileInputs("one\n2\nthree\nfour\nfive\n", "one\nTWO\nthree\nfour\nfive\n")
+	result, err := repo.MergeFile(ancestor, ours, theirs, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	expected := "one\n<<<<<<<<<< file.txt\n2\n|||||||||| file.txt\ntwo\n==========\nTWO\n>>>>>>>>>> file.txt\nthree\nfour\nfive\n"
+	if string(result.Contents) != expected {
+		t.Errorf("it should use merge.conflictStyle and conflict-marker-size: %q", result.Contents)
+	}
+
+	repo.Config().SetString("merge.conflictStyle", "fancy")
+	_, err = repo.MergeFile(ancestor, ours, theirs, nil)
+	if !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should return ErrInvalid for an unknown style:", err)
+	}
+}
+
+func Test_MergeFile_Binary(t *testing.T) {
+	base := bytes.Repeat([]byte("\x00binary\n"), 100)
+	ourContents := append([]byte(nil), base...)
+	ourContents[10] = 'X'
+	theirContents := append([]byte(nil), base...)
+	theirContents[700] = 'Y'
+	ancestor := &MergeFileInput{Path: "file.bin", Mode: FilemodeBlob, Contents: base}
+	ours := &MergeFileInput{Path: "file.bin", Mode: FilemodeBlob, Contents: ourContents}
+	theirs := &MergeFileInput{Path: "file.bin", Mode: FilemodeBlob, Contents: theirContents}
+	result, err := MergeFile(ancestor, ours, theirs, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if result.Automergeable {
+		t.Error("it should not merge the changes of binary files")
+	}
+	if !bytes.Equal(result.Contents, ourContents) {
+		t.Error("it should keep ours for binary files")
+	}
+	result, _ = MergeFile(ancestor, ours, ours, nil)
+	if !result.Automergeable || !bytes.Equal(result.Contents, ourContents) {
+		t.Error("it should take the same change of both sides")
+	}
+	result, _ = MergeFile(ancestor, ours, theirs, &MergeFileOptions{Driver: MergeFileDriverText})
+	if !result.Automergeable {
+		t.Error("it should merge binary files as text with MergeFileDriverText")
+	}
+}
+
+func Test_RepositoryMergeFile_MergeAttr(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/attr")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/attr")
+	ioutil.WriteFile(filepath.Join(repo.Workdir(), ".gitattributes"), []byte("*.txt -merge\n"), 0644)
+
+	ancestor, ours, theirs := mergeFileInputs("ONE\ntwo\nthree\nfour\nfive\n", "one\ntwo\nthree\nfour\nFIVE\n")
+	result, err := repo.MergeFile(ancestor, ours, theirs, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if result.Automergeable || string(result.Contents) != "ONE\ntwo\nthree\nfour\nfive\n" {
+		t.Errorf("it should keep ours for -merge: %q", result.Contents)
+	}
+	ioutil.WriteFile(filepath.Join(repo.Workdir(), ".gitattributes"), []byte("*.txt merge=text\n"), 0644)
+	ours.Contents = []byte("\x00ONE\ntwo\nthree\nfour\nfive\n")
+	result, _ = repo.MergeFile(ancestor, ours, theirs, nil)
+	if !result.Automergeable || string(result.Contents) != "\x00ONE\ntwo\nthree\nfour\nFIVE\n" {
+		t.Errorf("it should merge the lines for merge=text: %q", result.Contents)
+	}
+}
@@ -0,0 +1,357 @@
+package git4go
+
+import (
+	"bytes"
+	"path"
+	"sort"
+	"strings"
+)
+
+// The rename detection of a merge pairs the files by their contents only
+// if the number of deleted files times the number of added files is below
+// the square of this, like merge.renameLimit of git.
+const mergeRenameLimit = 7000
+
+const defaultMergeRenameThreshold = 50
+
+// mergeRenames is what the rename detection of a tree merge found.
+type mergeRenames struct {
+	// the conflicts that the renames cause, by their paths in the merge
+	conflicts map[string]MergeConflictType
+	// the paths of the renamed files on each side, by their paths in the
+	// merge
+	names map[string]*IndexNameEntry
+}
+
+// detectMergeRenames finds the files that each side renamed and moves the
+// entries of the sides to the new paths, so the files are merged there.
+// The files that one side added to a directory that the other side renamed
+// follow the rename, unless merge.directoryRenames is false.
+func (r *Repository) detectMergeRenames(sides []map[string]*TreeEntry, opts *MergeOptions) (*mergeRenames, error) {
+	result := &mergeRenames{
+		conflicts: make(map[string]MergeConflictType),
+		names:     make(map[string]*IndexNameEntry),
+	}
+	if opts.NoRenames || !r.mergeRenamesEnabled() {
+		return result, nil
+	}
+	threshold := opts.RenameThreshold
+	if threshold <= 0 {
+		threshold = defaultMergeRenameThreshold
+	}
+	renames := make([]map[string]string, 3)
+	for s := 1; s <= 2; s++ {
+		found, err := r.sideRenames(sides[0], sides[s], threshold)
+		if err != nil {
+			return nil, err
+		}
+		renames[s] = found
+	}
+
+	if dirRenames := r.mergeDirectoryRenames(); dirRenames != "false" {
+		for s := 1; s <= 2; s++ {
+			other := 3 - s
+			dirs := directoryRenames(sides[s], renames[s])
+			if len(dirs) == 0 {
+				continue
+			}
+			var added []string
+			for path := range sides[other] {
+				if sides[0][path] == nil {
+					added = append(added, path)
+				}
+			}
+			sort.Strings(added)
+			for _, oldPath := range added {
+				newPath, ok := applyDirectoryRename(oldPath, dirs)
+				if !ok || sides[0][newPath] != nil || sides[1][newPath] != nil || sides[2][newPath] != nil {
+					continue
+				}
+				sides[other][newPath] = sides[other][oldPath]
+				delete(sides[other], oldPath)
+				for old, renamed := range renames[other] {
+					if renamed == oldPath {
+						renames[other][old] = newPath
+					}
+				}
+				if dirRenames != "true" {
+					result.conflicts[newPath] = MergeConflictFileLocation
+				}
+			}
+		}
+	}
+
+	// rename/rename(2to1): the sides renamed different files to the same
+	// path. The renamed files conflict at the path like files that both
+	// sides added, and the source that the other side changed conflicts
+	// at its old path.
+	theirSources := make(map[string]string)
+	for old, newPath := range renames[2] {
+		theirSources[newPath] = old
+	}
+	for ourOld, newPath := range renames[1] {
+		theirOld, ok := theirSources[newPath]
+		if !ok || theirOld == ourOld || sides[0][newPath] != nil {
+			continue
+		}
+		if _, ok := renames[2][ourOld]; ok {
+			continue
+		}
+		if _, ok := renames[1][theirOld]; ok {
+			continue
+		}
+		delete(renames[1], ourOld)
+		delete(renames[2], theirOld)
+		result.conflicts[newPath] = MergeConflictRenameRename2to1
+		sources := []string{1: ourOld, 2: theirOld}
+		for side := 1; side <= 2; side++ {
+			old, other := sources[side], 3-side
+			if sameTreeEntry(sides[0][old], sides[other][old]) {
+				delete(sides[0], old)
+				delete(sides[other], old)
+				continue
+			}
+			paths := []string{old, old, old}
+			paths[side] = newPath
+			result.conflicts[old] = MergeConflictRenameRename2to1
+			result.names[old] = &IndexNameEntry{ancestor: paths[0], ours: paths[1], theirs: paths[2]}
+		}
+	}
+
+	var renamed []string
+	for s := 1; s <= 2; s++ {
+		for old := range renames[s] {
+			if _, ok := renames[1][old]; s == 1 || !ok {
+				renamed = append(renamed, old)
+			}
+		}
+	}
+	sort.Strings(renamed)
+	for _, old := range renamed {
+		ours, ourRenamed := renames[1][old]
+		theirs, theirRenamed := renames[2][old]
+		base := sides[0][old]
+		switch {
+		case ourRenamed && theirRenamed && ours == theirs:
+			delete(sides[0], old)
+			sides[0][ours] = base
+		case ourRenamed && theirRenamed:
+			if sides[0][ours] != nil || sides[0][theirs] != nil {
+				continue
+			}
+			delete(sides[0], old)
+			sides[0][ours] = base
+			sides[0][theirs] = base
+			name := &IndexNameEntry{ancestor: old, ours: ours, theirs: theirs}
+			for _, path := range []string{ours, theirs} {
+				result.conflicts[path] = MergeConflictRenameRename
+				result.names[path] = name
+			}
+		default:
+			side, newPath := 1, ours
+			if theirRenamed {
+				side, newPath = 2, theirs
+			}
+			other := 3 - side
+			if sides[0][newPath] != nil || sides[other][newPath] != nil {
+				// the other side added a file at the path: they are merged
+				// like files that both sides added
+				continue
+			}
+			delete(sides[0], old)
+			sides[0][newPath] = base
+			paths := []string{old, old, old}
+			paths[side] = newPath
+			if entry := sides[other][old]; entry != nil {
+				delete(sides[other], old)
+				sides[other][newPath] = entry
+			} else {
+				paths[other] = ""
+				result.conflicts[newPath] = MergeConflictRenameDelete
+			}
+			result.names[newPath] = &IndexNameEntry{ancestor: paths[0], ours: paths[1], theirs: paths[2]}
+		}
+	}
+	return result, nil
+}
+
+// sideRenames returns the new paths of the files that the side renamed by
+// their paths in the ancestor. Like git, the files with the same contents
+// are paired first, and the others by the similarity of their lines.
+func (r *Repository) sideRenames(ancestor, side map[string]*TreeEntry, threshold int) (map[string]string, error) {
+	var deleted, added []string
+	for path, entry := range ancestor {
+		if side[path] == nil && isMergeableFile(entry) {
+			deleted = append(deleted, path)
+		}
+	}
+	for path, entry := range side {
+		if ancestor[path] == nil && isMergeableFile(entry) {
+			added = append(added, path)
+		}
+	}
+	renames := make(map[string]string)
+	if len(deleted) == 0 || len(added) == 0 {
+		return renames, nil
+	}
+	sort.Strings(deleted)
+	sort.Strings(added)
+
+	// the added files by their contents, in the order of their paths
+	addedByOid := make(map[Oid][]string)
+	for _, path := range added {
+		addedByOid[*side[path].Id] = append(addedByOid[*side[path].Id], path)
+	}
+	usedPaths := make(map[string]bool)
+	var restDeleted []string
+	for _, old := range deleted {
+		paths := addedByOid[*ancestor[old].Id]
+		if len(paths) == 0 {
+			restDeleted = append(restDeleted, old)
+			continue
+		}
+		renames[old] = paths[0]
+		usedPaths[paths[0]] = true
+		addedByOid[*ancestor[old].Id] = paths[1:]
+	}
+	deleted = restDeleted
+	var restAdded []string
+	for _, path := range added {
+		if !usedPaths[path] {
+			restAdded = append(restAdded, path)
+		}
+	}
+	if len(deleted) == 0 || len(restAdded) == 0 || len(deleted)*len(restAdded) > mergeRenameLimit*mergeRenameLimit {
+		return renames, nil
+	}
+
+	oldContents := make([][]byte, len(deleted))
+	for i, path := range deleted {
+		content, err := r.blobContent(ancestor[path].Id)
+		if err != nil {
+			return nil, err
+		}
+		oldContents[i] = content
+	}
+	newContents := make([][]byte, len(restAdded))
+	for j, path := range restAdded {
+		content, err := r.blobContent(side[path].Id)
+		if err != nil {
+			return nil, err
+		}
+		newContents[j] = content
+	}
+	// empty files are never renames, like git
+	var candidates []statusRenameCandidate
+	for i, oldContent := range oldContents {
+		if len(oldContent) == 0 {
+			continue
+		}
+		for j, newContent := range newContents {
+			if len(newContent) == 0 || bytes.Equal(oldContent, newContent) {
+				continue
+			}
+			if similarity := contentSimilarity(oldContent, newContent); similarity >= threshold {
+				candidates = append(candidates, statusRenameCandidate{deleted: i, added: j, similarity: similarity})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].similarity > candidates[j].similarity
+	})
+	usedDeleted := make(map[int]bool)
+	usedAdded := make(map[int]bool)
+	for _, candidate := range candidates {
+		if usedDeleted[candidate.deleted] || usedAdded[candidate.added] {
+			continue
+		}
+		usedDeleted[candidate.deleted] = true
+		usedAdded[candidate.added] = true
+		renames[deleted[candidate.deleted]] = restAdded[candidate.added]
+	}
+	return renames, nil
+}
+
+// directoryRenames returns the new directories of the directories that the
+// side renamed by their old paths: the side has no file in them any more,
+// and most of their files were renamed to the same directory. Parents are
+// renamed with them while the names of the directories match, like a/b to
+// x/b with a to x.
+func directoryRenames(side map[string]*TreeEntry, renames map[string]string) map[string]string {
+	remaining := make(map[string]bool)
+	for file := range side {
+		for dir := path.Dir(file); dir != "."; dir = path.Dir(dir) {
+			remaining[dir] = true
+		}
+	}
+	votes := make(map[string]map[string]int)
+	for old, renamed := range renames {
+		oldDir, newDir := path.Dir(old), path.Dir(renamed)
+		for oldDir != "." && oldDir != newDir && !remaining[oldDir] {
+			if votes[oldDir] == nil {
+				votes[oldDir] = make(map[string]int)
+			}
+			votes[oldDir][newDir]++
+			if newDir == "." || path.Base(oldDir) != path.Base(newDir) {
+				break
+			}
+			oldDir, newDir = path.Dir(oldDir), path.Dir(newDir)
+		}
+	}
+	result := make(map[string]string)
+	for oldDir, targets := range votes {
+		best, count, tie := "", 0, false
+		for newDir, n := range targets {
+			switch {
+			case n > count:
+				best, count, tie = newDir, n, false
+			case n == count:
+				tie = true
+			}
+		}
+		// like git, a directory that was split evenly is not renamed
+		if !tie {
+			result[oldDir] = best
+		}
+	}
+	return result
+}
+
+// applyDirectoryRename returns the path of the file in the renamed
+// directory that is the nearest to it.
+func applyDirectoryRename(file string, dirs map[string]string) (string, bool) {
+	for dir := path.Dir(file); dir != "."; dir = path.Dir(dir) {
+		if newDir, ok := dirs[dir]; ok {
+			return path.Join(newDir, strings.TrimPrefix(file, dir+"/")), true
+		}
+	}
+	return "", false
+}
+
+func (r *Repository) mergeRenamesEnabled() bool {
+	config := r.Config()
+	if config == nil {
+		return true
+	}
+	if enabled, err := config.LookupBool("merge.renames"); err == nil {
+		return enabled
+	}
+	return true
+}
+
+// mergeDirectoryRenames returns merge.directoryRenames as "true", "false"
+// or "conflict", the default of git.
+func (r *Repository) mergeDirectoryRenames() string {
+	config := r.Config()
+	if config == nil {
+		return "conflict"
+	}
+	value, _ := config.LookupString("merge.directoryRenames")
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return "true"
+	case "false", "no", "off", "0":
+		return "false"
+	}
+	return "conflict"
+}
@@ -0,0 +1,484 @@
+package git4go
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_MergeAnalysis(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	first, _, _ := writeConnectivityHistory(repo, []byte("first\n"), nil)
+	second, _, _ := writeConnectivityHistory(repo, []byte("second\n"), first)
+	unrelated, _, _ := writeConnectivityHistory(repo, []byte("unrelated\n"), nil)
+
+	analysis, _, err := repo.MergeAnalysis([]*Oid{first})
+	if err != nil || analysis != MergeAnalysisFastForward|MergeAnalysisUnborn {
+		t.Error("it should fast-forward unborn HEAD:", analysis, err)
+	}
+	repo.CreateReference("refs/heads/master", first, false)
+	analysis, _, err = repo.MergeAnalysis([]*Oid{second})
+	if err != nil || analysis != MergeAnalysisFastForward|MergeAnalysisNormal {
+		t.Error("it should fast-forward to descendant:", analysis, err)
+	}
+	analysis, _, _ = repo.MergeAnalysis([]*Oid{first})
+	if analysis != MergeAnalysisUpToDate {
+		t.Error("it should be up to date with HEAD:", analysis)
+	}
+	analysis, _, _ = repo.MergeAnalysis([]*Oid{unrelated})
+	if analysis != MergeAnalysisNormal {
+		t.Error("it should need normal merge of unrelated history:", analysis)
+	}
+	repo.CreateReference("refs/heads/master", second, true)
+	analysis, _, _ = repo.MergeAnalysis([]*Oid{first})
+	if analysis != MergeAnalysisUpToDate {
+		t.Error("it should be up to date with ancestor:", analysis)
+	}
+
+	_, _, err = repo.MergeAnalysis([]*Oid{first, second})
+	if !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should reject octopus analysis:", err)
+	}
+}
+
+func Test_MergePreference(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	oid, _, _ := writeConnectivityHistory(repo, []byte("first\n"), nil)
+	for value, expected := range map[string]MergePreference{
+		"true":  MergePreferenceNone,
+		"false": MergePreferenceNoFastForward,
+		"only":  MergePreferenceFastForwardOnly,
+	} {
+		repo.Config().SetString("merge.ff", value)
+		_, preference, _ := repo.MergeAnalysis([]*Oid{oid})
+		if preference != expected {
+			t.Error("it should read merge.ff:", value, preference)
+		}
+	}
+}
+
+// writeMergeCommit writes a commit whose tree has the files.
+func writeMergeCommit(repo *Repository, files map[string]string, parents ...*Commit) *Commit {
+	index, _ := NewIndex()
+	for path, contents := range files {
+		oid, _ := repo.CreateBlobFromBuffer([]byte(contents))
+		index.Add(&IndexEntry{Path: path, Mode: FilemodeBlob, Id: oid})
+	}
+	treeId, _ := index.WriteTreeTo(repo)
+	tree, _ := repo.LookupTree(treeId)
+	sig := &Signature{"A", "a@example.com", time.Unix(1400000000+int64(len(parents)), 0)}
+	oid, _ := repo.CreateCommit("", sig, sig, "merge test\n", tree, parents...)
+	commit, _ := repo.LookupCommit(oid)
+	return commit
+}
+
+func checkMergedFile(index *Index, path, expected string, repo *Repository, t *testing.T) {
+	entry, err := index.EntryByPath(path, 0)
+	if err != nil {
+		t.Error("it should have merged the path:", path, err)
+		return
+	}
+	blob, _ := repo.LookupBlob(entry.Id)
+	if string(blob.Contents()) != expected {
+		t.Errorf("it should merge %s: %q", path, blob.Contents())
+	}
+}
+
+func Test_MergeCommits(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	base := writeMergeCommit(repo, map[string]string{
+		"a.txt":     "one\ntwo\nthree\n",
+		"b.txt":     "b\n",
+		"deleted":   "deleted\n",
+		"dir/c.txt": "c\n",
+	})
+	ours := writeMergeCommit(repo, map[string]string{
+		"a.txt":     "ONE\ntwo\nthree\n",
+		"b.txt":     "ours\n",
+		"dir/c.txt": "c\n",
+		"new.txt":   "new\n",
+	}, base)
+	theirs := writeMergeCommit(repo, map[string]string{
+		"a.txt":     "one\ntwo\nTHREE\n",
+		"b.txt":     "theirs\n",
+		"deleted":   "deleted\n",
+		"dir/c.txt": "C\n",
+	}, base)
+
+	index, err := repo.MergeCommits(ours, theirs, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	checkMergedFile(index, "a.txt", "ONE\ntwo\nTHREE\n", repo, t)
+	checkMergedFile(index, "dir/c.txt", "C\n", repo, t)
+	checkMergedFile(index, "new.txt", "new\n", repo, t)
+	if _, err := index.EntryByPath("deleted", 0); err == nil {
+		t.Error("it should delete the file that ours deleted")
+	}
+	if !index.HasConflicts() {
+		t.Fatal("it should have a conflict")
+	}
+	conflict, err := index.GetConflict("b.txt")
+	if err != nil || conflict.Ancestor == nil || conflict.Our == nil || conflict.Their == nil {
+		t.Fatal("it should have all stages of the conflict:", err)
+	}
+	if conflict.Ancestor.Stage() != StageAncestor || conflict.Our.Stage() != StageOurs || conflict.Their.Stage() != StageTheirs {
+		t.Error("it should add the sides as stages 1, 2 and 3")
+	}
+	if tree, _ := ours.Tree(); !conflict.Our.Id.Equal(tree.EntryByName("b.txt").Id) {
+		t.Error("it should keep the blob of ours in stage 2:", conflict.Our.Id)
+	}
+	if _, err = index.WriteTreeTo(repo); !IsErrorCode(err, ErrUnmerged) {
+		t.Error("it should not write a tree of the conflicted index:", err)
+	}
+
+	_, err = repo.MergeCommits(ours, theirs, &MergeOptions{FailOnConflict: true})
+	if !IsErrorCode(err, ErrMergeConflict) {
+		t.Error("it should return ErrMergeConflict with FailOnConflict:", err)
+	}
+}
+
+func Test_MergeCommitsPreview(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	base := writeMergeCommit(repo, map[string]string{
+		"a.txt":  "one\ntwo\nthree\n",
+		"b.txt":  "b\n",
+		"md.txt": "md\n",
+	})
+	ours := writeMergeCommit(repo, map[string]string{
+		"a.txt": "ONE\ntwo\nthree\n",
+		"b.txt": "ours\n",
+		"fd":    "file\n",
+	}, base)
+	theirs := writeMergeCommit(repo, map[string]string{
+		"a.txt":  "one\ntwo\nTHREE\n",
+		"b.txt":  "theirs\n",
+		"md.txt": "modified\n",
+		"fd/x":   "x\n",
+	}, base)
+
+	preview, err := repo.MergeCommitsPreview(ours, theirs, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	expected := []struct {
+		path         string
+		conflictType MergeConflictType
+	}{
+		{"b.txt", MergeConflictContent},
+		{"fd", MergeConflictFileDirectory},
+		{"md.txt", MergeConflictModifyDelete},
+	}
+	if len(preview.Conflicts) != len(expected) {
+		t.Fatal("it should list the conflicts:", len(preview.Conflicts))
+	}
+	for i, conflict := range preview.Conflicts {
+		if conflict.Path != expected[i].path || conflict.Type != expected[i].conflictType {
+			t.Error("it should report the conflict with its type:", conflict.Path, conflict.Type)
+		}
+	}
+	if preview.Conflicts[0].Ancestor == nil || preview.Conflicts[0].Ours == nil || preview.Conflicts[0].Theirs == nil {
+		t.Error("it should report the stages of the conflict")
+	}
+
+	tree, _ := repo.LookupTree(preview.Tree)
+	files, _ := flattenTree(tree)
+	contents := func(path string) string {
+		if files[path] == nil {
+			return ""
+		}
+		blob, _ := repo.LookupBlob(files[path].Id)
+		return string(blob.Contents())
+	}
+	if contents("a.txt") != "ONE\ntwo\nTHREE\n" || contents("md.txt") != "modified\n" || contents("fd/x") != "x\n" {
+		t.Error("it should write the merged files to the tree")
+	}
+	if contents("b.txt") != "<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\n" {
+		t.Error("it should write the conflict markers:", contents("b.txt"))
+	}
+
+	clean, _ := repo.MergeCommitsPreview(ours, ours, nil)
+	if len(clean.Conflicts) != 0 || !clean.Tree.Equal(ours.TreeId()) {
+		t.Error("it should merge cleanly")
+	}
+}
+
+func Test_MergeCommits_CrissCross(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	base := writeMergeCommit(repo, map[string]string{"f.txt": "1\n2\n3\n"})
+	x1 := writeMergeCommit(repo, map[string]string{"f.txt": "X\n2\n3\n"}, base)
+	y1 := writeMergeCommit(repo, map[string]string{"f.txt": "1\n2\nY\n"}, base)
+	x2 := writeMergeCommit(repo, map[string]string{"f.txt": "X\n2\nY\n"}, x1, y1)
+	y2 := writeMergeCommit(repo, map[string]string{"f.txt": "X\n2\nY\n"}, y1, x1)
+	x3 := writeMergeCommit(repo, map[string]string{"f.txt": "X\nP\nY\n"}, x2)
+
+	bases, err := repo.MergeBases(x3.Id(), y2.Id())
+	if err != nil || len(bases) != 2 {
+		t.Fatal("it should find both merge bases:", bases, err)
+	}
+	index, err := repo.MergeCommits(x3, y2, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if index.HasConflicts() {
+		t.Fatal("it should merge with the virtual ancestor of the merge bases")
+	}
+	checkMergedFile(index, "f.txt", "X\nP\nY\n", repo, t)
+
+	if bases, _ = repo.MergeBases(x2.Id(), x1.Id()); len(bases) != 1 || !bases[0].Equal(x1.Id()) {
+		t.Error("it should return the commit that the other one contains:", bases)
+	}
+}
+
+func Test_MergeCommits_FileDirectory(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	base := writeMergeCommit(repo, map[string]string{"a/b": "b\n"})
+	ours := writeMergeCommit(repo, map[string]string{"a/b": "ours\n"}, base)
+	theirs := writeMergeCommit(repo, map[string]string{"a": "file\n"}, base)
+
+	index, err := repo.MergeCommits(ours, theirs, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if _, err := index.EntryByPath("a", 0); err == nil {
+		t.Error("it should not leave the file in the way of the conflicts of the directory")
+	}
+	if conflict, err := index.GetConflict("a"); err != nil || conflict.Their == nil {
+		t.Error("it should record the file as a conflict:", err)
+	}
+	preview, err := repo.MergeCommitsPreview(ours, theirs, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if len(preview.Conflicts) != 2 || preview.Conflicts[0].Type != MergeConflictFileDirectory ||
+		preview.Conflicts[1].Type != MergeConflictModifyDelete {
+		t.Fatal("it should report the file/directory conflict:", preview.Conflicts)
+	}
+	tree, _ := repo.LookupTree(preview.Tree)
+	if files, _ := flattenTree(tree); len(files) != 1 || files["a/b"] == nil {
+		t.Error("it should keep the directory in the tree")
+	}
+}
+
+func Test_MergeCommits_Renames(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	lines := "1\n2\n3\n4\n5\n6\n7\n8\n"
+	base := writeMergeCommit(repo, map[string]string{
+		"moved.txt":   lines,
+		"deleted.txt": "d1\nd2\nd3\n",
+		"split.txt":   "s1\ns2\ns3\n",
+	})
+	ours := writeMergeCommit(repo, map[string]string{
+		"renamed.txt": lines,
+		"kept.txt":    "d1\nd2\nd3\n",
+		"ours.txt":    "s1\ns2\ns3\n",
+	}, base)
+	theirs := writeMergeCommit(repo, map[string]string{
+		"moved.txt":  "1\n2\n3\n4\n5\n6\n7\nEIGHT\n",
+		"theirs.txt": "s1\ns2\ns3\n",
+	}, base)
+
+	index, err := repo.MergeCommits(ours, theirs, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	checkMergedFile(index, "renamed.txt", "1\n2\n3\n4\n5\n6\n7\nEIGHT\n", repo, t)
+	if _, err := index.EntryByPath("moved.txt", 0); err == nil {
+		t.Error("it should not keep the file at the old path")
+	}
+	if index.NameEntryCount() != 2 {
+		t.Fatal("it should record the renames that conflict:", index.NameEntryCount())
+	}
+	if name, _ := index.NameEntryByIndex(0); name.Ancestor() != "deleted.txt" || name.Ours() != "kept.txt" || name.Theirs() != "" {
+		t.Error("it should record the paths of the rename/delete conflict:", name)
+	}
+	if name, _ := index.NameEntryByIndex(1); name.Ancestor() != "split.txt" || name.Ours() != "ours.txt" || name.Theirs() != "theirs.txt" {
+		t.Error("it should record the paths of the rename/rename conflict:", name)
+	}
+
+	preview, err := repo.MergeCommitsPreview(ours, theirs, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	expected := []struct {
+		path         string
+		conflictType MergeConflictType
+	}{
+		{"kept.txt", MergeConflictRenameDelete},
+		{"ours.txt", MergeConflictRenameRename},
+		{"theirs.txt", MergeConflictRenameRename},
+	}
+	if len(preview.Conflicts) != len(expected) {
+		t.Fatal("it should list the conflicts of the renames:", preview.Conflicts)
+	}
+	for i, conflict := range preview.Conflicts {
+		if conflict.Path != expected[i].path || conflict.Type != expected[i].conflictType {
+			t.Error("it should report the conflict with its type:", conflict.Path, conflict.Type)
+		}
+		if conflict.AncestorPath == "" || conflict.Ancestor == nil {
+			t.Error("it should report the path of the file in the ancestor:", conflict.Path)
+		}
+	}
+	tree, _ := repo.LookupTree(preview.Tree)
+	files, _ := flattenTree(tree)
+	if len(files) != 4 || files["kept.txt"] == nil || files["ours.txt"] == nil || files["theirs.txt"] == nil {
+		t.Error("it should keep the renamed files in the tree:", files)
+	}
+
+	if index, _ = repo.MergeCommits(ours, theirs, &MergeOptions{NoRenames: true}); index.NameEntryCount() != 0 {
+		t.Error("it should not detect renames with NoRenames")
+	}
+}
+
+func Test_MergeCommits_RenameRename2to1(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	base := writeMergeCommit(repo, map[string]string{
+		"a.txt": "a1\na2\na3\n",
+		"b.txt": "b1\nb2\nb3\n",
+	})
+	ours := writeMergeCommit(repo, map[string]string{
+		"c.txt": "a1\na2\na3\n",
+		"b.txt": "b1\nb2\nB3\n",
+	}, base)
+	theirs := writeMergeCommit(repo, map[string]string{
+		"a.txt": "a1\na2\na3\n",
+		"c.txt": "b1\nb2\nb3\n",
+	}, base)
+
+	index, err := repo.MergeCommits(ours, theirs, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if _, err := index.EntryByPath("a.txt", 0); err == nil {
+		t.Error("it should not keep the renamed file that the other side did not change")
+	}
+	if conflict, err := index.GetConflict("c.txt"); err != nil || conflict.Our == nil || conflict.Their == nil {
+		t.Error("it should conflict at the path of the renames:", err)
+	}
+	if index.NameEntryCount() != 1 {
+		t.Fatal("it should record the rename of the changed file:", index.NameEntryCount())
+	}
+	if name, _ := index.NameEntryByIndex(0); name.Ancestor() != "b.txt" || name.Ours() != "b.txt" || name.Theirs() != "c.txt" {
+		t.Error("it should record the paths of the changed file:", name)
+	}
+
+	preview, err := repo.MergeCommitsPreview(ours, theirs, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if len(preview.Conflicts) != 2 {
+		t.Fatal("it should list the conflicts of the renames:", preview.Conflicts)
+	}
+	for i, path := range []string{"b.txt", "c.txt"} {
+		if conflict := preview.Conflicts[i]; conflict.Path != path || conflict.Type != MergeConflictRenameRename2to1 {
+			t.Error("it should report the rename/rename(2to1) conflict:", conflict.Path, conflict.Type)
+		}
+	}
+	tree, _ := repo.LookupTree(preview.Tree)
+	files, _ := flattenTree(tree)
+	if len(files) != 2 || files["b.txt"] == nil || files["c.txt"] == nil {
+		t.Error("it should keep ours and the changed file in the tree:", files)
+	}
+}
+
+func Test_SideRenames_Exact(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	same, _ := repo.CreateBlobFromBuffer([]byte("same\n"))
+	other, _ := repo.CreateBlobFromBuffer([]byte("other\n"))
+	ancestor := map[string]*TreeEntry{
+		"a.txt": {Name: "a.txt", Id: same, Filemode: FilemodeBlob},
+		"b.txt": {Name: "b.txt", Id: same, Filemode: FilemodeBlob},
+		"c.txt": {Name: "c.txt", Id: other, Filemode: FilemodeBlob},
+	}
+	side := map[string]*TreeEntry{
+		"y.txt": {Name: "y.txt", Id: same, Filemode: FilemodeBlob},
+		"x.txt": {Name: "x.txt", Id: same, Filemode: FilemodeBlob},
+		"z.txt": {Name: "z.txt", Id: other, Filemode: FilemodeBlob},
+	}
+	renames, err := repo.sideRenames(ancestor, side, 50)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if len(renames) != 3 || renames["a.txt"] != "x.txt" || renames["b.txt"] != "y.txt" || renames["c.txt"] != "z.txt" {
+		t.Error("it should pair the files with the same contents in the order of their paths:", renames)
+	}
+}
+
+func Test_MergeCommits_DirectoryRenames(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	base := writeMergeCommit(repo, map[string]string{
+		"dir/a.txt": "a\n",
+		"dir/b.txt": "b\n",
+	})
+	ours := writeMergeCommit(repo, map[string]string{
+		"lib/a.txt": "a\n",
+		"lib/b.txt": "b\n",
+	}, base)
+	theirs := writeMergeCommit(repo, map[string]string{
+		"dir/a.txt": "a\n",
+		"dir/b.txt": "b\n",
+		"dir/c.txt": "c\n",
+	}, base)
+
+	preview, err := repo.MergeCommitsPreview(ours, theirs, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if len(preview.Conflicts) != 1 || preview.Conflicts[0].Path != "lib/c.txt" || preview.Conflicts[0].Type != MergeConflictFileLocation {
+		t.Fatal("it should report the file that follows the directory rename:", preview.Conflicts)
+	}
+	tree, _ := repo.LookupTree(preview.Tree)
+	if files, _ := flattenTree(tree); len(files) != 3 || files["lib/c.txt"] == nil {
+		t.Error("it should move the new file to the renamed directory:", files)
+	}
+
+	repo.Config().SetString("merge.directoryRenames", "true")
+	index, err := repo.MergeCommits(ours, theirs, nil)
+	if err != nil || index.HasConflicts() {
+		t.Fatal("it should merge cleanly with merge.directoryRenames:", err)
+	}
+	checkMergedFile(index, "lib/c.txt", "c\n", repo, t)
+	repo.Config().SetString("merge.directoryRenames", "false")
+	if index, _ = repo.MergeCommits(ours, theirs, nil); index.Find("dir/c.txt") < 0 {
+		t.Error("it should keep the new file without directory renames")
+	}
+}
+
+func Test_MergeOctopus(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	base := writeMergeCommit(repo, map[string]string{"a.txt": "a\n", "b.txt": "b\n", "c.txt": "c\n"})
+	first := writeMergeCommit(repo, map[string]string{"a.txt": "A\n", "b.txt": "b\n", "c.txt": "c\n"}, base)
+	second := writeMergeCommit(repo, map[string]string{"a.txt": "a\n", "b.txt": "B\n", "c.txt": "c\n"}, base)
+	third := writeMergeCommit(repo, map[string]string{"a.txt": "a\n", "b.txt": "b\n", "c.txt": "C\n"}, base)
+
+	index, err := repo.MergeOctopus([]*Commit{first, second, third, base}, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if index.HasConflicts() {
+		t.Error("it should merge the heads without conflicts")
+	}
+	checkMergedFile(index, "a.txt", "A\n", repo, t)
+	checkMergedFile(index, "b.txt", "B\n", repo, t)
+	checkMergedFile(index, "c.txt", "C\n", repo, t)
+
+	index, err = repo.MergeOctopus([]*Commit{base, first, base}, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	checkMergedFile(index, "a.txt", "A\n", repo, t)
+
+	conflicting := writeMergeCommit(repo, map[string]string{"a.txt": "X\n", "b.txt": "b\n", "c.txt": "c\n"}, base)
+	_, err = repo.MergeOctopus([]*Commit{first, conflicting, second}, nil)
+	if !IsErrorCode(err, ErrMergeConflict) {
+		t.Error("it should give up when a head before the last conflicts:", err)
+	}
+	index, err = repo.MergeOctopus([]*Commit{first, second, conflicting}, nil)
+	if err != nil || !index.HasConflicts() {
+		t.Error("it should leave the conflicts of the last head in the index:", err)
+	}
+
+	_, err = repo.MergeOctopus([]*Commit{first}, nil)
+	if !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should need two heads:", err)
+	}
+}
@@ -0,0 +1,52 @@
+package git4go
+
+import (
+	"bytes"
+	"strings"
+)
+
+const DefaultCommentChar byte = '#'
+
+// MessagePrettify cleans up the commit message in the same way as git
+// commit does: trailing whitespace of each line is removed, consecutive
+// empty lines are collapsed into one, leading and trailing empty lines are
+// dropped and the message ends with a newline. If stripComments is true,
+// lines that start with commentChar are removed.
+func MessagePrettify(message string, stripComments bool, commentChar byte) string {
+	var buffer bytes.Buffer
+	consecutiveEmptyLines := 0
+	for len(message) > 0 {
+		var line string
+		if eol := strings.IndexByte(message, '\n'); eol != -1 {
+			line, message = message[:eol], message[eol+1:]
+		} else {
+			line, message = message, ""
+		}
+		if stripComments && len(line) > 0 && line[0] == commentChar {
+			continue
+		}
+		line = strings.TrimRight(line, " \t\n\v\f\r")
+		if len(line) == 0 {
+			consecutiveEmptyLines++
+			continue
+		}
+		if consecutiveEmptyLines > 0 && buffer.Len() > 0 {
+			buffer.WriteByte('\n')
+		}
+		consecutiveEmptyLines = 0
+		buffer.WriteString(line)
+		buffer.WriteByte('\n')
+	}
+	return buffer.String()
+}
+
+// CommentChar returns core.commentChar of the repository. "auto" and
+// missing values fall back to DefaultCommentChar.
+func (r *Repository) CommentChar() byte {
+	config := r.Config()
+	value, err := config.LookupString("core.commentChar")
+	if err == nil && len(value) == 1 {
+		return value[0]
+	}
+	return DefaultCommentChar
+}
@@ -0,0 +1,46 @@
+package git4go
+
+import (
+	"./testutil"
+	"testing"
+)
+
+func Test_MessagePrettify(t *testing.T) {
+	testCases := []struct {
+		message       string
+		stripComments bool
+		commentChar   byte
+		expected      string
+	}{
+		{"", false, '#', ""},
+		{"\n\n  \n", false, '#', ""},
+		{"Subject", false, '#', "Subject\n"},
+		{"Subject  \t\r\n", false, '#', "Subject\n"},
+		{"\n\nSubject\n\n\n\nBody line  \nsecond\n\n\n", false, '#', "Subject\n\nBody line\nsecond\n"},
+		{"Subject\n# comment\nBody\n", false, '#', "Subject\n# comment\nBody\n"},
+		{"Subject\n# comment\n\n# another\nBody\n", true, '#', "Subject\n\nBody\n"},
+		{"Subject\n; comment\n #not a comment\n", true, ';', "Subject\n #not a comment\n"},
+		{"# only comments\n#\n", true, '#', ""},
+	}
+	for _, testCase := range testCases {
+		result := MessagePrettify(testCase.message, testCase.stripComments, testCase.commentChar)
+		if result != testCase.expected {
+			t.Errorf("it should prettify %q: %q (expected %q)", testCase.message, result, testCase.expected)
+		}
+	}
+}
+
+func Test_CommentChar(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	if repo.CommentChar() != '#' {
+		t.Error("it should return '#' by default")
+	}
+	repo.Config().SetString("core.commentChar", ";")
+	repo, _ = OpenRepository("test_resources/testrepo.git")
+	if repo.CommentChar() != ';' {
+		t.Error("it should return core.commentChar:", string(repo.CommentChar()))
+	}
+}
@@ -0,0 +1,63 @@
+package git4go
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Names of the metrics that are reported to Metrics
+const (
+	MetricObjectsRead            = "odb_objects_read"
+	MetricObjectsNotFound        = "odb_objects_not_found"
+	MetricObjectReadTime         = "odb_object_read_seconds"
+	MetricObjectReadsCoalesced   = "odb_object_reads_coalesced"
+	MetricPromisedObjectsFetched = "odb_promised_objects_fetched"
+	MetricCommitCacheHits        = "commit_cache_hits"
+	MetricCommitCacheMisses      = "commit_cache_misses"
+	MetricGraphCacheHits         = "graph_cache_hits"
+	MetricGraphCacheMisses       = "graph_cache_misses"
+	MetricPackLookups            = "pack_lookups"
+	MetricPackWindowsMapped      = "pack_windows_mapped"
+	MetricBytesTransferred       = "transfer_bytes"
+	MetricFetchNegotiationRound  = "fetch_negotiation_rounds"
+)
+
+// Metrics receives counters and timings from the package. Implementations
+// must be safe for concurrent use. Cache hit ratio can be computed from the
+// hit and miss counters.
+type Metrics interface {
+	AddCounter(name string, delta int64)
+	ObserveDuration(name string, duration time.Duration)
+}
+
+type metricsHolder struct {
+	metrics Metrics
+}
+
+var currentMetrics atomic.Value
+
+// SetMetrics registers the Metrics implementation. Passing nil disables
+// instrumentation.
+func SetMetrics(metrics Metrics) {
+	currentMetrics.Store(&metricsHolder{metrics: metrics})
+}
+
+func getMetrics() Metrics {
+	holder, _ := currentMetrics.Load().(*metricsHolder)
+	if holder == nil {
+		return nil
+	}
+	return holder.metrics
+}
+
+func addCounter(name string, delta int64) {
+	if metrics := getMetrics(); metrics != nil {
+		metrics.AddCounter(name, delta)
+	}
+}
+
+func observeDuration(name string, duration time.Duration) {
+	if metrics := getMetrics(); metrics != nil {
+		metrics.ObserveDuration(name, duration)
+	}
+}
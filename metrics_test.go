@@ -0,0 +1,95 @@
+package git4go
+
+import (
+	"./testutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testMetrics struct {
+	lock      sync.Mutex
+	counters  map[string]int64
+	durations map[string]int
+}
+
+func (m *testMetrics) AddCounter(name string, delta int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.counters[name] += delta
+}
+
+func (m *testMetrics) ObserveDuration(name string, duration time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.durations[name]++
+}
+
+func Test_Metrics(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+	defer SetMetrics(nil)
+
+	metrics := &testMetrics{
+		counters:  make(map[string]int64),
+		durations: make(map[string]int),
+	}
+	SetMetrics(metrics)
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	oid, _ := NewOid(commitHead)
+	repo.LookupCommit(oid)
+	repo.LookupCommit(oid)
+	missing, _ := NewOid("0000000000000000000000000000000000000001")
+	odb, _ := repo.Odb()
+	odb.Read(missing)
+
+	if metrics.counters[MetricObjectsRead] != 1 {
+		t.Error("it should count object reads:", metrics.counters[MetricObjectsRead])
+	}
+	if metrics.durations[MetricObjectReadTime] != 1 {
+		t.Error("it should observe read time")
+	}
+	if metrics.counters[MetricObjectsNotFound] != 1 {
+		t.Error("it should count missing objects")
+	}
+	if metrics.counters[MetricCommitCacheHits] != 1 || metrics.counters[MetricCommitCacheMisses] != 1 {
+		t.Error("it should count cache hits and misses:", metrics.counters)
+	}
+	if metrics.counters[MetricPackLookups] == 0 {
+		t.Error("it should count pack lookups")
+	}
+}
+
+func Test_Metrics_BytesTransferred(t *testing.T) {
+	defer SetMetrics(nil)
+	repo, server, transport, cleanup := prepareFetch(t)
+	defer cleanup()
+	commit := writeMergeCommit(server, map[string]string{"a.txt": "a\n"})
+	server.CreateReference("refs/heads/master", commit.Id(), true)
+
+	metrics := &testMetrics{
+		counters:  make(map[string]int64),
+		durations: make(map[string]int),
+	}
+	SetMetrics(metrics)
+	remote, _ := repo.LookupRemote("origin")
+	remote.SetRetryPolicy(&RetryPolicy{MaxRetries: 1, InitialBackoff: time.Millisecond})
+	if err := remote.Fetch(nil); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	received := metrics.counters[MetricBytesTransferred]
+	if received < 32 {
+		t.Error("it should count the bytes of the pack:", received)
+	}
+
+	// the part of the pack before a failure counts too
+	second := writeMergeCommit(server, map[string]string{"a.txt": "b\n"}, commit)
+	server.CreateReference("refs/heads/master", second.Id(), true)
+	transport.downloadFailures = 1
+	metrics.counters[MetricBytesTransferred] = 0
+	remote.Fetch(nil)
+	if metrics.counters[MetricBytesTransferred] < 8+32 {
+		t.Error("it should count the bytes of every download:", metrics.counters[MetricBytesTransferred])
+	}
+}
@@ -15,30 +15,84 @@ var memCtl MWindowCtl = MWindowCtl{}
 var packCache map[string]*PackFile = map[string]*PackFile{}
 var mwindowMutex sync.Mutex
 
+// SetMWindowSize sets the size of the memory windows that are mapped from
+// packfiles. The value is rounded up to a multiple of the page size.
+func SetMWindowSize(size uint64) {
+	mwindowMutex.Lock()
+	defer mwindowMutex.Unlock()
+
+	pageSize := uint64(os.Getpagesize()) * 2
+	if size < pageSize {
+		size = pageSize
+	}
+	mwindow_windowSize = (size + pageSize - 1) / pageSize * pageSize
+}
+
+func MWindowSize() uint64 {
+	mwindowMutex.Lock()
+	defer mwindowMutex.Unlock()
+
+	return mwindow_windowSize
+}
+
+// SetMWindowMappedLimit sets the maximum amount of memory that can be mapped
+// from packfiles at any time. Windows that are not in use are unmapped in
+// LRU order when the limit is exceeded.
+func SetMWindowMappedLimit(limit uint64) {
+	mwindowMutex.Lock()
+	defer mwindowMutex.Unlock()
+
+	mwindow_mappedLimit = limit
+}
+
+func MWindowMappedLimit() uint64 {
+	mwindowMutex.Lock()
+	defer mwindowMutex.Unlock()
+
+	return mwindow_mappedLimit
+}
+
 type MWindow struct {
 	windowMap mmap.MMap
 	offset    uint64
 	lastUsed  uint64
+	inUse     int
 }
 
 func mwindowFinalizer(w *MWindow) {
 	mwindowMutex.Lock()
 	defer mwindowMutex.Unlock()
 
-	w.windowMap.Unmap()
+	w.unmap()
+}
+
+func (w *MWindow) unmap() {
+	if w.windowMap != nil {
+		w.windowMap.Unmap()
+		w.windowMap = nil
+	}
 }
 
 func (w *MWindow) contains(offset uint64) bool {
 	return w.offset <= offset && offset <= (w.offset+uint64(len(w.windowMap)))
 }
 
+// Close releases the window that is returned from MWindowFile.Open. The
+// window can be unmapped after that, so the data must not be used anymore.
+func (w *MWindow) Close() {
+	mwindowMutex.Lock()
+	defer mwindowMutex.Unlock()
+
+	w.inUse--
+}
+
 type MWindowFile struct {
 	windows []*MWindow
 	file    *os.File
 	size    uint64
 }
 
-func (mwf *MWindowFile) Open(offset, extra uint64) ([]byte, error) {
+func (mwf *MWindowFile) Open(offset, extra uint64) ([]byte, *MWindow, error) {
 	mwindowMutex.Lock()
 	defer mwindowMutex.Unlock()
 	var w *MWindow
@@ -53,14 +107,15 @@ func (mwf *MWindowFile) Open(offset, extra uint64) ([]byte, error) {
 		var err error
 		w, err = mwf.newWindow(offset)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		mwf.windows = append([]*MWindow{w}, mwf.windows...)
 	}
 	w.lastUsed = memCtl.usedCtr
+	w.inUse++
 	memCtl.usedCtr++
 	offset -= w.offset
-	return w.windowMap[offset:], nil
+	return w.windowMap[offset:], w, nil
 }
 
 func (mwf *MWindowFile) freeAll() {
@@ -78,19 +133,20 @@ func (mwf *MWindowFile) newWindow(offset uint64) (*MWindow, error) {
 	if length > mwindow_windowSize {
 		length = mwindow_windowSize
 	}
-	memCtl.mapped += length
 
-	for mwindow_mappedLimit < memCtl.mapped && mwf.closeLru() == nil {
+	for mwindow_mappedLimit < memCtl.mapped+length && mwf.closeLru() == nil {
 		/* nop */
 	}
 
-	mmapObj, err := mmap.MapRegion(mwf.file, int(mwf.size), 0, mmap.RDONLY, int64(w.offset))
+	mmapObj, err := mmap.MapRegion(mwf.file, int(length), mmap.RDONLY, 0, int64(w.offset))
 	if err != nil {
 		return nil, err
 	}
 	w.windowMap = mmapObj
 	runtime.SetFinalizer(w, mwindowFinalizer)
+	memCtl.mapped += length
 	memCtl.mmapCalls++
+	addCounter(MetricPackWindowsMapped, 1)
 	memCtl.openWindow++
 	if memCtl.mapped > memCtl.peakMapped {
 		memCtl.peakMapped = memCtl.mapped
@@ -104,26 +160,46 @@ func (mwf *MWindowFile) newWindow(offset uint64) (*MWindow, error) {
 func (mwf *MWindowFile) freeAllLocked() {
 	for i, w := range memCtl.windowFiles {
 		if w == mwf {
-			newFiles := memCtl.windowFiles[:i]
-			if i != len(memCtl.windowFiles)-1 {
-				newFiles = append(newFiles, memCtl.windowFiles[i+1:]...)
-			}
-			memCtl.windowFiles = newFiles
+			memCtl.windowFiles = append(memCtl.windowFiles[:i], memCtl.windowFiles[i+1:]...)
 			break
 		}
 	}
 	for _, window := range mwf.windows {
 		memCtl.mapped -= uint64(len(window.windowMap))
 		memCtl.openWindow--
-		window.windowMap.Unmap()
+		window.unmap()
 	}
+	mwf.windows = nil
+}
+
+// freeUnused unmaps the windows that are not in use and returns their
+// size.
+func (mwf *MWindowFile) freeUnused() uint64 {
+	mwindowMutex.Lock()
+	defer mwindowMutex.Unlock()
+
+	var freed uint64
+	var kept []*MWindow
+	for _, window := range mwf.windows {
+		if window.inUse > 0 {
+			kept = append(kept, window)
+			continue
+		}
+		size := uint64(len(window.windowMap))
+		memCtl.mapped -= size
+		memCtl.openWindow--
+		window.unmap()
+		freed += size
+	}
+	mwf.windows = kept
+	return freed
 }
 
 func (mwf *MWindowFile) register() {
 	mwindowMutex.Lock()
 	defer mwindowMutex.Unlock()
 
-	memCtl.windowFiles = append(memCtl.windowFiles)
+	memCtl.windowFiles = append(memCtl.windowFiles, mwf)
 }
 
 func (mwf *MWindowFile) unregister() {
@@ -132,10 +208,7 @@ func (mwf *MWindowFile) unregister() {
 
 	for i, w := range memCtl.windowFiles {
 		if w == mwf {
-			newFiles := memCtl.windowFiles[:i]
-			if i != len(memCtl.windowFiles)-1 {
-				newFiles = append(newFiles, memCtl.windowFiles[i+1:]...)
-			}
+			memCtl.windowFiles = append(memCtl.windowFiles[:i], memCtl.windowFiles[i+1:]...)
 			break
 		}
 	}
@@ -143,6 +216,9 @@ func (mwf *MWindowFile) unregister() {
 
 func (mwf *MWindowFile) scanLru(lruWindow **MWindow, lruFile **MWindowFile, lruIndex *int) {
 	for i, window := range mwf.windows {
+		if window.inUse > 0 {
+			continue
+		}
 		if (*lruWindow) == nil || window.lastUsed < (*lruWindow).lastUsed {
 			*lruWindow = window
 			*lruFile = mwf
@@ -167,6 +243,8 @@ func (mwf *MWindowFile) closeLru() error {
 		return errors.New("Failed to close memory window. Couldn't find LRU")
 	}
 	memCtl.mapped -= uint64(len(lruWindow.windowMap))
+	memCtl.openWindow--
+	lruWindow.unmap()
 	lruFile.windows = append(lruFile.windows[:lruIndex], lruFile.windows[lruIndex+1:]...)
 	return nil
 }
@@ -0,0 +1,491 @@
+package git4go
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type NegotiationAlgorithm int
+
+const (
+	// sends every commit, newest first, like git's default
+	NegotiationConsecutive NegotiationAlgorithm = iota
+	// skips commits in growing steps, for histories where the common
+	// commits are far away
+	NegotiationSkipping
+	// sends no haves
+	NegotiationNoop
+)
+
+const (
+	negotiationInitialFlush = 16
+	negotiationLargeFlush   = 16384
+	// haves without a new ack after which the negotiation gives up
+	negotiationMaxInVain = 256
+)
+
+func (a NegotiationAlgorithm) String() string {
+	switch a {
+	case NegotiationSkipping:
+		return "skipping"
+	case NegotiationNoop:
+		return "noop"
+	}
+	return "consecutive"
+}
+
+// ParseNegotiationAlgorithm parses a value of fetch.negotiationAlgorithm.
+// "default" is NegotiationConsecutive.
+func ParseNegotiationAlgorithm(name string) (NegotiationAlgorithm, error) {
+	switch strings.ToLower(name) {
+	case "consecutive", "default":
+		return NegotiationConsecutive, nil
+	case "skipping":
+		return NegotiationSkipping, nil
+	case "noop":
+		return NegotiationNoop, nil
+	}
+	return NegotiationConsecutive, MakeGitError(fmt.Sprintf("unknown fetch negotiation algorithm '%s'", name), ErrInvalid)
+}
+
+// NegotiationAlgorithm returns the algorithm of fetch.negotiationAlgorithm.
+// If it is not set or "default", feature.experimental selects
+// NegotiationSkipping like in git.
+func (r *Repository) NegotiationAlgorithm() NegotiationAlgorithm {
+	config := r.Config()
+	if config == nil {
+		return NegotiationConsecutive
+	}
+	if value, err := config.LookupString("fetch.negotiationAlgorithm"); err == nil && !strings.EqualFold(value, "default") {
+		if algorithm, err := ParseNegotiationAlgorithm(value); err == nil {
+			return algorithm
+		}
+	}
+	if value, err := config.LookupBool("feature.experimental"); err == nil && value {
+		return NegotiationSkipping
+	}
+	return NegotiationConsecutive
+}
+
+// FetchNegotiator chooses the "have" lines of a fetch. The tips are added
+// first, then Next and Ack are called while the server answers.
+type FetchNegotiator interface {
+	// AddTip adds a local commit whose history is offered, e.g. a branch.
+	// Tags are peeled.
+	AddTip(oid *Oid) error
+	// KnownCommon adds a commit that the server has, e.g. an advertised
+	// reference that exists locally. Its ancestors are not sent.
+	KnownCommon(oid *Oid) error
+	// Next returns the next have to send, or nil when there are none.
+	Next() (*Oid, error)
+	// Ack marks a have that the server acknowledged, and its ancestors, as
+	// common. It tells if the commit was known to be common already.
+	Ack(oid *Oid) (bool, error)
+}
+
+// NewFetchNegotiator returns a negotiator of the algorithm.
+func (r *Repository) NewFetchNegotiator(algorithm NegotiationAlgorithm) (FetchNegotiator, error) {
+	if algorithm == NegotiationNoop {
+		return noopNegotiator{}, nil
+	}
+	walk, err := r.Walk()
+	if err != nil {
+		return nil, err
+	}
+	state := &negotiationState{
+		repo:    r,
+		walk:    walk,
+		flags:   make(map[*commitListNode]negotiationFlag),
+		entries: make(map[*commitListNode]*negotiationEntry),
+	}
+	if algorithm == NegotiationSkipping {
+		return &skippingNegotiator{state}, nil
+	}
+	return &consecutiveNegotiator{state}, nil
+}
+
+// NegotiationCallback sends a round of haves to the server and returns the
+// haves that it acknowledged as common, and if it is ready to send the
+// pack.
+type NegotiationCallback func(haves []*Oid) (acks []*Oid, ready bool, err error)
+
+// Negotiate sends the haves of the negotiator in rounds that grow like in
+// the stateless protocol of git, until the server is ready, the haves run
+// out, or 256 haves after the first ack found nothing new. It returns the
+// commits that the server acknowledged.
+func (r *Repository) Negotiate(negotiator FetchNegotiator, callback NegotiationCallback) ([]*Oid, error) {
+	var common []*Oid
+	count := negotiationInitialFlush
+	inVain := 0
+	seenAck := false
+	for {
+		var haves []*Oid
+		for len(haves) < count {
+			have, err := negotiator.Next()
+			if err != nil {
+				return nil, err
+			}
+			if have == nil {
+				break
+			}
+			haves = append(haves, have)
+		}
+		if len(haves) == 0 {
+			return common, nil
+		}
+		addCounter(MetricFetchNegotiationRound, 1)
+		acks, ready, err := callback(haves)
+		if err != nil {
+			return nil, err
+		}
+		inVain += len(haves)
+		for _, ack := range acks {
+			known, err := negotiator.Ack(ack)
+			if err != nil {
+				return nil, err
+			}
+			seenAck = true
+			if !known {
+				inVain = 0
+				common = append(common, ack)
+			}
+		}
+		if ready || (seenAck && inVain >= negotiationMaxInVain) {
+			return common, nil
+		}
+		if count < negotiationLargeFlush {
+			count *= 2
+		} else {
+			count = count * 11 / 10
+		}
+	}
+}
+
+// internal functions and methods
+
+type negotiationFlag uint
+
+const (
+	negotiationSeen negotiationFlag = 1 << iota
+	negotiationCommon
+	negotiationCommonRef
+	negotiationPopped
+)
+
+type negotiationEntry struct {
+	commit *commitListNode
+	// the skipping negotiator sends the commit when ttl is 0
+	ttl         int
+	originalTtl int
+}
+
+type negotiationState struct {
+	repo    *Repository
+	walk    *RevWalk
+	flags   map[*commitListNode]negotiationFlag
+	entries map[*commitListNode]*negotiationEntry
+	// newest first, in the order of insertion for equal times
+	queue     []*negotiationEntry
+	nonCommon int
+}
+
+// commit returns the node of the commit that the oid peels to.
+func (s *negotiationState) commit(oid *Oid) (*commitListNode, error) {
+	object, err := s.repo.Lookup(oid)
+	if err != nil {
+		return nil, err
+	}
+	peeled, err := object.Peel(ObjectCommit)
+	if err != nil {
+		return nil, err
+	}
+	commit := s.walk.commitLookup(peeled.Id())
+	if err = s.walk.commitListParse(commit); err != nil {
+		return nil, err
+	}
+	return commit, nil
+}
+
+func (s *negotiationState) push(commit *commitListNode) (*negotiationEntry, error) {
+	if err := s.walk.commitListParse(commit); err != nil {
+		return nil, err
+	}
+	entry := &negotiationEntry{commit: commit}
+	i := sort.Search(len(s.queue), func(i int) bool {
+		return s.queue[i].commit.time < commit.time
+	})
+	s.queue = append(s.queue, nil)
+	copy(s.queue[i+1:], s.queue[i:])
+	s.queue[i] = entry
+	s.entries[commit] = entry
+	return entry, nil
+}
+
+func (s *negotiationState) pop() *negotiationEntry {
+	entry := s.queue[0]
+	s.queue = s.queue[1:]
+	delete(s.entries, entry.commit)
+	s.flags[entry.commit] |= negotiationPopped
+	if s.flags[entry.commit]&negotiationCommon == 0 {
+		s.nonCommon--
+	}
+	return entry
+}
+
+// consecutiveNegotiator is git's default negotiator.
+type consecutiveNegotiator struct {
+	*negotiationState
+}
+
+func (n *consecutiveNegotiator) AddTip(oid *Oid) error {
+	commit, err := n.commit(oid)
+	if err != nil {
+		return err
+	}
+	return n.revListPush(commit, negotiationSeen)
+}
+
+func (n *consecutiveNegotiator) KnownCommon(oid *Oid) error {
+	commit, err := n.commit(oid)
+	if err != nil {
+		return err
+	}
+	if n.flags[commit]&negotiationSeen != 0 {
+		return nil
+	}
+	if err = n.revListPush(commit, negotiationCommonRef|negotiationSeen); err != nil {
+		return err
+	}
+	return n.markCommon(commit, true)
+}
+
+func (n *consecutiveNegotiator) Next() (*Oid, error) {
+	for len(n.queue) > 0 && n.nonCommon > 0 {
+		commit := n.pop().commit
+		flags := n.flags[commit]
+		mark := negotiationSeen
+		if flags&(negotiationCommon|negotiationCommonRef) != 0 {
+			// the ancestors of common commits are not sent
+			mark |= negotiationCommon
+		}
+		for _, parent := range commit.parents {
+			if n.flags[parent]&negotiationSeen == 0 {
+				if err := n.revListPush(parent, mark); err != nil {
+					return nil, err
+				}
+			}
+			if mark&negotiationCommon != 0 {
+				if err := n.markCommon(parent, true); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if flags&negotiationCommon == 0 {
+			return commit.oid, nil
+		}
+	}
+	return nil, nil
+}
+
+func (n *consecutiveNegotiator) Ack(oid *Oid) (bool, error) {
+	commit, err := n.commit(oid)
+	if err != nil {
+		return false, err
+	}
+	known := n.flags[commit]&negotiationCommon != 0
+	return known, n.markCommon(commit, false)
+}
+
+func (n *consecutiveNegotiator) revListPush(commit *commitListNode, mark negotiationFlag) error {
+	if n.flags[commit]&mark != 0 {
+		return nil
+	}
+	n.flags[commit] |= mark
+	if _, err := n.push(commit); err != nil {
+		return err
+	}
+	if n.flags[commit]&negotiationCommon == 0 {
+		n.nonCommon++
+	}
+	return nil
+}
+
+func (n *consecutiveNegotiator) markCommon(commit *commitListNode, ancestorsOnly bool) error {
+	flags := n.flags[commit]
+	if flags&negotiationCommon != 0 {
+		return nil
+	}
+	if !ancestorsOnly {
+		n.flags[commit] |= negotiationCommon
+	}
+	if flags&negotiationSeen == 0 {
+		return n.revListPush(commit, negotiationSeen)
+	}
+	if !ancestorsOnly && flags&negotiationPopped == 0 {
+		n.nonCommon--
+	}
+	if !commit.parsed {
+		return nil
+	}
+	for _, parent := range commit.parents {
+		if err := n.markCommon(parent, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skippingNegotiator sends a commit, skips one, then 2, 4 and so on along
+// each line of history, so that it reaches old common commits in few
+// rounds. Commits without a sent descendant are always sent.
+type skippingNegotiator struct {
+	*negotiationState
+}
+
+func (n *skippingNegotiator) AddTip(oid *Oid) error {
+	commit, err := n.commit(oid)
+	if err != nil {
+		return err
+	}
+	if n.flags[commit]&negotiationSeen != 0 {
+		return nil
+	}
+	_, err = n.pushEntry(commit)
+	return err
+}
+
+func (n *skippingNegotiator) KnownCommon(oid *Oid) error {
+	commit, err := n.commit(oid)
+	if err != nil {
+		return err
+	}
+	if n.flags[commit]&negotiationSeen == 0 {
+		if _, err = n.pushEntry(commit); err != nil {
+			return err
+		}
+		n.markCommon(commit)
+	}
+	return nil
+}
+
+func (n *skippingNegotiator) Next() (*Oid, error) {
+	for len(n.queue) > 0 && n.nonCommon > 0 {
+		entry := n.pop()
+		commit := entry.commit
+		common := n.flags[commit]&negotiationCommon != 0
+		send := !common && entry.ttl == 0
+		parentPushed := false
+		for _, parent := range commit.parents {
+			pushed, err := n.pushParent(entry, parent)
+			if err != nil {
+				return nil, err
+			}
+			parentPushed = parentPushed || pushed
+		}
+		// a commit without parents to go on with is sent anyway
+		if send || (!common && !parentPushed) {
+			return commit.oid, nil
+		}
+	}
+	return nil, nil
+}
+
+func (n *skippingNegotiator) Ack(oid *Oid) (bool, error) {
+	commit, err := n.commit(oid)
+	if err != nil {
+		return false, err
+	}
+	if n.flags[commit]&negotiationCommon != 0 {
+		return true, nil
+	}
+	n.markCommon(commit)
+	return false, nil
+}
+
+func (n *skippingNegotiator) pushEntry(commit *commitListNode) (*negotiationEntry, error) {
+	entry, err := n.push(commit)
+	if err != nil {
+		return nil, err
+	}
+	n.flags[commit] |= negotiationSeen
+	if n.flags[commit]&negotiationCommon == 0 {
+		n.nonCommon++
+	}
+	return entry, nil
+}
+
+// pushParent queues the parent with the ttl that follows the entry of its
+// child, or raises the ttl of its entry.
+func (n *skippingNegotiator) pushParent(entry *negotiationEntry, parent *commitListNode) (bool, error) {
+	flags := n.flags[parent]
+	var parentEntry *negotiationEntry
+	if flags&negotiationSeen != 0 {
+		if flags&negotiationPopped != 0 {
+			return false, nil
+		}
+		parentEntry = n.entries[parent]
+	} else {
+		var err error
+		if parentEntry, err = n.pushEntry(parent); err != nil {
+			return false, err
+		}
+	}
+	if flags&negotiationCommon == 0 {
+		originalTtl, ttl := entry.originalTtl, entry.ttl-1
+		if entry.ttl == 0 {
+			originalTtl = entry.originalTtl*3/2 + 1
+			ttl = originalTtl
+		}
+		if parentEntry.originalTtl < originalTtl {
+			parentEntry.originalTtl = originalTtl
+			parentEntry.ttl = ttl
+		}
+	}
+	return true, nil
+}
+
+// markCommon marks the commit and its ancestors that were seen as common.
+func (n *skippingNegotiator) markCommon(commit *commitListNode) {
+	if n.flags[commit]&negotiationCommon != 0 {
+		return
+	}
+	n.flags[commit] |= negotiationCommon
+	stack := []*commitListNode{commit}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if n.flags[current]&negotiationPopped == 0 {
+			n.nonCommon--
+		}
+		if !current.parsed {
+			continue
+		}
+		for _, parent := range current.parents {
+			flags := n.flags[parent]
+			if flags&negotiationSeen == 0 || flags&negotiationCommon != 0 {
+				continue
+			}
+			n.flags[parent] |= negotiationCommon
+			stack = append(stack, parent)
+		}
+	}
+}
+
+type noopNegotiator struct{}
+
+func (noopNegotiator) AddTip(oid *Oid) error {
+	return nil
+}
+
+func (noopNegotiator) KnownCommon(oid *Oid) error {
+	return nil
+}
+
+func (noopNegotiator) Next() (*Oid, error) {
+	return nil, nil
+}
+
+func (noopNegotiator) Ack(oid *Oid) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,115 @@
+package git4go
+
+import (
+	"testing"
+	"time"
+)
+
+// createLinearHistory creates count commits on top of parent, one minute
+// apart, and returns their ids, oldest first.
+func createLinearHistory(repo *Repository, parent *Commit, count int, start time.Time) []*Oid {
+	index, _ := NewIndex()
+	treeId, _ := index.WriteTreeTo(repo)
+	tree, _ := repo.LookupTree(treeId)
+	var ids []*Oid
+	for i := 0; i < count; i++ {
+		sig := &Signature{"A", "a@example.com", start.Add(time.Duration(i) * time.Minute)}
+		var parents []*Commit
+		if parent != nil {
+			parents = append(parents, parent)
+		}
+		id, _ := repo.CreateCommit("", sig, sig, "commit\n", tree, parents...)
+		parent, _ = repo.LookupCommit(id)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func Test_FetchNegotiation(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	start := time.Unix(1400000000, 0)
+	shared := createLinearHistory(repo, nil, 50, start)
+	base, _ := repo.LookupCommit(shared[len(shared)-1])
+	local := createLinearHistory(repo, base, 1000, start.Add(time.Hour))
+	tip := local[len(local)-1]
+
+	server := make(map[Oid]bool)
+	for _, id := range shared {
+		server[*id] = true
+	}
+	negotiate := func(algorithm NegotiationAlgorithm) ([]*Oid, int, int) {
+		negotiator, err := repo.NewFetchNegotiator(algorithm)
+		if err != nil {
+			t.Fatal("err should be nil:", err)
+		}
+		if err = negotiator.AddTip(tip); err != nil {
+			t.Fatal("err should be nil:", err)
+		}
+		sent, rounds := 0, 0
+		common, err := repo.Negotiate(negotiator, func(haves []*Oid) ([]*Oid, bool, error) {
+			sent += len(haves)
+			rounds++
+			var acks []*Oid
+			for _, have := range haves {
+				if server[*have] {
+					acks = append(acks, have)
+				}
+			}
+			return acks, false, nil
+		})
+		if err != nil {
+			t.Fatal("err should be nil:", err)
+		}
+		return common, sent, rounds
+	}
+
+	common, consecutiveSent, _ := negotiate(NegotiationConsecutive)
+	if len(common) != 1 || !common[0].Equal(base.Id()) {
+		t.Error("it should find the newest common commit:", common)
+	}
+	// rounds of 16, 32, ... 512 haves, the last one past the common commit
+	if consecutiveSent != 1008 {
+		t.Error("it should send every commit down to the common one:", consecutiveSent)
+	}
+	common, skippingSent, skippingRounds := negotiate(NegotiationSkipping)
+	if len(common) == 0 || !server[*common[0]] {
+		t.Error("it should find a common commit:", common)
+	}
+	if skippingSent >= 100 || skippingRounds > 3 {
+		t.Error("it should skip commits:", skippingSent, skippingRounds)
+	}
+	if common, sent, _ := negotiate(NegotiationNoop); len(common) != 0 || sent != 0 {
+		t.Error("it should not send haves:", common, sent)
+	}
+
+	negotiator, _ := repo.NewFetchNegotiator(NegotiationConsecutive)
+	negotiator.AddTip(tip)
+	negotiator.KnownCommon(local[900])
+	sent := 0
+	for {
+		have, _ := negotiator.Next()
+		if have == nil {
+			break
+		}
+		sent++
+	}
+	if sent != 100 {
+		t.Error("it should not send the ancestors of known common commits:", sent)
+	}
+
+	config := repo.Config()
+	if repo.NegotiationAlgorithm() != NegotiationConsecutive {
+		t.Error("it should use the consecutive algorithm by default")
+	}
+	config.SetBool("feature.experimental", true)
+	if repo.NegotiationAlgorithm() != NegotiationSkipping {
+		t.Error("it should use the skipping algorithm with feature.experimental")
+	}
+	config.SetString("fetch.negotiationAlgorithm", "noop")
+	if repo.NegotiationAlgorithm() != NegotiationNoop {
+		t.Error("it should use fetch.negotiationAlgorithm")
+	}
+	if _, err := ParseNegotiationAlgorithm("fastest"); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should reject unknown algorithms:", err)
+	}
+}
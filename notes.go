@@ -0,0 +1,262 @@
+package git4go
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	GitNotesRefsDir    = "refs/notes/"
+	GitNotesDefaultRef = "refs/notes/commits"
+)
+
+// Note is the note that is attached to an object. Notes are blobs in the
+// tree of the notes reference, named by the hex id of the annotated object
+// and possibly split into fan-out directories like "ab/cdef...".
+type Note struct {
+	// the id of the blob of the note
+	Id        *Oid
+	Annotated *Oid
+	Message   string
+}
+
+// DefaultNotesRef returns core.notesRef, or refs/notes/commits if it is
+// not set.
+func (r *Repository) DefaultNotesRef() string {
+	if config := r.Config(); config != nil {
+		if value, err := config.LookupString("core.notesRef"); err == nil && value != "" {
+			return expandNotesRef(value)
+		}
+	}
+	return GitNotesDefaultRef
+}
+
+// ReadNote returns the note of the object. notesRef is expanded like git
+// does: "review" is refs/notes/review, and "" is DefaultNotesRef. It fails
+// with ErrNotFound if the object has no note.
+func (r *Repository) ReadNote(notesRef string, annotated *Oid) (*Note, error) {
+	notesRef = r.notesRefName(notesRef)
+	commit, err := r.notesCommit(notesRef)
+	if err != nil {
+		return nil, err
+	}
+	if commit != nil {
+		tree, err := commit.Tree()
+		if err != nil {
+			return nil, err
+		}
+		id, err := r.findNote(tree, annotated.String())
+		if err != nil {
+			return nil, err
+		}
+		if id != nil {
+			blob, err := r.LookupBlob(id)
+			if err != nil {
+				return nil, err
+			}
+			return &Note{Id: id, Annotated: annotated.Copy(), Message: string(blob.Contents())}, nil
+		}
+	}
+	return nil, MakeGitError(fmt.Sprintf("note could not be found for object %s in %s", annotated.String(), notesRef), ErrNotFound)
+}
+
+// ForEachNote calls the callback with the blob id and the annotated object
+// of each note of the notes reference, in the order of the annotated ids.
+func (r *Repository) ForEachNote(notesRef string, callback func(id, annotated *Oid) error) error {
+	commit, err := r.notesCommit(r.notesRefName(notesRef))
+	if err != nil || commit == nil {
+		return err
+	}
+	notes, _, err := r.readNotes(commit)
+	if err != nil {
+		return err
+	}
+	for _, annotated := range sortedNoteIds(notes) {
+		if err := callback(notes[annotated], &annotated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateNote adds the note to the object with a new commit of the notes
+// reference and returns the id of the blob of the note. If the object has
+// a note already, it is replaced if force is true and ErrExists is
+// returned otherwise.
+func (r *Repository) CreateNote(notesRef string, author, committer *Signature, annotated *Oid, message string, force bool) (*Oid, error) {
+	notesRef = r.notesRefName(notesRef)
+	commit, err := r.notesCommit(notesRef)
+	if err != nil {
+		return nil, err
+	}
+	notes, other, err := r.readNotes(commit)
+	if err != nil {
+		return nil, err
+	}
+	if notes[*annotated] != nil && !force {
+		return nil, MakeGitError(fmt.Sprintf("note for object %s already exists", annotated.String()), ErrExists)
+	}
+	id, err := r.CreateBlobFromBuffer([]byte(message))
+	if err != nil {
+		return nil, err
+	}
+	notes[*annotated] = id
+	var parents []*Commit
+	if commit != nil {
+		parents = append(parents, commit)
+	}
+	_, err = r.writeNotesCommit(notesRef, author, committer, "Notes added by 'git notes add'\n", notes, other, parents)
+	if err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// internal functions and methods
+
+// expandNotesRef returns the full name of the notes reference like git:
+// refs/notes/ is added unless the name starts with it, and "notes/x" is
+// refs/notes/x.
+func expandNotesRef(name string) string {
+	switch {
+	case strings.HasPrefix(name, GitNotesRefsDir):
+		return name
+	case strings.HasPrefix(name, "notes/"):
+		return GitRefsDir + name
+	}
+	return GitNotesRefsDir + name
+}
+
+func (r *Repository) notesRefName(notesRef string) string {
+	if notesRef == "" {
+		return r.DefaultNotesRef()
+	}
+	return expandNotesRef(notesRef)
+}
+
+// notesCommit returns the commit of the notes reference, or nil if it
+// doesn't exist yet.
+func (r *Repository) notesCommit(notesRef string) (*Commit, error) {
+	ref, err := r.LookupReference(notesRef)
+	if IsErrorCode(err, ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	resolved, err := ref.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	return r.LookupCommit(resolved.Target())
+}
+
+// findNote returns the blob of the note in the tree of notes, following
+// the fan-out directories, or nil if there is none.
+func (r *Repository) findNote(tree *Tree, hex string) (*Oid, error) {
+	for {
+		if entry := tree.EntryByName(hex); entry != nil && entry.Type == ObjectBlob {
+			return entry.Id, nil
+		}
+		if len(hex) <= 2 {
+			return nil, nil
+		}
+		entry := tree.EntryByName(hex[:2])
+		if entry == nil || entry.Type != ObjectTree {
+			return nil, nil
+		}
+		var err error
+		tree, err = r.LookupTree(entry.Id)
+		if err != nil {
+			return nil, err
+		}
+		hex = hex[2:]
+	}
+}
+
+// readNotes returns the notes of the notes commit by annotated id, and the
+// other entries of its tree by path, which are kept as they are. A nil
+// commit has no notes.
+func (r *Repository) readNotes(commit *Commit) (map[Oid]*Oid, map[string]*TreeEntry, error) {
+	notes := make(map[Oid]*Oid)
+	other := make(map[string]*TreeEntry)
+	if commit == nil {
+		return notes, other, nil
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, err := flattenTree(tree)
+	if err != nil {
+		return nil, nil, err
+	}
+	for path, entry := range entries {
+		hex := strings.Replace(path, "/", "", -1)
+		if entry.Type == ObjectBlob && len(hex) == GitOidHexSize {
+			if annotated, err := NewOid(hex); err == nil {
+				notes[*annotated] = entry.Id
+				continue
+			}
+		}
+		other[path] = entry
+	}
+	return notes, other, nil
+}
+
+// notesFanout returns the number of fan-out directories for the notes:
+// one more for each 256 times as many notes, so that the trees stay small.
+func notesFanout(count int) int {
+	fanout := 0
+	for limit := 256; count > limit && fanout < GitOidRawSize-1; limit *= 256 {
+		fanout++
+	}
+	return fanout
+}
+
+// writeNotesCommit writes the tree of the notes and commits it to the
+// notes reference, whose current value must be the first parent.
+func (r *Repository) writeNotesCommit(notesRef string, author, committer *Signature, message string, notes map[Oid]*Oid, other map[string]*TreeEntry, parents []*Commit) (*Oid, error) {
+	index, err := NewIndex()
+	if err != nil {
+		return nil, err
+	}
+	fanout := notesFanout(len(notes))
+	for annotated, id := range notes {
+		hex := annotated.String()
+		path := ""
+		for i := 0; i < fanout; i++ {
+			path += hex[i*2:i*2+2] + "/"
+		}
+		path += hex[fanout*2:]
+		if err := index.Add(&IndexEntry{Path: path, Mode: FilemodeBlob, Id: id}); err != nil {
+			return nil, err
+		}
+	}
+	for path, entry := range other {
+		if err := index.Add(&IndexEntry{Path: path, Mode: entry.Filemode, Id: entry.Id}); err != nil {
+			return nil, err
+		}
+	}
+	treeId, err := index.WriteTreeTo(r)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := r.LookupTree(treeId)
+	if err != nil {
+		return nil, err
+	}
+	return r.CreateCommit(notesRef, author, committer, message, tree, parents...)
+}
+
+func sortedNoteIds(notes map[Oid]*Oid) []Oid {
+	ids := make([]Oid, 0, len(notes))
+	for annotated := range notes {
+		ids = append(ids, annotated)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i][:], ids[j][:]) < 0
+	})
+	return ids
+}
@@ -0,0 +1,283 @@
+package git4go
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// NotesMergeStrategy resolves the notes that both sides changed
+// differently, like "git notes merge --strategy".
+type NotesMergeStrategy int
+
+const (
+	// notes.<name>.mergeStrategy or notes.mergeStrategy, or
+	// NotesMergeManual if they are not set
+	NotesMergeDefault NotesMergeStrategy = iota
+	// conflicts are not resolved; the merge fails with ErrMergeConflict
+	NotesMergeManual
+	// the local note wins
+	NotesMergeOurs
+	// the remote note wins
+	NotesMergeTheirs
+	// the local and the remote note are concatenated
+	NotesMergeUnion
+	// the lines of the local and the remote note are concatenated, sorted
+	// and made unique; empty lines are removed
+	NotesMergeCatSortUniq
+)
+
+type NotesMergeOptions struct {
+	Strategy NotesMergeStrategy
+	// The committer of the merge commit. The default signature of the
+	// repository is used if it is nil.
+	Committer *Signature
+}
+
+type NotesMergeResult struct {
+	// The new commit of the local notes reference
+	Id *Oid
+	// The local notes reference already had the remote notes
+	UpToDate bool
+	// The local notes reference was moved to the remote notes
+	FastForward bool
+	// The annotated objects whose notes both sides changed differently,
+	// whether or not the strategy resolved them
+	Conflicts []*Oid
+}
+
+// MergeNotes merges the notes of remoteRef into localRef, like "git notes
+// merge", e.g. to collect the notes that CI servers push to
+// refs/notes/ci. The names are expanded like ReadNote does. If one side
+// has all notes of the other, the local reference is left alone or
+// fast-forwarded. Otherwise the changes of both
+// sides since their merge base are merged per annotated object and a merge
+// commit with both parents is written. With NotesMergeManual, the conflicts
+// are returned with ErrMergeConflict and nothing is written; git's
+// NOTES_MERGE_WORKTREE for resolving them by hand is not supported.
+func (r *Repository) MergeNotes(localRef, remoteRef string, opts *NotesMergeOptions) (*NotesMergeResult, error) {
+	if opts == nil {
+		opts = &NotesMergeOptions{}
+	}
+	localRef = r.notesRefName(localRef)
+	if remoteRef == "" {
+		return nil, MakeGitError("the remote notes reference should not be empty", ErrInvalidSpec)
+	}
+	remoteRef = expandNotesRef(remoteRef)
+	strategy := opts.Strategy
+	if strategy == NotesMergeDefault {
+		strategy = r.notesMergeStrategy(localRef)
+	}
+
+	remote, err := r.notesCommit(remoteRef)
+	if err != nil {
+		return nil, err
+	}
+	if remote == nil {
+		return nil, MakeGitError(fmt.Sprintf("notes reference %s does not exist", remoteRef), ErrNotFound)
+	}
+	local, err := r.notesCommit(localRef)
+	if err != nil {
+		return nil, err
+	}
+	if local == nil {
+		if _, err = r.CreateReference(localRef, remote.Id(), false); err != nil {
+			return nil, err
+		}
+		return &NotesMergeResult{Id: remote.Id().Copy(), FastForward: true}, nil
+	}
+	var base *Commit
+	baseId, err := r.MergeBase(local.Id(), remote.Id())
+	if err == nil {
+		base, err = r.LookupCommit(baseId)
+	}
+	if err != nil && !IsErrorCode(err, ErrNotFound) {
+		return nil, err
+	}
+	switch {
+	case base != nil && base.Id().Equal(remote.Id()):
+		return &NotesMergeResult{Id: local.Id().Copy(), UpToDate: true}, nil
+	case base != nil && base.Id().Equal(local.Id()):
+		if err = r.updateCommitRef(localRef, local.Id(), remote.Id()); err != nil {
+			return nil, err
+		}
+		return &NotesMergeResult{Id: remote.Id().Copy(), FastForward: true}, nil
+	}
+
+	sides := make([]map[Oid]*Oid, 3)
+	var other map[string]*TreeEntry
+	for i, commit := range []*Commit{base, local, remote} {
+		notes, entries, err := r.readNotes(commit)
+		if err != nil {
+			return nil, err
+		}
+		sides[i] = notes
+		if commit == local {
+			other = entries
+		}
+	}
+	result := &NotesMergeResult{}
+	merged, err := r.mergeNotes(sides[0], sides[1], sides[2], strategy, result)
+	if err != nil {
+		return nil, err
+	}
+	if strategy == NotesMergeManual && len(result.Conflicts) > 0 {
+		return result, MakeGitError(fmt.Sprintf("automatic notes merge failed with %d conflicts", len(result.Conflicts)), ErrMergeConflict)
+	}
+
+	committer := opts.Committer
+	if committer == nil {
+		committer, err = r.DefaultSignature()
+		if err != nil {
+			return nil, err
+		}
+	}
+	message := fmt.Sprintf("notes: Merged notes from %s into %s\n", remoteRef, localRef)
+	result.Id, err = r.writeNotesCommit(localRef, committer, committer, message, merged, other, []*Commit{local, remote})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ParseNotesMergeStrategy returns the strategy of the name that git uses,
+// e.g. "cat_sort_uniq".
+func ParseNotesMergeStrategy(name string) (NotesMergeStrategy, error) {
+	switch name {
+	case "manual":
+		return NotesMergeManual, nil
+	case "ours":
+		return NotesMergeOurs, nil
+	case "theirs":
+		return NotesMergeTheirs, nil
+	case "union":
+		return NotesMergeUnion, nil
+	case "cat_sort_uniq":
+		return NotesMergeCatSortUniq, nil
+	}
+	return NotesMergeDefault, MakeGitError(fmt.Sprintf("unknown notes merge strategy '%s'", name), ErrInvalid)
+}
+
+// internal functions and methods
+
+// notesMergeStrategy returns the strategy of notes.<name>.mergeStrategy or
+// notes.mergeStrategy, where name is the reference without refs/notes/.
+func (r *Repository) notesMergeStrategy(notesRef string) NotesMergeStrategy {
+	config := r.Config()
+	if config == nil {
+		return NotesMergeManual
+	}
+	name := strings.TrimPrefix(notesRef, GitNotesRefsDir)
+	for _, key := range []string{"notes." + name + ".mergeStrategy", "notes.mergeStrategy"} {
+		if value, err := config.LookupString(key); err == nil {
+			if strategy, err := ParseNotesMergeStrategy(value); err == nil {
+				return strategy
+			}
+		}
+	}
+	return NotesMergeManual
+}
+
+// mergeNotes merges the changes that local and remote made to the notes of
+// base. The conflicts are added to the result.
+func (r *Repository) mergeNotes(base, local, remote map[Oid]*Oid, strategy NotesMergeStrategy, result *NotesMergeResult) (map[Oid]*Oid, error) {
+	annotated := make(map[Oid]*Oid)
+	for _, side := range []map[Oid]*Oid{base, local, remote} {
+		for id := range side {
+			annotated[id] = nil
+		}
+	}
+	merged := make(map[Oid]*Oid)
+	for _, id := range sortedNoteIds(annotated) {
+		baseNote, localNote, remoteNote := base[id], local[id], remote[id]
+		note := localNote
+		switch {
+		case sameNote(localNote, remoteNote) || sameNote(remoteNote, baseNote):
+		case sameNote(localNote, baseNote):
+			note = remoteNote
+		default:
+			result.Conflicts = append(result.Conflicts, id.Copy())
+			var err error
+			note, err = r.resolveNotes(localNote, remoteNote, strategy)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if note != nil {
+			merged[id] = note
+		}
+	}
+	return merged, nil
+}
+
+func sameNote(one, two *Oid) bool {
+	if one == nil || two == nil {
+		return one == two
+	}
+	return one.Equal(two)
+}
+
+// resolveNotes returns the note that the strategy makes of the local and
+// the remote note, which may be nil if it was removed.
+func (r *Repository) resolveNotes(local, remote *Oid, strategy NotesMergeStrategy) (*Oid, error) {
+	switch strategy {
+	case NotesMergeOurs:
+		return local, nil
+	case NotesMergeTheirs:
+		return remote, nil
+	case NotesMergeUnion, NotesMergeCatSortUniq:
+	default:
+		// the conflict is only reported
+		return local, nil
+	}
+	localMessage, err := r.noteContents(local)
+	if err != nil {
+		return nil, err
+	}
+	remoteMessage, err := r.noteContents(remote)
+	if err != nil {
+		return nil, err
+	}
+	var combined []byte
+	if strategy == NotesMergeUnion {
+		switch {
+		case len(remoteMessage) == 0:
+			return local, nil
+		case len(localMessage) == 0:
+			return remote, nil
+		}
+		// like git, the notes are separated by an empty line
+		combined = append(combined, bytes.TrimSuffix(localMessage, []byte("\n"))...)
+		combined = append(combined, '\n', '\n')
+		combined = append(combined, remoteMessage...)
+	} else {
+		var lines []string
+		for _, line := range strings.Split(string(localMessage)+"\n"+string(remoteMessage), "\n") {
+			if line != "" {
+				lines = append(lines, line)
+			}
+		}
+		sort.Strings(lines)
+		for i, line := range lines {
+			if i == 0 || line != lines[i-1] {
+				combined = append(combined, line+"\n"...)
+			}
+		}
+		if len(combined) == 0 {
+			return nil, nil
+		}
+	}
+	return r.CreateBlobFromBuffer(combined)
+}
+
+func (r *Repository) noteContents(id *Oid) ([]byte, error) {
+	if id == nil {
+		return nil, nil
+	}
+	blob, err := r.LookupBlob(id)
+	if err != nil {
+		return nil, err
+	}
+	return blob.Contents(), nil
+}
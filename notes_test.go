@@ -0,0 +1,132 @@
+package git4go
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Notes(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	sig := &Signature{"A", "a@example.com", time.Unix(1400000000, 0)}
+	one, _ := NewOid("1111111111111111111111111111111111111111")
+	two, _ := NewOid("2222222222222222222222222222222222222222")
+
+	if _, err := repo.ReadNote("", one); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should not find notes without the reference:", err)
+	}
+	id, err := repo.CreateNote("", sig, sig, one, "first\n", false)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	repo.CreateNote("commits", sig, sig, two, "second\n", false)
+	note, err := repo.ReadNote(GitNotesDefaultRef, one)
+	if err != nil || !note.Id.Equal(id) || note.Message != "first\n" {
+		t.Error("it should read the note:", note, err)
+	}
+	if _, err = repo.CreateNote("", sig, sig, one, "again\n", false); !IsErrorCode(err, ErrExists) {
+		t.Error("it should not replace the note without force:", err)
+	}
+	var annotated []string
+	repo.ForEachNote("", func(id, object *Oid) error {
+		annotated = append(annotated, object.String()[:2])
+		return nil
+	})
+	if len(annotated) != 2 || annotated[0] != "11" || annotated[1] != "22" {
+		t.Error("it should iterate the notes:", annotated)
+	}
+
+	// notes in fan-out directories
+	blob, _ := repo.CreateBlobFromBuffer([]byte("fanout\n"))
+	index, _ := NewIndex()
+	index.Add(&IndexEntry{Path: "33/33/333333333333333333333333333333333333", Mode: FilemodeBlob, Id: blob})
+	treeId, _ := index.WriteTreeTo(repo)
+	tree, _ := repo.LookupTree(treeId)
+	repo.CreateCommit("refs/notes/fanout", sig, sig, "fanout\n", tree)
+	three, _ := NewOid("3333333333333333333333333333333333333333")
+	if note, err = repo.ReadNote("fanout", three); err != nil || note.Message != "fanout\n" {
+		t.Error("it should read the notes in fan-out directories:", note, err)
+	}
+	if notesFanout(256) != 0 || notesFanout(257) != 1 {
+		t.Error("it should add fan-out directories for many notes")
+	}
+}
+
+func Test_MergeNotes(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	sig := &Signature{"A", "a@example.com", time.Unix(1400000000, 0)}
+	one, _ := NewOid("1111111111111111111111111111111111111111")
+	two, _ := NewOid("2222222222222222222222222222222222222222")
+	three, _ := NewOid("3333333333333333333333333333333333333333")
+
+	repo.CreateNote("", sig, sig, one, "build: ok\n", false)
+	base, _ := repo.CreateNote("", sig, sig, two, "base\n", false)
+	baseCommit, _ := repo.notesCommit(GitNotesDefaultRef)
+	repo.CreateReference("refs/notes/ci", baseCommit.Id(), false)
+
+	result, err := repo.MergeNotes("", "ci", &NotesMergeOptions{Committer: sig})
+	if err != nil || !result.UpToDate {
+		t.Error("it should be up to date:", result, err)
+	}
+	repo.CreateNote("ci", sig, sig, one, "test: ok\nbuild: ok\n", true)
+	repo.CreateNote("ci", sig, sig, three, "remote\n", false)
+	remoteCommit, _ := repo.notesCommit("refs/notes/ci")
+	repo.CreateNote("", sig, sig, one, "lint: ok\nbuild: ok\n", true)
+	localCommit, _ := repo.notesCommit(GitNotesDefaultRef)
+
+	_, err = repo.MergeNotes("", "ci", &NotesMergeOptions{Committer: sig})
+	if !IsErrorCode(err, ErrMergeConflict) {
+		t.Error("it should fail with the manual strategy:", err)
+	}
+	if current, _ := repo.notesCommit(GitNotesDefaultRef); !current.Id().Equal(localCommit.Id()) {
+		t.Error("it should not write the conflicting merge")
+	}
+
+	expected := map[NotesMergeStrategy]string{
+		NotesMergeOurs:        "lint: ok\nbuild: ok\n",
+		NotesMergeTheirs:      "test: ok\nbuild: ok\n",
+		NotesMergeUnion:       "lint: ok\nbuild: ok\n\ntest: ok\nbuild: ok\n",
+		NotesMergeCatSortUniq: "build: ok\nlint: ok\ntest: ok\n",
+	}
+	for strategy, message := range expected {
+		repo.CreateReference("refs/notes/merged", localCommit.Id(), true)
+		result, err = repo.MergeNotes("merged", "refs/notes/ci", &NotesMergeOptions{Strategy: strategy, Committer: sig})
+		if err != nil || len(result.Conflicts) != 1 || !result.Conflicts[0].Equal(one) {
+			t.Error("it should resolve the conflict:", strategy, result, err)
+			continue
+		}
+		if note, _ := repo.ReadNote("merged", one); note == nil || note.Message != message {
+			t.Errorf("it should merge the notes with %d: %v", strategy, note)
+		}
+		if note, _ := repo.ReadNote("merged", two); note == nil || !note.Id.Equal(base) {
+			t.Error("it should keep the unchanged notes")
+		}
+		if note, _ := repo.ReadNote("merged", three); note == nil || note.Message != "remote\n" {
+			t.Error("it should add the remote notes")
+		}
+		commit, _ := repo.notesCommit("refs/notes/merged")
+		if len(commit.Parents) != 2 || commit.Message() != "notes: Merged notes from refs/notes/ci into refs/notes/merged\n" {
+			t.Error("it should write a merge commit:", commit.Parents, commit.Message())
+		}
+	}
+
+	// the config selects the strategy
+	repo.Config().SetString("notes.merged.mergeStrategy", "theirs")
+	repo.CreateReference("refs/notes/merged", localCommit.Id(), true)
+	repo.MergeNotes("merged", "ci", &NotesMergeOptions{Committer: sig})
+	if note, _ := repo.ReadNote("merged", one); note == nil || note.Message != "test: ok\nbuild: ok\n" {
+		t.Error("it should use notes.<name>.mergeStrategy:", note)
+	}
+
+	result, err = repo.MergeNotes("fresh", "ci", nil)
+	if err != nil || !result.FastForward || !result.Id.Equal(remoteCommit.Id()) {
+		t.Error("it should fast-forward a new reference:", result, err)
+	}
+	repo.CreateReference("refs/notes/behind", baseCommit.Id(), false)
+	result, err = repo.MergeNotes("behind", "ci", nil)
+	if err != nil || !result.FastForward {
+		t.Error("it should fast-forward:", result, err)
+	}
+	if _, err = repo.MergeNotes("", "missing", nil); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should fail without the remote notes:", err)
+	}
+}
@@ -1,7 +1,6 @@
 package git4go
 
 import (
-	"crypto/sha1"
 	"errors"
 	"fmt"
 )
@@ -52,17 +51,15 @@ func (o ObjectType) String() string {
 }
 
 func hash(data []byte, objType ObjectType) (*Oid, error) {
-	h := sha1.New()
+	h := newHasher()
 	fmt.Fprintf(h, "%s %d\x00", objType.String(), len(data))
 	h.Write(data)
-	sha1Hash := h.Sum(nil)
-	oid := new(Oid)
-	copy(oid[:], sha1Hash[:])
-	return oid, nil
+	return sumHasher(h)
 }
 
 type Object interface {
 	Id() *Oid
+	ShortId() (string, error)
 	Type() ObjectType
 	Owner() *Repository
 	Peel(targetType ObjectType) (Object, error)
@@ -81,6 +78,10 @@ func (o *gitObject) Id() *Oid {
 	return o.oid
 }
 
+func (o *gitObject) ShortId() (string, error) {
+	return o.repo.ShortId(o.oid)
+}
+
 func checkTypeCombination(sourceType, targetType ObjectType) bool {
 	if sourceType == targetType {
 		return true
@@ -0,0 +1,274 @@
+package git4go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+type ObjectCreationCheck int32
+
+const (
+	// The objects that are created are checked like "git fsck" does:
+	// tree entries are sorted, unique and have valid names and modes, and
+	// the ident lines of commits and tags are well formed
+	ObjectCreationCheckFormat ObjectCreationCheck = 1 << iota
+	// The objects that a new tree, commit or tag points to have to exist
+	// with the right type. Submodule commits are not checked.
+	ObjectCreationCheckTargets
+)
+
+var objectCreationChecks int32 = int32(ObjectCreationCheckFormat)
+
+// SetObjectCreationChecks selects the checks that TreeBuilder.Write,
+// Index.WriteTreeTo, CreateCommit and CreateTag make before they write an
+// object. Only ObjectCreationCheckFormat is enabled by default; 0 turns the
+// checks off for tools that recreate broken history. Odb.Write never checks
+// the objects.
+func SetObjectCreationChecks(checks ObjectCreationCheck) {
+	atomic.StoreInt32(&objectCreationChecks, int32(checks))
+}
+
+func GetObjectCreationChecks() ObjectCreationCheck {
+	return ObjectCreationCheck(atomic.LoadInt32(&objectCreationChecks))
+}
+
+// internal functions and methods
+
+// checkNewObject makes the checks of GetObjectCreationChecks on the object
+// that is going to be written. It fails with ErrInvalid.
+func (o *Odb) checkNewObject(data []byte, objType ObjectType) error {
+	checks := GetObjectCreationChecks()
+	if checks == 0 {
+		return nil
+	}
+	var targets []objectCheckTarget
+	var err error
+	switch objType {
+	case ObjectTree:
+		targets, err = checkTreeFormat(data)
+	case ObjectCommit:
+		targets, err = checkCommitFormat(data)
+	case ObjectTag:
+		targets, err = checkTagFormat(data)
+	}
+	if err == nil && checks&ObjectCreationCheckTargets != 0 {
+		err = o.checkTargets(targets)
+	}
+	if err != nil {
+		return MakeGitError(fmt.Sprintf("invalid %s: %s", objType.String(), err.Error()), ErrInvalid)
+	}
+	return nil
+}
+
+type objectCheckTarget struct {
+	id      *Oid
+	objType ObjectType
+}
+
+func (o *Odb) checkTargets(targets []objectCheckTarget) error {
+	for _, target := range targets {
+		objType, _, err := o.ReadHeader(target.id)
+		if err != nil {
+			return errors.New(fmt.Sprintf("%s %s does not exist", target.objType.String(), target.id.String()))
+		}
+		if objType != target.objType {
+			return errors.New(fmt.Sprintf("%s is a %s, not a %s", target.id.String(), objType.String(), target.objType.String()))
+		}
+	}
+	return nil
+}
+
+func checkTreeFormat(data []byte) ([]objectCheckTarget, error) {
+	var targets []objectCheckTarget
+	var previous string
+	var candidates []string
+	offset := 0
+	for offset < len(data) {
+		space := bytes.IndexByte(data[offset:], ' ')
+		if space == -1 {
+			return nil, errors.New(fmt.Sprintf("broken entry at %d", offset))
+		}
+		modeText := string(data[offset : offset+space])
+		mode, err := strconv.ParseUint(modeText, 8, 32)
+		if err != nil || modeText[0] == '0' || !validFilemode(Filemode(mode)) {
+			return nil, errors.New(fmt.Sprintf("bad filemode '%s'", modeText))
+		}
+		offset += space + 1
+		nul := bytes.IndexByte(data[offset:], 0)
+		if nul == -1 || offset+nul+1+GitOidRawSize > len(data) {
+			return nil, errors.New(fmt.Sprintf("broken entry at %d", offset))
+		}
+		name := string(data[offset : offset+nul])
+		if !isValidPathComponent(name, false) {
+			return nil, errors.New(fmt.Sprintf("bad name '%s'", name))
+		}
+		offset += nul + 1
+		id := NewOidFromBytes(data[offset : offset+GitOidRawSize])
+		offset += GitOidRawSize
+		if id.IsZero() {
+			return nil, errors.New(fmt.Sprintf("null id for '%s'", name))
+		}
+
+		// directories are sorted as if their names end with "/"
+		key := name
+		if Filemode(mode) == FilemodeTree {
+			key += "/"
+		}
+		if previous != "" {
+			previousName := previous
+			if previousName[len(previousName)-1] == '/' {
+				previousName = previousName[:len(previousName)-1]
+			}
+			if previousName == name {
+				return nil, errors.New(fmt.Sprintf("duplicate entry '%s'", name))
+			}
+			if previous > key {
+				return nil, errors.New(fmt.Sprintf("entries are not sorted at '%s'", name))
+			}
+		}
+		previous = key
+		// a tree with the name of a file comes after the names that start
+		// with the file name and a character before "/", like "a.c" after
+		// "a", so the files stay candidates while such names follow, like
+		// verify_ordered of git fsck
+		for len(candidates) > 0 {
+			top := candidates[len(candidates)-1]
+			if top == name {
+				return nil, errors.New(fmt.Sprintf("duplicate entry '%s'", name))
+			}
+			if strings.HasPrefix(name, top) && name[len(top)] < '/' {
+				break
+			}
+			candidates = candidates[:len(candidates)-1]
+		}
+		if Filemode(mode) != FilemodeTree {
+			candidates = append(candidates, name)
+		}
+
+		switch Filemode(mode) {
+		case FilemodeTree:
+			targets = append(targets, objectCheckTarget{id, ObjectTree})
+		case FilemodeCommit:
+		default:
+			targets = append(targets, objectCheckTarget{id, ObjectBlob})
+		}
+	}
+	return targets, nil
+}
+
+func checkCommitFormat(data []byte) ([]objectCheckTarget, error) {
+	var targets []objectCheckTarget
+	lines := objectHeaderLines(data)
+	if len(lines) == 0 || !bytes.HasPrefix(lines[0], []byte("tree ")) {
+		return nil, errors.New("missing tree")
+	}
+	tree, err := NewOid(string(lines[0][len("tree "):]))
+	if err != nil {
+		return nil, errors.New("bad tree id")
+	}
+	targets = append(targets, objectCheckTarget{tree, ObjectTree})
+	i := 1
+	for ; i < len(lines) && bytes.HasPrefix(lines[i], []byte("parent ")); i++ {
+		parent, err := NewOid(string(lines[i][len("parent "):]))
+		if err != nil {
+			return nil, errors.New("bad parent id")
+		}
+		targets = append(targets, objectCheckTarget{parent, ObjectCommit})
+	}
+	for _, field := range []string{"author ", "committer "} {
+		if i >= len(lines) || !bytes.HasPrefix(lines[i], []byte(field)) {
+			return nil, errors.New(fmt.Sprintf("missing %s", field[:len(field)-1]))
+		}
+		if err := checkIdent(lines[i][len(field):]); err != nil {
+			return nil, errors.New(fmt.Sprintf("bad %s: %s", field[:len(field)-1], err.Error()))
+		}
+		i++
+	}
+	return targets, nil
+}
+
+func checkTagFormat(data []byte) ([]objectCheckTarget, error) {
+	lines := objectHeaderLines(data)
+	if len(lines) < 3 || !bytes.HasPrefix(lines[0], []byte("object ")) {
+		return nil, errors.New("missing object")
+	}
+	target, err := NewOid(string(lines[0][len("object "):]))
+	if err != nil {
+		return nil, errors.New("bad object id")
+	}
+	if !bytes.HasPrefix(lines[1], []byte("type ")) {
+		return nil, errors.New("missing type")
+	}
+	targetType := TypeString2Type(string(lines[1][len("type "):]))
+	if targetType == ObjectBad {
+		return nil, errors.New(fmt.Sprintf("bad type '%s'", lines[1][len("type "):]))
+	}
+	if !bytes.HasPrefix(lines[2], []byte("tag ")) || len(lines[2]) == len("tag ") {
+		return nil, errors.New("missing tag name")
+	}
+	if len(lines) > 3 && bytes.HasPrefix(lines[3], []byte("tagger ")) {
+		if err := checkIdent(lines[3][len("tagger "):]); err != nil {
+			return nil, errors.New(fmt.Sprintf("bad tagger: %s", err.Error()))
+		}
+	}
+	return []objectCheckTarget{{target, targetType}}, nil
+}
+
+// objectHeaderLines returns the lines before the message, without the
+// newlines.
+func objectHeaderLines(data []byte) [][]byte {
+	var lines [][]byte
+	for offset := 0; offset < len(data); {
+		eol := bytes.IndexByte(data[offset:], '\n')
+		if eol <= 0 {
+			break
+		}
+		lines = append(lines, data[offset:offset+eol])
+		offset += eol + 1
+	}
+	return lines
+}
+
+// checkIdent checks "Name <email> 1234567890 +0000" like fsck does.
+func checkIdent(ident []byte) error {
+	emailStart := bytes.IndexAny(ident, "<>")
+	if emailStart == -1 || ident[emailStart] != '<' {
+		return errors.New("bad name")
+	}
+	if emailStart == 0 || ident[emailStart-1] != ' ' {
+		return errors.New("missing space before email")
+	}
+	emailEnd := bytes.IndexAny(ident[emailStart+1:], "<>")
+	if emailEnd == -1 || ident[emailStart+1+emailEnd] != '>' {
+		return errors.New("bad email")
+	}
+	rest := ident[emailStart+1+emailEnd+1:]
+	if len(rest) == 0 || rest[0] != ' ' {
+		return errors.New("missing space before date")
+	}
+	rest = rest[1:]
+	dateEnd := 0
+	for dateEnd < len(rest) && '0' <= rest[dateEnd] && rest[dateEnd] <= '9' {
+		dateEnd++
+	}
+	if dateEnd == 0 || (rest[0] == '0' && dateEnd > 1) {
+		return errors.New("bad date")
+	}
+	if _, err := strconv.ParseUint(string(rest[:dateEnd]), 10, 64); err != nil {
+		return errors.New("date overflow")
+	}
+	timezone := rest[dateEnd:]
+	if len(timezone) != 6 || timezone[0] != ' ' || (timezone[1] != '+' && timezone[1] != '-') {
+		return errors.New("bad timezone")
+	}
+	for _, c := range timezone[2:] {
+		if c < '0' || c > '9' {
+			return errors.New("bad timezone")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package git4go
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_ObjectCreationChecks(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_object_check")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	defer SetObjectCreationChecks(ObjectCreationCheckFormat)
+
+	blob, _ := repo.CreateBlobFromBuffer([]byte("a\n"))
+	builder, _ := repo.TreeBuilder()
+	builder.Insert("a", blob, Filemode(0100600))
+	if _, err := builder.Write(); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should reject a tree with a bad filemode:", err)
+	}
+
+	commit := writeMergeCommit(repo, map[string]string{"a.txt": "a\n"})
+	tree, _ := commit.Tree()
+	broken := &Signature{"A <a>", "a@example.com", time.Unix(1400000000, 0)}
+	if _, err := repo.CreateCommit("", broken, broken, "broken\n", tree); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should reject a commit with a bad author:", err)
+	}
+
+	SetObjectCreationChecks(0)
+	if _, err := repo.CreateCommit("", broken, broken, "broken\n", tree); err != nil {
+		t.Error("it should write the commit without the checks:", err)
+	}
+
+	SetObjectCreationChecks(ObjectCreationCheckFormat | ObjectCreationCheckTargets)
+	missing, _ := NewOid("1111111111111111111111111111111111111111")
+	builder, _ = repo.TreeBuilder()
+	builder.Insert("missing.txt", missing, FilemodeBlob)
+	if _, err := builder.Write(); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should reject a tree with a missing entry:", err)
+	}
+}
+
+func Test_TreeBuilder_Order(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_object_check")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+
+	blob, _ := repo.CreateBlobFromBuffer([]byte("a\n"))
+	builder, _ := repo.TreeBuilder()
+	builder.Insert("b", blob, FilemodeBlob)
+	subtree, _ := builder.Write()
+
+	builder, _ = repo.TreeBuilder()
+	builder.Insert("a", subtree, FilemodeTree)
+	builder.Insert("a.txt", blob, FilemodeBlob)
+	oid, err := builder.Write()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	tree, _ := repo.LookupTree(oid)
+	if tree.EntryByIndex(0).Name != "a.txt" || tree.EntryByIndex(1).Name != "a" {
+		t.Error("it should sort the directory as if its name ends with '/'")
+	}
+}
+
+func Test_CheckTreeFormat_Duplicates(t *testing.T) {
+	blob, _ := NewOid("78981922613b2afb6025042ff6bd878ac1994e85")
+	tree, _ := NewOid("4b825dc642cb6eb9a060e54bf8d69288fbee4904")
+	entry := func(mode, name string, id *Oid) []byte {
+		return append([]byte(mode+" "+name+"\x00"), id[:]...)
+	}
+	var data []byte
+	data = append(data, entry("100644", "a", blob)...)
+	data = append(data, entry("100644", "a.c", blob)...)
+	data = append(data, entry("40000", "a", tree)...)
+	if _, err := checkTreeFormat(data); err == nil {
+		t.Error("it should find a tree with the name of a file that is not next to it")
+	}
+
+	data = nil
+	data = append(data, entry("100644", "a", blob)...)
+	data = append(data, entry("100644", "a.c", blob)...)
+	data = append(data, entry("40000", "b", tree)...)
+	if _, err := checkTreeFormat(data); err != nil {
+		t.Error("err should be nil:", err)
+	}
+}
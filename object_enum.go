@@ -0,0 +1,285 @@
+package git4go
+
+// EnumeratedObject is an object that Repository.EnumerateObjects found.
+type EnumeratedObject struct {
+	Id   *Oid
+	Type ObjectType
+}
+
+// EnumerateObjects returns the objects that are reachable from the tips but
+// not from the haves, like "git rev-list --objects tips --not haves". This
+// is the set of objects that push, bundles and upload-pack send. Commits
+// come first, then tags, trees and blobs. Haves that are not in the object
+// database are ignored.
+//
+// If every tip and have is a commit with a bitmap in a pack (.bitmap), the
+// result is computed from the bitmaps without walking. Otherwise commits
+// are walked down to the haves, and the trees of the haves and of the
+// commits on the boundary are marked as known before the trees of the new
+// commits are walked.
+func (r *Repository) EnumerateObjects(tips, haves []*Oid) ([]*EnumeratedObject, error) {
+	odb, err := r.Odb()
+	if err != nil {
+		return nil, err
+	}
+	var knownHaves []*Oid
+	for _, have := range haves {
+		if odb.Exists(have) {
+			knownHaves = append(knownHaves, have)
+		}
+	}
+	if !r.IsShallow() {
+		result, err := r.enumerateObjectsWithBitmap(odb, tips, knownHaves)
+		if result != nil || err != nil {
+			return result, err
+		}
+	}
+	return r.enumerateObjectsWithWalk(tips, knownHaves)
+}
+
+// internal functions and methods
+
+// enumerateObjectsWithBitmap returns nil if no bitmap file has all tips and
+// haves.
+func (r *Repository) enumerateObjectsWithBitmap(odb *Odb, tips, haves []*Oid) ([]*EnumeratedObject, error) {
+	indexes, err := odb.packBitmapIndexes()
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range indexes {
+		wants, ok, err := index.union(tips)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		known, ok, err := index.union(haves)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		bitmap := wants.andNot(known)
+		var lists [3][]*EnumeratedObject
+		for position, id := range index.ids {
+			if !bitmap.get(position) {
+				continue
+			}
+			objType := index.objectType(position)
+			var list int
+			switch objType {
+			case ObjectCommit:
+				list = 0
+			case ObjectTag:
+				list = 1
+			case ObjectTree, ObjectBlob:
+				list = 2
+			default:
+				continue
+			}
+			lists[list] = append(lists[list], &EnumeratedObject{Id: id, Type: objType})
+		}
+		result := append(lists[0], lists[1]...)
+		return append(result, lists[2]...), nil
+	}
+	return nil, nil
+}
+
+// union returns the bitmap of the objects that are reachable from the
+// commits, or false if a commit has no bitmap.
+func (b *packBitmapIndex) union(commits []*Oid) (packBitmap, bool, error) {
+	var result packBitmap
+	for _, commit := range commits {
+		bitmap, ok, err := b.lookup(commit)
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		result = result.or(bitmap)
+	}
+	return result, true, nil
+}
+
+func (r *Repository) enumerateObjectsWithWalk(tips, haves []*Oid) ([]*EnumeratedObject, error) {
+	seen := make(map[Oid]bool)
+	var tipCommits, tipTrees, haveCommits []*Oid
+	var tags, blobs []*EnumeratedObject
+
+	// tags are peeled to the objects that they point to
+	for _, have := range haves {
+		for have != nil {
+			obj, err := r.Lookup(have)
+			if err != nil {
+				return nil, err
+			}
+			have = nil
+			switch obj := obj.(type) {
+			case *Tag:
+				seen[*obj.Id()] = true
+				have = obj.TargetId()
+			case *Commit:
+				haveCommits = append(haveCommits, obj.Id())
+			case *Tree:
+				if err := r.markTreeSeen(obj.Id(), seen); err != nil {
+					return nil, err
+				}
+			default:
+				seen[*obj.Id()] = true
+			}
+		}
+	}
+	for _, tip := range tips {
+		for tip != nil {
+			obj, err := r.Lookup(tip)
+			if err != nil {
+				return nil, err
+			}
+			tip = nil
+			switch obj := obj.(type) {
+			case *Tag:
+				if !seen[*obj.Id()] {
+					seen[*obj.Id()] = true
+					tags = append(tags, &EnumeratedObject{Id: obj.Id(), Type: ObjectTag})
+					tip = obj.TargetId()
+				}
+			case *Commit:
+				tipCommits = append(tipCommits, obj.Id())
+			case *Tree:
+				tipTrees = append(tipTrees, obj.Id())
+			default:
+				if !seen[*obj.Id()] {
+					seen[*obj.Id()] = true
+					blobs = append(blobs, &EnumeratedObject{Id: obj.Id(), Type: obj.Type()})
+				}
+			}
+		}
+	}
+
+	var commits []*Commit
+	if len(tipCommits) > 0 {
+		walk, err := r.Walk()
+		if err != nil {
+			return nil, err
+		}
+		walk.Sorting(SortTime)
+		for _, commit := range tipCommits {
+			if err := walk.Push(commit); err != nil {
+				return nil, err
+			}
+		}
+		for _, commit := range haveCommits {
+			if err := walk.Hide(commit); err != nil {
+				return nil, err
+			}
+		}
+		err = walk.Iterate(func(commit *Commit) bool {
+			commits = append(commits, commit)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the other side has the trees of the haves and of the parents that
+	// are not sent
+	interesting := make(map[Oid]bool)
+	for _, commit := range commits {
+		interesting[*commit.Id()] = true
+	}
+	known := haveCommits
+	for _, commit := range commits {
+		if r.isShallowRoot(commit.Id()) {
+			continue
+		}
+		for _, parent := range commit.Parents {
+			if !interesting[*parent] {
+				known = append(known, parent)
+			}
+		}
+	}
+	for _, id := range known {
+		commit, err := r.LookupCommit(id)
+		if IsErrorCode(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if err := r.markTreeSeen(commit.TreeId(), seen); err != nil {
+			return nil, err
+		}
+	}
+
+	var result []*EnumeratedObject
+	for _, commit := range commits {
+		seen[*commit.Id()] = true
+		result = append(result, &EnumeratedObject{Id: commit.Id(), Type: ObjectCommit})
+	}
+	result = append(result, tags...)
+	var err error
+	for _, commit := range commits {
+		result, err = r.appendTreeObjects(result, commit.TreeId(), seen)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, tree := range tipTrees {
+		result, err = r.appendTreeObjects(result, tree, seen)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(result, blobs...), nil
+}
+
+// markTreeSeen marks the tree and all trees and blobs in it as seen.
+// Subtrees that are already seen are not read again.
+func (r *Repository) markTreeSeen(id *Oid, seen map[Oid]bool) error {
+	if seen[*id] {
+		return nil
+	}
+	seen[*id] = true
+	tree, err := r.LookupTree(id)
+	if err != nil {
+		return err
+	}
+	for _, entry := range tree.Entries {
+		switch entry.Type {
+		case ObjectTree:
+			if err := r.markTreeSeen(entry.Id, seen); err != nil {
+				return err
+			}
+		case ObjectBlob:
+			seen[*entry.Id] = true
+		}
+	}
+	return nil
+}
+
+// appendTreeObjects appends the tree and the trees and blobs in it that
+// are not seen yet. Submodule commits are skipped.
+func (r *Repository) appendTreeObjects(result []*EnumeratedObject, id *Oid, seen map[Oid]bool) ([]*EnumeratedObject, error) {
+	if seen[*id] {
+		return result, nil
+	}
+	seen[*id] = true
+	result = append(result, &EnumeratedObject{Id: id, Type: ObjectTree})
+	tree, err := r.LookupTree(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range tree.Entries {
+		switch entry.Type {
+		case ObjectTree:
+			result, err = r.appendTreeObjects(result, entry.Id, seen)
+			if err != nil {
+				return nil, err
+			}
+		case ObjectBlob:
+			if !seen[*entry.Id] {
+				seen[*entry.Id] = true
+				result = append(result, &EnumeratedObject{Id: entry.Id, Type: ObjectBlob})
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,147 @@
+package git4go
+
+import (
+	"./testutil"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func enumeratedIds(objects []*EnumeratedObject) string {
+	var ids []string
+	for _, object := range objects {
+		ids = append(ids, object.Id.String())
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, " ")
+}
+
+func Test_EnumerateObjects(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/bitmap.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/bitmap.git")
+	master, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	third, _ := NewOid("4a202b346bb0fb0db7eff3cffeb3c70babbd2045")
+	unknown, _ := NewOid("0123456789012345678901234567890123456789")
+	// git rev-list --objects master ^4a202b3
+	expected := "1810dff58d8a660512d4832e740f692884338ccd 3697d64be941a53d4ae8f6a271e4e3fa56b022cc " +
+		"45b983be36b73c0788dc9cbcb76cbb80fc7bb057 75057dd4114e74cca1d750d0aee1647c903cb60a " +
+		"814889a078c031f61ed08ab5fa863aea9314344d 944c0f6e4dfa41595e6eb3ceecdb14f50fe18162 " +
+		"9fd738e8f7967c078dceed8190330fc8648ee56a a65fedf39aefe402d3bb6e24df4d4f5fe4547750 " +
+		"a71586c1dfe8a71c6cbf6c129f404c5642ff31bd be3563ae3f795b2b4353bcce3a527ad0a4f7f644 " +
+		"c47800c7266a2be04c571c04d5a6614691ea99bd"
+
+	objects, err := repo.EnumerateObjects([]*Oid{master}, []*Oid{third, unknown})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if actual := enumeratedIds(objects); actual != expected {
+		t.Error("wrong objects:", actual)
+	}
+	if objects[0].Type != ObjectCommit || objects[len(objects)-1].Type == ObjectCommit {
+		t.Error("commits should come first")
+	}
+
+	// the walk finds the same objects as the bitmaps
+	objects, err = repo.enumerateObjectsWithWalk([]*Oid{master}, []*Oid{third})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if actual := enumeratedIds(objects); actual != expected {
+		t.Error("wrong objects of walk:", actual)
+	}
+	for _, object := range objects {
+		header, _, _ := repo.odb.ReadHeader(object.Id)
+		if header != object.Type {
+			t.Error("wrong type:", object.Id.String(), object.Type)
+		}
+	}
+}
+
+func Test_EnumerateObjectsWithTag(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/bitmap.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/bitmap.git")
+	tag, _ := NewOid("7b4384978d2493e851f9cca7858815fac9b10980")
+	base, _ := NewOid("5b5b025afb0b4c913b4c338a42934a3863bf3644")
+	objects, err := repo.EnumerateObjects([]*Oid{tag}, []*Oid{base})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	// git rev-list --objects e90810b ^5b5b025
+	expected := "0266163a49e280c4f5ed1e08facd36a2bd716bcf 53fc32d17276939fc79ed05badaef2db09990016 " +
+		"6336846bd5c88d32f93ae57d846683e61ab5c530 6dcf9bf7541ee10456529833502442f385010c3d " +
+		"7b4384978d2493e851f9cca7858815fac9b10980 bed08a0b30b72a9d4aed7f1af8c8ca124e8d64b9 " +
+		"e90810b8df3e80c413d903f631643c716887138d"
+	if actual := enumeratedIds(objects); actual != expected {
+		t.Error("wrong objects:", actual)
+	}
+
+	objects, _ = repo.EnumerateObjects([]*Oid{base}, []*Oid{base})
+	if len(objects) != 0 {
+		t.Error("it should be empty if the tips are known:", len(objects))
+	}
+}
+
+func Test_PackBitmapIndex_Lazy(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/bitmap.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/bitmap.git")
+	odb, _ := repo.Odb()
+	if _, err := odb.packBitmapIndexes(); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	// the packs are shared by the tests, so the file is read again
+	var index *packBitmapIndex
+	for _, backend := range odb.backendList() {
+		if packed, ok := backend.(*OdbBackendPacked); ok && len(packed.packs) == 1 {
+			index, _ = readPackBitmapIndex(packed.packs[0])
+		}
+	}
+	if index == nil {
+		t.Fatal("it should read the bitmap file")
+	}
+	for _, entry := range index.entries {
+		if entry.bitmap != nil {
+			t.Fatal("it should not decode the bitmaps of the commits at open")
+		}
+	}
+	master, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	bitmap, ok, err := index.lookup(master)
+	if err != nil || !ok {
+		t.Fatal("it should find the bitmap of master:", err)
+	}
+	if entry := index.entries[index.positions[*master]]; entry.bitmap == nil {
+		t.Error("it should keep the decoded bitmap")
+	}
+	count := 0
+	for position := range index.ids {
+		if bitmap.get(position) {
+			count++
+		}
+	}
+	if count == 0 || !bitmap.get(indexOfOid(index.ids, master)) {
+		t.Error("it should have the bits of the reachable objects:", count)
+	}
+	decoded := 0
+	for _, entry := range index.entries {
+		if entry.bitmap != nil {
+			decoded++
+		}
+	}
+	if decoded == len(index.entries) && len(index.entries) > 1 {
+		t.Error("it should decode only the chain of the commit")
+	}
+}
+
+func indexOfOid(ids []*Oid, oid *Oid) int {
+	for i, id := range ids {
+		if id.Equal(oid) {
+			return i
+		}
+	}
+	return -1
+}
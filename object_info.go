@@ -0,0 +1,226 @@
+package git4go
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// the size of the objects, which git servers support
+	ObjectInfoSize = "size"
+	// the type of the objects, which git4go servers support too
+	ObjectInfoType = "type"
+)
+
+// ObjectInfoRequest is the request of the protocol v2 object-info command:
+// the attributes to return for the objects, without downloading them.
+type ObjectInfoRequest struct {
+	Attributes []string
+	Ids        []*Oid
+}
+
+// ObjectInfo is the information about an object in an object-info
+// response. Only the requested attributes are set.
+type ObjectInfo struct {
+	Id *Oid
+	// false if the server does not have the object
+	Found bool
+	Size  uint64
+	Type  ObjectType
+}
+
+// ObjectInfoTransport is a Transport that supports the object-info command
+// of protocol v2.
+type ObjectInfoTransport interface {
+	Transport
+	// ObjectInfoAttributes returns the attributes of the object-info
+	// capability, e.g. "size", an empty slice if the capability has no
+	// value, or nil if the server does not advertise it.
+	ObjectInfoAttributes() []string
+	// ObjectInfo sends the lines of the request and returns the lines of
+	// the response.
+	ObjectInfo(request []string) ([]string, error)
+}
+
+// AdvertiseObjectInfo tells if transfer.advertiseObjectInfo makes servers
+// advertise the object-info capability. It is false by default, like in
+// git.
+func (r *Repository) AdvertiseObjectInfo() bool {
+	if config := r.Config(); config != nil {
+		if value, err := config.LookupBool("transfer.advertiseObjectInfo"); err == nil {
+			return value
+		}
+	}
+	return false
+}
+
+// ObjectInfoCapability returns the value of the object-info capability that
+// servers advertise: the attributes that they support.
+func ObjectInfoCapability() string {
+	return "object-info=" + ObjectInfoSize + " " + ObjectInfoType
+}
+
+// ParseObjectInfoRequest parses the lines of an object-info request: the
+// attributes and "oid <id>" lines.
+func ParseObjectInfoRequest(lines []string) (*ObjectInfoRequest, error) {
+	request := &ObjectInfoRequest{}
+	for _, line := range lines {
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == ObjectInfoSize || line == ObjectInfoType:
+			request.Attributes = append(request.Attributes, line)
+		case strings.HasPrefix(line, "oid "):
+			oid, err := NewOid(line[len("oid "):])
+			if err != nil {
+				return nil, MakeGitError(fmt.Sprintf("object-info: protocol error, expected to get oid, not '%s'", line), ErrInvalid)
+			}
+			request.Ids = append(request.Ids, oid)
+		default:
+			return nil, MakeGitError(fmt.Sprintf("object-info: unexpected line '%s'", line), ErrInvalid)
+		}
+	}
+	return request, nil
+}
+
+// Lines returns the lines of the request that a client sends.
+func (request *ObjectInfoRequest) Lines() []string {
+	var lines []string
+	lines = append(lines, request.Attributes...)
+	for _, oid := range request.Ids {
+		lines = append(lines, "oid "+oid.String())
+	}
+	return lines
+}
+
+// ObjectInfo answers an object-info request like a git server: the first
+// line lists the attributes, then each object follows with their values,
+// which are empty if the object does not exist. The objects are not read,
+// only their headers.
+func (r *Repository) ObjectInfo(request *ObjectInfoRequest) ([]string, error) {
+	odb, err := r.Odb()
+	if err != nil {
+		return nil, err
+	}
+	size, objType := request.has(ObjectInfoSize), request.has(ObjectInfoType)
+	var attributes []string
+	if size {
+		attributes = append(attributes, ObjectInfoSize)
+	}
+	if objType {
+		attributes = append(attributes, ObjectInfoType)
+	}
+	lines := []string{strings.Join(attributes, " ")}
+	for _, oid := range request.Ids {
+		line := oid.String()
+		headerType, headerSize, err := odb.ReadHeader(oid)
+		found := err == nil
+		if size {
+			line += " "
+			if found {
+				line += strconv.FormatUint(headerSize, 10)
+			}
+		}
+		if objType {
+			line += " "
+			if found {
+				line += headerType.String()
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// ParseObjectInfoResponse parses the lines of an object-info response.
+func ParseObjectInfoResponse(lines []string) ([]*ObjectInfo, error) {
+	if len(lines) == 0 {
+		return nil, MakeGitError("object-info: empty response", ErrInvalid)
+	}
+	attributes := strings.Fields(lines[0])
+	var infos []*ObjectInfo
+	for _, line := range lines[1:] {
+		line = strings.TrimSuffix(line, "\n")
+		fields := strings.Split(line, " ")
+		if len(fields) != len(attributes)+1 {
+			return nil, MakeGitError(fmt.Sprintf("object-info: malformed line '%s'", line), ErrInvalid)
+		}
+		oid, err := NewOid(fields[0])
+		if err != nil {
+			return nil, MakeGitError(fmt.Sprintf("object-info: malformed line '%s'", line), ErrInvalid)
+		}
+		info := &ObjectInfo{Id: oid, Found: true}
+		for i, attribute := range attributes {
+			value := fields[i+1]
+			if value == "" {
+				info.Found = false
+				continue
+			}
+			switch attribute {
+			case ObjectInfoSize:
+				if info.Size, err = strconv.ParseUint(value, 10, 64); err != nil {
+					return nil, MakeGitError(fmt.Sprintf("object-info: malformed size '%s'", value), ErrInvalid)
+				}
+			case ObjectInfoType:
+				if info.Type = TypeString2Type(value); info.Type == ObjectBad {
+					return nil, MakeGitError(fmt.Sprintf("object-info: malformed type '%s'", value), ErrInvalid)
+				}
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// ObjectInfo asks the connected remote for the attributes of the objects
+// with the object-info command, e.g. to show the sizes of large files
+// without downloading them. Attributes that the server does not advertise
+// are not requested; the "size" of servers that advertise object-info
+// without a value is. It fails with ErrInvalid if the transport or the
+// server does not support the command.
+func (r *Remote) ObjectInfo(ids []*Oid, attributes ...string) ([]*ObjectInfo, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !r.connected {
+		return nil, MakeGitError(fmt.Sprintf("remote '%s' is not connected", r.name), ErrInvalid)
+	}
+	transport, ok := r.transport.(ObjectInfoTransport)
+	if !ok {
+		return nil, MakeGitError("the transport does not support object-info", ErrInvalid)
+	}
+	supported := transport.ObjectInfoAttributes()
+	if supported == nil {
+		return nil, MakeGitError(fmt.Sprintf("remote '%s' does not support object-info", r.name), ErrInvalid)
+	}
+	if len(supported) == 0 {
+		supported = []string{ObjectInfoSize}
+	}
+	request := &ObjectInfoRequest{Ids: ids}
+	for _, attribute := range attributes {
+		for _, candidate := range supported {
+			if attribute == candidate {
+				request.Attributes = append(request.Attributes, attribute)
+			}
+		}
+	}
+	if len(request.Attributes) == 0 {
+		return nil, MakeGitError(fmt.Sprintf("remote '%s' supports none of the object-info attributes", r.name), ErrInvalid)
+	}
+	lines, err := transport.ObjectInfo(request.Lines())
+	if err != nil {
+		return nil, err
+	}
+	return ParseObjectInfoResponse(lines)
+}
+
+// internal functions and methods
+
+func (request *ObjectInfoRequest) has(attribute string) bool {
+	for _, candidate := range request.Attributes {
+		if candidate == attribute {
+			return true
+		}
+	}
+	return false
+}
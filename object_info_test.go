@@ -0,0 +1,81 @@
+package git4go
+
+import (
+	"./testutil"
+	"testing"
+)
+
+// objectInfoTransport answers object-info requests with the repository.
+type objectInfoTransport struct {
+	testTransport
+	server     *Repository
+	attributes []string
+	requests   [][]string
+}
+
+func (t *objectInfoTransport) ObjectInfoAttributes() []string {
+	return t.attributes
+}
+
+func (t *objectInfoTransport) ObjectInfo(lines []string) ([]string, error) {
+	t.requests = append(t.requests, lines)
+	request, err := ParseObjectInfoRequest(lines)
+	if err != nil {
+		return nil, err
+	}
+	return t.server.ObjectInfo(request)
+}
+
+func Test_ObjectInfo(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	blob, _ := NewOid("001d938dbe69b6251f4a03cf374235c72fd0a0d2")
+	commit, _ := NewOid("007e075337848055a92e218bdfe137451a4c9635")
+	missing, _ := NewOid("1111111111111111111111111111111111111111")
+
+	lines, err := repo.ObjectInfo(&ObjectInfoRequest{Attributes: []string{ObjectInfoSize}, Ids: []*Oid{blob, missing}})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if len(lines) != 3 || lines[0] != "size" || lines[1] != blob.String()+" 3628" || lines[2] != missing.String()+" " {
+		t.Error("it should answer like git:", lines)
+	}
+	if _, err = ParseObjectInfoRequest([]string{"size", "oid xyz"}); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should reject invalid ids:", err)
+	}
+	if _, err = ParseObjectInfoRequest([]string{"mtime"}); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should reject unknown lines:", err)
+	}
+
+	transport := &objectInfoTransport{server: repo}
+	RegisterTransport("git://", func(remote *Remote) (Transport, error) {
+		return transport, nil
+	})
+	defer UnregisterTransport("git://")
+	remote, _ := repo.LookupRemote("test_with_pushurl")
+	remote.Connect(ConnectDirectionFetch)
+	defer remote.Disconnect()
+
+	if _, err = remote.ObjectInfo([]*Oid{blob}, ObjectInfoSize); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should fail if the server does not advertise object-info:", err)
+	}
+	transport.attributes = []string{}
+	infos, err := remote.ObjectInfo([]*Oid{blob, commit, missing}, ObjectInfoSize, ObjectInfoType)
+	if err != nil || len(infos) != 3 {
+		t.Fatal("it should ask for the sizes:", infos, err)
+	}
+	if request := transport.requests[0]; len(request) != 4 || request[0] != "size" {
+		t.Error("it should only ask for the size without the attributes of the capability:", request)
+	}
+	if !infos[0].Found || infos[0].Size != 3628 || infos[1].Size != 534 || infos[2].Found {
+		t.Error("it should parse the sizes:", infos[0], infos[1], infos[2])
+	}
+
+	transport.attributes = []string{ObjectInfoSize, ObjectInfoType}
+	infos, err = remote.ObjectInfo([]*Oid{blob, commit, missing}, ObjectInfoSize, ObjectInfoType)
+	if err != nil || infos[0].Type != ObjectBlob || infos[1].Type != ObjectCommit || infos[1].Size != 534 || infos[2].Found {
+		t.Error("it should parse the types:", infos, err)
+	}
+}
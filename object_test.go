@@ -198,3 +198,49 @@ func Test_Peel_Any(t *testing.T) {
 	assertPeel("e90810b8df3e80c413d903f631643c716887138d", ObjectAny,
 		"53fc32d17276939fc79ed05badaef2db09990016", ObjectTree, repo, t)
 }
+
+func Test_ObjectShortId(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	oid, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	obj, err := repo.Lookup(oid)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	shortId, err := obj.ShortId()
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if shortId != "a65fedf" {
+		t.Error("short id should be 7 digits:", shortId)
+	}
+}
+
+func Test_Repository_AbbrevLength(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	oid, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	if length, err := repo.AbbrevLength(); err != nil || length != 7 {
+		t.Error("it should use 7 digits for a small repository:", length, err)
+	}
+	repo.Config().SetString("core.abbrev", "10")
+	if shortId, _ := repo.ShortId(oid); shortId != "a65fedf39a" {
+		t.Error("it should use core.abbrev:", shortId)
+	}
+	repo.Config().SetString("core.abbrev", "no")
+	if shortId, _ := repo.ShortId(oid); shortId != oid.String() {
+		t.Error("it should not abbreviate with core.abbrev=no:", shortId)
+	}
+	repo.Config().SetString("core.abbrev", "2")
+	if _, err := repo.AbbrevLength(); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should reject a too short core.abbrev:", err)
+	}
+
+	if autoAbbrevLength(1<<23) != 12 || autoAbbrevLength(1<<16) != 9 || autoAbbrevLength(100) != 7 {
+		t.Error("it should scale the length with the number of objects")
+	}
+}
@@ -1,12 +1,14 @@
 package git4go
 
 import (
-	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
+	"sync"
+	"time"
 )
 
 const (
@@ -17,54 +19,128 @@ const (
 )
 
 func (r *Repository) Odb() (odb *Odb, err error) {
+	r.odbLock.Lock()
+	defer r.odbLock.Unlock()
+
 	if r.odb == nil {
-		odb, err := OdbOpen(filepath.Join(r.pathRepository, GitObjectsDir))
+		odb, err := openOdb(r.fs, filepath.Join(r.pathCommon, GitObjectsDir))
 		if err != nil {
 			return nil, err
 		}
+		odb.readOnly = r.readOnly
+		odb.addListener(r, r.notify)
 		r.odb = odb
 	}
 	return r.odb, nil
 }
 
+// SetOdb replaces the object database of the repository, like
+// git_repository_set_odb of libgit2. An object database can be shared by
+// many repositories, e.g. the handles of a server that opens the same
+// repository for each request, and the subscribers of each of them are
+// notified of its changes. The database is used as it is, so the read-only
+// mode of the repository does not apply to it.
+func (r *Repository) SetOdb(odb *Odb) {
+	r.odbLock.Lock()
+	defer r.odbLock.Unlock()
+
+	if r.odb == odb {
+		return
+	}
+	if r.odb != nil {
+		r.odb.removeListener(r)
+	}
+	odb.addListener(r, r.notify)
+	r.odb = odb
+}
+
 // Odb type and its methods
 
 type Odb struct {
-	backends []OdbBackend
+	lock       sync.RWMutex
+	fs         FileSystem
+	objectsDir string
+	backends   []OdbBackend
+	readOnly   bool
+	flights    flightGroup
+
+	promisedObject OdbPromisedObjectCallback
+	replaceObject  OdbReplaceCallback
+	notify         func(event *RepositoryEvent)
+	// the repositories that use the object database, with their notify
+	listenersLock sync.Mutex
+	listeners     map[*Repository]func(event *RepositoryEvent)
+}
+
+// NewOdb creates an object database without any backends. Backends are
+// added with AddBackend.
+func NewOdb() *Odb {
+	return &Odb{}
 }
 
 func OdbOpen(objectsDir string) (*Odb, error) {
-	odb := &Odb{}
+	return openOdb(OSFileSystem, objectsDir)
+}
+
+func openOdb(fsys FileSystem, objectsDir string) (*Odb, error) {
+	odb := &Odb{fs: fsys, objectsDir: objectsDir}
 	err := odb.AddDefaultBackends(objectsDir, false, 0)
 	return odb, err
 }
 
+// AddDefaultBackends adds the loose and packed backends of the directory.
+// Packfiles are memory mapped, so the packed backend is added only when the
+// Odb uses OSFileSystem.
 func (o *Odb) AddDefaultBackends(objectsDir string, asAlternates bool, alternateDepth int) error {
-	info, err := os.Stat(objectsDir)
+	return o.addDefaultBackends(objectsDir, asAlternates, alternateDepth, nil)
+}
+
+// addDefaultBackends adds the backends of the directory and its alternates.
+// chain is the directories whose alternates led to this one; finding the
+// directory in it means that the alternates form a cycle.
+func (o *Odb) addDefaultBackends(objectsDir string, asAlternates bool, alternateDepth int, chain []os.FileInfo) error {
+	fsys := fileSystemOrDefault(o.fs)
+	info, err := fsys.Stat(objectsDir)
 	if err != nil {
 		return errors.New(fmt.Sprintf("Failed to load object database in '%s'", objectsDir))
 	}
-	for _, backend := range o.backends {
+	for _, parent := range chain {
+		if os.SameFile(parent, info) {
+			return MakeGitError(fmt.Sprintf("alternate object store '%s' forms a cycle", objectsDir), ErrInvalid)
+		}
+	}
+	for _, backend := range o.backendList() {
 		if backend.SameDirectory(info) {
 			return nil
 		}
 	}
 	loose := NewOdbBackendLoose(objectsDir, -1, false, 0, 0)
+	loose.fs = fsys
 	o.addBackendInternal(loose, GitLoosePriority, asAlternates, info)
-	packed := NewOdbBackendPacked(objectsDir)
-	if packed != nil {
-		o.addBackendInternal(packed, GitPackedPriority, asAlternates, info)
+	if fsys == OSFileSystem {
+		packed := NewOdbBackendPacked(objectsDir)
+		if packed != nil {
+			o.addBackendInternal(packed, GitPackedPriority, asAlternates, info)
+		}
 	}
-	o.loadAlternates(objectsDir, alternateDepth)
+	o.loadAlternates(objectsDir, alternateDepth, append(chain, info))
 	return nil
 }
 
+func (o *Odb) AddBackend(backend OdbBackend, priority int) {
+	o.addBackendInternal(backend, priority, false, nil)
+}
+
+func (o *Odb) AddAlternate(backend OdbBackend, priority int) {
+	o.addBackendInternal(backend, priority, true, nil)
+}
+
 func (v *Odb) Hash(data []byte, objType ObjectType) (*Oid, error) {
 	return hash(data, objType)
 }
 
 func (o *Odb) Exists(oid *Oid) bool {
-	for _, backend := range o.backends {
+	for _, backend := range o.backendList() {
 		if backend.Exists(oid) {
 			return true
 		}
@@ -75,7 +151,7 @@ func (o *Odb) Exists(oid *Oid) bool {
 func (o *Odb) ExistsPrefix(oid *Oid, length int) (*Oid, error) {
 	var foundId *Oid
 	var err error
-	for _, backend := range o.backends {
+	for _, backend := range o.backendList() {
 		foundId, err = backend.ExistsPrefix(oid, length)
 		if foundId != nil {
 			return foundId, nil
@@ -84,15 +160,91 @@ func (o *Odb) ExistsPrefix(oid *Oid, length int) (*Oid, error) {
 	return nil, err
 }
 
+// Read reads the object. Concurrent reads of the same object are coalesced,
+// so the backends inflate it only once.
 func (o *Odb) Read(oid *Oid) (*OdbObject, error) {
-	for _, backend := range o.backends {
+	oid, err := o.replacement(oid)
+	if err != nil {
+		return nil, err
+	}
+	return o.readNoReplace(oid)
+}
+
+// readNoReplace reads the object itself even if it is replaced.
+func (o *Odb) readNoReplace(oid *Oid) (*OdbObject, error) {
+	value, err, shared := o.flights.do(flightKey{oid: *oid}, func() (interface{}, error) {
+		obj, err := o.read(oid)
+		if o.fetchPromised(oid, err) {
+			obj, err = o.read(oid)
+		}
+		return obj, err
+	}, func(value interface{}) interface{} {
+		// the caller that ran the read may release its object at any time
+		return copyOdbObject(value.(*OdbObject))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if shared {
+		addCounter(MetricObjectReadsCoalesced, 1)
+		return copyOdbObject(value.(*OdbObject)), nil
+	}
+	return value.(*OdbObject), nil
+}
+
+func (o *Odb) read(oid *Oid) (*OdbObject, error) {
+	var start time.Time
+	tracing := traceEnabled(TraceDebug)
+	metrics := getMetrics()
+	if tracing || metrics != nil {
+		start = time.Now()
+	}
+	var corruptErr error
+	for _, backend := range o.backendList() {
 		odbObject, err := backend.Read(oid)
+		if IsErrorCode(err, ErrObjectCorrupt) && corruptErr == nil {
+			corruptErr = err
+		}
 		if err == nil {
+			if metrics != nil {
+				metrics.AddCounter(MetricObjectsRead, 1)
+				metrics.ObserveDuration(MetricObjectReadTime, time.Since(start))
+			}
+			if tracing {
+				trace(TraceDebug, TraceCategoryOdb, "read object", time.Since(start), map[string]interface{}{
+					"id":   oid.String(),
+					"type": odbObject.Type.String(),
+					"size": len(odbObject.Data),
+				})
+			}
 			return odbObject, nil
 		}
 	}
 
-	return nil, errors.New(fmt.Sprintf("no match for id: %s", oid.String()))
+	if metrics != nil {
+		metrics.AddCounter(MetricObjectsNotFound, 1)
+	}
+	if tracing {
+		trace(TraceDebug, TraceCategoryOdb, "object not found", time.Since(start), map[string]interface{}{
+			"id": oid.String(),
+		})
+	}
+	if corruptErr != nil {
+		return nil, corruptErr
+	}
+	return nil, MakeGitError(fmt.Sprintf("no match for id: %s", oid.String()), ErrNotFound)
+}
+
+// WithData reads the object and passes its content to the callback without
+// copying it. The data is only valid during the callback and its buffer is
+// reused afterwards, so it must not be retained.
+func (o *Odb) WithData(oid *Oid, callback func(objType ObjectType, data []byte) error) error {
+	obj, err := o.Read(oid)
+	if err != nil {
+		return err
+	}
+	defer obj.Release()
+	return callback(obj.Type, obj.Data)
 }
 
 func (o *Odb) ReadPrefix(oid *Oid, length int) (*Oid, *OdbObject, error) {
@@ -100,34 +252,104 @@ func (o *Odb) ReadPrefix(oid *Oid, length int) (*Oid, *OdbObject, error) {
 	var foundObject *OdbObject
 	var err error
 
-	for _, backend := range o.backends {
+	var corruptErr error
+
+	for _, backend := range o.backendList() {
 		foundId, foundObject, err = backend.ReadPrefix(oid, length)
 		if err == nil {
-			return foundId, foundObject, nil
+			replaced, err := o.replacement(foundId)
+			if err != nil || replaced == foundId {
+				return foundId, foundObject, err
+			}
+			foundObject.Release()
+			foundObject, err = o.readNoReplace(replaced)
+			return foundId, foundObject, err
 		}
+		if IsErrorCode(err, ErrObjectCorrupt) && corruptErr == nil {
+			corruptErr = err
+		}
+	}
+
+	if corruptErr != nil {
+		return nil, nil, corruptErr
 	}
+	return nil, nil, MakeGitError(fmt.Sprintf("no match for id: %s", oid.String()), ErrNotFound)
+}
 
-	return nil, nil, errors.New(fmt.Sprintf("no match for id: %s", oid.String()))
+type odbHeader struct {
+	objType ObjectType
+	size    uint64
 }
 
 func (o *Odb) ReadHeader(oid *Oid) (ObjectType, uint64, error) {
-	for _, backend := range o.backends {
+	oid, err := o.replacement(oid)
+	if err != nil {
+		return ObjectBad, 0, err
+	}
+	return o.readHeaderNoReplace(oid)
+}
+
+func (o *Odb) readHeaderNoReplace(oid *Oid) (ObjectType, uint64, error) {
+	value, err, shared := o.flights.do(flightKey{oid: *oid, header: true}, func() (interface{}, error) {
+		objType, size, err := o.readHeader(oid)
+		if o.fetchPromised(oid, err) {
+			objType, size, err = o.readHeader(oid)
+		}
+		return odbHeader{objType, size}, err
+	}, func(value interface{}) interface{} {
+		return value
+	})
+	if err != nil {
+		return ObjectBad, 0, err
+	}
+	if shared {
+		addCounter(MetricObjectReadsCoalesced, 1)
+	}
+	header := value.(odbHeader)
+	return header.objType, header.size, nil
+}
+
+func (o *Odb) readHeader(oid *Oid) (ObjectType, uint64, error) {
+	var corruptErr error
+	for _, backend := range o.backendList() {
 		objType, size, err := backend.ReadHeader(oid)
 		if err == nil {
 			return objType, size, nil
 		}
+		if IsErrorCode(err, ErrObjectCorrupt) && corruptErr == nil {
+			corruptErr = err
+		}
 	}
 
-	return ObjectBad, 0, errors.New(fmt.Sprintf("no match for id: %s", oid.String()))
+	if corruptErr != nil {
+		return ObjectBad, 0, corruptErr
+	}
+	return ObjectBad, 0, MakeGitError(fmt.Sprintf("no match for id: %s", oid.String()), ErrNotFound)
 }
 
+// Write stores the object. If the object already exists, it only freshens
+// the file that has it, so the object survives the next gc without being
+// written again.
 func (o *Odb) Write(data []byte, objType ObjectType) (*Oid, error) {
-	for _, backend := range o.backends {
+	if o.readOnly {
+		return nil, errReadOnly("Odb.Write")
+	}
+	oid, err := hash(data, objType)
+	if err != nil {
+		return nil, err
+	}
+	if o.freshen(oid) {
+		return oid, nil
+	}
+	for _, backend := range o.backendList() {
 		if backend.IsAlternate() {
 			continue
 		}
 		oid, err := backend.Write(data, objType)
 		if err == nil {
+			if o.notify != nil {
+				o.notify(&RepositoryEvent{Type: RepositoryEventObjectWritten, Id: oid})
+			}
 			return oid, nil
 		}
 	}
@@ -135,13 +357,221 @@ func (o *Odb) Write(data []byte, objType ObjectType) (*Oid, error) {
 	return nil, errors.New("Odb.Write: no backend write data")
 }
 
+// ShortId is an abbreviated object id that is resolved by ExpandIds. Type
+// restricts the match to the type unless it is ObjectAny or zero.
+type ShortId struct {
+	Id     Oid
+	Length int
+	Type   ObjectType
+}
+
+type expandRequest struct {
+	shortId   *ShortId
+	found     *Oid
+	ambiguous bool
+}
+
+func (r *expandRequest) add(oid *Oid) {
+	if r.found == nil {
+		r.found = oid.Copy()
+	} else if !r.found.Equal(oid) {
+		r.ambiguous = true
+	}
+}
+
+type expandRequests []*expandRequest
+
+func (a expandRequests) Len() int      { return len(a) }
+func (a expandRequests) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a expandRequests) Less(i, j int) bool {
+	return bytes.Compare(a[i].shortId.Id[:], a[j].shortId.Id[:]) < 0
+}
+
+// odbBackendExpander is implemented by backends that resolve many prefixes
+// in one pass. The requests are sorted by their prefixes.
+type odbBackendExpander interface {
+	expandIds(requests []*expandRequest) error
+}
+
+// ExpandIds resolves many abbreviated ids at once. The prefixes are sorted
+// and every pack index and loose object directory is swept only once. Each
+// resolved entry gets the full id, the length of 40 and the object type.
+// Entries that are not found, are ambiguous or have the wrong type are
+// cleared to a zero id and length.
+func (o *Odb) ExpandIds(ids []ShortId) error {
+	var requests expandRequests
+	for i := range ids {
+		shortId := &ids[i]
+		if shortId.Length < GitOidMinimumPrefixLength || GitOidHexSize < shortId.Length {
+			*shortId = ShortId{Type: ObjectBad}
+			continue
+		}
+		// bits after the prefix must not affect sorting and matching
+		prefix, err := NewOidFromPrefix(shortId.Id.String()[:shortId.Length])
+		if err != nil {
+			return err
+		}
+		shortId.Id = *prefix
+		requests = append(requests, &expandRequest{shortId: shortId})
+	}
+	sort.Sort(requests)
+
+	for _, backend := range o.backendList() {
+		if expander, ok := backend.(odbBackendExpander); ok {
+			err := expander.expandIds(requests)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		for _, request := range requests {
+			foundId, err := backend.ExistsPrefix(&request.shortId.Id, request.shortId.Length)
+			if IsErrorCode(err, ErrAmbiguous) {
+				request.ambiguous = true
+			} else if foundId != nil {
+				request.add(foundId)
+			}
+		}
+	}
+
+	for _, request := range requests {
+		shortId := request.shortId
+		if request.found == nil || request.ambiguous {
+			*shortId = ShortId{Type: ObjectBad}
+			continue
+		}
+		objType, _, err := o.ReadHeader(request.found)
+		if err != nil || (shortId.Type != ObjectAny && shortId.Type != 0 && shortId.Type != objType) {
+			*shortId = ShortId{Type: ObjectBad}
+			continue
+		}
+		*shortId = ShortId{
+			Id:     *request.found,
+			Length: GitOidHexSize,
+			Type:   objType,
+		}
+	}
+	return nil
+}
+
+// ShortId returns the shortest hex prefix (not shorter than minLength) that
+// identifies the oid unambiguously in this object database.
+func (o *Odb) ShortId(oid *Oid, minLength int) (string, error) {
+	if minLength < GitOidMinimumPrefixLength {
+		minLength = GitOidMinimumPrefixLength
+	}
+	idString := oid.String()
+	for length := minLength; length < GitOidHexSize; length++ {
+		prefix, err := NewOidFromPrefix(idString[:length])
+		if err != nil {
+			return "", err
+		}
+		unique, err := o.isUniquePrefix(prefix, length)
+		if err != nil {
+			return "", err
+		}
+		if unique {
+			return idString[:length], nil
+		}
+	}
+	return idString, nil
+}
+
+func (o *Odb) isUniquePrefix(prefix *Oid, length int) (bool, error) {
+	var foundId *Oid
+	for _, backend := range o.backendList() {
+		id, err := backend.ExistsPrefix(prefix, length)
+		if IsErrorCode(err, ErrAmbiguous) {
+			return false, nil
+		}
+		if id == nil {
+			continue
+		}
+		if foundId != nil && !foundId.Equal(id) {
+			return false, nil
+		}
+		foundId = id
+	}
+	return true, nil
+}
+
+// OdbForEachCallback is called for each object id. Returning StopIteration
+// stops the iteration; Odb.ForEach returns nil then, while the ForEach
+// methods of the backends return it to the caller.
 type OdbForEachCallback func(id *Oid) error
 
 func (o *Odb) ForEach(callback OdbForEachCallback) error {
-	for _, backend := range o.backends {
+	for _, backend := range o.backendList() {
 		err := backend.ForEach(callback)
 		if err != nil {
-			return err
+			return iterationResult(err)
+		}
+	}
+	return nil
+}
+
+// OdbForEachFlag selects the metadata that Odb.ForEachExt reads for each
+// object. Where the object is stored is always reported.
+type OdbForEachFlag int
+
+const (
+	// Type and Size of the objects. Packed deltas are resolved, so the
+	// type and the size are the ones of the object itself.
+	OdbForEachHeader OdbForEachFlag = 1 << iota
+	// DiskSize of the objects
+	OdbForEachDiskSize
+)
+
+// OdbObjectInfo describes an object that is found by Odb.ForEachExt.
+type OdbObjectInfo struct {
+	Id *Oid
+	// Type and Size are set with OdbForEachHeader
+	Type ObjectType
+	Size uint64
+	// DiskSize is the size of the compressed loose file or of the packed
+	// data, which may be a delta. It is set with OdbForEachDiskSize by the
+	// backends of git4go.
+	DiskSize uint64
+	Backend  OdbBackend
+	// PackFile is the path of the packfile that contains the object and
+	// Offset is the position in it. PackFile is empty for other backends.
+	PackFile string
+	Offset   uint64
+}
+
+// OdbForEachExtCallback is called for each object. Returning StopIteration
+// stops the iteration like OdbForEachCallback.
+type OdbForEachExtCallback func(info *OdbObjectInfo) error
+
+// ForEachExt calls the callback for each object of each backend with the
+// metadata that the flags select. The packfiles are read in the order of the
+// offsets and the headers are read while the objects are found, so it is
+// much cheaper than ForEach followed by ReadHeader for each id. An object
+// that is stored by several backends is reported once for each of them.
+func (o *Odb) ForEachExt(flags OdbForEachFlag, callback OdbForEachExtCallback) error {
+	for _, backend := range o.backendList() {
+		var err error
+		if extBackend, ok := backend.(odbBackendForEachExt); ok {
+			err = extBackend.forEachExt(flags, func(info *OdbObjectInfo) error {
+				info.Backend = backend
+				return callback(info)
+			})
+		} else {
+			err = backend.ForEach(func(id *Oid) error {
+				info := &OdbObjectInfo{Id: id, Backend: backend}
+				if flags&OdbForEachHeader != 0 {
+					objType, size, err := backend.ReadHeader(id)
+					if err != nil {
+						return err
+					}
+					info.Type = objType
+					info.Size = size
+				}
+				return callback(info)
+			})
+		}
+		if err != nil {
+			return iterationResult(err)
 		}
 	}
 	return nil
@@ -158,38 +588,122 @@ func (o *Odb) GetAllObjects() ([]*Oid, error) {
 
 // internal functions and methods
 
+// odbBackendForEachExt is implemented by backends that find the metadata of
+// ForEachExt while they list the objects.
+type odbBackendForEachExt interface {
+	forEachExt(flags OdbForEachFlag, callback OdbForEachExtCallback) error
+}
+
+// OdbBackendFreshener is implemented by backends that can update the
+// modification time of the file that stores the object.
+type OdbBackendFreshener interface {
+	Freshen(oid *Oid) error
+}
+
+// setNotify makes the object database and its packed backends report the
+// changes to the subscribers of the repository.
+func (o *Odb) setNotify(notify func(event *RepositoryEvent)) {
+	o.notify = notify
+	for _, backend := range o.backendList() {
+		if packed, ok := backend.(*OdbBackendPacked); ok {
+			packed.lock.Lock()
+			packed.notify = notify
+			packed.lock.Unlock()
+		}
+	}
+}
+
+// addListener makes the object database report its changes to the
+// repository. The notify functions of all repositories that use the
+// database are called.
+func (o *Odb) addListener(repo *Repository, notify func(event *RepositoryEvent)) {
+	o.listenersLock.Lock()
+	first := o.listeners == nil
+	if first {
+		o.listeners = make(map[*Repository]func(event *RepositoryEvent))
+	}
+	o.listeners[repo] = notify
+	o.listenersLock.Unlock()
+	if first {
+		o.setNotify(o.broadcast)
+	}
+}
+
+func (o *Odb) removeListener(repo *Repository) {
+	o.listenersLock.Lock()
+	defer o.listenersLock.Unlock()
+
+	delete(o.listeners, repo)
+}
+
+func (o *Odb) broadcast(event *RepositoryEvent) {
+	o.listenersLock.Lock()
+	listeners := make([]func(event *RepositoryEvent), 0, len(o.listeners))
+	for _, notify := range o.listeners {
+		listeners = append(listeners, notify)
+	}
+	o.listenersLock.Unlock()
+	for _, notify := range listeners {
+		notify(event)
+	}
+}
+
+func (o *Odb) freshen(oid *Oid) bool {
+	for _, backend := range o.backendList() {
+		if freshener, ok := backend.(OdbBackendFreshener); ok {
+			if freshener.Freshen(oid) == nil {
+				return true
+			}
+		} else if backend.Exists(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *Odb) backendList() []OdbBackend {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+
+	return o.backends
+}
+
 func (o *Odb) addBackendInternal(backend OdbBackend, priority int, asAlternates bool, dirInfo os.FileInfo) {
 	backend.InitBackend(priority, asAlternates, dirInfo)
-	o.backends = append(o.backends, backend)
-	var backends OdbBackends = o.backends
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	// copy on write: readers may still be iterating over the old slice
+	var backends OdbBackends = make([]OdbBackend, len(o.backends), len(o.backends)+1)
+	copy(backends, o.backends)
+	backends = append(backends, backend)
 	sort.Sort(backends)
+	o.backends = backends
 }
 
-func (o *Odb) loadAlternates(objectsDir string, alternateDepth int) error {
-	if alternateDepth > GitAlternatesMaxDepth {
-		return nil
+// loadAlternates adds the object stores that are listed in the alternates
+// file. Like git, a store that is nested too deep or that leads back to one
+// of the stores in the chain is skipped with a warning.
+func (o *Odb) loadAlternates(objectsDir string, alternateDepth int, chain []os.FileInfo) error {
+	alternates, err := readAlternatesFile(fileSystemOrDefault(o.fs), objectsDir)
+	if err != nil || len(alternates) == 0 {
+		return err
 	}
-	alternatePath := filepath.Join(objectsDir, GitAlternatesFile)
-	_, err := os.Stat(alternatePath)
-	if os.IsNotExist(err) {
+	if alternateDepth > GitAlternatesMaxDepth {
+		trace(TraceWarn, TraceCategoryOdb, "ignoring alternate object stores, nesting too deep", 0, map[string]interface{}{
+			"path": objectsDir,
+		})
 		return nil
 	}
-	file, err := os.Open(alternatePath)
-	if err != nil {
-		return err
-	}
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if len(line) == 0 || line[0] == '#' {
-			continue
-		}
-		if line[0] == '.' && alternateDepth > 0 {
-			alternatesPath2 := filepath.Join(objectsDir, line)
-			err = o.AddDefaultBackends(alternatesPath2, true, alternateDepth+1)
-			if err != nil {
-				return err
-			}
+	for _, alternate := range alternates {
+		err = o.addDefaultBackends(resolveAlternate(objectsDir, alternate), true, alternateDepth+1, chain)
+		if IsErrorCode(err, ErrInvalid) {
+			trace(TraceWarn, TraceCategoryOdb, "ignoring alternate object store", 0, map[string]interface{}{
+				"path":  alternate,
+				"error": err.Error(),
+			})
+		} else if err != nil {
+			return err
 		}
 	}
 	return nil
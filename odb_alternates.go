@@ -0,0 +1,135 @@
+package git4go
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Alternates returns the object stores that are listed in objects/info/alternates
+// as they are written. Relative paths are relative to the objects directory.
+func (o *Odb) Alternates() ([]string, error) {
+	if o.objectsDir == "" {
+		return nil, errors.New("the object database has no objects directory")
+	}
+	return readAlternatesFile(fileSystemOrDefault(o.fs), o.objectsDir)
+}
+
+// WriteAlternatesFile replaces objects/info/alternates with the paths, and
+// adds the stores that were not used yet to the object database. An empty
+// list removes the file. Every path must be an objects directory, and it
+// fails with ErrInvalid if the alternates of a path lead back to this
+// object database.
+func (o *Odb) WriteAlternatesFile(alternates []string) error {
+	if o.readOnly {
+		return errReadOnly("Odb.WriteAlternatesFile")
+	}
+	if o.objectsDir == "" {
+		return errors.New("the object database has no objects directory")
+	}
+	fsys := fileSystemOrDefault(o.fs)
+	info, err := fsys.Stat(o.objectsDir)
+	if err != nil {
+		return err
+	}
+	var buffer bytes.Buffer
+	for _, alternate := range alternates {
+		if alternate == "" || alternate[0] == '#' || strings.ContainsAny(alternate, "\r\n") {
+			return MakeGitError(fmt.Sprintf("invalid alternate object store '%s'", alternate), ErrInvalid)
+		}
+		path := resolveAlternate(o.objectsDir, alternate)
+		stat, err := fsys.Stat(path)
+		if err != nil || !stat.IsDir() {
+			return MakeGitError(fmt.Sprintf("alternate object store '%s' does not exist", alternate), ErrNotFound)
+		}
+		if alternatesReach(fsys, path, info, 0) {
+			return MakeGitError(fmt.Sprintf("alternate object store '%s' forms a cycle", alternate), ErrInvalid)
+		}
+		buffer.WriteString(alternate)
+		buffer.WriteByte('\n')
+	}
+
+	path := filepath.Join(o.objectsDir, filepath.FromSlash(GitAlternatesFile))
+	if len(alternates) == 0 {
+		err = fsys.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	err = fsys.MkdirAll(filepath.Dir(path), os.FileMode(GitObjectDirMode))
+	if err != nil {
+		return err
+	}
+	lock, err := newLockfile(fsys, path, 0666, DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	_, err = lock.Write(buffer.Bytes())
+	if err != nil {
+		lock.Rollback()
+		return err
+	}
+	err = lock.Commit()
+	if err != nil {
+		return err
+	}
+	return o.loadAlternates(o.objectsDir, 0, []os.FileInfo{info})
+}
+
+// internal functions
+
+func readAlternatesFile(fsys FileSystem, objectsDir string) ([]string, error) {
+	file, err := fsys.Open(filepath.Join(objectsDir, filepath.FromSlash(GitAlternatesFile)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var alternates []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		alternates = append(alternates, line)
+	}
+	return alternates, scanner.Err()
+}
+
+// resolveAlternate returns the path of the alternate. A relative path is
+// relative to the objects directory whose alternates file lists it.
+func resolveAlternate(objectsDir, alternate string) string {
+	if filepath.IsAbs(alternate) {
+		return alternate
+	}
+	return filepath.Join(objectsDir, alternate)
+}
+
+// alternatesReach checks whether the target is the objects directory or one
+// of its alternates, following them as deep as the object database does.
+func alternatesReach(fsys FileSystem, objectsDir string, target os.FileInfo, depth int) bool {
+	info, err := fsys.Stat(objectsDir)
+	if err != nil {
+		return false
+	}
+	if os.SameFile(info, target) {
+		return true
+	}
+	if depth > GitAlternatesMaxDepth {
+		return false
+	}
+	alternates, _ := readAlternatesFile(fsys, objectsDir)
+	for _, alternate := range alternates {
+		if alternatesReach(fsys, resolveAlternate(objectsDir, alternate), target, depth+1) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package git4go
+
+import (
+	"./testutil"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_OdbWriteAlternatesFile(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+	dir, _ := ioutil.TempDir("", "git4go_alternates")
+	defer os.RemoveAll(dir)
+
+	shared, _ := filepath.Abs("test_resources/testrepo.git/objects")
+	repo, err := InitRepository(dir, true)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	odb, _ := repo.Odb()
+	id, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	if odb.Exists(id) {
+		t.Fatal("test setup error: the object should not exist yet")
+	}
+	err = odb.WriteAlternatesFile([]string{shared})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if !odb.Exists(id) {
+		t.Error("it should add the alternate to the object database")
+	}
+	alternates, err := odb.Alternates()
+	if err != nil || len(alternates) != 1 || alternates[0] != shared {
+		t.Error("it should write the alternates file:", alternates, err)
+	}
+	reopened, _ := OdbOpen(filepath.Join(dir, "objects"))
+	if !reopened.Exists(id) {
+		t.Error("it should read the alternates file on open")
+	}
+
+	sharedOdb, _ := OdbOpen(shared)
+	err = sharedOdb.WriteAlternatesFile([]string{filepath.Join(dir, "objects")})
+	if !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should refuse the alternate that forms a cycle:", err)
+	}
+	err = odb.WriteAlternatesFile([]string{filepath.Join(dir, "missing")})
+	if !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should refuse the missing directory:", err)
+	}
+
+	err = odb.WriteAlternatesFile(nil)
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "objects", "info", "alternates")); !os.IsNotExist(err) {
+		t.Error("it should remove the alternates file")
+	}
+}
+
+func Test_OdbAlternatesCycle(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+	dir, _ := ioutil.TempDir("", "git4go_alternates")
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "a", "info"), 0777)
+	os.MkdirAll("test_resources/testrepo.git/objects/info", 0777)
+	shared, _ := filepath.Abs("test_resources/testrepo.git/objects")
+	ioutil.WriteFile(filepath.Join(dir, "a", "info", "alternates"), []byte(shared+"\n"), 0666)
+	ioutil.WriteFile(filepath.Join(shared, "info", "alternates"), []byte(filepath.Join(dir, "a")+"\n"), 0666)
+
+	var warnings int
+	SetTracer(TraceWarn, func(event *TraceEvent) {
+		if event.Category == TraceCategoryOdb {
+			warnings++
+		}
+	})
+	defer SetTracer(TraceNone, nil)
+
+	odb, err := OdbOpen(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	id, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	if !odb.Exists(id) {
+		t.Error("it should read the objects of the alternate")
+	}
+	if warnings != 1 {
+		t.Error("it should warn about the cycle once:", warnings)
+	}
+}
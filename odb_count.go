@@ -0,0 +1,178 @@
+package git4go
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ObjectCount is the result of Odb.CountObjects, like the output of
+// "git count-objects -v". The sizes are in bytes.
+type ObjectCount struct {
+	LooseObjects int
+	LooseSize    uint64
+	// PackedObjects is the sum of the objects of the packfiles, so an
+	// object that is in two packfiles is counted twice
+	PackedObjects int
+	Packs         int
+	// PackSize is the size of the packfiles and their indexes
+	PackSize uint64
+	// PrunePackable is the number of loose objects that are also in a
+	// packfile and can be removed by "git prune-packed"
+	PrunePackable int
+	// Garbage is the files in the object directories that are neither
+	// objects nor the files of packfiles, e.g. temporary files of failed
+	// writes or indexes without packfiles
+	Garbage      int
+	GarbageSize  uint64
+	GarbageFiles []string
+}
+
+// CountObjects counts the objects of the object database for decisions like
+// "git gc --auto" makes. Only the file system is read: no object is
+// inflated. Alternate object databases are not counted, but their packfiles
+// are used to find PrunePackable objects.
+func (o *Odb) CountObjects() (*ObjectCount, error) {
+	count := &ObjectCount{}
+	var looseIds []*Oid
+	var packedBackends []*OdbBackendPacked
+	for _, backend := range o.backendList() {
+		switch typed := backend.(type) {
+		case *OdbBackendLoose:
+			if typed.IsAlternate() {
+				continue
+			}
+			ids, err := typed.countObjects(count)
+			if err != nil {
+				return nil, err
+			}
+			looseIds = append(looseIds, ids...)
+		case *OdbBackendPacked:
+			packedBackends = append(packedBackends, typed)
+			if typed.IsAlternate() {
+				continue
+			}
+			err := typed.countObjects(count)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, id := range looseIds {
+		for _, packed := range packedBackends {
+			if packed.Exists(id) {
+				count.PrunePackable++
+				break
+			}
+		}
+	}
+	return count, nil
+}
+
+// internal functions and methods
+
+func (c *ObjectCount) addGarbage(path string, size int64) {
+	c.Garbage++
+	c.GarbageSize += uint64(size)
+	c.GarbageFiles = append(c.GarbageFiles, path)
+}
+
+// countObjects adds the loose objects and the garbage in the fan-out
+// directories to the count, and returns the ids of the objects.
+func (o *OdbBackendLoose) countObjects(count *ObjectCount) ([]*Oid, error) {
+	dirs, err := o.fs.ReadDir(o.objectsDir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []*Oid
+	for _, dir := range dirs {
+		dirName := dir.Name()
+		if !dir.IsDir() || len(dirName) != 2 {
+			continue
+		}
+		if _, err := strconv.ParseUint(dirName, 16, 8); err != nil {
+			continue
+		}
+		dirPath := filepath.Join(o.objectsDir, dirName)
+		children, err := o.fs.ReadDir(dirPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			info, err := child.Info()
+			if err != nil {
+				// removed while it was counted
+				continue
+			}
+			childPath := filepath.Join(dirPath, child.Name())
+			oid, err := NewOid(dirName + child.Name())
+			if err != nil || len(child.Name()) != GitOidHexSize-2 || !info.Mode().IsRegular() {
+				count.addGarbage(childPath, info.Size())
+				continue
+			}
+			count.LooseObjects++
+			count.LooseSize += uint64(info.Size())
+			ids = append(ids, oid)
+		}
+	}
+	return ids, nil
+}
+
+// packFileExtensions are the files that belong to a packfile besides its
+// index.
+var packFileExtensions = []string{".keep", ".bitmap", ".promisor", ".rev", ".mtimes"}
+
+// countObjects adds the packfiles and the garbage in the pack directory to
+// the count.
+func (o *OdbBackendPacked) countObjects(count *ObjectCount) error {
+	err := o.Refresh()
+	if err != nil {
+		return err
+	}
+	infos, err := ioutil.ReadDir(o.packFolder)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for _, info := range infos {
+		names[info.Name()] = true
+	}
+	for _, info := range infos {
+		name := info.Name()
+		path := filepath.Join(o.packFolder, name)
+		ext := filepath.Ext(name)
+		baseName := strings.TrimSuffix(name, ext)
+		switch {
+		case info.IsDir():
+			count.addGarbage(path, 0)
+		case ext == ".pack" && names[baseName+".idx"]:
+			count.PackSize += uint64(info.Size())
+		case ext == ".idx" && names[baseName+".pack"]:
+			count.PackSize += uint64(info.Size())
+			count.Packs++
+			pack, err := GetPack(path)
+			if err != nil {
+				return err
+			}
+			err = pack.openIndex()
+			if err != nil {
+				return err
+			}
+			count.PackedObjects += pack.numObjects
+		case isPackFileExtension(ext) && names[baseName+".pack"]:
+		default:
+			count.addGarbage(path, info.Size())
+		}
+	}
+	return nil
+}
+
+func isPackFileExtension(ext string) bool {
+	for _, packExt := range packFileExtensions {
+		if ext == packExt {
+			return true
+		}
+	}
+	return false
+}
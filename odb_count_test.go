@@ -0,0 +1,49 @@
+package git4go
+
+import (
+	"./testutil"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Odb_CountObjects(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+	objectsDir := "test_resources/testrepo.git/objects"
+	odb, _ := OdbOpen(objectsDir)
+
+	count, err := odb.CountObjects()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if count.LooseObjects != 47 || count.LooseSize == 0 {
+		t.Error("it should count the loose objects:", count.LooseObjects, count.LooseSize)
+	}
+	if count.PackedObjects != 1640 || count.Packs == 0 || count.PackSize == 0 {
+		t.Error("it should count the packed objects:", count.PackedObjects, count.Packs)
+	}
+	if count.Garbage != 0 || count.PrunePackable != 0 {
+		t.Error("it should not find garbage:", count.GarbageFiles, count.PrunePackable)
+	}
+
+	packedId, _ := NewOid(testutil.PackedObjects[0])
+	obj, _ := odb.Read(packedId)
+	loose := NewOdbBackendLoose(objectsDir, -1, false, 0, 0)
+	loose.Write(obj.Data, obj.Type)
+	ioutil.WriteFile(filepath.Join(objectsDir, "pack", "pack-0123.idx"), []byte("broken"), 0644)
+	os.MkdirAll(filepath.Join(objectsDir, "ab"), 0755)
+	ioutil.WriteFile(filepath.Join(objectsDir, "ab", "tmp_obj_123"), []byte("tmp"), 0644)
+
+	count, err = odb.CountObjects()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if count.LooseObjects != 48 || count.PrunePackable != 1 {
+		t.Error("it should find the loose object that is also packed:", count.LooseObjects, count.PrunePackable)
+	}
+	if count.Garbage != 2 || count.GarbageSize != 9 {
+		t.Error("it should find the garbage files:", count.GarbageFiles)
+	}
+}
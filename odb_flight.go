@@ -0,0 +1,68 @@
+package git4go
+
+import (
+	"errors"
+	"sync"
+)
+
+// flightGroup runs only one read per object at a time. Goroutines that ask
+// for the same object while the read is running wait for it and share its
+// result instead of inflating the object again.
+type flightGroup struct {
+	lock  sync.Mutex
+	calls map[flightKey]*flightCall
+}
+
+type flightKey struct {
+	oid    Oid
+	header bool
+}
+
+type flightCall struct {
+	wg     sync.WaitGroup
+	waiter int
+	shared interface{}
+	err    error
+}
+
+// do calls fn, or waits for the running call of the same key. The caller
+// that runs fn gets its result as is. If other goroutines were waiting,
+// share is called once to make the value that they receive, so the
+// original value can be modified or released by its owner.
+func (g *flightGroup) do(key flightKey, fn func() (interface{}, error), share func(interface{}) interface{}) (value interface{}, err error, shared bool) {
+	g.lock.Lock()
+	if g.calls == nil {
+		g.calls = make(map[flightKey]*flightCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		call.waiter++
+		g.lock.Unlock()
+		call.wg.Wait()
+		return call.shared, call.err, true
+	}
+	call := &flightCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.lock.Unlock()
+
+	completed := false
+	defer func() {
+		g.lock.Lock()
+		delete(g.calls, key)
+		waiter := call.waiter
+		g.lock.Unlock()
+		if !completed {
+			// fn panicked, the panic continues in this goroutine
+			call.err = errors.New("object read was aborted")
+		} else {
+			call.err = err
+			if err == nil && waiter > 0 {
+				call.shared = share(value)
+			}
+		}
+		call.wg.Done()
+	}()
+	value, err = fn()
+	completed = true
+	return value, err, false
+}
@@ -5,20 +5,41 @@ import (
 	"compress/zlib"
 	"errors"
 	"fmt"
+	"github.com/edsrzf/mmap-go"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// Loose objects that are larger than this size are memory mapped instead of
+// being read into the heap.
+var looseMmapThreshold int64 = 1024 * 1024
+
+// ReadHeader reads only this many bytes of the loose object file. It is
+// enough for the compressed header in almost every case, and the rest of
+// the file is read only when it is not.
+const looseHeaderReadSize = 512
+
+// Longest valid header is "commit <20 digits>\x00"
+const looseMaxHeaderSize = 64
+
 type OdbBackendLoose struct {
 	OdbBackendBase
-	objectsDir string
-	dirMode    uint32
-	fileMode   uint32
-	doFileSync bool
+	fs               FileSystem
+	objectsDir       string
+	compressionLevel int
+	dirMode          uint32
+	fileMode         uint32
+	doFileSync       bool
+
+	dirCacheLock     sync.Mutex
+	dirCacheDisabled bool
+	dirCache         [256]*looseDirListing
 }
 
 func NewOdbBackendLoose(objectsDir string, compressionLevel int, doFileSync bool, dirMode, fileMode uint32) *OdbBackendLoose {
@@ -32,50 +53,49 @@ func NewOdbBackendLoose(objectsDir string, compressionLevel int, doFileSync bool
 		fileMode = GitObjectFileMode
 	}
 	return &OdbBackendLoose{
-		objectsDir: objectsDir,
-		dirMode:    dirMode,
-		fileMode:   fileMode,
-		doFileSync: doFileSync,
+		fs:               OSFileSystem,
+		objectsDir:       objectsDir,
+		compressionLevel: compressionLevel,
+		dirMode:          dirMode,
+		fileMode:         fileMode,
+		doFileSync:       doFileSync,
 	}
 }
 
 func isZlibCompressedData(data []byte) bool {
+	if len(data) < 2 {
+		return false
+	}
 	w := uint(data[0])<<8 + uint(data[1])
 	return (data[0]&0x8F) == 0x08 && (w%31) == 0
 }
 
 func parseObjectHeader(data []byte) (ObjectType, uint64, int, error) {
-	resultType := ObjectBad
-	var size uint64
-	offset := 0
-	typeEnd := 0
-	var err error
-	for ; offset < len(data); offset++ {
-		if data[offset] == ' ' {
-			resultType = TypeString2Type(string(data[:offset]))
-			typeEnd = offset + 1
-			offset++
-			break
-		}
+	typeEnd := bytes.IndexByte(data, ' ')
+	if typeEnd == -1 {
+		return ObjectBad, 0, 0, errors.New("parseObjectHeader: type is not terminated")
 	}
-	for ; offset < len(data); offset++ {
-		if data[offset] == 0 {
-			size, err = strconv.ParseUint(string(data[typeEnd:offset]), 10, 64)
-			if err != nil {
-				return ObjectBad, 0, 0, err
-			}
-			offset++
-			break
-		}
+	resultType := TypeString2Type(string(data[:typeEnd]))
+	if resultType == ObjectBad {
+		return ObjectBad, 0, 0, errors.New("parseObjectHeader: invalid type")
 	}
-	return resultType, size, offset, nil
+	sizeEnd := bytes.IndexByte(data[typeEnd+1:], 0)
+	if sizeEnd == -1 {
+		return ObjectBad, 0, 0, errors.New("parseObjectHeader: size is not terminated")
+	}
+	sizeEnd += typeEnd + 1
+	size, err := strconv.ParseUint(string(data[typeEnd+1:sizeEnd]), 10, 64)
+	if err != nil {
+		return ObjectBad, 0, 0, err
+	}
+	return resultType, size, sizeEnd + 1, nil
 }
 
 func parseBinaryObjectHeader(data []byte) (ObjectType, uint64, int, error) {
 	if len(data) == 0 {
 		return ObjectBad, 0, 0, errors.New("parseBinaryObjectHeader: input is empty")
 	}
-	c := int(data[0])
+	c := data[0]
 	resultType := ObjectType((c >> 4) & 7)
 	size := uint64(c & 15)
 	var shift uint = 4
@@ -84,52 +104,101 @@ func parseBinaryObjectHeader(data []byte) (ObjectType, uint64, int, error) {
 		if len(data) <= offset {
 			return ObjectBad, 0, 0, errors.New("parseBinaryObjectHeader: input is too short")
 		}
+		if 64 <= shift {
+			return ObjectBad, 0, 0, errors.New("parseBinaryObjectHeader: size is too big")
+		}
+		c = data[offset]
 		offset++
-		size += (uint64(data[offset]) & 0x7f) << shift
+		size += (uint64(c) & 0x7f) << shift
 		shift += 7
 	}
+	if resultType < ObjectCommit || ObjectTag < resultType {
+		return ObjectBad, 0, 0, errors.New("parseBinaryObjectHeader: invalid type")
+	}
 	return resultType, size, offset, nil
 }
 
+// readLooseFile returns the raw content of the loose object file. Big files
+// are memory mapped and it falls back to a plain read if mapping fails.
+// The returned function must be called after the content is consumed.
+func readLooseFile(fsys FileSystem, path string) ([]byte, func(), error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if osFile, ok := file.(*os.File); ok && stat.Size() >= looseMmapThreshold {
+		mapped, err := mmap.Map(osFile, mmap.RDONLY, 0)
+		if err == nil {
+			return mapped, func() { mapped.Unmap() }, nil
+		}
+	}
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, func() {}, nil
+}
+
 func (o *OdbBackendLoose) Read(oid *Oid) (*OdbObject, error) {
 	dirName, fileName := oid.PathFormat()
-	content, err := ioutil.ReadFile(filepath.Join(o.objectsDir, dirName, fileName))
+	path := filepath.Join(o.objectsDir, dirName, fileName)
+	content, release, err := readLooseFile(o.fs, path)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
+	obj, err := parseLooseObject(content)
+	if err != nil {
+		return nil, newObjectCorruptError(oid, path, err)
+	}
+	return obj, nil
+}
+
+func parseLooseObject(content []byte) (*OdbObject, error) {
+	var buffer *bytes.Buffer
+	var objType ObjectType
+	var size uint64
+	var data []byte
 	if isZlibCompressedData(content) {
-		reader, err := zlib.NewReader(bytes.NewReader(content))
+		var err error
+		buffer, err = inflate(bytes.NewReader(content))
 		if err != nil {
 			return nil, err
 		}
-		var buffer bytes.Buffer
-		io.Copy(&buffer, reader)
-		data := buffer.Bytes()
-		objType, _, offset, err := parseObjectHeader(data)
+		var offset int
+		objType, size, offset, err = parseObjectHeader(buffer.Bytes())
 		if err != nil {
+			putBuffer(buffer)
 			return nil, err
 		}
-		return &OdbObject{
-			Type: objType,
-			Data: data[offset:],
-		}, nil
+		data = buffer.Bytes()[offset:]
 	} else {
-		objType, _, offset, err := parseBinaryObjectHeader(content)
+		var offset int
+		var err error
+		objType, size, offset, err = parseBinaryObjectHeader(content)
 		if err != nil {
 			return nil, err
 		}
-		reader, err := zlib.NewReader(bytes.NewReader(content[offset:]))
-		defer reader.Close()
+		buffer, err = inflate(bytes.NewReader(content[offset:]))
 		if err != nil {
 			return nil, err
 		}
-		var buffer bytes.Buffer
-		io.Copy(&buffer, reader)
-		return &OdbObject{
-			Type: objType,
-			Data: buffer.Bytes(),
-		}, nil
+		data = buffer.Bytes()
+	}
+	if uint64(len(data)) != size {
+		putBuffer(buffer)
+		return nil, errors.New(fmt.Sprintf("object size is %d but header says %d", len(data), size))
 	}
+	return &OdbObject{
+		Type:   objType,
+		Data:   data,
+		buffer: buffer,
+	}, nil
 }
 
 func (o *OdbBackendLoose) ReadPrefix(oid *Oid, length int) (*Oid, *OdbObject, error) {
@@ -146,111 +215,198 @@ func (o *OdbBackendLoose) ReadPrefix(oid *Oid, length int) (*Oid, *OdbObject, er
 
 func (o *OdbBackendLoose) ReadHeader(oid *Oid) (ObjectType, uint64, error) {
 	dirName, fileName := oid.PathFormat()
-	content, err := ioutil.ReadFile(filepath.Join(o.objectsDir, dirName, fileName))
+	path := filepath.Join(o.objectsDir, dirName, fileName)
+	file, err := o.fs.Open(path)
 	if err != nil {
 		return ObjectBad, 0, err
 	}
-	if isZlibCompressedData(content) {
-		reader, err := zlib.NewReader(bytes.NewReader(content))
+	defer file.Close()
+	head := make([]byte, looseHeaderReadSize)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return ObjectBad, 0, err
+	}
+	head = head[:n]
+	if isZlibCompressedData(head) {
+		header, err := inflateLooseHeader(bytes.NewReader(head))
+		if err != nil && n == looseHeaderReadSize {
+			// the deflate block header is unusually big
+			header, err = inflateLooseHeader(io.MultiReader(bytes.NewReader(head), file))
+		}
 		if err != nil {
-			return ObjectBad, 0, err
+			return ObjectBad, 0, newObjectCorruptError(oid, path, err)
 		}
-		var buffer bytes.Buffer
-		io.CopyN(&buffer, reader, 64)
-		data := buffer.Bytes()
-		objType, size, _, err := parseObjectHeader(data)
+		objType, size, _, err := parseObjectHeader(header)
 		if err != nil {
-			return ObjectBad, 0, err
+			return ObjectBad, 0, newObjectCorruptError(oid, path, err)
 		}
 		return objType, size, nil
 	} else {
-		objType, size, _, err := parseBinaryObjectHeader(content)
+		objType, size, _, err := parseBinaryObjectHeader(head)
 		if err != nil {
-			return ObjectBad, 0, err
+			return ObjectBad, 0, newObjectCorruptError(oid, path, err)
 		}
 		return objType, size, nil
 	}
 }
 
+// inflateLooseHeader inflates the compressed object until the end of its
+// header and stops there.
+func inflateLooseHeader(source io.Reader) ([]byte, error) {
+	reader, err := zlib.NewReader(source)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	header := make([]byte, looseMaxHeaderSize)
+	length := 0
+	for length < len(header) {
+		read, err := reader.Read(header[length:])
+		if end := bytes.IndexByte(header[length:length+read], 0); end != -1 {
+			return header[:length+end+1], nil
+		}
+		length += read
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, errors.New("object header is too long")
+}
+
 func (o *OdbBackendLoose) Write(data []byte, objType ObjectType) (*Oid, error) {
 	oid, err := hash(data, objType)
 	if err != nil {
 		return nil, err
 	}
+	if o.Freshen(oid) == nil {
+		return oid, nil
+	}
 	dirName, fileName := oid.PathFormat()
 	dirPath := filepath.Join(o.objectsDir, dirName)
-	os.MkdirAll(dirPath, os.FileMode(GitObjectDirMode))
-	file, err := os.OpenFile(filepath.Join(dirPath, fileName), os.O_WRONLY, os.FileMode(GitObjectFileMode))
-	defer file.Close()
-	writer := zlib.NewWriter(file)
-	fmt.Fprintf(writer, "%s %d\x00", objType.String(), len(data))
-	writer.Write(data)
-	defer writer.Close()
-
+	err = o.fs.MkdirAll(dirPath, os.FileMode(o.dirMode))
+	if err != nil {
+		return nil, err
+	}
+	// the object appears at once, so readers never see a partial file
+	file, err := o.fs.TempFile(dirPath, "tmp_obj_")
+	if err != nil {
+		return nil, err
+	}
+	tempPath := file.Name()
+	writer, err := zlib.NewWriterLevel(file, o.compressionLevel)
+	if err == nil {
+		fmt.Fprintf(writer, "%s %d\x00", objType.String(), len(data))
+		writer.Write(data)
+		err = writer.Close()
+	}
+	if err == nil && o.doFileSync {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = o.fs.Chmod(tempPath, os.FileMode(o.fileMode))
+	}
+	if err == nil {
+		err = o.fs.Rename(tempPath, filepath.Join(dirPath, fileName))
+	}
+	if err != nil {
+		o.fs.Remove(tempPath)
+		return nil, err
+	}
+	o.addCachedName(oid[0], fileName)
 	return oid, nil
 }
 
+// Freshen updates the modification time of the object file instead of
+// writing the same content again.
+func (o *OdbBackendLoose) Freshen(oid *Oid) error {
+	dirName, fileName := oid.PathFormat()
+	path := filepath.Join(o.objectsDir, dirName, fileName)
+	now := time.Now()
+	return o.fs.Chtimes(path, now, now)
+}
+
 func (o *OdbBackendLoose) Exists(oid *Oid) bool {
 	dirName, fileName := oid.PathFormat()
-	_, err := os.Stat(filepath.Join(o.objectsDir, dirName, fileName))
+	_, err := o.fs.Stat(filepath.Join(o.objectsDir, dirName, fileName))
 	return !os.IsNotExist(err)
 }
 
+// ExistsPrefix finds the object of the prefix. The names of the fan-out
+// directory are cached, see SetDirectoryCache.
 func (o *OdbBackendLoose) ExistsPrefix(oid *Oid, length int) (*Oid, error) {
 	dirName, fileName := oid.PathFormat()
 	prefix := fileName[:length-2]
-	file, err := os.Open(filepath.Join(o.objectsDir, dirName))
+	matches, err := o.prefixMatches(oid[0], prefix)
 	if err != nil {
 		return nil, err
 	}
-	found := 0
-	var foundId string
-	dirChildNames, err := file.Readdirnames(0)
-	if err != nil {
-		return nil, err
+	if len(matches) == 0 {
+		return nil, MakeGitError("no matching loose object for prefix", ErrNotFound)
+	} else if len(matches) == 1 {
+		return NewOid(dirName + matches[0])
+	} else {
+		return nil, MakeGitError("multiple matches in loose objects", ErrAmbiguous)
 	}
-	for _, dirChildName := range dirChildNames {
-		if strings.HasPrefix(dirChildName, prefix) {
-			found++
-			foundId = dirChildName
+}
+
+// expandIds reads each fan-out directory once for all prefixes in it.
+func (o *OdbBackendLoose) expandIds(requests []*expandRequest) error {
+	for start := 0; start < len(requests); {
+		dirName, _ := requests[start].shortId.Id.PathFormat()
+		end := start + 1
+		for end < len(requests) && requests[end].shortId.Id[0] == requests[start].shortId.Id[0] {
+			end++
 		}
+		names, err := o.looseNames(requests[start].shortId.Id[0], false)
+		if err != nil {
+			return err
+		}
+		// names are sorted, and so are the requests
+		index := 0
+		for _, request := range requests[start:end] {
+			_, fileName := request.shortId.Id.PathFormat()
+			prefix := fileName[:request.shortId.Length-2]
+			for index < len(names) && names[index] < prefix {
+				index++
+			}
+			for i := index; i < len(names) && strings.HasPrefix(names[i], prefix); i++ {
+				oid, err := NewOid(dirName + names[i])
+				if err == nil {
+					request.add(oid)
+				}
+			}
+		}
+		start = end
 	}
-	if found == 0 {
-		return nil, errors.New("no matching loose object for prefix")
-	} else if found == 1 {
-		return NewOid(dirName + foundId)
-	} else {
-		return nil, errors.New("multiple matches in loose objects")
-	}
+	return nil
 }
 
+// Refresh drops the cached names of the fan-out directories.
 func (o *OdbBackendLoose) Refresh() error {
+	o.ClearDirectoryCache()
 	return nil
 }
 
 func (o *OdbBackendLoose) ForEach(callback OdbForEachCallback) error {
-	objectDir, err := os.Open(o.objectsDir)
-	if err != nil {
-		return err
-	}
-	dirNames, err := objectDir.Readdirnames(0)
+	dirs, err := o.fs.ReadDir(o.objectsDir)
 	if err != nil {
 		return err
 	}
-	for _, dirName := range dirNames {
+	for _, dir := range dirs {
+		dirName := dir.Name()
 		if len(dirName) != 2 {
 			continue
 		}
 		dirPath := filepath.Join(o.objectsDir, dirName)
-		childDir, err := os.Open(dirPath)
+		children, err := o.fs.ReadDir(dirPath)
 		if err != nil {
 			return err
 		}
-		childItems, err := childDir.Readdirnames(0)
-		if err != nil {
-			return err
-		}
-		for _, childItem := range childItems {
+		for _, child := range children {
+			childItem := child.Name()
 			if len(childItem) != 38 {
 				continue
 			}
@@ -266,3 +422,28 @@ func (o *OdbBackendLoose) ForEach(callback OdbForEachCallback) error {
 	}
 	return nil
 }
+
+// internal functions and methods
+
+func (o *OdbBackendLoose) forEachExt(flags OdbForEachFlag, callback OdbForEachExtCallback) error {
+	return o.ForEach(func(oid *Oid) error {
+		info := &OdbObjectInfo{Id: oid}
+		if flags&OdbForEachHeader != 0 {
+			objType, size, err := o.ReadHeader(oid)
+			if err != nil {
+				return err
+			}
+			info.Type = objType
+			info.Size = size
+		}
+		if flags&OdbForEachDiskSize != 0 {
+			dirName, fileName := oid.PathFormat()
+			stat, err := o.fs.Stat(filepath.Join(o.objectsDir, dirName, fileName))
+			if err != nil {
+				return err
+			}
+			info.DiskSize = uint64(stat.Size())
+		}
+		return callback(info)
+	})
+}
@@ -0,0 +1,185 @@
+package git4go
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LooseFanoutStats is the distribution of the loose objects over the
+// fan-out directories, e.g. to find directories that grow too large for
+// the file system.
+type LooseFanoutStats struct {
+	// The objects of each directory, indexed by the first byte of the ids
+	Counts [256]int
+	Total  int
+	// The directories that have objects
+	Directories int
+	// The count of the largest directory
+	Max int
+}
+
+// SetDirectoryCache enables or disables the cache of the names of the
+// fan-out directories, which ExistsPrefix and the id expansions use. It is
+// enabled by default. The names are added by Write and dropped by Refresh
+// and ClearDirectoryCache. When a prefix is not found, the directory is
+// read again if it was changed, so objects that other processes wrote are
+// found; a cached prefix may still be reported unique until Refresh.
+// Disabling drops the names.
+func (o *OdbBackendLoose) SetDirectoryCache(enabled bool) {
+	o.dirCacheLock.Lock()
+	defer o.dirCacheLock.Unlock()
+
+	o.dirCacheDisabled = !enabled
+	if !enabled {
+		o.dirCache = [256]*looseDirListing{}
+	}
+}
+
+// ClearDirectoryCache drops the cached names of the fan-out directories.
+func (o *OdbBackendLoose) ClearDirectoryCache() {
+	o.dirCacheLock.Lock()
+	defer o.dirCacheLock.Unlock()
+
+	o.dirCache = [256]*looseDirListing{}
+}
+
+// FanoutStats counts the loose objects of each fan-out directory. The
+// names are cached if the directory cache is enabled.
+func (o *OdbBackendLoose) FanoutStats() (*LooseFanoutStats, error) {
+	stats := &LooseFanoutStats{}
+	for i := range stats.Counts {
+		names, err := o.looseNames(byte(i), true)
+		if err != nil {
+			return nil, err
+		}
+		count := len(names)
+		stats.Counts[i] = count
+		stats.Total += count
+		if count > 0 {
+			stats.Directories++
+		}
+		if count > stats.Max {
+			stats.Max = count
+		}
+	}
+	return stats, nil
+}
+
+// internal functions and methods
+
+// looseDirListing is the sorted names of the objects of a fan-out
+// directory, without the directory, and the time stamp of the directory.
+type looseDirListing struct {
+	names   []string
+	modTime time.Time
+}
+
+// looseNames returns the names of the objects in the fan-out directory.
+// With validate, the cached names are read again if the directory was
+// changed. The slice must not be modified.
+func (o *OdbBackendLoose) looseNames(first byte, validate bool) ([]string, error) {
+	o.dirCacheLock.Lock()
+	defer o.dirCacheLock.Unlock()
+
+	dirPath := filepath.Join(o.objectsDir, fmt.Sprintf("%02x", first))
+	cached := o.dirCache[first]
+	if cached != nil && !validate {
+		return cached.names, nil
+	}
+	info, err := o.fs.Stat(dirPath)
+	if os.IsNotExist(err) {
+		if !o.dirCacheDisabled {
+			o.dirCache[first] = &looseDirListing{}
+		}
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	modTime := info.ModTime()
+	if cached != nil && cached.modTime.Equal(modTime) {
+		return cached.names, nil
+	}
+
+	entries, err := o.fs.ReadDir(dirPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		// ReadDir sorts the entries
+		if isLooseObjectName(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	if !o.dirCacheDisabled {
+		o.dirCache[first] = &looseDirListing{names: names, modTime: modTime}
+	}
+	return names, nil
+}
+
+// prefixMatches returns the names of the fan-out directory that start with
+// the prefix. The directory is validated only if nothing matches.
+func (o *OdbBackendLoose) prefixMatches(first byte, prefix string) ([]string, error) {
+	validate := false
+	for {
+		names, err := o.looseNames(first, validate)
+		if err != nil {
+			return nil, err
+		}
+		index := sort.SearchStrings(names, prefix)
+		end := index
+		for end < len(names) && strings.HasPrefix(names[end], prefix) {
+			end++
+		}
+		if end > index || validate {
+			return names[index:end], nil
+		}
+		validate = true
+	}
+}
+
+// addCachedName adds the object that was written to the cached names of
+// its directory. The names are copied, because readers may hold them.
+func (o *OdbBackendLoose) addCachedName(first byte, name string) {
+	o.dirCacheLock.Lock()
+	defer o.dirCacheLock.Unlock()
+
+	cached := o.dirCache[first]
+	if cached == nil {
+		return
+	}
+	index := sort.SearchStrings(cached.names, name)
+	if index < len(cached.names) && cached.names[index] == name {
+		return
+	}
+	names := make([]string, 0, len(cached.names)+1)
+	names = append(names, cached.names[:index]...)
+	names = append(names, name)
+	names = append(names, cached.names[index:]...)
+	o.dirCache[first] = &looseDirListing{names: names, modTime: cached.modTime}
+}
+
+// dirCacheSize returns the number of the cached names and their estimated
+// size in bytes.
+func (o *OdbBackendLoose) dirCacheSize() (int, uint64) {
+	o.dirCacheLock.Lock()
+	defer o.dirCacheLock.Unlock()
+
+	count := 0
+	for _, cached := range o.dirCache {
+		if cached != nil {
+			count += len(cached.names)
+		}
+	}
+	return count, uint64(count) * (GitOidHexSize - 2 + 16)
+}
+
+// isLooseObjectName tells if the name in a fan-out directory is an object,
+// not e.g. a temporary file of a write.
+func isLooseObjectName(name string) bool {
+	return len(name) == GitOidHexSize-2 && strings.Trim(name, "0123456789abcdef") == ""
+}
@@ -3,9 +3,16 @@ package git4go
 import (
 	"./testutil"
 	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test_LooseExists_Success(t *testing.T) {
@@ -114,6 +121,34 @@ func Test_LooseRead(t *testing.T) {
 	}
 }
 
+func Test_LooseRead_Mmap(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-objects")
+	defer testutil.CleanupEmptyWorkDir()
+	odb, _ := OdbOpen("test-objects")
+
+	threshold := looseMmapThreshold
+	looseMmapThreshold = 0
+	defer func() {
+		looseMmapThreshold = threshold
+	}()
+
+	testutil.Commit.Write()
+	id, _ := NewOid(testutil.Commit.Id)
+	odbObject, err := odb.Read(id)
+	if err != nil {
+		t.Error("Error should be nil: ", err)
+	} else if bytes.Compare(odbObject.Data, testutil.Commit.Data) != 0 {
+		t.Error("Data should be same")
+	}
+	objType, size, err := odb.ReadHeader(id)
+	if err != nil {
+		t.Error("Error should be nil: ", err)
+	}
+	if objType != ObjectCommit || size != uint64(len(testutil.Commit.Data)) {
+		t.Error("header should be read from mapped file:", objType, size)
+	}
+}
+
 func Test_LooseReadPrefix(t *testing.T) {
 	testutil.PrepareEmptyWorkDir("test-objects")
 	defer testutil.CleanupEmptyWorkDir()
@@ -195,12 +230,36 @@ func Test_LooseWrite(t *testing.T) {
 			t.Error("id is wrong: ", oid.String())
 		}
 		_, err = os.Stat(filepath.Join("test-objects", "67", "b808feb36201507a77f85e6d898f0a2836e4a5"))
-		if !os.IsNotExist(err) {
+		if err != nil {
 			t.Error("file is missing")
 		}
 	}
 }
 
+func Test_LooseWrite_Settings(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-objects")
+	defer testutil.CleanupEmptyWorkDir()
+	backend := NewOdbBackendLoose("test-objects", zlib.NoCompression, true, 0750, 0440)
+
+	data := []byte(strings.Repeat("Test data\n", 10))
+	oid, err := backend.Write(data, ObjectBlob)
+	if err != nil {
+		t.Fatal("write should finish successfully: ", err)
+	}
+	dirName, fileName := oid.PathFormat()
+	if stat, err := os.Stat(filepath.Join("test-objects", dirName)); err != nil || stat.Mode().Perm()&0007 != 0 {
+		t.Error("it should make the directory with the mode of the backend:", err)
+	}
+	stat, err := os.Stat(filepath.Join("test-objects", dirName, fileName))
+	if err != nil || stat.Mode().Perm() != 0440 {
+		t.Error("it should write the file with the mode of the backend:", err)
+	}
+	content, _ := ioutil.ReadFile(filepath.Join("test-objects", dirName, fileName))
+	if !bytes.Contains(content, data) {
+		t.Error("it should compress with the level of the backend")
+	}
+}
+
 func Test_LooseOdb_ForEach(t *testing.T) {
 	testutil.PrepareWorkspace("test_resources/blametest.git")
 	defer testutil.CleanupWorkspace()
@@ -222,3 +281,215 @@ func Test_LooseOdb_ForEach(t *testing.T) {
 		t.Error("target id is not found")
 	}
 }
+
+func Test_ParseBinaryObjectHeader(t *testing.T) {
+	objType, size, offset, err := parseBinaryObjectHeader([]byte{0x95, 0x01, 0xff})
+	if err != nil {
+		t.Error("err should be nil:", err)
+	}
+	if objType != ObjectCommit || size != 21 || offset != 2 {
+		t.Error("it should read continuation byte right after the first byte:", objType, size, offset)
+	}
+	_, _, _, err = parseBinaryObjectHeader([]byte{0x95})
+	if err == nil {
+		t.Error("it should fail on truncated header")
+	}
+	_, _, _, err = parseObjectHeader([]byte("blob 5"))
+	if err == nil {
+		t.Error("it should fail on header without terminator")
+	}
+}
+
+func Test_LooseRead_Corrupt(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-objects")
+	defer testutil.CleanupEmptyWorkDir()
+	odb, _ := OdbOpen("test-objects")
+
+	testutil.Commit.Write()
+	ioutil.WriteFile(testutil.Commit.File, testutil.Commit.Bytes[:len(testutil.Commit.Bytes)/2], 0666)
+
+	id, _ := NewOid(testutil.Commit.Id)
+	_, err := odb.Read(id)
+	if !IsErrorCode(err, ErrObjectCorrupt) {
+		t.Fatal("it should return corrupt error:", err)
+	}
+	corruptErr, ok := err.(*ObjectCorruptError)
+	if !ok {
+		t.Fatal("it should be ObjectCorruptError")
+	}
+	if !strings.HasSuffix(filepath.ToSlash(corruptErr.Path), testutil.Commit.File) {
+		t.Error("it should have file path:", corruptErr.Path)
+	}
+}
+
+func Test_LooseRead_CorruptFallbackToPack(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	packedId := testutil.PackedObjects[0]
+	dir := filepath.Join("test_resources/testrepo.git/objects", packedId[:2])
+	os.MkdirAll(dir, 0777)
+	ioutil.WriteFile(filepath.Join(dir, packedId[2:]), []byte{0x78, 0x9c, 0x01}, 0666)
+
+	odb, _ := OdbOpen("test_resources/testrepo.git/objects")
+	id, _ := NewOid(packedId)
+	obj, err := odb.Read(id)
+	if err != nil {
+		t.Error("it should read object from other backend:", err)
+	} else if obj == nil {
+		t.Error("object should not be nil")
+	}
+}
+
+type countingFileSystem struct {
+	FileSystem
+	read int64
+}
+
+type countingFile struct {
+	fs.File
+	owner *countingFileSystem
+}
+
+func (c *countingFileSystem) Open(name string) (fs.File, error) {
+	file, err := c.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingFile{File: file, owner: c}, nil
+}
+
+func (c *countingFile) Read(buffer []byte) (int, error) {
+	n, err := c.File.Read(buffer)
+	c.owner.read += int64(n)
+	return n, err
+}
+
+func Test_LooseReadHeader_ReadsOnlyHead(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-objects")
+	defer testutil.CleanupEmptyWorkDir()
+
+	data := make([]byte, 1024*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	oid, _ := hash(data, ObjectBlob)
+	dirName, fileName := oid.PathFormat()
+	os.MkdirAll(filepath.Join("test-objects", dirName), 0777)
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	fmt.Fprintf(writer, "blob %d\x00", len(data))
+	writer.Write(data)
+	writer.Close()
+	ioutil.WriteFile(filepath.Join("test-objects", dirName, fileName), compressed.Bytes(), 0666)
+
+	fsys := &countingFileSystem{FileSystem: OSFileSystem}
+	backend := NewOdbBackendLoose("test-objects", -1, false, 0, 0)
+	backend.fs = fsys
+	objType, size, err := backend.ReadHeader(oid)
+	if err != nil {
+		t.Fatal("it should read header:", err)
+	}
+	if objType != ObjectBlob || size != uint64(len(data)) {
+		t.Error("it should return type and size:", objType, size)
+	}
+	if fsys.read > looseHeaderReadSize {
+		t.Error("it should not read the whole file:", fsys.read)
+	}
+}
+
+func Test_LooseWrite_Freshen(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-objects")
+	defer testutil.CleanupEmptyWorkDir()
+	testutil.One.Write()
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(testutil.One.File, old, old)
+
+	odb, _ := OdbOpen("test-objects")
+	oid, err := odb.Write(testutil.One.Data, ObjectBlob)
+	if err != nil || oid.String() != testutil.One.Id {
+		t.Fatal("it should return id of existing object:", err)
+	}
+	stat, _ := os.Stat(testutil.One.File)
+	if !stat.ModTime().After(old.Add(time.Minute)) {
+		t.Error("it should freshen the existing object:", stat.ModTime())
+	}
+	content, _ := ioutil.ReadFile(testutil.One.File)
+	if !bytes.Equal(content, testutil.One.Bytes) {
+		t.Error("it should not rewrite the existing object")
+	}
+}
+
+func Test_LooseOdb_ForEachExt(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/blametest.git")
+	defer testutil.CleanupWorkspace()
+	odb, _ := OdbOpen("test_resources/blametest.git/objects")
+	checkId, _ := NewOid("0cbab4d45fd61e55a1c9697f9f9cb07a12e15448")
+	found := false
+	err := odb.ForEachExt(OdbForEachHeader|OdbForEachDiskSize, func(info *OdbObjectInfo) error {
+		if checkId.Equal(info.Id) {
+			found = true
+			objType, size, _ := odb.ReadHeader(info.Id)
+			if info.Type != objType || info.Size != size || info.DiskSize == 0 || info.PackFile != "" {
+				t.Error("it should report the header and the file size:", info.Type, info.Size, info.DiskSize)
+			}
+		}
+		return nil
+	})
+	if err != nil || !found {
+		t.Error("it should find the loose object:", err)
+	}
+}
+
+func Test_LooseDirectoryCache(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-objects")
+	defer testutil.CleanupEmptyWorkDir()
+	backend := NewOdbBackendLoose("test-objects", -1, false, 0, 0)
+
+	oid, _ := backend.Write([]byte("Test data\n"), ObjectBlob)
+	prefix, _ := NewOidFromPrefix(oid.String()[:6])
+	found, err := backend.ExistsPrefix(prefix, 6)
+	if err != nil || !found.Equal(oid) {
+		t.Fatal("it should find the written object:", err)
+	}
+	if names, _ := backend.dirCacheSize(); names != 1 {
+		t.Error("it should cache the names of the directory:", names)
+	}
+
+	// other processes write objects and temporary files
+	dirPath := filepath.Join("test-objects", "67")
+	content, _ := ioutil.ReadFile(filepath.Join(dirPath, "b808feb36201507a77f85e6d898f0a2836e4a5"))
+	ioutil.WriteFile(filepath.Join(dirPath, "b808fe"+strings.Repeat("0", 32)), content, 0444)
+	ioutil.WriteFile(filepath.Join(dirPath, "0123456789abcdef0123456789abcdef012345"), content, 0444)
+	ioutil.WriteFile(filepath.Join(dirPath, "tmp_obj_123"), content, 0444)
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(dirPath, future, future)
+
+	if _, err := backend.ExistsPrefix(prefix, 6); err != nil {
+		t.Error("it should answer from the cache until the refresh:", err)
+	}
+	other, _ := NewOidFromPrefix("670123")
+	found, err = backend.ExistsPrefix(other, 6)
+	if err != nil || found.String() != "670123456789abcdef0123456789abcdef012345" {
+		t.Error("it should read the changed directory when the prefix is missing:", err)
+	}
+	backend.Refresh()
+	if _, err := backend.ExistsPrefix(prefix, 6); !IsErrorCode(err, ErrAmbiguous) {
+		t.Error("it should read the directory again after the refresh:", err)
+	}
+
+	stats, err := backend.FanoutStats()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if stats.Total != 3 || stats.Counts[0x67] != 3 || stats.Directories != 1 || stats.Max != 3 {
+		t.Error("it should count the objects of the directories:", stats.Total, stats.Directories, stats.Max)
+	}
+
+	backend.SetDirectoryCache(false)
+	if names, _ := backend.dirCacheSize(); names != 0 {
+		t.Error("it should drop the names when it is disabled:", names)
+	}
+	backend.ExistsPrefix(prefix, 6)
+	if names, _ := backend.dirCacheSize(); names != 0 {
+		t.Error("it should not cache when it is disabled:", names)
+	}
+}
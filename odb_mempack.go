@@ -0,0 +1,179 @@
+package git4go
+
+import (
+	"fmt"
+	"sync"
+)
+
+// OdbBackendMemPack keeps objects in memory. It is used by the in-memory
+// repository, and it can be added to an on-disk Odb to stage objects that
+// should not be written as loose files.
+type OdbBackendMemPack struct {
+	OdbBackendBase
+	lock    sync.RWMutex
+	objects map[Oid]*memObject
+}
+
+type memObject struct {
+	objType ObjectType
+	data    []byte
+}
+
+func NewOdbBackendMemPack() *OdbBackendMemPack {
+	return &OdbBackendMemPack{
+		objects: make(map[Oid]*memObject),
+	}
+}
+
+func (o *OdbBackendMemPack) Read(oid *Oid) (*OdbObject, error) {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+
+	obj, ok := o.objects[*oid]
+	if !ok {
+		return nil, MakeGitError(fmt.Sprintf("no match for id: %s", oid.String()), ErrNotFound)
+	}
+	return obj.toOdbObject(), nil
+}
+
+func (o *OdbBackendMemPack) ReadPrefix(shortOid *Oid, length int) (*Oid, *OdbObject, error) {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+
+	oid, obj, err := o.findPrefix(shortOid, length)
+	if err != nil {
+		return nil, nil, err
+	}
+	return oid, obj.toOdbObject(), nil
+}
+
+func (o *OdbBackendMemPack) ReadHeader(oid *Oid) (ObjectType, uint64, error) {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+
+	obj, ok := o.objects[*oid]
+	if !ok {
+		return ObjectBad, 0, MakeGitError(fmt.Sprintf("no match for id: %s", oid.String()), ErrNotFound)
+	}
+	return obj.objType, uint64(len(obj.data)), nil
+}
+
+func (o *OdbBackendMemPack) Write(data []byte, objType ObjectType) (*Oid, error) {
+	oid, err := hash(data, objType)
+	if err != nil {
+		return nil, err
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if _, ok := o.objects[*oid]; !ok {
+		stored := make([]byte, len(data))
+		copy(stored, data)
+		o.objects[*oid] = &memObject{objType: objType, data: stored}
+	}
+	return oid, nil
+}
+
+func (o *OdbBackendMemPack) Freshen(oid *Oid) error {
+	if !o.Exists(oid) {
+		return MakeGitError(fmt.Sprintf("no match for id: %s", oid.String()), ErrNotFound)
+	}
+	return nil
+}
+
+func (o *OdbBackendMemPack) Exists(oid *Oid) bool {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+
+	_, ok := o.objects[*oid]
+	return ok
+}
+
+func (o *OdbBackendMemPack) ExistsPrefix(shortOid *Oid, length int) (*Oid, error) {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+
+	oid, _, err := o.findPrefix(shortOid, length)
+	return oid, err
+}
+
+func (o *OdbBackendMemPack) Refresh() error {
+	return nil
+}
+
+func (o *OdbBackendMemPack) ForEach(callback OdbForEachCallback) error {
+	o.lock.RLock()
+	oids := make([]*Oid, 0, len(o.objects))
+	for oid := range o.objects {
+		oids = append(oids, oid.Copy())
+	}
+	o.lock.RUnlock()
+
+	for _, oid := range oids {
+		err := callback(oid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset drops all objects that are stored in the backend.
+func (o *OdbBackendMemPack) Reset() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.objects = make(map[Oid]*memObject)
+}
+
+// internal functions and methods
+
+func (o *OdbBackendMemPack) forEachExt(flags OdbForEachFlag, callback OdbForEachExtCallback) error {
+	o.lock.RLock()
+	infos := make([]*OdbObjectInfo, 0, len(o.objects))
+	for oid, obj := range o.objects {
+		infos = append(infos, &OdbObjectInfo{
+			Id:       oid.Copy(),
+			Type:     obj.objType,
+			Size:     uint64(len(obj.data)),
+			DiskSize: uint64(len(obj.data)),
+		})
+	}
+	o.lock.RUnlock()
+
+	for _, info := range infos {
+		err := callback(info)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *OdbBackendMemPack) findPrefix(shortOid *Oid, length int) (*Oid, *memObject, error) {
+	var foundId *Oid
+	var found *memObject
+	for oid, obj := range o.objects {
+		if oid.NCmp(shortOid, uint(length)) != 0 {
+			continue
+		}
+		if found != nil {
+			return nil, nil, MakeGitError("found multiple objects for: "+shortOid.String(), ErrAmbiguous)
+		}
+		foundId = oid.Copy()
+		found = obj
+	}
+	if found == nil {
+		return nil, nil, MakeGitError("no match for prefix: "+shortOid.String(), ErrNotFound)
+	}
+	return foundId, found, nil
+}
+
+func (m *memObject) toOdbObject() *OdbObject {
+	data := make([]byte, len(m.data))
+	copy(data, m.data)
+	return &OdbObject{
+		Type: m.objType,
+		Data: data,
+	}
+}
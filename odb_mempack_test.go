@@ -0,0 +1,37 @@
+package git4go
+
+import (
+	"testing"
+)
+
+func Test_OdbMemPack(t *testing.T) {
+	odb := NewOdb()
+	backend := NewOdbBackendMemPack()
+	odb.AddBackend(backend, GitLoosePriority)
+
+	oid, err := odb.Write([]byte("test content\n"), ObjectBlob)
+	if err != nil {
+		t.Fatal("it should write object:", err)
+	}
+	if oid.String() != "d670460b4b4aece5915caf5c68d12f560a9fe3e4" {
+		t.Error("it should return the same id as git:", oid.String())
+	}
+	obj, err := odb.Read(oid)
+	if err != nil || obj.Type != ObjectBlob || string(obj.Data) != "test content\n" {
+		t.Error("it should read written object:", err)
+	}
+	objType, size, err := odb.ReadHeader(oid)
+	if err != nil || objType != ObjectBlob || size != 13 {
+		t.Error("it should read header:", objType, size, err)
+	}
+	shortOid, _ := NewOidFromPrefix("d670460")
+	foundId, _, err := odb.ReadPrefix(shortOid, 7)
+	if err != nil || !foundId.Equal(oid) {
+		t.Error("it should find object by prefix:", err)
+	}
+	backend.Reset()
+	_, err = odb.Read(oid)
+	if !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should drop objects after Reset():", err)
+	}
+}
@@ -1,6 +1,75 @@
 package git4go
 
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"sync"
+)
+
 type OdbObject struct {
-	Type ObjectType
-	Data []byte
+	Type   ObjectType
+	Data   []byte
+	buffer *bytes.Buffer
+}
+
+// Release returns the buffer that holds Data to the pool. Data (and any
+// slices of it) must not be used after calling it. Releasing is optional;
+// objects that are never released are simply garbage collected.
+func (o *OdbObject) Release() {
+	if o.buffer != nil {
+		putBuffer(o.buffer)
+		o.buffer = nil
+	}
+	o.Data = nil
+}
+
+func copyOdbObject(obj *OdbObject) *OdbObject {
+	data := make([]byte, len(obj.Data))
+	copy(data, obj.Data)
+	return &OdbObject{
+		Type: obj.Type,
+		Data: data,
+	}
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+var zlibReaderPool sync.Pool
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buffer *bytes.Buffer) {
+	buffer.Reset()
+	bufferPool.Put(buffer)
+}
+
+// inflate decompresses the zlib stream into a pooled buffer.
+func inflate(source io.Reader) (*bytes.Buffer, error) {
+	var reader io.ReadCloser
+	var err error
+	if pooled := zlibReaderPool.Get(); pooled != nil {
+		reader = pooled.(io.ReadCloser)
+		err = reader.(zlib.Resetter).Reset(source, nil)
+	} else {
+		reader, err = zlib.NewReader(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	buffer := getBuffer()
+	_, err = io.Copy(buffer, reader)
+	reader.Close()
+	zlibReaderPool.Put(reader)
+	if err != nil {
+		putBuffer(buffer)
+		return nil, err
+	}
+	return buffer, nil
 }
@@ -5,15 +5,21 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 type OdbBackendPacked struct {
 	OdbBackendBase
+	lock       sync.Mutex
 	packFolder string
 	packs      []*PackFile
 	lastFound  *PackFile
+	notify     func(event *RepositoryEvent)
 }
 
+// NewOdbBackendPacked reads the packs of objects/pack. The pack directory is
+// read from the disk and not through a FileSystem, because the packs are
+// memory mapped.
 func NewOdbBackendPacked(objectsDir string) *OdbBackendPacked {
 	folderPath := filepath.Join(objectsDir, "pack")
 	info, err := os.Stat(folderPath)
@@ -33,7 +39,10 @@ func (o *OdbBackendPacked) Read(oid *Oid) (*OdbObject, error) {
 		return nil, err
 	}
 	obj, _, err := entry.PackFile.unpack(entry.Offset)
-	return obj, err
+	if err != nil {
+		return nil, newObjectCorruptError(oid, entry.PackFile.packName, err)
+	}
+	return obj, nil
 }
 
 func (o *OdbBackendPacked) ReadPrefix(shortOid *Oid, length int) (*Oid, *OdbObject, error) {
@@ -42,7 +51,10 @@ func (o *OdbBackendPacked) ReadPrefix(shortOid *Oid, length int) (*Oid, *OdbObje
 		return nil, nil, err
 	}
 	obj, _, err := entry.PackFile.unpack(entry.Offset)
-	return entry.Sha1, obj, err
+	if err != nil {
+		return nil, nil, newObjectCorruptError(entry.Sha1, entry.PackFile.packName, err)
+	}
+	return entry.Sha1, obj, nil
 }
 
 func (o *OdbBackendPacked) ReadHeader(oid *Oid) (ObjectType, uint64, error) {
@@ -51,13 +63,26 @@ func (o *OdbBackendPacked) ReadHeader(oid *Oid) (ObjectType, uint64, error) {
 		return ObjectBad, 0, err
 	}
 	objType, size, err := entry.PackFile.resolveHeader(entry.Offset)
-	return objType, size, err
+	if err != nil {
+		return ObjectBad, 0, newObjectCorruptError(oid, entry.PackFile.packName, err)
+	}
+	return objType, size, nil
 }
 
 func (o *OdbBackendPacked) Write(data []byte, objType ObjectType) (*Oid, error) {
 	return nil, errors.New("not implemented")
 }
 
+// Freshen touches the packfile that has the object, at most once in
+// packFreshenInterval.
+func (o *OdbBackendPacked) Freshen(oid *Oid) error {
+	entry, err := o.findEntry(oid)
+	if err != nil {
+		return err
+	}
+	return entry.PackFile.freshen()
+}
+
 func (o *OdbBackendPacked) Exists(oid *Oid) bool {
 	_, err := o.findEntry(oid)
 	return err == nil
@@ -73,6 +98,9 @@ func (o *OdbBackendPacked) ExistsPrefix(shortOid *Oid, length int) (*Oid, error)
 }
 
 func (o *OdbBackendPacked) Refresh() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
 	dir, err := os.Open(o.packFolder)
 	defer dir.Close()
 	if err != nil {
@@ -86,6 +114,7 @@ func (o *OdbBackendPacked) Refresh() error {
 	if err != nil {
 		return errors.New("failed to refresh packfiles")
 	}
+	count := len(o.packs)
 	for _, name := range names {
 		if !strings.HasSuffix(name, ".idx") {
 			continue
@@ -103,10 +132,14 @@ func (o *OdbBackendPacked) Refresh() error {
 			continue
 		}
 		pack, err := GetPack(path)
-		if err == nil {
+		if err == nil && !containsPack(o.packs, pack) {
+			// a pack that another object database opened first has its path
 			o.packs = append(o.packs, pack)
 		}
 	}
+	if len(o.packs) != count && o.notify != nil {
+		o.notify(&RepositoryEvent{Type: RepositoryEventPacksChanged})
+	}
 	return nil
 }
 
@@ -115,7 +148,10 @@ func (o *OdbBackendPacked) ForEach(callback OdbForEachCallback) error {
 	if err != nil {
 		return err
 	}
-	for _, pack := range o.packs {
+	o.lock.Lock()
+	packs := o.packs
+	o.lock.Unlock()
+	for _, pack := range packs {
 		err = pack.forEach(callback)
 		if err != nil {
 			return err
@@ -126,6 +162,36 @@ func (o *OdbBackendPacked) ForEach(callback OdbForEachCallback) error {
 
 // internal functions
 
+func (o *OdbBackendPacked) forEachExt(flags OdbForEachFlag, callback OdbForEachExtCallback) error {
+	err := o.Refresh()
+	if err != nil {
+		return err
+	}
+	o.lock.Lock()
+	packs := o.packs
+	o.lock.Unlock()
+	for _, pack := range packs {
+		err = pack.forEachExt(flags, callback)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *OdbBackendPacked) expandIds(requests []*expandRequest) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	for _, pack := range o.packs {
+		err := pack.expandIds(requests)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (o *OdbBackendPacked) findEntry(oid *Oid) (*PackEntry, error) {
 	entry, notFound, err := o.findEntryInternal(oid)
 	if err == nil {
@@ -142,6 +208,11 @@ func (o *OdbBackendPacked) findEntry(oid *Oid) (*PackEntry, error) {
 }
 
 func (o *OdbBackendPacked) findEntryInternal(oid *Oid) (*PackEntry, bool, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	addCounter(MetricPackLookups, 1)
+
 	if o.lastFound != nil {
 		entry, notFound, err := o.lastFound.findEntry(oid, GitOidHexSize)
 		if !notFound && err != nil {
@@ -183,6 +254,9 @@ func (o *OdbBackendPacked) findEntryByPrefix(shortOid *Oid, length int) (*PackEn
 }
 
 func (o *OdbBackendPacked) findEntryByPrefixInternal(shortOid *Oid, length int) (*PackEntry, bool, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
 	var foundEntry *PackEntry = nil
 	if o.lastFound != nil {
 		entry, notFound, err := o.lastFound.findEntry(shortOid, length)
@@ -200,8 +274,9 @@ func (o *OdbBackendPacked) findEntryByPrefixInternal(shortOid *Oid, length int)
 		}
 		if err == nil {
 			if foundEntry != nil && !foundEntry.Sha1.Equal(entry.Sha1) {
-				return nil, false, errors.New("found multiple pack entries for: " + shortOid.String())
+				return nil, false, MakeGitError("found multiple pack entries for: "+shortOid.String(), ErrAmbiguous)
 			}
+			foundEntry = entry
 			o.lastFound = pack
 		}
 	}
@@ -211,3 +286,12 @@ func (o *OdbBackendPacked) findEntryByPrefixInternal(shortOid *Oid, length int)
 		return nil, true, errors.New("failed to find pack entry: " + shortOid.String())
 	}
 }
+
+func containsPack(packs []*PackFile, pack *PackFile) bool {
+	for _, existing := range packs {
+		if existing == pack {
+			return true
+		}
+	}
+	return false
+}
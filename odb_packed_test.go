@@ -116,4 +116,72 @@ func Test_PackedOdb_ForEach(t *testing.T) {
 	if !found {
 		t.Error("target id is not found")
 	}
+
+	count := 0
+	err := odb.ForEach(func(oid *Oid) error {
+		count++
+		if count == 100 {
+			return StopIteration
+		}
+		return nil
+	})
+	if err != nil || count != 100 {
+		t.Error("it should stop the iteration without an error:", count, err)
+	}
+}
+
+func Test_PackedOdb_ReadWithSmallWindows(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	windowSize := MWindowSize()
+	mappedLimit := MWindowMappedLimit()
+	SetMWindowSize(0)
+	SetMWindowMappedLimit(MWindowSize())
+	defer func() {
+		SetMWindowSize(windowSize)
+		SetMWindowMappedLimit(mappedLimit)
+	}()
+
+	odb, _ := OdbOpen("test_resources/testrepo.git/objects")
+	for i, packedObject := range testutil.PackedObjects {
+		oid, _ := NewOid(packedObject)
+		obj, err := odb.Read(oid)
+		if err != nil {
+			t.Error("err should be nil: ", i, err)
+		} else if obj == nil {
+			t.Error("Can't read object", i)
+		}
+	}
+}
+
+func Test_PackedOdb_ForEachExt(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+	odb, _ := OdbOpen("test_resources/testrepo.git/objects")
+
+	count := 0
+	packed := 0
+	err := odb.ForEachExt(OdbForEachHeader|OdbForEachDiskSize, func(info *OdbObjectInfo) error {
+		count++
+		objType, size, err := info.Backend.ReadHeader(info.Id)
+		if err != nil || objType != info.Type || size != info.Size {
+			t.Error("it should report the header of the object:", info.Id.String(), info.Type, info.Size)
+			return StopIteration
+		}
+		if info.PackFile != "" {
+			packed++
+			if info.DiskSize == 0 || info.Offset == 0 {
+				t.Error("it should report where the object is stored:", info.Id.String())
+				return StopIteration
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if count != 1640+47 || packed != 1640 {
+		t.Error("it should report all objects:", count, packed)
+	}
 }
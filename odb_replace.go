@@ -0,0 +1,94 @@
+package git4go
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	GitRefsReplaceDir = "refs/replace/"
+	// how many replacements of a replacement are followed
+	GitReplaceMaxDepth = 5
+)
+
+// OdbReplaceCallback returns the id of the object that is read instead of
+// oid, or nil to read oid itself.
+type OdbReplaceCallback func(oid *Oid) *Oid
+
+// SetReplaceCallback sets the callback that substitutes the objects that
+// Read, ReadHeader and ReadPrefix return. The replacement is read under the
+// requested id, so it is transparent to the callers, like refs/replace of
+// git. Packing and indexing always read the objects themselves. It should
+// be set before objects are read, because parsed commits are cached by id.
+func (o *Odb) SetReplaceCallback(callback OdbReplaceCallback) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.replaceObject = callback
+}
+
+// EnableReplaceRefs makes the object database of the repository read the
+// objects that refs/replace/<id> points to instead of <id>. The references
+// are read once; call it again after they are changed.
+func (r *Repository) EnableReplaceRefs() error {
+	odb, err := r.Odb()
+	if err != nil {
+		return err
+	}
+	replacements := make(map[Oid]*Oid)
+	err = r.ForEachGlobReference(GitRefsReplaceDir+"*", func(ref *Reference) error {
+		id, err := NewOid(strings.TrimPrefix(ref.Name(), GitRefsReplaceDir))
+		if err != nil {
+			return nil // not a replace ref
+		}
+		resolved, err := ref.Resolve()
+		if err != nil {
+			return err
+		}
+		replacements[*id] = resolved.Target()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(replacements) == 0 {
+		odb.SetReplaceCallback(nil)
+	} else {
+		odb.SetReplaceCallback(func(oid *Oid) *Oid {
+			return replacements[*oid]
+		})
+	}
+	r.commitCache.Clear()
+	r.graphCache.clear()
+	return nil
+}
+
+// internal functions and methods
+
+// hasReplacements tells if a replace callback is set.
+func (o *Odb) hasReplacements() bool {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+
+	return o.replaceObject != nil
+}
+
+// replacement returns the id of the object that is read for oid. Chains of
+// replacements are followed up to GitReplaceMaxDepth.
+func (o *Odb) replacement(oid *Oid) (*Oid, error) {
+	o.lock.RLock()
+	callback := o.replaceObject
+	o.lock.RUnlock()
+	if callback == nil {
+		return oid, nil
+	}
+	current := oid
+	for depth := 0; depth <= GitReplaceMaxDepth; depth++ {
+		next := callback(current)
+		if next == nil || next.Equal(current) {
+			return current, nil
+		}
+		current = next
+	}
+	return nil, MakeGitError(fmt.Sprintf("replace depth too high for object %s", oid.String()), ErrInvalid)
+}
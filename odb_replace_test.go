@@ -0,0 +1,77 @@
+package git4go
+
+import (
+	"./testutil"
+	"bytes"
+	"testing"
+)
+
+func Test_OdbReplaceCallback(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	odb, _ := OdbOpen("test_resources/testrepo.git/objects")
+	original, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	replacement, _ := NewOid("be3563ae3f795b2b4353bcce3a527ad0a4f7f644")
+	expected, _ := odb.Read(replacement)
+	odb.SetReplaceCallback(func(oid *Oid) *Oid {
+		if oid.Equal(original) {
+			return replacement
+		}
+		return nil
+	})
+
+	obj, err := odb.Read(original)
+	if err != nil || !bytes.Equal(obj.Data, expected.Data) {
+		t.Error("it should read the replacement:", err)
+	}
+	_, size, err := odb.ReadHeader(original)
+	if err != nil || size != uint64(len(expected.Data)) {
+		t.Error("it should read the header of the replacement:", size, err)
+	}
+	id, obj, err := odb.ReadPrefix(original, 8)
+	if err != nil || !id.Equal(original) || !bytes.Equal(obj.Data, expected.Data) {
+		t.Error("it should resolve the prefix and read the replacement:", err)
+	}
+	obj, _ = odb.readNoReplace(original)
+	if bytes.Equal(obj.Data, expected.Data) {
+		t.Error("it should read the object itself without replacement")
+	}
+
+	odb.SetReplaceCallback(func(oid *Oid) *Oid {
+		if oid.Equal(original) {
+			return replacement
+		}
+		return original
+	})
+	_, err = odb.Read(original)
+	if !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should stop at the cycle of replacements:", err)
+	}
+}
+
+func Test_EnableReplaceRefs(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	original, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	replacement, _ := NewOid("be3563ae3f795b2b4353bcce3a527ad0a4f7f644")
+	expected, _ := repo.LookupCommit(replacement)
+	before, _ := repo.LookupCommit(original)
+	if before.Message() == expected.Message() {
+		t.Fatal("test setup error: the commits should differ")
+	}
+	_, err := repo.CreateReference(GitRefsReplaceDir+original.String(), replacement, false)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	err = repo.EnableReplaceRefs()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	commit, err := repo.LookupCommit(original)
+	if err != nil || commit.Message() != expected.Message() {
+		t.Error("it should read the commit that refs/replace points to:", err)
+	}
+}
@@ -2,7 +2,14 @@ package git4go
 
 import (
 	"./testutil"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func Test_OdbHash(t *testing.T) {
@@ -31,3 +38,206 @@ func Test_OdbHash(t *testing.T) {
 		}
 	}
 }
+
+func Test_OdbWithData(t *testing.T) {
+	testutil.PrepareEmptyWorkDir("test-objects")
+	defer testutil.CleanupEmptyWorkDir()
+	odb, _ := OdbOpen("test-objects")
+
+	testEntries := []*testutil.ObjectData{
+		&testutil.One,
+		&testutil.Commit,
+		&testutil.Tree,
+	}
+	for i := 0; i < 2; i++ {
+		for _, entry := range testEntries {
+			entry.Write()
+			oid, _ := NewOid(entry.Id)
+			err := odb.WithData(oid, func(objType ObjectType, data []byte) error {
+				if objType != TypeString2Type(entry.Type) {
+					t.Error("Type should be same: ", entry.Name)
+				}
+				if bytes.Compare(data, entry.Data) != 0 {
+					t.Error("Data should be same: ", entry.Name)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Error("Error should be nil: ", err, entry.Name)
+			}
+		}
+	}
+}
+
+func Test_OdbObjectRelease(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+	odb, _ := OdbOpen("test_resources/testrepo.git/objects")
+
+	for i, packedObject := range testutil.PackedObjects {
+		oid, _ := NewOid(packedObject)
+		obj, err := odb.Read(oid)
+		if err != nil {
+			t.Error("err should be nil: ", i, err)
+			continue
+		}
+		_, size, _ := odb.ReadHeader(oid)
+		if uint64(len(obj.Data)) != size {
+			t.Error("size is wrong", i, len(obj.Data), size)
+		}
+		obj.Release()
+		if obj.Data != nil {
+			t.Error("it should clear data after releasing")
+		}
+	}
+}
+
+type blockingBackend struct {
+	*OdbBackendMemPack
+	reads   int32
+	release chan struct{}
+}
+
+func (b *blockingBackend) Read(oid *Oid) (*OdbObject, error) {
+	atomic.AddInt32(&b.reads, 1)
+	<-b.release
+	return b.OdbBackendMemPack.Read(oid)
+}
+
+func Test_OdbReadCoalescing(t *testing.T) {
+	backend := &blockingBackend{
+		OdbBackendMemPack: NewOdbBackendMemPack(),
+		release:           make(chan struct{}),
+	}
+	odb := NewOdb()
+	odb.AddBackend(backend, GitLoosePriority)
+	oid, _ := odb.Write([]byte("shared content"), ObjectBlob)
+
+	const readers = 8
+	var wg sync.WaitGroup
+	results := make([]*OdbObject, readers)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = odb.Read(oid)
+		}(i)
+	}
+	for {
+		odb.flights.lock.Lock()
+		call := odb.flights.calls[flightKey{oid: *oid}]
+		waiting := call != nil && call.waiter == readers-1
+		odb.flights.lock.Unlock()
+		if waiting {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(backend.release)
+	wg.Wait()
+
+	if backend.reads != 1 {
+		t.Error("it should read the object only once:", backend.reads)
+	}
+	for _, obj := range results {
+		if obj == nil || string(obj.Data) != "shared content" {
+			t.Fatal("every reader should get the object")
+		}
+	}
+	results[0].Data[0] = 'X'
+	if string(results[1].Data) != "shared content" {
+		t.Error("readers should not share the data buffer")
+	}
+}
+
+func Test_OdbExpandIds(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	odb, _ := OdbOpen("test_resources/testrepo.git/objects")
+	shortId := func(prefix string, objType ObjectType) ShortId {
+		oid, _ := NewOidFromPrefix(prefix)
+		return ShortId{Id: *oid, Length: len(prefix), Type: objType}
+	}
+	ids := []ShortId{
+		shortId("a65fedf3", ObjectAny),
+		shortId("001d938d", ObjectBlob),
+		shortId("0266163a", 0),
+		shortId("1810", ObjectAny),
+		shortId("deadbeef", ObjectAny),
+		shortId("a65fedf3", ObjectTree),
+	}
+	ids = append(ids, ShortId{Id: ids[0].Id, Length: 2})
+	err := odb.ExpandIds(ids)
+	if err != nil {
+		t.Fatal("it should expand ids:", err)
+	}
+	if ids[0].Id.String() != "a65fedf39aefe402d3bb6e24df4d4f5fe4547750" || ids[0].Length != 40 || ids[0].Type != ObjectCommit {
+		t.Error("it should expand loose object:", ids[0])
+	}
+	if ids[1].Id.String() != "001d938dbe69b6251f4a03cf374235c72fd0a0d2" || ids[1].Type != ObjectBlob {
+		t.Error("it should expand packed object:", ids[1])
+	}
+	if ids[2].Id.String() != "0266163a49e280c4f5ed1e08facd36a2bd716bcf" {
+		t.Error("it should expand object without type:", ids[2])
+	}
+	for i, name := range []string{"ambiguous", "missing", "wrong type", "too short"} {
+		if ids[i+3].Length != 0 || !ids[i+3].Id.IsZero() {
+			t.Error("it should clear "+name+" id:", ids[i+3])
+		}
+	}
+}
+
+func Test_Repository_SetOdb(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+	dir, _ := ioutil.TempDir("", "git4go_setodb")
+	defer os.RemoveAll(dir)
+
+	base, _ := OpenRepository("test_resources/testrepo.git")
+	baseOdb, _ := base.Odb()
+	fork, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{Bare: true, NoTemplate: true, Hermetic: true})
+	baseObjects, _ := filepath.Abs("test_resources/testrepo.git/objects")
+	relative, _ := filepath.Rel(filepath.Join(dir, "objects"), baseObjects)
+	os.MkdirAll(filepath.Join(dir, "objects", "info"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "objects", GitAlternatesFile), []byte(relative+"\n"), 0644)
+
+	packedId, _ := NewOid(testutil.PackedObjects[0])
+	forkOdb, _ := fork.Odb()
+	if _, err := forkOdb.Read(packedId); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	baseOdb.Read(packedId)
+	basePacks := base.openedPacks()
+	forkPacks := fork.openedPacks()
+	if len(basePacks) == 0 || len(forkPacks) != len(basePacks) || basePacks[0] != forkPacks[0] {
+		t.Error("the fork should share the packfiles of its alternate")
+	}
+
+	other, _ := OpenRepository("test_resources/testrepo.git")
+	other.SetOdb(baseOdb)
+	if odb, _ := other.Odb(); odb != baseOdb {
+		t.Fatal("it should use the shared object database")
+	}
+	var baseEvents, otherEvents int
+	base.Subscribe(func(event *RepositoryEvent) {
+		if event.Type == RepositoryEventObjectWritten {
+			baseEvents++
+		}
+	})
+	other.Subscribe(func(event *RepositoryEvent) {
+		if event.Type == RepositoryEventObjectWritten {
+			otherEvents++
+		}
+	})
+	other.CreateBlobFromBuffer([]byte("shared\n"))
+	if baseEvents != 1 || otherEvents != 1 {
+		t.Error("it should notify all repositories of the shared object database:", baseEvents, otherEvents)
+	}
+
+	other.SetOdb(forkOdb)
+	base.CreateBlobFromBuffer([]byte("shared again\n"))
+	if baseEvents != 2 || otherEvents != 1 {
+		t.Error("it should stop notifying the repository that replaced the database:", baseEvents, otherEvents)
+	}
+}
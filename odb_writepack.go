@@ -0,0 +1,69 @@
+package git4go
+
+import (
+	"errors"
+)
+
+// OdbBackendWritePacker is implemented by backends that can store a pack
+// that is received as a stream.
+type OdbBackendWritePacker interface {
+	WritePack(odb *Odb, progress IndexerProgressCallback) (*OdbWritepack, error)
+}
+
+// OdbWritepack streams a received pack into the object database. The pack
+// is written to it, like to an io.Writer, and Commit makes the objects
+// available. Close discards a pack that is not committed.
+type OdbWritepack struct {
+	indexer *Indexer
+	backend OdbBackend
+}
+
+// WritePack returns a writer that stores a pack in the first backend that
+// can store packs, which is the packed backend of the objects directory.
+// The pack is indexed with the Indexer, so thin packs are fixed with the
+// objects of this database.
+func (o *Odb) WritePack(progress IndexerProgressCallback) (*OdbWritepack, error) {
+	if o.readOnly {
+		return nil, errReadOnly("Odb.WritePack")
+	}
+	for _, backend := range o.backendList() {
+		if backend.IsAlternate() {
+			continue
+		}
+		if writePacker, ok := backend.(OdbBackendWritePacker); ok {
+			return writePacker.WritePack(o, progress)
+		}
+	}
+	return nil, errors.New("Odb.WritePack: no backend can write packs")
+}
+
+func (w *OdbWritepack) Write(data []byte) (int, error) {
+	return w.indexer.Write(data)
+}
+
+func (w *OdbWritepack) Stats() IndexerStats {
+	return w.indexer.Stats()
+}
+
+// Commit stores the pack and its index and returns the checksum that names
+// the pack.
+func (w *OdbWritepack) Commit() (*Oid, error) {
+	checksum, err := w.indexer.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return checksum, w.backend.Refresh()
+}
+
+func (w *OdbWritepack) Close() {
+	w.indexer.Close()
+}
+
+func (o *OdbBackendPacked) WritePack(odb *Odb, progress IndexerProgressCallback) (*OdbWritepack, error) {
+	indexer := NewIndexer(o.packFolder, odb)
+	indexer.SetProgressCallback(progress)
+	return &OdbWritepack{
+		indexer: indexer,
+		backend: o,
+	}, nil
+}
@@ -0,0 +1,93 @@
+package git4go
+
+import (
+	"./testutil"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func Test_OdbWritePack(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/bitmap.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/bitmap.git")
+	blobs := writeSimilarBlobs(repo)
+	builder, _ := repo.NewPackBuilder()
+	for _, blob := range blobs {
+		builder.Insert(blob)
+	}
+	var pack bytes.Buffer
+	builder.Write(&pack)
+
+	target, _ := OpenRepository("test_resources/bitmap.git")
+	odb, _ := target.Odb()
+	if odb.Exists(blobs[0]) {
+		t.Fatal("the blob should not exist before the pack is written")
+	}
+	var calls int
+	writepack, err := odb.WritePack(func(stats IndexerStats) error {
+		calls++
+		return nil
+	})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	// the pack is streamed in small chunks
+	_, err = io.CopyBuffer(writepack, &pack, make([]byte, 64))
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if writepack.Stats().TotalObjects != 2 {
+		t.Error("it should read the object count from the header:", writepack.Stats())
+	}
+	_, err = writepack.Commit()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if calls == 0 {
+		t.Error("it should report progress")
+	}
+	for _, blob := range blobs {
+		if !odb.Exists(blob) {
+			t.Error("the blob should be readable from the new pack:", blob.String())
+		}
+	}
+	temp, _ := filepath.Glob("test_resources/bitmap.git/objects/pack/tmp_pack_*")
+	if len(temp) != 0 {
+		t.Error("temporary files should be removed:", temp)
+	}
+}
+
+func Test_OdbWritePackCancel(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/bitmap.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/bitmap.git")
+	var pack bytes.Buffer
+	builder, _ := repo.NewPackBuilder()
+	master, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	builder.InsertCommit(master)
+	builder.Write(&pack)
+
+	odb, _ := repo.Odb()
+	cancel := errors.New("cancel")
+	writepack, _ := odb.WritePack(func(stats IndexerStats) error {
+		if stats.IndexedObjects > 0 {
+			return cancel
+		}
+		return nil
+	})
+	writepack.Write(pack.Bytes())
+	_, err := writepack.Commit()
+	if err != cancel {
+		t.Error("it should stop with the error of the callback:", err)
+	}
+	entries, _ := ioutil.ReadDir("test_resources/bitmap.git/objects/pack")
+	if len(entries) != 3 {
+		t.Error("no file should be left:", len(entries))
+	}
+}
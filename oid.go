@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/hex"
 	"errors"
+	"sort"
 )
 
 type Oid [GitOidRawSize]byte
@@ -36,11 +37,14 @@ func NewOidFromPrefix(s string) (*Oid, error) {
 	if len(s) > GitOidHexSize {
 		return nil, errors.New("string is too long for oid")
 	}
+	length := len(s)
+	if length%2 == 1 {
+		s += "0"
+	}
 	slice, err := hex.DecodeString(s)
 	if err != nil {
 		return nil, err
 	}
-	length := len(s)
 
 	shortOid := new(Oid)
 	copy(shortOid[:], slice[:(length+1)/2])
@@ -61,7 +65,8 @@ func parseOidWithPrefix(buffer []byte, index int, prefix []byte) (*Oid, int) {
 	if buffer[index+prefixLength+GitOidHexSize] != '\n' {
 		return nil, index
 	}
-	oid, err := NewOid(string(buffer[index+prefixLength : index+prefixLength+GitOidHexSize]))
+	oid := new(Oid)
+	_, err := hex.Decode(oid[:], buffer[index+prefixLength:index+prefixLength+GitOidHexSize])
 	if err != nil {
 		return nil, index
 	}
@@ -100,13 +105,92 @@ func (oid *Oid) IsZero() bool {
 	return true
 }
 
+// NCmp compares the first n hex digits of two oids.
 func (oid *Oid) NCmp(oid2 *Oid, n uint) int {
+	if n > GitOidHexSize {
+		n = GitOidHexSize
+	}
 	result := bytes.Compare(oid[:n/2], oid2[:n/2])
 	if result == 0 && n%2 == 1 {
-		if (oid[n/2+1]^oid2[n/2+1])&0xf0 != 0 {
+		a := oid[n/2] >> 4
+		b := oid2[n/2] >> 4
+		if a < b {
+			return -1
+		} else if a > b {
 			return 1
 		}
 		return 0
 	}
 	return result
 }
+
+// OidShortener computes the minimal length that keeps all added oids
+// unique, like git_oid_shorten in libgit2.
+type OidShortener struct {
+	minLength int
+	length    int
+	oids      []*Oid
+}
+
+func NewOidShortener(minLength int) *OidShortener {
+	if minLength < GitOidMinimumPrefixLength {
+		minLength = GitOidMinimumPrefixLength
+	}
+	return &OidShortener{
+		minLength: minLength,
+		length:    minLength,
+	}
+}
+
+// Add registers the given hex oid and returns the minimal prefix length
+// that is needed to distinguish all oids added so far.
+func (s *OidShortener) Add(text string) (int, error) {
+	oid, err := NewOid(text)
+	if err != nil {
+		return 0, err
+	}
+	pos := sort.Search(len(s.oids), func(i int) bool {
+		return s.oids[i].Cmp(oid) >= 0
+	})
+	if pos < len(s.oids) && s.oids[pos].Equal(oid) {
+		return s.length, nil
+	}
+	if pos > 0 {
+		s.updateLength(s.oids[pos-1], oid)
+	}
+	if pos < len(s.oids) {
+		s.updateLength(s.oids[pos], oid)
+	}
+	s.oids = append(s.oids, nil)
+	copy(s.oids[pos+1:], s.oids[pos:])
+	s.oids[pos] = oid
+	return s.length, nil
+}
+
+func (s *OidShortener) Length() int {
+	return s.length
+}
+
+func (s *OidShortener) updateLength(oid1, oid2 *Oid) {
+	length := commonPrefixLength(oid1, oid2) + 1
+	if length > GitOidHexSize {
+		length = GitOidHexSize
+	}
+	if length > s.length {
+		s.length = length
+	}
+}
+
+func commonPrefixLength(oid1, oid2 *Oid) int {
+	for i := 0; i < GitOidRawSize; i++ {
+		diff := oid1[i] ^ oid2[i]
+		if diff == 0 {
+			continue
+		}
+		if diff&0xf0 != 0 {
+			return i * 2
+		}
+		return i*2 + 1
+	}
+	return GitOidHexSize
+}
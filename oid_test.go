@@ -0,0 +1,65 @@
+package git4go
+
+import (
+	"testing"
+)
+
+func Test_OidNCmp(t *testing.T) {
+	oid1, _ := NewOid("16a0123456789abcdef4b775213c23a8bd74f5e0")
+	oid2, _ := NewOid("16a0123456789abcdef4b775213c23a8bd74f5e1")
+	oid3, _ := NewOid("16a1123456789abcdef4b775213c23a8bd74f5e0")
+
+	if oid1.NCmp(oid2, 39) != 0 {
+		t.Error("first 39 digits should be same")
+	}
+	if oid1.NCmp(oid2, 40) >= 0 {
+		t.Error("oid1 should be smaller than oid2")
+	}
+	if oid1.NCmp(oid3, 3) != 0 {
+		t.Error("first 3 digits should be same")
+	}
+	if oid1.NCmp(oid3, 4) >= 0 || oid3.NCmp(oid1, 4) <= 0 {
+		t.Error("4th digit should be different")
+	}
+}
+
+func Test_OidIsZero(t *testing.T) {
+	zero := new(Oid)
+	if !zero.IsZero() {
+		t.Error("new oid should be zero")
+	}
+	oid, _ := NewOid("16a0123456789abcdef4b775213c23a8bd74f5e0")
+	if oid.IsZero() {
+		t.Error("oid should not be zero")
+	}
+	if !NewOidFromBytes(oid[:]).Equal(oid) {
+		t.Error("oid from bytes should be equal")
+	}
+}
+
+func Test_OidShortener(t *testing.T) {
+	shortener := NewOidShortener(5)
+	length, _ := shortener.Add("22596363b3de40b06f981fb85d82312e8c0ed511")
+	if length != 5 {
+		t.Error("length should be minimum length:", length)
+	}
+	length, _ = shortener.Add("ce08fe4884650f067bd5703b6a59a8b3b3c99a09")
+	if length != 5 {
+		t.Error("length should be minimum length:", length)
+	}
+	length, _ = shortener.Add("ca8a1df5ebc5ee1e6c1e80b3feb3a8b3a14c3a1f")
+	if length != 5 {
+		t.Error("length should be minimum length:", length)
+	}
+	length, _ = shortener.Add("ce08fe4884650f067bd5703b6a59a8b3b3c90000")
+	if length != 37 {
+		t.Error("length should cover common prefix:", length)
+	}
+	length, err := shortener.Add("invalid")
+	if err == nil {
+		t.Error("err should not be nil")
+	}
+	if shortener.Length() != 37 {
+		t.Error("length should not be changed by error")
+	}
+}
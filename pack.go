@@ -2,7 +2,6 @@ package git4go
 
 import (
 	"bytes"
-	"compress/zlib"
 	"encoding/binary"
 	"errors"
 	"github.com/edsrzf/mmap-go"
@@ -10,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 	"unsafe"
@@ -30,6 +30,35 @@ type PackFile struct {
 
 	packName string
 	baseName string
+
+	lastFreshen time.Time
+
+	bitmapOnce sync.Once
+	bitmap     *packBitmapIndex
+	bitmapErr  error
+
+	revIndexOnce sync.Once
+	revPositions []int
+	revOffsets   []uint64
+}
+
+// Packfiles are not touched more often than this by freshen.
+const packFreshenInterval = 2 * time.Second
+
+func (p *PackFile) freshen() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(p.lastFreshen) < packFreshenInterval {
+		return nil
+	}
+	err := os.Chtimes(longPath(p.packName), now, now)
+	if err != nil {
+		return err
+	}
+	p.lastFreshen = now
+	return nil
 }
 
 func (p *PackFile) findEntry(shortOid *Oid, length int) (*PackEntry, bool, error) {
@@ -44,11 +73,9 @@ func (p *PackFile) findEntry(shortOid *Oid, length int) (*PackEntry, bool, error
 	if err != nil {
 		return nil, notFound, err
 	}
-	if p.mwf.file == nil {
-		err = p.open()
-		if err != nil {
-			return nil, false, err
-		}
+	err = p.open()
+	if err != nil {
+		return nil, false, err
 	}
 	return &PackEntry{
 		Offset:   offset,
@@ -60,12 +87,10 @@ func (p *PackFile) findEntry(shortOid *Oid, length int) (*PackEntry, bool, error
 func (p *PackFile) findOffset(shortOid *Oid, length int) (offsetOut uint64, foundOid *Oid, notFound bool, err error) {
 	notFound = true
 
-	if p.indexVersion == -1 {
-		err = p.openIndex()
-		if err != nil {
-			notFound = false
-			return
-		}
+	err = p.openIndex()
+	if err != nil {
+		notFound = false
+		return
 	}
 	level1 := *(*[]uint32)(unsafe.Pointer(&p.indexMap))
 	level1Offset := 0
@@ -115,7 +140,7 @@ func (p *PackFile) findOffset(shortOid *Oid, length int) (offsetOut uint64, foun
 		next := new(Oid)
 		copy(next[:], p.indexMap[current+stride:])
 		if shortOid.NCmp(next, uint(length)) == 0 {
-			err = errors.New("found multiple offsets for pack entry")
+			err = MakeGitError("found multiple offsets for pack entry", ErrAmbiguous)
 			return
 		}
 	}
@@ -124,6 +149,55 @@ func (p *PackFile) findOffset(shortOid *Oid, length int) (offsetOut uint64, foun
 	return
 }
 
+// expandIds sweeps the sorted oid table of the index with the sorted
+// requests. Each search starts where the previous one ended.
+func (p *PackFile) expandIds(requests []*expandRequest) error {
+	err := p.openIndex()
+	if err != nil {
+		return err
+	}
+	level1 := *(*[]uint32)(unsafe.Pointer(&p.indexMap))
+	level1Offset := 0
+	offset := 0
+	stride := 20
+	if p.indexVersion > 1 {
+		level1Offset = 2
+		offset = 8
+	} else {
+		stride = 24
+		offset += 4
+	}
+	offset += 4 * 256
+	table := p.indexMap[offset:]
+
+	var sweep uint32
+	for _, request := range requests {
+		shortId := request.shortId
+		firstId := int(shortId.Id[0])
+		hi := ntohl(level1[level1Offset+firstId])
+		var lo uint32
+		if firstId != 0 {
+			lo = ntohl(level1[level1Offset+firstId-1])
+		}
+		if lo < sweep {
+			lo = sweep
+		}
+		pos := sha1Position(table, stride, lo, hi, shortId.Id[:])
+		if pos < 0 {
+			pos = -1 - pos
+		}
+		sweep = uint32(pos)
+		for i := pos; i < p.numObjects; i++ {
+			oid := NewOidFromBytes(table[i*stride : i*stride+GitOidRawSize])
+			if shortId.Id.NCmp(oid, uint(shortId.Length)) != 0 {
+				break
+			}
+			request.add(oid)
+		}
+	}
+	return nil
+}
+
 func sha1Position(table []byte, stride int, lo, hi uint32, key []byte) int {
 	for lo < hi {
 		mi := int((lo + hi) / 2)
@@ -158,22 +232,101 @@ func (p *PackFile) nthPackedObjectOffset(n int) uint64 {
 	}
 }
 
+// nthPackedObjectId returns the id of the nth object in the pack index.
+func (p *PackFile) nthPackedObjectId(n int) *Oid {
+	var start int
+	if p.indexVersion == 1 {
+		start = 4*256 + 24*n + 4
+	} else {
+		start = 4*(2+256) + GitOidRawSize*n
+	}
+	return NewOidFromBytes(p.indexMap[start : start+GitOidRawSize])
+}
+
+// nthPackedObjectCrc returns the CRC32 of the packed data of the nth
+// object. Only version 2 indexes have it.
+func (p *PackFile) nthPackedObjectCrc(n int) (uint32, bool) {
+	if p.indexVersion == 1 {
+		return 0, false
+	}
+	start := 4*(2+256) + GitOidRawSize*p.numObjects + 4*n
+	return ntohlFromBytes(p.indexMap, start), true
+}
+
+// revIndex returns the positions of the objects in the pack index in the
+// order of their offsets, and the sorted offsets.
+func (p *PackFile) revIndex() ([]int, []uint64, error) {
+	err := p.openIndex()
+	if err != nil {
+		return nil, nil, err
+	}
+	p.revIndexOnce.Do(func() {
+		positions := make([]int, p.numObjects)
+		offsets := make([]uint64, p.numObjects)
+		for i := range positions {
+			positions[i] = i
+			offsets[i] = p.nthPackedObjectOffset(i)
+		}
+		sort.Slice(positions, func(i, j int) bool {
+			return offsets[positions[i]] < offsets[positions[j]]
+		})
+		sort.Slice(offsets, func(i, j int) bool {
+			return offsets[i] < offsets[j]
+		})
+		// the lock is for CacheStatistics, the readers are behind the Once
+		p.lock.Lock()
+		p.revPositions = positions
+		p.revOffsets = offsets
+		p.lock.Unlock()
+	})
+	return p.revPositions, p.revOffsets, nil
+}
+
+// findEntryAt returns the position in the pack index of the object at the
+// offset, and the offset where its packed data ends.
+func (p *PackFile) findEntryAt(offset uint64) (int, uint64, error) {
+	positions, offsets, err := p.revIndex()
+	if err != nil {
+		return 0, 0, err
+	}
+	i := sort.Search(len(offsets), func(i int) bool {
+		return offsets[i] >= offset
+	})
+	if i == len(offsets) || offsets[i] != offset {
+		return 0, 0, errors.New("no object at the offset of the packfile")
+	}
+	end := p.mwf.size - GitOidRawSize
+	if i+1 < len(offsets) {
+		end = offsets[i+1]
+	}
+	return positions[i], end, nil
+}
+
 func (p *PackFile) open() error {
-	if p.indexVersion == -1 && p.openIndex() != nil {
+	if p.openIndex() != nil {
 		return errors.New("failed to open packfile (0)")
 	}
-	if p.mwf.file != nil {
-		return nil
-	}
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	var err error
-	p.mwf.file, err = os.Open(p.packName)
+	if p.mwf.file != nil {
+		return nil
+	}
+	file, err := os.Open(longPath(p.packName))
 	if err != nil {
 		return err
 	}
-	stat, err := p.mwf.file.Stat()
+	err = p.openPackFile(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	p.mwf.file = file
+	return nil
+}
+
+func (p *PackFile) openPackFile(file *os.File) error {
+	stat, err := file.Stat()
 	if err != nil {
 		return err
 	}
@@ -190,20 +343,20 @@ func (p *PackFile) open() error {
 	var hdr_version uint32
 	var hdr_entities uint32
 
-	binary.Read(p.mwf.file, binary.BigEndian, &hdr_signature)
-	binary.Read(p.mwf.file, binary.BigEndian, &hdr_version)
-	binary.Read(p.mwf.file, binary.BigEndian, &hdr_entities)
+	binary.Read(file, binary.BigEndian, &hdr_signature)
+	binary.Read(file, binary.BigEndian, &hdr_version)
+	binary.Read(file, binary.BigEndian, &hdr_entities)
 
 	if hdr_signature != 0x5041434b /*PACK*/ || !versionOk(hdr_version) || p.numObjects != int(hdr_entities) {
 		return errors.New("failed to open packfile (3)")
 	}
 	var sha1 Oid
 	var idxSha1 Oid
-	_, err = p.mwf.file.Seek(int64(p.mwf.size-GitOidRawSize), os.SEEK_SET)
+	_, err = file.Seek(int64(p.mwf.size-GitOidRawSize), os.SEEK_SET)
 	if err != nil {
 		return errors.New("failed to open packfile (4)")
 	}
-	p.mwf.file.Read(sha1[:])
+	file.Read(sha1[:])
 	copy(idxSha1[:], p.indexMap[len(p.indexMap)-40:])
 
 	if !sha1.Equal(&idxSha1) {
@@ -217,7 +370,7 @@ func versionOk(version uint32) bool {
 }
 
 func (p *PackFile) checkIndex(path string) error {
-	file, err := os.Open(path)
+	file, err := os.Open(longPath(path))
 	defer file.Close()
 	if err != nil {
 		return err
@@ -280,9 +433,6 @@ func (p *PackFile) checkIndex(path string) error {
 }
 
 func (p *PackFile) openIndex() error {
-	if p.indexVersion > -1 {
-		return nil
-	}
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
@@ -292,19 +442,38 @@ func (p *PackFile) openIndex() error {
 	return nil
 }
 
-func (p *PackFile) openWindow(offset uint64) ([]byte, error) {
-	if p.mwf.file == nil {
-		err := p.open()
-		if err != nil {
-			return nil, err
-		}
+func (p *PackFile) openWindow(offset uint64) ([]byte, *MWindow, error) {
+	err := p.open()
+	if err != nil {
+		return nil, nil, err
 	}
 	if offset > (p.mwf.size - 20) {
-		return nil, errors.New("invalid size")
+		return nil, nil, errors.New("invalid size")
 	}
 	return p.mwf.Open(offset, 20)
 }
 
+// packWindowReader reads the packfile sequentially from the given offset
+// and moves to the next window when the current one is exhausted.
+type packWindowReader struct {
+	pack   *PackFile
+	offset uint64
+}
+
+func (r *packWindowReader) Read(buffer []byte) (int, error) {
+	if r.offset >= r.pack.mwf.size-GitOidRawSize {
+		return 0, io.EOF
+	}
+	data, w, err := r.pack.mwf.Open(r.offset, 1)
+	if err != nil {
+		return 0, err
+	}
+	defer w.Close()
+	n := copy(buffer, data)
+	r.offset += uint64(n)
+	return n, nil
+}
+
 func (p *PackFile) resolveHeader(offset uint64) (ObjectType, uint64, error) {
 	elem, err := p.unpackHeader(offset)
 	if err != nil {
@@ -320,7 +489,11 @@ func (p *PackFile) resolveHeader(offset uint64) (ObjectType, uint64, error) {
 		if err != nil {
 			return ObjectBad, 0, err
 		}
-		_, targetSize, offset := decodeHeader(delta)
+		_, targetSize, offset, err := decodeHeader(delta.Bytes())
+		putBuffer(delta)
+		if err != nil {
+			return ObjectBad, 0, err
+		}
 		resultSize = targetSize
 		curPos += offset
 	} else {
@@ -341,25 +514,20 @@ func (p *PackFile) resolveHeader(offset uint64) (ObjectType, uint64, error) {
 	return elem.objType, resultSize, nil
 }
 
-func (p *PackFile) unpackCompressed(offset uint64, objType ObjectType) ([]byte, error) {
-	data, err := p.openWindow(offset)
+func (p *PackFile) unpackCompressed(offset uint64, objType ObjectType) (*bytes.Buffer, error) {
+	err := p.open()
 	if err != nil {
 		return nil, err
 	}
-	reader, err := zlib.NewReader(bytes.NewReader(data))
-	if err != nil {
-		return nil, err
-	}
-	var buffer bytes.Buffer
-	io.Copy(&buffer, reader)
-	return buffer.Bytes(), nil
+	return inflate(&packWindowReader{pack: p, offset: offset})
 }
 
 func (p *PackFile) unpackHeader(curPos uint64) (*PackChainElem, error) {
-	buffer, err := p.mwf.Open(curPos, 20)
+	buffer, w, err := p.mwf.Open(curPos, 20)
 	if err != nil {
 		return nil, err
 	}
+	defer w.Close()
 
 	c := buffer[0]
 	objType := ObjectType((c >> 4) & 7)
@@ -368,7 +536,7 @@ func (p *PackFile) unpackHeader(curPos uint64) (*PackChainElem, error) {
 	length := len(buffer)
 	used := 1
 	for c&0x80 != 0 {
-		if length < used {
+		if length <= used {
 			return nil, errors.New("buffer too small")
 		}
 		if 64 <= shift {
@@ -395,10 +563,12 @@ func MSB(x uint64, bit uint) bool {
 
 func (p *PackFile) getDeltaBase(curPos uint64, objType ObjectType, deltaObjOffset uint64) (baseOffset, resultCurPos uint64, err error) {
 	var buffer []byte
-	buffer, err = p.openWindow(curPos)
+	var w *MWindow
+	buffer, w, err = p.openWindow(curPos)
 	if err != nil {
 		return 0, 0, err
 	}
+	defer w.Close()
 	resultCurPos = curPos
 	if objType == ObjectOfsDelta {
 		c := buffer[0]
@@ -480,31 +650,42 @@ func (p *PackFile) unpack(objOffset uint64) (obj *OdbObject, resultObjOffset uin
 	obj = &OdbObject{
 		Type: baseType,
 	}
+	var base *bytes.Buffer
 	var baseData []byte
 	if baseType == ObjectCommit || baseType == ObjectTree || baseType == ObjectTag || baseType == ObjectBlob {
-		baseData, err = p.unpackCompressed(lastElem.offset, lastElem.objType)
-		obj.Data = baseData
+		base, err = p.unpackCompressed(lastElem.offset, lastElem.objType)
 		if err != nil {
+			return nil, resultObjOffset, err
 		}
+		baseData = base.Bytes()
+		if uint64(len(baseData)) != lastElem.size {
+			putBuffer(base)
+			return nil, resultObjOffset, errors.New("packed object size doesn't match with its header")
+		}
+		obj.Data = baseData
+		obj.buffer = base
 	} else if baseType == ObjectOfsDelta || baseType == ObjectRefDelta {
-		err = errors.New("dependency chain ends in a delta")
-		return
+		return nil, resultObjOffset, errors.New("dependency chain ends in a delta")
 	} else {
-		err = errors.New("invalid packfile type in header")
-		return
+		return nil, resultObjOffset, errors.New("invalid packfile type in header")
 	}
-	//var buffer bytes.Buffer
 	for i := len(stack) - 2; i >= 0; i-- {
 		elem := stack[i]
-		delta, err := p.unpackCompressed(elem.offset, elem.objType)
+		var delta *bytes.Buffer
+		delta, err = p.unpackCompressed(elem.offset, elem.objType)
 		if err != nil {
-			err = errors.New("can't read unpack delta")
-			continue
+			obj.Release()
+			return nil, resultObjOffset, errors.New("can't unpack delta: " + err.Error())
 		}
-		baseData, err = ApplyDelta(baseData, delta)
+		baseData, err = ApplyDelta(baseData, delta.Bytes())
+		putBuffer(delta)
 		if err != nil {
-			err = errors.New("can't apply delta")
-			continue
+			obj.Release()
+			return nil, resultObjOffset, errors.New("can't apply delta: " + err.Error())
+		}
+		if obj.buffer != nil {
+			putBuffer(obj.buffer)
+			obj.buffer = nil
 		}
 		obj.Data = baseData
 	}
@@ -527,22 +708,14 @@ func (a uint32PointerArray) Less(i, j int) bool {
 }
 
 func (p *PackFile) forEach(callback OdbForEachCallback) error {
-	if len(p.indexMap) == 0 {
-		err := p.openIndex()
-		if err != nil {
-			return err
-		}
+	err := p.openIndex()
+	if err != nil {
+		return err
 	}
 	intMap := *(*[]uint32)(unsafe.Pointer(&p.indexMap))
 
 	var index int
 
-	if len(p.indexMap) == 0 {
-		err := p.openIndex()
-		if err != nil {
-			return err
-		}
-	}
 	if p.indexVersion > 1 {
 		index += 2
 	}
@@ -584,6 +757,48 @@ func (p *PackFile) forEach(callback OdbForEachCallback) error {
 	return nil
 }
 
+// forEachExt reports the objects in the order of their offsets, so the
+// headers are read from the packfile front to back.
+func (p *PackFile) forEachExt(flags OdbForEachFlag, callback OdbForEachExtCallback) error {
+	positions, offsets, err := p.revIndex()
+	if err != nil {
+		return err
+	}
+	if flags&OdbForEachHeader != 0 {
+		err = p.open()
+		if err != nil {
+			return err
+		}
+	}
+	for i, position := range positions {
+		info := &OdbObjectInfo{
+			Id:       p.nthPackedObjectId(position),
+			PackFile: p.packName,
+			Offset:   offsets[i],
+		}
+		if flags&OdbForEachHeader != 0 {
+			objType, size, err := p.resolveHeader(info.Offset)
+			if err != nil {
+				return newObjectCorruptError(info.Id, p.packName, err)
+			}
+			info.Type = objType
+			info.Size = size
+		}
+		if flags&OdbForEachDiskSize != 0 {
+			end := p.mwf.size - GitOidRawSize
+			if i+1 < len(offsets) {
+				end = offsets[i+1]
+			}
+			info.DiskSize = end - info.Offset
+		}
+		err = callback(info)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func NewPackFile(path string) (*PackFile, error) {
 	ext := filepath.Ext(path)
 	result := &PackFile{
@@ -610,11 +825,15 @@ func NewPackFile(path string) (*PackFile, error) {
 	return result, nil
 }
 
+// GetPack returns the packfile of the path, which is the .idx or the .pack
+// file. Packfiles are shared by all object databases, so the forks whose
+// alternates point to the same repository map its packfiles only once.
 func GetPack(path string) (*PackFile, error) {
 	mwindowMutex.Lock()
 	defer mwindowMutex.Unlock()
 
-	existingEntry, ok := packCache[path]
+	key := packCacheKey(path)
+	existingEntry, ok := packCache[key]
 	if ok {
 		return existingEntry, nil
 	}
@@ -622,17 +841,27 @@ func GetPack(path string) (*PackFile, error) {
 	if err != nil {
 		return nil, err
 	}
-	packCache[path] = packFile
+	packCache[key] = packFile
 	return packFile, nil
 }
 
 func PutPack(pack *PackFile) error {
 	mwindowMutex.Lock()
 	defer mwindowMutex.Unlock()
-	delete(packCache, pack.packName)
+	delete(packCache, packCacheKey(pack.packName))
 	return nil
 }
 
+// packCacheKey is the absolute path of the packfile without the extension,
+// so the paths of the index and the packfile, and the relative paths of
+// alternates, find the same entry.
+func packCacheKey(path string) string {
+	if absolute, err := filepath.Abs(path); err == nil {
+		path = absolute
+	}
+	return strings.TrimSuffix(path, filepath.Ext(path))
+}
+
 type PackEntry struct {
 	Offset   uint64
 	Sha1     *Oid
@@ -0,0 +1,285 @@
+package git4go
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+const (
+	// all objects that are reachable from the commits are in the pack
+	bitmapOptFullDag = 0x1
+	// the name hash of each object follows the bitmaps
+	bitmapOptHashCache = 0x4
+	// the table to find the bitmaps without reading all of them follows
+	bitmapOptLookupTable = 0x10
+)
+
+// packBitmap is a bit set over the objects of a pack in the order of their
+// offsets.
+type packBitmap []uint64
+
+func newPackBitmapFromPositions(positions []int) packBitmap {
+	var bitmap packBitmap
+	for _, position := range positions {
+		bitmap.set(position)
+	}
+	return bitmap
+}
+
+func (b *packBitmap) set(position int) {
+	word := position / 64
+	for len(*b) <= word {
+		*b = append(*b, 0)
+	}
+	(*b)[word] |= 1 << uint(position%64)
+}
+
+func (b packBitmap) get(position int) bool {
+	word := position / 64
+	return word < len(b) && b[word]&(1<<uint(position%64)) != 0
+}
+
+func (b packBitmap) or(other packBitmap) packBitmap {
+	result := make(packBitmap, len(b))
+	copy(result, b)
+	for len(result) < len(other) {
+		result = append(result, 0)
+	}
+	for i, word := range other {
+		result[i] |= word
+	}
+	return result
+}
+
+func (b packBitmap) xor(other packBitmap) packBitmap {
+	result := b.or(nil)
+	for len(result) < len(other) {
+		result = append(result, 0)
+	}
+	for i, word := range other {
+		result[i] ^= word
+	}
+	return result
+}
+
+func (b packBitmap) andNot(other packBitmap) packBitmap {
+	result := b.or(nil)
+	for i := 0; i < len(result) && i < len(other); i++ {
+		result[i] &^= other[i]
+	}
+	return result
+}
+
+// packBitmapIndex is the reachability bitmap file (.bitmap) of a pack. The
+// bitmap of a commit has the bits of all objects that are reachable from
+// it, and the type bitmaps tell the types of the objects.
+type packBitmapIndex struct {
+	path string
+	data []byte
+	// the objects of the pack in the order of the bits
+	ids     []*Oid
+	commits packBitmap
+	trees   packBitmap
+	blobs   packBitmap
+	tags    packBitmap
+	// the entries of the commits with a bitmap, by their ids
+	positions map[Oid]int
+	lock      sync.Mutex
+	entries   []packBitmapEntry
+}
+
+// packBitmapEntry is the bitmap of a commit. It is decoded on first use.
+type packBitmapEntry struct {
+	// the offset of the EWAH bitmap in the file
+	offset int
+	// the entry that the bitmap is the difference to, or -1
+	base   int
+	bitmap packBitmap
+}
+
+// readPackBitmapIndex reads the bitmap file of the pack. It returns nil if
+// the pack has no bitmap file. Only the type bitmaps are decoded; the
+// bitmaps of the commits are decoded when they are looked up.
+func readPackBitmapIndex(pack *PackFile) (*packBitmapIndex, error) {
+	path := pack.baseName + ".bitmap"
+	data, err := ioutil.ReadFile(longPath(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = pack.openIndex()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12+2*GitOidRawSize || string(data[:4]) != "BITM" {
+		return nil, errors.New(fmt.Sprintf("corrupt bitmap index '%s' (bad header)", path))
+	}
+	version := binary.BigEndian.Uint16(data[4:])
+	flags := binary.BigEndian.Uint16(data[6:])
+	if version != 1 {
+		return nil, errors.New(fmt.Sprintf("unsupported bitmap index version %d", version))
+	}
+	if flags&bitmapOptFullDag == 0 {
+		return nil, errors.New(fmt.Sprintf("bitmap index '%s' is not closed", path))
+	}
+	entryCount := int(ntohlFromBytes(data, 8))
+	packChecksum := pack.indexMap[len(pack.indexMap)-2*GitOidRawSize : len(pack.indexMap)-GitOidRawSize]
+	if string(data[12:12+GitOidRawSize]) != string(packChecksum) {
+		return nil, errors.New(fmt.Sprintf("bitmap index '%s' does not match its pack", path))
+	}
+
+	bound := len(data) - GitOidRawSize
+	if flags&bitmapOptLookupTable != 0 {
+		bound -= entryCount * (4 + 8 + 4)
+	}
+	if flags&bitmapOptHashCache != 0 {
+		bound -= 4 * pack.numObjects
+	}
+	offset := 12 + GitOidRawSize
+	if bound < offset {
+		return nil, errors.New(fmt.Sprintf("corrupt bitmap index '%s' (truncated)", path))
+	}
+	index := &packBitmapIndex{
+		path:      path,
+		data:      data[:bound],
+		positions: make(map[Oid]int),
+		entries:   make([]packBitmapEntry, 0, entryCount),
+	}
+	for _, typeBitmap := range []*packBitmap{&index.commits, &index.trees, &index.blobs, &index.tags} {
+		var positions []int
+		positions, offset, err = readEwahBitmap(data, offset, bound)
+		if err != nil {
+			return nil, err
+		}
+		*typeBitmap = newPackBitmapFromPositions(positions)
+	}
+	for i := 0; i < entryCount; i++ {
+		if bound-offset < 6 {
+			return nil, errors.New(fmt.Sprintf("corrupt bitmap index '%s' (truncated entry)", path))
+		}
+		position := int(ntohlFromBytes(data, offset))
+		xorOffset := int(data[offset+4])
+		offset += 6
+		if position >= pack.numObjects || xorOffset > i {
+			return nil, errors.New(fmt.Sprintf("corrupt bitmap index '%s' (entry %d)", path, i))
+		}
+		entry := packBitmapEntry{offset: offset, base: -1}
+		// the bitmap is stored as the difference to a previous one
+		if xorOffset > 0 {
+			entry.base = i - xorOffset
+		}
+		offset, err = skipEwahBitmap(data, offset, bound)
+		if err != nil {
+			return nil, err
+		}
+		index.entries = append(index.entries, entry)
+		index.positions[*pack.nthPackedObjectId(position)] = i
+	}
+
+	positions, _, err := pack.revIndex()
+	if err != nil {
+		return nil, err
+	}
+	index.ids = make([]*Oid, len(positions))
+	for i, n := range positions {
+		index.ids[i] = pack.nthPackedObjectId(n)
+	}
+	return index, nil
+}
+
+// skipEwahBitmap returns the offset after the EWAH bitmap without decoding
+// its words.
+func skipEwahBitmap(buffer []byte, offset, bound int) (int, error) {
+	if bound-offset < 8 {
+		return offset, errors.New("corrupt ewah bitmap (too short)")
+	}
+	wordCount := int(ntohlFromBytes(buffer, offset+4))
+	offset += 8
+	if (bound-offset)/8 < wordCount || bound-offset-wordCount*8 < 4 {
+		return offset, errors.New("corrupt ewah bitmap (truncated)")
+	}
+	return offset + wordCount*8 + 4, nil
+}
+
+// lookup returns the bitmap of the commit, or false if it has none. The
+// bitmaps of the chain of differences are decoded once.
+func (b *packBitmapIndex) lookup(commit *Oid) (packBitmap, bool, error) {
+	i, ok := b.positions[*commit]
+	if !ok {
+		return nil, false, nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var chain []int
+	for n := i; n >= 0 && b.entries[n].bitmap == nil; n = b.entries[n].base {
+		chain = append(chain, n)
+	}
+	for c := len(chain) - 1; c >= 0; c-- {
+		entry := &b.entries[chain[c]]
+		positions, _, err := readEwahBitmap(b.data, entry.offset, len(b.data))
+		if err != nil {
+			return nil, false, errors.New(fmt.Sprintf("corrupt bitmap index '%s' (%s)", b.path, err))
+		}
+		bitmap := newPackBitmapFromPositions(positions)
+		if entry.base >= 0 {
+			bitmap = bitmap.xor(b.entries[entry.base].bitmap)
+		} else if bitmap == nil {
+			bitmap = packBitmap{}
+		}
+		entry.bitmap = bitmap
+	}
+	return b.entries[i].bitmap, true, nil
+}
+
+// objectType returns the type of the object at the position.
+func (b *packBitmapIndex) objectType(position int) ObjectType {
+	switch {
+	case b.commits.get(position):
+		return ObjectCommit
+	case b.trees.get(position):
+		return ObjectTree
+	case b.blobs.get(position):
+		return ObjectBlob
+	case b.tags.get(position):
+		return ObjectTag
+	}
+	return ObjectBad
+}
+
+// bitmapIndex reads the bitmap file of the pack once.
+func (p *PackFile) bitmapIndex() (*packBitmapIndex, error) {
+	p.bitmapOnce.Do(func() {
+		p.bitmap, p.bitmapErr = readPackBitmapIndex(p)
+	})
+	return p.bitmap, p.bitmapErr
+}
+
+// packBitmapIndexes returns the bitmap files of the packs of the object
+// database.
+func (o *Odb) packBitmapIndexes() ([]*packBitmapIndex, error) {
+	var indexes []*packBitmapIndex
+	for _, backend := range o.backendList() {
+		packed, ok := backend.(*OdbBackendPacked)
+		if !ok {
+			continue
+		}
+		packed.lock.Lock()
+		packs := packed.packs
+		packed.lock.Unlock()
+		for _, pack := range packs {
+			index, err := pack.bitmapIndex()
+			if err != nil {
+				return nil, err
+			}
+			if index != nil {
+				indexes = append(indexes, index)
+			}
+		}
+	}
+	return indexes, nil
+}
@@ -0,0 +1,655 @@
+package git4go
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	gohash "hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+)
+
+const (
+	defaultPackWindow = 10
+	defaultPackDepth  = 50
+)
+
+type PackBuilderStage int
+
+const (
+	// Objects are inserted to the builder
+	PackBuilderAddingObjects PackBuilderStage = iota
+	// Deltas are searched for the objects that are written
+	PackBuilderDeltafication
+)
+
+// PackBuilderProgressCallback is called when an object is inserted, and
+// during the delta search when an object is done. The total is 0 while
+// objects are inserted.
+type PackBuilderProgressCallback func(stage PackBuilderStage, current, total uint)
+
+// PackBuilderStats counts how the objects of a pack were written.
+type PackBuilderStats struct {
+	Written uint
+	// Objects whose compressed data was copied from an existing pack
+	ReusedObjects uint
+	// Deltas that were copied from an existing pack because their bases
+	// are in the new pack too
+	ReusedDeltas uint
+	// Deltas that were copied from an existing pack as REF_DELTA because
+	// the receiver has their bases (thin pack)
+	ThinDeltas uint
+	// Deltas that the delta search found
+	Deltas uint
+}
+
+// PackBuilder writes a pack of the inserted objects. Objects that are in
+// existing packs are not compressed again: the compressed data is copied,
+// and a delta is copied if its base is written to the same pack. Other
+// objects are written whole.
+//
+// After SetThinBases, deltas against objects that the receiver has are
+// copied too, which makes a thin pack. The receiver has to append the
+// bases before it can use the pack (see Indexer).
+//
+// Objects whose packed data is not reused are compared with the objects of
+// the same type and a similar size, and they are written as deltas when
+// that is smaller. The search uses pack.window, pack.depth and pack.threads
+// of the config, and the threads do not change the written pack.
+type PackBuilder struct {
+	repo      *Repository
+	odb       *Odb
+	objects   []*packBuilderObject
+	index     map[Oid]*packBuilderObject
+	thinBases map[Oid]bool
+	stats     PackBuilderStats
+	window    int
+	depth     int
+	threads   int
+	progress  PackBuilderProgressCallback
+}
+
+const (
+	packObjectPending = iota
+	packObjectWriting
+	packObjectWritten
+)
+
+type packBuilderObject struct {
+	id      *Oid
+	objType ObjectType
+	state   int
+	offset  uint64
+	crc     uint32
+	// set by the delta search
+	deltaBase *packBuilderObject
+	delta     []byte
+}
+
+func (r *Repository) NewPackBuilder() (*PackBuilder, error) {
+	odb, err := r.Odb()
+	if err != nil {
+		return nil, err
+	}
+	pb := &PackBuilder{
+		repo:   r,
+		odb:    odb,
+		index:  make(map[Oid]*packBuilderObject),
+		window: defaultPackWindow,
+		depth:  defaultPackDepth,
+	}
+	if config := r.Config(); config != nil {
+		if value, err := config.LookupInt32("pack.window"); err == nil {
+			pb.SetWindow(int(value))
+		}
+		if value, err := config.LookupInt32("pack.depth"); err == nil {
+			pb.SetDepth(int(value))
+		}
+		if value, err := config.LookupInt32("pack.threads"); err == nil {
+			pb.SetThreads(int(value))
+		}
+	}
+	if pb.threads == 0 {
+		pb.SetThreads(0)
+	}
+	return pb, nil
+}
+
+// SetWindow sets the number of objects that each object is compared with
+// in the delta search. 0 disables the search.
+func (pb *PackBuilder) SetWindow(window int) {
+	if window < 0 {
+		window = 0
+	}
+	pb.window = window
+}
+
+// SetDepth sets the maximum length of the delta chains that the delta
+// search makes.
+func (pb *PackBuilder) SetDepth(depth int) {
+	if depth < 0 {
+		depth = 0
+	}
+	pb.depth = depth
+}
+
+// SetThreads sets the number of goroutines of the delta search and returns
+// it. 0 uses the number of CPUs.
+func (pb *PackBuilder) SetThreads(threads int) int {
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	pb.threads = threads
+	return threads
+}
+
+func (pb *PackBuilder) SetProgressCallback(callback PackBuilderProgressCallback) {
+	pb.progress = callback
+}
+
+// Insert adds the object. Objects that are already inserted are ignored.
+func (pb *PackBuilder) Insert(id *Oid) error {
+	if _, ok := pb.index[*id]; ok {
+		return nil
+	}
+	objType, _, err := pb.odb.readHeaderNoReplace(id)
+	if err != nil {
+		return err
+	}
+	pb.insert(id, objType)
+	return nil
+}
+
+// InsertTree adds the tree and all trees and blobs in it.
+func (pb *PackBuilder) InsertTree(id *Oid) error {
+	if _, ok := pb.index[*id]; ok {
+		return nil
+	}
+	tree, err := pb.repo.LookupTree(id)
+	if err != nil {
+		return err
+	}
+	pb.insert(id, ObjectTree)
+	for _, entry := range tree.Entries {
+		switch entry.Type {
+		case ObjectTree:
+			err = pb.InsertTree(entry.Id)
+		case ObjectBlob:
+			err = pb.Insert(entry.Id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertCommit adds the commit and its tree.
+func (pb *PackBuilder) InsertCommit(id *Oid) error {
+	commit, err := pb.repo.LookupCommit(id)
+	if err != nil {
+		return err
+	}
+	if _, ok := pb.index[*id]; !ok {
+		pb.insert(id, ObjectCommit)
+	}
+	return pb.InsertTree(commit.TreeId())
+}
+
+// InsertObjects adds the objects that Repository.EnumerateObjects found.
+func (pb *PackBuilder) InsertObjects(objects []*EnumeratedObject) {
+	for _, object := range objects {
+		if _, ok := pb.index[*object.Id]; !ok {
+			pb.insert(object.Id, object.Type)
+		}
+	}
+}
+
+// SetThinBases makes the pack thin. The haves are objects that the
+// receiver has, usually the commits that the remote refs point to. The
+// commits and tags are peeled, and the objects in their trees can be the
+// bases of deltas that are not sent.
+func (pb *PackBuilder) SetThinBases(haves []*Oid) error {
+	bases := make(map[Oid]bool)
+	for _, have := range haves {
+		for have != nil {
+			obj, err := pb.repo.Lookup(have)
+			if err != nil {
+				return err
+			}
+			have = nil
+			switch obj := obj.(type) {
+			case *Tag:
+				bases[*obj.Id()] = true
+				have = obj.TargetId()
+			case *Commit:
+				bases[*obj.Id()] = true
+				err = pb.repo.markTreeSeen(obj.TreeId(), bases)
+			case *Tree:
+				err = pb.repo.markTreeSeen(obj.Id(), bases)
+			default:
+				bases[*obj.Id()] = true
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	pb.thinBases = bases
+	return nil
+}
+
+func (pb *PackBuilder) ObjectCount() int {
+	return len(pb.objects)
+}
+
+// Stats returns the counts of the last Write.
+func (pb *PackBuilder) Stats() PackBuilderStats {
+	return pb.stats
+}
+
+// Write writes the pack and returns its checksum, which names the pack.
+func (pb *PackBuilder) Write(w io.Writer) (*Oid, error) {
+	pb.stats = PackBuilderStats{}
+	for _, obj := range pb.objects {
+		obj.state = packObjectPending
+	}
+	err := pb.searchDeltas()
+	if err != nil {
+		return nil, err
+	}
+	pw := &packWriter{
+		writer: w,
+		hash:   sha1.New(),
+		crc:    crc32.NewIEEE(),
+	}
+	header := make([]byte, 12)
+	copy(header, "PACK")
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(pb.objects)))
+	_, err = pw.Write(header)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range pb.objects {
+		err = pb.writeObject(pw, obj)
+		if err != nil {
+			return nil, err
+		}
+	}
+	checksum := NewOidFromBytes(pw.hash.Sum(nil))
+	_, err = w.Write(checksum[:])
+	if err != nil {
+		return nil, err
+	}
+	return checksum, nil
+}
+
+// WriteToDir writes the pack and its index as pack-<checksum>.pack and
+// .idx in the directory, which is usually objects/pack. The pack is
+// streamed to a temporary file in the directory, which is flushed to the
+// disk and renamed, so readers never see a partial pack. The directory is
+// on the disk even if the repository uses another FileSystem, like the
+// other files of the pack directory.
+func (pb *PackBuilder) WriteToDir(dir string) (*Oid, error) {
+	if pb.repo.readOnly {
+		return nil, errReadOnly("PackBuilder.WriteToDir")
+	}
+	tempFile, err := ioutil.TempFile(dir, "tmp_pack_")
+	if err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriter(tempFile)
+	checksum, err := pb.Write(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	baseName := ""
+	if err == nil {
+		baseName = "pack-" + checksum.String()
+		err = os.Rename(tempFile.Name(), filepath.Join(dir, baseName+".pack"))
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return nil, err
+	}
+	entries := make([]*packIndexEntry, len(pb.objects))
+	for i, obj := range pb.objects {
+		entries[i] = &packIndexEntry{id: obj.id, offset: obj.offset, crc: obj.crc}
+	}
+	var index bytes.Buffer
+	writePackIndex(&index, entries, checksum)
+	err = writePackFile(dir, baseName+".idx", index.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return checksum, nil
+}
+
+// internal functions and methods
+
+func (pb *PackBuilder) insert(id *Oid, objType ObjectType) {
+	obj := &packBuilderObject{id: id, objType: objType}
+	pb.objects = append(pb.objects, obj)
+	pb.index[*id] = obj
+	if pb.progress != nil {
+		pb.progress(PackBuilderAddingObjects, uint(len(pb.objects)), 0)
+	}
+}
+
+// packWriter counts the offset and computes the checksum of the pack and
+// the CRC32 of the current entry.
+type packWriter struct {
+	writer io.Writer
+	hash   gohash.Hash
+	crc    gohash.Hash32
+	offset uint64
+}
+
+func (w *packWriter) Write(data []byte) (int, error) {
+	n, err := w.writer.Write(data)
+	w.hash.Write(data[:n])
+	w.crc.Write(data[:n])
+	w.offset += uint64(n)
+	return n, err
+}
+
+func (pb *PackBuilder) writeObject(pw *packWriter, obj *packBuilderObject) error {
+	if obj.state != packObjectPending {
+		return nil
+	}
+	obj.state = packObjectWriting
+	var written bool
+	var err error
+	if obj.delta != nil {
+		written, err = pb.writeFoundDelta(pw, obj)
+	} else {
+		written, err = pb.writeReusedObject(pw, obj)
+	}
+	if err != nil {
+		return err
+	}
+	if !written {
+		err = pb.writeWholeObject(pw, obj)
+		if err != nil {
+			return err
+		}
+	}
+	obj.state = packObjectWritten
+	return nil
+}
+
+// findPackEntry returns the pack that stores the object.
+func (pb *PackBuilder) findPackEntry(id *Oid) *PackEntry {
+	for _, backend := range pb.odb.backendList() {
+		packed, ok := backend.(*OdbBackendPacked)
+		if !ok {
+			continue
+		}
+		entry, err := packed.findEntry(id)
+		if err == nil {
+			return entry
+		}
+	}
+	return nil
+}
+
+// writeReusedObject copies the packed data of the object from the pack that
+// has it. It returns false if the object is not packed, if it is a delta
+// whose base is neither written to the new pack nor a thin base, or if the
+// data is corrupt.
+func (pb *PackBuilder) writeReusedObject(pw *packWriter, obj *packBuilderObject) (bool, error) {
+	entry := pb.findPackEntry(obj.id)
+	if entry == nil {
+		return false, nil
+	}
+	pack := entry.PackFile
+	err := pack.open()
+	if err != nil {
+		return false, nil
+	}
+	position, end, err := pack.findEntryAt(entry.Offset)
+	if err != nil {
+		return false, nil
+	}
+	elem, err := pack.unpackHeader(entry.Offset)
+	if err != nil {
+		return false, nil
+	}
+	dataOffset := elem.offset
+	var base *packBuilderObject
+	var thinBase *Oid
+	if elem.objType == ObjectOfsDelta || elem.objType == ObjectRefDelta {
+		var baseOffset uint64
+		baseOffset, dataOffset, err = pack.getDeltaBase(elem.offset, elem.objType, entry.Offset)
+		if err != nil {
+			return false, nil
+		}
+		basePosition, _, err := pack.findEntryAt(baseOffset)
+		if err != nil {
+			return false, nil
+		}
+		baseId := pack.nthPackedObjectId(basePosition)
+		base = pb.index[*baseId]
+		if base == nil {
+			if !pb.thinBases[*baseId] {
+				return false, nil
+			}
+			thinBase = baseId
+		} else {
+			// a base that is being written depends on this object in another pack
+			if base.state == packObjectWriting {
+				return false, nil
+			}
+			err = pb.writeObject(pw, base)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	raw := make([]byte, end-entry.Offset)
+	_, err = io.ReadFull(&packWindowReader{pack: pack, offset: entry.Offset}, raw)
+	if err != nil {
+		return false, nil
+	}
+	// corrupt data must not be copied to the new pack
+	if crc, ok := pack.nthPackedObjectCrc(position); ok && crc != crc32.ChecksumIEEE(raw) {
+		return false, nil
+	}
+
+	obj.offset = pw.offset
+	pw.crc.Reset()
+	if base != nil {
+		header := packEntryHeader(ObjectOfsDelta, elem.size)
+		_, err = pw.Write(append(header, packOfsDeltaOffset(obj.offset-base.offset)...))
+		pb.stats.ReusedDeltas++
+	} else if thinBase != nil {
+		header := packEntryHeader(ObjectRefDelta, elem.size)
+		_, err = pw.Write(append(header, thinBase[:]...))
+		pb.stats.ThinDeltas++
+	} else {
+		_, err = pw.Write(packEntryHeader(elem.objType, elem.size))
+		pb.stats.ReusedObjects++
+	}
+	if err == nil {
+		_, err = pw.Write(raw[dataOffset-entry.Offset:])
+	}
+	if err != nil {
+		return false, err
+	}
+	obj.crc = pw.crc.Sum32()
+	pb.stats.Written++
+	return true, nil
+}
+
+// writeFoundDelta writes the delta that the delta search found. It returns
+// false if the base depends on the object.
+func (pb *PackBuilder) writeFoundDelta(pw *packWriter, obj *packBuilderObject) (bool, error) {
+	base := obj.deltaBase
+	if base.state == packObjectWriting {
+		return false, nil
+	}
+	err := pb.writeObject(pw, base)
+	if err != nil {
+		return false, err
+	}
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	writer.Write(obj.delta)
+	writer.Close()
+
+	obj.offset = pw.offset
+	pw.crc.Reset()
+	header := packEntryHeader(ObjectOfsDelta, uint64(len(obj.delta)))
+	_, err = pw.Write(append(header, packOfsDeltaOffset(obj.offset-base.offset)...))
+	if err == nil {
+		_, err = pw.Write(compressed.Bytes())
+	}
+	if err != nil {
+		return false, err
+	}
+	obj.crc = pw.crc.Sum32()
+	pb.stats.Deltas++
+	pb.stats.Written++
+	return true, nil
+}
+
+func (pb *PackBuilder) writeWholeObject(pw *packWriter, obj *packBuilderObject) error {
+	odbObj, err := pb.odb.readNoReplace(obj.id)
+	if err != nil {
+		return err
+	}
+	defer odbObj.Release()
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	writer.Write(odbObj.Data)
+	writer.Close()
+
+	obj.offset = pw.offset
+	pw.crc.Reset()
+	_, err = pw.Write(packEntryHeader(odbObj.Type, uint64(len(odbObj.Data))))
+	if err == nil {
+		_, err = pw.Write(compressed.Bytes())
+	}
+	if err != nil {
+		return err
+	}
+	obj.crc = pw.crc.Sum32()
+	pb.stats.Written++
+	return nil
+}
+
+// packEntryHeader encodes the type and the inflated size of an entry.
+func packEntryHeader(objType ObjectType, size uint64) []byte {
+	c := byte(objType)<<4 | byte(size&15)
+	size >>= 4
+	var header []byte
+	for size != 0 {
+		header = append(header, c|0x80)
+		c = byte(size & 0x7f)
+		size >>= 7
+	}
+	return append(header, c)
+}
+
+// packOfsDeltaOffset encodes the distance to the base of an OFS_DELTA in
+// the way that PackFile.getDeltaBase reads it.
+func packOfsDeltaOffset(offset uint64) []byte {
+	buffer := make([]byte, 10)
+	pos := len(buffer) - 1
+	buffer[pos] = byte(offset & 127)
+	for offset >>= 7; offset != 0; offset >>= 7 {
+		offset--
+		pos--
+		buffer[pos] = 128 | byte(offset&127)
+	}
+	return buffer[pos:]
+}
+
+// packIndexEntry is an object of a written pack.
+type packIndexEntry struct {
+	id     *Oid
+	offset uint64
+	crc    uint32
+}
+
+// writePackIndex writes the version 2 index of a pack.
+func writePackIndex(w *bytes.Buffer, entries []*packIndexEntry, checksum *Oid) {
+	sorted := make([]*packIndexEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].id.Cmp(sorted[j].id) < 0
+	})
+	writeUint32(w, 0xff744f63)
+	writeUint32(w, 2)
+	var fanout [256]uint32
+	for _, entry := range sorted {
+		fanout[entry.id[0]]++
+	}
+	var count uint32
+	for _, n := range fanout {
+		count += n
+		writeUint32(w, count)
+	}
+	for _, entry := range sorted {
+		w.Write(entry.id[:])
+	}
+	for _, entry := range sorted {
+		writeUint32(w, entry.crc)
+	}
+	var largeOffsets []uint64
+	for _, entry := range sorted {
+		if entry.offset < 0x80000000 {
+			writeUint32(w, uint32(entry.offset))
+		} else {
+			writeUint32(w, 0x80000000|uint32(len(largeOffsets)))
+			largeOffsets = append(largeOffsets, entry.offset)
+		}
+	}
+	for _, offset := range largeOffsets {
+		var buffer [8]byte
+		binary.BigEndian.PutUint64(buffer[:], offset)
+		w.Write(buffer[:])
+	}
+	w.Write(checksum[:])
+	sum := sha1.Sum(w.Bytes())
+	w.Write(sum[:])
+}
+
+// writePackFile writes the data to a temporary file in the directory,
+// flushes it to the disk and renames it, so readers never see a partial
+// file.
+func writePackFile(dir, name string, data []byte) error {
+	tempFile, err := ioutil.TempFile(dir, "tmp_pack_")
+	if err != nil {
+		return err
+	}
+	_, err = tempFile.Write(data)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempFile.Name(), filepath.Join(dir, name))
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+	}
+	return err
+}
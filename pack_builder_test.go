@@ -0,0 +1,213 @@
+package git4go
+
+import (
+	"./testutil"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_PackBuilderReuse(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/bitmap.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/bitmap.git")
+	master, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	objects, _ := repo.EnumerateObjects([]*Oid{master}, nil)
+	builder, err := repo.NewPackBuilder()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	builder.InsertObjects(objects)
+	if builder.ObjectCount() != 20 {
+		t.Error("wrong object count:", builder.ObjectCount())
+	}
+
+	os.MkdirAll("test_resources/bitmap.git/new/pack", 0777)
+	_, err = builder.WriteToDir("test_resources/bitmap.git/new/pack")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	stats := builder.Stats()
+	if stats.Written != 20 {
+		t.Error("wrong written count:", stats.Written)
+	}
+	// 4a202b3 is a delta of c47800c, which is sent too. The bases of the
+	// deltas be3563a and 75057dd are not sent.
+	if stats.ReusedDeltas != 1 || stats.ReusedObjects != 17 {
+		t.Errorf("wrong reused count: %+v", stats)
+	}
+
+	if temps, _ := filepath.Glob("test_resources/bitmap.git/new/pack/tmp_pack_*"); len(temps) != 0 {
+		t.Error("it should rename the temporary files:", temps)
+	}
+
+	backend := NewOdbBackendPacked("test_resources/bitmap.git/new")
+	for _, object := range objects {
+		written, err := backend.Read(object.Id)
+		if err != nil {
+			t.Error("err should be nil:", object.Id.String(), err)
+			continue
+		}
+		original, _ := repo.odb.Read(object.Id)
+		if written.Type != original.Type || !bytes.Equal(written.Data, original.Data) {
+			t.Error("written object is different:", object.Id.String())
+		}
+	}
+}
+
+func Test_PackBuilderDeltaWithoutBase(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/bitmap.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/bitmap.git")
+	// a delta of c47800c, which is not in the pack
+	third, _ := NewOid("4a202b346bb0fb0db7eff3cffeb3c70babbd2045")
+	builder, _ := repo.NewPackBuilder()
+	builder.Insert(third)
+	var buffer bytes.Buffer
+	_, err := builder.Write(&buffer)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if stats := builder.Stats(); stats.ReusedDeltas != 0 || stats.Written != 1 {
+		t.Errorf("the delta should be written whole: %+v", stats)
+	}
+	if !bytes.HasPrefix(buffer.Bytes(), []byte("PACK\x00\x00\x00\x02\x00\x00\x00\x01")) {
+		t.Error("wrong header")
+	}
+}
+
+func Test_PackBuilderThin(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/bitmap.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/bitmap.git")
+	// 4a202b3 is a delta of c47800c, which the receiver has
+	third, _ := NewOid("4a202b346bb0fb0db7eff3cffeb3c70babbd2045")
+	base, _ := NewOid("c47800c7266a2be04c571c04d5a6614691ea99bd")
+	second, _ := NewOid("5b5b025afb0b4c913b4c338a42934a3863bf3644")
+	objects, _ := repo.EnumerateObjects([]*Oid{third}, []*Oid{second})
+	builder, _ := repo.NewPackBuilder()
+	builder.InsertObjects(objects)
+	err := builder.SetThinBases([]*Oid{base, second})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	var buffer bytes.Buffer
+	_, err = builder.Write(&buffer)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if stats := builder.Stats(); stats.Written != 3 || stats.ThinDeltas != 1 {
+		t.Errorf("the delta should be written as REF_DELTA: %+v", stats)
+	}
+	if !bytes.Contains(buffer.Bytes(), base[:]) {
+		t.Error("the pack should refer to the base")
+	}
+}
+
+// writeSimilarBlobs stages two blobs that are not packed
+func writeSimilarBlobs(repo *Repository) []*Oid {
+	odb, _ := repo.Odb()
+	backend := NewOdbBackendMemPack()
+	odb.AddBackend(backend, GitLoosePriority)
+	var content bytes.Buffer
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&content, "line %d of a file that is packed with a delta\n", i)
+	}
+	first, _ := backend.Write(content.Bytes(), ObjectBlob)
+	content.WriteString("one more line\n")
+	second, _ := backend.Write(content.Bytes(), ObjectBlob)
+	return []*Oid{first, second}
+}
+
+func Test_PackBuilderDeltaSearch(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/bitmap.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/bitmap.git")
+	blobs := writeSimilarBlobs(repo)
+	var packs [][]byte
+	for _, threads := range []int{1, 4} {
+		builder, _ := repo.NewPackBuilder()
+		builder.SetThreads(threads)
+		var stages []PackBuilderStage
+		builder.SetProgressCallback(func(stage PackBuilderStage, current, total uint) {
+			stages = append(stages, stage)
+		})
+		for _, blob := range blobs {
+			builder.Insert(blob)
+		}
+		var buffer bytes.Buffer
+		_, err := builder.Write(&buffer)
+		if err != nil {
+			t.Fatal("err should be nil:", err)
+		}
+		if stats := builder.Stats(); stats.Deltas != 1 || stats.Written != 2 {
+			t.Errorf("a blob should be written as a delta: %+v", stats)
+		}
+		if len(stages) != 4 || stages[1] != PackBuilderAddingObjects || stages[3] != PackBuilderDeltafication {
+			t.Error("wrong progress:", stages)
+		}
+		packs = append(packs, buffer.Bytes())
+	}
+	if !bytes.Equal(packs[0], packs[1]) {
+		t.Error("the pack should not depend on the threads")
+	}
+
+	os.MkdirAll("test_resources/bitmap.git/new/pack", 0777)
+	builder, _ := repo.NewPackBuilder()
+	for _, blob := range blobs {
+		builder.Insert(blob)
+	}
+	builder.WriteToDir("test_resources/bitmap.git/new/pack")
+	backend := NewOdbBackendPacked("test_resources/bitmap.git/new")
+	for _, blob := range blobs {
+		written, err := backend.Read(blob)
+		if err != nil {
+			t.Error("err should be nil:", err)
+			continue
+		}
+		original, _ := repo.odb.Read(blob)
+		if !bytes.Equal(written.Data, original.Data) {
+			t.Error("written object is different:", blob.String())
+		}
+	}
+}
+
+func Test_PackBuilderNoWindow(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/bitmap.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/bitmap.git")
+	builder, _ := repo.NewPackBuilder()
+	builder.SetWindow(0)
+	for _, blob := range writeSimilarBlobs(repo) {
+		builder.Insert(blob)
+	}
+	var buffer bytes.Buffer
+	builder.Write(&buffer)
+	if stats := builder.Stats(); stats.Deltas != 0 {
+		t.Errorf("the delta search should be disabled: %+v", stats)
+	}
+}
+
+func Test_PackBuilderWriteToDirReadOnly(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/bitmap.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepositoryReadOnly("test_resources/bitmap.git")
+	master, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	builder, _ := repo.NewPackBuilder()
+	builder.InsertCommit(master)
+	os.MkdirAll("test_resources/bitmap.git/new/pack", 0777)
+	if _, err := builder.WriteToDir("test_resources/bitmap.git/new/pack"); !IsErrorCode(err, ErrReadOnly) {
+		t.Error("it should not write a pack into a read-only repository:", err)
+	}
+	if files, _ := filepath.Glob("test_resources/bitmap.git/new/pack/*"); len(files) != 0 {
+		t.Error("it should not leave files:", files)
+	}
+}
@@ -0,0 +1,213 @@
+package git4go
+
+import (
+	"sort"
+	"sync"
+)
+
+// deltaSearchEntry is an object in the window of the delta search.
+type deltaSearchEntry struct {
+	obj  *packBuilderObject
+	size uint64
+	data []byte
+	// false if the packed data of the object is reused
+	target bool
+}
+
+// deltaSearchResult is a delta of a target against an object before it.
+type deltaSearchResult struct {
+	base  int
+	delta []byte
+}
+
+// searchDeltas finds deltas for the objects whose packed data is not
+// reused. The objects are sorted by type and size, and each target is
+// compared with the objects of the window before it. The comparisons run
+// in parallel, and then the bases are chosen in order, so the result does
+// not depend on the number of threads.
+func (pb *PackBuilder) searchDeltas() error {
+	for _, obj := range pb.objects {
+		obj.deltaBase = nil
+		obj.delta = nil
+	}
+	if pb.window == 0 || pb.depth == 0 {
+		return nil
+	}
+
+	// reused deltas make chains too
+	reusedBases := make(map[*packBuilderObject]*packBuilderObject)
+	entries := make([]*deltaSearchEntry, 0, len(pb.objects))
+	targets := 0
+	for _, obj := range pb.objects {
+		entry := &deltaSearchEntry{obj: obj}
+		packed, baseId := pb.packedDeltaBase(obj)
+		switch {
+		case packed && baseId == nil:
+		case packed && pb.index[*baseId] != nil:
+			reusedBases[obj] = pb.index[*baseId]
+		case packed && pb.thinBases[*baseId]:
+		default:
+			entry.target = true
+			targets++
+		}
+		_, size, err := pb.odb.readHeaderNoReplace(obj.id)
+		if err != nil {
+			return err
+		}
+		entry.size = size
+		entries = append(entries, entry)
+	}
+	if targets == 0 {
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.obj.objType != b.obj.objType {
+			return a.obj.objType < b.obj.objType
+		}
+		if a.size != b.size {
+			return a.size > b.size
+		}
+		return a.obj.id.Cmp(b.obj.id) < 0
+	})
+
+	// only the targets and the objects in their windows are read
+	for i, entry := range entries {
+		if !entry.target {
+			continue
+		}
+		for j := i; j >= 0 && j >= i-pb.window; j-- {
+			if entries[j].data != nil || entries[j].obj.objType != entry.obj.objType {
+				continue
+			}
+			odbObj, err := pb.odb.readNoReplace(entries[j].obj.id)
+			if err != nil {
+				return err
+			}
+			entries[j].data = make([]byte, len(odbObj.Data))
+			copy(entries[j].data, odbObj.Data)
+			odbObj.Release()
+		}
+	}
+
+	results := make([][]*deltaSearchResult, len(entries))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var done uint
+	for i := 0; i < pb.threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range jobs {
+				results[target] = pb.findDeltas(entries, target)
+				if pb.progress != nil {
+					lock.Lock()
+					done++
+					pb.progress(PackBuilderDeltafication, done, uint(targets))
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+	for i, entry := range entries {
+		if entry.target {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i, entry := range entries {
+		for _, result := range results[i] {
+			base := entries[result.base].obj
+			depth, ok := pb.deltaChainDepth(base, entry.obj, reusedBases)
+			if !ok || depth+1 > pb.depth {
+				continue
+			}
+			entry.obj.deltaBase = base
+			entry.obj.delta = result.delta
+			break
+		}
+	}
+	return nil
+}
+
+// findDeltas returns the deltas of the target against the objects in its
+// window that are small enough, the smallest first.
+func (pb *PackBuilder) findDeltas(entries []*deltaSearchEntry, target int) []*deltaSearchResult {
+	entry := entries[target]
+	// a delta has to save at least half of the object
+	maxSize := len(entry.data)/2 - 20
+	if maxSize <= 0 {
+		return nil
+	}
+	var results []*deltaSearchResult
+	for i := target - 1; i >= 0 && i >= target-pb.window; i-- {
+		base := entries[i]
+		if base.obj.objType != entry.obj.objType {
+			break
+		}
+		delta, err := CreateDelta(base.data, entry.data, uint64(maxSize))
+		if err != nil || len(delta) > maxSize {
+			continue
+		}
+		results = append(results, &deltaSearchResult{base: i, delta: delta})
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return len(results[i].delta) < len(results[j].delta)
+	})
+	return results
+}
+
+// deltaChainDepth returns the number of deltas to apply to get the base.
+// It returns false if the base depends on the target.
+func (pb *PackBuilder) deltaChainDepth(base, target *packBuilderObject, reusedBases map[*packBuilderObject]*packBuilderObject) (int, bool) {
+	depth := 0
+	obj := base
+	// reused deltas from different packs can make a loop
+	for depth <= len(pb.objects) {
+		if obj == target {
+			return 0, false
+		}
+		next := obj.deltaBase
+		if next == nil {
+			next = reusedBases[obj]
+		}
+		if next == nil {
+			return depth, true
+		}
+		obj = next
+		depth++
+	}
+	return 0, false
+}
+
+// packedDeltaBase returns whether the object is in a pack, and the id of
+// its delta base if it is stored as a delta there.
+func (pb *PackBuilder) packedDeltaBase(obj *packBuilderObject) (bool, *Oid) {
+	entry := pb.findPackEntry(obj.id)
+	if entry == nil {
+		return false, nil
+	}
+	pack := entry.PackFile
+	if pack.open() != nil {
+		return false, nil
+	}
+	elem, err := pack.unpackHeader(entry.Offset)
+	if err != nil {
+		return false, nil
+	}
+	if elem.objType != ObjectOfsDelta && elem.objType != ObjectRefDelta {
+		return true, nil
+	}
+	baseOffset, _, err := pack.getDeltaBase(elem.offset, elem.objType, entry.Offset)
+	if err != nil {
+		return false, nil
+	}
+	position, _, err := pack.findEntryAt(baseOffset)
+	if err != nil {
+		return false, nil
+	}
+	return true, pack.nthPackedObjectId(position)
+}
@@ -0,0 +1,127 @@
+package git4go
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// PackfileUri is a line of the packfile-uris section of a protocol v2 fetch
+// response: a pack that the server did not send, which the client
+// downloads from the uri, usually a CDN.
+type PackfileUri struct {
+	// the checksum of the pack, the last 20 bytes of it
+	Hash *Oid
+	Uri  string
+}
+
+// ParsePackfileUri parses a "<hash> <uri>" line of the packfile-uris
+// section.
+func ParsePackfileUri(line string) (*PackfileUri, error) {
+	fields := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 2)
+	if len(fields) != 2 || fields[1] == "" {
+		return nil, MakeGitError(fmt.Sprintf("malformed packfile-uris line '%s'", line), ErrInvalid)
+	}
+	hash, err := NewOid(fields[0])
+	if err != nil {
+		return nil, MakeGitError(fmt.Sprintf("malformed packfile-uris line '%s'", line), ErrInvalid)
+	}
+	return &PackfileUri{Hash: hash, Uri: fields[1]}, nil
+}
+
+// PackfileUriProtocols returns the protocols of fetch.uriProtocols, like
+// "https", which clients send as "packfile-uris https" in fetch requests.
+// It is empty if the config is not set; servers then send the whole pack.
+func (r *Repository) PackfileUriProtocols() []string {
+	config := r.Config()
+	if config == nil {
+		return nil
+	}
+	value, err := config.LookupString("fetch.uriProtocols")
+	if err != nil {
+		return nil
+	}
+	var protocols []string
+	for _, protocol := range strings.Split(value, ",") {
+		if protocol = strings.TrimSpace(protocol); protocol != "" {
+			protocols = append(protocols, protocol)
+		}
+	}
+	return protocols
+}
+
+// DownloadPackfileUris downloads the packs of the packfile-uris section
+// and stores them in the object database, after the pack of the response
+// itself. The uris must use a protocol of PackfileUriProtocols, and each
+// pack must end with its hash, like git checks; a pack that does not is
+// not stored.
+func (r *Repository) DownloadPackfileUris(uris []*PackfileUri, download UriDownloadCallback, progress IndexerProgressCallback) error {
+	protocols := r.PackfileUriProtocols()
+	for _, uri := range uris {
+		allowed := false
+		for _, protocol := range protocols {
+			if strings.HasPrefix(uri.Uri, protocol+"://") {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return MakeGitError(fmt.Sprintf("the protocol of packfile uri '%s' is not in fetch.uriProtocols", uri.Uri), ErrInvalid)
+		}
+		if err := r.downloadPackfileUri(uri, download, progress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// internal functions and methods
+
+// packTrailerWriter keeps the last bytes that were written to the pack,
+// which are its checksum.
+type packTrailerWriter struct {
+	writer  io.Writer
+	trailer []byte
+}
+
+func (w *packTrailerWriter) Write(data []byte) (int, error) {
+	w.trailer = append(w.trailer, data...)
+	if len(w.trailer) > GitOidRawSize {
+		w.trailer = append(w.trailer[:0], w.trailer[len(w.trailer)-GitOidRawSize:]...)
+	}
+	return w.writer.Write(data)
+}
+
+func (r *Repository) downloadPackfileUri(uri *PackfileUri, download UriDownloadCallback, progress IndexerProgressCallback) (err error) {
+	start := time.Now()
+	defer func() {
+		traceNet("download packfile uri", start, err, map[string]interface{}{
+			"uri":  uri.Uri,
+			"hash": uri.Hash.String(),
+		})
+	}()
+	odb, err := r.Odb()
+	if err != nil {
+		return err
+	}
+	body, err := download(uri.Uri)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	writepack, err := odb.WritePack(progress)
+	if err != nil {
+		return err
+	}
+	defer writepack.Close()
+	writer := &packTrailerWriter{writer: writepack}
+	if _, err = io.Copy(writer, body); err != nil {
+		return err
+	}
+	if !bytes.Equal(writer.trailer, uri.Hash[:]) {
+		return MakeGitError(fmt.Sprintf("pack downloaded from %s does not match expected hash %s", uri.Uri, uri.Hash.String()), ErrInvalid)
+	}
+	_, err = writepack.Commit()
+	return err
+}
@@ -0,0 +1,58 @@
+package git4go
+
+import (
+	"./testutil"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_DownloadPackfileUris(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	source, _ := OpenRepository("test_resources/testrepo.git")
+	commit, _ := NewOid("c47800c7266a2be04c571c04d5a6614691ea99bd")
+	builder, _ := source.NewPackBuilder()
+	builder.InsertCommit(commit)
+	var pack bytes.Buffer
+	checksum, _ := builder.Write(&pack)
+	download := func(uri string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(pack.Bytes())), nil
+	}
+
+	uri, err := ParsePackfileUri(checksum.String() + " https://cdn.example.com/pack\n")
+	if err != nil || !uri.Hash.Equal(checksum) || uri.Uri != "https://cdn.example.com/pack" {
+		t.Fatal("it should parse the line:", uri, err)
+	}
+	if _, err = ParsePackfileUri("https://cdn.example.com/pack"); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should reject lines without a hash:", err)
+	}
+
+	dir, _ := ioutil.TempDir("", "git4go_packfile_uri")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	if err = repo.DownloadPackfileUris([]*PackfileUri{uri}, download, nil); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should not download without fetch.uriProtocols:", err)
+	}
+	repo.Config().SetString("fetch.uriProtocols", "http, https")
+	if protocols := repo.PackfileUriProtocols(); len(protocols) != 2 || protocols[1] != "https" {
+		t.Error("it should read the protocols:", protocols)
+	}
+
+	wrong, _ := ParsePackfileUri("0123456789012345678901234567890123456789 https://cdn.example.com/pack")
+	if err = repo.DownloadPackfileUris([]*PackfileUri{wrong}, download, nil); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should check the hash of the pack:", err)
+	}
+	if _, err = repo.LookupCommit(commit); err == nil {
+		t.Error("it should not store a pack with the wrong hash")
+	}
+	if err = repo.DownloadPackfileUris([]*PackfileUri{uri}, download, nil); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if _, err = repo.LookupCommit(commit); err != nil {
+		t.Error("it should store the pack:", err)
+	}
+}
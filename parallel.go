@@ -0,0 +1,81 @@
+package git4go
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultParallelThreshold is the number of files that checkout and status
+// need to use more than one goroutine, like checkout.thresholdForParallelism
+// of git. Starting goroutines costs more than it saves for fewer files.
+const defaultParallelThreshold = 100
+
+// parallelWorkers decides the number of goroutines for count jobs. If
+// workers is 0, the config value of configName is used, and the number of
+// CPUs if it is not set or below 1, like checkout.workers of git.
+func (r *Repository) parallelWorkers(workers int, configName string, count int) int {
+	threshold := defaultParallelThreshold
+	if config := r.Config(); config != nil {
+		if workers == 0 && configName != "" {
+			if value, err := config.LookupInt32(configName); err == nil {
+				workers = int(value)
+			}
+		}
+		if value, err := config.LookupInt32("checkout.thresholdForParallelism"); err == nil {
+			threshold = int(value)
+		}
+	}
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+	if count < threshold {
+		return 1
+	}
+	if workers > count {
+		return count
+	}
+	return workers
+}
+
+// parallelFor calls fn for each index below count on the goroutines and
+// waits for them. fn gets the number of its goroutine, so it can keep
+// per-goroutine data without locks. No new job is started after an error,
+// and the error of the lowest index is returned, so a failure is reported
+// the same way as the sequential loop would.
+func parallelFor(workers, count int, fn func(worker, i int) error) error {
+	if workers <= 1 {
+		for i := 0; i < count; i++ {
+			if err := fn(0, i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	errs := make([]error, count)
+	var next int64 = -1
+	var failed int32
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for atomic.LoadInt32(&failed) == 0 {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= count {
+					return
+				}
+				if errs[i] = fn(worker, i); errs[i] != nil {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package git4go
+
+import (
+	"golang.org/x/text/unicode/norm"
+	"strings"
+)
+
+var ntfsReservedNames = []string{
+	"CON", "PRN", "AUX", "NUL",
+	"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+	"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9",
+}
+
+// isValidPathComponent checks one component of a path that is stored in a
+// tree or the index. With protectNTFS, names that windows can't create or
+// that it treats as ".git" are rejected too.
+func isValidPathComponent(name string, protectNTFS bool) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	if strings.ContainsAny(name, "/\x00") {
+		return false
+	}
+	if strings.EqualFold(name, ".git") {
+		return false
+	}
+	if !protectNTFS {
+		return true
+	}
+	if strings.ContainsAny(name, "\\:") {
+		return false
+	}
+	// windows strips trailing dots and spaces
+	trimmed := strings.TrimRight(name, ". ")
+	if trimmed != name {
+		return false
+	}
+	if strings.EqualFold(name, "git~1") {
+		return false
+	}
+	base := name
+	if index := strings.IndexByte(base, '.'); index != -1 {
+		base = base[:index]
+	}
+	base = strings.TrimRight(base, " ")
+	for _, reserved := range ntfsReservedNames {
+		if strings.EqualFold(base, reserved) {
+			return false
+		}
+	}
+	return true
+}
+
+// precomposePath converts decomposed unicode (NFD), which macOS returns from
+// readdir, into the precomposed form (NFC) that git stores when
+// core.precomposeUnicode is set.
+func precomposePath(path string, precompose bool) string {
+	if !precompose || norm.NFC.IsNormalString(path) {
+		return path
+	}
+	return norm.NFC.String(path)
+}
+
+// precomposeUnicode tells if core.precomposeUnicode is set, so the names
+// that are read from the working directory are precomposed.
+func (r *Repository) precomposeUnicode() bool {
+	config := r.Config()
+	if config == nil {
+		return false
+	}
+	precompose, _ := config.LookupBooleanWithDefaultValue("core.precomposeunicode")
+	return precompose
+}
@@ -0,0 +1,43 @@
+package git4go
+
+import (
+	"testing"
+)
+
+func Test_IsValidPathComponent(t *testing.T) {
+	validNames := []string{"README", "src", ".gitignore", "con.d", "aux1", "nul~1", "file."}
+	for _, name := range validNames {
+		if !isValidPathComponent(name, false) {
+			t.Error("it should be valid:", name)
+		}
+	}
+	invalidNames := []string{"", ".", "..", "a/b", ".git", ".GIT"}
+	for _, name := range invalidNames {
+		if isValidPathComponent(name, false) {
+			t.Error("it should be invalid:", name)
+		}
+	}
+	invalidNTFSNames := []string{"NUL", "aux", "con.txt", "Com1 .c", "lpt9", "git~1", "GIT~1", ".git.", ".git ", "a:b", "a\\b", "file."}
+	for _, name := range invalidNTFSNames {
+		if isValidPathComponent(name, true) {
+			t.Error("it should be invalid on NTFS:", name)
+		}
+	}
+	if !isValidPathComponent("console", true) {
+		t.Error("it should accept names that only start with reserved names")
+	}
+}
+
+func Test_PrecomposePath(t *testing.T) {
+	decomposed := "résumé"
+	precomposed := "résumé"
+	if precomposePath(decomposed, true) != precomposed {
+		t.Error("it should convert NFD to NFC")
+	}
+	if precomposePath(decomposed, false) != decomposed {
+		t.Error("it should keep path when precompose is disabled")
+	}
+	if precomposePath(precomposed, true) != precomposed {
+		t.Error("it should keep NFC path")
+	}
+}
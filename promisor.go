@@ -0,0 +1,161 @@
+package git4go
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type FilterType int
+
+const (
+	FilterBlobNone FilterType = iota + 1
+	FilterBlobLimit
+	FilterTreeDepth
+)
+
+// FilterSpec is the object filter of a partial clone, like "blob:none",
+// "blob:limit=1m" or "tree:0". It is sent to the server in the fetch
+// request and recorded for the promisor remote.
+type FilterSpec struct {
+	Type FilterType
+	// Blobs of this size or bigger are omitted (FilterBlobLimit)
+	Limit uint64
+	// Trees deeper than this are omitted, 0 omits all trees (FilterTreeDepth)
+	Depth int
+}
+
+func ParseFilterSpec(spec string) (*FilterSpec, error) {
+	switch {
+	case spec == "blob:none":
+		return &FilterSpec{Type: FilterBlobNone}, nil
+	case strings.HasPrefix(spec, "blob:limit="):
+		limit, err := parseFilterSize(spec[len("blob:limit="):])
+		if err == nil {
+			return &FilterSpec{Type: FilterBlobLimit, Limit: limit}, nil
+		}
+	case strings.HasPrefix(spec, "tree:"):
+		depth, err := strconv.Atoi(spec[len("tree:"):])
+		if err == nil && depth >= 0 {
+			return &FilterSpec{Type: FilterTreeDepth, Depth: depth}, nil
+		}
+	}
+	return nil, MakeGitError(fmt.Sprintf("invalid filter-spec '%s'", spec), ErrInvalidSpec)
+}
+
+func (f *FilterSpec) String() string {
+	switch f.Type {
+	case FilterBlobNone:
+		return "blob:none"
+	case FilterBlobLimit:
+		return "blob:limit=" + strconv.FormatUint(f.Limit, 10)
+	case FilterTreeDepth:
+		return "tree:" + strconv.Itoa(f.Depth)
+	}
+	return ""
+}
+
+// SetPromisorRemote records the remote that promises the objects omitted by
+// the filter, as "git clone --filter" does. Missing objects are then
+// fetched through the promised object callback of the Odb.
+func (r *Repository) SetPromisorRemote(remoteName string, filter *FilterSpec) error {
+	config := r.Config()
+	if config == nil {
+		return errors.New("failed to load config")
+	}
+	err := config.SetString("core.repositoryformatversion", "1")
+	if err == nil {
+		err = config.SetString("extensions.partialclone", remoteName)
+	}
+	if err == nil {
+		err = config.SetBool("remote."+remoteName+".promisor", true)
+	}
+	if err == nil && filter != nil {
+		err = config.SetString("remote."+remoteName+".partialclonefilter", filter.String())
+	}
+	return err
+}
+
+// PromisorRemote returns the promisor remote of the partial clone and the
+// filter that was used to fetch from it. It fails with ErrNotFound if the
+// repository is not a partial clone.
+func (r *Repository) PromisorRemote() (string, *FilterSpec, error) {
+	config := r.Config()
+	if config != nil {
+		name, err := config.LookupString("extensions.partialclone")
+		if err == nil && name != "" {
+			spec, err := config.LookupString("remote." + name + ".partialclonefilter")
+			if err != nil || spec == "" {
+				return name, nil, nil
+			}
+			filter, err := ParseFilterSpec(spec)
+			return name, filter, err
+		}
+	}
+	return "", nil, MakeGitError("repository is not a partial clone", ErrNotFound)
+}
+
+// OdbPromisedObjectCallback fetches an object that is missing from a
+// partial clone and writes it to the Odb. It must not read the object
+// itself, the read that asked for it is retried when the callback returns.
+type OdbPromisedObjectCallback func(oid *Oid) error
+
+// SetPromisedObjectCallback sets the callback that is called when an object
+// is not found in any backend.
+func (o *Odb) SetPromisedObjectCallback(callback OdbPromisedObjectCallback) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.promisedObject = callback
+}
+
+// internal functions and methods
+
+// fetchPromised calls the promised object callback for an object that was
+// not found, and returns true if it was fetched and can be read again.
+func (o *Odb) fetchPromised(oid *Oid, err error) bool {
+	if !IsErrorCode(err, ErrNotFound) {
+		return false
+	}
+	o.lock.RLock()
+	callback := o.promisedObject
+	o.lock.RUnlock()
+	if callback == nil {
+		return false
+	}
+	start := time.Now()
+	err = callback(oid)
+	if err != nil {
+		trace(TraceWarn, TraceCategoryOdb, "failed to fetch promised object", time.Since(start), map[string]interface{}{
+			"id":    oid.String(),
+			"error": err.Error(),
+		})
+		return false
+	}
+	addCounter(MetricPromisedObjectsFetched, 1)
+	return true
+}
+
+func parseFilterSize(value string) (uint64, error) {
+	unit := uint64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'k', 'K':
+			unit = 1024
+		case 'm', 'M':
+			unit = 1024 * 1024
+		case 'g', 'G':
+			unit = 1024 * 1024 * 1024
+		}
+		if unit != 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	size, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return size * unit, nil
+}
@@ -0,0 +1,85 @@
+package git4go
+
+import (
+	"testing"
+)
+
+func Test_ParseFilterSpec(t *testing.T) {
+	for spec, expected := range map[string]FilterSpec{
+		"blob:none":       {Type: FilterBlobNone},
+		"blob:limit=1024": {Type: FilterBlobLimit, Limit: 1024},
+		"blob:limit=1m":   {Type: FilterBlobLimit, Limit: 1024 * 1024},
+		"tree:0":          {Type: FilterTreeDepth},
+		"tree:2":          {Type: FilterTreeDepth, Depth: 2},
+	} {
+		filter, err := ParseFilterSpec(spec)
+		if err != nil || *filter != expected {
+			t.Error("it should parse filter spec:", spec, filter, err)
+		}
+	}
+	filter, _ := ParseFilterSpec("blob:limit=1k")
+	if filter.String() != "blob:limit=1024" {
+		t.Error("it should format filter spec:", filter.String())
+	}
+	for _, spec := range []string{"", "blob:some", "blob:limit=", "blob:limit=1x", "tree:-1", "sparse:oid=master"} {
+		if _, err := ParseFilterSpec(spec); !IsErrorCode(err, ErrInvalidSpec) {
+			t.Error("it should reject filter spec:", spec, err)
+		}
+	}
+}
+
+func Test_PromisorRemote(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	_, _, err := repo.PromisorRemote()
+	if !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should not be a partial clone:", err)
+	}
+	filter, _ := ParseFilterSpec("blob:none")
+	err = repo.SetPromisorRemote("origin", filter)
+	if err != nil {
+		t.Fatal("it should record promisor remote:", err)
+	}
+	name, filter, err := repo.PromisorRemote()
+	if err != nil || name != "origin" || filter == nil || filter.Type != FilterBlobNone {
+		t.Error("it should read promisor remote:", name, filter, err)
+	}
+	promisor, _ := repo.Config().LookupBool("remote.origin.promisor")
+	if !promisor {
+		t.Error("it should mark remote as promisor")
+	}
+}
+
+func Test_OdbPromisedObjectCallback(t *testing.T) {
+	odb := NewOdb()
+	odb.AddBackend(NewOdbBackendMemPack(), GitLoosePriority)
+	promised := NewOdbBackendMemPack()
+	oid, _ := promised.Write([]byte("lazy blob\n"), ObjectBlob)
+
+	_, err := odb.Read(oid)
+	if !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should not find object without callback:", err)
+	}
+	calls := 0
+	odb.SetPromisedObjectCallback(func(id *Oid) error {
+		calls++
+		obj, err := promised.Read(id)
+		if err != nil {
+			return err
+		}
+		_, err = odb.Write(obj.Data, obj.Type)
+		return err
+	})
+	obj, err := odb.Read(oid)
+	if err != nil || string(obj.Data) != "lazy blob\n" {
+		t.Fatal("it should fetch promised object:", err)
+	}
+	_, err = odb.Read(oid)
+	if err != nil || calls != 1 {
+		t.Error("it should fetch promised object only once:", calls, err)
+	}
+	missing, _ := NewOid("0123456789012345678901234567890123456789")
+	_, _, err = odb.ReadHeader(missing)
+	if !IsErrorCode(err, ErrNotFound) || calls != 2 {
+		t.Error("it should report objects the callback can not fetch:", calls, err)
+	}
+}
@@ -0,0 +1,385 @@
+package git4go
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialer opens the connections of a transport, directly or through a
+// proxy. SSH and git:// transports use it instead of net.Dial.
+type Dialer func(network, address string) (net.Conn, error)
+
+// NewProxyDialer returns the dialer that connects through the proxy:
+// "socks5://" resolves the host names locally and "socks5h://" lets the
+// proxy resolve them, like curl; "http://" tunnels with HTTP CONNECT. The
+// user and password of the url are sent to the proxy. An empty proxy
+// connects directly.
+func NewProxyDialer(proxy string) (Dialer, error) {
+	if proxy == "" {
+		return net.Dial, nil
+	}
+	proxyUrl, err := url.Parse(proxy)
+	if err != nil || proxyUrl.Host == "" {
+		return nil, MakeGitError(fmt.Sprintf("invalid proxy '%s'", proxy), ErrInvalid)
+	}
+	switch proxyUrl.Scheme {
+	case "socks5", "socks5h":
+		return func(network, address string) (net.Conn, error) {
+			return dialSocks5(proxyUrl, address)
+		}, nil
+	case "http":
+		return func(network, address string) (net.Conn, error) {
+			return dialHttpConnect(proxyUrl, address)
+		}, nil
+	}
+	return nil, MakeGitError(fmt.Sprintf("unsupported proxy protocol '%s'", proxyUrl.Scheme), ErrInvalid)
+}
+
+// Dialer returns the dialer for the url of the remote (the push url for
+// ConnectDirectionPush). remote.<name>.proxy is used if it is set. For
+// git:// urls GIT_PROXY_COMMAND or the first core.gitProxy that matches
+// the host is used next, like git: "<command> for <domain>" applies to the
+// hosts in the domain, "none" connects directly, and a command is run
+// with the host and the port and is talked to through its standard input
+// and output; a socks5:// or http:// url is accepted instead of a command
+// too. Otherwise ALL_PROXY is used, unless the host is in NO_PROXY. The
+// environment variables are not read in a hermetic repository.
+func (r *Remote) Dialer(direction ConnectDirection) (Dialer, error) {
+	config := r.repo.Config()
+	if config != nil {
+		if proxy, err := config.LookupString("remote." + r.name + ".proxy"); err == nil {
+			return NewProxyDialer(proxy)
+		}
+	}
+	remoteUrl, err := url.Parse(r.connectUrl(direction))
+	if err == nil && remoteUrl.Scheme == "git" {
+		if command, ok := r.repo.gitProxyCommand(remoteUrl.Hostname()); ok {
+			if command == "" || strings.Contains(command, "://") {
+				return NewProxyDialer(command)
+			}
+			return func(network, address string) (net.Conn, error) {
+				return dialProxyCommand(command, address)
+			}, nil
+		}
+	}
+	if !r.repo.hermetic {
+		if err == nil && noProxyMatches(proxyEnv("NO_PROXY"), remoteUrl.Hostname()) {
+			return net.Dial, nil
+		}
+		if proxy := proxyEnv("ALL_PROXY"); proxy != "" {
+			return NewProxyDialer(proxy)
+		}
+	}
+	return net.Dial, nil
+}
+
+// internal functions and methods
+
+// proxyEnv reads the variable in upper case or in lower case.
+func proxyEnv(name string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return os.Getenv(strings.ToLower(name))
+}
+
+// noProxyMatches tells if the host is in the comma separated NO_PROXY list,
+// like curl: "*" matches every host, and a domain matches its subdomains
+// with or without a leading dot.
+func noProxyMatches(noProxy, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		return false
+	}
+	for _, pattern := range strings.Split(noProxy, ",") {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "*" {
+			return true
+		}
+		pattern = strings.TrimSuffix(strings.TrimPrefix(pattern, "."), ".")
+		if pattern == "" {
+			continue
+		}
+		if host == pattern || strings.HasSuffix(host, "."+pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitProxyCommand returns the proxy of GIT_PROXY_COMMAND or of the first
+// core.gitProxy that matches the host. "none" is "".
+func (r *Repository) gitProxyCommand(host string) (string, bool) {
+	if command := os.Getenv("GIT_PROXY_COMMAND"); command != "" && !r.hermetic {
+		return command, true
+	}
+	config := r.Config()
+	if config == nil {
+		return "", false
+	}
+	// git reads the files from the system level up and the first match wins
+	entries := config.Entries()
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Level < entries[j].Level
+	})
+	for _, entry := range entries {
+		if !strings.EqualFold(entry.Name, "core.gitproxy") {
+			continue
+		}
+		command := entry.Value
+		if index := strings.Index(command, " for "); index != -1 {
+			domain := command[index+5:]
+			if !strings.HasSuffix(host, domain) || (len(host) != len(domain) && host[len(host)-len(domain)-1] != '.') {
+				continue
+			}
+			command = command[:index]
+		}
+		if command == "none" {
+			command = ""
+		}
+		return command, true
+	}
+	return "", false
+}
+
+const proxyTimeout = 30 * time.Second
+
+func dialSocks5(proxyUrl *url.URL, address string) (net.Conn, error) {
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return nil, err
+	}
+	request := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip != nil || proxyUrl.Scheme == "socks5" {
+		if ip == nil {
+			ips, err := net.LookupIP(host)
+			if err != nil || len(ips) == 0 {
+				return nil, MakeGitError(fmt.Sprintf("could not resolve host '%s'", host), ErrNotFound)
+			}
+			ip = ips[0]
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			request = append(append(request, 1), ip4...)
+		} else {
+			request = append(append(request, 4), ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, MakeGitError(fmt.Sprintf("host name '%s' is too long for SOCKS5", host), ErrInvalid)
+		}
+		request = append(append(request, 3, byte(len(host))), host...)
+	}
+	request = append(request, byte(port>>8), byte(port))
+
+	conn, err := net.DialTimeout("tcp", proxyUrl.Host, proxyTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(proxyTimeout))
+	if err = socks5Handshake(conn, proxyUrl.User, request); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func socks5Handshake(conn net.Conn, user *url.Userinfo, request []byte) error {
+	methods := []byte{5, 1, 0}
+	if user != nil {
+		methods = []byte{5, 2, 0, 2}
+	}
+	if _, err := conn.Write(methods); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	switch {
+	case reply[0] != 5:
+		return MakeGitError("the proxy is not a SOCKS5 proxy", ErrInvalid)
+	case reply[1] == 2 && user != nil:
+		password, _ := user.Password()
+		if len(user.Username()) > 255 || len(password) > 255 {
+			return MakeGitError("the SOCKS5 user or password is too long", ErrInvalid)
+		}
+		auth := append([]byte{1, byte(len(user.Username()))}, user.Username()...)
+		auth = append(append(auth, byte(len(password))), password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return MakeGitError("the SOCKS5 proxy rejected the user and password", ErrInvalid)
+		}
+	case reply[1] != 0:
+		return MakeGitError("the SOCKS5 proxy accepts no authentication method", ErrInvalid)
+	}
+
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		return MakeGitError(fmt.Sprintf("the SOCKS5 proxy could not connect (error %d)", header[1]), ErrNotFound)
+	}
+	// skip the bound address and port
+	var skip int
+	switch header[3] {
+	case 1:
+		skip = net.IPv4len + 2
+	case 4:
+		skip = net.IPv6len + 2
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0]) + 2
+	default:
+		return MakeGitError("invalid SOCKS5 reply", ErrInvalid)
+	}
+	_, err := io.CopyN(ioutil.Discard, conn, int64(skip))
+	return err
+}
+
+func dialHttpConnect(proxyUrl *url.URL, address string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", proxyUrl.Host, proxyTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(proxyTimeout))
+	request := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", address, address)
+	if user := proxyUrl.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		request += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	if _, err = io.WriteString(conn, request+"\r\n"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, MakeGitError(fmt.Sprintf("the proxy refused to connect to %s: %s", address, response.Status), ErrInvalid)
+	}
+	conn.SetDeadline(time.Time{})
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// bufferedConn reads the data that came after the response of the proxy
+// first.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(buffer []byte) (int, error) {
+	return c.reader.Read(buffer)
+}
+
+// proxyCommandConn talks to the proxy command of core.gitProxy through its
+// standard input and output.
+type proxyCommandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+}
+
+func dialProxyCommand(command, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	// like git, the command gets the host and the port as arguments
+	cmd := exec.Command("sh", "-c", command+` "$@"`, command, host, port)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &proxyCommandConn{cmd: cmd, stdin: stdin, stdout: stdout}, nil
+}
+
+func (c *proxyCommandConn) Read(buffer []byte) (int, error) {
+	return c.stdout.Read(buffer)
+}
+
+func (c *proxyCommandConn) Write(data []byte) (int, error) {
+	return c.stdin.Write(data)
+}
+
+func (c *proxyCommandConn) Close() error {
+	c.stdin.Close()
+	c.stdout.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+	return nil
+}
+
+type proxyCommandAddr string
+
+func (a proxyCommandAddr) Network() string {
+	return "proxy-command"
+}
+
+func (a proxyCommandAddr) String() string {
+	return string(a)
+}
+
+func (c *proxyCommandConn) LocalAddr() net.Addr {
+	return proxyCommandAddr("")
+}
+
+func (c *proxyCommandConn) RemoteAddr() net.Addr {
+	return proxyCommandAddr(c.cmd.Path)
+}
+
+// deadlines are not supported by the pipes of the command
+func (c *proxyCommandConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *proxyCommandConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *proxyCommandConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
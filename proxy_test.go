@@ -0,0 +1,291 @@
+package git4go
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// listenTest accepts the connections of a test server on localhost.
+func listenTest(t *testing.T, serve func(conn net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func echoServer(conn net.Conn) {
+	io.Copy(conn, conn)
+}
+
+// socks5Server is a SOCKS5 proxy that requires the user "git" with the
+// password "secret" and records the requested addresses.
+func socks5Server(requests chan string) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		header := make([]byte, 2)
+		io.ReadFull(conn, header)
+		methods := make([]byte, header[1])
+		io.ReadFull(conn, methods)
+		conn.Write([]byte{5, 2})
+		io.ReadFull(conn, header)
+		user := make([]byte, header[1])
+		io.ReadFull(conn, user)
+		io.ReadFull(conn, header[:1])
+		password := make([]byte, header[0])
+		io.ReadFull(conn, password)
+		if string(user) != "git" || string(password) != "secret" {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+
+		request := make([]byte, 4)
+		io.ReadFull(conn, request)
+		var host string
+		switch request[3] {
+		case 1:
+			ip := make([]byte, net.IPv4len)
+			io.ReadFull(conn, ip)
+			host = net.IP(ip).String()
+		case 3:
+			io.ReadFull(conn, header[:1])
+			name := make([]byte, header[0])
+			io.ReadFull(conn, name)
+			host = string(name)
+		}
+		port := make([]byte, 2)
+		io.ReadFull(conn, port)
+		address := net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1])))
+		requests <- address
+		if host == "localhost" {
+			address = net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port[0])<<8|int(port[1])))
+		}
+		target, err := net.Dial("tcp", address)
+		if err != nil {
+			conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer target.Close()
+		conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+		go io.Copy(target, conn)
+		io.Copy(conn, target)
+	}
+}
+
+func connectServer(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	request, err := http.ReadRequest(reader)
+	if err != nil {
+		return
+	}
+	if request.Method != http.MethodConnect || request.Header.Get("Proxy-Authorization") != "Basic Z2l0OnNlY3JldA==" {
+		io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		return
+	}
+	target, err := net.Dial("tcp", request.Host)
+	if err != nil {
+		io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	defer target.Close()
+	io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	go io.Copy(target, reader)
+	io.Copy(conn, target)
+}
+
+func testProxyEcho(t *testing.T, dialer Dialer, address string) error {
+	conn, err := dialer("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	io.WriteString(conn, "0032want 099fabac3a9ea935598528c27f866e34089c2eff\n")
+	reply := make([]byte, 50)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if string(reply) != "0032want 099fabac3a9ea935598528c27f866e34089c2eff\n" {
+		t.Error("it should tunnel the connection:", string(reply))
+	}
+	return nil
+}
+
+func Test_ProxyDialer(t *testing.T) {
+	target := listenTest(t, echoServer)
+	_, port, _ := net.SplitHostPort(target)
+
+	requests := make(chan string, 4)
+	socks := listenTest(t, socks5Server(requests))
+	dialer, err := NewProxyDialer("socks5h://git:secret@" + socks)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if err = testProxyEcho(t, dialer, "localhost:"+port); err != nil {
+		t.Error("it should connect through the SOCKS5 proxy:", err)
+	}
+	if address := <-requests; address != "localhost:"+port {
+		t.Error("it should let the proxy resolve the host with socks5h:", address)
+	}
+	dialer, _ = NewProxyDialer("socks5://git:secret@" + socks)
+	if err = testProxyEcho(t, dialer, "localhost:"+port); err != nil {
+		t.Error("it should connect through the SOCKS5 proxy:", err)
+	}
+	if address := <-requests; address == "localhost:"+port {
+		t.Error("it should resolve the host with socks5:", address)
+	}
+	dialer, _ = NewProxyDialer("socks5://git:wrong@" + socks)
+	if _, err = dialer("tcp", target); err == nil {
+		t.Error("it should fail with a wrong password")
+	}
+
+	connect := listenTest(t, connectServer)
+	dialer, _ = NewProxyDialer("http://git:secret@" + connect)
+	if err = testProxyEcho(t, dialer, target); err != nil {
+		t.Error("it should connect through the HTTP proxy:", err)
+	}
+	dialer, _ = NewProxyDialer("http://" + connect)
+	if _, err = dialer("tcp", target); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should fail when the proxy refuses the tunnel:", err)
+	}
+
+	if _, err = NewProxyDialer("ftp://proxy.example.com"); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should not accept other protocols:", err)
+	}
+}
+
+func Test_RemoteDialer(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_proxy")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	config := repo.Config()
+	config.SetString("core.gitProxy", "none for internal.example.com")
+	config.addData([]byte("[core]\ngitProxy = echo for example.com\n"), ConfigLevelApp)
+
+	if command, ok := repo.gitProxyCommand("internal.example.com"); !ok || command != "" {
+		t.Error("it should not use a proxy for 'none':", command, ok)
+	}
+	if command, ok := repo.gitProxyCommand("git.example.com"); !ok || command != "echo" {
+		t.Error("it should use the proxy of the domain:", command, ok)
+	}
+	if _, ok := repo.gitProxyCommand("badexample.com"); ok {
+		t.Error("it should match the whole parts of the domain")
+	}
+
+	config.SetString("remote.origin.url", "git://git.example.com/repo.git")
+	remote, err := repo.LookupRemote("origin")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	dialer, err := remote.Dialer(ConnectDirectionFetch)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	conn, err := dialer("tcp", "git.example.com:9418")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	output, _ := ioutil.ReadAll(conn)
+	conn.Close()
+	if string(output) != "git.example.com 9418\n" {
+		t.Error("it should run the proxy command with the host and the port:", string(output))
+	}
+
+	requests := make(chan string, 1)
+	socks := listenTest(t, socks5Server(requests))
+	target := listenTest(t, echoServer)
+	config.SetString("remote.origin.proxy", "socks5h://git:secret@"+socks)
+	remote, _ = repo.LookupRemote("origin")
+	dialer, _ = remote.Dialer(ConnectDirectionFetch)
+	if err = testProxyEcho(t, dialer, target); err != nil {
+		t.Error("it should use the proxy of the remote:", err)
+	}
+}
+
+func Test_RemoteDialer_Hermetic(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_proxy")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	repo.Config().SetString("remote.origin.url", "git://git.example.com/repo.git")
+	os.Setenv("GIT_PROXY_COMMAND", "echo")
+	os.Setenv("ALL_PROXY", "socks5://127.0.0.1:1")
+	defer os.Unsetenv("GIT_PROXY_COMMAND")
+	defer os.Unsetenv("ALL_PROXY")
+
+	if _, ok := repo.gitProxyCommand("git.example.com"); ok {
+		t.Error("it should not read GIT_PROXY_COMMAND in a hermetic repository")
+	}
+	target := listenTest(t, echoServer)
+	remote, _ := repo.LookupRemote("origin")
+	dialer, err := remote.Dialer(ConnectDirectionFetch)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if err = testProxyEcho(t, dialer, target); err != nil {
+		t.Error("it should connect directly without ALL_PROXY:", err)
+	}
+
+	opened, _ := OpenRepository(dir)
+	if command, ok := opened.gitProxyCommand("git.example.com"); !ok || command != "echo" {
+		t.Error("it should read GIT_PROXY_COMMAND otherwise:", command, ok)
+	}
+}
+
+func Test_RemoteDialer_NoProxy(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_proxy")
+	defer os.RemoveAll(dir)
+	InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	repo, _ := OpenRepository(dir)
+	repo.Config().SetString("remote.origin.url", "ssh://git.internal.example.com/repo.git")
+	repo.Config().SetString("remote.public.url", "ssh://github.com/repo.git")
+	os.Setenv("ALL_PROXY", "socks5://127.0.0.1:1")
+	os.Setenv("no_proxy", "localhost, .example.com")
+	defer os.Unsetenv("ALL_PROXY")
+	defer os.Unsetenv("no_proxy")
+
+	target := listenTest(t, echoServer)
+	remote, _ := repo.LookupRemote("origin")
+	dialer, _ := remote.Dialer(ConnectDirectionFetch)
+	if err := testProxyEcho(t, dialer, target); err != nil {
+		t.Error("it should connect directly to the hosts of NO_PROXY:", err)
+	}
+	remote, _ = repo.LookupRemote("public")
+	dialer, _ = remote.Dialer(ConnectDirectionFetch)
+	if conn, err := dialer("tcp", target); err == nil {
+		conn.Close()
+		t.Error("it should use ALL_PROXY for the other hosts")
+	}
+
+	for _, c := range []struct {
+		noProxy, host string
+		matches       bool
+	}{
+		{"*", "github.com", true},
+		{"example.com", "example.com", true},
+		{"example.com", "git.example.com", true},
+		{"example.com", "badexample.com", false},
+		{".example.com", "EXAMPLE.com", true},
+		{"", "example.com", false},
+	} {
+		if noProxyMatches(c.noProxy, c.host) != c.matches {
+			t.Error("it should match the hosts like curl:", c.noProxy, c.host)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package git4go
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const GitMergeHeadFile = "MERGE_HEAD"
+
+type PullRebaseMode int
+
+const (
+	// branch.<name>.rebase or pull.rebase decides
+	PullRebaseDefault PullRebaseMode = iota
+	// The local commits are rebased onto the fetched branch
+	PullRebaseEnabled
+	// The fetched branch is merged
+	PullRebaseDisabled
+)
+
+// PullOptions controls Repository.Pull.
+type PullOptions struct {
+	// The options of the fetch. Its refspecs are replaced by the branch.
+	FetchOptions *FetchOptions
+	// The options of the merge, or of the rebase of the local commits
+	MergeOptions *MergeOptions
+	// Whether the local commits are rebased instead of merged
+	Rebase PullRebaseMode
+	// The committer of the merge commit or of the rebased commits. The
+	// default signature of the repository is used if it is nil.
+	Committer *Signature
+	// Whether the local changes are stashed before the pull and applied
+	// after it. By default merge.autoStash decides, or rebase.autoStash
+	// when the local commits are rebased.
+	AutoStash AutoStashMode
+}
+
+// PullResult tells what Pull did.
+type PullResult struct {
+	// How the fetched branch could be merged
+	Analysis MergeAnalysis
+	// The commit that was fetched
+	Fetched *Oid
+	// The commit of HEAD after the pull, nil if it stopped at a conflict
+	Head *Oid
+	// The paths of the conflicts that stopped the merge or the rebase
+	Conflicts []string
+}
+
+// Pull fetches the branch of the remote and merges it into the current
+// branch, like "git pull <remote> <branch>". Empty names use the upstream
+// of the current branch, branch.<name>.remote and branch.<name>.merge.
+//
+// HEAD is fast-forwarded when it can be, unless merge.ff is false; with
+// merge.ff set to "only", other merges fail with ErrNonFastForward.
+// Otherwise a merge commit is created, or the local commits are rebased
+// onto the fetched branch if the rebase mode, branch.<name>.rebase or
+// pull.rebase asks for it. When the merge conflicts, the conflicts are left
+// in the index and the working directory with MERGE_HEAD and MERGE_MSG, and
+// ErrMergeConflict is returned with their paths in the result; a rebase
+// stays in progress and is continued with OpenRebase. Stashed local
+// changes are applied after the pull, or kept in refs/stash when the merge
+// stops at conflicts.
+func (r *Repository) Pull(remoteName, branch string, opts *PullOptions) (*PullResult, error) {
+	if r.readOnly {
+		return nil, errReadOnly("Repository.Pull")
+	}
+	if r.IsBare() {
+		return nil, MakeGitError("cannot pull into a bare repository", ErrBareRepository)
+	}
+	if opts == nil {
+		opts = &PullOptions{}
+	}
+	headBranch := ""
+	if head, err := r.LookupReference(GitHeadFile); err == nil && head.Type() == ReferenceSymbolic {
+		headBranch = head.SymbolicTarget()
+	}
+	if remoteName == "" || branch == "" {
+		upstreamRemote, upstreamBranch, err := r.branchUpstreamConfig(headBranch)
+		if err != nil {
+			return nil, err
+		}
+		if remoteName == "" {
+			remoteName = upstreamRemote
+		}
+		if branch == "" {
+			branch = upstreamBranch
+		}
+	}
+	remote, err := r.LookupRemote(remoteName)
+	if err != nil {
+		return nil, err
+	}
+	var fetchOpts FetchOptions
+	if opts.FetchOptions != nil {
+		fetchOpts = *opts.FetchOptions
+	}
+	fetchOpts.Refspecs = []string{branch}
+	fetchHeads, err := remote.fetchRefs(&fetchOpts)
+	if err != nil {
+		return nil, err
+	}
+	result := &PullResult{}
+	for _, fetchHead := range fetchHeads {
+		if fetchHead.IsMerge {
+			result.Fetched = fetchHead.Id
+			break
+		}
+	}
+	if result.Fetched == nil {
+		return nil, MakeGitError(fmt.Sprintf("couldn't find remote ref %s", branch), ErrNotFound)
+	}
+	theirs, err := r.LookupCommit(result.Fetched)
+	if err != nil {
+		return nil, err
+	}
+
+	analysis, preference, err := r.MergeAnalysis([]*Oid{result.Fetched})
+	if err != nil {
+		return nil, err
+	}
+	result.Analysis = analysis
+	rebase := r.pullRebaseEnabled(opts.Rebase, headBranch)
+	switch {
+	case analysis&MergeAnalysisUpToDate != 0:
+		result.Head, err = r.pullHead()
+		return result, err
+	case analysis&MergeAnalysisUnborn != 0:
+		if headBranch == "" {
+			return nil, MakeGitError("cannot pull into an unborn detached HEAD", ErrInvalid)
+		}
+		if err = r.pullCheckout(theirs); err != nil {
+			return nil, err
+		}
+		if _, err = r.CreateReference(headBranch, result.Fetched, false); err != nil {
+			return nil, err
+		}
+		result.Head = result.Fetched
+		return result, nil
+	}
+	fastForward := analysis&MergeAnalysisFastForward != 0 && (rebase || preference&MergePreferenceNoFastForward == 0)
+	if !fastForward && !rebase && preference&MergePreferenceFastForwardOnly != 0 {
+		return nil, MakeGitError("not possible to fast-forward, aborting", ErrNonFastForward)
+	}
+	if rebase && !fastForward {
+		// the rebase stashes and applies the local changes itself
+		if err = r.pullRebase(theirs, opts, result); err != nil {
+			return result, err
+		}
+		result.Head, err = r.pullHead()
+		return result, err
+	}
+
+	head, err := r.pullHead()
+	if err != nil {
+		return nil, err
+	}
+	var stash *Oid
+	configName := "merge.autoStash"
+	if rebase {
+		configName = "rebase.autoStash"
+	}
+	if r.autoStashEnabled(opts.AutoStash, configName) {
+		if stash, err = r.autostashCreate(); err != nil {
+			return nil, err
+		}
+	}
+	if fastForward {
+		err = r.pullCheckout(theirs)
+		if err == nil {
+			err = r.updateCommitRef(GitHeadFile, head, result.Fetched)
+		}
+	} else {
+		message := fmt.Sprintf("Merge branch '%s' of %s\n", strings.TrimPrefix(branch, GitRefsHeadsDir), remote.Url())
+		err = r.pullMerge(theirs, message, opts, result)
+	}
+	if err = r.pullApplyAutostash(stash, err); err != nil {
+		return result, err
+	}
+	result.Head, err = r.pullHead()
+	return result, err
+}
+
+// internal functions and methods
+
+// pullRebaseEnabled reads branch.<name>.rebase and pull.rebase. Like git,
+// "merges" and "interactive" rebase too.
+func (r *Repository) pullRebaseEnabled(mode PullRebaseMode, headBranch string) bool {
+	switch mode {
+	case PullRebaseEnabled:
+		return true
+	case PullRebaseDisabled:
+		return false
+	}
+	config := r.Config()
+	if config == nil {
+		return false
+	}
+	names := []string{"pull.rebase"}
+	if strings.HasPrefix(headBranch, GitRefsHeadsDir) {
+		names = append([]string{"branch." + headBranch[len(GitRefsHeadsDir):] + ".rebase"}, names...)
+	}
+	for _, name := range names {
+		value, err := config.LookupString(name)
+		if err != nil || value == "" {
+			continue
+		}
+		switch strings.ToLower(value) {
+		case "false", "no", "off", "0":
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// pullApplyAutostash applies the stashed local changes to HEAD after the
+// pull, even if it failed. When the merge stopped at conflicts, they are
+// kept in refs/stash instead.
+func (r *Repository) pullApplyAutostash(stash *Oid, err error) error {
+	if stash == nil {
+		return err
+	}
+	if IsErrorCode(err, ErrMergeConflict) {
+		if refErr := r.stashStore(stash, "autostash"); refErr != nil {
+			return refErr
+		}
+		return err
+	}
+	head, headErr := r.headCommit()
+	if headErr != nil {
+		if err == nil {
+			err = headErr
+		}
+		return err
+	}
+	tree, headErr := head.Tree()
+	if headErr != nil {
+		if err == nil {
+			err = headErr
+		}
+		return err
+	}
+	if applyErr := r.autostashApply(stash, tree); err == nil {
+		err = applyErr
+	}
+	return err
+}
+
+func (r *Repository) pullHead() (*Oid, error) {
+	head, err := r.headCommit()
+	if err != nil {
+		return nil, err
+	}
+	return head.Id(), nil
+}
+
+func (r *Repository) pullCheckout(commit *Commit) error {
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	return r.CheckoutTree(tree, nil)
+}
+
+// pullMerge merges the commit into HEAD and commits the merge, or leaves
+// the conflicts to be resolved.
+func (r *Repository) pullMerge(theirs *Commit, message string, opts *PullOptions, result *PullResult) error {
+	ours, err := r.headCommit()
+	if err != nil {
+		return err
+	}
+	var mergeOpts MergeOptions
+	if opts.MergeOptions != nil {
+		mergeOpts = *opts.MergeOptions
+	}
+	mergeOpts.FailOnConflict = false
+	index, err := r.MergeCommits(ours, theirs, &mergeOpts)
+	if err != nil {
+		return err
+	}
+	if index.HasConflicts() {
+		paths, err := r.checkoutConflicts(index, r.shortIdForMessage(theirs.Id()), opts.MergeOptions)
+		if err != nil {
+			return err
+		}
+		result.Conflicts = paths
+		var buffer bytes.Buffer
+		buffer.WriteString(message)
+		buffer.WriteString("\n# Conflicts:\n")
+		for _, path := range paths {
+			buffer.WriteString("#\t" + path + "\n")
+		}
+		err = r.writeGitFile(filepath.Join(r.pathRepository, GitMergeHeadFile), []byte(theirs.Id().String()+"\n"))
+		if err == nil {
+			err = r.writeGitFile(filepath.Join(r.pathRepository, GitMergeMsgFile), buffer.Bytes())
+		}
+		if err != nil {
+			return err
+		}
+		return MakeGitError(fmt.Sprintf("merge conflict in '%s'", strings.Join(paths, "', '")), ErrMergeConflict)
+	}
+	treeId, err := index.WriteTreeTo(r)
+	if err != nil {
+		return err
+	}
+	tree, err := r.LookupTree(treeId)
+	if err != nil {
+		return err
+	}
+	if err = r.CheckoutTree(tree, nil); err != nil {
+		return err
+	}
+	committer := opts.Committer
+	if committer == nil {
+		committer, err = r.DefaultSignature()
+		if err != nil {
+			return err
+		}
+	}
+	_, err = r.CreateCommit(GitHeadFile, committer, committer, message, tree, ours, theirs)
+	return err
+}
+
+// pullRebase rebases the local commits onto the commit, and stops at the
+// first one that conflicts.
+func (r *Repository) pullRebase(onto *Commit, opts *PullOptions, result *PullResult) error {
+	rebase, err := r.InitRebase("", onto, nil, &RebaseOptions{MergeOptions: opts.MergeOptions, AutoStash: opts.AutoStash})
+	if err != nil {
+		return err
+	}
+	for {
+		operation, err := rebase.Next()
+		if IsErrorCode(err, ErrIterOver) {
+			return rebase.Finish()
+		} else if err != nil {
+			return err
+		}
+		index, err := r.Index()
+		if err != nil {
+			return err
+		}
+		if index.HasConflicts() {
+			iterator, err := index.ConflictIterator()
+			if err != nil {
+				return err
+			}
+			for {
+				conflict, err := iterator.Next()
+				if IsErrorCode(err, ErrIterOver) {
+					break
+				} else if err != nil {
+					return err
+				}
+				result.Conflicts = append(result.Conflicts, conflictPath(conflict))
+			}
+			return MakeGitError(fmt.Sprintf("could not apply %s", r.shortIdForMessage(operation.Id)), ErrMergeConflict)
+		}
+		_, err = rebase.Commit(nil, opts.Committer, "")
+		if err != nil && !IsErrorCode(err, ErrApplied) {
+			return err
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package git4go
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// preparePull makes the remote and the local branch master point to a
+// commit with a.txt, and sets the upstream of master.
+func preparePull(t *testing.T) (*Repository, *Repository, *Commit, func()) {
+	repo, server, _, cleanup := prepareFetch(t)
+	base := writeMergeCommit(server, map[string]string{"a.txt": "a\n"})
+	server.CreateReference("refs/heads/master", base.Id(), true)
+	remote, _ := repo.LookupRemote("origin")
+	if err := remote.Fetch(nil); err != nil {
+		cleanup()
+		t.Fatal("err should be nil:", err)
+	}
+	commitLocally(repo, base)
+	repo.Config().SetString("branch.master.remote", "origin")
+	repo.Config().SetString("branch.master.merge", "refs/heads/master")
+	return repo, server, base, cleanup
+}
+
+func commitLocally(repo *Repository, commit *Commit) {
+	tree, _ := commit.Tree()
+	repo.CheckoutTree(tree, nil)
+	repo.CreateReference("refs/heads/master", commit.Id(), true)
+}
+
+func pullOptions() *PullOptions {
+	return &PullOptions{Committer: &Signature{"C", "c@example.com", time.Unix(1500000000, 0)}}
+}
+
+func Test_Pull_FastForward(t *testing.T) {
+	repo, server, base, cleanup := preparePull(t)
+	defer cleanup()
+	next := writeMergeCommit(server, map[string]string{"a.txt": "a\n", "b.txt": "b\n"}, base)
+	server.CreateReference("refs/heads/master", next.Id(), true)
+
+	result, err := repo.Pull("", "", pullOptions())
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if result.Analysis&MergeAnalysisFastForward == 0 || !result.Head.Equal(next.Id()) || !result.Fetched.Equal(next.Id()) {
+		t.Error("it should fast-forward the branch:", result.Analysis)
+	}
+	if ref, _ := repo.LookupReference("refs/heads/master"); !ref.Target().Equal(next.Id()) {
+		t.Error("it should move the current branch")
+	}
+	if contents, err := ioutil.ReadFile(filepath.Join(repo.Workdir(), "b.txt")); err != nil || string(contents) != "b\n" {
+		t.Error("it should check out the fetched commit:", err)
+	}
+	if result, err = repo.Pull("origin", "master", pullOptions()); err != nil || result.Analysis != MergeAnalysisUpToDate {
+		t.Error("it should do nothing when the branch is up to date:", err)
+	}
+
+	other := writeMergeCommit(server, map[string]string{"a.txt": "a\n", "b.txt": "b\n", "c.txt": "c\n"}, next)
+	server.CreateReference("refs/heads/master", other.Id(), true)
+	repo.Config().SetString("merge.ff", "false")
+	if result, err = repo.Pull("", "", pullOptions()); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	head, _ := repo.LookupCommit(result.Head)
+	if head.ParentCount() != 2 || !head.ParentId(1).Equal(other.Id()) {
+		t.Error("it should create a merge commit when merge.ff is false")
+	}
+}
+
+func Test_Pull_Merge(t *testing.T) {
+	repo, server, base, cleanup := preparePull(t)
+	defer cleanup()
+	local := writeMergeCommit(repo, map[string]string{"a.txt": "a\n", "local.txt": "local\n"}, base)
+	commitLocally(repo, local)
+	remoteCommit := writeMergeCommit(server, map[string]string{"a.txt": "a\n", "remote.txt": "remote\n"}, base)
+	server.CreateReference("refs/heads/master", remoteCommit.Id(), true)
+
+	repo.Config().SetString("merge.ff", "only")
+	if _, err := repo.Pull("", "", pullOptions()); !IsErrorCode(err, ErrNonFastForward) {
+		t.Error("it should not merge when merge.ff is only:", err)
+	}
+	repo.Config().SetString("merge.ff", "true")
+	result, err := repo.Pull("", "", pullOptions())
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	head, _ := repo.LookupCommit(result.Head)
+	if result.Analysis != MergeAnalysisNormal || head.ParentCount() != 2 || !head.ParentId(0).Equal(local.Id()) || !head.ParentId(1).Equal(remoteCommit.Id()) {
+		t.Fatal("it should commit the merge:", result.Analysis)
+	}
+	if head.Message() != "Merge branch 'master' of test://server\n" {
+		t.Error("it should describe the merge like git:", head.Message())
+	}
+	for _, name := range []string{"local.txt", "remote.txt"} {
+		if _, err := ioutil.ReadFile(filepath.Join(repo.Workdir(), name)); err != nil {
+			t.Error("it should check out the merge:", name)
+		}
+	}
+}
+
+func Test_Pull_Conflict(t *testing.T) {
+	repo, server, base, cleanup := preparePull(t)
+	defer cleanup()
+	local := writeMergeCommit(repo, map[string]string{"a.txt": "local\n"}, base)
+	commitLocally(repo, local)
+	remoteCommit := writeMergeCommit(server, map[string]string{"a.txt": "remote\n"}, base)
+	server.CreateReference("refs/heads/master", remoteCommit.Id(), true)
+
+	result, err := repo.Pull("", "", pullOptions())
+	if !IsErrorCode(err, ErrMergeConflict) {
+		t.Fatal("it should stop at the conflicts:", err)
+	}
+	if len(result.Conflicts) != 1 || result.Conflicts[0] != "a.txt" || result.Head != nil {
+		t.Error("it should report the conflicts:", result.Conflicts)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(repo.Path(), GitMergeHeadFile)); err != nil || string(data) != remoteCommit.Id().String()+"\n" {
+		t.Error("it should record MERGE_HEAD:", err)
+	}
+	if ref, _ := repo.LookupReference("refs/heads/master"); !ref.Target().Equal(local.Id()) {
+		t.Error("it should not move the branch")
+	}
+	index, _ := repo.Index()
+	if !index.HasConflicts() {
+		t.Error("it should leave the conflicts in the index")
+	}
+}
+
+func Test_Pull_Rebase(t *testing.T) {
+	repo, server, base, cleanup := preparePull(t)
+	defer cleanup()
+	local := writeMergeCommit(repo, map[string]string{"a.txt": "a\n", "local.txt": "local\n"}, base)
+	commitLocally(repo, local)
+	remoteCommit := writeMergeCommit(server, map[string]string{"a.txt": "a\n", "remote.txt": "remote\n"}, base)
+	server.CreateReference("refs/heads/master", remoteCommit.Id(), true)
+
+	repo.Config().SetString("pull.rebase", "true")
+	result, err := repo.Pull("", "", pullOptions())
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	head, _ := repo.LookupCommit(result.Head)
+	if head.ParentCount() != 1 || !head.ParentId(0).Equal(remoteCommit.Id()) || head.Message() != local.Message() {
+		t.Error("it should rebase the local commits onto the fetched branch")
+	}
+	if ref, _ := repo.LookupReference(GitHeadFile); ref.Type() != ReferenceSymbolic || ref.SymbolicTarget() != "refs/heads/master" {
+		t.Error("it should finish the rebase on the branch")
+	}
+}
+
+func Test_Pull_AutoStash(t *testing.T) {
+	repo, server, _, cleanup := prepareFetch(t)
+	defer cleanup()
+	base := writeMergeCommit(server, map[string]string{"a.txt": "1\n2\n3\n4\n5\n"})
+	server.CreateReference("refs/heads/master", base.Id(), true)
+	remote, _ := repo.LookupRemote("origin")
+	remote.Fetch(nil)
+	commitLocally(repo, base)
+	repo.Config().SetString("branch.master.remote", "origin")
+	repo.Config().SetString("branch.master.merge", "refs/heads/master")
+	repo.Config().SetString("user.name", "C")
+	repo.Config().SetString("user.email", "c@example.com")
+	next := writeMergeCommit(server, map[string]string{"a.txt": "one\n2\n3\n4\n5\n"}, base)
+	server.CreateReference("refs/heads/master", next.Id(), true)
+	path := filepath.Join(repo.Workdir(), "a.txt")
+	ioutil.WriteFile(path, []byte("1\n2\n3\n4\nfive\n"), 0644)
+
+	if _, err := repo.Pull("", "", pullOptions()); err == nil {
+		t.Error("it should not overwrite the local changes without autostash")
+	}
+	repo.Config().SetString("merge.autoStash", "true")
+	result, err := repo.Pull("", "", pullOptions())
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if !result.Head.Equal(next.Id()) {
+		t.Error("it should fast-forward the branch")
+	}
+	if contents, _ := ioutil.ReadFile(path); string(contents) != "one\n2\n3\n4\nfive\n" {
+		t.Error("it should apply the local changes after the pull:", string(contents))
+	}
+
+	local := writeMergeCommit(repo, map[string]string{"a.txt": "one\n2\n3\n4\n5\n", "local.txt": "local\n"}, next)
+	commitLocally(repo, local)
+	other := writeMergeCommit(server, map[string]string{"a.txt": "one\n2\n3\n4\n5\n", "remote.txt": "remote\n"}, next)
+	server.CreateReference("refs/heads/master", other.Id(), true)
+	ioutil.WriteFile(path, []byte("one\n2\n3\n4\nfive\n"), 0644)
+	options := pullOptions()
+	options.AutoStash = AutoStashEnabled
+	repo.Config().SetString("merge.autoStash", "false")
+	if result, err = repo.Pull("", "", options); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if head, _ := repo.LookupCommit(result.Head); head.ParentCount() != 2 {
+		t.Error("it should commit the merge")
+	}
+	if contents, _ := ioutil.ReadFile(path); string(contents) != "one\n2\n3\n4\nfive\n" {
+		t.Error("it should apply the local changes after the merge:", string(contents))
+	}
+}
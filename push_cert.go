@@ -0,0 +1,334 @@
+package git4go
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const pushCertificateVersion = "0.1"
+
+// PushCertificateCommand is a reference update of a push certificate.
+type PushCertificateCommand struct {
+	OldId   *Oid
+	NewId   *Oid
+	RefName string
+}
+
+// PushCertificate is the signed statement of "git push --signed" about the
+// references that the pusher wants to update, which the receiving side
+// keeps for audits. The client signs it with Sign; receive-pack parses it
+// with ParsePushCertificate, checks the nonce and passes HookEnvironment to
+// the hooks.
+type PushCertificate struct {
+	Version string
+	Pusher  *Signature
+	// The URL of the repository that is pushed to; it may be empty
+	Pushee      string
+	Nonce       string
+	PushOptions []string
+	Commands    []*PushCertificateCommand
+	// The armored signature, empty until the certificate is signed
+	Signature string
+	// the signed part as it was received, which is verified and stored
+	// instead of the fields written again
+	payload string
+}
+
+// PushCertNonceStatus is the result of the nonce check, with the values of
+// GIT_PUSH_CERT_NONCE_STATUS.
+type PushCertNonceStatus string
+
+const (
+	// the certificate has a nonce that was not asked for
+	PushCertNonceUnsolicited PushCertNonceStatus = "UNSOLICITED"
+	// the nonce was asked for but the certificate has none
+	PushCertNonceMissing PushCertNonceStatus = "MISSING"
+	PushCertNonceBad     PushCertNonceStatus = "BAD"
+	PushCertNonceOk      PushCertNonceStatus = "OK"
+	// the nonce was made by this server, but not for this session; it is
+	// within receive.certNonceSlop
+	PushCertNonceSlop PushCertNonceStatus = "SLOP"
+)
+
+// NewPushCertificate creates an unsigned certificate. nonce is the value of
+// the push-cert capability of the server.
+func NewPushCertificate(pusher *Signature, pushee, nonce string, commands []*PushCertificateCommand) *PushCertificate {
+	return &PushCertificate{
+		Version:  pushCertificateVersion,
+		Pusher:   pusher,
+		Pushee:   pushee,
+		Nonce:    nonce,
+		Commands: commands,
+	}
+}
+
+// Payload returns the signed part of the certificate. For a parsed
+// certificate it is the received bytes, so unknown headers and formatting
+// are kept.
+func (c *PushCertificate) Payload() string {
+	if c.payload != "" {
+		return c.payload
+	}
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "certificate version %s\n", c.Version)
+	writeSignature(&buffer, "pusher ", c.Pusher)
+	if c.Pushee != "" {
+		fmt.Fprintf(&buffer, "pushee %s\n", c.Pushee)
+	}
+	fmt.Fprintf(&buffer, "nonce %s\n", c.Nonce)
+	for _, option := range c.PushOptions {
+		fmt.Fprintf(&buffer, "push-option %s\n", option)
+	}
+	buffer.WriteByte('\n')
+	for _, command := range c.Commands {
+		fmt.Fprintf(&buffer, "%s %s %s\n", command.OldId.String(), command.NewId.String(), command.RefName)
+	}
+	return buffer.String()
+}
+
+// Sign signs the payload with the signer, e.g. the one that signs commits.
+// The signature field that the callback returns is not used.
+func (c *PushCertificate) Sign(signer SigningCallback) error {
+	signature, _, err := signer(c.Payload())
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(signature, "\n") {
+		signature += "\n"
+	}
+	c.Signature = signature
+	return nil
+}
+
+// String returns the certificate like receive-pack stores it: the payload
+// and the signature, without the "push-cert" and "push-cert-end" lines of
+// the protocol.
+func (c *PushCertificate) String() string {
+	return c.Payload() + c.Signature
+}
+
+// ParsePushCertificate parses the certificate that a client sent. The
+// protocol lines around it are not part of data.
+func ParsePushCertificate(data []byte) (*PushCertificate, error) {
+	cert := &PushCertificate{}
+	offset := 0
+	nextLine := func() (string, bool) {
+		if offset >= len(data) {
+			return "", false
+		}
+		eol := bytes.IndexByte(data[offset:], '\n')
+		if eol == -1 {
+			eol = len(data) - offset
+		}
+		line := string(data[offset : offset+eol])
+		offset += eol + 1
+		return line, true
+	}
+
+	line, _ := nextLine()
+	if !strings.HasPrefix(line, "certificate version ") {
+		return nil, MakeGitError("push certificate doesn't start with the version", ErrInvalid)
+	}
+	cert.Version = strings.TrimPrefix(line, "certificate version ")
+	if cert.Version != pushCertificateVersion {
+		return nil, MakeGitError(fmt.Sprintf("unsupported push certificate version '%s'", cert.Version), ErrInvalid)
+	}
+	for {
+		start := offset
+		line, ok := nextLine()
+		if !ok {
+			return nil, MakeGitError("push certificate has no commands", ErrInvalid)
+		}
+		if line == "" {
+			break
+		}
+		switch {
+		case strings.HasPrefix(line, "pusher "):
+			pusher, _, err := parseSignature(data, start, []byte("pusher "))
+			if err != nil {
+				return nil, err
+			}
+			cert.Pusher = pusher
+		case strings.HasPrefix(line, "pushee "):
+			cert.Pushee = strings.TrimPrefix(line, "pushee ")
+		case strings.HasPrefix(line, "nonce "):
+			cert.Nonce = strings.TrimPrefix(line, "nonce ")
+		case strings.HasPrefix(line, "push-option "):
+			cert.PushOptions = append(cert.PushOptions, strings.TrimPrefix(line, "push-option "))
+		}
+	}
+	if cert.Pusher == nil {
+		return nil, MakeGitError("push certificate has no pusher", ErrInvalid)
+	}
+	for {
+		start := offset
+		line, ok := nextLine()
+		if !ok {
+			break
+		}
+		if isSignatureStart(line) {
+			cert.payload = string(data[:start])
+			cert.Signature = string(data[start:])
+			break
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			return nil, MakeGitError(fmt.Sprintf("invalid push certificate command '%s'", line), ErrInvalid)
+		}
+		oldId, err := NewOid(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		newId, err := NewOid(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		cert.Commands = append(cert.Commands, &PushCertificateCommand{
+			OldId:   oldId,
+			NewId:   newId,
+			RefName: fields[2],
+		})
+	}
+	if cert.Signature == "" {
+		cert.payload = string(data)
+	}
+	return cert, nil
+}
+
+// Verify checks the signature of the certificate with the verifier. It
+// fails with ErrNotFound if the certificate is not signed.
+func (c *PushCertificate) Verify(verifier VerifierCallback) (*SignatureVerification, error) {
+	if c.Signature == "" {
+		return nil, MakeGitError("push certificate is not signed", ErrNotFound)
+	}
+	return verifier(c.Signature, c.Payload())
+}
+
+// Command returns the command for the reference, or nil. receive-pack
+// rejects updates that the certificate doesn't list.
+func (c *PushCertificate) Command(refName string) *PushCertificateCommand {
+	for _, command := range c.Commands {
+		if command.RefName == refName {
+			return command
+		}
+	}
+	return nil
+}
+
+// NewPushCertNonce creates the nonce that receive-pack advertises with the
+// push-cert capability, like git: the time stamp and the HMAC-SHA1 of the
+// repository path and the time stamp with receive.certNonceSeed as the key.
+func NewPushCertNonce(seed, path string, stamp time.Time) string {
+	return fmt.Sprintf("%d-%s", stamp.Unix(), pushCertNonceHmac(seed, path, stamp.Unix()))
+}
+
+// CheckNonce compares the nonce of the certificate with the one that was
+// advertised. With a positive slop (receive.certNonceSlop), a nonce that
+// this server made for the same path at most slop earlier or later is
+// accepted as PushCertNonceSlop, e.g. for stateless HTTP, where the
+// advertisement and the push are different requests.
+func (c *PushCertificate) CheckNonce(advertised, seed, path string, slop time.Duration) PushCertNonceStatus {
+	if advertised == "" {
+		return PushCertNonceUnsolicited
+	}
+	if c.Nonce == "" {
+		return PushCertNonceMissing
+	}
+	if c.Nonce == advertised {
+		return PushCertNonceOk
+	}
+	if slop <= 0 {
+		return PushCertNonceBad
+	}
+	stamp, ok := pushCertNonceStamp(c.Nonce)
+	if !ok || NewPushCertNonce(seed, path, time.Unix(stamp, 0)) != c.Nonce {
+		return PushCertNonceBad
+	}
+	advertisedStamp, ok := pushCertNonceStamp(advertised)
+	if !ok {
+		return PushCertNonceBad
+	}
+	difference := time.Duration(stamp-advertisedStamp) * time.Second
+	if difference < 0 {
+		difference = -difference
+	}
+	if difference > slop {
+		return PushCertNonceBad
+	}
+	return PushCertNonceSlop
+}
+
+// StorePushCertificate writes the certificate as a blob, like receive-pack,
+// whose id is GIT_PUSH_CERT of the hooks.
+func (r *Repository) StorePushCertificate(cert *PushCertificate) (*Oid, error) {
+	odb, err := r.Odb()
+	if err != nil {
+		return nil, err
+	}
+	return odb.Write([]byte(cert.String()), ObjectBlob)
+}
+
+// HookEnvironment returns the GIT_PUSH_CERT variables that receive-pack
+// gives to the pre-receive and post-receive hooks. certId is the blob of
+// StorePushCertificate, and verification and verifyErr are the result of
+// Verify. GIT_PUSH_CERT_STATUS is "G" for a good signature, "N" without a
+// signature, "E" if the key is unknown and "B" for a bad signature.
+func (c *PushCertificate) HookEnvironment(certId *Oid, verification *SignatureVerification, verifyErr error, nonceStatus PushCertNonceStatus) []string {
+	status := "G"
+	var signer, key string
+	switch {
+	case c.Signature == "":
+		status = "N"
+	case IsErrorCode(verifyErr, ErrNotFound):
+		status = "E"
+	case verifyErr != nil || verification == nil:
+		status = "B"
+	default:
+		signer = verification.Signer
+		key = verification.KeyId
+	}
+	env := []string{
+		"GIT_PUSH_CERT=" + certId.String(),
+		"GIT_PUSH_CERT_SIGNER=" + signer,
+		"GIT_PUSH_CERT_KEY=" + key,
+		"GIT_PUSH_CERT_STATUS=" + status,
+	}
+	if c.Nonce != "" {
+		env = append(env,
+			"GIT_PUSH_CERT_NONCE="+c.Nonce,
+			"GIT_PUSH_CERT_NONCE_STATUS="+string(nonceStatus))
+	}
+	return env
+}
+
+// internal functions and methods
+
+func isSignatureStart(line string) bool {
+	for _, prefix := range tagSignaturePrefixes {
+		if line == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+func pushCertNonceHmac(seed, path string, stamp int64) string {
+	mac := hmac.New(sha1.New, []byte(seed))
+	fmt.Fprintf(mac, "%s:%d", path, stamp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func pushCertNonceStamp(nonce string) (int64, bool) {
+	dash := strings.IndexByte(nonce, '-')
+	if dash == -1 {
+		return 0, false
+	}
+	stamp, err := strconv.ParseInt(nonce[:dash], 10, 64)
+	return stamp, err == nil
+}
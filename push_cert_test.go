@@ -0,0 +1,148 @@
+package git4go
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_PushCertificate(t *testing.T) {
+	oldId, _ := NewOid("0000000000000000000000000000000000000000")
+	newId, _ := NewOid("1385f264afb75a56a5bec74243be9b367ba4ca08")
+	pusher := &Signature{
+		Name:  "Alice",
+		Email: "alice@example.com",
+		When:  time.Unix(1400000000, 0).In(time.FixedZone("", 9*60*60)),
+	}
+	nonce := NewPushCertNonce("seed", "/srv/repo.git", time.Unix(1400000000, 0))
+	cert := NewPushCertificate(pusher, "https://example.com/repo.git", nonce, []*PushCertificateCommand{
+		{OldId: oldId, NewId: newId, RefName: "refs/heads/master"},
+	})
+	cert.PushOptions = []string{"ci.skip"}
+
+	expected := "certificate version 0.1\n" +
+		"pusher Alice <alice@example.com> 1400000000 +0900\n" +
+		"pushee https://example.com/repo.git\n" +
+		"nonce " + nonce + "\n" +
+		"push-option ci.skip\n" +
+		"\n" +
+		"0000000000000000000000000000000000000000 1385f264afb75a56a5bec74243be9b367ba4ca08 refs/heads/master\n"
+	if cert.Payload() != expected {
+		t.Error("it should format the payload like git push --signed:", cert.Payload())
+	}
+
+	signature := "-----BEGIN PGP SIGNATURE-----\n\nfake\n-----END PGP SIGNATURE-----"
+	err := cert.Sign(func(payload string) (string, string, error) {
+		return signature, "", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParsePushCertificate([]byte(cert.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Pusher.Name != "Alice" || parsed.Pushee != "https://example.com/repo.git" || parsed.Nonce != nonce {
+		t.Error("it should parse the header lines")
+	}
+	if len(parsed.PushOptions) != 1 || len(parsed.Commands) != 1 || !parsed.Commands[0].NewId.Equal(newId) {
+		t.Error("it should parse the options and the commands")
+	}
+	if parsed.Command("refs/heads/master") == nil || parsed.Command("refs/heads/other") != nil {
+		t.Error("it should find the command of the reference")
+	}
+	if parsed.Signature != signature+"\n" || parsed.Payload() != expected {
+		t.Error("it should separate the signature from the payload")
+	}
+
+	verification, err := parsed.Verify(func(sig, payload string) (*SignatureVerification, error) {
+		if sig != signature+"\n" || payload != expected {
+			return nil, errors.New("bad signature")
+		}
+		return &SignatureVerification{Signer: "Alice", KeyId: "ABCD"}, nil
+	})
+	if err != nil || verification.Signer != "Alice" {
+		t.Error("it should verify the payload with the signature", err)
+	}
+
+	certId, _ := NewOid("f1f4b3d6b4c1e0b1c8a4b0f1d6d3b1f9d0d2a5c7")
+	env := strings.Join(parsed.HookEnvironment(certId, verification, nil, PushCertNonceOk), "\n")
+	if !strings.Contains(env, "GIT_PUSH_CERT_STATUS=G") || !strings.Contains(env, "GIT_PUSH_CERT_KEY=ABCD") ||
+		!strings.Contains(env, "GIT_PUSH_CERT_NONCE_STATUS=OK") || !strings.Contains(env, "GIT_PUSH_CERT="+certId.String()) {
+		t.Error("it should pass the certificate to the hooks:", env)
+	}
+	env = strings.Join(parsed.HookEnvironment(certId, nil, errors.New("bad"), PushCertNonceOk), "\n")
+	if !strings.Contains(env, "GIT_PUSH_CERT_STATUS=B") {
+		t.Error("it should report a bad signature")
+	}
+
+	_, err = ParsePushCertificate([]byte("certificate version 0.2\n"))
+	if !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should reject unknown versions")
+	}
+}
+
+func Test_PushCertificate_Raw(t *testing.T) {
+	// an unknown header and a pusher line that git would not write again
+	payload := "certificate version 0.1\n" +
+		"pusher Alice  <alice@example.com> 1400000000 +0900\n" +
+		"nonce 1400000000-abc\n" +
+		"x-future header\n" +
+		"\n" +
+		"0000000000000000000000000000000000000000 1385f264afb75a56a5bec74243be9b367ba4ca08 refs/heads/master\n"
+	signature := "-----BEGIN PGP SIGNATURE-----\n\nfake\n-----END PGP SIGNATURE-----\n"
+	cert, err := ParsePushCertificate([]byte(payload + signature))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cert.Verify(func(sig, signed string) (*SignatureVerification, error) {
+		if sig != signature || signed != payload {
+			return nil, errors.New("bad signature")
+		}
+		return &SignatureVerification{Signer: "Alice"}, nil
+	})
+	if err != nil {
+		t.Error("it should verify the received payload:", err)
+	}
+
+	repo, _ := NewInMemoryRepository()
+	certId, _ := repo.StorePushCertificate(cert)
+	blob, _ := repo.LookupBlob(certId)
+	if string(blob.Contents()) != payload+signature {
+		t.Errorf("it should store the received certificate: %q", blob.Contents())
+	}
+}
+
+func Test_PushCertificate_CheckNonce(t *testing.T) {
+	path := "/srv/repo.git"
+	now := time.Unix(1400000000, 0)
+	advertised := NewPushCertNonce("seed", path, now)
+	cert := &PushCertificate{Nonce: advertised}
+
+	if cert.CheckNonce(advertised, "seed", path, 0) != PushCertNonceOk {
+		t.Error("it should accept the advertised nonce")
+	}
+	if cert.CheckNonce("", "seed", path, 0) != PushCertNonceUnsolicited {
+		t.Error("it should report a nonce that was not asked for")
+	}
+	if (&PushCertificate{}).CheckNonce(advertised, "seed", path, 0) != PushCertNonceMissing {
+		t.Error("it should report a missing nonce")
+	}
+
+	cert.Nonce = NewPushCertNonce("seed", path, now.Add(-30*time.Second))
+	if cert.CheckNonce(advertised, "seed", path, 0) != PushCertNonceBad {
+		t.Error("it should reject another nonce without slop")
+	}
+	if cert.CheckNonce(advertised, "seed", path, time.Minute) != PushCertNonceSlop {
+		t.Error("it should accept an earlier nonce within the slop")
+	}
+	if cert.CheckNonce(advertised, "seed", path, 10*time.Second) != PushCertNonceBad {
+		t.Error("it should reject a nonce outside of the slop")
+	}
+	cert.Nonce = NewPushCertNonce("other seed", path, now.Add(-30*time.Second))
+	if cert.CheckNonce(advertised, "seed", path, time.Minute) != PushCertNonceBad {
+		t.Error("it should reject a nonce that another server made")
+	}
+}
@@ -0,0 +1,550 @@
+package git4go
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	// RangeDiffCreationFactor is the default of RangeDiffOptions, in
+	// percent like "git range-diff --creation-factor"
+	RangeDiffCreationFactor = 60
+
+	rangeDiffContext = 3
+	rangeDiffCostMax = 1 << 16
+)
+
+// RangeDiffStatus is the relation of the commits of a RangeDiffPair. The
+// values are the characters of "git range-diff".
+type RangeDiffStatus byte
+
+const (
+	// the patches are the same
+	RangeDiffEqual RangeDiffStatus = '='
+	// the new commit is a changed version of the old one
+	RangeDiffModified RangeDiffStatus = '!'
+	// the old commit has no counterpart
+	RangeDiffRemoved RangeDiffStatus = '<'
+	// the new commit has no counterpart
+	RangeDiffAdded RangeDiffStatus = '>'
+)
+
+func (s RangeDiffStatus) String() string {
+	return string(s)
+}
+
+type RangeDiffOptions struct {
+	// CreationFactor is the percentage of the size of a patch that pairing
+	// it with another patch may cost before the two are treated as a
+	// removed and an added commit. 0 means RangeDiffCreationFactor.
+	CreationFactor int
+}
+
+// RangeDiffPair is a line of the output of "git range-diff".
+type RangeDiffPair struct {
+	Status RangeDiffStatus
+	// The positions of the commits in their ranges, starting at 1; 0 if
+	// there is no commit
+	OldNumber int
+	NewNumber int
+	Old       *Commit
+	New       *Commit
+	// Diff is the difference of the patches for RangeDiffModified, whose
+	// lines start with ' ', '-' or '+' and whose hunks start with "@@"
+	Diff string
+}
+
+// RangeDiff compares two versions of a patch series, like "git range-diff
+// old-base..old new-base..new", e.g. for a review tool that shows what a
+// force-push changed. Merge commits are skipped. The patches with the same
+// changes are paired first; the others are paired so that the differences
+// of the pairs are smallest, where leaving a commit alone costs
+// CreationFactor percent of the size of its patch. The assignment is the
+// optimal one; git occasionally settles for a costlier one. The pairs are
+// in the order of the new range, with the removed commits where git shows
+// them.
+func (r *Repository) RangeDiff(oldRange, newRange string, opts *RangeDiffOptions) ([]*RangeDiffPair, error) {
+	if opts == nil {
+		opts = &RangeDiffOptions{}
+	}
+	creationFactor := opts.CreationFactor
+	if creationFactor <= 0 {
+		creationFactor = RangeDiffCreationFactor
+	}
+	oldPatches, err := r.rangeDiffPatches(oldRange)
+	if err != nil {
+		return nil, err
+	}
+	newPatches, err := r.rangeDiffPatches(newRange)
+	if err != nil {
+		return nil, err
+	}
+	findExactMatches(oldPatches, newPatches)
+	findCorrespondences(oldPatches, newPatches, creationFactor)
+	return rangeDiffOutput(oldPatches, newPatches), nil
+}
+
+// internal functions and methods
+
+type rangeDiffPatch struct {
+	commit *Commit
+	number int
+	// text is the whole patch and diff its part after the commit message,
+	// which is compared to pair the commits
+	text     string
+	diff     string
+	diffSize int
+	matching int
+	shown    bool
+}
+
+// rangeDiffPatches returns the patches of the non-merge commits of the
+// range, the oldest first.
+func (r *Repository) rangeDiffPatches(spec string) ([]*rangeDiffPatch, error) {
+	revspec, err := r.Revparse(spec)
+	if err != nil {
+		return nil, err
+	}
+	if revspec.Flags() != RevparseRange {
+		return nil, MakeGitError(fmt.Sprintf("'%s' is not a range like 'base..tip'", spec), ErrInvalidSpec)
+	}
+	walk, err := r.Walk()
+	if err != nil {
+		return nil, err
+	}
+	walk.Sorting(SortTopological | SortReverse)
+	if err := walk.Push(revspec.To().Id()); err != nil {
+		return nil, err
+	}
+	if err := walk.Hide(revspec.From().Id()); err != nil {
+		return nil, err
+	}
+	var patches []*rangeDiffPatch
+	err = walk.Iterate(func(commit *Commit) bool {
+		if commit.ParentCount() > 1 {
+			return true
+		}
+		patches = append(patches, &rangeDiffPatch{commit: commit, number: len(patches) + 1, matching: -1})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, patch := range patches {
+		if err := r.formatRangeDiffPatch(patch); err != nil {
+			return nil, err
+		}
+	}
+	return patches, nil
+}
+
+// formatRangeDiffPatch formats the commit like git range-diff before it
+// compares the patches. The hunk headers have no line numbers, so patches
+// that only moved in their files are equal. Renames are not detected.
+func (r *Repository) formatRangeDiffPatch(patch *rangeDiffPatch) error {
+	var buffer bytes.Buffer
+	author := patch.commit.Author()
+	fmt.Fprintf(&buffer, " ## Metadata ##\nAuthor: %s <%s>\n\n ## Commit message ##\n", author.Name, author.Email)
+	for _, line := range strings.Split(strings.TrimRight(patch.commit.Message(), "\n"), "\n") {
+		buffer.WriteString(strings.TrimRight("    "+line, " \t\r\v\f") + "\n")
+	}
+	diffOffset := 0
+	files := 0
+	defer func() {
+		patch.text = buffer.String()
+		patch.diff = patch.text[diffOffset:]
+		if files > 0 {
+			// the blank lines between the files are not counted
+			patch.diffSize = strings.Count(patch.diff, "\n") - files + 1
+		}
+	}()
+
+	tree, err := patch.commit.Tree()
+	if err != nil {
+		return err
+	}
+	var parentTree *Tree
+	if patch.commit.ParentCount() > 0 {
+		parentTree, err = r.commitTree(patch.commit.ParentId(0))
+		if err != nil {
+			return err
+		}
+	}
+	iter, err := r.NewTreeDiffIterator(parentTree, tree)
+	if err != nil {
+		return err
+	}
+	for {
+		entry, err := iter.Next()
+		if IsErrorCode(err, ErrIterOver) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		buffer.WriteByte('\n')
+		if files == 0 {
+			diffOffset = buffer.Len()
+		}
+		files++
+		switch {
+		case entry.Status == DeltaAdded:
+			fmt.Fprintf(&buffer, " ## %s (new) ##\n", entry.Path)
+		case entry.Status == DeltaDeleted:
+			fmt.Fprintf(&buffer, " ## %s (deleted) ##\n", entry.Path)
+		case entry.OldMode != entry.NewMode:
+			fmt.Fprintf(&buffer, " ## %s (mode change %06o => %06o) ##\n", entry.Path, entry.OldMode, entry.NewMode)
+		default:
+			fmt.Fprintf(&buffer, " ## %s ##\n", entry.Path)
+		}
+		oldContent, err := r.rangeDiffContent(entry.OldId, entry.OldMode)
+		if err != nil {
+			return err
+		}
+		newContent, err := r.rangeDiffContent(entry.NewId, entry.NewMode)
+		if err != nil {
+			return err
+		}
+		if isBinaryContent(oldContent) || isBinaryContent(newContent) {
+			oldPath, newPath := entry.Path, entry.Path
+			if entry.Status == DeltaAdded {
+				oldPath = "/dev/null"
+			} else if entry.Status == DeltaDeleted {
+				newPath = "/dev/null"
+			}
+			fmt.Fprintf(&buffer, " Binary files %s and %s differ\n", oldPath, newPath)
+			continue
+		}
+		path := entry.Path
+		writeUnifiedDiff(&buffer, oldContent, newContent, func(lines [][]byte, start int) string {
+			if funcLine := diffFuncLine(lines, start, defaultFuncLine); funcLine != "" {
+				return "@@ " + path + ": " + funcLine
+			}
+			return "@@"
+		})
+	}
+}
+
+// rangeDiffContent returns the content of the side of a changed file; a
+// submodule is its commit like "git diff --submodule=short".
+func (r *Repository) rangeDiffContent(oid *Oid, mode Filemode) ([]byte, error) {
+	if oid == nil || mode == 0 {
+		return nil, nil
+	}
+	if mode == FilemodeCommit {
+		return []byte("Subproject commit " + oid.String() + "\n"), nil
+	}
+	blob, err := r.LookupBlob(oid)
+	if err != nil {
+		return nil, err
+	}
+	return blob.Contents(), nil
+}
+
+func isBinaryContent(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return bytes.IndexByte(content, 0) != -1
+}
+
+// writeUnifiedDiff writes the changed lines with three lines of context.
+// Each hunk starts with the header that hunkHeader returns for the old
+// lines and the first line of the hunk.
+func writeUnifiedDiff(buffer *bytes.Buffer, oldContent, newContent []byte, hunkHeader func(lines [][]byte, start int) string) {
+	ids := make(map[string]int)
+	oldLines, oldIds := splitLines(oldContent, ids)
+	newLines, newIds := splitLines(newContent, ids)
+	hunks := diffLines(oldIds, newIds)
+	for start := 0; start < len(hunks); {
+		end := start + 1
+		for end < len(hunks) && hunks[end].aStart-hunks[end-1].aEnd <= 2*rangeDiffContext {
+			end++
+		}
+		position := hunks[start].aStart - rangeDiffContext
+		if position < 0 {
+			position = 0
+		}
+		buffer.WriteString(hunkHeader(oldLines, position) + "\n")
+		for _, hunk := range hunks[start:end] {
+			for ; position < hunk.aStart; position++ {
+				writeDiffLine(buffer, ' ', oldLines[position])
+			}
+			for i := hunk.aStart; i < hunk.aEnd; i++ {
+				writeDiffLine(buffer, '-', oldLines[i])
+			}
+			for i := hunk.bStart; i < hunk.bEnd; i++ {
+				writeDiffLine(buffer, '+', newLines[i])
+			}
+			position = hunk.aEnd
+		}
+		limit := hunks[end-1].aEnd + rangeDiffContext
+		if limit > len(oldLines) {
+			limit = len(oldLines)
+		}
+		for ; position < limit; position++ {
+			writeDiffLine(buffer, ' ', oldLines[position])
+		}
+		start = end
+	}
+}
+
+// diffFuncLine returns the function line of the hunk that starts at the
+// line start: the last line before it that match accepts, like the
+// function context of git's hunk headers.
+func diffFuncLine(lines [][]byte, start int, match func(line []byte) (string, bool)) string {
+	for i := start - 1; i >= 0; i-- {
+		if funcLine, ok := match(lines[i]); ok {
+			return funcLine
+		}
+	}
+	return ""
+}
+
+// defaultFuncLine accepts the lines that start with a letter, '_' or '$'
+// like xdiff, truncated to 80 bytes.
+func defaultFuncLine(line []byte) (string, bool) {
+	if len(line) == 0 {
+		return "", false
+	}
+	c := line[0]
+	if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$') {
+		return "", false
+	}
+	if len(line) > 80 {
+		line = line[:80]
+	}
+	return strings.TrimRight(string(line), " \t\r\n\v\f"), true
+}
+
+// patchSectionLine accepts the section and hunk headers of the patch
+// texts, like the funcname pattern that git range-diff uses.
+func patchSectionLine(line []byte) (string, bool) {
+	text := strings.TrimRight(string(line), "\n")
+	if strings.HasPrefix(text, " ## ") && strings.HasSuffix(text, " ##") && len(text) >= 7 {
+		return text[4 : len(text)-3], true
+	}
+	if strings.HasPrefix(text, "@@ ") {
+		return text[3:], true
+	}
+	if len(text) > 0 && strings.HasPrefix(text[1:], "@@ ") {
+		return text[4:], true
+	}
+	return "", false
+}
+
+func writeDiffLine(buffer *bytes.Buffer, prefix byte, line []byte) {
+	buffer.WriteByte(prefix)
+	buffer.Write(line)
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		buffer.WriteString("\n \\ No newline at end of file\n")
+	}
+}
+
+// patchDistance returns the number of the lines of the diff of the texts
+// without the hunk headers, the cost of pairing two patches in git.
+func patchDistance(a, b string) int {
+	var buffer bytes.Buffer
+	hunks := 0
+	writeUnifiedDiff(&buffer, []byte(a), []byte(b), func(lines [][]byte, start int) string {
+		hunks++
+		return "@@"
+	})
+	return strings.Count(buffer.String(), "\n") - hunks
+}
+
+// findExactMatches pairs the patches with the same diff, the first ones
+// first.
+func findExactMatches(oldPatches, newPatches []*rangeDiffPatch) {
+	byDiff := make(map[string][]int)
+	for i, patch := range oldPatches {
+		byDiff[patch.diff] = append(byDiff[patch.diff], i)
+	}
+	for j, patch := range newPatches {
+		candidates := byDiff[patch.diff]
+		if len(candidates) == 0 {
+			continue
+		}
+		i := candidates[0]
+		byDiff[patch.diff] = candidates[1:]
+		oldPatches[i].matching = j
+		patch.matching = i
+	}
+}
+
+// findCorrespondences pairs the other patches with the assignment of the
+// least cost, like git: the matrix has a row for each old patch and for
+// each new patch that is left alone, and a column for each new patch and
+// for each old patch that is left alone.
+func findCorrespondences(oldPatches, newPatches []*rangeDiffPatch, creationFactor int) {
+	n := len(oldPatches) + len(newPatches)
+	if n == 0 {
+		return
+	}
+	// the costs are scaled so that one more than the number of the pairs
+	// breaks the ties, which git resolves by leaving the commits alone
+	scale := n + 1
+	cost := make([][]int, n)
+	for i := range cost {
+		cost[i] = make([]int, n)
+	}
+	for i, oldPatch := range oldPatches {
+		for j, newPatch := range newPatches {
+			switch {
+			case oldPatch.matching == j:
+				cost[i][j] = 0
+			case oldPatch.matching < 0 && newPatch.matching < 0:
+				cost[i][j] = patchDistance(oldPatch.diff, newPatch.diff)*scale + 1
+			default:
+				cost[i][j] = rangeDiffCostMax * scale
+			}
+		}
+		alone := rangeDiffCostMax
+		if oldPatch.matching < 0 {
+			alone = oldPatch.diffSize * creationFactor / 100
+		}
+		for j := len(newPatches); j < n; j++ {
+			cost[i][j] = alone * scale
+		}
+	}
+	for j, newPatch := range newPatches {
+		alone := rangeDiffCostMax
+		if newPatch.matching < 0 {
+			alone = newPatch.diffSize * creationFactor / 100
+		}
+		for i := len(oldPatches); i < n; i++ {
+			cost[i][j] = alone * scale
+		}
+	}
+
+	for i, j := range minimumCostAssignment(cost) {
+		if i < len(oldPatches) && j < len(newPatches) && oldPatches[i].matching < 0 && newPatches[j].matching < 0 {
+			oldPatches[i].matching = j
+			newPatches[j].matching = i
+		}
+	}
+}
+
+// minimumCostAssignment solves the assignment problem of the square matrix
+// with the Hungarian algorithm. It returns the column of each row.
+func minimumCostAssignment(cost [][]int) []int {
+	n := len(cost)
+	const infinity = int(^uint(0) >> 1)
+	// the potentials and the matching are 1-based; column 0 holds the row
+	// that is being added
+	u := make([]int, n+1)
+	v := make([]int, n+1)
+	rowOf := make([]int, n+1)
+	way := make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		rowOf[0] = i
+		column := 0
+		minimum := make([]int, n+1)
+		used := make([]bool, n+1)
+		for j := range minimum {
+			minimum[j] = infinity
+		}
+		for {
+			used[column] = true
+			row := rowOf[column]
+			delta := infinity
+			next := 0
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+				current := cost[row-1][j-1] - u[row] - v[j]
+				if current < minimum[j] {
+					minimum[j] = current
+					way[j] = column
+				}
+				if minimum[j] < delta {
+					delta = minimum[j]
+					next = j
+				}
+			}
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[rowOf[j]] += delta
+					v[j] -= delta
+				} else {
+					minimum[j] -= delta
+				}
+			}
+			column = next
+			if rowOf[column] == 0 {
+				break
+			}
+		}
+		for column != 0 {
+			previous := way[column]
+			rowOf[column] = rowOf[previous]
+			column = previous
+		}
+	}
+	assignment := make([]int, n)
+	for j := 1; j <= n; j++ {
+		assignment[rowOf[j]-1] = j - 1
+	}
+	return assignment
+}
+
+// rangeDiffOutput orders the pairs like git: the new commits in order, and
+// each removed commit as soon as the old commits before it were shown.
+func rangeDiffOutput(oldPatches, newPatches []*rangeDiffPatch) []*RangeDiffPair {
+	var pairs []*RangeDiffPair
+	i, j := 0, 0
+	for i < len(oldPatches) || j < len(newPatches) {
+		if i < len(oldPatches) && oldPatches[i].shown {
+			i++
+			continue
+		}
+		if i < len(oldPatches) && oldPatches[i].matching < 0 {
+			pairs = append(pairs, newRangeDiffPair(oldPatches[i], nil))
+			i++
+			continue
+		}
+		for j < len(newPatches) && newPatches[j].matching < 0 {
+			pairs = append(pairs, newRangeDiffPair(nil, newPatches[j]))
+			j++
+		}
+		if j < len(newPatches) {
+			oldPatch := oldPatches[newPatches[j].matching]
+			pairs = append(pairs, newRangeDiffPair(oldPatch, newPatches[j]))
+			oldPatch.shown = true
+			j++
+		}
+	}
+	return pairs
+}
+
+func newRangeDiffPair(oldPatch, newPatch *rangeDiffPatch) *RangeDiffPair {
+	pair := &RangeDiffPair{}
+	if oldPatch != nil {
+		pair.Old = oldPatch.commit
+		pair.OldNumber = oldPatch.number
+	}
+	if newPatch != nil {
+		pair.New = newPatch.commit
+		pair.NewNumber = newPatch.number
+	}
+	switch {
+	case oldPatch == nil:
+		pair.Status = RangeDiffAdded
+	case newPatch == nil:
+		pair.Status = RangeDiffRemoved
+	case oldPatch.text == newPatch.text:
+		pair.Status = RangeDiffEqual
+	default:
+		pair.Status = RangeDiffModified
+		var buffer bytes.Buffer
+		writeUnifiedDiff(&buffer, []byte(oldPatch.text), []byte(newPatch.text), func(lines [][]byte, start int) string {
+			if section := diffFuncLine(lines, start, patchSectionLine); section != "" {
+				return "@@ " + section
+			}
+			return "@@"
+		})
+		pair.Diff = buffer.String()
+	}
+	return pair
+}
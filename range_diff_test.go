@@ -0,0 +1,100 @@
+package git4go
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeSeriesCommit(repo *Repository, parent *Commit, message string, files map[string]string) *Commit {
+	index, _ := NewIndex()
+	for path, contents := range files {
+		oid, _ := repo.CreateBlobFromBuffer([]byte(contents))
+		index.Add(&IndexEntry{Path: path, Mode: FilemodeBlob, Id: oid})
+	}
+	treeId, _ := index.WriteTreeTo(repo)
+	tree, _ := repo.LookupTree(treeId)
+	sig := &Signature{"A", "a@example.com", time.Unix(1400000000, 0)}
+	var parents []*Commit
+	if parent != nil {
+		parents = append(parents, parent)
+	}
+	oid, _ := repo.CreateCommit("", sig, sig, message+"\n", tree, parents...)
+	commit, _ := repo.LookupCommit(oid)
+	return commit
+}
+
+func rangeDiffSummary(pairs []*RangeDiffPair) string {
+	var result []string
+	for _, pair := range pairs {
+		result = append(result, fmt.Sprintf("%d%s%d", pair.OldNumber, pair.Status, pair.NewNumber))
+	}
+	return strings.Join(result, " ")
+}
+
+func Test_RangeDiff(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	var lines []string
+	for i := 1; i <= 30; i++ {
+		lines = append(lines, fmt.Sprint(i))
+	}
+	content := func(replacements ...string) string {
+		result := append([]string(nil), lines...)
+		for i := 0; i < len(replacements); i += 2 {
+			for j, line := range result {
+				if line == replacements[i] {
+					result[j] = replacements[i+1]
+				}
+			}
+		}
+		return strings.Join(result, "\n") + "\n"
+	}
+	base := writeSeriesCommit(repo, nil, "base", map[string]string{"f": content()})
+
+	oldTip := writeSeriesCommit(repo, base, "change five", map[string]string{"f": content("5", "five")})
+	oldTip = writeSeriesCommit(repo, oldTip, "change fifteen", map[string]string{"f": content("5", "five", "15", "fifteen")})
+	oldTip = writeSeriesCommit(repo, oldTip, "add g", map[string]string{"f": content("5", "five", "15", "fifteen"), "g": "x\n"})
+	oldTip = writeSeriesCommit(repo, oldTip, "change 25", map[string]string{"f": content("5", "five", "15", "fifteen", "25", "twentyfive"), "g": "x\n"})
+
+	h := "0\n"
+	newTip := writeSeriesCommit(repo, base, "add h", map[string]string{"f": content(), "h": h})
+	newTip = writeSeriesCommit(repo, newTip, "change five", map[string]string{"f": content("5", "five"), "h": h})
+	newTip = writeSeriesCommit(repo, newTip, "change fifteen", map[string]string{"f": content("5", "five", "15", "FIFTEEN"), "h": h})
+	newTip = writeSeriesCommit(repo, newTip, "change 25", map[string]string{"f": content("5", "five", "15", "FIFTEEN", "25", "twentyfive"), "h": h})
+	newTip = writeSeriesCommit(repo, newTip, "totally new", map[string]string{"f": content("5", "five", "15", "FIFTEEN", "25", "twentyfive"), "h": h, "k": "a\nb\nc\nd\ne\nf\n"})
+
+	pairs, err := repo.RangeDiff(base.Id().String()+".."+oldTip.Id().String(), base.Id().String()+".."+newTip.Id().String(), nil)
+	if err != nil {
+		t.Fatal("it should compare the ranges:", err)
+	}
+	// like "git range-diff"
+	if result := rangeDiffSummary(pairs); result != "0>1 1=2 2!3 3<0 4!4 0>5" {
+		t.Error("it should pair the commits like git:", result)
+	}
+	if pairs[1].Old.Summary() != "change five" || pairs[1].New.Summary() != "change five" || pairs[1].Diff != "" {
+		t.Error("it should pair the equal patches")
+	}
+	if pairs[0].Old != nil || pairs[0].New.Summary() != "add h" || pairs[3].New != nil || pairs[3].Old.Summary() != "add g" {
+		t.Error("it should return the added and removed commits")
+	}
+	expected := "@@ f: five\n  13\n  14\n -15\n-+fifteen\n++FIFTEEN\n  16\n  17\n  18\n"
+	if pairs[2].Diff != expected {
+		t.Errorf("it should return the difference of the patches: %q", pairs[2].Diff)
+	}
+	// the function context of the hunk changed
+	if !strings.Contains(pairs[4].Diff, "-@@ f: fifteen\n+@@ f: FIFTEEN\n") {
+		t.Errorf("it should compare the hunk headers: %q", pairs[4].Diff)
+	}
+
+	// leaving the large "totally new" alone costs more than leaving "add h"
+	pairs, _ = repo.RangeDiff(base.Id().String()+".."+oldTip.Id().String(), base.Id().String()+".."+newTip.Id().String(), &RangeDiffOptions{CreationFactor: 1000})
+	if result := rangeDiffSummary(pairs); result != "0>1 1=2 2!3 4!4 3!5" {
+		t.Error("it should pair more commits with a higher creation factor:", result)
+	}
+
+	_, err = repo.RangeDiff(oldTip.Id().String(), base.Id().String()+".."+newTip.Id().String(), nil)
+	if !IsErrorCode(err, ErrInvalidSpec) {
+		t.Error("it should reject a revision that is not a range:", err)
+	}
+}
@@ -0,0 +1,734 @@
+package git4go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	GitRebaseMergeDir = "rebase-merge"
+	GitRebaseTodoFile = "git-rebase-todo"
+
+	gitRebaseDoneFile        = "done"
+	gitRebaseOntoFile        = "onto"
+	gitRebaseOrigHeadFile    = "orig-head"
+	gitRebaseHeadNameFile    = "head-name"
+	gitRebaseInteractiveFile = "interactive"
+	gitRebaseStoppedShaFile  = "stopped-sha"
+	gitRebaseDetachedHead    = "detached HEAD"
+)
+
+type RebaseOperationType int
+
+const (
+	// The commit is applied and committed as it is
+	RebaseOperationPick RebaseOperationType = iota
+	// The commit is applied and committed with a new message
+	RebaseOperationReword
+	// The commit is applied, and the rebase stops so that it can be amended
+	RebaseOperationEdit
+	// The commit is melded into the previous one with both messages
+	RebaseOperationSquash
+	// The commit is melded into the previous one with its message
+	RebaseOperationFixup
+	// The command is run by the caller
+	RebaseOperationExec
+	// The commit is left out
+	RebaseOperationDrop
+)
+
+var rebaseOperationCommands = []string{"pick", "reword", "edit", "squash", "fixup", "exec", "drop"}
+var rebaseOperationShortCommands = []string{"p", "r", "e", "s", "f", "x", "d"}
+
+func (t RebaseOperationType) String() string {
+	if t >= 0 && int(t) < len(rebaseOperationCommands) {
+		return rebaseOperationCommands[t]
+	}
+	return fmt.Sprintf("RebaseOperationType(%d)", int(t))
+}
+
+type RebaseOperation struct {
+	Type RebaseOperationType
+	// The commit of the operation; it is nil for exec
+	Id *Oid
+	// The command of exec
+	Exec string
+}
+
+// RebaseTodo is the todo list of an interactive rebase, as it is in
+// .git/rebase-merge/git-rebase-todo. The operations can be reordered,
+// changed, added and removed before Rebase.Next processes them.
+type RebaseTodo struct {
+	Operations []*RebaseOperation
+	repo       *Repository
+}
+
+// ParseRebaseTodo parses a todo list in the format of git. The commands
+// may be abbreviated to their first letters, and so may the commits.
+func (r *Repository) ParseRebaseTodo(data []byte) (*RebaseTodo, error) {
+	todo := &RebaseTodo{repo: r}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		operation, err := r.parseRebaseOperation(line)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid rebase todo at line %d: %s", i+1, err.Error()))
+		}
+		todo.Operations = append(todo.Operations, operation)
+	}
+	return todo, nil
+}
+
+// Bytes formats the todo list like git, with the summaries of the commits.
+func (t *RebaseTodo) Bytes() []byte {
+	var buffer bytes.Buffer
+	for _, operation := range t.Operations {
+		buffer.WriteString(operation.Type.String())
+		buffer.WriteByte(' ')
+		if operation.Type == RebaseOperationExec {
+			buffer.WriteString(operation.Exec)
+		} else {
+			buffer.WriteString(operation.Id.String())
+			if commit, err := t.repo.LookupCommit(operation.Id); err == nil {
+				buffer.WriteByte(' ')
+				buffer.WriteString(commit.Summary())
+			}
+		}
+		buffer.WriteByte('\n')
+	}
+	return buffer.Bytes()
+}
+
+// Validate checks that the operations can be processed: each operation
+// other than exec has a commit of the repository, each exec has a command
+// of a single line, and squash and fixup have a commit to meld into.
+func (t *RebaseTodo) Validate() error {
+	return t.validate(false)
+}
+
+// validate checks the operations; picked tells that a commit was picked
+// before the first one.
+func (t *RebaseTodo) validate(picked bool) error {
+	for i, operation := range t.Operations {
+		switch operation.Type {
+		case RebaseOperationExec:
+			if strings.TrimSpace(operation.Exec) == "" || strings.ContainsAny(operation.Exec, "\r\n") {
+				return MakeGitError(fmt.Sprintf("invalid exec command at %d: '%s'", i, operation.Exec), ErrInvalid)
+			}
+			continue
+		case RebaseOperationPick, RebaseOperationReword, RebaseOperationEdit, RebaseOperationDrop:
+		case RebaseOperationSquash, RebaseOperationFixup:
+			if !picked {
+				return MakeGitError(fmt.Sprintf("cannot '%s' without a previous commit", operation.Type), ErrInvalid)
+			}
+		default:
+			return MakeGitError(fmt.Sprintf("unknown rebase operation at %d: %s", i, operation.Type), ErrInvalid)
+		}
+		if operation.Id == nil {
+			return MakeGitError(fmt.Sprintf("'%s' at %d has no commit", operation.Type, i), ErrInvalid)
+		}
+		if _, err := t.repo.LookupCommit(operation.Id); err != nil {
+			return err
+		}
+		if operation.Type != RebaseOperationDrop {
+			picked = true
+		}
+	}
+	return nil
+}
+
+type RebaseOptions struct {
+	// The options of the merges of the commits
+	MergeOptions *MergeOptions
+	// Local changes are stashed before the rebase and applied again when it
+	// finishes or is aborted. By default, it is rebase.autoStash.
+	AutoStash AutoStashMode
+	// The pre-rebase hook is not run, like "git rebase --no-verify". A hook
+	// that rejects the rebase fails InitRebase with a *HookError.
+	NoVerify bool
+}
+
+// Rebase applies the commits of a branch onto another commit one by one,
+// by the operations of its todo list. Its state is kept in
+// .git/rebase-merge like an interactive rebase of git.
+type Rebase struct {
+	repo     *Repository
+	opts     *RebaseOptions
+	headName string
+	origHead *Oid
+	onto     *Oid
+	todo     *RebaseTodo
+	done     []*RebaseOperation
+}
+
+// InitRebase starts to rebase the commits of the branch that are not in
+// upstream onto the commit onto. An empty branch is HEAD, and onto is
+// upstream if it is nil. Merge commits are left out, like git does by
+// default. The working directory is checked out to onto with a detached
+// HEAD; the commits are not applied until Next is called, so the todo list
+// can be edited first.
+func (r *Repository) InitRebase(branch string, upstream, onto *Commit, opts *RebaseOptions) (*Rebase, error) {
+	if r.readOnly {
+		return nil, errReadOnly("Repository.InitRebase")
+	}
+	if r.IsBare() {
+		return nil, MakeGitError("cannot rebase in a bare repository", ErrBareRepository)
+	}
+	if opts == nil {
+		opts = &RebaseOptions{}
+	}
+	if onto == nil {
+		onto = upstream
+	}
+	if onto == nil {
+		return nil, MakeGitError("a rebase needs an upstream or a commit to rebase onto", ErrInvalid)
+	}
+	if _, err := r.fs.Stat(r.rebasePath("")); err == nil {
+		return nil, MakeGitError("a rebase is already in progress", ErrExists)
+	}
+	hookArgs := []string{"--root"}
+	if upstream != nil {
+		hookArgs[0] = upstream.Id().String()
+	}
+	if branch != "" {
+		hookArgs = append(hookArgs, branch)
+	}
+	err := r.runHook("pre-rebase", opts.NoVerify, &HookOptions{Args: hookArgs})
+	if err != nil {
+		return nil, err
+	}
+	headName := gitRebaseDetachedHead
+	var ref *Reference
+	if branch == "" {
+		ref, err = r.LookupReference(GitHeadFile)
+		if err == nil && ref.Type() == ReferenceSymbolic {
+			headName = ref.SymbolicTarget()
+			ref, err = ref.Resolve()
+		}
+	} else {
+		ref, err = r.DwimReference(branch)
+		if err == nil {
+			headName = ref.Name()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	origHead := ref.Target()
+
+	walk, err := r.Walk()
+	if err != nil {
+		return nil, err
+	}
+	walk.Sorting(SortTopological | SortReverse)
+	if err = walk.Push(origHead); err != nil {
+		return nil, err
+	}
+	if upstream != nil {
+		if err = walk.Hide(upstream.Id()); err != nil {
+			return nil, err
+		}
+	}
+	todo := &RebaseTodo{repo: r}
+	for {
+		// the walk keeps the oids of the commits that it has looked up
+		oid := new(Oid)
+		err = walk.Next(oid)
+		if IsErrorCode(err, ErrIterOver) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		commit, err := r.LookupCommit(oid)
+		if err != nil {
+			return nil, err
+		}
+		if commit.ParentCount() < 2 {
+			todo.Operations = append(todo.Operations, &RebaseOperation{Type: RebaseOperationPick, Id: oid})
+		}
+	}
+
+	var stash *Oid
+	if r.autoStashEnabled(opts.AutoStash, "rebase.autoStash") {
+		stash, err = r.autostashCreate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	tree, err := onto.Tree()
+	if err != nil {
+		return nil, err
+	}
+	err = r.CheckoutTree(tree, nil)
+	if err != nil {
+		if stash != nil {
+			if headTree, headErr := r.commitTree(origHead); headErr == nil {
+				r.autostashApply(stash, headTree)
+			}
+		}
+		return nil, err
+	}
+	_, err = r.CreateReference(GitHeadFile, onto.Id(), true)
+	if err != nil {
+		return nil, err
+	}
+	rebase := &Rebase{
+		repo:     r,
+		opts:     opts,
+		headName: headName,
+		origHead: origHead,
+		onto:     onto.Id(),
+		todo:     todo,
+	}
+	err = r.fs.MkdirAll(r.rebasePath(""), os.FileMode(GitObjectDirMode))
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]string{
+		gitRebaseHeadNameFile:    headName + "\n",
+		gitRebaseOrigHeadFile:    origHead.String() + "\n",
+		gitRebaseOntoFile:        onto.Id().String() + "\n",
+		gitRebaseInteractiveFile: "",
+	}
+	if stash != nil {
+		files[gitRebaseAutostashFile] = stash.String() + "\n"
+	}
+	for name, contents := range files {
+		err = r.writeGitFile(r.rebasePath(name), []byte(contents))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rebase, rebase.save()
+}
+
+// OpenRebase reads the state of the rebase in progress. It fails with
+// ErrNotFound if there is none.
+func (r *Repository) OpenRebase(opts *RebaseOptions) (*Rebase, error) {
+	if opts == nil {
+		opts = &RebaseOptions{}
+	}
+	rebase := &Rebase{repo: r, opts: opts}
+	data, err := r.fs.ReadFile(r.rebasePath(gitRebaseHeadNameFile))
+	if os.IsNotExist(err) {
+		return nil, MakeGitError("no rebase in progress", ErrNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+	rebase.headName = strings.TrimSpace(string(data))
+	for _, file := range []struct {
+		name string
+		oid  **Oid
+	}{
+		{gitRebaseOrigHeadFile, &rebase.origHead},
+		{gitRebaseOntoFile, &rebase.onto},
+	} {
+		data, err = r.fs.ReadFile(r.rebasePath(file.name))
+		if err != nil {
+			return nil, err
+		}
+		*file.oid, err = NewOid(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("corrupt rebase %s: %s", file.name, err.Error()))
+		}
+	}
+	for _, name := range []string{gitRebaseDoneFile, GitRebaseTodoFile} {
+		data, err = r.fs.ReadFile(r.rebasePath(name))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		todo, err := r.ParseRebaseTodo(data)
+		if err != nil {
+			return nil, err
+		}
+		if name == gitRebaseDoneFile {
+			rebase.done = todo.Operations
+		} else {
+			rebase.todo = todo
+		}
+	}
+	return rebase, nil
+}
+
+// Todo returns the operations that are not processed yet. Changes to it
+// are saved by the next call of Next.
+func (rb *Rebase) Todo() *RebaseTodo {
+	return rb.todo
+}
+
+// OperationCount returns the number of the processed operations and the
+// ones in the todo list.
+func (rb *Rebase) OperationCount() int {
+	return len(rb.done) + len(rb.todo.Operations)
+}
+
+// CurrentOperationIndex returns the index of the operation that Next
+// processed last, or -1 before the first one.
+func (rb *Rebase) CurrentOperationIndex() int {
+	return len(rb.done) - 1
+}
+
+// OperationByIndex returns the processed operation or the operation of
+// the todo list by its index.
+func (rb *Rebase) OperationByIndex(index int) *RebaseOperation {
+	if index < 0 || index >= rb.OperationCount() {
+		return nil
+	}
+	if index < len(rb.done) {
+		return rb.done[index]
+	}
+	return rb.todo.Operations[index-len(rb.done)]
+}
+
+// Next processes the next operation of the todo list and returns it. The
+// commit of the operation is applied to the index and the working
+// directory, and it is committed by Commit; if it conflicts, the
+// conflicts are left in the index to be resolved first. An exec operation
+// is only returned, the caller runs its command. Dropped commits are
+// skipped. It fails with ErrIterOver at the end of the todo list, and with
+// ErrUnmerged while the last operation is not committed.
+func (rb *Rebase) Next() (*RebaseOperation, error) {
+	r := rb.repo
+	if r.readOnly {
+		return nil, errReadOnly("Rebase.Next")
+	}
+	if _, err := r.fs.Stat(r.rebasePath(gitRebaseStoppedShaFile)); err == nil {
+		return nil, MakeGitError("the current operation is not committed", ErrUnmerged)
+	}
+	picked := false
+	for _, operation := range rb.done {
+		picked = picked || (operation.Type != RebaseOperationExec && operation.Type != RebaseOperationDrop)
+	}
+	err := rb.todo.validate(picked)
+	if err != nil {
+		return nil, err
+	}
+	for len(rb.todo.Operations) > 0 {
+		operation := rb.todo.Operations[0]
+		rb.todo.Operations = rb.todo.Operations[1:]
+		rb.done = append(rb.done, operation)
+		if err = rb.save(); err != nil {
+			return nil, err
+		}
+		switch operation.Type {
+		case RebaseOperationDrop:
+			continue
+		case RebaseOperationExec:
+			return operation, nil
+		}
+		err = rb.apply(operation)
+		if err != nil {
+			// the operation is tried again after the cause is fixed, like
+			// local changes that are in the way
+			rb.done = rb.done[:len(rb.done)-1]
+			rb.todo.Operations = append([]*RebaseOperation{operation}, rb.todo.Operations...)
+			rb.save()
+			return nil, err
+		}
+		return operation, nil
+	}
+	return nil, MakeGitError("no more rebase operations", ErrIterOver)
+}
+
+// Commit commits the index for the operation that Next processed last. The
+// author and the message are the ones of the commit of the operation if
+// they are not given; squash and fixup meld the commit into HEAD, with both
+// messages for squash. It fails with ErrUnmerged if the index has
+// conflicts, and with ErrApplied if the commit has become empty.
+func (rb *Rebase) Commit(author, committer *Signature, message string) (*Oid, error) {
+	r := rb.repo
+	if r.readOnly {
+		return nil, errReadOnly("Rebase.Commit")
+	}
+	data, err := r.fs.ReadFile(r.rebasePath(gitRebaseStoppedShaFile))
+	if os.IsNotExist(err) {
+		return nil, MakeGitError("no rebase operation to commit", ErrNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+	oid, err := NewOid(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("corrupt rebase %s: %s", gitRebaseStoppedShaFile, err.Error()))
+	}
+	commit, err := r.LookupCommit(oid)
+	if err != nil {
+		return nil, err
+	}
+	index, err := r.Index()
+	if err != nil {
+		return nil, err
+	}
+	if index.filePath != "" {
+		if err = index.Read(false); err != nil {
+			return nil, err
+		}
+	}
+	if index.HasConflicts() {
+		return nil, MakeGitError("you need to resolve the conflicts before committing", ErrUnmerged)
+	}
+	treeId, err := index.WriteTreeTo(r)
+	if err != nil {
+		return nil, err
+	}
+	head, err := r.headCommit()
+	if err != nil {
+		return nil, err
+	}
+
+	operation := rb.done[len(rb.done)-1]
+	parents := []*Commit{head}
+	if author == nil {
+		author = commit.Author()
+	}
+	if message == "" {
+		message = commit.Message()
+	}
+	if operation.Type == RebaseOperationSquash || operation.Type == RebaseOperationFixup {
+		parents = parents[:0]
+		for i := 0; i < head.ParentCount(); i++ {
+			parent, err := r.LookupCommit(head.ParentId(i))
+			if err != nil {
+				return nil, err
+			}
+			parents = append(parents, parent)
+		}
+		author = head.Author()
+		if operation.Type == RebaseOperationFixup {
+			message = head.Message()
+		} else {
+			message = strings.TrimRight(head.Message(), "\n") + "\n\n" + message
+		}
+	} else if head.TreeId().Equal(treeId) {
+		if err = rb.removeStoppedSha(); err != nil {
+			return nil, err
+		}
+		return nil, MakeGitError(fmt.Sprintf("commit %s has already been applied", oid), ErrApplied)
+	}
+	if committer == nil {
+		committer, err = r.DefaultSignature()
+		if err != nil {
+			return nil, err
+		}
+	}
+	tree, err := r.LookupTree(treeId)
+	if err != nil {
+		return nil, err
+	}
+	newId, err := r.CreateCommit("", author, committer, message, tree, parents...)
+	if err != nil {
+		return nil, err
+	}
+	err = r.updateCommitRef(GitHeadFile, head.Id(), newId)
+	if err != nil {
+		return nil, err
+	}
+	return newId, rb.removeStoppedSha()
+}
+
+// Finish moves the rebased branch to HEAD, checks it out again and removes
+// the state. It fails with ErrInvalid while the todo list has operations.
+// The autostash is applied at last; if it conflicts, it is kept in
+// refs/stash and ErrMergeConflict is returned.
+func (rb *Rebase) Finish() error {
+	r := rb.repo
+	if r.readOnly {
+		return errReadOnly("Rebase.Finish")
+	}
+	if len(rb.todo.Operations) > 0 {
+		return MakeGitError(fmt.Sprintf("the rebase has %d operations left", len(rb.todo.Operations)), ErrInvalid)
+	}
+	if rb.headName != gitRebaseDetachedHead {
+		head, err := r.headCommit()
+		if err != nil {
+			return err
+		}
+		_, err = r.CreateReference(rb.headName, head.Id(), true)
+		if err != nil {
+			return err
+		}
+		_, err = r.CreateSymbolicReference(GitHeadFile, rb.headName, true)
+		if err != nil {
+			return err
+		}
+	}
+	return rb.removeAndApplyAutostash()
+}
+
+// Abort checks out the branch as it was before the rebase with the
+// autostash, and removes the state.
+func (rb *Rebase) Abort() error {
+	r := rb.repo
+	if r.readOnly {
+		return errReadOnly("Rebase.Abort")
+	}
+	tree, err := r.commitTree(rb.origHead)
+	if err != nil {
+		return err
+	}
+	err = r.checkoutReset(tree)
+	if err != nil {
+		return err
+	}
+	if rb.headName == gitRebaseDetachedHead {
+		_, err = r.CreateReference(GitHeadFile, rb.origHead, true)
+	} else {
+		_, err = r.CreateReference(rb.headName, rb.origHead, true)
+		if err == nil {
+			_, err = r.CreateSymbolicReference(GitHeadFile, rb.headName, true)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return rb.removeAndApplyAutostash()
+}
+
+// internal functions and methods
+
+// apply merges the commit of the operation into HEAD, and records it in
+// stopped-sha until it is committed.
+func (rb *Rebase) apply(operation *RebaseOperation) error {
+	r := rb.repo
+	commit, err := r.LookupCommit(operation.Id)
+	if err != nil {
+		return err
+	}
+	head, err := r.headCommit()
+	if err != nil {
+		return err
+	}
+	headTree, err := head.Tree()
+	if err != nil {
+		return err
+	}
+	index, err := r.pickMerge(commit, headTree, false, rb.opts.MergeOptions)
+	if err != nil {
+		return err
+	}
+	if index.HasConflicts() {
+		theirLabel := fmt.Sprintf("%s... %s", r.shortIdForMessage(operation.Id), commit.Summary())
+		_, err = r.checkoutConflicts(index, theirLabel, rb.opts.MergeOptions)
+	} else {
+		var treeId *Oid
+		treeId, err = index.WriteTreeTo(r)
+		if err != nil {
+			return err
+		}
+		var tree *Tree
+		tree, err = r.LookupTree(treeId)
+		if err != nil {
+			return err
+		}
+		err = r.CheckoutTree(tree, nil)
+	}
+	if err != nil {
+		return err
+	}
+	return r.writeGitFile(r.rebasePath(gitRebaseStoppedShaFile), []byte(operation.Id.String()+"\n"))
+}
+
+// save writes the todo list and the processed operations.
+func (rb *Rebase) save() error {
+	r := rb.repo
+	err := r.writeGitFile(r.rebasePath(GitRebaseTodoFile), rb.todo.Bytes())
+	if err != nil {
+		return err
+	}
+	done := &RebaseTodo{Operations: rb.done, repo: r}
+	return r.writeGitFile(r.rebasePath(gitRebaseDoneFile), done.Bytes())
+}
+
+func (rb *Rebase) removeStoppedSha() error {
+	err := rb.repo.fs.Remove(rb.repo.rebasePath(gitRebaseStoppedShaFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// removeAndApplyAutostash removes the state, and applies the local changes
+// that were stashed when the rebase started to HEAD.
+func (rb *Rebase) removeAndApplyAutostash() error {
+	r := rb.repo
+	stash, err := r.readAutostash(r.rebasePath(gitRebaseAutostashFile))
+	if err != nil {
+		return err
+	}
+	if err = rb.remove(); err != nil || stash == nil {
+		return err
+	}
+	head, err := r.headCommit()
+	if err != nil {
+		return err
+	}
+	tree, err := head.Tree()
+	if err != nil {
+		return err
+	}
+	return r.autostashApply(stash, tree)
+}
+
+func (rb *Rebase) remove() error {
+	r := rb.repo
+	names, err := r.fs.ReadDir(r.rebasePath(""))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, info := range names {
+		err = r.fs.Remove(r.rebasePath(info.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	err = r.fs.Remove(r.rebasePath(""))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// parseRebaseOperation parses a line of the todo list, like
+// "pick 1a2b3c4 summary" or "exec make test".
+func (r *Repository) parseRebaseOperation(line string) (*RebaseOperation, error) {
+	fields := strings.Fields(line)
+	operation := &RebaseOperation{Type: -1}
+	for i, command := range rebaseOperationCommands {
+		if fields[0] == command || fields[0] == rebaseOperationShortCommands[i] {
+			operation.Type = RebaseOperationType(i)
+			break
+		}
+	}
+	if operation.Type == -1 {
+		return nil, errors.New(fmt.Sprintf("unknown command '%s'", fields[0]))
+	}
+	if operation.Type == RebaseOperationExec {
+		operation.Exec = strings.TrimSpace(line[len(fields[0]):])
+		if operation.Exec == "" {
+			return nil, errors.New("missing command of exec")
+		}
+		return operation, nil
+	}
+	if len(fields) < 2 {
+		return nil, errors.New(fmt.Sprintf("missing commit in '%s'", line))
+	}
+	prefix, err := NewOidFromPrefix(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	commit, err := r.LookupPrefixCommit(prefix, len(fields[1]))
+	if err != nil {
+		return nil, err
+	}
+	operation.Id = commit.Id()
+	return operation, nil
+}
+
+func (r *Repository) rebasePath(name string) string {
+	return filepath.Join(r.pathRepository, GitRebaseMergeDir, name)
+}
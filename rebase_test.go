@@ -0,0 +1,177 @@
+package git4go
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ParseRebaseTodo(t *testing.T) {
+	repo, _, commits := prepareSequencerRepository(t)
+	defer os.RemoveAll(repo.Workdir())
+
+	data := "p " + commits[1].Id().String()[:7] + " merge test\n" +
+		"# a comment\n\n" +
+		"x make test\n" +
+		"fixup " + commits[2].Id().String() + "\n"
+	todo, err := repo.ParseRebaseTodo([]byte(data))
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if len(todo.Operations) != 3 {
+		t.Fatal("it should parse the operations:", len(todo.Operations))
+	}
+	if todo.Operations[0].Type != RebaseOperationPick || !todo.Operations[0].Id.Equal(commits[1].Id()) {
+		t.Error("it should parse the abbreviated command and commit")
+	}
+	if todo.Operations[1].Type != RebaseOperationExec || todo.Operations[1].Exec != "make test" {
+		t.Error("it should parse the command of exec:", todo.Operations[1].Exec)
+	}
+	if todo.Operations[2].Type != RebaseOperationFixup {
+		t.Error("it should parse fixup")
+	}
+	expected := "pick " + commits[1].Id().String() + " merge test\nexec make test\nfixup " + commits[2].Id().String() + " merge test\n"
+	if string(todo.Bytes()) != expected {
+		t.Errorf("it should format the todo list like git: %q", todo.Bytes())
+	}
+	if err = todo.Validate(); err != nil {
+		t.Error("err should be nil:", err)
+	}
+
+	todo.Operations = todo.Operations[1:]
+	if err = todo.Validate(); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should not fixup without a previous commit:", err)
+	}
+	if _, err = repo.ParseRebaseTodo([]byte("merge abc\n")); err == nil {
+		t.Error("it should fail with an unknown command")
+	}
+}
+
+func Test_Rebase(t *testing.T) {
+	repo, head, commits := prepareSequencerRepository(t)
+	defer os.RemoveAll(repo.Workdir())
+	repo.CreateReference("refs/heads/topic", commits[3].Id(), true)
+	sig := &Signature{"C", "c@example.com", repo.now()}
+
+	rebase, err := repo.InitRebase("topic", head, nil, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	todo := rebase.Todo()
+	if len(todo.Operations) != 3 || !todo.Operations[0].Id.Equal(commits[1].Id()) {
+		t.Fatal("it should pick the commits of the branch oldest first")
+	}
+	todo.Operations[1].Type = RebaseOperationDrop
+	todo.Operations[2].Type = RebaseOperationSquash
+	todo.Operations = append(todo.Operations, &RebaseOperation{Type: RebaseOperationExec, Exec: "make test"})
+
+	operation, err := rebase.Next()
+	if err != nil || operation.Type != RebaseOperationPick {
+		t.Fatal("it should pick the first commit:", err)
+	}
+	if _, err = rebase.Next(); !IsErrorCode(err, ErrUnmerged) {
+		t.Error("it should not go on before the operation is committed:", err)
+	}
+	picked, err := rebase.Commit(nil, sig, "")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+
+	reopened, err := repo.OpenRebase(nil)
+	if err != nil || reopened.OperationCount() != 4 || reopened.CurrentOperationIndex() != 0 {
+		t.Fatal("it should read the edited todo list:", err)
+	}
+	operation, err = reopened.Next()
+	if err != nil || operation.Type != RebaseOperationSquash || reopened.CurrentOperationIndex() != 2 {
+		t.Fatal("it should skip the dropped commit:", err)
+	}
+	squashed, err := reopened.Commit(nil, sig, "")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	commit, _ := repo.LookupCommit(squashed)
+	if commit.ParentCount() != 1 || !commit.ParentId(0).Equal(head.Id()) {
+		t.Error("it should meld the commit into the picked one:", picked)
+	}
+	if commit.Message() != "merge test\n\nmerge test\n" {
+		t.Errorf("it should join the messages of squash: %q", commit.Message())
+	}
+	operation, err = reopened.Next()
+	if err != nil || operation.Type != RebaseOperationExec || operation.Exec != "make test" {
+		t.Fatal("it should return exec to the caller:", err)
+	}
+	if _, err = reopened.Next(); !IsErrorCode(err, ErrIterOver) {
+		t.Error("it should end the todo list:", err)
+	}
+
+	err = reopened.Finish()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	ref, _ := repo.LookupReference(GitHeadFile)
+	if ref.Type() != ReferenceSymbolic || ref.SymbolicTarget() != "refs/heads/topic" {
+		t.Error("it should check out the branch again")
+	}
+	tip, _ := repo.headCommit()
+	if !tip.Id().Equal(squashed) {
+		t.Error("it should move the branch")
+	}
+	if readWorkdirFile(repo, "a.txt") != "one\n2\nthree\n" || readWorkdirFile(repo, "b.txt") != "B\n" || readWorkdirFile(repo, "c.txt") != "c\n" {
+		t.Error("it should check out the rebased commits")
+	}
+	if _, err := os.Stat(filepath.Join(repo.Path(), GitRebaseMergeDir)); !os.IsNotExist(err) {
+		t.Error("it should remove the state")
+	}
+}
+
+func Test_Rebase_ConflictAndAbort(t *testing.T) {
+	repo, head, commits := prepareSequencerRepository(t)
+	defer os.RemoveAll(repo.Workdir())
+	repo.CreateReference("refs/heads/topic", commits[3].Id(), true)
+	repo.CreateSymbolicReference(GitHeadFile, "refs/heads/topic", true)
+	tree, _ := commits[3].Tree()
+	repo.CheckoutTree(tree, &CheckoutOptions{Force: true})
+	sig := &Signature{"C", "c@example.com", repo.now()}
+
+	rebase, err := repo.InitRebase("", head, nil, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	rebase.Next()
+	rebase.Commit(nil, sig, "")
+	_, err = rebase.Next()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	index, _ := repo.Index()
+	if !index.HasConflicts() {
+		t.Error("it should leave the conflict in the index")
+	}
+	contents, _ := ioutil.ReadFile(filepath.Join(repo.Workdir(), "a.txt"))
+	if string(contents[:16]) != "one\n<<<<<<< HEAD" {
+		t.Errorf("it should write the conflict markers: %q", contents)
+	}
+	if _, err = rebase.Commit(nil, sig, ""); !IsErrorCode(err, ErrUnmerged) {
+		t.Error("it should not commit the conflict:", err)
+	}
+
+	err = rebase.Abort()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	ref, _ := repo.LookupReference(GitHeadFile)
+	if ref.Type() != ReferenceSymbolic || ref.SymbolicTarget() != "refs/heads/topic" {
+		t.Error("it should check out the branch again")
+	}
+	tip, _ := repo.headCommit()
+	if !tip.Id().Equal(commits[3].Id()) {
+		t.Error("it should keep the branch as it was")
+	}
+	if readWorkdirFile(repo, "a.txt") != "one\nTWO\nthree\n" || readWorkdirFile(repo, "b.txt") != "B\n" {
+		t.Error("it should reset the working directory")
+	}
+	if _, err = repo.OpenRebase(nil); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should remove the state:", err)
+	}
+}
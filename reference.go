@@ -3,8 +3,6 @@ package git4go
 import (
 	"errors"
 	"fmt"
-	"golang.org/x/text/unicode/norm"
-	"os"
 	"path/filepath"
 	"strings"
 )
@@ -42,6 +40,42 @@ var dwimReferenceFormatter []string = []string{
 	"refs/remotes/%s/HEAD",
 }
 
+// ShortenReferenceName returns the shortest name that DwimReference
+// resolves to the reference. If core.warnAmbiguousRefs is true, which is
+// the default, a name is not used when any other rule finds a reference
+// with it; otherwise only the rules that DwimReference tries first are
+// checked. The full name is returned if nothing shorter is unambiguous.
+func (r *Repository) ShortenReferenceName(name string) string {
+	strict := true
+	if config := r.Config(); config != nil {
+		if value, err := config.LookupBool("core.warnAmbiguousRefs"); err == nil {
+			strict = value
+		}
+	}
+	// the first rule, the name itself, is never shorter
+	for i := len(dwimReferenceFormatter) - 1; i > 0; i-- {
+		short, ok := matchDwimFormatter(dwimReferenceFormatter[i], name)
+		if !ok {
+			continue
+		}
+		rulesToCheck := i
+		if strict {
+			rulesToCheck = len(dwimReferenceFormatter)
+		}
+		ambiguous := false
+		for j := 0; j < rulesToCheck && !ambiguous; j++ {
+			if j != i {
+				_, err := referenceLookupResolved(r, fmt.Sprintf(dwimReferenceFormatter[j], short), -1)
+				ambiguous = err == nil
+			}
+		}
+		if !ambiguous {
+			return short
+		}
+	}
+	return name
+}
+
 func (r *Repository) DwimReference(name string) (*Reference, error) {
 	if name == "" {
 		name = GitHeadFile
@@ -60,24 +94,108 @@ func (r *Repository) DwimReference(name string) (*Reference, error) {
 	return nil, errors.New(fmt.Sprintf("Could not use '%s' as valid reference name", name))
 }
 
+// RefResolution is the result of ResolveRefs for one name.
+type RefResolution struct {
+	// The name that was asked for
+	Name string
+	// The direct reference that the name resolves to, or nil
+	Reference *Reference
+	// The reason why Reference is nil: ErrNotFound if the name or the target
+	// of a symbolic reference is missing, or the error of an invalid name
+	Err error
+}
+
+// Found tells if the name was resolved.
+func (r *RefResolution) Found() bool {
+	return r.Reference != nil
+}
+
+// ResolveRefs resolves many references in one pass, like "git show-ref
+// --verify": symbolic references are followed to direct ones. packed-refs
+// is checked for changes once for the whole batch and each name is read
+// once, even if several symbolic references point to it, so it is cheaper
+// than LookupReference and Resolve for each name. The results are in the
+// order of the names; missing names don't make it fail.
+func (r *Repository) ResolveRefs(names ...string) ([]*RefResolution, error) {
+	refDb := r.NewRefDb()
+	if refDb == nil {
+		return nil, errors.New("repository has no reference database")
+	}
+	if traceEnabled(TraceTrace) {
+		trace(TraceTrace, TraceCategoryRefs, "resolve references", 0, map[string]interface{}{
+			"count": len(names),
+		})
+	}
+	cache := refDb.cache
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	err := cache.reloadIfChanged(false)
+	if err != nil {
+		return nil, err
+	}
+	type lookupResult struct {
+		ref *Reference
+		err error
+	}
+	lookups := make(map[string]lookupResult)
+	lookup := func(name string) (*Reference, error) {
+		result, ok := lookups[name]
+		if !ok {
+			refFile, err := refDb.readLoose(name)
+			if err == nil {
+				result.ref, result.err = refDb.parseLoose(name, refFile)
+			} else {
+				result.ref, result.err = refDb.packedReference(name, cache.cacheMap[name])
+			}
+			lookups[name] = result
+		}
+		return result.ref, result.err
+	}
+
+	results := make([]*RefResolution, len(names))
+	for i, name := range names {
+		result := &RefResolution{Name: name}
+		results[i] = result
+		scanName, err := referenceNormalize(name, refDb.precomposeUnicode, true)
+		if err != nil {
+			result.Err = err
+			continue
+		}
+		for nesting := DefaultNestingLevel; ; nesting-- {
+			ref, err := lookup(scanName)
+			if err != nil {
+				result.Err = err
+				break
+			}
+			if ref.refType == ReferenceOid {
+				result.Reference = ref
+				break
+			}
+			if nesting == 0 {
+				result.Err = errors.New(fmt.Sprintf("cannot resolve reference '%s' (>%d levels deep)", name, DefaultNestingLevel))
+				break
+			}
+			scanName = ref.targetSymbolic
+		}
+	}
+	return results, nil
+}
+
+// ForEachReferenceNameCallback is called for each reference name.
+// Returning StopIteration stops the iteration without an error.
 type ForEachReferenceNameCallback func(string) error
 
 func (r *Repository) ForEachReferenceName(callback ForEachReferenceNameCallback) error {
-	rootDir := filepath.Join(r.pathRepository, GitRefsDir)
+	refDb := r.NewRefDb()
 	processed := make(map[string]bool)
-	offset := len(r.pathRepository)
-	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-		path = path[offset:]
+	err := refDb.forEachLooseName(func(path string) error {
 		processed[path] = true
 		return callback(path)
 	})
 	if err != nil {
-		return err
+		return iterationResult(err)
 	}
-	refDb := r.NewRefDb()
 	refs, err := refDb.GetPackedReferences()
 	if err != nil {
 		return err
@@ -86,24 +204,21 @@ func (r *Repository) ForEachReferenceName(callback ForEachReferenceNameCallback)
 		if !processed[ref.name] {
 			err = callback(ref.name)
 			if err != nil {
-				return err
+				return iterationResult(err)
 			}
 		}
 	}
 	return nil
 }
 
+// ForEachReferenceCallback is called for each reference. Returning
+// StopIteration stops the iteration without an error.
 type ForEachReferenceCallback func(*Reference) error
 
 func (r *Repository) ForEachReference(callback ForEachReferenceCallback) error {
-	rootDir := filepath.Join(r.pathRepository, GitRefsDir)
+	refDb := r.NewRefDb()
 	processed := make(map[string]bool)
-	offset := len(rootDir) - 4
-	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-		path = path[offset:]
+	err := refDb.forEachLooseName(func(path string) error {
 		ref, err := r.LookupReference(path)
 		if err == nil {
 			processed[path] = true
@@ -112,9 +227,8 @@ func (r *Repository) ForEachReference(callback ForEachReferenceCallback) error {
 		return nil // ignore error
 	})
 	if err != nil {
-		return err
+		return iterationResult(err)
 	}
-	refDb := r.NewRefDb()
 	refs, err := refDb.GetPackedReferences()
 	if err != nil {
 		return err
@@ -123,7 +237,7 @@ func (r *Repository) ForEachReference(callback ForEachReferenceCallback) error {
 		if !processed[ref.name] {
 			err = callback(ref)
 			if err != nil {
-				return err
+				return iterationResult(err)
 			}
 		}
 	}
@@ -131,14 +245,9 @@ func (r *Repository) ForEachReference(callback ForEachReferenceCallback) error {
 }
 
 func (r *Repository) ForEachGlobReferenceName(pattern string, callback ForEachReferenceNameCallback) error {
-	rootDir := filepath.Join(r.pathRepository, GitRefsDir)
+	refDb := r.NewRefDb()
 	processed := make(map[string]bool)
-	offset := len(r.pathRepository)
-	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-		path = path[offset:]
+	err := refDb.forEachLooseName(func(path string) error {
 		processed[path] = true
 		if fnMatch(pattern, path, 0) {
 			return callback(path)
@@ -146,9 +255,8 @@ func (r *Repository) ForEachGlobReferenceName(pattern string, callback ForEachRe
 		return nil
 	})
 	if err != nil {
-		return err
+		return iterationResult(err)
 	}
-	refDb := r.NewRefDb()
 	refs, err := refDb.GetPackedReferences()
 	if err != nil {
 		return err
@@ -158,7 +266,7 @@ func (r *Repository) ForEachGlobReferenceName(pattern string, callback ForEachRe
 			if fnMatch(pattern, ref.name, 0) {
 				err = callback(ref.name)
 				if err != nil {
-					return err
+					return iterationResult(err)
 				}
 			}
 		}
@@ -167,14 +275,9 @@ func (r *Repository) ForEachGlobReferenceName(pattern string, callback ForEachRe
 }
 
 func (r *Repository) ForEachGlobReference(pattern string, callback ForEachReferenceCallback) error {
-	rootDir := filepath.Join(r.pathRepository, GitRefsDir)
+	refDb := r.NewRefDb()
 	processed := make(map[string]bool)
-	offset := len(r.pathRepository)
-	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
-		}
-		path = path[offset:]
+	err := refDb.forEachLooseName(func(path string) error {
 		processed[path] = true
 		if fnMatch(pattern, path, 0) {
 			ref, err := r.LookupReference(path)
@@ -186,9 +289,8 @@ func (r *Repository) ForEachGlobReference(pattern string, callback ForEachRefere
 		return nil
 	})
 	if err != nil {
-		return err
+		return iterationResult(err)
 	}
-	refDb := r.NewRefDb()
 	refs, err := refDb.GetPackedReferences()
 	if err != nil {
 		return err
@@ -198,7 +300,7 @@ func (r *Repository) ForEachGlobReference(pattern string, callback ForEachRefere
 			if fnMatch(pattern, ref.name, 0) {
 				err = callback(ref)
 				if err != nil {
-					return err
+					return iterationResult(err)
 				}
 			}
 		}
@@ -206,6 +308,48 @@ func (r *Repository) ForEachGlobReference(pattern string, callback ForEachRefere
 	return nil
 }
 
+// CreateReference creates the direct reference. If force is false and the
+// reference already exists, an ErrExists error is returned.
+func (r *Repository) CreateReference(name string, id *Oid, force bool) (*Reference, error) {
+	refDb, name, err := r.refDbForWrite(name)
+	if err != nil {
+		return nil, err
+	}
+	err = refDb.write(name, []byte(id.String()+"\n"), force)
+	if err != nil {
+		return nil, err
+	}
+	return &Reference{
+		refType:   ReferenceOid,
+		targetOid: id.Copy(),
+		repo:      r,
+		name:      name,
+	}, nil
+}
+
+// CreateSymbolicReference creates the reference that points to the other
+// reference, like HEAD.
+func (r *Repository) CreateSymbolicReference(name, target string, force bool) (*Reference, error) {
+	refDb, name, err := r.refDbForWrite(name)
+	if err != nil {
+		return nil, err
+	}
+	target, err = referenceNormalize(target, refDb.precomposeUnicode, true)
+	if err != nil {
+		return nil, err
+	}
+	err = refDb.write(name, []byte(GitSymbolReference+target+"\n"), force)
+	if err != nil {
+		return nil, err
+	}
+	return &Reference{
+		refType:        ReferenceSymbolic,
+		targetSymbolic: target,
+		repo:           r,
+		name:           name,
+	}, nil
+}
+
 // Reference type and its methods
 type Reference struct {
 	refType        ReferenceType
@@ -251,6 +395,18 @@ func (r *Reference) IsTag() bool {
 	return false
 }
 
+// Shorthand returns the shortest name that still resolves to the reference
+// and to no other, like "git rev-parse --abbrev-ref" and "git symbolic-ref
+// --short": refs/heads/master is "master" and refs/remotes/origin/master
+// is "origin/master", unless e.g. refs/tags/master exists too, which makes
+// it "heads/master". See Repository.ShortenReferenceName.
+func (r *Reference) Shorthand() string {
+	if r.repo == nil {
+		return r.name
+	}
+	return r.repo.ShortenReferenceName(r.name)
+}
+
 func (r *Reference) Resolve() (*Reference, error) {
 	if r.refType == ReferenceOid {
 		return r, nil
@@ -293,6 +449,28 @@ func (v *ReferenceNameIterator) Next() (string, error) {
 
 // internal functions
 
+// matchDwimFormatter returns the name that the formatter of
+// dwimReferenceFormatter turns into the full name.
+func matchDwimFormatter(formatter, name string) (string, bool) {
+	parts := strings.SplitN(formatter, "%s", 2)
+	if len(name) <= len(parts[0])+len(parts[1]) || !strings.HasPrefix(name, parts[0]) || !strings.HasSuffix(name, parts[1]) {
+		return "", false
+	}
+	return name[len(parts[0]) : len(name)-len(parts[1])], true
+}
+
+func (r *Repository) refDbForWrite(name string) (*RefDb, string, error) {
+	refDb := r.NewRefDb()
+	if refDb == nil {
+		return nil, "", errors.New("repository has no reference database")
+	}
+	name, err := referenceNormalize(name, refDb.precomposeUnicode, true)
+	if err != nil {
+		return nil, "", err
+	}
+	return refDb, name, nil
+}
+
 func referenceLookupResolved(repo *Repository, name string, maxNesting int) (*Reference, error) {
 	if maxNesting > MaxNestingLevel {
 		maxNesting = MaxNestingLevel
@@ -302,7 +480,7 @@ func referenceLookupResolved(repo *Repository, name string, maxNesting int) (*Re
 
 	scanType := ReferenceSymbolic
 	config := repo.Config()
-	precomposeUnicode, _ := config.LookupBool("core.precomposeunicode")
+	precomposeUnicode, _ := config.LookupBooleanWithDefaultValue("core.precomposeunicode")
 	scanName, err := referenceNormalize(name, precomposeUnicode, true)
 	if err != nil {
 		return nil, err
@@ -346,8 +524,5 @@ func referenceNormalize(name string, precomposeUnicode, allowOneLevel bool) (str
 	if invalid {
 		return "", errors.New(fmt.Sprintf("The given reference name '%s' is not valid", name))
 	}
-	if precomposeUnicode {
-		name = norm.NFC.String(name)
-	}
-	return name, nil
+	return precomposePath(name, precomposeUnicode), nil
 }
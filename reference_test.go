@@ -2,7 +2,12 @@ package git4go
 
 import (
 	"./testutil"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 )
 
 func Test_RepositoryHead(t *testing.T) {
@@ -156,6 +161,28 @@ func Test_ForEachReference(t *testing.T) {
 	if len(names) != 15 {
 		t.Error("it should have references in repository:", len(names), names)
 	}
+
+	// the last ones come from packed-refs
+	for _, stopAt := range []int{3, 14} {
+		count := 0
+		err = repo.ForEachReference(func(ref *Reference) error {
+			count++
+			if count == stopAt {
+				return StopIteration
+			}
+			return nil
+		})
+		if err != nil || count != stopAt {
+			t.Error("it should stop the iteration without an error:", count, err)
+		}
+	}
+	failure := errors.New("failure")
+	err = repo.ForEachReference(func(ref *Reference) error {
+		return failure
+	})
+	if err != failure {
+		t.Error("it should return the error of the callback:", err)
+	}
 }
 
 func Test_ForEachGlobReference(t *testing.T) {
@@ -175,3 +202,156 @@ func Test_ForEachGlobReference(t *testing.T) {
 		t.Error("it should have references in repository:", len(names), names)
 	}
 }
+
+func Test_HasExactCase(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	if !hasExactCase(OSFileSystem, "test_resources/testrepo.git", "refs/heads/master", false, nil) {
+		t.Error("it should find ref with same case")
+	}
+	if hasExactCase(OSFileSystem, "test_resources/testrepo.git", "refs/heads/MASTER", false, nil) {
+		t.Error("it should not match ref with different case")
+	}
+	if hasExactCase(OSFileSystem, "test_resources/testrepo.git", "Refs/heads/master", false, nil) {
+		t.Error("it should check every component")
+	}
+
+	var cache dirListingCache
+	if !hasExactCase(OSFileSystem, "test_resources/testrepo.git", "refs/heads/master", false, &cache) || len(cache.dirs) != 3 {
+		t.Error("it should keep the listings of the directories:", len(cache.dirs))
+	}
+	ioutil.WriteFile("test_resources/testrepo.git/refs/heads/Topic", []byte("a65fedf39aefe402d3bb6e24df4d4f5fe4547750\n"), 0644)
+	os.Chtimes("test_resources/testrepo.git/refs/heads", time.Now(), time.Now().Add(time.Hour))
+	if !hasExactCase(OSFileSystem, "test_resources/testrepo.git", "refs/heads/Topic", false, &cache) {
+		t.Error("it should list the directory again when it changes")
+	}
+
+	for i := len(cache.dirs); i < dirListingCacheSize; i++ {
+		cache.dirs[fmt.Sprintf("dir%d", i)] = &dirListing{}
+	}
+	hasExactCase(OSFileSystem, "test_resources/testrepo.git", "refs/tags/e90810b", false, &cache)
+	if len(cache.dirs) > dirListingCacheSize {
+		t.Error("it should bound the listings:", len(cache.dirs))
+	}
+	if count, size := cache.size(); count != len(cache.dirs) || size == 0 {
+		t.Error("it should report the size of the listings:", count, size)
+	}
+	cache.clear()
+	if count, _ := cache.size(); count != 0 {
+		t.Error("it should clear the listings:", count)
+	}
+}
+
+func Test_CreateReference(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo/")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo/")
+	oid, _ := NewOid("099fabac3a9ea935598528c27f866e34089c2eff")
+	_, err := repo.CreateReference("refs/heads/master", oid, false)
+	if !IsErrorCode(err, ErrExists) {
+		t.Error("it should not overwrite reference without force:", err)
+	}
+	_, err = repo.CreateReference("refs/heads/new/branch", oid, false)
+	if err != nil {
+		t.Error("it should create reference:", err)
+	}
+	ref, err := repo.LookupReference("refs/heads/new/branch")
+	if err != nil || !ref.Target().Equal(oid) {
+		t.Error("it should read created reference:", err)
+	}
+	_, err = repo.CreateSymbolicReference("refs/heads/alias", "refs/heads/new/branch", false)
+	if err != nil {
+		t.Error("it should create symbolic reference:", err)
+	}
+	ref, _ = repo.LookupReference("refs/heads/alias")
+	ref, err = ref.Resolve()
+	if err != nil || !ref.Target().Equal(oid) {
+		t.Error("it should resolve symbolic reference:", err)
+	}
+}
+
+func Test_ReferenceShorthand(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo/")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo/")
+	oid, _ := NewOid("099fabac3a9ea935598528c27f866e34089c2eff")
+	repo.CreateReference("refs/remotes/origin/master", oid, false)
+	repo.CreateSymbolicReference("refs/remotes/origin/HEAD", "refs/remotes/origin/master", false)
+	expected := map[string]string{
+		"refs/heads/master":          "master",
+		"refs/heads/packed":          "packed",
+		"refs/tags/foo":              "foo",
+		"refs/remotes/origin/master": "origin/master",
+		"refs/remotes/origin/HEAD":   "origin",
+		// refs/tags/test exists too
+		"refs/heads/test": "heads/test",
+		"refs/tags/test":  "tags/test",
+		"HEAD":            "HEAD",
+	}
+	for name, short := range expected {
+		if result := repo.ShortenReferenceName(name); result != short {
+			t.Errorf("it should shorten %s to %s: %s", name, short, result)
+		}
+	}
+	head, _ := repo.LookupReference("HEAD")
+	if result := repo.ShortenReferenceName(head.SymbolicTarget()); result != "master" {
+		t.Error("it should shorten the target of HEAD like symbolic-ref --short:", result)
+	}
+	ref, _ := repo.LookupReference("refs/remotes/origin/master")
+	if ref.Shorthand() != "origin/master" {
+		t.Error("it should return the shorthand of the reference:", ref.Shorthand())
+	}
+
+	// only the rules before the matching one are checked
+	repo.Config().SetBool("core.warnAmbiguousRefs", false)
+	if result := repo.ShortenReferenceName("refs/tags/test"); result != "test" {
+		t.Error("it should shorten the tag without strict checks:", result)
+	}
+	if result := repo.ShortenReferenceName("refs/heads/test"); result != "heads/test" {
+		t.Error("it should keep the branch unambiguous from the tag:", result)
+	}
+}
+
+func Test_Repository_ResolveRefs(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	names := []string{"HEAD", "refs/heads/master", "refs/heads/packed", "refs/heads/missing", "refs/heads/a..b"}
+	results, err := repo.ResolveRefs(names...)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if len(results) != len(names) {
+		t.Fatal("it should return a result for each name:", len(results))
+	}
+	for i, name := range names[:3] {
+		if results[i].Name != name || !results[i].Found() || results[i].Err != nil {
+			t.Error("it should resolve the reference:", name, results[i].Err)
+			continue
+		}
+		expected, _ := repo.LookupReference(name)
+		expected, _ = expected.Resolve()
+		if results[i].Reference.Name() != expected.Name() || !results[i].Reference.Target().Equal(expected.Target()) {
+			t.Error("it should resolve like LookupReference:", name, results[i].Reference.Name())
+		}
+	}
+	if results[2].Reference.Type() != ReferenceOid {
+		t.Error("it should return the direct reference")
+	}
+	if results[3].Found() || !IsErrorCode(results[3].Err, ErrNotFound) {
+		t.Error("it should report the missing reference:", results[3].Err)
+	}
+	if results[4].Found() || results[4].Err == nil {
+		t.Error("it should report the invalid name")
+	}
+
+	repo.CreateSymbolicReference("refs/heads/dangling", "refs/heads/nowhere", true)
+	results, _ = repo.ResolveRefs("refs/heads/dangling")
+	if results[0].Found() || !IsErrorCode(results[0].Err, ErrNotFound) {
+		t.Error("it should report the missing target:", results[0].Err)
+	}
+}
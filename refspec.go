@@ -0,0 +1,111 @@
+package git4go
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Refspec maps references of a remote to local references, like
+// "+refs/heads/*:refs/remotes/origin/*".
+type Refspec struct {
+	str     string
+	src     string
+	dst     string
+	force   bool
+	pattern bool
+	push    bool
+}
+
+func ParseRefspec(input string, isFetch bool) (*Refspec, error) {
+	spec := &Refspec{
+		str:  input,
+		push: !isFetch,
+	}
+	rest := input
+	if strings.HasPrefix(rest, "+") {
+		spec.force = true
+		rest = rest[1:]
+	}
+	if colon := strings.LastIndexByte(rest, ':'); colon != -1 {
+		spec.src = rest[:colon]
+		spec.dst = rest[colon+1:]
+	} else {
+		spec.src = rest
+	}
+	srcStars := strings.Count(spec.src, "*")
+	dstStars := strings.Count(spec.dst, "*")
+	spec.pattern = srcStars == 1
+	if (isFetch && spec.src == "") || srcStars > 1 || dstStars > 1 || (spec.dst != "" && srcStars != dstStars) {
+		return nil, MakeGitError(fmt.Sprintf("'%s' is not a valid refspec.", input), ErrInvalidSpec)
+	}
+	return spec, nil
+}
+
+func (s *Refspec) String() string {
+	return s.str
+}
+
+func (s *Refspec) Src() string {
+	return s.src
+}
+
+func (s *Refspec) Dst() string {
+	return s.dst
+}
+
+func (s *Refspec) Force() bool {
+	return s.force
+}
+
+func (s *Refspec) IsPush() bool {
+	return s.push
+}
+
+// SrcMatches returns true when the reference name matches the source side.
+func (s *Refspec) SrcMatches(name string) bool {
+	return refspecMatch(s.src, name, s.pattern)
+}
+
+// DstMatches returns true when the reference name matches the destination
+// side.
+func (s *Refspec) DstMatches(name string) bool {
+	return refspecMatch(s.dst, name, s.pattern)
+}
+
+// Transform converts a reference name that matches the source side to the
+// name on the destination side.
+func (s *Refspec) Transform(name string) (string, error) {
+	if !s.SrcMatches(name) {
+		return "", MakeGitError(fmt.Sprintf("ref '%s' doesn't match the source", name), ErrInvalid)
+	}
+	return refspecReplace(s.src, s.dst, name, s.pattern), nil
+}
+
+// Rtransform converts a reference name that matches the destination side
+// back to the name on the source side.
+func (s *Refspec) Rtransform(name string) (string, error) {
+	if !s.DstMatches(name) {
+		return "", MakeGitError(fmt.Sprintf("ref '%s' doesn't match the destination", name), ErrInvalid)
+	}
+	return refspecReplace(s.dst, s.src, name, s.pattern), nil
+}
+
+// internal functions
+
+func refspecMatch(pattern, name string, isPattern bool) bool {
+	if !isPattern {
+		return pattern == name
+	}
+	star := strings.IndexByte(pattern, '*')
+	prefix, suffix := pattern[:star], pattern[star+1:]
+	return len(name) >= len(prefix)+len(suffix) && strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix)
+}
+
+func refspecReplace(from, to, name string, isPattern bool) string {
+	if !isPattern {
+		return to
+	}
+	star := strings.IndexByte(from, '*')
+	matched := name[star : len(name)-(len(from)-star-1)]
+	return strings.Replace(to, "*", matched, 1)
+}
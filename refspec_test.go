@@ -0,0 +1,45 @@
+package git4go
+
+import (
+	"testing"
+)
+
+func Test_ParseRefspec(t *testing.T) {
+	spec, err := ParseRefspec("+refs/heads/*:refs/remotes/origin/*", true)
+	if err != nil {
+		t.Fatal("it should parse refspec:", err)
+	}
+	if !spec.Force() || spec.Src() != "refs/heads/*" || spec.Dst() != "refs/remotes/origin/*" {
+		t.Error("it should split refspec:", spec.Force(), spec.Src(), spec.Dst())
+	}
+	if !spec.SrcMatches("refs/heads/feature/x") || spec.SrcMatches("refs/tags/v1") {
+		t.Error("it should match source pattern")
+	}
+	name, err := spec.Transform("refs/heads/feature/x")
+	if err != nil || name != "refs/remotes/origin/feature/x" {
+		t.Error("it should transform to destination:", name, err)
+	}
+	name, err = spec.Rtransform("refs/remotes/origin/master")
+	if err != nil || name != "refs/heads/master" {
+		t.Error("it should transform back to source:", name, err)
+	}
+	_, err = spec.Transform("refs/tags/v1")
+	if !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should not transform unmatched name:", err)
+	}
+
+	spec, err = ParseRefspec("refs/heads/master:refs/heads/upstream", true)
+	if err != nil || spec.Force() {
+		t.Fatal("it should parse non-forced refspec:", err)
+	}
+	name, _ = spec.Transform("refs/heads/master")
+	if name != "refs/heads/upstream" {
+		t.Error("it should transform exact name:", name)
+	}
+
+	for _, input := range []string{"", "refs/heads/*:refs/remotes/origin/master", "refs/*/*:refs/*/*"} {
+		if _, err := ParseRefspec(input, true); !IsErrorCode(err, ErrInvalidSpec) {
+			t.Error("it should reject invalid refspec:", input, err)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package git4go
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	GitRemoteOrigin          = "origin"
+	GitDefaultBranchFallback = "master"
+)
+
+// Remote is a remote that is configured in the repository.
+type Remote struct {
+	repo    *Repository
+	name    string
+	url     string
+	pushUrl string
+	fetch   []string
+
+	// the connection of Connect, see transport.go
+	lock            sync.Mutex
+	transport       Transport
+	transportPrefix string
+	connected       bool
+	direction       ConnectDirection
+	connectedUrl    string
+	retryPolicy     *RetryPolicy
+}
+
+// ListRemotes returns the names of the remotes that are configured.
+func (r *Repository) ListRemotes() []string {
+	config := r.Config()
+	if config == nil {
+		return nil
+	}
+	var names []string
+	for _, name := range config.subsections("remote") {
+		if _, err := r.LookupRemote(name); err == nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (r *Repository) LookupRemote(name string) (*Remote, error) {
+	config := r.Config()
+	if config == nil || name == "" || strings.ContainsAny(name, ". \"") {
+		return nil, MakeGitError(fmt.Sprintf("remote '%s' does not exist", name), ErrNotFound)
+	}
+	url, urlErr := config.LookupString("remote." + name + ".url")
+	pushUrl, pushUrlErr := config.LookupString("remote." + name + ".pushurl")
+	if urlErr != nil && pushUrlErr != nil {
+		return nil, MakeGitError(fmt.Sprintf("remote '%s' does not exist", name), ErrNotFound)
+	}
+	remote := &Remote{
+		repo:    r,
+		name:    name,
+		url:     url,
+		pushUrl: pushUrl,
+	}
+	if fetch, err := config.LookupString("remote." + name + ".fetch"); err == nil && fetch != "" {
+		remote.fetch = append(remote.fetch, fetch)
+	}
+	return remote, nil
+}
+
+func (r *Remote) Name() string {
+	return r.name
+}
+
+func (r *Remote) Url() string {
+	return r.url
+}
+
+func (r *Remote) PushUrl() string {
+	return r.pushUrl
+}
+
+// FetchRefspecs returns the fetch refspecs of the remote.
+func (r *Remote) FetchRefspecs() []string {
+	return append([]string(nil), r.fetch...)
+}
+
+func (r *Remote) Owner() *Repository {
+	return r.repo
+}
+
+// DefaultBranch returns the branch that HEAD of the remote points to, like
+// "refs/heads/main". If the remote is connected, it is the symref target
+// of HEAD that the remote advertised. Otherwise it is read from
+// refs/remotes/<name>/HEAD, which clone and "git remote set-head" record
+// from that advertisement.
+func (r *Remote) DefaultBranch() (string, error) {
+	if connected, _ := r.Connected(); connected {
+		heads, err := r.Ls()
+		if err != nil {
+			return "", err
+		}
+		for _, head := range heads {
+			if head.Name == GitHeadFile && strings.HasPrefix(head.SymrefTarget, GitRefsHeadsDir) {
+				return head.SymrefTarget, nil
+			}
+		}
+	}
+	prefix := GitRefsRemotesDir + r.name + "/"
+	head, err := r.repo.LookupReference(prefix + GitHeadFile)
+	if err != nil || head.Type() != ReferenceSymbolic || !strings.HasPrefix(head.SymbolicTarget(), prefix) {
+		return "", MakeGitError(fmt.Sprintf("default branch of remote '%s' is unknown", r.name), ErrNotFound)
+	}
+	return GitRefsHeadsDir + head.SymbolicTarget()[len(prefix):], nil
+}
+
+// GuessDefaultBranch returns the default branch of the repository. It is
+// the default branch of origin if it is known, otherwise init.defaultBranch
+// or "refs/heads/master".
+func (r *Repository) GuessDefaultBranch() string {
+	if remote, err := r.LookupRemote(GitRemoteOrigin); err == nil {
+		if branch, err := remote.DefaultBranch(); err == nil {
+			return branch
+		}
+	}
+	if config := r.Config(); config != nil {
+		if branch, err := config.LookupString("init.defaultBranch"); err == nil && branch != "" {
+			return GitRefsHeadsDir + branch
+		}
+	}
+	return GitRefsHeadsDir + GitDefaultBranchFallback
+}
+
+type DownloadTags int
+
+const (
+	// Use the setting from the configuration
+	DownloadTagsUnspecified DownloadTags = iota
+	// Fetch tags that point to objects that are fetched anyway
+	DownloadTagsAuto
+	// Don't fetch any tags
+	DownloadTagsNone
+	// Fetch all tags
+	DownloadTagsAll
+)
+
+// RemoteHead is a reference that the remote advertises.
+type RemoteHead struct {
+	Id   *Oid
+	Name string
+	// The reference that a symbolic reference points to, from the
+	// symref-target attribute of ls-refs or the symref capability of the
+	// v0 advertisement, e.g. "refs/heads/main" for HEAD
+	SymrefTarget string
+}
+
+// DownloadTags returns the tag fetching mode of remote.<name>.tagOpt:
+// "--no-tags" is DownloadTagsNone, "--tags" is DownloadTagsAll, and
+// everything else is DownloadTagsAuto.
+func (r *Remote) DownloadTags() DownloadTags {
+	config := r.repo.Config()
+	if config != nil {
+		switch value, _ := config.LookupString("remote." + r.name + ".tagOpt"); value {
+		case "--no-tags":
+			return DownloadTagsNone
+		case "--tags":
+			return DownloadTagsAll
+		}
+	}
+	return DownloadTagsAuto
+}
+
+// SetRemoteAutotag stores the tag fetching mode of the remote in
+// remote.<name>.tagOpt.
+func (r *Repository) SetRemoteAutotag(remote string, downloadTags DownloadTags) error {
+	if _, err := r.LookupRemote(remote); err != nil {
+		return err
+	}
+	value := ""
+	switch downloadTags {
+	case DownloadTagsNone:
+		value = "--no-tags"
+	case DownloadTagsAll:
+		value = "--tags"
+	}
+	config := r.Config()
+	return config.SetString("remote."+remote+".tagOpt", value)
+}
+
+// TagsToFetch selects the tags in the advertised heads that a fetch should
+// update. With DownloadTagsAuto, a tag is followed when the object that it
+// peels to is in the repository and the tag does not exist locally yet, so
+// it must be called after the pack of the fetch is stored.
+// DownloadTagsUnspecified uses the mode of the remote.
+func (r *Remote) TagsToFetch(heads []RemoteHead, downloadTags DownloadTags) ([]RemoteHead, error) {
+	if downloadTags == DownloadTagsUnspecified {
+		downloadTags = r.DownloadTags()
+	}
+	if downloadTags == DownloadTagsNone {
+		return nil, nil
+	}
+	odb, err := r.repo.Odb()
+	if err != nil {
+		return nil, err
+	}
+	peeled := make(map[string]*Oid)
+	for _, head := range heads {
+		if strings.HasPrefix(head.Name, GitRefsTagsDir) && strings.HasSuffix(head.Name, "^{}") {
+			peeled[head.Name[:len(head.Name)-3]] = head.Id
+		}
+	}
+	var tags []RemoteHead
+	for _, head := range heads {
+		if !strings.HasPrefix(head.Name, GitRefsTagsDir) || strings.HasSuffix(head.Name, "^{}") {
+			continue
+		}
+		if downloadTags == DownloadTagsAuto {
+			target, ok := peeled[head.Name]
+			if !ok {
+				target = head.Id
+			}
+			if !odb.Exists(target) {
+				continue
+			}
+			if _, err := r.repo.LookupReference(head.Name); err == nil {
+				continue
+			}
+		}
+		tags = append(tags, head)
+	}
+	return tags, nil
+}
@@ -0,0 +1,114 @@
+package git4go
+
+import (
+	"./testutil"
+	"testing"
+)
+
+func Test_LookupRemote(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	remote, err := repo.LookupRemote("test_with_pushurl")
+	if err != nil {
+		t.Fatal("it should find remote:", err)
+	}
+	if remote.Url() != "git://github.com/libgit2/fetchlibgit2" || remote.PushUrl() != "git://github.com/libgit2/pushlibgit2" {
+		t.Error("it should read urls:", remote.Url(), remote.PushUrl())
+	}
+	_, err = repo.LookupRemote("missing")
+	if !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should not find missing remote:", err)
+	}
+}
+
+func Test_RemoteDefaultBranch(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	if repo.GuessDefaultBranch() != "refs/heads/master" {
+		t.Error("it should fall back to master:", repo.GuessDefaultBranch())
+	}
+	repo.Config().SetString("init.defaultBranch", "trunk")
+	if repo.GuessDefaultBranch() != "refs/heads/trunk" {
+		t.Error("it should use init.defaultBranch:", repo.GuessDefaultBranch())
+	}
+	repo.Config().SetString("remote.origin.url", "https://example.com/repo.git")
+	remote, err := repo.LookupRemote("origin")
+	if err != nil {
+		t.Fatal("it should find remote:", err)
+	}
+	_, err = remote.DefaultBranch()
+	if !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should not know default branch before HEAD is recorded:", err)
+	}
+	repo.CreateSymbolicReference("refs/remotes/origin/HEAD", "refs/remotes/origin/main", false)
+	branch, err := remote.DefaultBranch()
+	if err != nil || branch != "refs/heads/main" {
+		t.Error("it should resolve HEAD of the remote:", branch, err)
+	}
+	if repo.GuessDefaultBranch() != "refs/heads/main" {
+		t.Error("it should use default branch of origin:", repo.GuessDefaultBranch())
+	}
+}
+
+func Test_RemoteDefaultBranch_Advertised(t *testing.T) {
+	repo, server, _, cleanup := prepareFetch(t)
+	defer cleanup()
+	commit := writeMergeCommit(server, map[string]string{"a.txt": "a\n"})
+	server.CreateReference("refs/heads/main", commit.Id(), true)
+	server.CreateSymbolicReference(GitHeadFile, "refs/heads/main", true)
+
+	remote, _ := repo.LookupRemote("origin")
+	if err := remote.Connect(ConnectDirectionFetch); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	branch, err := remote.DefaultBranch()
+	if err != nil || branch != "refs/heads/main" {
+		t.Error("it should use the symref target of the advertised HEAD:", branch, err)
+	}
+	remote.Disconnect()
+	if _, err = remote.DefaultBranch(); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should read refs/remotes/origin/HEAD when it is not connected:", err)
+	}
+}
+
+func Test_RemoteTagsToFetch(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	remote, _ := repo.LookupRemote("test")
+	if remote.DownloadTags() != DownloadTagsAuto {
+		t.Error("it should follow tags by default:", remote.DownloadTags())
+	}
+	fetched, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	missing, _ := NewOid("0123456789012345678901234567890123456789")
+	heads := []RemoteHead{
+		{Id: fetched, Name: "refs/heads/master"},
+		{Id: missing, Name: "refs/tags/annotated"},
+		{Id: fetched, Name: "refs/tags/annotated^{}"},
+		{Id: missing, Name: "refs/tags/unrelated"},
+		{Id: fetched, Name: "refs/tags/test"},
+	}
+	tags, err := remote.TagsToFetch(heads, DownloadTagsUnspecified)
+	if err != nil || len(tags) != 1 || tags[0].Name != "refs/tags/annotated" {
+		t.Error("it should follow tags that point into fetched history:", tags, err)
+	}
+	tags, _ = remote.TagsToFetch(heads, DownloadTagsAll)
+	if len(tags) != 3 {
+		t.Error("it should fetch all tags:", tags)
+	}
+
+	err = repo.SetRemoteAutotag("test", DownloadTagsNone)
+	if err != nil {
+		t.Fatal("it should store tagOpt:", err)
+	}
+	remote, _ = repo.LookupRemote("test")
+	if remote.DownloadTags() != DownloadTagsNone {
+		t.Error("it should read tagOpt:", remote.DownloadTags())
+	}
+	tags, _ = remote.TagsToFetch(heads, DownloadTagsUnspecified)
+	if len(tags) != 0 {
+		t.Error("it should not fetch tags:", tags)
+	}
+}
@@ -3,10 +3,10 @@ package git4go
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -14,39 +14,136 @@ const (
 	GIT_REPOSITORY_OPEN_NO_SEARCH uint32 = (1 << 0)
 	GIT_REPOSITORY_OPEN_CROSS_FS  uint32 = (1 << 1)
 	GIT_REPOSITORY_OPEN_BARE      uint32 = (1 << 2)
+	GIT_REPOSITORY_OPEN_READ_ONLY uint32 = (1 << 4)
+	GIT_REPOSITORY_OPEN_HERMETIC  uint32 = (1 << 5)
 	GitObjectsDir                 string = "objects/"
 	GitHeadFile                   string = "HEAD"
 	GitRefsDir                    string = "refs/"
 	GitRefsTagsDir                string = "refs/tags"
+	GitRefsHeadsDir               string = "refs/heads/"
+	GitRefsRemotesDir             string = "refs/remotes/"
+	GitCommonDirFile              string = "commondir"
+	gitFilePrefix                 string = "gitdir:"
 )
 
 // Repository type and its methods
 
+// Repository can be shared between goroutines. Lazily created members
+// (config, odb, refdb and index) are guarded by their own locks. Objects
+// returned from it (Commit, Tree, ...) are immutable, but RevWalk and
+// TreeBuilder instances must be used from one goroutine at a time.
 type Repository struct {
-	pathRepository string
-	workDir        string
-	namespace      string
-	pathGitLink    string
-	isBare         bool
-	config         *Config
-	refDb          *RefDb
-	odb            *Odb
-	index          *Index
-	//cache          *Cache
+	pathRepository  string
+	pathCommon      string
+	workDir         string
+	namespace       string
+	pathGitLink     string
+	isBare          bool
+	readOnly        bool
+	hermetic        bool
+	fs              FileSystem
+	config          *Config
+	refDb           *RefDb
+	odb             *Odb
+	index           *Index
+	configLock      sync.Mutex
+	refDbLock       sync.Mutex
+	odbLock         sync.Mutex
+	indexLock       sync.Mutex
+	shallowLock     sync.Mutex
+	shallow         map[Oid]bool
+	fetchHeadLock   sync.Mutex
+	fetchHead       []byte
+	eventLock       sync.RWMutex
+	subscribers     []repositorySubscriber
+	nextSubscriber  int
+	commitCache     *CommitCache
+	graphCache      *graphCache
+	commitGraphLock sync.Mutex
+	commitGraph     *CommitGraph
+	names           *stringPool
+	clock           func() time.Time
 }
 
 func OpenRepository(path string) (*Repository, error) {
-	return openRepository(path, GIT_REPOSITORY_OPEN_NO_SEARCH)
+	return openRepository(OSFileSystem, path, GIT_REPOSITORY_OPEN_NO_SEARCH)
 }
 
 func OpenRepositoryExtended(path string) (*Repository, error) {
-	return openRepository(path, GIT_REPOSITORY_OPEN_NO_FLAG)
+	return openRepository(OSFileSystem, path, GIT_REPOSITORY_OPEN_NO_FLAG)
+}
+
+// OpenRepositoryWithFileSystem opens the repository through fsys instead of
+// the os package. The git directory, the index and the working directory are
+// all accessed with it.
+func OpenRepositoryWithFileSystem(path string, fsys FileSystem, flags uint32) (*Repository, error) {
+	return openRepository(fileSystemOrDefault(fsys), path, flags)
+}
+
+// OpenRepositoryReadOnly opens the repository that lives on a read-only
+// mount or snapshot. Nothing is written to the repository directory: no
+// lockfiles are created and mutating APIs fail with ErrReadOnly.
+func OpenRepositoryReadOnly(path string) (*Repository, error) {
+	return openRepository(OSFileSystem, path, GIT_REPOSITORY_OPEN_NO_SEARCH|GIT_REPOSITORY_OPEN_READ_ONLY)
+}
+
+// OpenRepositoryHermetic opens the repository without any influence of the
+// environment, for build tools that need reproducible results. No GIT_*
+// variable is read, the global, XDG and system config files are ignored and
+// signatures get a fixed time (the Unix epoch) unless SetClock is called.
+func OpenRepositoryHermetic(path string) (*Repository, error) {
+	return openRepository(OSFileSystem, path, GIT_REPOSITORY_OPEN_NO_SEARCH|GIT_REPOSITORY_OPEN_HERMETIC)
+}
+
+// NewInMemoryRepository creates a bare repository that never touches the
+// filesystem. Objects are stored in a mempack backend and references in
+// memory, so everything is lost when the repository is released.
+func NewInMemoryRepository() (*Repository, error) {
+	config, err := NewConfig()
+	if err != nil {
+		return nil, err
+	}
+	err = config.addData(nil, ConfigLevelLocal)
+	if err != nil {
+		return nil, err
+	}
+	odb := NewOdb()
+	odb.AddBackend(NewOdbBackendMemPack(), GitLoosePriority)
+	index, err := NewIndex()
+	if err != nil {
+		return nil, err
+	}
+	repo := &Repository{
+		isBare:      true,
+		fs:          OSFileSystem,
+		config:      config,
+		odb:         odb,
+		commitCache: NewCommitCache(DefaultCommitCacheSize),
+		graphCache:  newGraphCache(DefaultGraphCacheSize),
+		names:       newStringPool(DefaultStringPoolSize),
+	}
+	repo.refDb = newInMemoryRefDb(repo)
+	repo.SetIndex(index)
+	return repo, nil
+}
+
+// CommitCache returns the cache of parsed commits that is shared by
+// LookupCommit and RevWalk.
+func (r *Repository) CommitCache() *CommitCache {
+	return r.commitCache
 }
 
 func (r *Repository) Path() string {
 	return r.pathRepository
 }
 
+// CommonDir returns the directory that has the objects, the config and the
+// refs that are shared between linked worktrees. It is the same as Path
+// except in a linked worktree.
+func (r *Repository) CommonDir() string {
+	return r.pathCommon
+}
+
 func (r *Repository) Workdir() string {
 	if r.isBare {
 		return ""
@@ -58,17 +155,43 @@ func (r *Repository) IsBare() bool {
 	return r.isBare
 }
 
+func (r *Repository) IsReadOnly() bool {
+	return r.readOnly
+}
+
+func (r *Repository) IsHermetic() bool {
+	return r.hermetic
+}
+
+// SetClock replaces the clock that gives the time of DefaultSignature. It
+// makes the hashes of new commits reproducible. nil restores the default:
+// the current time, or the Unix epoch in a hermetic repository.
+func (r *Repository) SetClock(clock func() time.Time) {
+	r.clock = clock
+}
+
+// FileSystem returns the file system that the repository is accessed with.
+func (r *Repository) FileSystem() FileSystem {
+	return r.fs
+}
+
 // internal functions
 
-func openRepository(path string, flags uint32) (*Repository, error) {
-	path, parent, link_path, err := findRepo(path, flags, []string{})
+func openRepository(fsys FileSystem, path string, flags uint32) (*Repository, error) {
+	path, parent, link_path, err := findRepo(fsys, path, flags, []string{})
 	if err != nil {
 		return nil, err
 	}
 	repo := &Repository{
 		pathRepository: path,
+		pathCommon:     commonDir(fsys, path),
 		pathGitLink:    link_path,
-		//cache:          NewCache(),
+		readOnly:       (flags & GIT_REPOSITORY_OPEN_READ_ONLY) != 0,
+		hermetic:       (flags & GIT_REPOSITORY_OPEN_HERMETIC) != 0,
+		fs:             fsys,
+		commitCache:    NewCommitCache(DefaultCommitCacheSize),
+		graphCache:     newGraphCache(DefaultGraphCacheSize),
+		names:          newStringPool(DefaultStringPoolSize),
 	}
 	config := repo.Config()
 	loadConfigData(repo, config)
@@ -76,6 +199,20 @@ func openRepository(path string, flags uint32) (*Repository, error) {
 	return repo, nil
 }
 
+func (r *Repository) now() time.Time {
+	if r.clock != nil {
+		return r.clock()
+	}
+	if r.hermetic {
+		return time.Unix(0, 0).UTC()
+	}
+	return time.Now()
+}
+
+func errReadOnly(operation string) error {
+	return MakeGitError(fmt.Sprintf("%s: repository is read-only", operation), ErrReadOnly)
+}
+
 func loadConfigData(repo *Repository, config *Config) {
 	isBare, err := config.LookupBool("core.bare")
 	if err == nil {
@@ -93,8 +230,8 @@ func loadWorkDir(repo *Repository, config *Config, parent string) {
 		repo.workDir = filepath.Clean(path)
 		return
 	} else if parent != "" {
-		info, _ := os.Stat(parent)
-		if info.IsDir() {
+		info, err := repo.fs.Stat(parent)
+		if err == nil && info.IsDir() {
 			repo.workDir = parent
 		}
 		return
@@ -102,7 +239,7 @@ func loadWorkDir(repo *Repository, config *Config, parent string) {
 	repo.workDir = filepath.Dir(repo.pathRepository) + string(filepath.Separator)
 }
 
-func findRepo(startPath string, flags uint32, ceilingDirs []string) (repoPath, parentPath, linkPath string, err error) {
+func findRepo(fsys FileSystem, startPath string, flags uint32, ceilingDirs []string) (repoPath, parentPath, linkPath string, err error) {
 	path, err := filepath.Abs(startPath)
 	if err != nil {
 		return
@@ -122,19 +259,27 @@ func findRepo(startPath string, flags uint32, ceilingDirs []string) (repoPath, p
 		path = filepath.Join(path, ".git")
 	}
 	for repoPath == "" {
-		stat, tempErr := os.Stat(path)
+		stat, tempErr := fsys.Stat(path)
 		if tempErr == nil {
 			if stat.IsDir() {
-				if isValidRepositoryPath(path) {
+				if isValidRepositoryPath(fsys, path) {
 					repoPath = path + string(filepath.Separator)
 				}
 			}
-			if stat.Mode().IsRegular() {
-				repoLink, tempErr2 := readGitFile(path)
-				if tempErr2 == nil && isValidRepositoryPath(repoLink) {
-					repoPath = repoLink
-					linkPath = path
+			if stat.Mode().IsRegular() && filepath.Base(path) == GitDirName {
+				// submodules and linked worktrees have a .git file that
+				// points to their git directories
+				var repoLink string
+				repoLink, err = readGitFile(fsys, path)
+				if err != nil {
+					return
+				}
+				if !isValidRepositoryPath(fsys, repoLink) {
+					err = MakeGitError(fmt.Sprintf("gitfile '%s' points to '%s', which is not a git repository", path, repoLink), ErrNotFound)
+					return
 				}
+				repoPath = repoLink + string(filepath.Separator)
+				linkPath = path
 			}
 		}
 		parentDir := filepath.Dir(path)
@@ -153,6 +298,9 @@ func findRepo(startPath string, flags uint32, ceilingDirs []string) (repoPath, p
 	if err == nil && (flags&GIT_REPOSITORY_OPEN_BARE) == 0 {
 		if len(repoPath) == 0 {
 			parentPath = ""
+		} else if linkPath != "" {
+			// the working directory has the .git file
+			parentPath = filepath.Dir(linkPath) + string(filepath.Separator)
 		} else {
 			parentPath = filepath.Dir(repoPath[:len(repoPath)-1]) + string(filepath.Separator)
 		}
@@ -163,36 +311,67 @@ func findRepo(startPath string, flags uint32, ceilingDirs []string) (repoPath, p
 	return
 }
 
-func readGitFile(path string) (string, error) {
-	contentBytes, err := ioutil.ReadFile(path)
+// readGitFile returns the git directory that the .git file points to with
+// a "gitdir: <path>" line. A relative path is relative to the directory of
+// the file.
+func readGitFile(fsys FileSystem, path string) (string, error) {
+	contentBytes, err := fsys.ReadFile(path)
 	if err != nil {
 		return "", err
 	}
 	content := string(contentBytes)
-	if !strings.HasPrefix(content, "gitdir:") {
-		return "", errors.New(".git file shoudl have 'gitdir:' prefix")
+	if !strings.HasPrefix(content, gitFilePrefix) {
+		return "", MakeGitError(fmt.Sprintf("invalid gitfile format '%s': it should start with 'gitdir:'", path), ErrInvalid)
+	}
+	gitDir := strings.TrimSpace(content[len(gitFilePrefix):])
+	if gitDir == "" || strings.ContainsAny(gitDir, "\r\n") {
+		return "", MakeGitError(fmt.Sprintf("invalid gitfile format '%s': no path", path), ErrInvalid)
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(path), gitDir)
+	}
+	return filepath.Clean(gitDir), nil
+}
+
+// commonDir returns the directory that the commondir file of a linked
+// worktree points to, or the git directory itself.
+func commonDir(fsys FileSystem, repositoryPath string) string {
+	data, err := fsys.ReadFile(filepath.Join(repositoryPath, GitCommonDirFile))
+	if err != nil {
+		return repositoryPath
+	}
+	dir := strings.TrimSpace(string(data))
+	if dir == "" {
+		return repositoryPath
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(repositoryPath, dir)
 	}
-	return filepath.Clean(filepath.Join(filepath.Dir(path), strings.TrimSpace(content[7:]))), nil
+	return filepath.Clean(dir) + string(filepath.Separator)
 }
 
-func isContainsFile(dir, fileName string) bool {
-	stat, err := os.Stat(filepath.Join(dir, fileName))
+func isContainsFile(fsys FileSystem, dir, fileName string) bool {
+	stat, err := fsys.Stat(filepath.Join(dir, fileName))
 	if err != nil {
 		return false
 	}
 	return stat.Mode().IsRegular()
 }
 
-func isContainsDir(dir, subDirName string) bool {
-	stat, err := os.Stat(filepath.Join(dir, subDirName))
+func isContainsDir(fsys FileSystem, dir, subDirName string) bool {
+	stat, err := fsys.Stat(filepath.Join(dir, subDirName))
 	if err != nil {
 		return false
 	}
 	return stat.IsDir()
 }
 
-func isValidRepositoryPath(repositoryPath string) bool {
-	return isContainsDir(repositoryPath, GitObjectsDir) &&
-		isContainsFile(repositoryPath, GitHeadFile) &&
-		isContainsDir(repositoryPath, GitRefsDir)
+// isValidRepositoryPath checks the HEAD of the git directory, and the
+// objects and the refs of the common directory, which is the git directory
+// itself unless it is a linked worktree.
+func isValidRepositoryPath(fsys FileSystem, repositoryPath string) bool {
+	common := commonDir(fsys, repositoryPath)
+	return isContainsDir(fsys, common, GitObjectsDir) &&
+		isContainsFile(fsys, repositoryPath, GitHeadFile) &&
+		isContainsDir(fsys, common, GitRefsDir)
 }
@@ -0,0 +1,246 @@
+package git4go
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	GitDescriptionFile  string = "description"
+	GitHooksDir         string = "hooks"
+	gitTemplateDirEnv   string = "GIT_TEMPLATE_DIR"
+	gitInitConfigFormat string = "[core]\n\trepositoryformatversion = 0\n\tfilemode = %t\n\tbare = %t\n"
+)
+
+// the files that are written when no template directory is found
+var defaultTemplateFiles = map[string]string{
+	GitDescriptionFile: "Unnamed repository; edit this file 'description' to name the repository.\n",
+	GitInfoExcludeFile: "# git ls-files --others --exclude-from=.git/info/exclude\n" +
+		"# Lines that start with '#' are comments.\n" +
+		"# For a project mostly in C, the following would be a good set of\n" +
+		"# exclude patterns (uncomment them if you want to use them):\n" +
+		"# *.[oa]\n" +
+		"# *~\n",
+}
+
+type RepositoryInitOptions struct {
+	Bare bool
+	// The directory whose files (hooks, info/exclude, description, ...) are
+	// copied to the new repository. If it is empty, $GIT_TEMPLATE_DIR,
+	// init.templateDir of the global config and the system template
+	// directory are tried in this order.
+	TemplatePath string
+	// No template is copied
+	NoTemplate bool
+	// The branch that HEAD points to, like "main" or "refs/heads/main". If
+	// it is empty, init.defaultBranch or "master" is used.
+	InitialHead string
+	// Neither GIT_TEMPLATE_DIR nor the global config is read, and the
+	// repository is opened with OpenRepositoryHermetic. Templates are
+	// copied only from TemplatePath.
+	Hermetic bool
+	// The file system that the repository is created on and opened with,
+	// OSFileSystem if it is nil. The template directory is read from the
+	// disk like the global config.
+	FileSystem FileSystem
+}
+
+// InitRepository creates a repository at the path, like "git init". The
+// git directory is path/.git unless the repository is bare.
+func InitRepository(path string, isBare bool) (*Repository, error) {
+	return InitRepositoryExtended(path, &RepositoryInitOptions{Bare: isBare})
+}
+
+// InitRepositoryExtended creates a repository with the options. Running it
+// on an existing repository is safe: files that exist are kept, and only
+// the missing template files are copied, like "git init" does to
+// reinitialize a repository.
+func InitRepositoryExtended(path string, opts *RepositoryInitOptions) (*Repository, error) {
+	if opts == nil {
+		opts = &RepositoryInitOptions{}
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	fsys := fileSystemOrDefault(opts.FileSystem)
+	repoPath := path
+	if !opts.Bare {
+		repoPath = filepath.Join(path, GitDirName)
+	}
+	for _, dir := range []string{"objects/info", "objects/pack", GitRefsHeadsDir, GitRefsTagsDir} {
+		err = fsys.MkdirAll(filepath.Join(repoPath, filepath.FromSlash(dir)), os.FileMode(GitObjectDirMode))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	globalConfig, _ := NewConfig()
+	if !opts.Hermetic {
+		globalConfig.addGlobalFiles()
+	}
+	if !opts.NoTemplate {
+		templateDir := opts.TemplatePath
+		if !opts.Hermetic {
+			templateDir = findTemplateDir(templateDir, globalConfig)
+		}
+		err = copyTemplates(fsys, repoPath, templateDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	configPath := filepath.Join(repoPath, ConfigFileNameInrepo)
+	if _, err := fsys.Stat(configPath); os.IsNotExist(err) {
+		config := fmt.Sprintf(gitInitConfigFormat, probeFileMode(fsys, repoPath), opts.Bare)
+		if !opts.Bare {
+			config += "\tlogallrefupdates = true\n"
+		}
+		err = writeInitFile(fsys, configPath, []byte(config), 0666)
+		if err != nil {
+			return nil, err
+		}
+	}
+	headPath := filepath.Join(repoPath, GitHeadFile)
+	if _, err := fsys.Stat(headPath); os.IsNotExist(err) {
+		head := opts.InitialHead
+		if head == "" {
+			head = GitDefaultBranchFallback
+			if branch, err := globalConfig.LookupString("init.defaultBranch"); err == nil && branch != "" {
+				head = branch
+			}
+		}
+		if !strings.HasPrefix(head, GitRefsDir) {
+			head = GitRefsHeadsDir + head
+		}
+		head, err = referenceNormalize(head, false, false)
+		if err != nil {
+			return nil, err
+		}
+		err = writeInitFile(fsys, headPath, []byte("ref: "+head+"\n"), 0666)
+		if err != nil {
+			return nil, err
+		}
+	}
+	flags := uint32(GIT_REPOSITORY_OPEN_NO_SEARCH)
+	if opts.Hermetic {
+		flags |= GIT_REPOSITORY_OPEN_HERMETIC
+	}
+	return openRepository(fsys, repoPath, flags)
+}
+
+// internal functions
+
+func findTemplateDir(templatePath string, config *Config) string {
+	if templatePath != "" {
+		return templatePath
+	}
+	if env := os.Getenv(gitTemplateDirEnv); env != "" {
+		return env
+	}
+	if dir, err := config.LookupString("init.templateDir"); err == nil && dir != "" {
+		return expandHomeDir(dir)
+	}
+	dir, err := findInDirList("", "template")
+	if err != nil {
+		return ""
+	}
+	return dir
+}
+
+func expandHomeDir(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
+
+// copyTemplates copies the files of the template directory that do not
+// exist in the repository yet. The config of the template is skipped,
+// because the repository gets its own. The default description and
+// info/exclude are written if there is no template directory.
+func copyTemplates(fsys FileSystem, repoPath, templateDir string) error {
+	info, err := os.Stat(templateDir)
+	if templateDir == "" || err != nil || !info.IsDir() {
+		err = fsys.MkdirAll(filepath.Join(repoPath, GitHooksDir), os.FileMode(GitObjectDirMode))
+		if err != nil {
+			return err
+		}
+		for name, content := range defaultTemplateFiles {
+			path := filepath.Join(repoPath, filepath.FromSlash(name))
+			if _, err := fsys.Stat(path); !os.IsNotExist(err) {
+				continue
+			}
+			err = fsys.MkdirAll(filepath.Dir(path), os.FileMode(GitObjectDirMode))
+			if err == nil {
+				err = writeInitFile(fsys, path, []byte(content), 0666)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return filepath.Walk(templateDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(templateDir, path)
+		if err != nil || rel == "." || rel == ConfigFileNameInrepo {
+			return err
+		}
+		target := filepath.Join(repoPath, rel)
+		if info.IsDir() {
+			return fsys.MkdirAll(target, os.FileMode(GitObjectDirMode))
+		}
+		if _, err := fsys.Lstat(target); !os.IsNotExist(err) {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return writeInitFile(fsys, target, data, info.Mode().Perm())
+	})
+}
+
+func writeInitFile(fsys FileSystem, path string, data []byte, mode os.FileMode) error {
+	file, err := fsys.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err == nil {
+		_, err = file.Write(data)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("failed to write '%s': %s", path, err.Error()))
+	}
+	return nil
+}
+
+// probeFileMode checks whether the file system keeps the execute bit, which
+// is core.filemode.
+func probeFileMode(fsys FileSystem, repoPath string) bool {
+	file, err := fsys.TempFile(repoPath, "config_probe_")
+	if err != nil {
+		return true
+	}
+	name := file.Name()
+	file.Close()
+	defer fsys.Remove(name)
+	before, err := fsys.Stat(name)
+	if err != nil {
+		return true
+	}
+	if fsys.Chmod(name, before.Mode()^0100) != nil {
+		return false
+	}
+	after, err := fsys.Stat(name)
+	return err == nil && after.Mode() != before.Mode()
+}
@@ -0,0 +1,134 @@
+package git4go
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func makeTemplateDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "git4go_template")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	os.MkdirAll(filepath.Join(dir, "hooks"), 0777)
+	os.MkdirAll(filepath.Join(dir, "info"), 0777)
+	ioutil.WriteFile(filepath.Join(dir, "hooks", "pre-receive"), []byte("#!/bin/sh\nexit 0\n"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "info", "exclude"), []byte("*.tmp\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "description"), []byte("template repository\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "config"), []byte("[core]\n\tbare = true\n"), 0644)
+	return dir
+}
+
+func Test_InitRepositoryWithTemplate(t *testing.T) {
+	templateDir := makeTemplateDir(t)
+	defer os.RemoveAll(templateDir)
+	dir, _ := ioutil.TempDir("", "git4go_init")
+	defer os.RemoveAll(dir)
+
+	repo, err := InitRepositoryExtended(dir, &RepositoryInitOptions{
+		TemplatePath: templateDir,
+		InitialHead:  "main",
+	})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if repo.IsBare() {
+		t.Error("it should not be bare: the config of the template is not copied")
+	}
+	if repo.Path() != filepath.Join(dir, ".git")+string(filepath.Separator) && repo.Path() != filepath.Join(dir, ".git") {
+		t.Error("wrong path:", repo.Path())
+	}
+	head, _ := ioutil.ReadFile(filepath.Join(dir, ".git", "HEAD"))
+	if string(head) != "ref: refs/heads/main\n" {
+		t.Errorf("wrong HEAD: %q", string(head))
+	}
+	stat, err := os.Stat(filepath.Join(dir, ".git", "hooks", "pre-receive"))
+	if err != nil || stat.Mode()&0100 == 0 {
+		t.Error("the hook should be copied with the execute bit:", err)
+	}
+	if ignored, _ := repo.IsPathIgnored("a.tmp"); !ignored {
+		t.Error("info/exclude should be copied")
+	}
+	for _, sub := range []string{"objects/pack", "objects/info", "refs/heads", "refs/tags"} {
+		if _, err := os.Stat(filepath.Join(dir, ".git", filepath.FromSlash(sub))); err != nil {
+			t.Error("the directory should be created:", sub)
+		}
+	}
+}
+
+func Test_InitRepositoryReinit(t *testing.T) {
+	templateDir := makeTemplateDir(t)
+	defer os.RemoveAll(templateDir)
+	dir, _ := ioutil.TempDir("", "git4go_init")
+	defer os.RemoveAll(dir)
+
+	_, err := InitRepositoryExtended(dir, &RepositoryInitOptions{
+		Bare:         true,
+		TemplatePath: filepath.Join(templateDir, "missing"),
+		InitialHead:  "refs/heads/trunk",
+	})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	description, _ := ioutil.ReadFile(filepath.Join(dir, "description"))
+	if !strings.HasPrefix(string(description), "Unnamed repository") {
+		t.Error("the default description should be written without templates")
+	}
+
+	os.Setenv("GIT_TEMPLATE_DIR", templateDir)
+	defer os.Unsetenv("GIT_TEMPLATE_DIR")
+	repo, err := InitRepositoryExtended(dir, &RepositoryInitOptions{Bare: true, InitialHead: "other"})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if !repo.IsBare() {
+		t.Error("it should be bare")
+	}
+	description, _ = ioutil.ReadFile(filepath.Join(dir, "description"))
+	if !strings.HasPrefix(string(description), "Unnamed repository") {
+		t.Error("existing files should be kept")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "hooks", "pre-receive")); err != nil {
+		t.Error("missing template files should be copied from $GIT_TEMPLATE_DIR")
+	}
+	head, _ := ioutil.ReadFile(filepath.Join(dir, "HEAD"))
+	if string(head) != "ref: refs/heads/trunk\n" {
+		t.Errorf("HEAD should be kept: %q", string(head))
+	}
+}
+
+func Test_InitRepositoryInvalidHead(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_init")
+	defer os.RemoveAll(dir)
+
+	_, err := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, InitialHead: "bad."})
+	if err == nil {
+		t.Error("it should reject the invalid branch name")
+	}
+}
+
+func Test_InitRepositoryHermetic(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_init")
+	defer os.RemoveAll(dir)
+	templateDir := makeTemplateDir(t)
+	defer os.RemoveAll(templateDir)
+
+	os.Setenv(gitTemplateDirEnv, templateDir)
+	defer os.Unsetenv(gitTemplateDirEnv)
+	repo, err := InitRepositoryExtended(dir, &RepositoryInitOptions{Hermetic: true})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if !repo.IsHermetic() {
+		t.Error("it should open the repository in hermetic mode")
+	}
+	if _, err := os.Stat(filepath.Join(repo.Path(), GitDescriptionFile)); err != nil {
+		t.Error("it should write the default files:", err)
+	}
+	if _, err := os.Stat(filepath.Join(repo.Path(), GitHooksDir, "pre-receive")); err == nil {
+		t.Error("it should not read GIT_TEMPLATE_DIR")
+	}
+}
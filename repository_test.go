@@ -2,8 +2,11 @@ package git4go
 
 import (
 	"./testutil"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -12,17 +15,17 @@ func Test_isValidRepositoryPath(t *testing.T) {
 	testutil.PrepareWorkspace("test_resources/empty_standard_repo/")
 	defer testutil.CleanupWorkspace()
 
-	if isValidRepositoryPath("test_resources/empty_standard_repo") {
+	if isValidRepositoryPath(OSFileSystem, "test_resources/empty_standard_repo") {
 		t.Errorf("It is invalid path because it is not initialized")
 	}
 
-	if !isValidRepositoryPath("test_resources/empty_standard_repo/.git") {
+	if !isValidRepositoryPath(OSFileSystem, "test_resources/empty_standard_repo/.git") {
 		t.Errorf("It should be valid path")
 	}
 }
 
 func Test_readGitFile(t *testing.T) {
-	path, err := readGitFile("test_resources/submod2/sm_unchanged/.gitted")
+	path, err := readGitFile(OSFileSystem, "test_resources/submod2/sm_unchanged/.gitted")
 	if err != nil {
 		t.Error("it shouldn't be error:", err)
 	}
@@ -197,3 +200,210 @@ func Test_OpenRepositoryExtended_failure(t *testing.T) {
 		t.Error("it should not be null when loading repository in failure")
 	}
 }
+
+func Test_Repository_concurrentAccess(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, err := OpenRepository("test_resources/testrepo.git")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	ids := []string{
+		"a65fedf39aefe402d3bb6e24df4d4f5fe4547750",
+		"c47800c7266a2be04c571c04d5a6614691ea99bd",
+		"a71586c1dfe8a71c6cbf6c129f404c5642ff31bd",
+		"1385f264afb75a56a5bec74243be9b367ba4ca08",
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 32*len(ids))
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, id := range ids {
+				oid, _ := NewOid(id)
+				_, err := repo.Lookup(oid)
+				if err != nil {
+					errs <- err
+				}
+			}
+			_, err := repo.Head()
+			if err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error("err should be nil:", err)
+	}
+}
+
+func Test_OpenRepositoryReadOnly(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, err := OpenRepositoryReadOnly("test_resources/testrepo.git")
+	if err != nil || repo == nil {
+		t.Fatal("it should open repository:", err)
+	}
+	if !repo.IsReadOnly() {
+		t.Error("it should be read-only")
+	}
+	oid, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	_, err = repo.LookupCommit(oid)
+	if err != nil {
+		t.Error("it should read objects:", err)
+	}
+	odb, _ := repo.Odb()
+	_, err = odb.Write([]byte("hello"), ObjectBlob)
+	if !IsErrorCode(err, ErrReadOnly) {
+		t.Error("it should not write objects:", err)
+	}
+	err = repo.Config().SetString("core.abbrev", "8")
+	if !IsErrorCode(err, ErrReadOnly) {
+		t.Error("it should not write config:", err)
+	}
+	_, err = os.Stat("test_resources/testrepo.git/config.lock")
+	if !os.IsNotExist(err) {
+		t.Error("it should not create lockfile")
+	}
+}
+
+func Test_NewInMemoryRepository(t *testing.T) {
+	repo, err := NewInMemoryRepository()
+	if err != nil {
+		t.Fatal("it should create repository:", err)
+	}
+	if !repo.IsBare() || repo.Path() != "" {
+		t.Error("it should be bare repository without path")
+	}
+	blobId, err := repo.CreateBlobFromBuffer([]byte("hello\n"))
+	if err != nil {
+		t.Fatal("it should write blob:", err)
+	}
+	builder, _ := repo.TreeBuilder()
+	builder.Insert("hello.txt", blobId, FilemodeBlob)
+	treeId, err := builder.Write()
+	if err != nil {
+		t.Fatal("it should write tree:", err)
+	}
+	tree, err := repo.LookupTree(treeId)
+	if err != nil || tree.EntryByName("hello.txt") == nil {
+		t.Error("it should read tree from memory:", err)
+	}
+	_, err = repo.CreateReference("refs/heads/master", treeId, false)
+	if err != nil {
+		t.Error("it should create reference:", err)
+	}
+	_, err = repo.CreateReference("refs/heads/master", treeId, false)
+	if !IsErrorCode(err, ErrExists) {
+		t.Error("it should not overwrite reference without force:", err)
+	}
+	head, err := repo.Head()
+	if err != nil || !head.Target().Equal(treeId) {
+		t.Error("HEAD should point to refs/heads/master:", err)
+	}
+	var names []string
+	repo.ForEachReferenceName(func(name string) error {
+		names = append(names, name)
+		return nil
+	})
+	if len(names) != 1 || names[0] != "refs/heads/master" {
+		t.Error("it should list references in memory:", names)
+	}
+}
+
+func Test_readGitFile_absoluteAndInvalid(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_gitfile")
+	defer os.RemoveAll(dir)
+
+	gitFile := filepath.Join(dir, ".git")
+	ioutil.WriteFile(gitFile, []byte("gitdir: "+filepath.Join(dir, "modules", "sub")+"\r\n"), 0644)
+	path, err := readGitFile(OSFileSystem, gitFile)
+	if err != nil || path != filepath.Join(dir, "modules", "sub") {
+		t.Error("it should use an absolute path as is:", path, err)
+	}
+	for _, content := range []string{"", "gitdir:\n", "git: ../x\n", "gitdir: a\nb\n"} {
+		ioutil.WriteFile(gitFile, []byte(content), 0644)
+		if _, err := readGitFile(OSFileSystem, gitFile); !IsErrorCode(err, ErrInvalid) {
+			t.Errorf("it should reject %q: %v", content, err)
+		}
+	}
+}
+
+func Test_OpenRepository_submodule(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/submod2")
+	defer testutil.CleanupWorkspace()
+
+	repo, err := OpenRepository("test_resources/submod2/sm_unchanged")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if !strings.HasSuffix(repo.Path(), filepath.FromSlash("submod2/.git/modules/sm_unchanged/")) {
+		t.Error("it should follow the .git file:", repo.Path())
+	}
+	if !strings.HasSuffix(repo.Workdir(), filepath.FromSlash("submod2/sm_unchanged")) {
+		t.Error("wrong workdir:", repo.Workdir())
+	}
+	head, err := repo.Head()
+	if err != nil || head.Name() != "refs/heads/master" {
+		t.Error("it should read HEAD of the submodule:", err)
+	}
+}
+
+func Test_OpenRepository_linkedWorktree(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo")
+	defer testutil.CleanupWorkspace()
+
+	gitDir := filepath.FromSlash("test_resources/testrepo/.git/worktrees/wt")
+	os.MkdirAll(gitDir, 0777)
+	ioutil.WriteFile(filepath.Join(gitDir, "HEAD"), []byte("ref: refs/heads/br2\n"), 0644)
+	ioutil.WriteFile(filepath.Join(gitDir, "commondir"), []byte("../..\n"), 0644)
+	os.MkdirAll("test_resources/testrepo/wt", 0777)
+	ioutil.WriteFile("test_resources/testrepo/wt/.git", []byte("gitdir: ../.git/worktrees/wt\n"), 0644)
+
+	repo, err := OpenRepository("test_resources/testrepo/wt")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if !strings.HasSuffix(repo.CommonDir(), filepath.FromSlash("testrepo/.git/")) {
+		t.Error("wrong common dir:", repo.CommonDir())
+	}
+	if !strings.HasSuffix(repo.Workdir(), filepath.FromSlash("testrepo/wt/")) {
+		t.Error("the workdir should be the directory of the .git file:", repo.Workdir())
+	}
+	head, err := repo.Head()
+	if err != nil || head.Name() != "refs/heads/br2" {
+		t.Error("HEAD should be the one of the worktree:", err)
+	}
+	master, err := repo.LookupReference("refs/heads/master")
+	if err != nil {
+		t.Fatal("shared refs should be read from the common dir:", err)
+	}
+	if _, err := repo.LookupCommit(master.Target()); err != nil {
+		t.Error("objects should be read from the common dir:", err)
+	}
+	repo.CreateReference("refs/bisect/bad", master.Target(), false)
+	if _, err := os.Stat(filepath.Join(gitDir, "refs", "bisect", "bad")); err != nil {
+		t.Error("per-worktree refs should be written to the worktree:", err)
+	}
+}
+
+func Test_OpenRepository_brokenGitFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_gitfile")
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, ".git"), []byte("gitdir: missing\n"), 0644)
+	_, err := OpenRepository(dir)
+	if !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should fail when the gitdir is not a repository:", err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, ".git"), []byte("garbage\n"), 0644)
+	_, err = OpenRepository(dir)
+	if !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should fail with a malformed .git file:", err)
+	}
+}
@@ -0,0 +1,537 @@
+package git4go
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	GitRerereDir   = "rr-cache"
+	GitMergeRRFile = "MERGE_RR"
+
+	gitRererePreimage         = "preimage"
+	gitRererePostimage        = "postimage"
+	gitRerereResolvedDays     = 60
+	gitRerereUnresolvedDays   = 15
+	gitRerereConflictFileMode = 0644
+)
+
+// RerereEnabled returns true if the resolutions of conflicts are recorded
+// and reused. It is rerere.enabled, or whether rr-cache exists if it is not
+// set, like git.
+func (r *Repository) RerereEnabled() bool {
+	if config := r.Config(); config != nil {
+		if enabled, err := config.LookupBool("rerere.enabled"); err == nil {
+			return enabled
+		}
+	}
+	info, err := r.fs.Stat(filepath.Join(r.pathCommon, GitRerereDir))
+	return err == nil && info.IsDir()
+}
+
+// EnableRerere sets rerere.enabled. The rr-cache directory is created when
+// it is enabled.
+func (r *Repository) EnableRerere(enabled bool) error {
+	if r.readOnly {
+		return errReadOnly("Repository.EnableRerere")
+	}
+	config := r.Config()
+	if config == nil {
+		return MakeGitError("the repository has no config", ErrNotFound)
+	}
+	err := config.SetBool("rerere.enabled", enabled)
+	if err != nil || !enabled {
+		return err
+	}
+	return r.fs.MkdirAll(filepath.Join(r.pathCommon, GitRerereDir), os.FileMode(GitObjectDirMode))
+}
+
+// Rerere records and reuses the resolutions of conflicts like "git rerere".
+// The conflicts of the index that have markers in the working directory
+// are recorded in MERGE_RR with their preimages. A conflict that was
+// resolved before is resolved again in the working directory, and the
+// paths that it resolved are returned. The paths of MERGE_RR that have no
+// conflict markers any more are recorded as resolutions. It does nothing if
+// rerere is not enabled.
+func (r *Repository) Rerere() ([]string, error) {
+	if !r.RerereEnabled() {
+		return nil, nil
+	}
+	if r.readOnly {
+		return nil, errReadOnly("Repository.Rerere")
+	}
+	if r.IsBare() {
+		return nil, MakeGitError("rerere needs a working directory", ErrBareRepository)
+	}
+	mergeRR, err := r.readMergeRR()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := r.rerereConflicts()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		if _, ok := mergeRR[path]; ok {
+			continue
+		}
+		contents, err := r.fs.ReadFile(filepath.Join(r.Workdir(), filepath.FromSlash(path)))
+		if err != nil {
+			return nil, err
+		}
+		id, preimage, err := r.rerereNormalize(path, contents)
+		if err != nil {
+			return nil, err
+		}
+		if id == "" {
+			continue
+		}
+		mergeRR[path] = id
+		if !r.rerereHas(id, gitRererePostimage) && !r.rerereHas(id, gitRererePreimage) {
+			err = r.writeRerereFile(id, gitRererePreimage, preimage)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var resolved []string
+	for path, id := range mergeRR {
+		done, reused, err := r.rerereOnePath(path, id)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			delete(mergeRR, path)
+		}
+		if reused {
+			resolved = append(resolved, path)
+		}
+	}
+	sort.Strings(resolved)
+	return resolved, r.writeMergeRR(mergeRR)
+}
+
+// RerereForget forgets the recorded resolution of the conflict of the path
+// in the index, like "git rerere forget". The preimage is recorded again, so
+// the next resolution of the path is recorded by Rerere.
+func (r *Repository) RerereForget(path string) error {
+	if r.readOnly {
+		return errReadOnly("Repository.RerereForget")
+	}
+	index, err := r.Index()
+	if err != nil {
+		return err
+	}
+	conflict, err := index.GetConflict(path)
+	if err != nil || conflict.Our == nil || conflict.Their == nil {
+		return MakeGitError(fmt.Sprintf("'%s' is not in conflict", path), ErrNotFound)
+	}
+	var inputs []*MergeFileInput
+	for _, entry := range []*IndexEntry{conflict.Ancestor, conflict.Our, conflict.Their} {
+		input := &MergeFileInput{}
+		if entry != nil {
+			blob, err := r.LookupBlob(entry.Id)
+			if err != nil {
+				return err
+			}
+			input.Path = path
+			input.Mode = entry.Mode
+			input.Contents = blob.Contents()
+		}
+		inputs = append(inputs, input)
+	}
+	merged, err := r.MergeFile(inputs[0], inputs[1], inputs[2], &MergeFileOptions{Style: MergeFileStyleMerge})
+	if err != nil {
+		return err
+	}
+	id, preimage, err := r.rerereNormalize(path, merged.Contents)
+	if err != nil {
+		return err
+	}
+	if id == "" || !r.rerereHas(id, gitRererePostimage) {
+		return MakeGitError(fmt.Sprintf("no remembered resolution for '%s'", path), ErrNotFound)
+	}
+	err = r.fs.Remove(r.rererePath(id, gitRererePostimage))
+	if err != nil {
+		return err
+	}
+	err = r.writeRerereFile(id, gitRererePreimage, preimage)
+	if err != nil {
+		return err
+	}
+	mergeRR, err := r.readMergeRR()
+	if err != nil {
+		return err
+	}
+	mergeRR[path] = id
+	return r.writeMergeRR(mergeRR)
+}
+
+// RerereClear forgets the conflicts of MERGE_RR that are not resolved yet,
+// like "git rerere clear" does when a merge is aborted.
+func (r *Repository) RerereClear() error {
+	if r.readOnly {
+		return errReadOnly("Repository.RerereClear")
+	}
+	mergeRR, err := r.readMergeRR()
+	if err != nil {
+		return err
+	}
+	for _, id := range mergeRR {
+		if !r.rerereHas(id, gitRererePostimage) {
+			err = r.removeRerereDir(id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = r.fs.Remove(filepath.Join(r.pathRepository, GitMergeRRFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// RerereGC removes the old records of rr-cache like "git rerere gc". The
+// resolutions that have not been used for gc.rerereResolved days (60 by
+// default) and the conflicts that have not been resolved for
+// gc.rerereUnresolved days (15 by default) are removed.
+func (r *Repository) RerereGC() error {
+	if r.readOnly {
+		return errReadOnly("Repository.RerereGC")
+	}
+	entries, err := r.fs.ReadDir(filepath.Join(r.pathCommon, GitRerereDir))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	now := r.now()
+	resolvedLimit := now.Add(-r.rerereExpiry("gc.rerereResolved", gitRerereResolvedDays))
+	unresolvedLimit := now.Add(-r.rerereExpiry("gc.rerereUnresolved", gitRerereUnresolvedDays))
+	for _, entry := range entries {
+		id := entry.Name()
+		if !entry.IsDir() || !isRerereId(id) {
+			continue
+		}
+		name, limit := gitRererePostimage, resolvedLimit
+		if !r.rerereHas(id, gitRererePostimage) {
+			name, limit = gitRererePreimage, unresolvedLimit
+		}
+		info, err := r.fs.Stat(r.rererePath(id, name))
+		if err == nil && !info.ModTime().Before(limit) {
+			continue
+		}
+		err = r.removeRerereDir(id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// internal functions and methods
+
+// rerereOnePath updates the record of a path of MERGE_RR. done is true if
+// the path is not in conflict any more, and reused is true if the recorded
+// resolution resolved it. The resolution of a path that the user resolved
+// is recorded.
+func (r *Repository) rerereOnePath(path, id string) (done, reused bool, err error) {
+	fullPath := filepath.Join(r.Workdir(), filepath.FromSlash(path))
+	contents, err := r.fs.ReadFile(fullPath)
+	if os.IsNotExist(err) {
+		// the user removed the file to resolve the conflict
+		return true, false, nil
+	} else if err != nil {
+		return false, false, err
+	}
+	if r.rerereHas(id, gitRererePostimage) {
+		reused, err = r.rerereMerge(id, path, fullPath, contents)
+		return reused, reused, err
+	}
+	currentId, _, err := r.rerereNormalize(path, contents)
+	if err != nil || currentId != "" || bytes.Contains(contents, []byte("<<<<<<<")) {
+		return false, false, err
+	}
+	return true, false, r.writeRerereFile(id, gitRererePostimage, contents)
+}
+
+// rerereMerge applies the change from the preimage to the postimage to the
+// conflict in the file. The file is not changed if it does not merge
+// cleanly.
+func (r *Repository) rerereMerge(id, path, fullPath string, contents []byte) (bool, error) {
+	_, current, err := r.rerereNormalize(path, contents)
+	if err != nil {
+		return false, err
+	}
+	preimage, err := r.fs.ReadFile(r.rererePath(id, gitRererePreimage))
+	if err != nil {
+		return false, err
+	}
+	postimage, err := r.fs.ReadFile(r.rererePath(id, gitRererePostimage))
+	if err != nil {
+		return false, err
+	}
+	merged, err := MergeFile(
+		&MergeFileInput{Path: path, Mode: FilemodeBlob, Contents: preimage},
+		&MergeFileInput{Path: path, Mode: FilemodeBlob, Contents: current},
+		&MergeFileInput{Path: path, Mode: FilemodeBlob, Contents: postimage}, nil)
+	if err != nil || !merged.Automergeable {
+		return false, err
+	}
+	file, err := r.fs.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, gitRerereConflictFileMode)
+	if err != nil {
+		return false, err
+	}
+	_, err = file.Write(merged.Contents)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, err
+	}
+	// the resolution is used, so gc keeps it
+	now := r.now()
+	r.fs.Chtimes(r.rererePath(id, gitRererePostimage), now, now)
+	return true, nil
+}
+
+// rerereNormalize finds the conflicts in the contents. The id is the hash
+// of the conflicts, where the two sides of each conflict are sorted, so a
+// conflict has the same id when the branches are merged the other way
+// round. The normalized contents have the sorted sides without labels and
+// without the common ancestor of diff3. The id is empty if the contents
+// have no conflicts, or conflicts that are broken or nested.
+func (r *Repository) rerereNormalize(path string, contents []byte) (string, []byte, error) {
+	markerSize, err := r.conflictMarkerSize(path)
+	if err != nil {
+		return "", nil, err
+	}
+	const (
+		outside = iota
+		inOurs
+		inBase
+		inTheirs
+	)
+	hash := sha1.New()
+	var normalized, ours, theirs bytes.Buffer
+	hunks := 0
+	state := outside
+	for len(contents) > 0 {
+		n := bytes.IndexByte(contents, '\n') + 1
+		if n == 0 {
+			n = len(contents)
+		}
+		line := contents[:n]
+		contents = contents[n:]
+		switch {
+		case isConflictMarker(line, '<', markerSize, true):
+			if state != outside {
+				// nested conflicts are not handled
+				return "", nil, nil
+			}
+			state = inOurs
+		case isConflictMarker(line, '|', markerSize, true) && state == inOurs:
+			state = inBase
+		case isConflictMarker(line, '=', markerSize, false) && (state == inOurs || state == inBase):
+			state = inTheirs
+		case isConflictMarker(line, '>', markerSize, true) && state == inTheirs:
+			one, two := ours.Bytes(), theirs.Bytes()
+			if bytes.Compare(one, two) > 0 {
+				one, two = two, one
+			}
+			writeConflictMarker(&normalized, '<', markerSize, "")
+			normalized.Write(one)
+			writeConflictMarker(&normalized, '=', markerSize, "")
+			normalized.Write(two)
+			writeConflictMarker(&normalized, '>', markerSize, "")
+			hash.Write(one)
+			hash.Write([]byte{0})
+			hash.Write(two)
+			hash.Write([]byte{0})
+			ours.Reset()
+			theirs.Reset()
+			hunks++
+			state = outside
+		case state == inOurs:
+			ours.Write(line)
+		case state == inTheirs:
+			theirs.Write(line)
+		case state == outside:
+			normalized.Write(line)
+		}
+	}
+	if state != outside || hunks == 0 {
+		return "", normalized.Bytes(), nil
+	}
+	return hex.EncodeToString(hash.Sum(nil)), normalized.Bytes(), nil
+}
+
+// isConflictMarker checks the line is a marker of the size. The markers
+// except "=======" may have a label after a space.
+func isConflictMarker(line []byte, marker byte, size int, label bool) bool {
+	if len(line) < size+1 {
+		return false
+	}
+	for _, c := range line[:size] {
+		if c != marker {
+			return false
+		}
+	}
+	next := line[size]
+	return next == '\n' || (label && next == ' ')
+}
+
+// rerereConflicts returns the paths of the index whose conflict is between
+// two regular files, which are the ones that rerere can handle.
+func (r *Repository) rerereConflicts() ([]string, error) {
+	index, err := r.Index()
+	if err != nil {
+		return nil, err
+	}
+	iter, err := index.ConflictIterator()
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for {
+		conflict, err := iter.Next()
+		if IsErrorCode(err, ErrIterOver) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if conflict.Our == nil || conflict.Their == nil {
+			continue
+		}
+		if (conflict.Our.Mode != FilemodeBlob && conflict.Our.Mode != FilemodeBlobExecutable) ||
+			(conflict.Their.Mode != FilemodeBlob && conflict.Their.Mode != FilemodeBlobExecutable) {
+			continue
+		}
+		paths = append(paths, conflict.Our.Path)
+	}
+	return paths, nil
+}
+
+// readMergeRR reads MERGE_RR, whose records are "<id>\t<path>\0".
+func (r *Repository) readMergeRR() (map[string]string, error) {
+	result := make(map[string]string)
+	data, err := r.fs.ReadFile(filepath.Join(r.pathRepository, GitMergeRRFile))
+	if os.IsNotExist(err) {
+		return result, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, record := range bytes.Split(data, []byte{0}) {
+		if len(record) == 0 {
+			continue
+		}
+		tab := bytes.IndexByte(record, '\t')
+		if tab == -1 || !isRerereId(string(record[:tab])) {
+			return nil, errors.New(fmt.Sprintf("corrupt MERGE_RR record '%s'", record))
+		}
+		result[string(record[tab+1:])] = string(record[:tab])
+	}
+	return result, nil
+}
+
+func (r *Repository) writeMergeRR(mergeRR map[string]string) error {
+	path := filepath.Join(r.pathRepository, GitMergeRRFile)
+	if len(mergeRR) == 0 {
+		err := r.fs.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	paths := make([]string, 0, len(mergeRR))
+	for path := range mergeRR {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var buffer bytes.Buffer
+	for _, p := range paths {
+		buffer.WriteString(mergeRR[p])
+		buffer.WriteByte('\t')
+		buffer.WriteString(p)
+		buffer.WriteByte(0)
+	}
+	lock, err := newLockfile(r.fs, path, 0644, DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	_, err = lock.Write(buffer.Bytes())
+	if err != nil {
+		lock.Rollback()
+		return err
+	}
+	return lock.Commit()
+}
+
+func (r *Repository) rererePath(id, name string) string {
+	return filepath.Join(r.pathCommon, GitRerereDir, id, name)
+}
+
+func (r *Repository) rerereHas(id, name string) bool {
+	_, err := r.fs.Stat(r.rererePath(id, name))
+	return err == nil
+}
+
+func (r *Repository) writeRerereFile(id, name string, data []byte) error {
+	err := r.fs.MkdirAll(filepath.Join(r.pathCommon, GitRerereDir, id), os.FileMode(GitObjectDirMode))
+	if err != nil {
+		return err
+	}
+	file, err := r.fs.OpenFile(r.rererePath(id, name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, gitRerereConflictFileMode)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (r *Repository) removeRerereDir(id string) error {
+	for _, name := range []string{gitRererePreimage, gitRererePostimage} {
+		err := r.fs.Remove(r.rererePath(id, name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	err := r.fs.Remove(filepath.Join(r.pathCommon, GitRerereDir, id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) rerereExpiry(name string, days int) time.Duration {
+	if config := r.Config(); config != nil {
+		for _, key := range []string{name, strings.ToLower(name)} {
+			if value, err := config.LookupInt32(key); err == nil {
+				days = int(value)
+				break
+			}
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func isRerereId(id string) bool {
+	if len(id) != GitOidHexSize {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
@@ -0,0 +1,150 @@
+package git4go
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	rerereOurs     = "one\ntwo ours\nthree\n"
+	rerereTheirs   = "one\ntwo theirs\nthree\n"
+	rerereResolved = "one\ntwo resolved\nthree\n"
+)
+
+// prepareRerereConflict merges two branches that conflict in file.txt and
+// writes the file with conflict markers to the working directory.
+func prepareRerereConflict(t *testing.T, repo *Repository, swap bool) {
+	ours, theirs := rerereOurs, rerereTheirs
+	if swap {
+		ours, theirs = theirs, ours
+	}
+	base := writeMergeCommit(repo, map[string]string{"file.txt": "one\ntwo\nthree\n"})
+	ourCommit := writeMergeCommit(repo, map[string]string{"file.txt": ours}, base)
+	theirCommit := writeMergeCommit(repo, map[string]string{"file.txt": theirs}, base)
+	index, err := repo.MergeCommits(ourCommit, theirCommit, nil)
+	if err != nil || !index.HasConflicts() {
+		t.Fatal("it should make a conflict:", err)
+	}
+	repo.SetIndex(index)
+	merged, _ := MergeFile(
+		&MergeFileInput{Path: "file.txt", Mode: FilemodeBlob, Contents: []byte("one\ntwo\nthree\n")},
+		&MergeFileInput{Path: "file.txt", Mode: FilemodeBlob, Contents: []byte(ours)},
+		&MergeFileInput{Path: "file.txt", Mode: FilemodeBlob, Contents: []byte(theirs)},
+		&MergeFileOptions{Style: MergeFileStyleDiff3, OurLabel: "HEAD", TheirLabel: "topic"})
+	ioutil.WriteFile(filepath.Join(repo.Workdir(), "file.txt"), merged.Contents, 0644)
+}
+
+func Test_Rerere(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_rerere")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+
+	prepareRerereConflict(t, repo, false)
+	resolved, err := repo.Rerere()
+	if err != nil || resolved != nil {
+		t.Error("it should do nothing while rerere is disabled:", resolved, err)
+	}
+	if _, err := os.Stat(filepath.Join(repo.Path(), GitMergeRRFile)); !os.IsNotExist(err) {
+		t.Error("it should not write MERGE_RR while rerere is disabled")
+	}
+
+	repo.EnableRerere(true)
+	if !repo.RerereEnabled() {
+		t.Fatal("it should be enabled")
+	}
+	_, err = repo.Rerere()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	mergeRR, _ := ioutil.ReadFile(filepath.Join(repo.Path(), GitMergeRRFile))
+	if len(mergeRR) < 40 || string(mergeRR[40:]) != "\tfile.txt\x00" {
+		t.Fatalf("it should record the conflict in MERGE_RR: %q", mergeRR)
+	}
+	id := string(mergeRR[:40])
+	preimage, _ := ioutil.ReadFile(filepath.Join(repo.Path(), GitRerereDir, id, "preimage"))
+	if string(preimage) != "one\n<<<<<<<\ntwo ours\n=======\ntwo theirs\n>>>>>>>\nthree\n" {
+		t.Errorf("it should record the normalized preimage: %q", preimage)
+	}
+
+	ioutil.WriteFile(filepath.Join(repo.Workdir(), "file.txt"), []byte(rerereResolved), 0644)
+	_, err = repo.Rerere()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	postimage, _ := ioutil.ReadFile(filepath.Join(repo.Path(), GitRerereDir, id, "postimage"))
+	if string(postimage) != rerereResolved {
+		t.Errorf("it should record the resolution: %q", postimage)
+	}
+	if _, err := os.Stat(filepath.Join(repo.Path(), GitMergeRRFile)); !os.IsNotExist(err) {
+		t.Error("it should remove MERGE_RR when all conflicts are resolved")
+	}
+
+	// the same conflict with the sides the other way round
+	prepareRerereConflict(t, repo, true)
+	resolved, err = repo.Rerere()
+	if err != nil || len(resolved) != 1 || resolved[0] != "file.txt" {
+		t.Fatal("it should reuse the resolution:", resolved, err)
+	}
+	contents, _ := ioutil.ReadFile(filepath.Join(repo.Workdir(), "file.txt"))
+	if string(contents) != rerereResolved {
+		t.Errorf("it should write the resolution to the working directory: %q", contents)
+	}
+
+	err = repo.RerereForget("file.txt")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if repo.rerereHas(id, "postimage") {
+		t.Error("it should remove the forgotten resolution")
+	}
+	mergeRR, _ = ioutil.ReadFile(filepath.Join(repo.Path(), GitMergeRRFile))
+	if string(mergeRR) != id+"\tfile.txt\x00" {
+		t.Errorf("it should record the conflict again: %q", mergeRR)
+	}
+	if err = repo.RerereForget("file.txt"); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should return ErrNotFound without a resolution:", err)
+	}
+
+	err = repo.RerereClear()
+	if err != nil || repo.rerereHas(id, "preimage") {
+		t.Error("it should remove the unresolved conflicts:", err)
+	}
+	if _, err := os.Stat(filepath.Join(repo.Path(), GitMergeRRFile)); !os.IsNotExist(err) {
+		t.Error("it should remove MERGE_RR")
+	}
+}
+
+func Test_RerereGC(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_rerere")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	repo.EnableRerere(true)
+
+	resolvedId := "0000000000000000000000000000000000000001"
+	unresolvedId := "0000000000000000000000000000000000000002"
+	repo.writeRerereFile(resolvedId, "preimage", []byte("pre\n"))
+	repo.writeRerereFile(resolvedId, "postimage", []byte("post\n"))
+	repo.writeRerereFile(unresolvedId, "preimage", []byte("pre\n"))
+
+	now := time.Now()
+	repo.SetClock(func() time.Time { return now.Add(20 * 24 * time.Hour) })
+	err := repo.RerereGC()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if !repo.rerereHas(resolvedId, "postimage") {
+		t.Error("it should keep the resolution that is used recently")
+	}
+	if repo.rerereHas(unresolvedId, "preimage") {
+		t.Error("it should remove the old unresolved conflict")
+	}
+
+	repo.Config().SetInt32("gc.rerereResolved", 10)
+	repo.RerereGC()
+	if _, err := os.Stat(filepath.Join(repo.Path(), GitRerereDir, resolvedId)); !os.IsNotExist(err) {
+		t.Error("it should remove the resolution that is older than gc.rerereResolved")
+	}
+}
@@ -0,0 +1,193 @@
+package git4go
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+const (
+	DefaultRetryInitialBackoff = time.Second
+	DefaultRetryMaxBackoff     = 30 * time.Second
+)
+
+// TransientError marks an error that may not happen again, like an HTTP
+// 502, 503 or 429 response, so that the request is retried. Transports
+// and download callbacks return it.
+type TransientError struct {
+	Err error
+	// how long the server asked to wait (Retry-After), or 0 for the
+	// backoff of the policy
+	RetryAfter time.Duration
+}
+
+func (e *TransientError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// RetryPolicy retries the requests that failed with transient errors, with
+// a backoff that doubles after each attempt.
+type RetryPolicy struct {
+	// how many times a request is retried; 0 disables retries
+	MaxRetries int
+	// DefaultRetryInitialBackoff and DefaultRetryMaxBackoff if they are 0
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Retryable tells if the error is transient. If it is nil, a
+	// TransientError, an unexpected EOF and network errors that are
+	// temporary or timeouts are.
+	Retryable func(err error) bool
+}
+
+// Do calls the operation until it succeeds, it fails with an error that
+// is not transient or the retries are used up. The last error is
+// returned. A nil policy does not retry.
+func (p *RetryPolicy) Do(operation func() error) error {
+	backoff := p.initialBackoff()
+	for attempt := 0; ; attempt++ {
+		err := operation()
+		if err == nil || p == nil || attempt >= p.MaxRetries || !p.retryable(err) {
+			return err
+		}
+		wait := backoff
+		var transient *TransientError
+		if errors.As(err, &transient) && transient.RetryAfter > 0 {
+			wait = transient.RetryAfter
+		}
+		time.Sleep(wait)
+		if backoff *= 2; backoff > p.maxBackoff() {
+			backoff = p.maxBackoff()
+		}
+	}
+}
+
+// RangeDownloadCallback downloads the uri from the offset, e.g. with an
+// HTTP Range request. resumed is false if the server sent the whole file
+// instead, because it does not support ranges.
+type RangeDownloadCallback func(uri string, offset int64) (body io.ReadCloser, resumed bool, err error)
+
+// ResumableDownload returns a download callback for FetchBundles and
+// DownloadPackfileUris that retries with the policy. If the connection
+// breaks in the middle of a pack or a bundle, the download continues
+// where it stopped instead of starting over; if the server does not
+// support ranges, the bytes that were read already are skipped.
+func ResumableDownload(download RangeDownloadCallback, policy *RetryPolicy) UriDownloadCallback {
+	return func(uri string) (io.ReadCloser, error) {
+		reader := &resumableReader{uri: uri, download: download, policy: policy}
+		if err := policy.Do(reader.open); err != nil {
+			return nil, err
+		}
+		return reader, nil
+	}
+}
+
+// internal functions and methods
+
+func (p *RetryPolicy) initialBackoff() time.Duration {
+	if p == nil || p.InitialBackoff <= 0 {
+		return DefaultRetryInitialBackoff
+	}
+	return p.InitialBackoff
+}
+
+func (p *RetryPolicy) maxBackoff() time.Duration {
+	if p.MaxBackoff <= 0 {
+		return DefaultRetryMaxBackoff
+	}
+	return p.MaxBackoff
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	var transient *TransientError
+	if errors.As(err, &transient) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr interface {
+		Timeout() bool
+		Temporary() bool
+	}
+	return errors.As(err, &netErr) && (netErr.Timeout() || netErr.Temporary())
+}
+
+// resumableReader reads a download and opens it again at the offset where
+// it broke.
+type resumableReader struct {
+	uri      string
+	download RangeDownloadCallback
+	policy   *RetryPolicy
+	body     io.ReadCloser
+	offset   int64
+	// the error that broke the download after the data that was returned
+	err error
+	// the breaks since data was read last
+	failures int
+}
+
+func (r *resumableReader) open() error {
+	body, resumed, err := r.download(r.uri, r.offset)
+	if err != nil {
+		return err
+	}
+	if !resumed && r.offset > 0 {
+		if _, err = io.CopyN(ioutil.Discard, body, r.offset); err != nil {
+			body.Close()
+			return err
+		}
+	}
+	r.body = body
+	return nil
+}
+
+func (r *resumableReader) Read(buffer []byte) (int, error) {
+	for {
+		if r.err != nil {
+			err := r.err
+			r.err = nil
+			if !r.retry(err) {
+				return 0, err
+			}
+		}
+		if r.body == nil {
+			if err := r.policy.Do(r.open); err != nil {
+				return 0, err
+			}
+		}
+		n, err := r.body.Read(buffer)
+		r.offset += int64(n)
+		if n > 0 {
+			r.failures = 0
+		}
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		r.body.Close()
+		r.body = nil
+		r.err = err
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// retry tells if the download should be opened again after the error.
+func (r *resumableReader) retry(err error) bool {
+	r.failures++
+	return r.policy != nil && r.failures <= r.policy.MaxRetries && r.policy.retryable(err)
+}
+
+func (r *resumableReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
@@ -0,0 +1,109 @@
+package git4go
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// brokenReader returns io.ErrUnexpectedEOF after limit bytes.
+type brokenReader struct {
+	reader io.Reader
+	limit  int
+}
+
+func (r *brokenReader) Read(buffer []byte) (int, error) {
+	if r.limit <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if len(buffer) > r.limit {
+		buffer = buffer[:r.limit]
+	}
+	n, err := r.reader.Read(buffer)
+	r.limit -= n
+	return n, err
+}
+
+func (r *brokenReader) Close() error {
+	return nil
+}
+
+func Test_RetryPolicy(t *testing.T) {
+	policy := &RetryPolicy{MaxRetries: 2, InitialBackoff: time.Millisecond}
+	calls := 0
+	err := policy.Do(func() error {
+		calls++
+		if calls < 3 {
+			return &TransientError{Err: errors.New("503 Service Unavailable")}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Error("it should retry transient errors:", calls, err)
+	}
+	calls = 0
+	err = policy.Do(func() error {
+		calls++
+		return &TransientError{Err: errors.New("503 Service Unavailable"), RetryAfter: time.Millisecond}
+	})
+	if err == nil || calls != 3 {
+		t.Error("it should give up after the retries:", calls, err)
+	}
+	calls = 0
+	policy.Do(func() error {
+		calls++
+		return errors.New("401 Unauthorized")
+	})
+	if calls != 1 {
+		t.Error("it should not retry other errors:", calls)
+	}
+	calls = 0
+	(*RetryPolicy)(nil).Do(func() error {
+		calls++
+		return io.ErrUnexpectedEOF
+	})
+	if calls != 1 {
+		t.Error("it should not retry without a policy:", calls)
+	}
+}
+
+func Test_ResumableDownload(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	for _, ranges := range []bool{true, false} {
+		var offsets []int64
+		download := ResumableDownload(func(uri string, offset int64) (io.ReadCloser, bool, error) {
+			offsets = append(offsets, offset)
+			if len(offsets) == 2 {
+				return nil, false, &TransientError{Err: errors.New("502 Bad Gateway")}
+			}
+			limit := 300
+			if !ranges {
+				limit += int(offset)
+				offset = 0
+			}
+			return &brokenReader{reader: bytes.NewReader(data[offset:]), limit: limit}, ranges, nil
+		}, &RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond})
+		body, err := download("https://cdn.example.com/pack")
+		if err != nil {
+			t.Fatal("err should be nil:", err)
+		}
+		result, err := ioutil.ReadAll(body)
+		if err != nil || !bytes.Equal(result, data) {
+			t.Error("it should resume the download:", ranges, len(result), err)
+		}
+		if len(offsets) < 4 || offsets[0] != 0 || offsets[1] != 300 || offsets[2] != 300 {
+			t.Error("it should continue where the download broke:", ranges, offsets)
+		}
+	}
+
+	download := ResumableDownload(func(uri string, offset int64) (io.ReadCloser, bool, error) {
+		return &brokenReader{reader: bytes.NewReader(data[offset:]), limit: 10 - int(offset)}, true, nil
+	}, &RetryPolicy{MaxRetries: 1, InitialBackoff: time.Millisecond})
+	body, _ := download("https://cdn.example.com/pack")
+	if _, err := ioutil.ReadAll(body); err != io.ErrUnexpectedEOF {
+		t.Error("it should give up when the download breaks without progress:", err)
+	}
+}
@@ -1,12 +1,11 @@
 package git4go
 
-/*
 import (
 	"errors"
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
-	"fmt"
 )
 
 type RevparseFlag int
@@ -41,7 +40,175 @@ func (rs *Revspec) IsSingle() bool {
 }
 
 func (rs *Revspec) IsMergeBase() bool {
-	return rs.flags == RevparseMergeBase
+	return rs.flags&RevparseMergeBase != 0
+}
+
+// RevparseExt finds the object of the revision, like "HEAD~2", "v1.0^{tree}",
+// ":/fix bug" (the newest commit whose message matches) or "master:README"
+// (the blob or tree at the path). ":path" and ":<n>:path" address the
+// entries of the index at the stage n. The reference is returned too when
+// the spec is the name of a reference.
+func (r *Repository) RevparseExt(spec string) (Object, *Reference, error) {
+	pos := 0
+	identifierLength := 0
+	shouldReturnReference := true
+	var reference *Reference
+	var baseRev Object
+	var err error
+
+	for pos < len(spec) {
+		switch spec[pos] {
+		case '^':
+			shouldReturnReference = false
+			baseRev, reference, err = ensureBaseRevLoaded(baseRev, reference, spec, identifierLength, r, false)
+			if err != nil {
+				return nil, nil, err
+			}
+			if pos+1 < len(spec) && spec[pos+1] == '{' {
+				var buf string
+				buf, pos, err = extractCurlyBracesContent(spec, pos)
+				if err != nil {
+					return nil, nil, err
+				}
+				baseRev, err = handleCaretCurlySyntax(baseRev, buf)
+			} else {
+				var n int
+				n, pos, err = extractHowMany(spec, pos)
+				if err != nil {
+					return nil, nil, err
+				}
+				baseRev, err = handleCaretParentSyntax(baseRev, n)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+		case '~':
+			var n int
+			shouldReturnReference = false
+			n, pos, err = extractHowMany(spec, pos)
+			if err != nil {
+				return nil, nil, err
+			}
+			baseRev, reference, err = ensureBaseRevLoaded(baseRev, reference, spec, identifierLength, r, false)
+			if err != nil {
+				return nil, nil, err
+			}
+			baseRev, err = handleLinearSyntax(baseRev, n)
+			if err != nil {
+				return nil, nil, err
+			}
+		case ':':
+			shouldReturnReference = false
+			var buf string
+			buf, pos = extractPath(spec, pos)
+			if anyLeftHandIdentifier(baseRev, reference, identifierLength) {
+				baseRev, reference, err = ensureBaseRevLoaded(baseRev, reference, spec, identifierLength, r, true)
+				if err != nil {
+					return nil, nil, err
+				}
+				baseRev, err = handleColonSyntax(baseRev, buf)
+			} else if strings.HasPrefix(buf, "/") {
+				baseRev, err = handleGrepSyntax(r, nil, buf[1:])
+			} else {
+				baseRev, err = handleIndexSyntax(r, buf)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+		case '@':
+			if pos+1 < len(spec) && spec[pos+1] == '{' {
+				var buf string
+				buf, pos, err = extractCurlyBracesContent(spec, pos)
+				if err != nil {
+					return nil, nil, err
+				}
+				err = ensureBaseRevIsNotKnownYet(baseRev)
+				if err != nil {
+					return nil, nil, err
+				}
+				baseRev, reference, err = handleAtSyntax(r, spec[:identifierLength], buf)
+				if err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			// "@" alone is HEAD, and it is a part of the name otherwise
+			fallthrough
+		default:
+			err = ensureLeftHandIdentifierIsNotKnownYet(baseRev, reference)
+			if err != nil {
+				return nil, nil, err
+			}
+			pos++
+			identifierLength++
+		}
+	}
+	baseRev, reference, err = ensureBaseRevLoaded(baseRev, reference, spec, identifierLength, r, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !shouldReturnReference {
+		reference = nil
+	}
+	return baseRev, reference, nil
+}
+
+func (r *Repository) RevparseSingle(spec string) (Object, error) {
+	obj, _, err := r.RevparseExt(spec)
+	return obj, err
+}
+
+// Revparse parses the single revision or the range, like "a..b" or
+// "a...b".
+func (r *Repository) Revparse(spec string) (*Revspec, error) {
+	revspec := &Revspec{}
+	var err error
+
+	// the dots in the path of "rev:path" or in the pattern of ":/text" are
+	// not a range
+	dotdotPos := -1
+	if !strings.HasPrefix(spec, ":") {
+		dotdotPos = strings.Index(spec, "..")
+		if colon := strings.IndexByte(spec, ':'); colon != -1 && colon < dotdotPos {
+			dotdotPos = -1
+		}
+	}
+	if dotdotPos != -1 {
+		leftStr := spec[:dotdotPos]
+		rightStr := spec[dotdotPos+2:]
+		revspec.flags = RevparseRange
+		if strings.HasPrefix(rightStr, ".") {
+			revspec.flags |= RevparseMergeBase
+			rightStr = rightStr[1:]
+		}
+		if leftStr == "" {
+			leftStr = GitHeadFile
+		}
+		if rightStr == "" {
+			rightStr = GitHeadFile
+		}
+		revspec.from, err = r.RevparseSingle(leftStr)
+		if err != nil {
+			return nil, err
+		}
+		revspec.to, err = r.RevparseSingle(rightStr)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		revspec.flags = RevparseSingle
+		revspec.from, err = r.RevparseSingle(spec)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return revspec, nil
+}
+
+// internal functions
+
+func invalidSpecError(spec string) error {
+	return MakeGitError(fmt.Sprintf("invalid revision spec '%s'", spec), ErrInvalidSpec)
 }
 
 func objectFromReference(reference *Reference) (Object, error) {
@@ -71,23 +238,20 @@ func maybeAbbrev(repo *Repository, spec string) (Object, error) {
 	return repo.LookupPrefix(oid, len(spec))
 }
 
-var describeRegexp *regexp.Regexp
+var describeRegexp = regexp.MustCompile("^.+-[0-9]+-g[0-9a-fA-F]+$")
 
 func maybeDescribe(repo *Repository, spec string) (Object, error) {
-	if describeRegexp == nil {
-		describeRegexp = regexp.MustCompilePOSIX(".+-[0-9]+-g[0-9a-fA-F]+")
-	}
-	index := strings.Index(spec, "-g")
-	if index == -1 {
-		return nil, errors.New("not found")
-	}
-	if !describeRegexp.MatchString(spec) {
+	index := strings.LastIndex(spec, "-g")
+	if index == -1 || !describeRegexp.MatchString(spec) {
 		return nil, errors.New("not found")
 	}
 	return maybeAbbrev(repo, spec[index+2:])
 }
 
 func revParseLookupObject(repo *Repository, spec string) (Object, *Reference, error) {
+	if spec == "@" {
+		spec = GitHeadFile
+	}
 	object, err := maybeSha(repo, spec)
 	if err == nil {
 		return object, nil, nil
@@ -106,7 +270,10 @@ func revParseLookupObject(repo *Repository, spec string) (Object, *Reference, er
 		}
 	}
 	object, err = maybeDescribe(repo, spec)
-	return object, nil, err
+	if err != nil {
+		return nil, nil, MakeGitError(fmt.Sprintf("revision spec '%s' not found", spec), ErrNotFound)
+	}
+	return object, nil, nil
 }
 
 func ensureBaseRevLoaded(object Object, reference *Reference, spec string, identifierLength int, repo *Repository, allowEmptyIdentifier bool) (Object, *Reference, error) {
@@ -121,7 +288,11 @@ func ensureBaseRevLoaded(object Object, reference *Reference, spec string, ident
 		return object, reference, nil
 	}
 	if !allowEmptyIdentifier && identifierLength == 0 {
-		return nil, nil, errors.New("Invalid Spec")
+		return nil, nil, invalidSpecError(spec)
+	}
+	if identifierLength == 0 {
+		identifierLength = len(GitHeadFile)
+		spec = GitHeadFile
 	}
 	return revParseLookupObject(repo, spec[:identifierLength])
 }
@@ -133,7 +304,7 @@ func extractCurlyBracesContent(spec string, pos int) (string, int, error) {
 	}
 	pos++
 	if pos >= len(spec) || spec[pos] != '{' {
-		return "", 0, errors.New("invalid spec: extractCurlyBracesContent()")
+		return "", 0, invalidSpecError(spec)
 	}
 	pos++
 	endPos := pos
@@ -144,26 +315,32 @@ func extractCurlyBracesContent(spec string, pos int) (string, int, error) {
 		}
 		endPos++
 	}
-	return "", 0, errors.New("invalid spec: extractCurlyBracesContent()")
+	return "", 0, invalidSpecError(spec)
 }
 
-func deferenceToNonTag(object Object) (Object, error) {
-	if object.Type() == ObjectTag {
-		return object.Peel(ObjectAny)
+func dereferenceToNonTag(object Object) (Object, error) {
+	for object.Type() == ObjectTag {
+		object = object.(*Tag).Target()
+		if object == nil {
+			return nil, errors.New("the target of the tag is missing")
+		}
 	}
 	return object, nil
 }
 
 func handleCaretCurlySyntax(object Object, curlyBracesContent string) (Object, error) {
 	if len(curlyBracesContent) == 0 {
-		return deferenceToNonTag(object)
+		return dereferenceToNonTag(object)
 	}
 	if curlyBracesContent[0] == '/' {
 		return handleGrepSyntax(object.Owner(), object.Id(), curlyBracesContent[1:])
 	}
 	expectedType := TypeString2Type(curlyBracesContent)
 	if expectedType == ObjectBad {
-		return nil, errors.New("Invalid Spec: handleCaretCurlySyntax()")
+		if curlyBracesContent != "object" {
+			return nil, invalidSpecError("^{" + curlyBracesContent + "}")
+		}
+		expectedType = object.Type()
 	}
 	return object.Peel(expectedType)
 }
@@ -177,33 +354,38 @@ func handleCaretParentSyntax(baseRev Object, n int) (Object, error) {
 		return peeled, nil
 	}
 	commit := peeled.(*Commit)
-	return commit.Parent(n - 1), nil
+	var parent *Commit
+	if n <= commit.ParentCount() {
+		parent = commit.Parent(n - 1)
+	}
+	if parent == nil {
+		return nil, MakeGitError(fmt.Sprintf("commit %s has no parent %d", commit.Id().String(), n), ErrNotFound)
+	}
+	return parent, nil
 }
 
 func isDigit(c byte) bool {
-	return 0 <= c && c <= 9
+	return '0' <= c && c <= '9'
 }
 
+// strtol parses the digits from start and returns the number and the
+// position after them.
 func strtol(str string, start, base int) (int, int, error) {
 	end := start
-	for end < len(str) {
-		if !isDigit(str[end]) {
-			result, err := strconv.ParseInt(str[start:end], base, 64)
-			if err != nil {
-				return 0, 0, err
-			}
-			return int(result), end, nil
-		}
+	for end < len(str) && isDigit(str[end]) {
 		end++
 	}
-	return 0, 0, errors.New("invalid format: strtol()")
+	result, err := strconv.ParseInt(str[start:end], base, 32)
+	if err != nil {
+		return 0, 0, invalidSpecError(str)
+	}
+	return int(result), end, nil
 }
 
+// extractHowMany parses "^", "^2", "~", "~~" and "~3" at pos.
 func extractHowMany(spec string, pos int) (int, int, error) {
-	parsed := 0
 	accumulated := 0
 	kind := spec[pos]
-	specLength := len(spec)
 	if kind != '^' && kind != '~' {
 		return 0, 0, errors.New("assertion error: extractHowMany()")
 	}
@@ -211,12 +393,12 @@ func extractHowMany(spec string, pos int) (int, int, error) {
 		for {
 			pos++
 			accumulated++
-			fmt.Println("@@@", pos, spec, specLength)
-			if pos >= specLength-1 || spec[pos] != kind || kind != '~' {
+			if pos >= len(spec) || spec[pos] != kind || kind != '~' {
 				break
 			}
 		}
-		if pos < specLength && isDigit(spec[pos]) {
+		if pos < len(spec) && isDigit(spec[pos]) {
+			var parsed int
 			var err error
 			parsed, pos, err = strtol(spec, pos, 10)
 			if err != nil {
@@ -224,72 +406,78 @@ func extractHowMany(spec string, pos int) (int, int, error) {
 			}
 			accumulated += parsed - 1
 		}
-		if pos >= specLength-1 || spec[pos] != kind || kind != '~' {
+		if pos >= len(spec) || spec[pos] != kind || kind != '~' {
 			break
 		}
 	}
 	return accumulated, pos, nil
 }
 
-func handlingLinearSyntax(obj Object, n int) (*Commit, error) {
+func handleLinearSyntax(obj Object, n int) (Object, error) {
 	peeled, err := obj.Peel(ObjectCommit)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	commit := peeled.(*Commit)
-	return commit.NthGenAncestor(n)
+	return commit.NthGenAncestor(uint(n))
 }
 
-func extractPath(spec string, pos int) (string, int, error) {
-	if spec[pos] != ':' {
-		return "", 0, errors.New("assertion error: extractPath()")
-	}
-	return spec[pos+1:], len(spec), nil
+func extractPath(spec string, pos int) (string, int) {
+	return spec[pos+1:], len(spec)
 }
 
-func handleAtSyntax(spec string, identifierLength int, repo *Repository, curlyBasedContent string) (Object, *Reference, error) {
-	parsed, err := strconv.ParseInt(curlyBasedContent, 10, 64)
-	isNumeric := (err == nil)
-	if curlyBasedContent[0] == '-' && (!isNumeric || parsed == 0) {
-		return nil, nil, errors.New("invalid spec")
-	}
-	if isNumeric {
-		if parsed < 0 {
-			return retrievePreviouslyCheckedOutBranchOrRevision(repo, spec, -int(parsed))
-		} else {
-			return retrieveRevObjectFromRefLog(repo, spec, int(parsed))
+// handleAtSyntax resolves "<branch>@{...}". Only the upstream ("@{u}",
+// "@{upstream}") is supported, the other forms need the reflog.
+func handleAtSyntax(repo *Repository, identifier, curlyBracesContent string) (Object, *Reference, error) {
+	switch strings.ToLower(curlyBracesContent) {
+	case "u", "upstream":
+		ref, err := retrieveRemoteTrackingReference(repo, identifier)
+		if err != nil {
+			return nil, nil, err
 		}
+		object, err := objectFromReference(ref)
+		return object, ref, err
 	}
-
-	if curlyBasedContent == "u" || curlyBasedContent == "upstream" {
-		ref, err := retrieveRemoteTrackingReference(repo, spec)
-		return nil, ref, err
-	}
-	// todo: parse time
-	// return retrieveRevObjectFromRefLog(repo, spec, timestamp)
-	return nil, nil, errors.New("not implemented")
-}
-
-func retrievePreviouslyCheckedOutBranchOrRevision(repo *Repository, identifier string, parsed int) (Object, *Reference, error) {
-
-}
-
-func retrieveRevObjectFromRefLog(repo *Repository, identifier string, parsed int) (Object, *Reference, error) {
-
+	return nil, nil, MakeGitError(fmt.Sprintf("'%s@{%s}' needs the reflog, which is not supported", identifier, curlyBracesContent), ErrInvalidSpec)
 }
 
 func retrieveRemoteTrackingReference(repo *Repository, identifier string) (*Reference, error) {
-
+	var refname string
+	if identifier == "" || identifier == "@" || identifier == GitHeadFile {
+		head, err := repo.LookupReference(GitHeadFile)
+		if err != nil {
+			return nil, err
+		}
+		if head.Type() != ReferenceSymbolic {
+			return nil, MakeGitError("HEAD does not point to a branch", ErrNotFound)
+		}
+		refname = head.SymbolicTarget()
+	} else {
+		ref, err := repo.LookupReference(GitRefsHeadsDir + identifier)
+		if err != nil {
+			return nil, err
+		}
+		refname = ref.Name()
+	}
+	upstream, err := repo.BranchUpstreamName(refname)
+	if err != nil {
+		return nil, err
+	}
+	return repo.LookupReference(upstream)
 }
 
 func ensureLeftHandIdentifierIsNotKnownYet(baseRev Object, reference *Reference) error {
+	if baseRev != nil || reference != nil {
+		return MakeGitError("invalid revision spec: the revision is already known", ErrInvalidSpec)
+	}
+	return nil
 }
 
 func ensureBaseRevIsNotKnownYet(object Object) error {
 	if object == nil {
 		return nil
 	}
-	return errors.New("invalid spec: ensureBaseRevIsNotKnownYet()")
+	return MakeGitError("invalid revision spec: the revision is already known", ErrInvalidSpec)
 }
 
 func anyLeftHandIdentifier(object Object, reference *Reference, identifierLength int) bool {
@@ -302,11 +490,14 @@ func anyLeftHandIdentifier(object Object, reference *Reference, identifierLength
 	return identifierLength > 0
 }
 
+// handleColonSyntax finds the object at the path in the tree of the
+// object. An empty path is the tree itself.
 func handleColonSyntax(object Object, path string) (Object, error) {
 	peeled, err := object.Peel(ObjectTree)
 	if err != nil {
 		return nil, err
 	}
+	path = strings.TrimPrefix(path, "./")
 	if path == "" {
 		return peeled, err
 	}
@@ -318,10 +509,49 @@ func handleColonSyntax(object Object, path string) (Object, error) {
 	return tree.repo.Lookup(entry.Id)
 }
 
+// handleIndexSyntax finds the blob of ":path" (stage 0) or ":<n>:path" in
+// the index.
+func handleIndexSyntax(repo *Repository, path string) (Object, error) {
+	stage := IndexStage(0)
+	if len(path) >= 2 && path[1] == ':' && '0' <= path[0] && path[0] <= '3' {
+		stage = IndexStage(path[0] - '0')
+		path = path[2:]
+	}
+	path = strings.TrimPrefix(path, "./")
+	if path == "" {
+		return nil, invalidSpecError(":" + path)
+	}
+	index, err := repo.Index()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := index.EntryByPath(path, stage)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Lookup(entry.Id)
+}
+
+// handleGrepSyntax finds the newest commit whose message matches the
+// pattern. It starts from the commit of specOid, or from all references
+// if it is nil. Like git, "!-" negates the pattern and "!!" is a literal
+// "!".
 func handleGrepSyntax(repo *Repository, specOid *Oid, pattern string) (Object, error) {
+	negate := false
+	if strings.HasPrefix(pattern, "!") {
+		switch {
+		case strings.HasPrefix(pattern, "!-"):
+			negate = true
+			pattern = pattern[2:]
+		case strings.HasPrefix(pattern, "!!"):
+			pattern = pattern[1:]
+		default:
+			return nil, invalidSpecError(":/" + pattern)
+		}
+	}
 	rx, err := regexp.Compile(pattern)
 	if err != nil {
-		return nil, err
+		return nil, MakeGitError(fmt.Sprintf("invalid pattern '%s': %s", pattern, err.Error()), ErrInvalidSpec)
 	}
 	walk, err := repo.Walk()
 	if err != nil {
@@ -329,15 +559,20 @@ func handleGrepSyntax(repo *Repository, specOid *Oid, pattern string) (Object, e
 	}
 	walk.Sorting(SortTime)
 	if specOid == nil {
-		err = walk.PushRef("refs/*")
+		err = walk.PushGlob(GitRefsDir)
+		if err == nil {
+			// HEAD may be detached
+			walk.PushHead()
+		}
 	} else {
 		err = walk.Push(specOid)
 	}
 	if err != nil {
 		return nil, err
 	}
-	oid := new(Oid)
 	for {
+		// the looked up commit keeps the oid, so it is not reused
+		oid := new(Oid)
 		err = walk.Next(oid)
 		if err != nil {
 			break
@@ -346,169 +581,12 @@ func handleGrepSyntax(repo *Repository, specOid *Oid, pattern string) (Object, e
 		if err != nil {
 			return nil, err
 		}
-		if rx.MatchString(commit.Message()) {
+		if rx.MatchString(commit.Message()) != negate {
 			return commit, nil
 		}
 	}
 	if IsErrorCode(err, ErrIterOver) {
-		return nil, errors.New("not found")
+		return nil, MakeGitError(fmt.Sprintf("no commit message matches '%s'", pattern), ErrNotFound)
 	}
 	return nil, err
 }
-
-func (r *Repository) RevparseExt(spec string) (Object, *Reference, error) {
-	pos := 0
-	identifierLength := 0
-	shouldReturnReference := true
-	var reference *Reference
-	var baseRev Object
-	var err error
-
-	for pos < len(spec) {
-		switch spec[pos] {
-			case '^':
-			shouldReturnReference = false
-			baseRev, reference, err = ensureBaseRevLoaded(baseRev, reference, spec, identifierLength, r, false)
-			if err != nil {
-				return nil, nil, err
-			}
-			if pos+1<len(spec) && spec[pos+1] == '{' {
-				var buf string
-				buf, pos, err = extractCurlyBracesContent(spec, pos)
-				if err != nil {
-					return nil, nil, err
-				}
-				baseRev, err = handleCaretCurlySyntax(baseRev, buf)
-			} else {
-				var n int
-				n, pos, err = extractHowMany(spec, pos)
-				if err != nil {
-					return nil, nil, err
-				}
-				baseRev, err = handleCaretParentSyntax(baseRev, n)
-				if err != nil {
-					return nil, nil, err
-				}
-			}
-			case '~':
-			var n int
-			shouldReturnReference = false
-			n, pos, err = extractHowMany(spec, pos)
-			if err != nil {
-				return nil, nil, err
-			}
-			baseRev, reference, err = ensureBaseRevLoaded(baseRev, reference, spec, identifierLength, r, false)
-			if err != nil {
-				return nil, nil, err
-			}
-			err = handlingLinearSyntax(baseRev, n)
-			if err != nil {
-				return nil, nil, err
-			}
-			case ':':
-			shouldReturnReference = false
-			var buf string
-			buf, pos, err = extractPath(spec, pos)
-			if err != nil {
-				return nil, nil, err
-			}
-			if anyLeftHandIdentifier(baseRev, reference, identifierLength) {
-				baseRev, reference, err = ensureBaseRevLoaded(baseRev, reference, spec, identifierLength, r, true)
-				if err != nil {
-					return nil, nil, err
-				}
-				baseRev, err = handleColonSyntax(baseRev, buf)
-				if err != nil {
-					return nil, nil, err
-				}
-			} else {
-				if buf[0] == '/' {
-					baseRev, err = handleGrepSyntax(r, nil, buf[1:])
-					if err != nil {
-						return nil, nil, err
-					} else {
-
-					}
-				} else {
-					// todo: support merge-stage path lookup (":2:Makefile")
-					// and plain index blob lookup (:i-am/a/blob)
-					return nil, nil, errors.New("Unimplemented")
-				}
-
-			}
-			case '@':
-			if pos+1<len(spec) && spec[pos+1] == '{' {
-				var buf string
-				buf, pos, err = extractCurlyBracesContent(spec, pos)
-				if err != nil {
-					return nil, nil, err
-				}
-				err = ensureBaseRevIsNotKnownYet(baseRev)
-				if err != nil {
-					return nil, nil, err
-				}
-				baseRev, reference, err = handleAtSyntax(spec, identifierLength, r, buf)
-				if err != nil {
-					return nil, nil, err
-				}
-			} else {
-				// fall through
-			}
-			default:
-			err = ensureLeftHandIdentifierIsNotKnownYet(baseRev, reference)
-			if err != nil {
-				return nil, nil, err
-			}
-			pos++
-			identifierLength++
-		}
-	}
-	baseRev, reference, err = ensureBaseRevLoaded(baseRev, reference, spec, identifierLength, r, false)
-	if err != nil {
-		return nil, nil, err
-	}
-	if !shouldReturnReference {
-		reference = nil
-	}
-	return baseRev, reference, nil
-}
-
-func (r *Repository) RevparseSingle(spec string) (Object, error) {
-	obj, _, err := r.RevparseExt(spec)
-	return obj, err
-}
-
-func (r *Repository) Revparse(spec string) (*Revspec, error) {
-	dotdotPos := strings.Index(spec, "..")
-	revspec := &Revspec{}
-
-	var err error
-
-	if dotdotPos != -1 {
-		leftStr := spec[:dotdotPos]
-		var rightStr string
-		revspec.flags = RevparseRange
-		if spec[dotdotPos+2] == '.' {
-			rightStr = spec[dotdotPos+3:]
-		} else {
-			revspec.flags |= RevparseMergeBase
-			rightStr = spec[dotdotPos+2:]
-		}
-		revspec.from, err = r.RevparseSingle(leftStr)
-		if err != nil {
-			return nil, err
-		}
-		revspec.to, err = r.RevparseSingle(rightStr)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		revspec.flags = RevparseSingle
-		revspec.from, err = r.RevparseSingle(spec)
-		if err != nil {
-			return nil, err
-		}
-	}
-	return revspec, err
-}
-*/
@@ -1,6 +1,5 @@
 package git4go
 
-/*
 import (
 	"./testutil"
 	"testing"
@@ -8,6 +7,10 @@ import (
 
 func checkObjectAndRefInRepo(spec, expectedOid, expectedRefName string, repo *Repository, t *testing.T) {
 	obj, ref, err := repo.RevparseExt(spec)
+	if err != nil {
+		t.Error("err should be nil:", err)
+		return
+	}
 	oid, err := NewOid(expectedOid)
 	if err != nil {
 		t.Error("id was wrong:", expectedOid)
@@ -21,17 +24,19 @@ func checkObjectAndRefInRepo(spec, expectedOid, expectedRefName string, repo *Re
 
 func checkObjectInRepo(spec, expectedOid string, repo *Repository, t *testing.T) {
 	obj, _, err := repo.RevparseExt(spec)
-	if obj == nil {
+	if expectedOid == "" {
+		if err == nil {
+			t.Error("err should be error", spec)
+		}
+	} else if obj == nil {
 		t.Error("obj should not be nil:", err)
-	} else if expectedOid != "" {
+	} else {
 		oid, err := NewOid(expectedOid)
 		if err != nil {
 			t.Error("err should be nil:", err)
 		} else if !obj.Id().Equal(oid) {
 			t.Error("Ids are not equal:", expectedOid, obj.Id().String())
 		}
-	} else if err == nil {
-		t.Error("err should be error", spec)
 	}
 }
 
@@ -61,7 +66,7 @@ func checkIdInRepo(spec, expectedLeft, expectedRight string, flag RevparseFlag,
 
 func checkInvalidSingleSpec(invalidSpec string, repo *Repository, t *testing.T) {
 	_, err := repo.RevparseSingle(invalidSpec)
-	if err != nil {
+	if err == nil {
 		t.Error("Id should not be found: ", invalidSpec)
 	}
 }
@@ -183,4 +188,85 @@ func Test_Revparse_NotTag(t *testing.T) {
 	checkObjectInRepo("tags/e90810b^{}", "e90810b8df3e80c413d903f631643c716887138d", repo, t)
 	checkObjectInRepo("e908^{}", "e90810b8df3e80c413d903f631643c716887138d", repo, t)
 }
-*/
+
+func Test_Revparse_MessageSearch(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+
+	checkObjectInRepo(":/Test commit", "e90810b8df3e80c413d903f631643c716887138d", repo, t)
+	checkObjectInRepo(":/packed commit", "41bc8c69075bbdb46c5c6f0566cc8cc5b46e8bd9", repo, t)
+	checkObjectInRepo(":/^a (third|fourth)", "9fd738e8f7967c078dceed8190330fc8648ee56a", repo, t)
+	checkObjectInRepo(":/!-Notes", "258f0e2a959a364e40ed6603d5d44fbb24765b10", repo, t)
+	checkObjectInRepo("be3563a^{/third}", "4a202b346bb0fb0db7eff3cffeb3c70babbd2045", repo, t)
+	checkObjectInRepo(":/this does not match", "", repo, t)
+	checkObjectInRepo("c47800c^{/fourth}", "", repo, t)
+
+	_, err := repo.RevparseSingle(":/this does not match")
+	if !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should return ErrNotFound when no message matches:", err)
+	}
+	_, err = repo.RevparseSingle(":/!unknown")
+	if !IsErrorCode(err, ErrInvalidSpec) {
+		t.Error("it should return ErrInvalidSpec for an unknown modifier:", err)
+	}
+}
+
+func Test_Revparse_Path(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+
+	checkObjectInRepo("master:README", "a8233120f6ad708f843d861ce2b7228ec4e3dec6", repo, t)
+	checkObjectInRepo("HEAD:./README", "a8233120f6ad708f843d861ce2b7228ec4e3dec6", repo, t)
+	checkObjectInRepo("master:", "944c0f6e4dfa41595e6eb3ceecdb14f50fe18162", repo, t)
+	checkObjectInRepo("master~0:new.txt", "a71586c1dfe8a71c6cbf6c129f404c5642ff31bd", repo, t)
+	checkObjectInRepo("master:does-not-exist", "", repo, t)
+	checkObjectInRepo("point_to_blob:README", "", repo, t)
+
+	obj, err := repo.RevparseSingle("master:README")
+	if err == nil && obj.Type() != ObjectBlob {
+		t.Error("it should return the blob at the path")
+	}
+}
+
+func Test_Revparse_IndexPath(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo")
+
+	checkObjectInRepo(":CONVENTIONS", "575cdc563801dcbef0ff667322c8d00176771516", repo, t)
+	checkObjectInRepo(":0:.gitignore", "0c3aa34742a8470a4ab4acc60e061bbd9a85650b", repo, t)
+	checkObjectInRepo(":0:src/block-sha1/sha1.h", "558d6aece77743bc4be56a0a62ca9838131d217f", repo, t)
+	checkObjectInRepo(":1:CONVENTIONS", "", repo, t)
+	checkObjectInRepo(":does-not-exist", "", repo, t)
+}
+
+func Test_Revparse_Upstream(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+
+	checkObjectInRepo("master@{upstream}", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", repo, t)
+	checkObjectInRepo("master@{u}", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", repo, t)
+	checkObjectInRepo("@{u}", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", repo, t)
+	checkObjectInRepo("@{u}^", "9fd738e8f7967c078dceed8190330fc8648ee56a", repo, t)
+	checkObjectInRepo("@", "a65fedf39aefe402d3bb6e24df4d4f5fe4547750", repo, t)
+	checkObjectInRepo("br2@{u}", "", repo, t)
+	checkObjectInRepo("master@{1}", "", repo, t)
+}
+
+func Test_Revparse_Range(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+
+	checkIdInRepo("be3563a^1..be3563a", "9fd738e8f7967c078dceed8190330fc8648ee56a", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", RevparseRange, repo, t)
+	checkIdInRepo("be3563a^1...be3563a", "9fd738e8f7967c078dceed8190330fc8648ee56a", "be3563ae3f795b2b4353bcce3a527ad0a4f7f644", RevparseRange|RevparseMergeBase, repo, t)
+	checkIdInRepo(":/packed commit..", "41bc8c69075bbdb46c5c6f0566cc8cc5b46e8bd9", "", RevparseSingle, repo, t)
+}
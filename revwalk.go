@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type SortType uint
@@ -37,7 +38,10 @@ func (v *Repository) Walk() (*RevWalk, error) {
 
 type getNextFunc func(revWalk *RevWalk) (*commitListNode, error)
 type enqueueFunc func(revWalk *RevWalk, commit *commitListNode) error
-type hideCbFunc func(oid *Oid) bool
+
+// RevWalkHideCallback is called for the commits that the walk reaches.
+// Returning true hides the commit and its ancestors like RevWalk.Hide.
+type RevWalkHideCallback func(oid *Oid) bool
 
 type RevWalk struct {
 	repo             *Repository
@@ -47,17 +51,32 @@ type RevWalk struct {
 	randIterator     commitListNodes
 	reverseIterator  commitListNodes
 	timeIterator     commitListNodes
+	limitedIterator  commitListNodes
 	userInput        commitListNodes
 
 	getNext getNextFunc
 	enqueue enqueueFunc
-	//hideCb hideCbFunc
+	hideCb  RevWalkHideCallback
 
 	walking     bool
 	firstParent bool
 	didHide     bool
 	didPush     bool
 	sorting     SortType
+	since       uint64
+	until       uint64
+	paths       []string
+	// ancestryPath and boundary need all commits before the first one is
+	// returned
+	ancestryPath bool
+	boundary     bool
+	// the Bloom keys of the paths and the commit-graph that has the
+	// filters, found when the walk starts
+	pathKeys [][]bloomKey
+	graph    *CommitGraph
+	// the commit-graph whose generation numbers order paintDown, see
+	// Repository.generationGraph
+	generations *CommitGraph
 }
 
 func (v *RevWalk) Reset() {
@@ -67,11 +86,20 @@ func (v *RevWalk) Reset() {
 		commit.topologyDelay = false
 		commit.uninteresting = false
 		commit.flags = 0
+		commit.boundary = false
 	}
 	v.timeIterator = []*commitListNode{}
 	v.randIterator = []*commitListNode{}
 	v.reverseIterator = []*commitListNode{}
+	v.topologyIterator = []*commitListNode{}
+	v.limitedIterator = []*commitListNode{}
 	v.userInput = []*commitListNode{}
+	// the sorting steps of the last walk replaced it
+	if v.sorting&SortTime != 0 {
+		v.getNext = revWalkNextTimeSort
+	} else {
+		v.getNext = revWalkNextUnsorted
+	}
 	v.firstParent = false
 	v.walking = false
 	v.didPush = false
@@ -125,6 +153,23 @@ func (v *RevWalk) Next(id *Oid) error {
 		}
 	}
 	commit, err := v.getNext(v)
+	for err == nil {
+		if v.until != 0 && commit.time > v.until {
+			commit, err = v.getNext(v)
+			continue
+		}
+		if len(v.paths) > 0 {
+			changed, pathErr := v.changesPaths(commit)
+			if pathErr != nil {
+				return pathErr
+			}
+			if !changed {
+				commit, err = v.getNext(v)
+				continue
+			}
+		}
+		break
+	}
 	if IsErrorCode(err, ErrIterOver) {
 		v.Reset()
 		return err
@@ -139,8 +184,9 @@ func (v *RevWalk) Next(id *Oid) error {
 type RevWalkIterator func(commit *Commit) bool
 
 func (v *RevWalk) Iterate(fun RevWalkIterator) (err error) {
-	oid := new(Oid)
 	for {
+		// the commit keeps the oid, so it is not reused
+		oid := new(Oid)
 		err = v.Next(oid)
 		if IsErrorCode(err, ErrIterOver) {
 			return nil
@@ -177,6 +223,88 @@ func (v *RevWalk) Sorting(sm SortType) {
 	}
 }
 
+// HideCallback sets the callback that decides whether a commit is hidden.
+// nil removes the callback.
+func (v *RevWalk) HideCallback(callback RevWalkHideCallback) {
+	if v.walking {
+		v.Reset()
+	}
+	v.hideCb = callback
+}
+
+// Since stops the walk at the commits that were committed before the time,
+// like "git rev-list --since". Their parents are not read, so the walk
+// doesn't go through the whole history. The zero time removes the limit.
+func (v *RevWalk) Since(t time.Time) {
+	if v.walking {
+		v.Reset()
+	}
+	v.since = commitTimeLimit(t)
+}
+
+// Until skips the commits that were committed after the time, like
+// "git rev-list --until". The walk still goes through them to find older
+// commits. The zero time removes the limit.
+func (v *RevWalk) Until(t time.Time) {
+	if v.walking {
+		v.Reset()
+	}
+	v.until = commitTimeLimit(t)
+}
+
+// FilterPaths limits the walk to the commits that change one of the
+// paths, like "git log -- <path>". A path is a file or a directory
+// relative to the root of the tree; "" is the whole tree. A commit is
+// compared with its first parent, or with the empty tree if it has none,
+// and all parents are still walked. The changed-path Bloom filters of the
+// commit-graph skip the comparisons of most commits that don't change the
+// paths. No paths removes the limit.
+func (v *RevWalk) FilterPaths(paths ...string) {
+	if v.walking {
+		v.Reset()
+	}
+	v.paths = nil
+	for _, path := range paths {
+		v.paths = append(v.paths, strings.Trim(filepath.ToSlash(path), "/"))
+	}
+}
+
+// AncestryPath limits the walk to the commits that are descendants of a
+// hidden commit and ancestors of a pushed one, like "git log --ancestry-path
+// A..B": the commits between two tags along the path from the older one.
+// The other commits are hidden. Without hidden commits it has no effect.
+func (v *RevWalk) AncestryPath(enabled bool) {
+	if v.walking {
+		v.Reset()
+	}
+	v.ancestryPath = enabled
+}
+
+// Boundary adds the boundary commits after the other commits, like "git
+// rev-list --boundary": the hidden commits whose children are walked.
+// IsBoundary tells them apart. They are not filtered by Until and
+// FilterPaths.
+func (v *RevWalk) Boundary(enabled bool) {
+	if v.walking {
+		v.Reset()
+	}
+	v.boundary = enabled
+}
+
+// IsBoundary tells if the commit that Next returned is a boundary commit.
+// It is only known until the walk is over.
+func (v *RevWalk) IsBoundary(oid *Oid) bool {
+	commit, ok := v.commits[*oid]
+	return ok && commit.boundary
+}
+
+func commitTimeLimit(t time.Time) uint64 {
+	if t.IsZero() || t.Unix() < 0 {
+		return 0
+	}
+	return uint64(t.Unix())
+}
+
 func (v *RevWalk) premarkUninteresting() error {
 	var q commitListNodes
 	for _, commit := range v.userInput {
@@ -237,14 +365,54 @@ func (v *RevWalk) commitListParse(commit *commitListNode) error {
 	if commit.parsed {
 		return nil
 	}
-	obj, err := v.odb.Read(commit.oid)
-	if err != nil {
-		return err
+	err := v.commitListParseParents(commit)
+	if err == nil && v.repo.isShallowRoot(commit.oid) {
+		// parents of shallow roots were not fetched
+		commit.parents = nil
+	}
+	commit.generation = generationInfinity
+	if v.generations != nil {
+		if generation, ok := v.generations.generation(commit.oid); ok {
+			commit.generation = generation
+		}
+	}
+	return err
+}
+
+// commitListParseParents reads the parents and the time of the commit
+// from the commit cache of the repository. The commits that are not in it
+// are parsed and added, so later walks and lookups find them.
+func (v *RevWalk) commitListParseParents(commit *commitListNode) error {
+	cache := v.repo.commitCache
+	if cache == nil {
+		return v.odb.WithData(commit.oid, func(objType ObjectType, data []byte) error {
+			if objType != ObjectCommit {
+				return errors.New("Object is no commit object")
+			}
+			return v.commitQuickParse(commit, data)
+		})
+	}
+	cached := cache.Get(commit.oid)
+	if cached == nil {
+		err := v.odb.WithData(commit.oid, func(objType ObjectType, data []byte) error {
+			if objType != ObjectCommit {
+				return errors.New("Object is no commit object")
+			}
+			var err error
+			cached, err = newCommit(v.repo, commit.oid, data)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		cache.Add(cached)
 	}
-	if obj.Type != ObjectCommit {
-		return errors.New("Object is no commit object")
+	for _, parentId := range cached.Parents {
+		commit.parents = append(commit.parents, v.commitLookup(parentId))
 	}
-	return v.commitQuickParse(commit, obj.Data)
+	commit.time = uint64(cached.committer.When.Unix())
+	commit.parsed = true
+	return nil
 }
 
 func (v *RevWalk) commitQuickParse(commit *commitListNode, data []byte) error {
@@ -323,6 +491,13 @@ func (v *RevWalk) prepareWalk() error {
 			return err
 		}
 	}
+	v.preparePathFilter()
+	if v.ancestryPath || v.boundary {
+		err := v.limitWalk()
+		if err != nil {
+			return err
+		}
+	}
 	if (v.sorting & SortTopological) == SortTopological {
 		next, err := v.getNext(v)
 		for err == nil {
@@ -431,6 +606,15 @@ func revWalkNextTopologySort(walk *RevWalk) (*commitListNode, error) {
 	}
 }
 
+func revWalkNextLimited(walk *RevWalk) (*commitListNode, error) {
+	if len(walk.limitedIterator) == 0 {
+		return nil, MakeGitError("iteration over", ErrIterOver)
+	}
+	next := walk.limitedIterator[0]
+	walk.limitedIterator = walk.limitedIterator[1:]
+	return next, nil
+}
+
 func revWalkNextReverse(walk *RevWalk) (*commitListNode, error) {
 	length := len(walk.reverseIterator)
 	if length == 0 {
@@ -442,9 +626,9 @@ func revWalkNextReverse(walk *RevWalk) (*commitListNode, error) {
 }
 
 func (v *RevWalk) processCommit(commit *commitListNode, hide bool) error {
-	/*if !hide && v.hideCb != nil {
+	if !hide && v.hideCb != nil {
 		hide = v.hideCb(commit.oid)
-	}*/
+	}
 	if hide {
 		err := v.markUninteresting(commit)
 		if err != nil {
@@ -459,6 +643,9 @@ func (v *RevWalk) processCommit(commit *commitListNode, hide bool) error {
 	if err != nil {
 		return err
 	}
+	if !hide && v.since != 0 && commit.time < v.since {
+		return nil
+	}
 	if !hide {
 		v.enqueue(v, commit)
 	}
@@ -544,3 +731,208 @@ func (v *RevWalk) commitLookup(oid *Oid) *commitListNode {
 	}
 	return commit
 }
+
+// limitWalk walks all commits first to apply AncestryPath and find the
+// boundary commits. The walk continues with the list.
+func (v *RevWalk) limitWalk() error {
+	var commits commitListNodes
+	next, err := v.getNext(v)
+	for err == nil {
+		commits = append(commits, next)
+		next, err = v.getNext(v)
+	}
+	if !IsErrorCode(err, ErrIterOver) {
+		return err
+	}
+	if v.ancestryPath {
+		v.limitToAncestryPath(commits)
+	}
+	// commits may be hidden after they were walked
+	var limited, boundary commitListNodes
+	for _, commit := range commits {
+		if !commit.uninteresting {
+			limited = append(limited, commit)
+		}
+	}
+	if v.boundary {
+		for _, commit := range limited {
+			for _, parent := range v.walkedParents(commit) {
+				if parent.uninteresting && !parent.boundary {
+					parent.boundary = true
+					boundary = append(boundary, parent)
+				}
+			}
+		}
+	}
+	v.limitedIterator = append(limited, v.sortBoundary(boundary)...)
+	v.getNext = revWalkNextLimited
+	return nil
+}
+
+// sortBoundary sorts the boundary commits like git: the ones that are
+// found later come first, but children come before their parents.
+func (v *RevWalk) sortBoundary(boundary commitListNodes) commitListNodes {
+	inDegree := make(map[*commitListNode]int, len(boundary))
+	for _, commit := range boundary {
+		inDegree[commit] = 0
+	}
+	for _, commit := range boundary {
+		for _, parent := range v.walkedParents(commit) {
+			if _, ok := inDegree[parent]; ok {
+				inDegree[parent]++
+			}
+		}
+	}
+	var stack, sorted commitListNodes
+	for _, commit := range boundary {
+		if inDegree[commit] == 0 {
+			stack = append(stack, commit)
+		}
+	}
+	for len(stack) > 0 {
+		commit := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		sorted = append(sorted, commit)
+		for _, parent := range v.walkedParents(commit) {
+			if degree, ok := inDegree[parent]; ok {
+				inDegree[parent] = degree - 1
+				if degree == 1 {
+					stack = append(stack, parent)
+				}
+			}
+		}
+	}
+	return sorted
+}
+
+// limitToAncestryPath hides the walked commits that don't reach a hidden
+// commit that was pushed by Hide.
+func (v *RevWalk) limitToAncestryPath(commits commitListNodes) {
+	onPath := make(map[*commitListNode]bool)
+	for _, commit := range v.userInput {
+		if commit.uninteresting {
+			onPath[commit] = true
+		}
+	}
+	if len(onPath) == 0 {
+		return
+	}
+	walked := make(map[*commitListNode]bool, len(commits))
+	for _, commit := range commits {
+		walked[commit] = !commit.uninteresting
+	}
+	for _, commit := range commits {
+		stack := commitListNodes{commit}
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if _, ok := onPath[top]; ok {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			found, pending := false, false
+			for _, parent := range v.walkedParents(top) {
+				if value, ok := onPath[parent]; ok {
+					found = found || value
+				} else if walked[parent] {
+					stack = append(stack, parent)
+					pending = true
+				}
+			}
+			if pending {
+				continue
+			}
+			onPath[top] = found
+			stack = stack[:len(stack)-1]
+		}
+	}
+	for _, commit := range commits {
+		if !onPath[commit] {
+			commit.uninteresting = true
+		}
+	}
+}
+
+// walkedParents returns the parents that the walk follows.
+func (v *RevWalk) walkedParents(commit *commitListNode) commitListNodes {
+	if v.firstParent && len(commit.parents) > 0 {
+		return commit.parents[:1]
+	}
+	return commit.parents
+}
+
+// preparePathFilter finds the Bloom keys of the paths if the commit-graph
+// has changed-path filters. The root of the tree is in no filter, so the
+// filters are not used for it.
+func (v *RevWalk) preparePathFilter() {
+	v.pathKeys = nil
+	v.graph = nil
+	if len(v.paths) == 0 {
+		return
+	}
+	graph, err := v.repo.CommitGraph()
+	if err != nil || !graph.HasChangedPaths() {
+		return
+	}
+	var keys [][]bloomKey
+	for _, path := range v.paths {
+		if path == "" {
+			return
+		}
+		keys = append(keys, bloomKeysForPath(path, graph.bloom))
+	}
+	v.pathKeys = keys
+	v.graph = graph
+}
+
+// changesPaths tells if the commit changes one of the paths of
+// FilterPaths compared to its first parent.
+func (v *RevWalk) changesPaths(commit *commitListNode) (bool, error) {
+	if v.graph != nil {
+		if filter := v.graph.changedPathsFilter(commit.oid); filter != nil && !filter.mayChange(v.pathKeys) {
+			return false, nil
+		}
+	}
+	tree, err := v.repo.commitTree(commit.oid)
+	if err != nil {
+		return false, err
+	}
+	var parentTree *Tree
+	if len(commit.parents) > 0 {
+		parentTree, err = v.repo.commitTree(commit.parents[0].oid)
+		if err != nil {
+			return false, err
+		}
+	}
+	for _, path := range v.paths {
+		id, mode, err := treeEntryAtPath(tree, path)
+		if err != nil {
+			return false, err
+		}
+		parentId, parentMode, err := treeEntryAtPath(parentTree, path)
+		if err != nil {
+			return false, err
+		}
+		if mode != parentMode || (id == nil) != (parentId == nil) || id != nil && !id.Equal(parentId) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// treeEntryAtPath returns the id and the mode of the path in the tree, or
+// nil if the path or the tree doesn't exist. "" is the tree itself.
+func treeEntryAtPath(tree *Tree, path string) (*Oid, Filemode, error) {
+	if tree == nil {
+		return nil, 0, nil
+	}
+	if path == "" {
+		return tree.Id(), FilemodeTree, nil
+	}
+	entry, err := tree.EntryByPath(path)
+	if IsErrorCode(err, ErrNotFound) {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	return entry.Id, entry.Filemode, nil
+}
@@ -4,6 +4,7 @@ import (
 	"./testutil"
 	"io/ioutil"
 	"testing"
+	"time"
 )
 
 /*
@@ -422,3 +423,167 @@ func Test_RevWalk_MimicGitRevList(t *testing.T) {
 		t.Error("error code is wrong")
 	}
 }
+
+func benchmarkRevWalk(b *testing.B, useCache bool) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	if !useCache {
+		repo.commitCache = nil
+	}
+	oid := new(Oid)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		walk, _ := repo.Walk()
+		walk.PushGlob("heads")
+		for walk.Next(oid) == nil {
+			if useCache {
+				repo.LookupCommit(oid)
+			}
+		}
+	}
+}
+
+func Benchmark_RevWalk(b *testing.B) {
+	benchmarkRevWalk(b, false)
+}
+
+func Benchmark_RevWalk_CommitCache(b *testing.B) {
+	benchmarkRevWalk(b, true)
+}
+
+func Test_RevWalk_HideCallback(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	walk, _ := repo.Walk()
+	walk.HideCallback(func(oid *Oid) bool {
+		return oid.String() == commitIds[2]
+	})
+	oid, _ := NewOid(commitHead)
+	walk.Sorting(SortTime)
+	walk.Push(oid)
+	if !checkWalkOnly(walk, [][]int{{0, 3, 1, -1, -1, -1}}, t) {
+		t.Error("it should hide the commit and its parents")
+	}
+
+	walk.HideCallback(nil)
+	if !checkWalk(walk, oid, SortTime, commitSortingTime, t) {
+		t.Error("it should walk all commits without the callback")
+	}
+}
+
+func Test_RevWalk_SinceUntil(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	walk, _ := repo.Walk()
+	oid, _ := NewOid(commitHead)
+
+	// between "a third commit" and "another commit"
+	walk.Since(time.Unix(1274000000, 0))
+	if !checkWalk(walk, oid, SortTime, [][]int{{0, 3, 1, 2, -1, -1}}, t) {
+		t.Error("it should stop at old commits")
+	}
+	root, _ := NewOid(commitIds[4])
+	if commit, ok := walk.commits[*root]; ok && commit.parsed {
+		t.Error("it should not read the parents of old commits")
+	}
+
+	// between "a fourth commit" and "branch commit one"
+	walk.Until(time.Unix(1274800000, 0))
+	if !checkWalk(walk, oid, SortTime, [][]int{{1, 2, -1, -1, -1, -1}}, t) {
+		t.Error("it should skip new commits")
+	}
+
+	walk.Since(time.Time{})
+	walk.Until(time.Time{})
+	if !checkWalk(walk, oid, SortTime, commitSortingTime, t) {
+		t.Error("it should walk all commits without limits")
+	}
+}
+
+/*
+       D---E-------F
+      /     \       \
+     B---C---G---H---I---J
+    /                     \
+   A-------K---------------L--M
+*/
+
+// writeAncestryGraph writes the history of the example of "git log
+// --ancestry-path".
+func writeAncestryGraph(repo *Repository) map[string]*Commit {
+	builder, _ := repo.TreeBuilder()
+	treeId, _ := builder.Write()
+	tree, _ := repo.LookupTree(treeId)
+	commits := make(map[string]*Commit)
+	when := int64(1400000000)
+	commit := func(name string, parents ...string) {
+		when += 10
+		sig := &Signature{"A", "a@example.com", time.Unix(when, 0)}
+		var parentCommits []*Commit
+		for _, parent := range parents {
+			parentCommits = append(parentCommits, commits[parent])
+		}
+		oid, _ := repo.CreateCommit("", sig, sig, name+"\n", tree, parentCommits...)
+		commits[name], _ = repo.LookupCommit(oid)
+	}
+	commit("A")
+	commit("K", "A")
+	commit("B", "A")
+	commit("D", "B")
+	commit("E", "D")
+	commit("C", "B")
+	commit("G", "C", "E")
+	commit("H", "G")
+	commit("F", "E")
+	commit("I", "H", "F")
+	commit("J", "I")
+	commit("L", "K", "J")
+	commit("M", "L")
+	return commits
+}
+
+func Test_RevWalk_AncestryPathBoundary(t *testing.T) {
+	repo, _ := NewInMemoryRepository()
+	commits := writeAncestryGraph(repo)
+	names := make(map[Oid]string)
+	for name, commit := range commits {
+		names[*commit.Id()] = name
+	}
+	walkNames := func(ancestryPath, boundary bool) string {
+		walk, _ := repo.Walk()
+		walk.Sorting(SortTime)
+		walk.AncestryPath(ancestryPath)
+		walk.Boundary(boundary)
+		walk.Push(commits["M"].Id())
+		walk.Hide(commits["D"].Id())
+		result := ""
+		oid := new(Oid)
+		for walk.Next(oid) == nil {
+			if walk.IsBoundary(oid) {
+				result += "-"
+			}
+			result += names[*oid]
+		}
+		return result
+	}
+
+	// the results of git rev-list
+	if result := walkNames(true, false); result != "MLJIFHGE" {
+		t.Error("it should walk the ancestry path:", result)
+	}
+	if result := walkNames(true, true); result != "MLJIFHGE-D-C-K" {
+		t.Error("it should add the boundary of the ancestry path:", result)
+	}
+	if result := walkNames(false, true); result != "MLJIFHGCEK-D-B-A" {
+		t.Error("it should add the boundary commits:", result)
+	}
+	if result := walkNames(false, false); result != "MLJIFHGCEK" {
+		t.Error("it should walk all commits without the options:", result)
+	}
+}
@@ -0,0 +1,841 @@
+package git4go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	GitSequencerDir       = "sequencer"
+	GitCherryPickHeadFile = "CHERRY_PICK_HEAD"
+	GitRevertHeadFile     = "REVERT_HEAD"
+	GitMergeMsgFile       = "MERGE_MSG"
+
+	gitSequencerHeadFile        = "head"
+	gitSequencerTodoFile        = "todo"
+	gitSequencerAbortSafetyFile = "abort-safety"
+)
+
+type SequencerAction int
+
+const (
+	SequencerPick SequencerAction = iota
+	SequencerRevert
+)
+
+func (a SequencerAction) String() string {
+	switch a {
+	case SequencerPick:
+		return "pick"
+	case SequencerRevert:
+		return "revert"
+	}
+	return fmt.Sprintf("SequencerAction(%d)", int(a))
+}
+
+type SequencerItem struct {
+	Action SequencerAction
+	Id     *Oid
+}
+
+type SequencerOptions struct {
+	// The committer of the new commits. The default signature of the
+	// repository is used if it is nil.
+	Committer *Signature
+	// The options of the merges of the picked and reverted commits
+	MergeOptions *MergeOptions
+	// Commit the items that change nothing on HEAD as empty commits, like
+	// --keep-redundant-commits. Otherwise the sequencer stops at them.
+	KeepRedundantCommits bool
+}
+
+// Sequencer picks and reverts a list of commits onto HEAD one after
+// another, like "git cherry-pick A..B" and "git revert A..B". Its state is
+// kept in .git/sequencer in the same format as git, so a cherry-pick that
+// git has stopped can be continued here and the other way round.
+type Sequencer struct {
+	repo *Repository
+	opts *SequencerOptions
+	head *Oid
+	todo []*SequencerItem
+}
+
+// SequencerTodo returns the items to pick or to revert the commits of the
+// revision. A range like "A..B" gives its commits oldest first for picks
+// and newest first for reverts, so each commit is applied on top of the
+// ones it depends on.
+func (r *Repository) SequencerTodo(action SequencerAction, spec string) ([]*SequencerItem, error) {
+	revspec, err := r.Revparse(spec)
+	if err != nil {
+		return nil, err
+	}
+	if revspec.flags&RevparseMergeBase != 0 {
+		return nil, MakeGitError(fmt.Sprintf("cannot %s the symmetric difference '%s'", action, spec), ErrInvalidSpec)
+	}
+	if revspec.flags&RevparseRange == 0 {
+		commit, err := revspec.From().Peel(ObjectCommit)
+		if err != nil {
+			return nil, err
+		}
+		return []*SequencerItem{{Action: action, Id: commit.Id()}}, nil
+	}
+	walk, err := r.Walk()
+	if err != nil {
+		return nil, err
+	}
+	sorting := SortTopological | SortTime
+	if action == SequencerPick {
+		sorting |= SortReverse
+	}
+	walk.Sorting(sorting)
+	if err = walk.Push(revspec.To().Id()); err != nil {
+		return nil, err
+	}
+	if err = walk.Hide(revspec.From().Id()); err != nil {
+		return nil, err
+	}
+	var items []*SequencerItem
+	for {
+		// the walk keeps the oids of the commits that it has looked up
+		oid := new(Oid)
+		err = walk.Next(oid)
+		if IsErrorCode(err, ErrIterOver) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		items = append(items, &SequencerItem{Action: action, Id: oid})
+	}
+	if len(items) == 0 {
+		return nil, MakeGitError(fmt.Sprintf("empty commit set passed: '%s'", spec), ErrNotFound)
+	}
+	return items, nil
+}
+
+// StartSequencer records the items in .git/sequencer and applies them. It
+// fails with ErrExists if a cherry-pick or a revert is already in
+// progress. When a commit conflicts, the sequencer stops with
+// ErrMergeConflict and the conflicts are left in the index and the
+// working directory; it is continued with Continue after they are
+// resolved. Like git, it also stops with ErrApplied at a commit that
+// changes nothing on HEAD, which is dropped with Skip.
+func (r *Repository) StartSequencer(todo []*SequencerItem, opts *SequencerOptions) (*Sequencer, error) {
+	if r.readOnly {
+		return nil, errReadOnly("Repository.StartSequencer")
+	}
+	if r.IsBare() {
+		return nil, MakeGitError("cannot cherry-pick in a bare repository", ErrBareRepository)
+	}
+	if len(todo) == 0 {
+		return nil, MakeGitError("nothing to cherry-pick or revert", ErrInvalid)
+	}
+	if _, err := r.fs.Stat(r.sequencerPath("")); err == nil {
+		return nil, MakeGitError("a cherry-pick or revert is already in progress", ErrExists)
+	}
+	head, err := r.headCommit()
+	if err != nil {
+		return nil, err
+	}
+	s := &Sequencer{repo: r, opts: opts, head: head.Id(), todo: todo}
+	if s.opts == nil {
+		s.opts = &SequencerOptions{}
+	}
+	err = r.fs.MkdirAll(r.sequencerPath(""), os.FileMode(GitObjectDirMode))
+	if err != nil {
+		return nil, err
+	}
+	err = r.writeGitFile(r.sequencerPath(gitSequencerHeadFile), []byte(s.head.String()+"\n"))
+	if err != nil {
+		return nil, err
+	}
+	err = s.save(s.head)
+	if err != nil {
+		return nil, err
+	}
+	return s, s.run()
+}
+
+// OpenSequencer reads the state of the cherry-pick or the revert in
+// progress. It fails with ErrNotFound if there is none.
+func (r *Repository) OpenSequencer(opts *SequencerOptions) (*Sequencer, error) {
+	if opts == nil {
+		opts = &SequencerOptions{}
+	}
+	data, err := r.fs.ReadFile(r.sequencerPath(gitSequencerHeadFile))
+	if os.IsNotExist(err) {
+		return nil, MakeGitError("no cherry-pick or revert in progress", ErrNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+	head, err := NewOid(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("corrupt sequencer head: %s", err.Error()))
+	}
+	s := &Sequencer{repo: r, opts: opts, head: head}
+	data, err = r.fs.ReadFile(r.sequencerPath(gitSequencerTodoFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		item, err := r.parseSequencerItem(line)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid sequencer todo at line %d: %s", i+1, err.Error()))
+		}
+		s.todo = append(s.todo, item)
+	}
+	return s, nil
+}
+
+// Todo returns the items that are not applied yet. The first one is the
+// one that stopped the sequencer.
+func (s *Sequencer) Todo() []*SequencerItem {
+	return s.todo
+}
+
+// Continue commits the resolved conflicts of the stopped commit with the
+// message of MERGE_MSG, and applies the rest of the items. It fails with
+// ErrUnmerged if the index still has conflicts. If CHERRY_PICK_HEAD or
+// REVERT_HEAD is gone and HEAD has moved since the sequencer stopped, the
+// stopped commit was committed by hand and is not applied again; if HEAD
+// has not moved, like when local changes were in the way, it is.
+func (s *Sequencer) Continue() error {
+	r := s.repo
+	if r.readOnly {
+		return errReadOnly("Sequencer.Continue")
+	}
+	pending, action, err := s.pendingCommit()
+	if err != nil {
+		return err
+	}
+	if pending != nil {
+		index, err := r.Index()
+		if err != nil {
+			return err
+		}
+		if index.filePath != "" {
+			if err = index.Read(false); err != nil {
+				return err
+			}
+		}
+		if index.HasConflicts() {
+			return MakeGitError("you need to resolve the conflicts before continuing", ErrUnmerged)
+		}
+		treeId, err := index.WriteTreeTo(r)
+		if err != nil {
+			return err
+		}
+		message, err := r.fs.ReadFile(filepath.Join(r.pathRepository, GitMergeMsgFile))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		_, err = s.commit(action, pending, treeId, MessagePrettify(string(message), true, r.CommentChar()))
+		if err != nil {
+			return err
+		}
+		if err = s.removePendingCommit(); err != nil {
+			return err
+		}
+		if err = s.next(); err != nil {
+			return err
+		}
+	} else if len(s.todo) > 0 {
+		moved, err := s.headMoved()
+		if err != nil {
+			return err
+		}
+		if moved {
+			if err = s.next(); err != nil {
+				return err
+			}
+		}
+	}
+	return s.run()
+}
+
+// Skip drops the stopped commit, resets the index and the working
+// directory to HEAD, and applies the rest of the items.
+func (s *Sequencer) Skip() error {
+	r := s.repo
+	if r.readOnly {
+		return errReadOnly("Sequencer.Skip")
+	}
+	head, err := r.headCommit()
+	if err != nil {
+		return err
+	}
+	tree, err := head.Tree()
+	if err != nil {
+		return err
+	}
+	err = r.checkoutReset(tree)
+	if err != nil {
+		return err
+	}
+	if err = s.removePendingCommit(); err != nil {
+		return err
+	}
+	if len(s.todo) > 0 {
+		if err = s.next(); err != nil {
+			return err
+		}
+	}
+	return s.run()
+}
+
+// Abort moves the branch back to the commit where the sequencer started,
+// resets the index and the working directory to it, and removes the
+// state. It fails with ErrModified if HEAD was moved since the last commit
+// of the sequencer, so that the commits made by hand are not lost.
+func (s *Sequencer) Abort() error {
+	r := s.repo
+	if r.readOnly {
+		return errReadOnly("Sequencer.Abort")
+	}
+	head, err := r.headCommit()
+	if err != nil {
+		return err
+	}
+	moved, err := s.headMoved()
+	if err != nil {
+		return err
+	}
+	if moved {
+		return MakeGitError("you seem to have moved HEAD; not rewinding, check your HEAD", ErrModified)
+	}
+	original, err := r.LookupCommit(s.head)
+	if err != nil {
+		return err
+	}
+	tree, err := original.Tree()
+	if err != nil {
+		return err
+	}
+	err = r.checkoutReset(tree)
+	if err != nil {
+		return err
+	}
+	err = r.updateCommitRef(GitHeadFile, head.Id(), s.head)
+	if err != nil {
+		return err
+	}
+	if err = s.removePendingCommit(); err != nil {
+		return err
+	}
+	return s.remove()
+}
+
+// internal functions and methods
+
+// run applies the items until the todo list is empty, and removes the
+// state then.
+func (s *Sequencer) run() error {
+	for len(s.todo) > 0 {
+		head, err := s.apply(s.todo[0])
+		if err != nil {
+			return err
+		}
+		s.todo = s.todo[1:]
+		if err = s.save(head); err != nil {
+			return err
+		}
+	}
+	return s.remove()
+}
+
+// apply merges the change of the item into HEAD and commits it. On a
+// conflict, or when the commit becomes empty, the state to resolve it is
+// written and ErrMergeConflict or ErrApplied is returned.
+func (s *Sequencer) apply(item *SequencerItem) (*Oid, error) {
+	r := s.repo
+	commit, err := r.LookupCommit(item.Id)
+	if err != nil {
+		return nil, err
+	}
+	head, err := r.headCommit()
+	if err != nil {
+		return nil, err
+	}
+	headTree, err := head.Tree()
+	if err != nil {
+		return nil, err
+	}
+	index, err := r.pickMerge(commit, headTree, item.Action == SequencerRevert, s.opts.MergeOptions)
+	if err != nil {
+		return nil, err
+	}
+	message := commit.Message()
+	if item.Action == SequencerRevert {
+		message = fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s.\n", commit.Summary(), item.Id)
+	}
+	if index.HasConflicts() {
+		err = s.stop(item, commit, index, message)
+		if err != nil {
+			return nil, err
+		}
+		return nil, MakeGitError(fmt.Sprintf("could not apply %s... %s", r.shortIdForMessage(item.Id), commit.Summary()), ErrMergeConflict)
+	}
+	treeId, err := index.WriteTreeTo(r)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := r.LookupTree(treeId)
+	if err != nil {
+		return nil, err
+	}
+	err = r.CheckoutTree(merged, nil)
+	if err != nil {
+		return nil, err
+	}
+	oid, err := s.commit(item.Action, commit, treeId, message)
+	if IsErrorCode(err, ErrApplied) {
+		if pendingErr := s.recordPending(item, []byte(message)); pendingErr != nil {
+			return nil, pendingErr
+		}
+	}
+	return oid, err
+}
+
+// stop writes the conflicts to the working directory and the index, and
+// records the commit in CHERRY_PICK_HEAD or REVERT_HEAD and its message in
+// MERGE_MSG.
+func (s *Sequencer) stop(item *SequencerItem, commit *Commit, merged *Index, message string) error {
+	r := s.repo
+	theirLabel := fmt.Sprintf("%s... %s", r.shortIdForMessage(item.Id), commit.Summary())
+	if item.Action == SequencerRevert {
+		theirLabel = "parent of " + theirLabel
+	}
+	paths, err := r.checkoutConflicts(merged, theirLabel, s.opts.MergeOptions)
+	if err != nil {
+		return err
+	}
+	var buffer bytes.Buffer
+	buffer.WriteString(message)
+	buffer.WriteString("\n# Conflicts:\n")
+	for _, path := range paths {
+		buffer.WriteString("#\t" + path + "\n")
+	}
+	return s.recordPending(item, buffer.Bytes())
+}
+
+// recordPending records the commit of the item in CHERRY_PICK_HEAD or
+// REVERT_HEAD and the message in MERGE_MSG.
+func (s *Sequencer) recordPending(item *SequencerItem, message []byte) error {
+	r := s.repo
+	name := GitCherryPickHeadFile
+	if item.Action == SequencerRevert {
+		name = GitRevertHeadFile
+	}
+	err := r.writeGitFile(filepath.Join(r.pathRepository, name), []byte(item.Id.String()+"\n"))
+	if err != nil {
+		return err
+	}
+	return r.writeGitFile(filepath.Join(r.pathRepository, GitMergeMsgFile), message)
+}
+
+// commit commits the tree onto HEAD. The author of a pick is the one of
+// the picked commit. If the tree is the one of HEAD, it fails with
+// ErrApplied unless KeepRedundantCommits is set.
+func (s *Sequencer) commit(action SequencerAction, commit *Commit, treeId *Oid, message string) (*Oid, error) {
+	r := s.repo
+	head, err := r.headCommit()
+	if err != nil {
+		return nil, err
+	}
+	if head.TreeId().Equal(treeId) && !s.opts.KeepRedundantCommits {
+		return nil, MakeGitError(fmt.Sprintf("the %s of %s is now empty; skip it, or commit it with KeepRedundantCommits", action, r.shortIdForMessage(commit.Id())), ErrApplied)
+	}
+	tree, err := r.LookupTree(treeId)
+	if err != nil {
+		return nil, err
+	}
+	committer := s.opts.Committer
+	if committer == nil {
+		committer, err = r.DefaultSignature()
+		if err != nil {
+			return nil, err
+		}
+	}
+	author := committer
+	if action == SequencerPick {
+		author = commit.Author()
+	}
+	return r.CreateCommit(GitHeadFile, author, committer, message, tree, head)
+}
+
+// pendingCommit returns the commit of CHERRY_PICK_HEAD or REVERT_HEAD that
+// waits for its conflicts to be resolved.
+func (s *Sequencer) pendingCommit() (*Commit, SequencerAction, error) {
+	r := s.repo
+	for _, action := range []SequencerAction{SequencerPick, SequencerRevert} {
+		name := GitCherryPickHeadFile
+		if action == SequencerRevert {
+			name = GitRevertHeadFile
+		}
+		data, err := r.fs.ReadFile(filepath.Join(r.pathRepository, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, action, err
+		}
+		oid, err := NewOid(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, action, errors.New(fmt.Sprintf("corrupt %s: %s", name, err.Error()))
+		}
+		commit, err := r.LookupCommit(oid)
+		return commit, action, err
+	}
+	return nil, SequencerPick, nil
+}
+
+func (s *Sequencer) removePendingCommit() error {
+	r := s.repo
+	for _, name := range []string{GitCherryPickHeadFile, GitRevertHeadFile, GitMergeMsgFile} {
+		err := r.fs.Remove(filepath.Join(r.pathRepository, name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// next drops the first item after it is committed.
+func (s *Sequencer) next() error {
+	head, err := s.repo.headCommit()
+	if err != nil {
+		return err
+	}
+	s.todo = s.todo[1:]
+	return s.save(head.Id())
+}
+
+// save writes the todo list, and HEAD as the abort safety that tells
+// whether the user has moved HEAD since.
+func (s *Sequencer) save(head *Oid) error {
+	r := s.repo
+	var buffer bytes.Buffer
+	for _, item := range s.todo {
+		buffer.WriteString(item.Action.String())
+		buffer.WriteByte(' ')
+		buffer.WriteString(item.Id.String())
+		if commit, err := r.LookupCommit(item.Id); err == nil {
+			buffer.WriteByte(' ')
+			buffer.WriteString(commit.Summary())
+		}
+		buffer.WriteByte('\n')
+	}
+	err := r.writeGitFile(r.sequencerPath(gitSequencerTodoFile), buffer.Bytes())
+	if err != nil {
+		return err
+	}
+	return r.writeGitFile(r.sequencerPath(gitSequencerAbortSafetyFile), []byte(head.String()+"\n"))
+}
+
+// headMoved tells if HEAD is not the abort safety, the commit that the
+// sequencer made last.
+func (s *Sequencer) headMoved() (bool, error) {
+	r := s.repo
+	data, err := r.fs.ReadFile(r.sequencerPath(gitSequencerAbortSafetyFile))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	head, err := r.headCommit()
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(data)) != head.Id().String(), nil
+}
+
+func (s *Sequencer) remove() error {
+	r := s.repo
+	for _, name := range []string{gitSequencerHeadFile, gitSequencerTodoFile, gitSequencerAbortSafetyFile, "opts"} {
+		err := r.fs.Remove(r.sequencerPath(name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	err := r.fs.Remove(r.sequencerPath(""))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// parseSequencerItem parses a line of the todo list, like
+// "pick 1a2b3c4 summary". The commit may be abbreviated.
+func (r *Repository) parseSequencerItem(line string) (*SequencerItem, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil, errors.New(fmt.Sprintf("missing commit in '%s'", line))
+	}
+	item := &SequencerItem{}
+	switch fields[0] {
+	case "pick", "p":
+		item.Action = SequencerPick
+	case "revert":
+		item.Action = SequencerRevert
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown command '%s'", fields[0]))
+	}
+	prefix, err := NewOidFromPrefix(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	commit, err := r.LookupPrefixCommit(prefix, len(fields[1]))
+	if err != nil {
+		return nil, err
+	}
+	item.Id = commit.Id()
+	return item, nil
+}
+
+func (r *Repository) headCommit() (*Commit, error) {
+	object, err := r.RevparseSingle(GitHeadFile)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := object.Peel(ObjectCommit)
+	if err != nil {
+		return nil, err
+	}
+	return commit.(*Commit), nil
+}
+
+func (r *Repository) sequencerPath(name string) string {
+	return filepath.Join(r.pathRepository, GitSequencerDir, name)
+}
+
+// pickMerge merges the change that the commit made to its parent into the
+// tree, or the reverse of the change if revert is true.
+func (r *Repository) pickMerge(commit *Commit, onto *Tree, revert bool, opts *MergeOptions) (*Index, error) {
+	if commit.ParentCount() > 1 {
+		return nil, MakeGitError(fmt.Sprintf("commit %s is a merge but no mainline was given", commit.Id()), ErrInvalid)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	var parentTree *Tree
+	if commit.ParentCount() == 1 {
+		parentTree, err = r.commitTree(commit.ParentId(0))
+		if err != nil {
+			return nil, err
+		}
+	}
+	ancestor, theirs := parentTree, tree
+	if revert {
+		ancestor, theirs = tree, parentTree
+	}
+	var mergeOpts MergeOptions
+	if opts != nil {
+		mergeOpts = *opts
+	}
+	mergeOpts.FailOnConflict = false
+	return r.MergeTrees(ancestor, onto, theirs, &mergeOpts)
+}
+
+// checkoutConflicts checks out the merged files with our side of the
+// conflicts, and writes the conflicts with their markers to the working
+// directory and the index. The paths of the conflicts are returned.
+func (r *Repository) checkoutConflicts(merged *Index, theirLabel string, opts *MergeOptions) ([]string, error) {
+	resolved, err := NewIndex()
+	if err != nil {
+		return nil, err
+	}
+	var conflicts []IndexConflict
+	dirs := make(map[string]bool)
+	for _, entry := range merged.Entries {
+		if entry.Stage() == 0 {
+			resolved.Add(&IndexEntry{Path: entry.Path, Mode: entry.Mode, Id: entry.Id})
+			for dir := filepath.Dir(entry.Path); dir != "."; dir = filepath.Dir(dir) {
+				dirs[filepath.ToSlash(dir)] = true
+			}
+		}
+	}
+	iterator, err := merged.ConflictIterator()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		conflict, err := iterator.Next()
+		if IsErrorCode(err, ErrIterOver) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, conflict)
+		// a file of ours that conflicts with a directory of theirs stays
+		// out of the working directory
+		if conflict.Our != nil && !dirs[conflict.Our.Path] {
+			resolved.Add(&IndexEntry{Path: conflict.Our.Path, Mode: conflict.Our.Mode, Id: conflict.Our.Id})
+		}
+	}
+	treeId, err := resolved.WriteTreeTo(r)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := r.LookupTree(treeId)
+	if err != nil {
+		return nil, err
+	}
+	err = r.CheckoutTree(tree, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := r.Index()
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, conflict := range conflicts {
+		err = r.writeConflictFile(conflict, theirLabel, opts)
+		if err != nil {
+			return nil, err
+		}
+		err = index.AddConflict(copyStage(conflict.Ancestor), copyStage(conflict.Our), copyStage(conflict.Their))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, conflictPath(conflict))
+	}
+	if index.filePath != "" {
+		if err = index.Write(); err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// writeConflictFile writes the file of a conflict to the working
+// directory. Files that both sides changed get conflict markers; a file
+// that only their side kept is written as it is.
+func (r *Repository) writeConflictFile(conflict IndexConflict, theirLabel string, opts *MergeOptions) error {
+	our, their := conflict.Our, conflict.Their
+	if their == nil || (our != nil && !(isMergeableEntry(our) && isMergeableEntry(their))) {
+		return nil
+	}
+	perfdata := &CheckoutPerfdata{}
+	if our == nil {
+		_, err := r.checkoutWrite(their, perfdata)
+		return err
+	}
+	result, err := r.mergeConflictContents(conflict, "HEAD", theirLabel, opts)
+	if err != nil {
+		return err
+	}
+	contents, err := r.ConvertToWorkdir(our.Path, result.Contents)
+	if err != nil {
+		return err
+	}
+	fullPath := filepath.Join(r.Workdir(), filepath.FromSlash(our.Path))
+	file, err := r.fs.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(contents)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// mergeConflictContents merges the files of both sides of a conflict with
+// conflict markers. The labels are used if the options do not set them.
+func (r *Repository) mergeConflictContents(conflict IndexConflict, ourLabel, theirLabel string, opts *MergeOptions) (*MergeFileResult, error) {
+	inputs := make([]*MergeFileInput, 3)
+	for i, entry := range []*IndexEntry{conflict.Ancestor, conflict.Our, conflict.Their} {
+		if entry == nil {
+			inputs[i] = &MergeFileInput{}
+			continue
+		}
+		blob, err := r.LookupBlob(entry.Id)
+		if err != nil {
+			return nil, err
+		}
+		inputs[i] = &MergeFileInput{Path: entry.Path, Mode: entry.Mode, Contents: blob.Contents()}
+	}
+	var fileOpts MergeFileOptions
+	if opts != nil && opts.FileOptions != nil {
+		fileOpts = *opts.FileOptions
+	}
+	if fileOpts.OurLabel == "" {
+		fileOpts.OurLabel = ourLabel
+	}
+	if fileOpts.TheirLabel == "" {
+		fileOpts.TheirLabel = theirLabel
+	}
+	return r.MergeFile(inputs[0], inputs[1], inputs[2], &fileOpts)
+}
+
+// checkoutReset makes the index and the working directory match the tree.
+// The files that only their side of a conflict added are not known to the
+// checkout, so they are removed first.
+func (r *Repository) checkoutReset(tree *Tree) error {
+	index, err := r.Index()
+	if err != nil {
+		return err
+	}
+	ours := make(map[string]bool)
+	var paths []string
+	for _, entry := range index.Entries {
+		switch entry.Stage() {
+		case 0:
+		case StageOurs:
+			ours[entry.Path] = true
+		default:
+			paths = append(paths, entry.Path)
+		}
+	}
+	for _, path := range paths {
+		if !ours[path] {
+			if err = r.checkoutRemove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return r.CheckoutTree(tree, &CheckoutOptions{Force: true})
+}
+
+// writeGitFile replaces a file of the git directory through its lock.
+func (r *Repository) writeGitFile(path string, data []byte) error {
+	lock, err := newLockfile(r.fs, path, 0644, DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	_, err = lock.Write(data)
+	if err != nil {
+		lock.Rollback()
+		return err
+	}
+	return lock.Commit()
+}
+
+func isMergeableEntry(entry *IndexEntry) bool {
+	return entry.Mode == FilemodeBlob || entry.Mode == FilemodeBlobExecutable
+}
+
+func copyStage(entry *IndexEntry) *IndexEntry {
+	if entry == nil {
+		return nil
+	}
+	return &IndexEntry{Path: entry.Path, Mode: entry.Mode, Id: entry.Id}
+}
+
+func conflictPath(conflict IndexConflict) string {
+	for _, entry := range []*IndexEntry{conflict.Our, conflict.Their, conflict.Ancestor} {
+		if entry != nil {
+			return entry.Path
+		}
+	}
+	return ""
+}
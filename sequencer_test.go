@@ -0,0 +1,263 @@
+package git4go
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// prepareSequencerRepository makes a topic branch of three commits whose
+// second one conflicts with HEAD, and checks out HEAD.
+func prepareSequencerRepository(t *testing.T) (*Repository, *Commit, []*Commit) {
+	dir, _ := ioutil.TempDir("", "git4go_sequencer")
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	base := writeMergeCommit(repo, map[string]string{"a.txt": "one\ntwo\nthree\n", "b.txt": "b\n"})
+	topic1 := writeMergeCommit(repo, map[string]string{"a.txt": "one\ntwo\nthree\n", "b.txt": "b\n", "c.txt": "c\n"}, base)
+	topic2 := writeMergeCommit(repo, map[string]string{"a.txt": "one\nTWO\nthree\n", "b.txt": "b\n", "c.txt": "c\n"}, topic1)
+	topic3 := writeMergeCommit(repo, map[string]string{"a.txt": "one\nTWO\nthree\n", "b.txt": "B\n", "c.txt": "c\n"}, topic2)
+	head := writeMergeCommit(repo, map[string]string{"a.txt": "one\n2\nthree\n", "b.txt": "b\n"}, base)
+	repo.CreateReference("refs/heads/master", head.Id(), true)
+	tree, _ := head.Tree()
+	if err := repo.CheckoutTree(tree, &CheckoutOptions{Force: true}); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	return repo, head, []*Commit{base, topic1, topic2, topic3}
+}
+
+func sequencerOptions(repo *Repository) *SequencerOptions {
+	return &SequencerOptions{Committer: &Signature{"C", "c@example.com", repo.now()}}
+}
+
+func startSequencer(t *testing.T, repo *Repository, spec string) *Sequencer {
+	todo, err := repo.SequencerTodo(SequencerPick, spec)
+	if err != nil || len(todo) != 3 {
+		t.Fatal("it should pick the commits of the range:", todo, err)
+	}
+	sequencer, err := repo.StartSequencer(todo, sequencerOptions(repo))
+	if !IsErrorCode(err, ErrMergeConflict) {
+		t.Fatal("it should stop at the conflict:", err)
+	}
+	return sequencer
+}
+
+func readWorkdirFile(repo *Repository, path string) string {
+	contents, err := ioutil.ReadFile(filepath.Join(repo.Workdir(), path))
+	if err != nil {
+		return "<" + err.Error() + ">"
+	}
+	return string(contents)
+}
+
+func Test_Sequencer_Continue(t *testing.T) {
+	repo, head, commits := prepareSequencerRepository(t)
+	defer os.RemoveAll(repo.Workdir())
+
+	sequencer := startSequencer(t, repo, commits[0].Id().String()+".."+commits[3].Id().String())
+	todo := sequencer.Todo()
+	if len(todo) != 2 || !todo[0].Id.Equal(commits[2].Id()) || !todo[1].Id.Equal(commits[3].Id()) {
+		t.Fatal("it should keep the stopped commit and the rest in the todo list:", todo)
+	}
+	pickHead, _ := ioutil.ReadFile(filepath.Join(repo.Path(), GitCherryPickHeadFile))
+	if strings.TrimSpace(string(pickHead)) != commits[2].Id().String() {
+		t.Errorf("it should record the stopped commit in CHERRY_PICK_HEAD: %q", pickHead)
+	}
+	if readWorkdirFile(repo, "c.txt") != "c\n" {
+		t.Error("it should check out the picked commit before the conflict")
+	}
+	if contents := readWorkdirFile(repo, "a.txt"); !strings.HasPrefix(contents, "one\n<<<<<<< HEAD\n2\n=======\nTWO\n>>>>>>> ") {
+		t.Errorf("it should write the conflict markers: %q", contents)
+	}
+	index, _ := repo.Index()
+	if !index.HasConflicts() {
+		t.Error("it should write the conflict to the index")
+	}
+
+	reopened, err := repo.OpenSequencer(sequencerOptions(repo))
+	if err != nil || len(reopened.Todo()) != 2 || !reopened.Todo()[0].Id.Equal(commits[2].Id()) {
+		t.Fatal("it should read the state of the sequencer:", err)
+	}
+	if err = reopened.Continue(); !IsErrorCode(err, ErrUnmerged) {
+		t.Error("it should not continue before the conflicts are resolved:", err)
+	}
+
+	resolution, _ := repo.CreateBlobFromBuffer([]byte("one\nTWO\nthree\n"))
+	ioutil.WriteFile(filepath.Join(repo.Workdir(), "a.txt"), []byte("one\nTWO\nthree\n"), 0644)
+	index.RemoveConflict("a.txt")
+	index.Add(&IndexEntry{Path: "a.txt", Mode: FilemodeBlob, Id: resolution})
+	index.Write()
+	err = reopened.Continue()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if _, err := os.Stat(filepath.Join(repo.Path(), GitSequencerDir)); !os.IsNotExist(err) {
+		t.Error("it should remove the state when it is done")
+	}
+	if _, err := os.Stat(filepath.Join(repo.Path(), GitCherryPickHeadFile)); !os.IsNotExist(err) {
+		t.Error("it should remove CHERRY_PICK_HEAD")
+	}
+	tip, _ := repo.headCommit()
+	ancestor, _ := tip.NthGenAncestor(3)
+	if ancestor == nil || !ancestor.Id().Equal(head.Id()) {
+		t.Error("it should pick the three commits onto HEAD")
+	}
+	if tip.Author().Name != "A" || tip.Committer().Name != "C" || tip.Message() != "merge test\n" {
+		t.Error("it should keep the author and the message of the picked commit:", tip.Author(), tip.Committer(), tip.Message())
+	}
+	if readWorkdirFile(repo, "b.txt") != "B\n" || readWorkdirFile(repo, "a.txt") != "one\nTWO\nthree\n" {
+		t.Error("it should check out the last commit")
+	}
+}
+
+func Test_Sequencer_SkipAndAbort(t *testing.T) {
+	repo, head, commits := prepareSequencerRepository(t)
+	defer os.RemoveAll(repo.Workdir())
+	spec := commits[0].Id().String() + ".." + commits[3].Id().String()
+
+	sequencer := startSequencer(t, repo, spec)
+	err := sequencer.Abort()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	tip, _ := repo.headCommit()
+	if !tip.Id().Equal(head.Id()) {
+		t.Error("it should move HEAD back")
+	}
+	if readWorkdirFile(repo, "a.txt") != "one\n2\nthree\n" {
+		t.Error("it should reset the working directory")
+	}
+	if _, err := os.Stat(filepath.Join(repo.Workdir(), "c.txt")); !os.IsNotExist(err) {
+		t.Error("it should remove the files of the picked commits")
+	}
+	if _, err := repo.OpenSequencer(nil); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should remove the state:", err)
+	}
+
+	sequencer = startSequencer(t, repo, spec)
+	err = sequencer.Skip()
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	tip, _ = repo.headCommit()
+	ancestor, _ := tip.NthGenAncestor(2)
+	if ancestor == nil || !ancestor.Id().Equal(head.Id()) {
+		t.Error("it should drop the skipped commit")
+	}
+	if readWorkdirFile(repo, "a.txt") != "one\n2\nthree\n" || readWorkdirFile(repo, "b.txt") != "B\n" {
+		t.Error("it should apply the commits after the skipped one")
+	}
+
+	// the first commit is on HEAD now, so its pick is empty
+	todo, _ := repo.SequencerTodo(SequencerPick, spec)
+	sequencer, err = repo.StartSequencer(todo, sequencerOptions(repo))
+	if !IsErrorCode(err, ErrApplied) {
+		t.Fatal("it should stop at the empty pick:", err)
+	}
+	tip, _ = repo.headCommit()
+	tree, _ := tip.Tree()
+	repo.CreateCommit(GitHeadFile, tip.Author(), tip.Committer(), "moved\n", tree, tip)
+	if err = sequencer.Abort(); !IsErrorCode(err, ErrModified) {
+		t.Error("it should not abort when HEAD was moved:", err)
+	}
+}
+
+func Test_Sequencer_ContinueAfterCommit(t *testing.T) {
+	repo, head, commits := prepareSequencerRepository(t)
+	defer os.RemoveAll(repo.Workdir())
+
+	sequencer := startSequencer(t, repo, commits[0].Id().String()+".."+commits[3].Id().String())
+	// the conflict is resolved and committed by hand, which removes
+	// CHERRY_PICK_HEAD like "git commit" does
+	resolution, _ := repo.CreateBlobFromBuffer([]byte("one\nTWO\nthree\n"))
+	ioutil.WriteFile(filepath.Join(repo.Workdir(), "a.txt"), []byte("one\nTWO\nthree\n"), 0644)
+	index, _ := repo.Index()
+	index.RemoveConflict("a.txt")
+	index.Add(&IndexEntry{Path: "a.txt", Mode: FilemodeBlob, Id: resolution})
+	index.Write()
+	treeId, _ := index.WriteTreeTo(repo)
+	tree, _ := repo.LookupTree(treeId)
+	tip, _ := repo.headCommit()
+	manual, _ := repo.CreateCommit(GitHeadFile, tip.Author(), tip.Committer(), "resolved\n", tree, tip)
+	os.Remove(filepath.Join(repo.Path(), GitCherryPickHeadFile))
+
+	if err := sequencer.Continue(); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	tip, _ = repo.headCommit()
+	if tip.ParentCount() != 1 || !tip.ParentId(0).Equal(manual) {
+		t.Error("it should continue after the commit that was made by hand")
+	}
+	ancestor, _ := tip.NthGenAncestor(3)
+	if ancestor == nil || !ancestor.Id().Equal(head.Id()) {
+		t.Error("it should not pick the committed commit again")
+	}
+}
+
+func Test_Sequencer_EmptyPick(t *testing.T) {
+	repo, head, commits := prepareSequencerRepository(t)
+	defer os.RemoveAll(repo.Workdir())
+
+	todo := []*SequencerItem{{Action: SequencerPick, Id: commits[1].Id()}, {Action: SequencerPick, Id: commits[1].Id()}}
+	sequencer, err := repo.StartSequencer(todo, sequencerOptions(repo))
+	if !IsErrorCode(err, ErrApplied) {
+		t.Fatal("it should stop at the pick that is empty:", err)
+	}
+	if len(sequencer.Todo()) != 1 {
+		t.Error("it should keep the empty pick in the todo list:", len(sequencer.Todo()))
+	}
+	if _, err = os.Stat(filepath.Join(repo.Path(), GitCherryPickHeadFile)); err != nil {
+		t.Error("it should record the empty pick in CHERRY_PICK_HEAD:", err)
+	}
+	if err = sequencer.Continue(); !IsErrorCode(err, ErrApplied) {
+		t.Error("it should not continue with the empty pick:", err)
+	}
+	if err = sequencer.Skip(); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	tip, _ := repo.headCommit()
+	if tip.ParentCount() != 1 || !tip.ParentId(0).Equal(head.Id()) {
+		t.Error("it should drop the empty pick")
+	}
+
+	opts := sequencerOptions(repo)
+	opts.KeepRedundantCommits = true
+	todo = []*SequencerItem{{Action: SequencerPick, Id: commits[1].Id()}}
+	if _, err = repo.StartSequencer(todo, opts); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	empty, _ := repo.headCommit()
+	if empty.ParentCount() != 1 || !empty.ParentId(0).Equal(tip.Id()) || !empty.TreeId().Equal(tip.TreeId()) {
+		t.Error("it should commit the empty pick with KeepRedundantCommits")
+	}
+}
+
+func Test_Sequencer_Revert(t *testing.T) {
+	repo, _, commits := prepareSequencerRepository(t)
+	defer os.RemoveAll(repo.Workdir())
+	repo.CreateReference("refs/heads/master", commits[3].Id(), true)
+	tree, _ := commits[3].Tree()
+	repo.CheckoutTree(tree, &CheckoutOptions{Force: true})
+	repo.Config().SetString("user.name", "R")
+	repo.Config().SetString("user.email", "r@example.com")
+
+	todo, err := repo.SequencerTodo(SequencerRevert, commits[1].Id().String())
+	if err != nil || len(todo) != 1 || todo[0].Action != SequencerRevert {
+		t.Fatal("it should revert the commit:", todo, err)
+	}
+	_, err = repo.StartSequencer(todo, nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	tip, _ := repo.headCommit()
+	if !tip.ParentId(0).Equal(commits[3].Id()) {
+		t.Fatal("it should commit the revert onto HEAD")
+	}
+	expected := "Revert \"merge test\"\n\nThis reverts commit " + commits[1].Id().String() + ".\n"
+	if tip.Message() != expected || tip.Author().Name != "R" {
+		t.Errorf("it should write the revert message: %q", tip.Message())
+	}
+	if _, err := os.Stat(filepath.Join(repo.Workdir(), "c.txt")); !os.IsNotExist(err) {
+		t.Error("it should remove the file that the commit added")
+	}
+}
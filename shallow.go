@@ -0,0 +1,146 @@
+package git4go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const GitShallowFile = "shallow"
+
+// IsShallow returns true if the history of the repository is cut at some
+// commits, as it is after a fetch with a depth limit.
+func (r *Repository) IsShallow() bool {
+	roots, err := r.shallowRoots()
+	return err == nil && len(roots) > 0
+}
+
+// ShallowRoots returns the commits whose parents are not in the repository.
+// They are read from the .git/shallow file.
+func (r *Repository) ShallowRoots() ([]*Oid, error) {
+	roots, err := r.shallowRoots()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Oid, 0, len(roots))
+	for oid := range roots {
+		result = append(result, oid.Copy())
+	}
+	sort.Sort(oidList(result))
+	return result, nil
+}
+
+// UpdateShallow applies the "shallow" and "unshallow" lines that the server
+// sent while negotiating a fetch with a depth limit. The shallow file is
+// rewritten under its lock, and it is removed when no roots are left.
+func (r *Repository) UpdateShallow(shallow, unshallow []*Oid) error {
+	if r.readOnly {
+		return errReadOnly("Repository.UpdateShallow")
+	}
+	r.shallowLock.Lock()
+	defer r.shallowLock.Unlock()
+
+	roots, err := r.loadShallowRoots()
+	if err != nil {
+		return err
+	}
+	updated := make(map[Oid]bool, len(roots)+len(shallow))
+	for oid := range roots {
+		updated[oid] = true
+	}
+	for _, oid := range shallow {
+		updated[*oid] = true
+	}
+	for _, oid := range unshallow {
+		delete(updated, *oid)
+	}
+	if r.pathRepository != "" {
+		err = r.writeShallowFile(updated)
+		if err != nil {
+			return err
+		}
+	}
+	r.shallow = updated
+	// the history that the cached merge bases were found in changed
+	r.graphCache.clear()
+	return nil
+}
+
+// internal functions and methods
+
+type oidList []*Oid
+
+func (l oidList) Len() int           { return len(l) }
+func (l oidList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l oidList) Less(i, j int) bool { return l[i].Cmp(l[j]) < 0 }
+
+func (r *Repository) shallowRoots() (map[Oid]bool, error) {
+	r.shallowLock.Lock()
+	defer r.shallowLock.Unlock()
+
+	return r.loadShallowRoots()
+}
+
+func (r *Repository) isShallowRoot(oid *Oid) bool {
+	roots, err := r.shallowRoots()
+	return err == nil && roots[*oid]
+}
+
+func (r *Repository) loadShallowRoots() (map[Oid]bool, error) {
+	if r.shallow != nil {
+		return r.shallow, nil
+	}
+	roots := make(map[Oid]bool)
+	if r.pathRepository != "" {
+		data, err := r.fs.ReadFile(filepath.Join(r.pathCommon, GitShallowFile))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for i, line := range bytes.Split(data, []byte("\n")) {
+			if len(line) == 0 {
+				continue
+			}
+			oid, err := NewOid(string(line))
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("invalid data in shallow file at line %d", i+1))
+			}
+			roots[*oid] = true
+		}
+	}
+	r.shallow = roots
+	return roots, nil
+}
+
+func (r *Repository) writeShallowFile(roots map[Oid]bool) error {
+	path := filepath.Join(r.pathCommon, GitShallowFile)
+	if len(roots) == 0 {
+		err := r.fs.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	lock, err := newLockfile(r.fs, path, 0644, DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	oids := make([]*Oid, 0, len(roots))
+	for oid := range roots {
+		oids = append(oids, oid.Copy())
+	}
+	sort.Sort(oidList(oids))
+	var buffer bytes.Buffer
+	for _, oid := range oids {
+		buffer.WriteString(oid.String())
+		buffer.WriteByte('\n')
+	}
+	_, err = lock.Write(buffer.Bytes())
+	if err != nil {
+		lock.Rollback()
+		return err
+	}
+	return lock.Commit()
+}
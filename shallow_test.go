@@ -0,0 +1,52 @@
+package git4go
+
+import (
+	"./testutil"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_UpdateShallow(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	if repo.IsShallow() {
+		t.Error("it should not be shallow")
+	}
+	root, _ := NewOid(commitIds[5])
+	err := repo.UpdateShallow([]*Oid{root}, nil)
+	if err != nil {
+		t.Fatal("it should update shallow file:", err)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join("test_resources/testrepo.git", GitShallowFile))
+	if string(data) != commitIds[5]+"\n" {
+		t.Error("it should write shallow file:", string(data))
+	}
+
+	repo, _ = OpenRepository("test_resources/testrepo.git")
+	roots, err := repo.ShallowRoots()
+	if err != nil || len(roots) != 1 || !roots[0].Equal(root) {
+		t.Error("it should read shallow roots:", roots, err)
+	}
+	walk, _ := repo.Walk()
+	walk.Push(root)
+	count := 0
+	walk.Iterate(func(commit *Commit) bool {
+		count++
+		return true
+	})
+	if count != 1 {
+		t.Error("it should not walk parents of shallow root:", count)
+	}
+
+	err = repo.UpdateShallow(nil, []*Oid{root})
+	if err != nil || repo.IsShallow() {
+		t.Error("it should unshallow:", err)
+	}
+	if _, err := os.Stat(filepath.Join("test_resources/testrepo.git", GitShallowFile)); !os.IsNotExist(err) {
+		t.Error("it should remove empty shallow file")
+	}
+}
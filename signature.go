@@ -3,6 +3,7 @@ package git4go
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -26,7 +27,7 @@ func (repo *Repository) DefaultSignature() (*Signature, error) {
 	return &Signature{
 		Name:  name,
 		Email: email,
-		When:  time.Now(),
+		When:  repo.now(),
 	}, nil
 
 }
@@ -43,62 +44,89 @@ func (v *Signature) Offset() int {
 	return offset / 60
 }
 
+// writeSignature writes the signature as an object header line, like
+// "author Name <email> 1225475778 -0700".
+func writeSignature(buffer *bytes.Buffer, prefix string, sig *Signature) {
+	offset := sig.Offset()
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	fmt.Fprintf(buffer, "%s%s <%s> %d %c%02d%02d\n", prefix, sig.Name, sig.Email, sig.When.Unix(), sign, offset/60, offset%60)
+}
+
+// parseSignature parses the ident line with the prefix. Like git, broken
+// lines of old commits are read as well as possible instead of failing:
+// without the brackets, the whole line is the name; a timestamp that can't
+// be read is the epoch, and an invalid timezone is UTC. Only a missing line
+// is an error.
 func parseSignature(data []byte, offset int, prefix []byte) (*Signature, int, error) {
+	if !bytes.HasPrefix(data[offset:], prefix) {
+		return nil, offset, errors.New("expected prefix doesn't match actual")
+	}
 	linePrefix := offset + len(prefix)
-	lineEnd := offset
-	found := false
+	lineEnd := bytes.IndexByte(data[linePrefix:], '\n')
+	next := len(data)
+	if lineEnd == -1 {
+		lineEnd = len(data)
+	} else {
+		lineEnd += linePrefix
+		next = lineEnd + 1
+	}
+	line := data[linePrefix:lineEnd]
+
+	// the last brackets are used, so names with "<" are read, like libgit2
+	emailEnd := bytes.LastIndexByte(line, '>')
 	emailStart := -1
-	emailEnd := -1
-	for lineEnd < len(data) {
-		switch data[lineEnd] {
-		case '<':
-			emailStart = lineEnd - linePrefix
-		case '>':
-			emailEnd = lineEnd - linePrefix
-		case '\n':
-			found = true
-		}
-		if found {
-			break
-		}
-		lineEnd++
+	if emailEnd != -1 {
+		emailStart = bytes.LastIndexByte(line[:emailEnd], '<')
 	}
-	if !found {
-		return nil, offset, errors.New("no newline given")
+	if emailStart == -1 {
+		return &Signature{
+			Name: string(bytes.TrimSpace(line)),
+			When: time.Unix(0, 0).UTC(),
+		}, next, nil
 	}
-	if !bytes.Equal(data[offset:offset+len(prefix)], prefix) {
-		return nil, offset, errors.New("expected prefix doesn't match actual")
+	sig := &Signature{
+		Name:  string(bytes.Trim(line[:emailStart], " \t<>")),
+		Email: string(bytes.Trim(line[emailStart+1:emailEnd], " \t<>")),
 	}
-	line := data[linePrefix:lineEnd]
-	if emailStart == -1 || emailEnd == -1 || emailEnd < emailStart {
-		return nil, offset, errors.New("malformed e-mail")
+	fields := strings.Fields(string(line[emailEnd+1:]))
+	var epoch int64
+	if len(fields) > 0 {
+		if value, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			epoch = value
+		}
 	}
-	sig := &Signature{
-		Name:  string(bytes.TrimSpace(line[:emailStart-1])),
-		Email: string(bytes.TrimSpace(line[emailStart+1 : emailEnd])),
+	offsetSeconds := 0
+	if len(fields) > 1 {
+		offsetSeconds = parseTimezone(fields[1])
 	}
-	timeStart := emailEnd + 1
-	for timeStart < len(line) && line[timeStart] == ' ' {
-		timeStart++
+	sig.When = time.Unix(epoch, 0).In(time.FixedZone("", offsetSeconds))
+	return sig, next, nil
+}
+
+// parseTimezone returns the offset of a timezone like "-0700" in seconds.
+// Offsets that are malformed or out of range are 0.
+func parseTimezone(timezone string) int {
+	if len(timezone) != 5 || (timezone[0] != '+' && timezone[0] != '-') {
+		return 0
 	}
-	timeEnd := timeStart + 1
-	for timeEnd < len(line) && line[timeEnd] != ' ' {
-		timeEnd++
+	value := 0
+	for _, c := range timezone[1:] {
+		if c < '0' || c > '9' {
+			return 0
+		}
+		value = value*10 + int(c-'0')
 	}
-	epoch, err := strconv.ParseInt(string(line[timeStart:timeEnd]), 10, 64)
-	if err != nil {
-		return nil, offset, err
+	hours, minutes := value/100, value%100
+	if hours > 14 || minutes > 59 {
+		return 0
 	}
-	timestamp := time.Unix(epoch, 0)
-	timezone, err := strconv.ParseInt(string(bytes.TrimSpace(line[timeEnd:])), 10, 64)
-	hour := timezone / 100
-	min := timezone % 100
-	if hour < 14 && min < 59 {
-		second := int(hour*3600 + min*60)
-		_, localTimezone := timestamp.Zone()
-		timestamp = timestamp.Add(time.Second * time.Duration(localTimezone-second))
-		timestamp = timestamp.In(time.FixedZone(" ", second))
+	seconds := hours*3600 + minutes*60
+	if timezone[0] == '-' {
+		seconds = -seconds
 	}
-	sig.When = timestamp
-	return sig, lineEnd + 1, nil
+	return seconds
 }
@@ -6,6 +6,7 @@ import (
 	//"fmt"
 	"github.com/Unknwon/goconfig"
 	"testing"
+	"time"
 )
 
 func Test_DefaultSignature(t *testing.T) {
@@ -89,3 +90,71 @@ func Test_parseSignature(t *testing.T) {
 		t.Error("offset is wrong")
 	}
 }
+
+func Test_DefaultSignature_hermetic(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/empty_standard_repo/")
+	defer testutil.CleanupWorkspace()
+
+	file, _ := goconfig.LoadConfigFile("test_resources/empty_standard_repo/.git/config")
+	file.SetValue("user", "name", "TestUser")
+	file.SetValue("user", "email", "user@example.com")
+	goconfig.SaveConfigFile(file, "test_resources/empty_standard_repo/.git/config")
+
+	repo, err := OpenRepositoryHermetic("test_resources/empty_standard_repo")
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if !repo.IsHermetic() {
+		t.Error("it should be hermetic")
+	}
+	if len(repo.Config().fileList()) != 1 {
+		t.Error("it should read only the config of the repository")
+	}
+	sig, err := repo.DefaultSignature()
+	if err != nil || !sig.When.Equal(time.Unix(0, 0)) {
+		t.Error("it should use the fixed time:", sig, err)
+	}
+	when := time.Date(2015, 4, 1, 12, 0, 0, 0, time.FixedZone("", 9*60*60))
+	repo.SetClock(func() time.Time { return when })
+	sig, _ = repo.DefaultSignature()
+	if !sig.When.Equal(when) || sig.Offset() != 9*60 {
+		t.Error("it should use the clock:", sig.When)
+	}
+}
+
+func Test_parseSignature_Malformed(t *testing.T) {
+	testCases := []struct {
+		line   string
+		name   string
+		email  string
+		unix   int64
+		offset int
+	}{
+		{"author A U Thor <author@example.com> 1225475778 +0930\n", "A U Thor", "author@example.com", 1225475778, 9*3600 + 30*60},
+		{"author A U Thor author@example.com 1225475778 +0000\n", "A U Thor author@example.com 1225475778 +0000", "", 0, 0},
+		{"author A U Thor <<author@example.com>> 1225475778 -0100\n", "A U Thor", "author@example.com", 1225475778, -3600},
+		{"author <> 1225475778 +0000\n", "", "", 1225475778, 0},
+		{"author A U Thor <author@example.com> 1225475778 +99999\n", "A U Thor", "author@example.com", 1225475778, 0},
+		{"author A U Thor <author@example.com> 1225475778 +1575\n", "A U Thor", "author@example.com", 1225475778, 0},
+		{"author A U Thor <author@example.com> 18446744073709551616 +0100\n", "A U Thor", "author@example.com", 0, 3600},
+		{"author A U Thor <author@example.com>\n", "A U Thor", "author@example.com", 0, 0},
+		{"author A U Thor <author@example.com> 1225475778 +0100", "A U Thor", "author@example.com", 1225475778, 3600},
+	}
+	for _, testCase := range testCases {
+		signature, offset, err := parseSignature([]byte(testCase.line), 0, []byte("author "))
+		if err != nil {
+			t.Error("it should read the broken line:", testCase.line, err)
+			continue
+		}
+		_, zone := signature.When.Zone()
+		if signature.Name != testCase.name || signature.Email != testCase.email || signature.When.Unix() != testCase.unix || zone != testCase.offset {
+			t.Errorf("it should recover the ident of %q: %#v", testCase.line, signature)
+		}
+		if offset != len(testCase.line) {
+			t.Error("it should return the end of the line:", offset)
+		}
+	}
+	if _, _, err := parseSignature([]byte("committer A <a@example.com> 0 +0000\n"), 0, []byte("author ")); err == nil {
+		t.Error("it should fail without the line")
+	}
+}
@@ -0,0 +1,441 @@
+package git4go
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const (
+	gitSSHSignatureNamespace = "git"
+	sshSignatureMagic        = "SSHSIG"
+	sshSignatureBegin        = "-----BEGIN SSH SIGNATURE-----"
+	sshSignatureEnd          = "-----END SSH SIGNATURE-----"
+)
+
+// the first lines of the signatures that git appends to tags
+var tagSignaturePrefixes = []string{
+	"-----BEGIN PGP SIGNATURE-----",
+	"-----BEGIN PGP MESSAGE-----",
+	"-----BEGIN SIGNED MESSAGE-----",
+	sshSignatureBegin,
+}
+
+// SignatureVerification is the result of a successful verification.
+type SignatureVerification struct {
+	// The user id of the OpenPGP key, or the principals of the allowed
+	// signers entry of the SSH key
+	Signer string
+	// The fingerprint of the key
+	KeyId string
+}
+
+// VerifierCallback checks the signature over the payload. It returns the
+// signer, or an error if the signature is not valid or the key is unknown.
+type VerifierCallback func(signature, payload string) (*SignatureVerification, error)
+
+// ExtractSignature returns the signature of the commit (the gpgsig header)
+// and the content that was signed. It fails with ErrNotFound if the commit
+// is not signed.
+func (c *Commit) ExtractSignature() (signature, payload string, err error) {
+	data, err := readRawObject(c.repo, c.oid)
+	if err != nil {
+		return "", "", err
+	}
+	var signed, sig bytes.Buffer
+	inSignature, current, found := false, false, false
+	offset := 0
+	for offset < len(data) {
+		eol := bytes.IndexByte(data[offset:], '\n')
+		if eol == -1 {
+			eol = len(data)
+		} else {
+			eol += offset + 1
+		}
+		line := data[offset:eol]
+		if line[0] == '\n' {
+			signed.Write(data[offset:])
+			break
+		}
+		if line[0] == ' ' && inSignature {
+			if current {
+				sig.Write(line[1:])
+			}
+		} else if bytes.HasPrefix(line, []byte(gitSignatureField+" ")) || bytes.HasPrefix(line, []byte(gitSignatureField+"-sha256 ")) {
+			// all signatures are left out of the payload, the first is used
+			inSignature, current, found = true, !found, true
+			if current {
+				sig.Write(line[bytes.IndexByte(line, ' ')+1:])
+			}
+		} else {
+			inSignature = false
+			signed.Write(line)
+		}
+		offset = eol
+	}
+	if !found {
+		return "", "", MakeGitError(fmt.Sprintf("commit %s is not signed", c.oid.String()), ErrNotFound)
+	}
+	return sig.String(), signed.String(), nil
+}
+
+// ExtractSignature returns the signature that follows the message of the
+// tag and the content that was signed. It fails with ErrNotFound if the tag
+// is not signed.
+func (t *Tag) ExtractSignature() (signature, payload string, err error) {
+	data, err := readRawObject(t.repo, t.oid)
+	if err != nil {
+		return "", "", err
+	}
+	body := bytes.Index(data, []byte("\n\n"))
+	for offset := body + 1; body != -1 && offset < len(data); {
+		for _, prefix := range tagSignaturePrefixes {
+			if bytes.HasPrefix(data[offset:], []byte(prefix)) {
+				return string(data[offset:]), string(data[:offset]), nil
+			}
+		}
+		eol := bytes.IndexByte(data[offset:], '\n')
+		if eol == -1 {
+			break
+		}
+		offset += eol + 1
+	}
+	return "", "", MakeGitError(fmt.Sprintf("tag %s is not signed", t.oid.String()), ErrNotFound)
+}
+
+// VerifyCommitSignature checks the signature of the commit with the
+// verifier.
+func VerifyCommitSignature(commit *Commit, verifier VerifierCallback) (*SignatureVerification, error) {
+	signature, payload, err := commit.ExtractSignature()
+	if err != nil {
+		return nil, err
+	}
+	return verifier(signature, payload)
+}
+
+// VerifyTagSignature checks the signature of the tag with the verifier.
+func VerifyTagSignature(tag *Tag, verifier VerifierCallback) (*SignatureVerification, error) {
+	signature, payload, err := tag.ExtractSignature()
+	if err != nil {
+		return nil, err
+	}
+	return verifier(signature, payload)
+}
+
+// NewOpenPGPVerifier creates the verifier of OpenPGP signatures made by the
+// keys of the armored keyring, like the output of "gpg --export --armor".
+// A signature by another key fails with ErrNotFound.
+func NewOpenPGPVerifier(keyring io.Reader) (VerifierCallback, error) {
+	keys, err := openpgp.ReadArmoredKeyRing(keyring)
+	if err != nil {
+		return nil, err
+	}
+	return func(signature, payload string) (*SignatureVerification, error) {
+		if !strings.HasPrefix(signature, "-----BEGIN PGP ") {
+			return nil, MakeGitError("not an OpenPGP signature", ErrInvalid)
+		}
+		signer, err := openpgp.CheckArmoredDetachedSignature(keys, strings.NewReader(payload), strings.NewReader(signature), nil)
+		if err == pgperrors.ErrUnknownIssuer {
+			return nil, MakeGitError("the signing key is not in the keyring", ErrNotFound)
+		} else if err != nil {
+			return nil, MakeGitError(fmt.Sprintf("bad OpenPGP signature: %s", err.Error()), ErrInvalid)
+		}
+		return &SignatureVerification{
+			Signer: signer.PrimaryIdentity().Name,
+			KeyId:  fmt.Sprintf("%X", signer.PrimaryKey.Fingerprint),
+		}, nil
+	}, nil
+}
+
+// NewSSHVerifier creates the verifier of SSH signatures made by the keys of
+// the allowed signers file (gpg.ssh.allowedSignersFile). Entries that are
+// limited to other namespaces, that are not valid at the moment or that
+// are certificate authorities are not used. A signature by another key
+// fails with ErrNotFound.
+func NewSSHVerifier(allowedSigners io.Reader) (VerifierCallback, error) {
+	data, err := ioutil.ReadAll(allowedSigners)
+	if err != nil {
+		return nil, err
+	}
+	signers, err := parseAllowedSigners(data)
+	if err != nil {
+		return nil, err
+	}
+	return func(signature, payload string) (*SignatureVerification, error) {
+		key, err := verifySSHSignature(signature, payload)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		for _, signer := range signers {
+			if !bytes.Equal(signer.key.Marshal(), key.Marshal()) || !signer.usable(now) {
+				continue
+			}
+			return &SignatureVerification{
+				Signer: signer.principals,
+				KeyId:  ssh.FingerprintSHA256(key),
+			}, nil
+		}
+		return nil, MakeGitError(fmt.Sprintf("key %s is not an allowed signer", ssh.FingerprintSHA256(key)), ErrNotFound)
+	}, nil
+}
+
+// internal functions and types
+
+func readRawObject(repo *Repository, oid *Oid) ([]byte, error) {
+	odb, err := repo.Odb()
+	if err != nil {
+		return nil, err
+	}
+	obj, err := odb.Read(oid)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Release()
+	return append([]byte(nil), obj.Data...), nil
+}
+
+type allowedSigner struct {
+	principals    string
+	namespaces    []string
+	certAuthority bool
+	validAfter    time.Time
+	validBefore   time.Time
+	key           ssh.PublicKey
+}
+
+func (s *allowedSigner) usable(now time.Time) bool {
+	if s.certAuthority {
+		return false
+	}
+	if !s.validAfter.IsZero() && now.Before(s.validAfter) {
+		return false
+	}
+	if !s.validBefore.IsZero() && !now.Before(s.validBefore) {
+		return false
+	}
+	if s.namespaces == nil {
+		return true
+	}
+	for _, namespace := range s.namespaces {
+		if fnMatch(namespace, gitSSHSignatureNamespace, 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAllowedSigners parses the lines of "principals [options] key", the
+// format of ssh-keygen(1).
+func parseAllowedSigners(data []byte) ([]*allowedSigner, error) {
+	var signers []*allowedSigner
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := splitAllowedSignersLine(line)
+		if len(fields) < 3 {
+			return nil, MakeGitError(fmt.Sprintf("invalid allowed signers line %d", i+1), ErrInvalid)
+		}
+		signer := &allowedSigner{principals: fields[0]}
+		keyFields := fields[1:]
+		// options come before the key type
+		if !isSSHKeyType(keyFields[0]) {
+			err := signer.parseOptions(keyFields[0])
+			if err != nil {
+				return nil, MakeGitError(fmt.Sprintf("invalid allowed signers line %d: %s", i+1, err.Error()), ErrInvalid)
+			}
+			keyFields = keyFields[1:]
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.Join(keyFields, " ")))
+		if err != nil {
+			return nil, MakeGitError(fmt.Sprintf("invalid allowed signers line %d: %s", i+1, err.Error()), ErrInvalid)
+		}
+		signer.key = key
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+func isSSHKeyType(field string) bool {
+	return strings.HasPrefix(field, "ssh-") || strings.HasPrefix(field, "ecdsa-") || strings.HasPrefix(field, "sk-")
+}
+
+// splitAllowedSignersLine splits the line at spaces that are not quoted.
+func splitAllowedSignersLine(line string) []string {
+	var fields []string
+	var field bytes.Buffer
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+			field.WriteByte(c)
+		case (c == ' ' || c == '\t') && !quoted:
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+		default:
+			field.WriteByte(c)
+		}
+	}
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
+
+func (s *allowedSigner) parseOptions(options string) error {
+	for _, option := range splitSSHOptions(options) {
+		name, value := option, ""
+		if eq := strings.IndexByte(option, '='); eq != -1 {
+			name, value = option[:eq], strings.Trim(option[eq+1:], "\"")
+		}
+		var err error
+		switch strings.ToLower(name) {
+		case "cert-authority":
+			s.certAuthority = true
+		case "namespaces":
+			s.namespaces = strings.Split(value, ",")
+		case "valid-after":
+			s.validAfter, err = parseSSHTime(value)
+		case "valid-before":
+			s.validBefore, err = parseSSHTime(value)
+		default:
+			err = errors.New(fmt.Sprintf("unknown option '%s'", name))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitSSHOptions splits the options at commas that are not quoted.
+func splitSSHOptions(options string) []string {
+	var result []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(options); i++ {
+		switch options[i] {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				result = append(result, options[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(result, options[start:])
+}
+
+// parseSSHTime parses the time of valid-after and valid-before, which is
+// YYYYMMDD[HHMM[SS]] in the local time zone, or in UTC with a "Z" suffix.
+func parseSSHTime(value string) (time.Time, error) {
+	location := time.Local
+	if strings.HasSuffix(value, "Z") {
+		location = time.UTC
+		value = value[:len(value)-1]
+	}
+	for _, layout := range []string{"20060102", "200601021504", "20060102150405"} {
+		if len(value) == len(layout) {
+			return time.ParseInLocation(layout, value, location)
+		}
+	}
+	return time.Time{}, errors.New(fmt.Sprintf("invalid time '%s'", value))
+}
+
+// verifySSHSignature checks the armored SSH signature (PROTOCOL.sshsig of
+// OpenSSH) over the payload, and returns the key that made it.
+func verifySSHSignature(signature, payload string) (ssh.PublicKey, error) {
+	signature = strings.TrimSpace(signature)
+	if !strings.HasPrefix(signature, sshSignatureBegin) || !strings.HasSuffix(signature, sshSignatureEnd) {
+		return nil, MakeGitError("not an SSH signature", ErrInvalid)
+	}
+	armored := strings.Join(strings.Fields(signature[len(sshSignatureBegin):len(signature)-len(sshSignatureEnd)]), "")
+	blob, err := base64.StdEncoding.DecodeString(armored)
+	if err != nil || !bytes.HasPrefix(blob, []byte(sshSignatureMagic)) {
+		return nil, MakeGitError("corrupt SSH signature", ErrInvalid)
+	}
+	reader := bytes.NewReader(blob[len(sshSignatureMagic):])
+	var version uint32
+	if binary.Read(reader, binary.BigEndian, &version) != nil || version != 1 {
+		return nil, MakeGitError("unsupported SSH signature version", ErrInvalid)
+	}
+	var fields [5][]byte
+	for i := range fields {
+		fields[i], err = readSSHString(reader)
+		if err != nil {
+			return nil, MakeGitError("corrupt SSH signature", ErrInvalid)
+		}
+	}
+	publicKey, namespace, reserved, hashAlgorithm, rawSignature := fields[0], fields[1], fields[2], fields[3], fields[4]
+	if string(namespace) != gitSSHSignatureNamespace {
+		return nil, MakeGitError(fmt.Sprintf("SSH signature is for the namespace '%s'", namespace), ErrInvalid)
+	}
+	var digest []byte
+	switch string(hashAlgorithm) {
+	case "sha256":
+		sum := sha256.Sum256([]byte(payload))
+		digest = sum[:]
+	case "sha512":
+		sum := sha512.Sum512([]byte(payload))
+		digest = sum[:]
+	default:
+		return nil, MakeGitError(fmt.Sprintf("unsupported SSH signature hash '%s'", hashAlgorithm), ErrInvalid)
+	}
+	key, err := ssh.ParsePublicKey(publicKey)
+	if err != nil {
+		return nil, MakeGitError(fmt.Sprintf("corrupt SSH signature: %s", err.Error()), ErrInvalid)
+	}
+	sig := new(ssh.Signature)
+	if err := ssh.Unmarshal(rawSignature, sig); err != nil {
+		return nil, MakeGitError("corrupt SSH signature", ErrInvalid)
+	}
+	// ssh-keygen refuses the SHA-1 signatures of RSA keys too
+	if sig.Format == ssh.KeyAlgoRSA {
+		return nil, MakeGitError("SSH signatures of RSA keys with SHA-1 are not allowed", ErrInvalid)
+	}
+	var signed bytes.Buffer
+	signed.WriteString(sshSignatureMagic)
+	for _, field := range [][]byte{namespace, reserved, hashAlgorithm, digest} {
+		writeSSHString(&signed, field)
+	}
+	if err := key.Verify(signed.Bytes(), sig); err != nil {
+		return nil, MakeGitError(fmt.Sprintf("bad SSH signature: %s", err.Error()), ErrInvalid)
+	}
+	return key, nil
+}
+
+func readSSHString(reader *bytes.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if int64(length) > int64(reader.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(reader, data)
+	return data, err
+}
+
+func writeSSHString(buffer *bytes.Buffer, data []byte) {
+	binary.Write(buffer, binary.BigEndian, uint32(len(data)))
+	buffer.Write(data)
+}
@@ -0,0 +1,201 @@
+package git4go
+
+import (
+	"./testutil"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/base64"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createSignedCommit(t *testing.T, repo *Repository, refname string, sign SigningCallback) *Commit {
+	head, _ := repo.Head()
+	parent, _ := repo.LookupCommit(head.Target())
+	tree, _ := parent.Tree()
+	sig := &Signature{"Test User", "test@example.com", time.Unix(1400000000, 0).UTC()}
+	oid, err := repo.CreateCommitExtended(refname, sig, sig, "signed\n", tree, []*Commit{parent}, &CreateCommitOpts{
+		SigningCallback: sign,
+	})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	commit, _ := repo.LookupCommit(oid)
+	return commit
+}
+
+// signSSH makes the signature in the same way as "ssh-keygen -Y sign -n git".
+func signSSH(t *testing.T, signer ssh.Signer, payload string) string {
+	digest := sha512.Sum512([]byte(payload))
+	var signed, blob bytes.Buffer
+	signed.WriteString(sshSignatureMagic)
+	for _, field := range [][]byte{[]byte("git"), nil, []byte("sha512"), digest[:]} {
+		writeSSHString(&signed, field)
+	}
+	sig, err := signer.Sign(rand.Reader, signed.Bytes())
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	blob.WriteString(sshSignatureMagic)
+	blob.Write([]byte{0, 0, 0, 1})
+	for _, field := range [][]byte{signer.PublicKey().Marshal(), []byte("git"), nil, []byte("sha512"), ssh.Marshal(sig)} {
+		writeSSHString(&blob, field)
+	}
+	return sshSignatureBegin + "\n" + base64.StdEncoding.EncodeToString(blob.Bytes()) + "\n" + sshSignatureEnd + "\n"
+}
+
+func Test_VerifyCommitSignature_OpenPGP(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	entity, _ := openpgp.NewEntity("Test User", "", "test@example.com", nil)
+	stranger, _ := openpgp.NewEntity("Stranger", "", "stranger@example.com", nil)
+	var keyring bytes.Buffer
+	writer, _ := armor.Encode(&keyring, openpgp.PublicKeyType, nil)
+	entity.Serialize(writer)
+	writer.Close()
+	verifier, err := NewOpenPGPVerifier(&keyring)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+
+	sign := func(key *openpgp.Entity) SigningCallback {
+		return func(payload string) (string, string, error) {
+			var signature bytes.Buffer
+			err := openpgp.ArmoredDetachSign(&signature, key, strings.NewReader(payload), nil)
+			return signature.String(), "", err
+		}
+	}
+	commit := createSignedCommit(t, repo, "HEAD", sign(entity))
+	result, err := VerifyCommitSignature(commit, verifier)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if result.Signer != "Test User <test@example.com>" || result.KeyId == "" {
+		t.Error("it should return the signer:", result)
+	}
+
+	commit = createSignedCommit(t, repo, "HEAD", sign(stranger))
+	_, err = VerifyCommitSignature(commit, verifier)
+	if !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should not know the key:", err)
+	}
+	parent := commit.Parent(0)
+	if _, err := VerifyCommitSignature(parent.Parent(0), verifier); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should fail for the unsigned commit:", err)
+	}
+}
+
+// rsaSHA512Signer signs with rsa-sha2-512 like ssh-keygen, instead of the
+// ssh-rsa format that the RSA signers of x/crypto use.
+type rsaSHA512Signer struct {
+	ssh.AlgorithmSigner
+}
+
+func (s rsaSHA512Signer) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, ssh.KeyAlgoRSASHA512)
+}
+
+func Test_VerifyCommitSignature_SSH(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	_, private, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(private)
+	_, otherPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := ssh.NewSignerFromKey(otherPrivate)
+	key := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+	otherKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(otherSigner.PublicKey())))
+	allowed := "# allowed signers\n" +
+		"test@example.com namespaces=\"file,git\" " + key + " comment\n" +
+		"other@example.com namespaces=\"file\",valid-after=\"20150101\" " + otherKey + "\n"
+	verifier, err := NewSSHVerifier(strings.NewReader(allowed))
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+
+	var payload string
+	commit := createSignedCommit(t, repo, "HEAD", func(data string) (string, string, error) {
+		payload = data
+		return signSSH(t, signer, data), "", nil
+	})
+	result, err := VerifyCommitSignature(commit, verifier)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if result.Signer != "test@example.com" || result.KeyId != ssh.FingerprintSHA256(signer.PublicKey()) {
+		t.Error("it should return the principal:", result)
+	}
+	signature, extracted, _ := commit.ExtractSignature()
+	if extracted != payload || !strings.HasPrefix(signature, sshSignatureBegin) {
+		t.Error("it should extract the signed payload")
+	}
+	if _, err := verifier(signature, payload+"tampered"); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should detect the modified payload:", err)
+	}
+
+	commit = createSignedCommit(t, repo, "HEAD", func(data string) (string, string, error) {
+		return signSSH(t, otherSigner, data), "", nil
+	})
+	if _, err := VerifyCommitSignature(commit, verifier); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should not allow the key outside of its namespaces:", err)
+	}
+
+	// RSA keys sign with SHA-1 unless they are asked for rsa-sha2-*
+	rsaPrivate, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaSigner, _ := ssh.NewSignerFromKey(rsaPrivate)
+	rsaKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(rsaSigner.PublicKey())))
+	verifier, _ = NewSSHVerifier(strings.NewReader("test@example.com " + rsaKey + "\n"))
+	if _, err := verifier(signSSH(t, rsaSigner, payload), payload); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should reject the ssh-rsa signature format:", err)
+	}
+	if _, err := verifier(signSSH(t, rsaSHA512Signer{rsaSigner.(ssh.AlgorithmSigner)}, payload), payload); err != nil {
+		t.Error("it should accept the rsa-sha2-512 signature format:", err)
+	}
+
+	if _, err := NewSSHVerifier(strings.NewReader("broken\n")); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should reject the broken allowed signers file:", err)
+	}
+}
+
+func Test_VerifyTagSignature(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	_, private, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(private)
+	verifier, _ := NewSSHVerifier(bytes.NewReader(append([]byte("release@example.com "), ssh.MarshalAuthorizedKey(signer.PublicKey())...)))
+
+	head, _ := repo.Head()
+	target, _ := repo.LookupCommit(head.Target())
+	sig := &Signature{"Test User", "test@example.com", time.Unix(1400000000, 0).UTC()}
+	oid, err := repo.CreateTagExtended("signed", target, sig, "release\n", false, &CreateTagOpts{
+		SigningCallback: func(payload string) (string, string, error) {
+			return signSSH(t, signer, payload), "", nil
+		},
+	})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	tag, _ := repo.LookupTag(oid)
+	result, err := VerifyTagSignature(tag, verifier)
+	if err != nil || result.Signer != "release@example.com" {
+		t.Error("it should verify the tag:", result, err)
+	}
+	oid, _ = repo.CreateTag("unsigned", target, sig, "release\n", false)
+	tag, _ = repo.LookupTag(oid)
+	if _, err := VerifyTagSignature(tag, verifier); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should fail for the unsigned tag:", err)
+	}
+}
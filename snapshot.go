@@ -0,0 +1,157 @@
+package git4go
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// SnapshotWorkdirToTree writes the files of the working directory that the
+// pathspecs match as a tree, like "git add -A" and "git write-tree" in a new
+// repository, and returns the id of the tree. The blobs and the trees are
+// written to the object database directly: the index is neither read nor
+// changed, so the ignore rules apply to all files, tracked ones included.
+// The blobs are hashed on a pool of goroutines. If filters is true, the
+// files are converted by .gitattributes and core.autocrlf like "git add"
+// does; otherwise they are stored as they are.
+//
+// Empty directories are left out, and other repositories in the working
+// directory are stored as submodules of the commit of their HEAD.
+func (r *Repository) SnapshotWorkdirToTree(pathspecs []string, filters bool) (*Oid, error) {
+	if r.IsBare() {
+		return nil, MakeGitError("cannot snapshot the working directory of a bare repository", ErrBareRepository)
+	}
+	specs, err := compilePathspecs(pathspecs)
+	if err != nil {
+		return nil, err
+	}
+	walk := &snapshotWalk{specs: specs, filemode: true, ignoreCase: r.ignoreCase(), precompose: r.precomposeUnicode()}
+	if config := r.Config(); config != nil {
+		walk.filemode, _ = config.LookupBooleanWithDefaultValue("core.filemode")
+	}
+	ignores, err := r.repositoryIgnoreFiles()
+	if err != nil {
+		return nil, err
+	}
+	if err = r.snapshotDir(walk, "", ignores); err != nil {
+		return nil, err
+	}
+	entries := walk.entries
+
+	var sources []*HashSource
+	var blobs []*IndexEntry
+	for _, entry := range entries {
+		if entry.Mode == FilemodeCommit {
+			continue
+		}
+		source := &HashSource{Path: entry.Path}
+		if entry.Mode == FilemodeLink {
+			// the target of the link is stored without filters
+			target, err := r.fs.Readlink(filepath.Join(r.Workdir(), filepath.FromSlash(entry.Path)))
+			if err != nil {
+				return nil, err
+			}
+			source = &HashSource{Reader: strings.NewReader(target)}
+		}
+		sources = append(sources, source)
+		blobs = append(blobs, entry)
+	}
+	oids, err := r.HashObjects(sources, &HashObjectsOptions{Write: true, NoFilters: !filters})
+	if err != nil {
+		return nil, err
+	}
+	for i, oid := range oids {
+		blobs[i].Id = oid
+	}
+
+	index, err := NewIndex()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if err = index.Add(entry); err != nil {
+			return nil, err
+		}
+	}
+	return index.WriteTreeTo(r)
+}
+
+// internal functions and methods
+
+// snapshotWalk is the state of the walk of SnapshotWorkdirToTree.
+type snapshotWalk struct {
+	specs      pathspecs
+	filemode   bool
+	ignoreCase bool
+	precompose bool
+	entries    []*IndexEntry
+}
+
+// snapshotDir collects the files of the directory that are not ignored. The
+// ignore files of the parent directories are passed down, so each
+// .gitignore is read once, and directories that no pathspec can match are
+// not read at all. The ids of blobs are set later.
+func (r *Repository) snapshotDir(walk *snapshotWalk, dir string, ignores []*attrFile) error {
+	fullDir := filepath.Join(r.Workdir(), filepath.FromSlash(dir))
+	ignoreFile, err := readAttrFile(r.fs, filepath.Join(fullDir, GitIgnoreFile), dir+GitIgnoreFile, dir)
+	if err != nil {
+		return err
+	}
+	if ignoreFile != nil {
+		// the slice is shared by the siblings of the directory
+		ignores = append(ignores[:len(ignores):len(ignores)], ignoreFile)
+	}
+	dirEntries, err := r.fs.ReadDir(fullDir)
+	if err != nil {
+		return err
+	}
+	for _, dirEntry := range dirEntries {
+		if dirEntry.Name() == GitDirName {
+			continue
+		}
+		path := dir + precomposePath(dirEntry.Name(), walk.precompose)
+		// the parents are not ignored, otherwise they would not be read
+		if match := matchIgnoreFiles(ignores, path, dirEntry.IsDir(), walk.ignoreCase); match != nil && match.Ignored {
+			continue
+		}
+		fullPath := filepath.Join(fullDir, dirEntry.Name())
+		if dirEntry.IsDir() {
+			if _, err := r.fs.Lstat(filepath.Join(fullPath, GitDirName)); err != nil {
+				if !walk.specs.mayMatchBelow(path) {
+					continue
+				}
+				if err = r.snapshotDir(walk, path+"/", ignores); err != nil {
+					return err
+				}
+				continue
+			}
+			if !walk.specs.match(path) {
+				continue
+			}
+			// repositories without commits are left out like git does
+			if sub, err := OpenRepository(fullPath); err == nil {
+				if head, err := sub.Head(); err == nil && head.Target() != nil {
+					walk.entries = append(walk.entries, &IndexEntry{Path: path, Mode: FilemodeCommit, Id: head.Target()})
+				}
+			}
+			continue
+		}
+		if !walk.specs.match(path) {
+			continue
+		}
+		stat, err := r.fs.Lstat(fullPath)
+		if err != nil {
+			return err
+		}
+		mode := FilemodeBlob
+		if isSymlinkMode(stat.Mode()) {
+			mode = FilemodeLink
+		} else if !stat.Mode().IsRegular() {
+			// sockets and fifos can't be stored
+			continue
+		} else if walk.filemode && stat.Mode()&0111 != 0 {
+			mode = FilemodeBlobExecutable
+		}
+		walk.entries = append(walk.entries, &IndexEntry{Path: path, Mode: mode})
+	}
+	return nil
+}
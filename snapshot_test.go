@@ -0,0 +1,142 @@
+package git4go
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func snapshotFiles(t *testing.T, repo *Repository, treeId *Oid) map[string]*TreeEntry {
+	tree, err := repo.LookupTree(treeId)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	files := make(map[string]*TreeEntry)
+	tree.Walk(func(root string, entry *TreeEntry) int {
+		if entry.Type != ObjectTree {
+			files[path.Join(root, entry.Name)] = entry
+		}
+		return 0
+	})
+	return files
+}
+
+func Test_SnapshotWorkdirToTree(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_snapshot")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	repo.Config().SetString("core.autocrlf", "true")
+	os.MkdirAll(filepath.Join(dir, "bin"), 0755)
+	os.MkdirAll(filepath.Join(dir, "build"), 0755)
+	os.MkdirAll(filepath.Join(dir, "empty"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, ".gitignore"), []byte("*.o\nbuild/\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a\r\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "bin", "run.sh"), []byte("#!/bin/sh\n"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "build", "out"), []byte("out\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "x.o"), []byte("x\n"), 0644)
+	os.Symlink("a.txt", filepath.Join(dir, "link"))
+
+	sub, _ := InitRepositoryExtended(filepath.Join(dir, "sub"), &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	subHead := writeMergeCommit(sub, map[string]string{"s.txt": "s\n"})
+	sub.CreateReference("refs/heads/master", subHead.Id(), true)
+
+	treeId, err := repo.SnapshotWorkdirToTree(nil, true)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	files := snapshotFiles(t, repo, treeId)
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	expected := []string{".gitignore", "a.txt", "bin/run.sh", "link", "sub"}
+	if len(paths) != len(expected) {
+		t.Fatal("it should store the files that are not ignored:", paths)
+	}
+	for i := range expected {
+		if paths[i] != expected[i] {
+			t.Fatal("it should store the files that are not ignored:", paths)
+		}
+	}
+	lf, _ := hash([]byte("a\n"), ObjectBlob)
+	if !files["a.txt"].Id.Equal(lf) || files["a.txt"].Filemode != FilemodeBlob {
+		t.Error("it should store the files with the filters")
+	}
+	if files["bin/run.sh"].Filemode != FilemodeBlobExecutable {
+		t.Error("it should store the executable bit:", files["bin/run.sh"].Filemode)
+	}
+	if link, err := repo.LookupBlob(files["link"].Id); err != nil || files["link"].Filemode != FilemodeLink || string(link.Contents()) != "a.txt" {
+		t.Error("it should store the target of the link:", err)
+	}
+	if files["sub"].Filemode != FilemodeCommit || !files["sub"].Id.Equal(subHead.Id()) {
+		t.Error("it should store other repositories as submodules")
+	}
+	if index, _ := repo.Index(); index.EntryCount() != 0 {
+		t.Error("it should not change the index:", index.EntryCount())
+	}
+
+	treeId, _ = repo.SnapshotWorkdirToTree(nil, false)
+	crlf, _ := hash([]byte("a\r\n"), ObjectBlob)
+	if files = snapshotFiles(t, repo, treeId); !files["a.txt"].Id.Equal(crlf) {
+		t.Error("it should store the files as they are without the filters")
+	}
+	treeId, _ = repo.SnapshotWorkdirToTree([]string{"bin", "*.txt"}, true)
+	if files = snapshotFiles(t, repo, treeId); len(files) != 2 || files["bin/run.sh"] == nil || files["a.txt"] == nil {
+		t.Error("it should store the files that the pathspecs match:", len(files))
+	}
+}
+
+// readCountingFileSystem counts the reads of the files and the directories.
+type readCountingFileSystem struct {
+	FileSystem
+	reads map[string]int
+}
+
+func (c *readCountingFileSystem) ReadFile(name string) ([]byte, error) {
+	c.reads[filepath.ToSlash(name)]++
+	return c.FileSystem.ReadFile(name)
+}
+
+func (c *readCountingFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	c.reads[filepath.ToSlash(name)+"/"]++
+	return c.FileSystem.ReadDir(name)
+}
+
+func Test_SnapshotWorkdirToTree_Ignores(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_snapshot")
+	defer os.RemoveAll(dir)
+	InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	os.MkdirAll(filepath.Join(dir, "sub", "deep"), 0755)
+	os.MkdirAll(filepath.Join(dir, "other"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, ".gitignore"), []byte("*.log\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "sub", ".gitignore"), []byte("!keep.log\n"), 0644)
+	for _, name := range []string{"sub/a.log", "sub/keep.log", "sub/b.txt", "sub/deep/c.txt", "sub/deep/d.log", "other/e.txt"} {
+		ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(name), 0644)
+	}
+
+	fsys := &readCountingFileSystem{FileSystem: OSFileSystem, reads: make(map[string]int)}
+	repo, err := OpenRepositoryWithFileSystem(dir, fsys, GIT_REPOSITORY_OPEN_NO_SEARCH)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	treeId, err := repo.SnapshotWorkdirToTree([]string{"sub"}, true)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	files := snapshotFiles(t, repo, treeId)
+	if len(files) != 4 || files["sub/.gitignore"] == nil || files["sub/keep.log"] == nil || files["sub/b.txt"] == nil || files["sub/deep/c.txt"] == nil {
+		t.Error("it should apply the ignore files of each directory:", len(files))
+	}
+	root := filepath.ToSlash(dir)
+	if fsys.reads[root+"/.gitignore"] != 1 || fsys.reads[root+"/sub/.gitignore"] != 1 {
+		t.Error("it should read each ignore file once:", fsys.reads[root+"/.gitignore"], fsys.reads[root+"/sub/.gitignore"])
+	}
+	if fsys.reads[root+"/other/"] != 0 {
+		t.Error("it should not read the directories that the pathspecs exclude")
+	}
+}
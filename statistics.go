@@ -0,0 +1,234 @@
+package git4go
+
+import (
+	"sort"
+	"strings"
+)
+
+const defaultStatisticsLargestBlobs = 10
+
+type StatisticsOptions struct {
+	// The number of the largest blobs to report. 0 means 10 and a
+	// negative value turns the report off.
+	LargestBlobs int
+}
+
+// ObjectStatistics is the number and the sizes of the objects of one type.
+// Size is the uncompressed size and DiskSize is the size that is stored in
+// loose files and packfiles.
+type ObjectStatistics struct {
+	Count    int
+	Size     uint64
+	DiskSize uint64
+}
+
+// BlobStatistics is one of the largest blobs. Path is the first path that
+// is found for it in the commits, or empty if no commit has the blob.
+type BlobStatistics struct {
+	Id   *Oid
+	Size uint64
+	Path string
+}
+
+// RepositoryStatistics is the data behind tools like git-sizer.
+type RepositoryStatistics struct {
+	Commits ObjectStatistics
+	Trees   ObjectStatistics
+	Blobs   ObjectStatistics
+	Tags    ObjectStatistics
+
+	// Objects that are stored both loose and packed are counted once, as
+	// packed objects.
+	LooseObjects  ObjectStatistics
+	PackedObjects ObjectStatistics
+	PackFiles     int
+
+	// The largest blobs, the largest one first
+	LargestBlobs []*BlobStatistics
+
+	References      int
+	Branches        int
+	RemoteBranches  int
+	TagReferences   int
+	OtherReferences int
+}
+
+// Statistics counts the objects and references of the repository. The
+// object database is read in one pass of Odb.ForEachExt, which reads only
+// the headers of the objects; then the trees of the commits are walked
+// until the paths of the largest blobs are found.
+func (r *Repository) Statistics(opts *StatisticsOptions) (*RepositoryStatistics, error) {
+	largestCount := defaultStatisticsLargestBlobs
+	if opts != nil && opts.LargestBlobs != 0 {
+		largestCount = opts.LargestBlobs
+	}
+	odb, err := r.Odb()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &RepositoryStatistics{}
+	objects := make(map[Oid]*OdbObjectInfo)
+	packFiles := make(map[string]bool)
+	var commits []*Oid
+	err = odb.ForEachExt(OdbForEachHeader|OdbForEachDiskSize, func(info *OdbObjectInfo) error {
+		if info.PackFile != "" {
+			packFiles[info.PackFile] = true
+		}
+		if found, ok := objects[*info.Id]; ok {
+			if found.PackFile == "" && info.PackFile != "" {
+				// count it as a packed object
+				stats.LooseObjects.remove(found)
+				stats.PackedObjects.add(info)
+				objects[*info.Id] = info
+			}
+			return nil
+		}
+		objects[*info.Id] = info
+		if info.PackFile != "" {
+			stats.PackedObjects.add(info)
+		} else {
+			stats.LooseObjects.add(info)
+		}
+		if info.Type == ObjectCommit {
+			commits = append(commits, info.Id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats.PackFiles = len(packFiles)
+
+	var largest []*BlobStatistics
+	for _, info := range objects {
+		switch info.Type {
+		case ObjectCommit:
+			stats.Commits.add(info)
+		case ObjectTree:
+			stats.Trees.add(info)
+		case ObjectBlob:
+			stats.Blobs.add(info)
+			if largestCount > 0 {
+				largest = addLargestBlob(largest, info, largestCount)
+			}
+		case ObjectTag:
+			stats.Tags.add(info)
+		}
+	}
+	if len(largest) > 0 {
+		err = r.findBlobPaths(commits, largest)
+		if err != nil {
+			return nil, err
+		}
+	}
+	stats.LargestBlobs = largest
+
+	err = r.ForEachReferenceName(func(name string) error {
+		stats.References++
+		switch {
+		case strings.HasPrefix(name, GitRefsHeadsDir):
+			stats.Branches++
+		case strings.HasPrefix(name, GitRefsRemotesDir):
+			stats.RemoteBranches++
+		case strings.HasPrefix(name, GitRefsTagsDir+"/"):
+			stats.TagReferences++
+		default:
+			stats.OtherReferences++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// internal functions and methods
+
+func (s *ObjectStatistics) add(info *OdbObjectInfo) {
+	s.Count++
+	s.Size += info.Size
+	s.DiskSize += info.DiskSize
+}
+
+func (s *ObjectStatistics) remove(info *OdbObjectInfo) {
+	s.Count--
+	s.Size -= info.Size
+	s.DiskSize -= info.DiskSize
+}
+
+// addLargestBlob keeps the count largest blobs sorted by size. Blobs of the
+// same size are sorted by id, so the result does not depend on the order of
+// the object database.
+func addLargestBlob(largest []*BlobStatistics, info *OdbObjectInfo, count int) []*BlobStatistics {
+	less := func(i int) bool {
+		if largest[i].Size != info.Size {
+			return largest[i].Size < info.Size
+		}
+		return info.Id.Cmp(largest[i].Id) < 0
+	}
+	i := sort.Search(len(largest), less)
+	if i == count {
+		return largest
+	}
+	if len(largest) < count {
+		largest = append(largest, nil)
+	}
+	copy(largest[i+1:], largest[i:])
+	largest[i] = &BlobStatistics{Id: info.Id, Size: info.Size}
+	return largest
+}
+
+// findBlobPaths walks the trees of the commits until the paths of all blobs
+// are found. Each tree is read only once and missing trees are skipped.
+func (r *Repository) findBlobPaths(commits []*Oid, blobs []*BlobStatistics) error {
+	wanted := make(map[Oid]*BlobStatistics, len(blobs))
+	for _, blob := range blobs {
+		wanted[*blob.Id] = blob
+	}
+	visited := make(map[Oid]bool)
+	var walk func(treeId *Oid, prefix string) error
+	walk = func(treeId *Oid, prefix string) error {
+		if visited[*treeId] {
+			return nil
+		}
+		visited[*treeId] = true
+		tree, err := r.LookupTree(treeId)
+		if IsErrorCode(err, ErrNotFound) {
+			// e.g. the empty tree, or a partial clone
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for _, entry := range tree.Entries {
+			switch entry.Type {
+			case ObjectTree:
+				err = walk(entry.Id, prefix+entry.Name+"/")
+				if err != nil {
+					return err
+				}
+			case ObjectBlob:
+				if blob, ok := wanted[*entry.Id]; ok {
+					blob.Path = prefix + entry.Name
+					delete(wanted, *entry.Id)
+				}
+			}
+			if len(wanted) == 0 {
+				return StopIteration
+			}
+		}
+		return nil
+	}
+	for _, commitId := range commits {
+		commit, err := r.LookupCommit(commitId)
+		if err != nil {
+			return err
+		}
+		err = walk(commit.TreeId(), "")
+		if err != nil {
+			return iterationResult(err)
+		}
+	}
+	return nil
+}
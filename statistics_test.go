@@ -0,0 +1,65 @@
+package git4go
+
+import (
+	"./testutil"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_Repository_Statistics(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "git4go_statistics")
+	defer os.RemoveAll(dir)
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	first := writeMergeCommit(repo, map[string]string{
+		"small.txt":   "a\n",
+		"dir/big.txt": strings.Repeat("big\n", 100),
+	})
+	second := writeMergeCommit(repo, map[string]string{
+		"small.txt":     "a\n",
+		"dir/big.txt":   strings.Repeat("big\n", 100),
+		"sub/large.txt": strings.Repeat("large\n", 50),
+	}, first)
+	repo.CreateReference("refs/heads/master", second.Id(), true)
+	repo.CreateReference("refs/tags/v1", first.Id(), true)
+	repo.CreateReference("refs/remotes/origin/master", first.Id(), true)
+
+	stats, err := repo.Statistics(&StatisticsOptions{LargestBlobs: 2})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if stats.Commits.Count != 2 || stats.Trees.Count != 4 || stats.Blobs.Count != 3 || stats.Tags.Count != 0 {
+		t.Error("it should count the objects by type:", stats.Commits.Count, stats.Trees.Count, stats.Blobs.Count)
+	}
+	if stats.Blobs.Size != 2+400+300 || stats.Blobs.DiskSize == 0 {
+		t.Error("it should sum the sizes of the blobs:", stats.Blobs.Size, stats.Blobs.DiskSize)
+	}
+	if stats.LooseObjects.Count != 9 || stats.PackedObjects.Count != 0 || stats.PackFiles != 0 {
+		t.Error("it should count the loose objects:", stats.LooseObjects.Count)
+	}
+	if len(stats.LargestBlobs) != 2 || stats.LargestBlobs[0].Path != "dir/big.txt" || stats.LargestBlobs[0].Size != 400 || stats.LargestBlobs[1].Path != "sub/large.txt" {
+		t.Error("it should report the largest blobs with their paths:", stats.LargestBlobs)
+	}
+	if stats.References != 3 || stats.Branches != 1 || stats.TagReferences != 1 || stats.RemoteBranches != 1 || stats.OtherReferences != 0 {
+		t.Error("it should count the references:", stats.References)
+	}
+}
+
+func Test_Repository_Statistics_Packed(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+
+	stats, err := repo.Statistics(nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	total := stats.Commits.Count + stats.Trees.Count + stats.Blobs.Count + stats.Tags.Count
+	if stats.PackedObjects.Count == 0 || stats.PackFiles == 0 || stats.PackedObjects.Count+stats.LooseObjects.Count != total {
+		t.Error("it should count each object once:", stats.PackedObjects.Count, stats.LooseObjects.Count, total)
+	}
+	if len(stats.LargestBlobs) != 10 || stats.LargestBlobs[0].Size < stats.LargestBlobs[9].Size {
+		t.Error("it should report the largest blobs:", len(stats.LargestBlobs))
+	}
+}
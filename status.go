@@ -0,0 +1,616 @@
+package git4go
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Status is the state of a file in the index and in the working directory
+// as the flags of "git status".
+type Status uint32
+
+const (
+	StatusCurrent         Status = 0
+	StatusIndexNew        Status = 1 << 0
+	StatusIndexModified   Status = 1 << 1
+	StatusIndexDeleted    Status = 1 << 2
+	StatusIndexRenamed    Status = 1 << 3
+	StatusIndexTypeChange Status = 1 << 4
+	StatusWtNew           Status = 1 << 7
+	StatusWtModified      Status = 1 << 8
+	StatusWtDeleted       Status = 1 << 9
+	StatusWtTypeChange    Status = 1 << 10
+	StatusWtRenamed       Status = 1 << 11
+	StatusIgnored         Status = 1 << 14
+	StatusConflicted      Status = 1 << 15
+)
+
+type StatusOption uint32
+
+const (
+	StatusOptionIncludeUntracked  StatusOption = 1 << 0
+	StatusOptionIncludeIgnored    StatusOption = 1 << 1
+	StatusOptionIncludeUnmodified StatusOption = 1 << 2
+	// Untracked directories are listed file by file instead of as "dir/"
+	StatusOptionRecurseUntrackedDirs StatusOption = 1 << 4
+	// Deleted and added files of the index are paired up as renames
+	StatusOptionRenamesHeadToIndex StatusOption = 1 << 7
+	// Deleted and untracked files of the working directory are paired up
+	// as renames
+	StatusOptionRenamesIndexToWorkdir StatusOption = 1 << 8
+)
+
+const defaultRenameThreshold = 50
+
+type StatusOptions struct {
+	Flags StatusOption
+	// The similarity in percent that a pair of files needs to be a rename.
+	// 0 means 50, the default of git.
+	RenameThreshold int
+	// The number of goroutines that check the tracked files. 0 uses the
+	// number of CPUs. Fewer files than checkout.thresholdForParallelism
+	// (100 by default) are checked on one goroutine.
+	Workers int
+}
+
+// StatusDelta is a change between HEAD and the index, or between the index
+// and the working directory. The path, the mode and the id of the missing
+// side are empty. Similarity is set for renames.
+type StatusDelta struct {
+	Status     Delta
+	OldPath    string
+	NewPath    string
+	OldMode    Filemode
+	NewMode    Filemode
+	OldId      *Oid
+	NewId      *Oid
+	Similarity int
+	submodule  SubmoduleStatus
+}
+
+// StatusEntry is a file of "git status". The deltas are nil if the file
+// has no change on that side.
+type StatusEntry struct {
+	Status         Status
+	HeadToIndex    *StatusDelta
+	IndexToWorkdir *StatusDelta
+	// The changes of the working directory of a submodule, like new
+	// commits or untracked files, that make the gitlink modified
+	Submodule SubmoduleStatus
+	path      string
+}
+
+// Path returns the path of the file in the index, or in the working
+// directory for untracked and ignored files.
+func (e *StatusEntry) Path() string {
+	return e.path
+}
+
+// StatusList returns the changed files sorted by path, like "git status".
+// Untracked directories are returned as one entry whose path ends with "/"
+// unless StatusOptionRecurseUntrackedDirs is set. A submodule with new
+// commits, changes or untracked files is modified in the working directory
+// as submodule.<name>.ignore allows, and the changes are in Submodule.
+func (r *Repository) StatusList(opts *StatusOptions) ([]*StatusEntry, error) {
+	if r.IsBare() {
+		return nil, MakeGitError("cannot get the status of a bare repository", ErrBareRepository)
+	}
+	if opts == nil {
+		opts = &StatusOptions{Flags: StatusOptionIncludeUntracked}
+	}
+	threshold := opts.RenameThreshold
+	if threshold == 0 {
+		threshold = defaultRenameThreshold
+	}
+	var headTree *Tree
+	head, err := r.headCommit()
+	if err == nil {
+		headTree, err = head.Tree()
+	} else if IsErrorCode(err, ErrNotFound) {
+		// the branch is unborn
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	index, err := r.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	headToIndex, err := r.statusHeadToIndex(headTree, index)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Flags&StatusOptionRenamesHeadToIndex != 0 {
+		headToIndex, err = r.detectStatusRenames(headToIndex, threshold, func(delta *StatusDelta) ([]byte, error) {
+			return r.blobContent(delta.NewId)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	indexToWorkdir, err := r.statusIndexToWorkdir(index, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Flags&StatusOptionRenamesIndexToWorkdir != 0 {
+		indexToWorkdir, err = r.detectStatusRenames(indexToWorkdir, threshold, func(delta *StatusDelta) ([]byte, error) {
+			return r.workdirContent(delta.NewPath)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.Flags&StatusOptionRecurseUntrackedDirs == 0 {
+		indexToWorkdir = collapseUntrackedDirs(indexToWorkdir, index)
+	}
+
+	entries := make(map[string]*StatusEntry)
+	entry := func(path string) *StatusEntry {
+		result, ok := entries[path]
+		if !ok {
+			result = &StatusEntry{path: path}
+			entries[path] = result
+		}
+		return result
+	}
+	for _, delta := range headToIndex {
+		result := entry(delta.path())
+		result.HeadToIndex = delta
+		result.Status |= headToIndexStatus[delta.Status]
+	}
+	for _, delta := range indexToWorkdir {
+		result := entry(delta.indexPath())
+		result.IndexToWorkdir = delta
+		result.Status |= indexToWorkdirStatus[delta.Status]
+		result.Submodule = delta.submodule
+	}
+	if opts.Flags&StatusOptionIncludeUnmodified != 0 {
+		for _, indexEntry := range index.Entries {
+			entry(indexEntry.Path)
+		}
+	}
+	paths := make([]string, 0, len(entries))
+	for path := range entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	result := make([]*StatusEntry, len(paths))
+	for i, path := range paths {
+		result[i] = entries[path]
+	}
+	return result, nil
+}
+
+// internal functions and methods
+
+var headToIndexStatus = map[Delta]Status{
+	DeltaAdded:      StatusIndexNew,
+	DeltaDeleted:    StatusIndexDeleted,
+	DeltaModified:   StatusIndexModified,
+	DeltaRenamed:    StatusIndexRenamed,
+	DeltaTypeChange: StatusIndexTypeChange,
+	DeltaConflicted: StatusConflicted,
+}
+
+var indexToWorkdirStatus = map[Delta]Status{
+	DeltaUntracked:  StatusWtNew,
+	DeltaDeleted:    StatusWtDeleted,
+	DeltaModified:   StatusWtModified,
+	DeltaRenamed:    StatusWtRenamed,
+	DeltaTypeChange: StatusWtTypeChange,
+	DeltaIgnored:    StatusIgnored,
+}
+
+// path returns the path of the delta in the newer side.
+func (d *StatusDelta) path() string {
+	if d.NewPath != "" {
+		return d.NewPath
+	}
+	return d.OldPath
+}
+
+// indexPath returns the path of the delta in the older side, the index,
+// or the path of the untracked file.
+func (d *StatusDelta) indexPath() string {
+	if d.OldPath != "" {
+		return d.OldPath
+	}
+	return d.NewPath
+}
+
+func (r *Repository) statusHeadToIndex(headTree *Tree, index *Index) ([]*StatusDelta, error) {
+	iter, err := r.NewTreeIndexDiffIterator(headTree, index)
+	if err != nil {
+		return nil, err
+	}
+	var deltas []*StatusDelta
+	for {
+		change, err := iter.Next()
+		if IsErrorCode(err, ErrIterOver) {
+			return deltas, nil
+		} else if err != nil {
+			return nil, err
+		}
+		delta := &StatusDelta{
+			Status:  change.Status,
+			OldMode: change.OldMode,
+			NewMode: change.NewMode,
+			OldId:   change.OldId,
+			NewId:   change.NewId,
+		}
+		if change.OldId != nil {
+			delta.OldPath = change.Path
+		}
+		if change.NewId != nil || change.Status == DeltaConflicted {
+			delta.NewPath = change.Path
+		}
+		deltas = append(deltas, delta)
+	}
+}
+
+func (r *Repository) statusIndexToWorkdir(index *Index, opts *StatusOptions) ([]*StatusDelta, error) {
+	filemode := true
+	if config := r.Config(); config != nil {
+		filemode, _ = config.LookupBooleanWithDefaultValue("core.filemode")
+	}
+	tracked := make(map[string]bool)
+	var entries, gitlinks []*IndexEntry
+	for _, entry := range index.Entries {
+		tracked[entry.Path] = true
+		for dir := filepath.ToSlash(filepath.Dir(entry.Path)); dir != "."; dir = filepath.ToSlash(filepath.Dir(dir)) {
+			tracked[dir+"/"] = true
+		}
+		// conflicts are reported by the HEAD to index side. Like in git,
+		// the files with the skip-worktree or assume-unchanged flag are
+		// not checked, so they are neither modified nor deleted.
+		if entry.Stage() != 0 || entry.SkipWorktree() || entry.AssumeUnchanged() {
+			continue
+		}
+		if entry.Mode == FilemodeCommit {
+			gitlinks = append(gitlinks, entry)
+		} else {
+			entries = append(entries, entry)
+		}
+	}
+
+	// the files are checked in parallel, and collected in the index order
+	workers := r.parallelWorkers(opts.Workers, "", len(entries))
+	perfdatas := make([]CheckoutPerfdata, workers)
+	changes := make([]*StatusDelta, len(entries))
+	err := parallelFor(workers, len(entries), func(worker, i int) error {
+		var err error
+		changes[i], err = r.statusWorkdirDelta(entries[i], filemode, &perfdatas[worker])
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(gitlinks) > 0 {
+		submodules, err := r.submodulesByPath()
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range gitlinks {
+			delta, err := r.statusSubmoduleDelta(entry, submodules)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, delta)
+		}
+	}
+	var deltas []*StatusDelta
+	for _, delta := range changes {
+		if delta != nil {
+			deltas = append(deltas, delta)
+		}
+	}
+	if opts.Flags&(StatusOptionIncludeUntracked|StatusOptionIncludeIgnored) == 0 {
+		return deltas, nil
+	}
+	untracked, err := r.statusUntracked("", tracked, opts.Flags)
+	if err != nil {
+		return nil, err
+	}
+	return append(deltas, untracked...), nil
+}
+
+// statusWorkdirDelta compares the file in the working directory with the
+// index entry. It returns nil if they are the same.
+func (r *Repository) statusWorkdirDelta(entry *IndexEntry, filemode bool, perfdata *CheckoutPerfdata) (*StatusDelta, error) {
+	perfdata.StatCalls++
+	stat, err := r.fs.Lstat(filepath.Join(r.Workdir(), filepath.FromSlash(entry.Path)))
+	if os.IsNotExist(err) || (err == nil && stat.IsDir()) {
+		return &StatusDelta{Status: DeltaDeleted, OldPath: entry.Path, OldMode: entry.Mode, OldId: entry.Id}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	delta := &StatusDelta{OldPath: entry.Path, NewPath: entry.Path, OldMode: entry.Mode, OldId: entry.Id, NewMode: entry.Mode}
+	if isSymlinkMode(stat.Mode()) != (entry.Mode == FilemodeLink) {
+		delta.Status = DeltaTypeChange
+		if entry.Mode == FilemodeLink {
+			delta.NewMode = FilemodeBlob
+		} else {
+			delta.NewMode = FilemodeLink
+		}
+		return delta, nil
+	}
+	if filemode && entry.Mode != FilemodeLink {
+		if stat.Mode()&0111 != 0 {
+			delta.NewMode = FilemodeBlobExecutable
+		} else {
+			delta.NewMode = FilemodeBlob
+		}
+	}
+	_, matches, err := r.workdirMatches(entry.Path, entry, true, perfdata)
+	if err != nil {
+		return nil, err
+	}
+	if matches && delta.NewMode == delta.OldMode {
+		return nil, nil
+	}
+	delta.Status = DeltaModified
+	return delta, nil
+}
+
+// statusSubmoduleDelta compares the gitlink with the repository of the
+// submodule by submodule.<name>.ignore. The gitlinks that are not in
+// .gitmodules are checked like submodules without settings. It returns nil
+// if the submodule is not changed or not checked out.
+func (r *Repository) statusSubmoduleDelta(entry *IndexEntry, submodules map[string]*Submodule) (*StatusDelta, error) {
+	submodule := submodules[entry.Path]
+	if submodule == nil {
+		submodule = &Submodule{repo: r, name: entry.Path, path: entry.Path, ignore: SubmoduleIgnoreNone}
+	}
+	status, err := submodule.Status(SubmoduleIgnoreUnspecified)
+	if err != nil {
+		return nil, err
+	}
+	delta := &StatusDelta{OldPath: entry.Path, OldMode: entry.Mode, OldId: entry.Id}
+	switch {
+	case status&SubmoduleStatusWdDeleted != 0:
+		delta.Status = DeltaDeleted
+	case status&SubmoduleStatusWdModified != 0 || status.IsWdDirty():
+		delta.Status = DeltaModified
+		delta.NewPath = entry.Path
+		delta.NewMode = entry.Mode
+	default:
+		return nil, nil
+	}
+	delta.submodule = status & (SubmoduleStatusWdDeleted | SubmoduleStatusWdModified | SubmoduleStatusWdIndexModified | SubmoduleStatusWdWdModified | SubmoduleStatusWdUntracked)
+	return delta, nil
+}
+
+// statusUntracked walks the directory of the working directory for the
+// files that are not in the index. Ignored directories are returned as
+// "dir/" without being walked.
+func (r *Repository) statusUntracked(dir string, tracked map[string]bool, flags StatusOption) ([]*StatusDelta, error) {
+	entries, err := r.fs.ReadDir(filepath.Join(r.Workdir(), filepath.FromSlash(dir)))
+	if err != nil {
+		return nil, err
+	}
+	precompose := r.precomposeUnicode()
+	var deltas []*StatusDelta
+	for _, entry := range entries {
+		if entry.Name() == GitDirName {
+			continue
+		}
+		path := dir + precomposePath(entry.Name(), precompose)
+		if tracked[path] {
+			continue
+		}
+		isDir := entry.IsDir()
+		if isDir && tracked[path+"/"] {
+			sub, err := r.statusUntracked(path+"/", tracked, flags)
+			if err != nil {
+				return nil, err
+			}
+			deltas = append(deltas, sub...)
+			continue
+		}
+		ignored, err := r.IsPathIgnored(path)
+		if err != nil {
+			return nil, err
+		}
+		if ignored {
+			if flags&StatusOptionIncludeIgnored != 0 {
+				if isDir {
+					path += "/"
+				}
+				deltas = append(deltas, &StatusDelta{Status: DeltaIgnored, NewPath: path})
+			}
+			continue
+		}
+		if flags&StatusOptionIncludeUntracked == 0 && !isDir {
+			continue
+		}
+		if !isDir {
+			deltas = append(deltas, &StatusDelta{Status: DeltaUntracked, NewPath: path, NewMode: FilemodeBlob})
+			continue
+		}
+		if _, err := r.fs.Lstat(filepath.Join(r.Workdir(), filepath.FromSlash(path), GitDirName)); err == nil {
+			// another repository is shown as a directory
+			if flags&StatusOptionIncludeUntracked != 0 {
+				deltas = append(deltas, &StatusDelta{Status: DeltaUntracked, NewPath: path + "/"})
+			}
+			continue
+		}
+		sub, err := r.statusUntracked(path+"/", tracked, flags)
+		if err != nil {
+			return nil, err
+		}
+		deltas = append(deltas, sub...)
+	}
+	return deltas, nil
+}
+
+// collapseUntrackedDirs replaces the untracked files of the directories
+// that have no tracked files with one "dir/" entry, like git does.
+func collapseUntrackedDirs(deltas []*StatusDelta, index *Index) []*StatusDelta {
+	trackedDirs := make(map[string]bool)
+	for _, entry := range index.Entries {
+		for dir := filepath.ToSlash(filepath.Dir(entry.Path)); dir != "."; dir = filepath.ToSlash(filepath.Dir(dir)) {
+			trackedDirs[dir] = true
+		}
+	}
+	var result []*StatusDelta
+	collapsed := make(map[string]bool)
+	for _, delta := range deltas {
+		if delta.Status != DeltaUntracked {
+			result = append(result, delta)
+			continue
+		}
+		path := delta.NewPath
+		fragments := strings.Split(strings.TrimSuffix(path, "/"), "/")
+		for i := 1; i < len(fragments); i++ {
+			dir := strings.Join(fragments[:i], "/")
+			if !trackedDirs[dir] {
+				path = dir + "/"
+				break
+			}
+		}
+		if collapsed[path] {
+			continue
+		}
+		collapsed[path] = true
+		result = append(result, &StatusDelta{Status: DeltaUntracked, NewPath: path, NewMode: delta.NewMode})
+	}
+	return result
+}
+
+type statusRenameCandidate struct {
+	deleted    int
+	added      int
+	similarity int
+}
+
+// detectStatusRenames pairs up the deleted files with the added or
+// untracked files of the same or similar contents, and replaces each pair
+// with a rename. newContent reads the content of an added file.
+func (r *Repository) detectStatusRenames(deltas []*StatusDelta, threshold int, newContent func(*StatusDelta) ([]byte, error)) ([]*StatusDelta, error) {
+	var deletedIndices, addedIndices []int
+	for i, delta := range deltas {
+		switch {
+		case delta.Status == DeltaDeleted && delta.OldMode != FilemodeCommit:
+			deletedIndices = append(deletedIndices, i)
+		case delta.Status == DeltaAdded && delta.NewMode != FilemodeCommit:
+			addedIndices = append(addedIndices, i)
+		case delta.Status == DeltaUntracked && !strings.HasSuffix(delta.NewPath, "/"):
+			addedIndices = append(addedIndices, i)
+		}
+	}
+	if len(deletedIndices) == 0 || len(addedIndices) == 0 {
+		return deltas, nil
+	}
+	oldContents := make([][]byte, len(deletedIndices))
+	for i, index := range deletedIndices {
+		content, err := r.blobContent(deltas[index].OldId)
+		if err != nil {
+			return nil, err
+		}
+		oldContents[i] = content
+	}
+	newContents := make([][]byte, len(addedIndices))
+	for i, index := range addedIndices {
+		content, err := newContent(deltas[index])
+		if err != nil {
+			return nil, err
+		}
+		newContents[i] = content
+	}
+
+	// empty files are never renames, like git
+	var candidates []statusRenameCandidate
+	for i, oldContent := range oldContents {
+		if len(oldContent) == 0 {
+			continue
+		}
+		for j, newContent := range newContents {
+			if len(newContent) == 0 {
+				continue
+			}
+			similarity := 100
+			if !bytes.Equal(oldContent, newContent) {
+				similarity = contentSimilarity(oldContent, newContent)
+			}
+			if similarity >= threshold {
+				candidates = append(candidates, statusRenameCandidate{deleted: i, added: j, similarity: similarity})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].similarity > candidates[j].similarity
+	})
+	usedDeleted := make(map[int]bool)
+	usedAdded := make(map[int]bool)
+	removed := make(map[int]bool)
+	for _, candidate := range candidates {
+		if usedDeleted[candidate.deleted] || usedAdded[candidate.added] {
+			continue
+		}
+		usedDeleted[candidate.deleted] = true
+		usedAdded[candidate.added] = true
+		deleted := deltas[deletedIndices[candidate.deleted]]
+		added := deltas[addedIndices[candidate.added]]
+		newId := added.NewId
+		if newId == nil {
+			var err error
+			newId, err = hash(newContents[candidate.added], ObjectBlob)
+			if err != nil {
+				return nil, err
+			}
+		}
+		deltas[deletedIndices[candidate.deleted]] = &StatusDelta{
+			Status:     DeltaRenamed,
+			OldPath:    deleted.OldPath,
+			NewPath:    added.NewPath,
+			OldMode:    deleted.OldMode,
+			NewMode:    deleted.OldMode,
+			OldId:      deleted.OldId,
+			NewId:      newId,
+			Similarity: candidate.similarity,
+		}
+		if added.Status == DeltaAdded {
+			deltas[deletedIndices[candidate.deleted]].NewMode = added.NewMode
+		}
+		removed[addedIndices[candidate.added]] = true
+	}
+	result := make([]*StatusDelta, 0, len(deltas)-len(removed))
+	for i, delta := range deltas {
+		if !removed[i] {
+			result = append(result, delta)
+		}
+	}
+	return result, nil
+}
+
+// contentSimilarity returns how much of the lines the contents share in
+// percent.
+func contentSimilarity(a, b []byte) int {
+	aLines := bytes.SplitAfter(bytes.TrimSuffix(a, []byte("\n")), []byte("\n"))
+	bLines := bytes.SplitAfter(bytes.TrimSuffix(b, []byte("\n")), []byte("\n"))
+	counts := make(map[string]int)
+	for _, line := range aLines {
+		counts[string(line)]++
+	}
+	common := 0
+	for _, line := range bLines {
+		if counts[string(line)] > 0 {
+			counts[string(line)]--
+			common++
+		}
+	}
+	return 200 * common / (len(aLines) + len(bLines))
+}
+
+func (r *Repository) blobContent(id *Oid) ([]byte, error) {
+	blob, err := r.LookupBlob(id)
+	if err != nil {
+		return nil, err
+	}
+	return blob.Contents(), nil
+}
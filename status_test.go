@@ -0,0 +1,254 @@
+package git4go
+
+import (
+	"./testutil"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func prepareStatusRepository(t *testing.T) *Repository {
+	dir, _ := ioutil.TempDir("", "git4go_status")
+	repo, _ := InitRepositoryExtended(dir, &RepositoryInitOptions{NoTemplate: true, Hermetic: true})
+	head := writeMergeCommit(repo, map[string]string{
+		"a.txt":     "1\n2\n3\n4\n5\n6\n7\n8\n",
+		"b.txt":     "b\n",
+		"dir/c.txt": "c\n",
+	})
+	repo.CreateReference("refs/heads/master", head.Id(), true)
+	tree, _ := head.Tree()
+	if err := repo.CheckoutTree(tree, &CheckoutOptions{Force: true}); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	return repo
+}
+
+func Test_StatusList(t *testing.T) {
+	repo := prepareStatusRepository(t)
+	defer os.RemoveAll(repo.Workdir())
+	workdir := repo.Workdir()
+	ioutil.WriteFile(filepath.Join(workdir, "b.txt"), []byte("B\n"), 0644)
+	os.Remove(filepath.Join(workdir, "dir", "c.txt"))
+	os.MkdirAll(filepath.Join(workdir, "new", "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(workdir, "new", "sub", "d.txt"), []byte("d\n"), 0644)
+	ioutil.WriteFile(filepath.Join(workdir, ".gitignore"), []byte("*.o\n"), 0644)
+	ioutil.WriteFile(filepath.Join(workdir, "e.o"), []byte("e\n"), 0644)
+
+	entries, err := repo.StatusList(nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	expected := []struct {
+		path   string
+		status Status
+	}{
+		{".gitignore", StatusWtNew},
+		{"b.txt", StatusWtModified},
+		{"dir/c.txt", StatusWtDeleted},
+		{"new/", StatusWtNew},
+	}
+	if len(entries) != len(expected) {
+		t.Fatal("it should list the changed files:", len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Path() != expected[i].path || entry.Status != expected[i].status {
+			t.Error("it should list the file with its status:", entry.Path(), entry.Status)
+		}
+	}
+
+	entries, _ = repo.StatusList(&StatusOptions{Flags: StatusOptionIncludeIgnored | StatusOptionIncludeUntracked | StatusOptionRecurseUntrackedDirs})
+	if len(entries) != 5 || entries[3].Path() != "e.o" || entries[3].Status != StatusIgnored || entries[4].Path() != "new/sub/d.txt" {
+		t.Error("it should list the ignored files and the files of untracked directories")
+	}
+}
+
+func Test_StatusList_SkipWorktree(t *testing.T) {
+	repo := prepareStatusRepository(t)
+	defer os.RemoveAll(repo.Workdir())
+	workdir := repo.Workdir()
+	ioutil.WriteFile(filepath.Join(workdir, "a.txt"), []byte("A\n"), 0644)
+	ioutil.WriteFile(filepath.Join(workdir, "b.txt"), []byte("B\n"), 0644)
+	os.Remove(filepath.Join(workdir, "dir", "c.txt"))
+
+	index, _ := repo.Index()
+	if err := index.SetAssumeUnchanged("a.txt", true); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if err := index.SetSkipWorktree("dir/c.txt", true); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if err := index.SetSkipWorktree("missing.txt", true); !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should fail for a file that is not in the index:", err)
+	}
+	if err := index.Write(); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	entries, err := repo.StatusList(nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if len(entries) != 1 || entries[0].Path() != "b.txt" || entries[0].Status != StatusWtModified {
+		t.Error("it should not check the files with the flags:", len(entries))
+	}
+
+	reread, _ := OpenRepository(workdir)
+	index, _ = reread.Index()
+	if index.Version() < IndexVersionNumberExt {
+		t.Error("it should write the extended flags:", index.Version())
+	}
+	a, _ := index.EntryByPath("a.txt", 0)
+	c, _ := index.EntryByPath("dir/c.txt", 0)
+	if !a.AssumeUnchanged() || a.SkipWorktree() || !c.SkipWorktree() || c.AssumeUnchanged() {
+		t.Error("it should read the flags back")
+	}
+
+	index.SetAssumeUnchanged("a.txt", false)
+	index.SetSkipWorktree("dir/c.txt", false)
+	index.Write()
+	entries, _ = reread.StatusList(nil)
+	if len(entries) != 3 || entries[0].Path() != "a.txt" || entries[2].Status != StatusWtDeleted {
+		t.Error("it should check the files again without the flags:", len(entries))
+	}
+}
+
+func Test_StatusList_Renames(t *testing.T) {
+	repo := prepareStatusRepository(t)
+	defer os.RemoveAll(repo.Workdir())
+	workdir := repo.Workdir()
+	index, _ := repo.Index()
+	entry, _ := index.EntryByPath("a.txt", 0)
+	index.Remove("a.txt", 0)
+	index.Add(&IndexEntry{Path: "renamed.txt", Mode: entry.Mode, Id: entry.Id})
+	index.Write()
+	os.Rename(filepath.Join(workdir, "a.txt"), filepath.Join(workdir, "renamed.txt"))
+	os.Rename(filepath.Join(workdir, "b.txt"), filepath.Join(workdir, "moved.txt"))
+
+	entries, err := repo.StatusList(&StatusOptions{Flags: StatusOptionIncludeUntracked})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if len(entries) != 4 || entries[0].Status != StatusIndexDeleted || entries[3].Status != StatusIndexNew {
+		t.Error("it should not detect renames without the options:", len(entries))
+	}
+
+	entries, err = repo.StatusList(&StatusOptions{Flags: StatusOptionIncludeUntracked | StatusOptionRenamesHeadToIndex | StatusOptionRenamesIndexToWorkdir})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if len(entries) != 2 {
+		t.Fatal("it should pair up the deleted and the added files:", len(entries))
+	}
+	if entries[0].Path() != "b.txt" || entries[0].Status != StatusWtRenamed || entries[0].IndexToWorkdir.NewPath != "moved.txt" {
+		t.Error("it should detect the rename in the working directory")
+	}
+	delta := entries[1].HeadToIndex
+	if entries[1].Path() != "renamed.txt" || entries[1].Status != StatusIndexRenamed || delta.OldPath != "a.txt" || delta.Similarity != 100 {
+		t.Error("it should detect the rename in the index")
+	}
+
+	ioutil.WriteFile(filepath.Join(workdir, "renamed.txt"), []byte("1\n2\n3\n4\n5\n6\n7\n9\n"), 0644)
+	index.Remove("renamed.txt", 0)
+	blob, _ := repo.CreateBlobFromBuffer([]byte("1\n2\n3\n4\n5\n6\n7\n9\n"))
+	index.Add(&IndexEntry{Path: "renamed.txt", Mode: FilemodeBlob, Id: blob})
+	index.Write()
+	entries, _ = repo.StatusList(&StatusOptions{Flags: StatusOptionRenamesHeadToIndex})
+	if len(entries) != 2 || entries[1].Status != StatusIndexRenamed || entries[1].HeadToIndex.Similarity != 87 {
+		t.Error("it should detect the rename of similar files")
+	}
+	entries, _ = repo.StatusList(&StatusOptions{Flags: StatusOptionRenamesHeadToIndex, RenameThreshold: 90})
+	if len(entries) != 3 {
+		t.Error("it should not detect the rename below the threshold")
+	}
+}
+
+func Test_StatusList_Parallel(t *testing.T) {
+	repo := prepareStatusRepository(t)
+	defer os.RemoveAll(repo.Workdir())
+	files := make(map[string]string)
+	for i := 0; i < 150; i++ {
+		files[fmt.Sprintf("file%03d.txt", i)] = fmt.Sprintf("%d\n", i)
+	}
+	commit := writeMergeCommit(repo, files)
+	repo.CreateReference("refs/heads/master", commit.Id(), true)
+	tree, _ := commit.Tree()
+	repo.CheckoutTree(tree, &CheckoutOptions{Force: true})
+	for i := 0; i < 150; i += 10 {
+		ioutil.WriteFile(filepath.Join(repo.Workdir(), fmt.Sprintf("file%03d.txt", i)), []byte("changed\n"), 0644)
+	}
+
+	entries, err := repo.StatusList(&StatusOptions{Workers: 4})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if len(entries) != 15 {
+		t.Fatal("it should find the changed files:", len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Path() != fmt.Sprintf("file%03d.txt", i*10) || entry.Status != StatusWtModified {
+			t.Error("it should list the files in order:", entry.Path(), entry.Status)
+		}
+	}
+}
+
+func Test_StatusList_Submodules(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/submod2")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/submod2")
+	entries, err := repo.StatusList(&StatusOptions{})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	statuses := make(map[string]*StatusEntry)
+	for _, entry := range entries {
+		statuses[entry.Path()] = entry
+	}
+	expected := map[string]SubmoduleStatus{
+		"sm_changed_head":           SubmoduleStatusWdModified,
+		"sm_changed_index":          SubmoduleStatusWdIndexModified,
+		"sm_changed_file":           SubmoduleStatusWdWdModified,
+		"sm_changed_untracked_file": SubmoduleStatusWdUntracked,
+		"sm_missing_commits":        SubmoduleStatusWdModified,
+	}
+	for path, changes := range expected {
+		entry := statuses[path]
+		if entry == nil || entry.Status&StatusWtModified == 0 || entry.Submodule != changes {
+			t.Error("it should report the changes of the submodule:", path, entry)
+		}
+	}
+	if entry := statuses["sm_unchanged"]; entry != nil {
+		t.Error("it should not report an unchanged submodule:", entry.Status)
+	}
+
+	repo.Config().SetString("submodule.sm_changed_untracked_file.ignore", "untracked")
+	repo.Config().SetString("submodule.sm_changed_head.ignore", "all")
+	entries, _ = repo.StatusList(&StatusOptions{})
+	for _, entry := range entries {
+		if entry.Path() == "sm_changed_untracked_file" || entry.Path() == "sm_changed_head" {
+			t.Error("it should follow submodule.<name>.ignore:", entry.Path())
+		}
+	}
+}
+
+func Test_StatusList_PrecomposeUnicode(t *testing.T) {
+	repo := prepareStatusRepository(t)
+	defer os.RemoveAll(repo.Workdir())
+	// macOS returns decomposed names from readdir
+	ioutil.WriteFile(filepath.Join(repo.Workdir(), "dir", "cafe\u0301.txt"), []byte("d\n"), 0644)
+
+	repo.Config().SetBool("core.precomposeunicode", false)
+	entries, _ := repo.StatusList(nil)
+	if len(entries) != 1 || entries[0].Path() != "dir/cafe\u0301.txt" {
+		t.Error("it should keep the names without core.precomposeUnicode:", entries)
+	}
+	repo.Config().SetBool("core.precomposeunicode", true)
+	entries, err := repo.StatusList(nil)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if len(entries) != 1 || entries[0].Path() != "dir/caf\u00e9.txt" || entries[0].Status != StatusWtNew {
+		t.Error("it should precompose the names of the working directory:", entries)
+	}
+}
@@ -0,0 +1,421 @@
+package git4go
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	GitDirName     = ".git"
+	GitModulesFile = ".gitmodules"
+)
+
+type SubmoduleIgnore int
+
+const (
+	// Use the setting of the configuration
+	SubmoduleIgnoreUnspecified SubmoduleIgnore = -1
+	// Any change of the submodule makes it modified
+	SubmoduleIgnoreNone SubmoduleIgnore = 1
+	// Untracked files don't make the submodule dirty
+	SubmoduleIgnoreUntracked SubmoduleIgnore = 2
+	// Only a changed HEAD of the submodule makes it modified
+	SubmoduleIgnoreDirty SubmoduleIgnore = 3
+	// The submodule is never modified
+	SubmoduleIgnoreAll SubmoduleIgnore = 4
+)
+
+type SubmoduleStatus uint32
+
+const (
+	SubmoduleStatusInHead SubmoduleStatus = 1 << iota
+	SubmoduleStatusInIndex
+	SubmoduleStatusInConfig
+	SubmoduleStatusInWd
+	SubmoduleStatusIndexAdded
+	SubmoduleStatusIndexDeleted
+	SubmoduleStatusIndexModified
+	SubmoduleStatusWdUninitialized
+	SubmoduleStatusWdAdded
+	SubmoduleStatusWdDeleted
+	SubmoduleStatusWdModified
+	SubmoduleStatusWdIndexModified
+	SubmoduleStatusWdWdModified
+	SubmoduleStatusWdUntracked
+)
+
+const submoduleStatusInFlags = SubmoduleStatusInHead | SubmoduleStatusInIndex | SubmoduleStatusInConfig | SubmoduleStatusInWd
+
+// IsUnmodified returns true if the status has no change flags.
+func (s SubmoduleStatus) IsUnmodified() bool {
+	return s&^submoduleStatusInFlags == 0
+}
+
+// IsWdDirty returns true if the working directory of the submodule has
+// changes that are not committed in it.
+func (s SubmoduleStatus) IsWdDirty() bool {
+	return s&(SubmoduleStatusWdIndexModified|SubmoduleStatusWdWdModified|SubmoduleStatusWdUntracked) != 0
+}
+
+// Submodule is a submodule that is configured in .gitmodules or in the
+// config of the repository.
+type Submodule struct {
+	repo   *Repository
+	name   string
+	path   string
+	url    string
+	ignore SubmoduleIgnore
+}
+
+func (s *Submodule) Name() string {
+	return s.name
+}
+
+func (s *Submodule) Path() string {
+	return s.path
+}
+
+func (s *Submodule) Url() string {
+	return s.url
+}
+
+// Ignore returns submodule.<name>.ignore, or SubmoduleIgnoreNone if it is
+// not set.
+func (s *Submodule) Ignore() SubmoduleIgnore {
+	return s.ignore
+}
+
+// LookupSubmodule finds the submodule by its name or by its path. The
+// settings of the repository config win over the ones of .gitmodules.
+func (r *Repository) LookupSubmodule(name string) (*Submodule, error) {
+	submodules, err := r.submodules()
+	if err != nil {
+		return nil, err
+	}
+	for _, submodule := range submodules {
+		if submodule.name == name {
+			return submodule, nil
+		}
+	}
+	for _, submodule := range submodules {
+		if submodule.path == name {
+			return submodule, nil
+		}
+	}
+	return nil, MakeGitError(fmt.Sprintf("no submodule named '%s'", name), ErrNotFound)
+}
+
+// Status compares the gitlink of the submodule in HEAD and in the index
+// with the repository that is checked out in the working directory. With
+// SubmoduleIgnoreUnspecified, submodule.<name>.ignore decides how deep the
+// working directory of the submodule is checked.
+func (s *Submodule) Status(ignore SubmoduleIgnore) (SubmoduleStatus, error) {
+	if ignore == SubmoduleIgnoreUnspecified {
+		ignore = s.ignore
+	}
+	r := s.repo
+	status := SubmoduleStatusInConfig
+	headId, err := r.headGitlink(s.path)
+	if err != nil {
+		return 0, err
+	}
+	if headId != nil {
+		status |= SubmoduleStatusInHead
+	}
+	var indexId *Oid
+	index, err := r.Index()
+	if err != nil {
+		return 0, err
+	}
+	if pos := index.Find(s.path); pos != -1 && index.Entries[pos].Mode == FilemodeCommit {
+		indexId = index.Entries[pos].Id
+		status |= SubmoduleStatusInIndex
+	}
+	if ignore == SubmoduleIgnoreAll {
+		return status, nil
+	}
+
+	switch {
+	case headId == nil && indexId != nil:
+		status |= SubmoduleStatusIndexAdded
+	case headId != nil && indexId == nil:
+		status |= SubmoduleStatusIndexDeleted
+	case headId != nil && !headId.Equal(indexId):
+		status |= SubmoduleStatusIndexModified
+	}
+
+	subRepo, exists, err := s.open()
+	if err != nil {
+		return 0, err
+	}
+	if subRepo == nil {
+		if exists {
+			status |= SubmoduleStatusWdUninitialized
+		} else if indexId != nil {
+			status |= SubmoduleStatusWdDeleted
+		}
+		return status, nil
+	}
+	status |= SubmoduleStatusInWd
+	if indexId == nil {
+		status |= SubmoduleStatusWdAdded
+	}
+	var wdId *Oid
+	wdHead, err := subRepo.LookupReference(GitHeadFile)
+	if err == nil {
+		wdHead, err = wdHead.Resolve()
+	}
+	if err == nil {
+		wdId = wdHead.Target()
+	} else if !IsErrorCode(err, ErrNotFound) {
+		return 0, err
+	}
+	if indexId != nil && (wdId == nil || !wdId.Equal(indexId)) {
+		status |= SubmoduleStatusWdModified
+	}
+	if ignore == SubmoduleIgnoreDirty {
+		return status, nil
+	}
+	dirty, err := subRepo.submoduleDirtyStatus(wdId, ignore != SubmoduleIgnoreUntracked)
+	if err != nil {
+		return 0, err
+	}
+	return status | dirty, nil
+}
+
+// SubmoduleStatus is the shortcut of LookupSubmodule and Submodule.Status.
+func (r *Repository) SubmoduleStatus(name string, ignore SubmoduleIgnore) (SubmoduleStatus, error) {
+	submodule, err := r.LookupSubmodule(name)
+	if err != nil {
+		return 0, err
+	}
+	return submodule.Status(ignore)
+}
+
+// internal functions and methods
+
+func parseSubmoduleIgnore(value string) SubmoduleIgnore {
+	switch strings.ToLower(value) {
+	case "untracked":
+		return SubmoduleIgnoreUntracked
+	case "dirty":
+		return SubmoduleIgnoreDirty
+	case "all":
+		return SubmoduleIgnoreAll
+	}
+	return SubmoduleIgnoreNone
+}
+
+func (r *Repository) submodules() ([]*Submodule, error) {
+	if r.IsBare() {
+		return nil, MakeGitError("cannot find submodules of a bare repository", ErrBareRepository)
+	}
+	modules, _ := NewConfig()
+	path := filepath.Join(r.Workdir(), GitModulesFile)
+	if _, err := r.fs.Stat(path); err == nil {
+		err = modules.addFile(r.fs, path, ConfigLevelLocal, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	configs := []*Config{modules}
+	if config := r.Config(); config != nil {
+		configs = append(configs, config)
+	}
+	var submodules []*Submodule
+	found := make(map[string]*Submodule)
+	for _, config := range configs {
+		for _, name := range config.subsections("submodule") {
+			submodule := found[name]
+			if submodule == nil {
+				submodule = &Submodule{repo: r, name: name, path: name, ignore: SubmoduleIgnoreNone}
+				found[name] = submodule
+				submodules = append(submodules, submodule)
+			}
+			prefix := "submodule." + name + "."
+			if value, err := config.LookupString(prefix + "path"); err == nil && config == modules {
+				submodule.path = strings.Trim(value, "/")
+			}
+			if value, err := config.LookupString(prefix + "url"); err == nil {
+				submodule.url = value
+			}
+			if value, err := config.LookupString(prefix + "ignore"); err == nil {
+				submodule.ignore = parseSubmoduleIgnore(value)
+			}
+		}
+	}
+	return submodules, nil
+}
+
+func (r *Repository) submodulesByPath() (map[string]*Submodule, error) {
+	submodules, err := r.submodules()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]*Submodule)
+	for _, submodule := range submodules {
+		result[submodule.path] = submodule
+	}
+	return result, nil
+}
+
+func (r *Repository) headGitlink(path string) (*Oid, error) {
+	head, err := r.LookupReference(GitHeadFile)
+	if err == nil {
+		head, err = head.Resolve()
+	}
+	if IsErrorCode(err, ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	commit, err := r.LookupCommit(head.Target())
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := tree.EntryByPath(path)
+	if IsErrorCode(err, ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if entry.Filemode != FilemodeCommit {
+		return nil, nil
+	}
+	return entry.Id, nil
+}
+
+// open opens the repository of the submodule in the working directory. It
+// returns nil if the directory has no repository, and whether the
+// directory exists.
+func (s *Submodule) open() (*Repository, bool, error) {
+	r := s.repo
+	path := filepath.Join(r.Workdir(), filepath.FromSlash(s.path))
+	stat, err := r.fs.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	if !stat.IsDir() {
+		return nil, false, nil
+	}
+	// without .git, opening would find the repository of the parent
+	if _, err := r.fs.Stat(filepath.Join(path, GitDirName)); err != nil {
+		return nil, true, nil
+	}
+	flags := GIT_REPOSITORY_OPEN_NO_SEARCH
+	if r.readOnly {
+		flags |= GIT_REPOSITORY_OPEN_READ_ONLY
+	}
+	if r.hermetic {
+		flags |= GIT_REPOSITORY_OPEN_HERMETIC
+	}
+	subRepo, err := openRepository(r.fs, path, flags)
+	if err != nil {
+		return nil, true, nil
+	}
+	subRepo.clock = r.clock
+	return subRepo, true, nil
+}
+
+// submoduleDirtyStatus checks the index and the working directory of the
+// repository of a submodule against its HEAD.
+func (r *Repository) submoduleDirtyStatus(headId *Oid, checkUntracked bool) (SubmoduleStatus, error) {
+	var status SubmoduleStatus
+	index, err := r.Index()
+	if err != nil {
+		return 0, err
+	}
+	var tree *Tree
+	if headId != nil {
+		commit, err := r.LookupCommit(headId)
+		if err != nil {
+			return 0, err
+		}
+		tree, err = commit.Tree()
+		if err != nil {
+			return 0, err
+		}
+	}
+	iter, err := r.NewTreeIndexDiffIterator(tree, index)
+	if err != nil {
+		return 0, err
+	}
+	_, err = iter.Next()
+	if err == nil {
+		status |= SubmoduleStatusWdIndexModified
+	} else if !IsErrorCode(err, ErrIterOver) {
+		return 0, err
+	}
+
+	tracked := make(map[string]bool)
+	perfdata := &CheckoutPerfdata{}
+	for _, entry := range index.Entries {
+		tracked[entry.Path] = true
+		if entry.Mode == FilemodeCommit || status&SubmoduleStatusWdWdModified != 0 {
+			continue
+		}
+		exists, matches, err := r.workdirMatches(entry.Path, entry, true, perfdata)
+		if err != nil {
+			return 0, err
+		}
+		if !exists || !matches {
+			status |= SubmoduleStatusWdWdModified
+		}
+	}
+	if checkUntracked {
+		untracked, err := r.hasUntrackedFiles("", tracked)
+		if err != nil {
+			return 0, err
+		}
+		if untracked {
+			status |= SubmoduleStatusWdUntracked
+		}
+	}
+	return status, nil
+}
+
+// hasUntrackedFiles looks for a file under the directory of the working
+// directory that is not in the index and not ignored. Ignored directories
+// are not walked.
+func (r *Repository) hasUntrackedFiles(dir string, tracked map[string]bool) (bool, error) {
+	entries, err := r.fs.ReadDir(filepath.Join(r.Workdir(), filepath.FromSlash(dir)))
+	if err != nil {
+		return false, err
+	}
+	precompose := r.precomposeUnicode()
+	for _, entry := range entries {
+		if entry.Name() == GitDirName {
+			continue
+		}
+		path := precomposePath(entry.Name(), precompose)
+		if dir != "" {
+			path = dir + "/" + path
+		}
+		if tracked[path] {
+			continue
+		}
+		ignored, err := r.IsPathIgnored(path)
+		if err != nil {
+			return false, err
+		}
+		if ignored {
+			continue
+		}
+		if !entry.IsDir() {
+			return true, nil
+		}
+		untracked, err := r.hasUntrackedFiles(path, tracked)
+		if err != nil || untracked {
+			return untracked, err
+		}
+	}
+	return false, nil
+}
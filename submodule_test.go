@@ -0,0 +1,105 @@
+package git4go
+
+import (
+	"./testutil"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_SubmoduleStatus(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/submod2")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/submod2")
+	expected := map[string]SubmoduleStatus{
+		"sm_unchanged":              0,
+		"sm_changed_head":           SubmoduleStatusWdModified,
+		"sm_changed_index":          SubmoduleStatusWdIndexModified,
+		"sm_changed_file":           SubmoduleStatusWdWdModified,
+		"sm_changed_untracked_file": SubmoduleStatusWdUntracked,
+		"sm_missing_commits":        SubmoduleStatusWdModified,
+		"sm_added_and_uncommited":   SubmoduleStatusIndexAdded,
+	}
+	for name, changes := range expected {
+		status, err := repo.SubmoduleStatus(name, SubmoduleIgnoreUnspecified)
+		if err != nil {
+			t.Error("err should be nil:", name, err)
+			continue
+		}
+		if status&^submoduleStatusInFlags != changes {
+			t.Errorf("wrong status of %s. expected: %x actual: %x", name, changes, status)
+		}
+		if status&SubmoduleStatusInWd == 0 || status&SubmoduleStatusInConfig == 0 {
+			t.Error("it should be in working directory and config:", name)
+		}
+	}
+
+	status, _ := repo.SubmoduleStatus("sm_gitmodules_only", SubmoduleIgnoreUnspecified)
+	if status != SubmoduleStatusInConfig {
+		t.Errorf("it should be only in config: %x", status)
+	}
+	_, err := repo.SubmoduleStatus("just_a_dir", SubmoduleIgnoreUnspecified)
+	if !IsErrorCode(err, ErrNotFound) {
+		t.Error("it should not find directory that is not submodule")
+	}
+}
+
+func Test_SubmoduleStatusIgnore(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/submod2")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/submod2")
+	ignored := map[string]SubmoduleIgnore{
+		"sm_changed_untracked_file": SubmoduleIgnoreUntracked,
+		"sm_changed_file":           SubmoduleIgnoreDirty,
+		"sm_changed_index":          SubmoduleIgnoreDirty,
+		"sm_changed_head":           SubmoduleIgnoreAll,
+	}
+	for name, ignore := range ignored {
+		status, _ := repo.SubmoduleStatus(name, ignore)
+		if !status.IsUnmodified() {
+			t.Errorf("it should ignore changes of %s: %x", name, status)
+		}
+	}
+
+	repo.Config().SetString("submodule.sm_changed_file.ignore", "dirty")
+	submodule, _ := repo.LookupSubmodule("sm_changed_file")
+	if submodule.Ignore() != SubmoduleIgnoreDirty {
+		t.Error("it should read ignore from config:", submodule.Ignore())
+	}
+	status, _ := submodule.Status(SubmoduleIgnoreUnspecified)
+	if !status.IsUnmodified() {
+		t.Errorf("it should use ignore of config: %x", status)
+	}
+	status, _ = submodule.Status(SubmoduleIgnoreNone)
+	if !status.IsWdDirty() {
+		t.Errorf("it should override ignore of config: %x", status)
+	}
+}
+
+func Test_SubmoduleStatusIgnoredFiles(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/submod2")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/submod2")
+	dir := filepath.Join(repo.Workdir(), "sm_unchanged")
+	ioutil.WriteFile(filepath.Join(dir, ".gitignore"), []byte(".gitignore\n*.o\nbuild/\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "main.o"), []byte("o\n"), 0644)
+	os.MkdirAll(filepath.Join(dir, "build"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "build", "out"), []byte("out\n"), 0644)
+	status, err := repo.SubmoduleStatus("sm_unchanged", SubmoduleIgnoreNone)
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	if !status.IsUnmodified() {
+		t.Errorf("it should not count the ignored files as untracked: %x", status)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "main.c"), []byte("c\n"), 0644)
+	status, _ = repo.SubmoduleStatus("sm_unchanged", SubmoduleIgnoreNone)
+	if status&SubmoduleStatusWdUntracked == 0 {
+		t.Errorf("it should find the untracked file: %x", status)
+	}
+}
@@ -5,11 +5,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 var dirListCache map[string][]string = make(map[string][]string)
+var dirListCacheLock sync.Mutex
 
 func findInDirList(name string, label string) (string, error) {
+	dirListCacheLock.Lock()
 	dirs, ok := dirListCache[label]
 	if !ok {
 		switch label {
@@ -24,6 +27,7 @@ func findInDirList(name string, label string) (string, error) {
 		}
 		dirListCache[label] = dirs
 	}
+	dirListCacheLock.Unlock()
 	for _, dir := range dirs {
 		path := dir
 		if name != "" {
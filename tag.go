@@ -3,10 +3,17 @@ package git4go
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"sort"
 	"strings"
 )
 
+type CreateTagOpts struct {
+	// The tag is signed with it if it is not nil. The signature is
+	// appended to the message, so the returned field name is not used.
+	SigningCallback SigningCallback
+}
+
 func (r *Repository) LookupTag(oid *Oid) (*Tag, error) {
 	obj, err := objectLookupPrefix(r, oid, GitOidHexSize, ObjectTag)
 	if obj != nil {
@@ -38,6 +45,70 @@ func (r *Repository) ListTag() ([]string, error) {
 	return tags, nil
 }
 
+// CreateTag writes the annotated tag object and creates refs/tags/<name>
+// that points to it. If the tag exists and force is false, it fails with
+// ErrExists.
+func (r *Repository) CreateTag(name string, target Object, tagger *Signature, message string, force bool) (*Oid, error) {
+	return r.CreateTagExtended(name, target, tagger, message, force, nil)
+}
+
+// CreateTagExtended is CreateTag with options, like the callback that signs
+// the tag.
+func (r *Repository) CreateTagExtended(name string, target Object, tagger *Signature, message string, force bool, opts *CreateTagOpts) (*Oid, error) {
+	if target == nil || tagger == nil {
+		return nil, errors.New("target and tagger should not be nil")
+	}
+	if target.Owner() != r {
+		return nil, MakeGitError("the target of the tag belongs to another repository", ErrInvalid)
+	}
+	refname := GitRefsTagsDir + "/" + name
+	if _, err := referenceNormalize(refname, false, false); err != nil || name == "" {
+		return nil, MakeGitError(fmt.Sprintf("invalid tag name '%s'", name), ErrInvalidSpec)
+	}
+	if !force {
+		if _, err := r.LookupReference(refname); err == nil {
+			return nil, MakeGitError(fmt.Sprintf("tag '%s' already exists", name), ErrExists)
+		}
+	}
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "object %s\ntype %s\ntag %s\n", target.Id().String(), target.Type().String(), name)
+	writeSignature(&buffer, "tagger ", tagger)
+	buffer.WriteByte('\n')
+	buffer.WriteString(message)
+	if opts != nil && opts.SigningCallback != nil {
+		// the signature starts on its own line
+		if !strings.HasSuffix(message, "\n") {
+			buffer.WriteByte('\n')
+		}
+		signature, _, err := opts.SigningCallback(buffer.String())
+		if err != nil {
+			return nil, err
+		}
+		buffer.WriteString(signature)
+		if !strings.HasSuffix(signature, "\n") {
+			buffer.WriteByte('\n')
+		}
+	}
+
+	odb, err := r.Odb()
+	if err != nil {
+		return nil, err
+	}
+	if err = odb.checkNewObject(buffer.Bytes(), ObjectTag); err != nil {
+		return nil, err
+	}
+	oid, err := odb.Write(buffer.Bytes(), ObjectTag)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.CreateReference(refname, oid, force)
+	if err != nil {
+		return nil, err
+	}
+	return oid, nil
+}
+
 type Tag struct {
 	gitObject
 	targetType ObjectType
@@ -4,6 +4,7 @@ import (
 	"./testutil"
 	"strings"
 	"testing"
+	"time"
 )
 
 func Test_LookupTag(t *testing.T) {
@@ -90,3 +91,45 @@ func Test_ReadTagTaggerLess(t *testing.T) {
 	}
 
 }
+
+func Test_CreateTag(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	head, _ := repo.Head()
+	target, _ := repo.LookupCommit(head.Target())
+	sig := &Signature{"Test User", "test@example.com", time.Unix(1400000000, 0).UTC()}
+
+	oid, err := repo.CreateTagExtended("v9.9", target, sig, "release", false, &CreateTagOpts{
+		SigningCallback: func(data string) (string, string, error) {
+			if !strings.HasSuffix(data, "\n\nrelease\n") {
+				t.Error("the payload should end with the message:", data)
+			}
+			return testSignature, "", nil
+		},
+	})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	tag, err := repo.LookupTag(oid)
+	if err != nil || tag.Name() != "v9.9" || !tag.TargetId().Equal(target.Id()) {
+		t.Fatal("it should create a readable tag:", err)
+	}
+	if tag.Message() != "release\n"+testSignature {
+		t.Error("the signature should follow the message:", tag.Message())
+	}
+	ref, err := repo.LookupReference("refs/tags/v9.9")
+	if err != nil || !ref.Target().Equal(oid) {
+		t.Error("it should create the reference:", err)
+	}
+
+	_, err = repo.CreateTag("v9.9", target, sig, "again\n", false)
+	if !IsErrorCode(err, ErrExists) {
+		t.Error("it should not overwrite the tag:", err)
+	}
+	_, err = repo.CreateTag("bad.", target, sig, "bad\n", false)
+	if !IsErrorCode(err, ErrInvalidSpec) {
+		t.Error("it should reject the invalid name:", err)
+	}
+}
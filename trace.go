@@ -0,0 +1,94 @@
+package git4go
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type TraceLevel int
+
+const (
+	// No tracing will be performed
+	TraceNone TraceLevel = iota
+	// Severe errors that may impact the program's execution
+	TraceFatal
+	// Errors that do not impact the program's execution
+	TraceError
+	// Warnings that suggest abnormal data
+	TraceWarn
+	// Informational messages about program execution
+	TraceInfo
+	// Detailed data that allows for debugging
+	TraceDebug
+	// Exceptionally detailed debugging data
+	TraceTrace
+)
+
+// Categories of trace events
+const (
+	TraceCategoryOdb  = "odb"
+	TraceCategoryRefs = "refs"
+	TraceCategoryLock = "lock"
+	TraceCategoryNet  = "net"
+)
+
+type TraceEvent struct {
+	Level    TraceLevel
+	Category string
+	Message  string
+	Duration time.Duration
+	Fields   map[string]interface{}
+}
+
+type TraceCallback func(event *TraceEvent)
+
+type tracer struct {
+	level    TraceLevel
+	callback TraceCallback
+}
+
+var currentTracer atomic.Value
+
+// SetTracer registers the callback that receives events at the given level
+// and the levels above it. Passing TraceNone or a nil callback disables
+// tracing. The callback may be called from several goroutines at once.
+func SetTracer(level TraceLevel, callback TraceCallback) {
+	if callback == nil {
+		level = TraceNone
+	}
+	currentTracer.Store(&tracer{
+		level:    level,
+		callback: callback,
+	})
+}
+
+func traceEnabled(level TraceLevel) bool {
+	t, _ := currentTracer.Load().(*tracer)
+	return t != nil && t.level != TraceNone && level <= t.level
+}
+
+func trace(level TraceLevel, category, message string, duration time.Duration, fields map[string]interface{}) {
+	t, _ := currentTracer.Load().(*tracer)
+	if t == nil || t.level == TraceNone || level > t.level {
+		return
+	}
+	t.callback(&TraceEvent{
+		Level:    level,
+		Category: category,
+		Message:  message,
+		Duration: duration,
+		Fields:   fields,
+	})
+}
+
+// traceNet emits a TraceCategoryNet event for a request to a server, or a
+// warning with the error if it failed.
+func traceNet(message string, start time.Time, err error, fields map[string]interface{}) {
+	level := TraceDebug
+	if err != nil {
+		level = TraceWarn
+		message = "failed to " + message
+		fields["error"] = err.Error()
+	}
+	trace(level, TraceCategoryNet, message, time.Since(start), fields)
+}
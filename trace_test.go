@@ -0,0 +1,97 @@
+package git4go
+
+import (
+	"./testutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Trace(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+	defer SetTracer(TraceNone, nil)
+
+	var lock sync.Mutex
+	var events []*TraceEvent
+	SetTracer(TraceDebug, func(event *TraceEvent) {
+		lock.Lock()
+		events = append(events, event)
+		lock.Unlock()
+	})
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	odb, _ := repo.Odb()
+	oid, _ := NewOid("a65fedf39aefe402d3bb6e24df4d4f5fe4547750")
+	odb.Read(oid)
+	repo.LookupReference("refs/heads/master")
+
+	found := false
+	for _, event := range events {
+		if event.Level > TraceDebug {
+			t.Error("it should not emit events above the level:", event.Message)
+		}
+		if event.Category == TraceCategoryOdb && event.Fields["id"] == oid.String() {
+			found = true
+			if event.Fields["type"] != "commit" {
+				t.Error("it should report object type:", event.Fields["type"])
+			}
+		}
+	}
+	if !found {
+		t.Error("it should trace object read")
+	}
+
+	SetTracer(TraceNone, nil)
+	count := len(events)
+	odb.Read(oid)
+	if len(events) != count {
+		t.Error("it should not trace after disabled")
+	}
+}
+
+func Test_Trace_Net(t *testing.T) {
+	defer SetTracer(TraceNone, nil)
+	repo, server, transport, cleanup := prepareFetch(t)
+	defer cleanup()
+	first := writeMergeCommit(server, map[string]string{"a.txt": "a\n"})
+	second := writeMergeCommit(server, map[string]string{"a.txt": "a\n", "b.txt": "b\n"}, first)
+	server.CreateReference("refs/heads/master", second.Id(), true)
+	transport.uris = map[string][]byte{
+		"https://cdn.example.com/base.bundle": writeTestBundle(server, first.Id(), nil, "refs/heads/master"),
+	}
+	transport.bundles, _ = ParseBundleList([]string{"bundle.version=1", "bundle.mode=all", "bundle.base.uri=https://cdn.example.com/base.bundle"}, "")
+	transport.failures = 1
+	repo.Config().SetBool("transfer.bundleURI", true)
+	repo.Config().SetString("fetch.uriProtocols", "https")
+
+	var lock sync.Mutex
+	var events []*TraceEvent
+	SetTracer(TraceDebug, func(event *TraceEvent) {
+		lock.Lock()
+		if event.Category == TraceCategoryNet {
+			events = append(events, event)
+		}
+		lock.Unlock()
+	})
+	remote, _ := repo.LookupRemote("origin")
+	remote.SetRetryPolicy(&RetryPolicy{MaxRetries: 1, InitialBackoff: time.Millisecond})
+	if err := remote.Fetch(&FetchOptions{Download: transport.download}); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	var messages []string
+	for _, event := range events {
+		messages = append(messages, event.Message)
+	}
+	expected := []string{"connect", "download bundle", "failed to negotiate", "negotiate", "download pack", "download packfile uri"}
+	if strings.Join(messages, ",") != strings.Join(expected, ",") {
+		t.Fatal("it should trace the requests to the server:", messages)
+	}
+	if events[0].Fields["url"] != "test://server" || events[2].Level != TraceWarn || events[2].Fields["error"] == nil {
+		t.Error("it should report the fields of the requests:", events[0].Fields, events[2].Fields)
+	}
+	if events[4].Fields["bytes"].(uint) == 0 {
+		t.Error("it should report the size of the pack:", events[4].Fields)
+	}
+}
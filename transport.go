@@ -0,0 +1,233 @@
+package git4go
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ConnectDirection int
+
+const (
+	// git-upload-pack, for ls-remote and fetch
+	ConnectDirectionFetch ConnectDirection = iota
+	// git-receive-pack, for push
+	ConnectDirectionPush
+)
+
+func (d ConnectDirection) String() string {
+	if d == ConnectDirectionPush {
+		return "push"
+	}
+	return "fetch"
+}
+
+// Transport is a connection to a remote, like the SSH or HTTP transports
+// that the application provides. A Remote calls Connect once per
+// direction that it is connected for, and Close only when it is
+// disconnected, so an implementation can keep one SSH or HTTP/2 session
+// and its authentication for ls-remote, fetch and push, and open a new
+// channel or stream for each service.
+type Transport interface {
+	// Connect starts the service of the direction on the url and reads the
+	// references that it advertises.
+	Connect(url string, direction ConnectDirection) error
+	// Ls returns the references that the service of the last Connect
+	// advertised.
+	Ls() ([]RemoteHead, error)
+	// Close closes the connection.
+	Close() error
+}
+
+// FetchTransport is a Transport that can also download objects, which
+// Remote.Fetch needs. The request is sent with every round of the
+// negotiation, as the stateless protocols do.
+type FetchTransport interface {
+	Transport
+	// Negotiate sends the request with a round of haves, and returns the
+	// haves that the server acknowledged and if it is ready to send the
+	// pack, like a NegotiationCallback.
+	Negotiate(request *FetchRequest, haves []*Oid) (acks []*Oid, ready bool, err error)
+	// Download asks for the pack of the request without the objects of the
+	// common commits, and writes it to the writer.
+	Download(request *FetchRequest, common []*Oid, pack io.Writer) (*FetchResponse, error)
+}
+
+// BundleUriTransport is a FetchTransport that can also ask the server for
+// its bundle list, with the bundle-uri command of protocol v2.
+type BundleUriTransport interface {
+	FetchTransport
+	// BundleUris returns the bundle list that the server advertises, or
+	// nil if it has none.
+	BundleUris() (*BundleList, error)
+}
+
+// TransportFactory creates the transport of the remote for urls whose
+// prefix it was registered for. It should not connect yet.
+type TransportFactory func(remote *Remote) (Transport, error)
+
+var (
+	transportsLock sync.RWMutex
+	transports     = make(map[string]TransportFactory)
+)
+
+// RegisterTransport registers the factory of the transports for the urls
+// that start with the prefix, e.g. "ssh://" or "https://". It fails with
+// ErrExists if the prefix is registered already.
+func RegisterTransport(prefix string, factory TransportFactory) error {
+	transportsLock.Lock()
+	defer transportsLock.Unlock()
+
+	if _, ok := transports[prefix]; ok {
+		return MakeGitError(fmt.Sprintf("a transport for '%s' is registered already", prefix), ErrExists)
+	}
+	transports[prefix] = factory
+	return nil
+}
+
+// UnregisterTransport removes the factory of the prefix.
+func UnregisterTransport(prefix string) error {
+	transportsLock.Lock()
+	defer transportsLock.Unlock()
+
+	if _, ok := transports[prefix]; !ok {
+		return MakeGitError(fmt.Sprintf("no transport is registered for '%s'", prefix), ErrNotFound)
+	}
+	delete(transports, prefix)
+	return nil
+}
+
+// Connect connects the remote for the direction: the push url is used for
+// pushes if it is set. The connection stays open until Disconnect is
+// called and is reused: connecting again for the same direction does
+// nothing, and connecting for the other direction asks the same transport
+// for the other service if both urls use it, without dialing and
+// authenticating again.
+func (r *Remote) Connect(direction ConnectDirection) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	url := r.connectUrl(direction)
+	if url == "" {
+		return MakeGitError(fmt.Sprintf("remote '%s' has no url to %s", r.name, direction), ErrInvalid)
+	}
+	prefix, factory := lookupTransport(url)
+	if factory == nil {
+		return MakeGitError(fmt.Sprintf("unsupported URL protocol of '%s'", url), ErrInvalid)
+	}
+	if r.transport != nil {
+		if r.connected && r.direction == direction && r.connectedUrl == url {
+			return nil
+		}
+		if r.transportPrefix != prefix {
+			r.closeTransport()
+		}
+	}
+	if r.transport == nil {
+		transport, err := factory(r)
+		if err != nil {
+			return err
+		}
+		r.transport = transport
+		r.transportPrefix = prefix
+	}
+	r.connected = false
+	err := r.retryPolicy.Do(func() error {
+		start := time.Now()
+		err := r.transport.Connect(url, direction)
+		traceNet("connect", start, err, map[string]interface{}{
+			"remote":    r.name,
+			"url":       url,
+			"direction": direction.String(),
+		})
+		return err
+	})
+	if err != nil {
+		r.closeTransport()
+		return err
+	}
+	r.connected = true
+	r.direction = direction
+	r.connectedUrl = url
+	return nil
+}
+
+// SetRetryPolicy sets the policy that Connect, and the negotiation and the
+// download of a fetch, retry transient errors of the transport with, like
+// HTTP 503 responses of a busy server. A download that fails is received
+// again from the start.
+func (r *Remote) SetRetryPolicy(policy *RetryPolicy) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.retryPolicy = policy
+}
+
+// Connected tells if the remote is connected, and for which direction.
+func (r *Remote) Connected() (bool, ConnectDirection) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.connected, r.direction
+}
+
+// Ls returns the references that the remote advertised when it was
+// connected, like "git ls-remote".
+func (r *Remote) Ls() ([]RemoteHead, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !r.connected {
+		return nil, MakeGitError(fmt.Sprintf("remote '%s' is not connected", r.name), ErrInvalid)
+	}
+	return r.transport.Ls()
+}
+
+// Disconnect closes the connection of the remote. It does nothing if the
+// remote is not connected.
+func (r *Remote) Disconnect() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.closeTransport()
+}
+
+// internal functions and methods
+
+// lookupTransport returns the factory of the longest registered prefix of
+// the url.
+func lookupTransport(url string) (string, TransportFactory) {
+	transportsLock.RLock()
+	defer transportsLock.RUnlock()
+
+	found := ""
+	var factory TransportFactory
+	for prefix, candidate := range transports {
+		if strings.HasPrefix(url, prefix) && (factory == nil || len(prefix) > len(found)) {
+			found = prefix
+			factory = candidate
+		}
+	}
+	return found, factory
+}
+
+func (r *Remote) connectUrl(direction ConnectDirection) string {
+	if direction == ConnectDirectionPush && r.pushUrl != "" {
+		return r.pushUrl
+	}
+	return r.url
+}
+
+func (r *Remote) closeTransport() error {
+	transport := r.transport
+	r.transport = nil
+	r.transportPrefix = ""
+	r.connected = false
+	r.connectedUrl = ""
+	if transport == nil {
+		return nil
+	}
+	return transport.Close()
+}
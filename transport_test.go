@@ -0,0 +1,107 @@
+package git4go
+
+import (
+	"./testutil"
+	"errors"
+	"testing"
+	"time"
+)
+
+type testTransport struct {
+	connects []string
+	closed   bool
+	// Connect fails with a TransientError this many times
+	failures int
+}
+
+func (t *testTransport) Connect(url string, direction ConnectDirection) error {
+	if t.failures > 0 {
+		t.failures--
+		return &TransientError{Err: errors.New("503 Service Unavailable")}
+	}
+	t.connects = append(t.connects, direction.String()+" "+url)
+	return nil
+}
+
+func (t *testTransport) Ls() ([]RemoteHead, error) {
+	oid, _ := NewOid("099fabac3a9ea935598528c27f866e34089c2eff")
+	return []RemoteHead{{Id: oid, Name: "refs/heads/master"}}, nil
+}
+
+func (t *testTransport) Close() error {
+	t.closed = true
+	return nil
+}
+
+func Test_RemoteConnect(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	var created []*testTransport
+	failures := 0
+	err := RegisterTransport("git://", func(remote *Remote) (Transport, error) {
+		transport := &testTransport{failures: failures}
+		created = append(created, transport)
+		return transport, nil
+	})
+	if err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	defer UnregisterTransport("git://")
+	if err = RegisterTransport("git://", nil); !IsErrorCode(err, ErrExists) {
+		t.Error("it should not register a prefix twice:", err)
+	}
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	remote, _ := repo.LookupRemote("test_with_pushurl")
+	if _, err = remote.Ls(); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should not list the references before connecting:", err)
+	}
+	if err = remote.Connect(ConnectDirectionFetch); err != nil {
+		t.Fatal("err should be nil:", err)
+	}
+	heads, err := remote.Ls()
+	if err != nil || len(heads) != 1 || heads[0].Name != "refs/heads/master" {
+		t.Error("it should list the advertised references:", heads, err)
+	}
+	remote.Connect(ConnectDirectionFetch)
+	remote.Connect(ConnectDirectionPush)
+	if connected, direction := remote.Connected(); !connected || direction != ConnectDirectionPush {
+		t.Error("it should be connected for push:", connected, direction)
+	}
+	if len(created) != 1 {
+		t.Fatal("it should reuse the transport:", len(created))
+	}
+	expected := []string{"fetch git://github.com/libgit2/fetchlibgit2", "push git://github.com/libgit2/pushlibgit2"}
+	if len(created[0].connects) != 2 || created[0].connects[0] != expected[0] || created[0].connects[1] != expected[1] {
+		t.Error("it should connect once per direction:", created[0].connects)
+	}
+
+	if err = remote.Disconnect(); err != nil || !created[0].closed {
+		t.Error("it should close the transport:", err)
+	}
+	if connected, _ := remote.Connected(); connected {
+		t.Error("it should not be connected after Disconnect")
+	}
+	remote.Connect(ConnectDirectionFetch)
+	if len(created) != 2 {
+		t.Error("it should create a new transport after Disconnect:", len(created))
+	}
+	remote.Disconnect()
+
+	// the busy server answers the third time
+	failures = 2
+	if err = remote.Connect(ConnectDirectionFetch); err == nil {
+		t.Error("it should fail without retries")
+	}
+	remote.SetRetryPolicy(&RetryPolicy{MaxRetries: 2, InitialBackoff: time.Millisecond})
+	if err = remote.Connect(ConnectDirectionFetch); err != nil {
+		t.Error("it should retry the transient errors:", err)
+	}
+	remote.Disconnect()
+
+	UnregisterTransport("git://")
+	if err = remote.Connect(ConnectDirectionFetch); !IsErrorCode(err, ErrInvalid) {
+		t.Error("it should not connect without a transport:", err)
+	}
+}
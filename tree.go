@@ -1,8 +1,11 @@
 package git4go
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"path/filepath"
+	"strings"
 )
 
 type Filemode uint32
@@ -53,8 +56,29 @@ func (t *Tree) EntryByName(filename string) *TreeEntry {
 	return nil
 }
 
+// EntryByPath finds the entry by its path relative to the tree. It fails
+// with ErrNotFound if the path doesn't exist.
 func (t *Tree) EntryByPath(path string) (*TreeEntry, error) {
-	return nil, nil
+	fragments := strings.Split(strings.Trim(path, "/"), "/")
+	current := t
+	for i, fragment := range fragments {
+		entry := current.EntryByName(fragment)
+		if entry == nil {
+			break
+		}
+		if i == len(fragments)-1 {
+			return entry, nil
+		}
+		if entry.Type != ObjectTree {
+			break
+		}
+		var err error
+		current, err = t.repo.LookupTree(entry.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, MakeGitError(fmt.Sprintf("the path '%s' does not exist in the given tree", path), ErrNotFound)
 }
 
 func (t *Tree) EntryByIndex(index int) *TreeEntry {
@@ -79,25 +103,28 @@ func (t *Tree) WalkPost(callback TreeWalkCallback) error {
 }
 
 func newTree(repo *Repository, oid *Oid, contents []byte) (*Tree, error) {
+	var names *stringPool
+	if repo != nil {
+		names = repo.names
+	}
 	var entries []*TreeEntry
 	rawOffset := 0
-	var name string
 	for rawOffset < len(contents) {
 		var attr int64
+		attrStart := rawOffset
 		attr, rawOffset = strtol32(contents, rawOffset, len(contents), 8)
-		if attr == -1 {
+		if attr == -1 || rawOffset-1 == attrStart || contents[rawOffset-1] != ' ' {
 			return nil, errors.New("Tree parse error: attribute")
 		}
-		for offset := rawOffset; offset < len(contents); offset++ {
-			if contents[offset] == 0 {
-				name = string(contents[rawOffset:offset])
-				rawOffset = offset + 1
-				break
-			}
-		}
-		if name == "" {
+		nameEnd := bytes.IndexByte(contents[rawOffset:], 0)
+		if nameEnd <= 0 {
 			return nil, errors.New("Tree parse error: name")
 		}
+		name := names.intern(contents[rawOffset : rawOffset+nameEnd])
+		rawOffset += nameEnd + 1
+		if len(contents)-rawOffset < GitOidRawSize {
+			return nil, errors.New("Tree parse error: object id is truncated")
+		}
 		oid := NewOidFromBytes(contents[rawOffset:])
 		rawOffset += GitOidRawSize
 
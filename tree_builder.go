@@ -27,14 +27,28 @@ func (p TreeEntries) Len() int {
 func (p TreeEntries) Swap(i, j int) {
 	p[i], p[j] = p[j], p[i]
 }
+
+// Less sorts the entries in the order of git, which compares the names of
+// trees as if they end with "/".
 func (p TreeEntries) Less(i, j int) bool {
-	return p[i].Name < p[j].Name
+	return treeEntrySortKey(p[i]) < treeEntrySortKey(p[j])
+}
+
+func treeEntrySortKey(entry *TreeEntry) string {
+	if entry.Filemode == FilemodeTree {
+		return entry.Name + "/"
+	}
+	return entry.Name
 }
 
 func (b *TreeBuilder) Insert(filename string, oid *Oid, filemode Filemode) error {
 	if oid == nil {
 		return errors.New("oid should not be nil")
 	}
+	protectNTFS, _ := b.repo.Config().LookupBooleanWithDefaultValue("core.protectNTFS")
+	if !isValidPathComponent(filename, protectNTFS) {
+		return errors.New(fmt.Sprintf("failed to insert entry: invalid name for a tree entry - %s", filename))
+	}
 	entry := &TreeEntry{
 		Name:     filename,
 		Id:       oid,
@@ -66,5 +80,8 @@ func (b *TreeBuilder) Write() (*Oid, error) {
 		buffer.WriteByte(0)
 		buffer.Write(entry.Id[:])
 	}
+	if err := odb.checkNewObject(buffer.Bytes(), ObjectTree); err != nil {
+		return nil, err
+	}
 	return odb.Write(buffer.Bytes(), ObjectTree)
 }
@@ -45,3 +45,24 @@ func Test_TreeBuilder(t *testing.T) {
 		}
 	}
 }
+
+func Test_TreeBuilder_InvalidName(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/empty_standard_repo/")
+	defer testutil.CleanupWorkspace()
+	repo, _ := OpenRepository("test_resources/empty_standard_repo/.git")
+
+	builder, _ := repo.TreeBuilder()
+	oid, _ := NewOid("1a039633309bdb88eb5e6c46d1f8c2ade51f09e6")
+	for _, name := range []string{"", "..", "a/b", ".git"} {
+		if builder.Insert(name, oid, 0100644) == nil {
+			t.Error("it should reject invalid name:", name)
+		}
+	}
+	repo.Config().SetBool("core.protectNTFS", true)
+	if builder.Insert("NUL", oid, 0100644) == nil {
+		t.Error("it should reject reserved name when core.protectNTFS is set")
+	}
+	if len(builder.Entries) != 0 {
+		t.Error("it should not insert invalid entries")
+	}
+}
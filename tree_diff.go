@@ -0,0 +1,304 @@
+package git4go
+
+import (
+	"sort"
+	"strings"
+)
+
+// TreeDiffEntry is a changed file that TreeDiffIterator returns. The mode
+// and the id of the missing side are zero and nil.
+type TreeDiffEntry struct {
+	Status  Delta
+	Path    string
+	OldMode Filemode
+	NewMode Filemode
+	OldId   *Oid
+	NewId   *Oid
+}
+
+// TreeDiffIterator compares two trees, or a tree and an index, without
+// building a diff. It returns the changed files one by one in path order
+// and reads only the subtrees whose ids differ, so it is cheap enough to
+// run for each commit of a long history.
+type TreeDiffIterator struct {
+	repo   *Repository
+	frames []*treeDiffFrame
+}
+
+// NewTreeDiffIterator creates an iterator over the changes from oldTree to
+// newTree. Either tree can be nil for an empty tree.
+func (r *Repository) NewTreeDiffIterator(oldTree, newTree *Tree) (*TreeDiffIterator, error) {
+	iter := &TreeDiffIterator{repo: r}
+	iter.frames = []*treeDiffFrame{{
+		old: newTreeDiffTreeSide(r, oldTree),
+		new: newTreeDiffTreeSide(r, newTree),
+	}}
+	return iter, nil
+}
+
+// NewTreeIndexDiffIterator creates an iterator over the changes from the
+// tree to the index, like "git diff --cached". If index is nil, the index
+// of the repository is used. Directories that the TREE extension of the
+// index knows to be the same as the tree are skipped. Paths with
+// conflicts are returned once as DeltaConflicted.
+func (r *Repository) NewTreeIndexDiffIterator(oldTree *Tree, index *Index) (*TreeDiffIterator, error) {
+	if index == nil {
+		var err error
+		index, err = r.Index()
+		if err != nil {
+			return nil, err
+		}
+	}
+	iter := &TreeDiffIterator{repo: r}
+	iter.frames = []*treeDiffFrame{{
+		old: newTreeDiffTreeSide(r, oldTree),
+		new: newTreeDiffIndexSide(index),
+	}}
+	return iter, nil
+}
+
+// Next returns the next changed file. It fails with ErrIterOver when there
+// are no more changes.
+func (v *TreeDiffIterator) Next() (*TreeDiffEntry, error) {
+	for len(v.frames) > 0 {
+		frame := v.frames[len(v.frames)-1]
+		oldItem := frame.old.peek()
+		newItem := frame.new.peek()
+		if oldItem == nil && newItem == nil {
+			v.frames = v.frames[:len(v.frames)-1]
+			continue
+		}
+		var cmp int
+		switch {
+		case oldItem == nil:
+			cmp = 1
+		case newItem == nil:
+			cmp = -1
+		default:
+			cmp = strings.Compare(oldItem.key(), newItem.key())
+		}
+		var path string
+		if cmp <= 0 {
+			path = frame.prefix + oldItem.name
+			frame.old.advance()
+		} else {
+			path = frame.prefix + newItem.name
+		}
+		if cmp >= 0 {
+			frame.new.advance()
+		}
+
+		switch {
+		case cmp < 0 && oldItem.isTree():
+			sub, err := frame.old.descend(oldItem)
+			if err != nil {
+				return nil, err
+			}
+			v.frames = append(v.frames, &treeDiffFrame{prefix: path + "/", old: sub, new: emptyTreeDiffSide})
+		case cmp < 0:
+			return &TreeDiffEntry{Status: DeltaDeleted, Path: path, OldMode: oldItem.mode, OldId: oldItem.id}, nil
+		case cmp > 0 && newItem.isTree():
+			sub, err := frame.new.descend(newItem)
+			if err != nil {
+				return nil, err
+			}
+			v.frames = append(v.frames, &treeDiffFrame{prefix: path + "/", old: emptyTreeDiffSide, new: sub})
+		case cmp > 0 && newItem.conflicted:
+			return &TreeDiffEntry{Status: DeltaConflicted, Path: path}, nil
+		case cmp > 0:
+			return &TreeDiffEntry{Status: DeltaAdded, Path: path, NewMode: newItem.mode, NewId: newItem.id}, nil
+		case oldItem.isTree():
+			if oldItem.id != nil && newItem.id != nil && oldItem.id.Equal(newItem.id) {
+				continue
+			}
+			oldSub, err := frame.old.descend(oldItem)
+			if err != nil {
+				return nil, err
+			}
+			newSub, err := frame.new.descend(newItem)
+			if err != nil {
+				return nil, err
+			}
+			v.frames = append(v.frames, &treeDiffFrame{prefix: path + "/", old: oldSub, new: newSub})
+		case newItem.conflicted:
+			return &TreeDiffEntry{Status: DeltaConflicted, Path: path, OldMode: oldItem.mode, OldId: oldItem.id}, nil
+		case oldItem.mode == newItem.mode && oldItem.id.Equal(newItem.id):
+			continue
+		default:
+			status := DeltaModified
+			if oldItem.mode&0170000 != newItem.mode&0170000 {
+				status = DeltaTypeChange
+			}
+			return &TreeDiffEntry{
+				Status:  status,
+				Path:    path,
+				OldMode: oldItem.mode,
+				NewMode: newItem.mode,
+				OldId:   oldItem.id,
+				NewId:   newItem.id,
+			}, nil
+		}
+	}
+	return nil, MakeGitError("TreeDiffIterator.Next(): iterator is over", ErrIterOver)
+}
+
+// internal functions and methods
+
+type treeDiffFrame struct {
+	prefix string
+	old    treeDiffSide
+	new    treeDiffSide
+}
+
+// treeDiffItem is an entry of a directory on one side. Directories of an
+// index side have the id from the TREE extension, or nil if it is invalid.
+type treeDiffItem struct {
+	name       string
+	mode       Filemode
+	id         *Oid
+	conflicted bool
+	entries    []*IndexEntry
+	cache      *TreeCache
+}
+
+func (i *treeDiffItem) isTree() bool {
+	return i.mode == FilemodeTree
+}
+
+// key sorts the items in the order of tree entries
+func (i *treeDiffItem) key() string {
+	if i.isTree() {
+		return i.name + "/"
+	}
+	return i.name
+}
+
+type treeDiffSide interface {
+	peek() *treeDiffItem
+	advance()
+	descend(item *treeDiffItem) (treeDiffSide, error)
+}
+
+var emptyTreeDiffSide treeDiffSide = &treeDiffTreeSide{}
+
+type treeDiffTreeSide struct {
+	repo    *Repository
+	entries []*TreeEntry
+	pos     int
+}
+
+func newTreeDiffTreeSide(repo *Repository, tree *Tree) treeDiffSide {
+	if tree == nil {
+		return emptyTreeDiffSide
+	}
+	return &treeDiffTreeSide{repo: repo, entries: tree.Entries}
+}
+
+func (s *treeDiffTreeSide) peek() *treeDiffItem {
+	if s.pos >= len(s.entries) {
+		return nil
+	}
+	entry := s.entries[s.pos]
+	return &treeDiffItem{name: entry.Name, mode: entry.Filemode, id: entry.Id}
+}
+
+func (s *treeDiffTreeSide) advance() {
+	s.pos++
+}
+
+func (s *treeDiffTreeSide) descend(item *treeDiffItem) (treeDiffSide, error) {
+	tree, err := s.repo.LookupTree(item.id)
+	if err != nil {
+		return nil, err
+	}
+	return newTreeDiffTreeSide(s.repo, tree), nil
+}
+
+type treeDiffIndexSide struct {
+	prefix  string
+	entries []*IndexEntry
+	cache   *TreeCache
+	pos     int
+	current *treeDiffItem
+	next    int
+}
+
+func newTreeDiffIndexSide(index *Index) treeDiffSide {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	index.sortEntriesIfNeeded(index.ignoreCase, false)
+	index.entriesSorted = true
+	var entries indexEntriesCaseSensitive = make([]*IndexEntry, 0, len(index.Entries))
+	cache := index.tree
+	for _, entry := range index.Entries {
+		if entry.Stage() != 0 {
+			// the cached counts are only for merged indexes
+			cache = nil
+			if len(entries) > 0 && entries[len(entries)-1].Path == entry.Path {
+				continue
+			}
+		}
+		entries = append(entries, entry)
+	}
+	if index.ignoreCase {
+		sort.Stable(entries)
+	}
+	return &treeDiffIndexSide{entries: entries, cache: cache}
+}
+
+func (s *treeDiffIndexSide) peek() *treeDiffItem {
+	if s.current != nil {
+		return s.current
+	}
+	if s.pos >= len(s.entries) {
+		return nil
+	}
+	entry := s.entries[s.pos]
+	name := entry.Path[len(s.prefix):]
+	slash := strings.IndexByte(name, '/')
+	if slash == -1 {
+		s.current = &treeDiffItem{
+			name:       name,
+			mode:       entry.Mode,
+			id:         entry.Id,
+			conflicted: entry.Stage() != 0,
+		}
+		s.next = s.pos + 1
+		return s.current
+	}
+	name = name[:slash]
+	item := &treeDiffItem{name: name, mode: FilemodeTree}
+	if s.cache != nil {
+		item.cache = s.cache.child(name)
+	}
+	if item.cache != nil && item.cache.entryCount >= 0 && s.pos+item.cache.entryCount <= len(s.entries) {
+		item.id = item.cache.oid
+		s.next = s.pos + item.cache.entryCount
+	} else {
+		childPrefix := s.prefix + name + "/"
+		s.next = s.pos + 1
+		for s.next < len(s.entries) && strings.HasPrefix(s.entries[s.next].Path, childPrefix) {
+			s.next++
+		}
+	}
+	item.entries = s.entries[s.pos:s.next]
+	s.current = item
+	return item
+}
+
+func (s *treeDiffIndexSide) advance() {
+	if s.peek() == nil {
+		return
+	}
+	s.pos = s.next
+	s.current = nil
+}
+
+func (s *treeDiffIndexSide) descend(item *treeDiffItem) (treeDiffSide, error) {
+	return &treeDiffIndexSide{
+		prefix:  s.prefix + item.name + "/",
+		entries: item.entries,
+		cache:   item.cache,
+	}, nil
+}
@@ -0,0 +1,104 @@
+package git4go
+
+import (
+	"./testutil"
+	"testing"
+)
+
+func collectTreeDiff(iter *TreeDiffIterator, t *testing.T) []string {
+	var result []string
+	for {
+		entry, err := iter.Next()
+		if IsErrorCode(err, ErrIterOver) {
+			return result
+		} else if err != nil {
+			t.Fatal("err should be nil:", err)
+		}
+		result = append(result, entry.Status.String()+" "+entry.Path)
+	}
+}
+
+func checkTreeDiff(actual, expected []string, t *testing.T) {
+	if len(actual) != len(expected) {
+		t.Error("it should return", len(expected), "changes, but", actual)
+		return
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Error("wrong change. expected:", expected[i], "actual:", actual[i])
+		}
+	}
+}
+
+func Test_TreeDiffIterator(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo")
+	lookup := func(id string) *Tree {
+		oid, _ := NewOid(id)
+		commit, err := repo.LookupCommit(oid)
+		if err != nil {
+			t.Fatal("err should be nil:", err)
+		}
+		tree, _ := commit.Tree()
+		return tree
+	}
+	subtrees := lookup("763d71aadf09a7951596c9746c024e7eece7c7af")
+	other := lookup("cf80f8de9f1185bf3a05f993f6121880dd0cfbc9")
+
+	iter, _ := repo.NewTreeDiffIterator(subtrees, other)
+	checkTreeDiff(collectTreeDiff(iter, t), []string{
+		"modified README",
+		"added a/b.txt",
+		"deleted ab/4.txt",
+		"deleted ab/c/3.txt",
+		"deleted ab/de/2.txt",
+		"deleted ab/de/fgh/1.txt",
+		"modified new.txt",
+	}, t)
+
+	iter, _ = repo.NewTreeDiffIterator(nil, other)
+	checkTreeDiff(collectTreeDiff(iter, t), []string{
+		"added README",
+		"added a/b.txt",
+		"added branch_file.txt",
+		"added new.txt",
+	}, t)
+
+	iter, _ = repo.NewTreeDiffIterator(subtrees, subtrees)
+	_, err := iter.Next()
+	if !IsErrorCode(err, ErrIterOver) {
+		t.Error("it should not return changes for same tree")
+	}
+}
+
+func Test_TreeIndexDiffIterator(t *testing.T) {
+	testutil.PrepareWorkspace("test_resources/testrepo")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo")
+	oid, _ := NewOid("763d71aadf09a7951596c9746c024e7eece7c7af")
+	commit, _ := repo.LookupCommit(oid)
+	tree, _ := commit.Tree()
+	index, _ := repo.Index()
+	index.ReadTree(tree)
+
+	iter, _ := repo.NewTreeIndexDiffIterator(tree, nil)
+	_, err := iter.Next()
+	if !IsErrorCode(err, ErrIterOver) {
+		t.Error("it should not return changes for index that is read from the tree")
+	}
+
+	blobId, _ := NewOid("270b8ea76056d5cad83af921837702d3e3c2924d")
+	index.Add(&IndexEntry{Path: "ab/c/5.txt", Mode: FilemodeBlobExecutable, Id: blobId})
+	index.Remove("ab/de/fgh/1.txt", 0)
+	index.Remove("new.txt", 0)
+	index.Add(&IndexEntry{Path: "new.txt", Mode: FilemodeLink, Id: blobId})
+	iter, _ = repo.NewTreeIndexDiffIterator(tree, index)
+	checkTreeDiff(collectTreeDiff(iter, t), []string{
+		"added ab/c/5.txt",
+		"deleted ab/de/fgh/1.txt",
+		"typechange new.txt",
+	}, t)
+}
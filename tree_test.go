@@ -239,3 +239,16 @@ func Test_TreeWalkPostSkip(t *testing.T) {
 		t.Error("callback should be called:", fileCount)
 	}
 }
+
+func Benchmark_LookupTree(b *testing.B) {
+	testutil.PrepareWorkspace("test_resources/testrepo.git")
+	defer testutil.CleanupWorkspace()
+
+	repo, _ := OpenRepository("test_resources/testrepo.git")
+	oid, _ := NewOid("53fc32d17276939fc79ed05badaef2db09990016")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		repo.LookupTree(oid)
+	}
+}
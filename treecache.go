@@ -7,6 +7,9 @@ import (
 	"strings"
 )
 
+// TreeCache is the TREE extension of the index. Each node remembers the
+// tree id of a directory and how many index entries it covers. A node with
+// an entryCount of -1 is invalid and its tree has to be written again.
 type TreeCache struct {
 	children []*TreeCache
 
@@ -19,7 +22,7 @@ func (v *TreeCache) write(buffer *bytes.Buffer) {
 	buffer.WriteString(v.name)
 	buffer.WriteByte(0)
 	fmt.Fprintf(buffer, "%d %d\n", v.entryCount, len(v.children))
-	if v.entryCount != -1 {
+	if v.entryCount >= 0 {
 		buffer.Write(v.oid[:])
 	}
 	for _, child := range v.children {
@@ -27,68 +30,138 @@ func (v *TreeCache) write(buffer *bytes.Buffer) {
 	}
 }
 
+func (v *TreeCache) child(name string) *TreeCache {
+	for _, child := range v.children {
+		if child.name == name {
+			return child
+		}
+	}
+	return nil
+}
+
 func (v *TreeCache) get(path string) *TreeCache {
 	current := v
 	for _, pathFragment := range strings.Split(path, "/") {
 		if pathFragment == "" {
 			continue
 		}
-		for _, child := range current.children {
-			if child.name == pathFragment {
-				current = child
-				continue
-			}
+		current = current.child(pathFragment)
+		if current == nil {
+			return nil
 		}
-		return nil
 	}
 	return current
 }
 
+// invalidatePath marks the trees that contain the path as changed. Other
+// trees keep their ids, so the next WriteTree doesn't write them again.
 func (v *TreeCache) invalidatePath(path string) {
-	v.entryCount--
+	if v == nil {
+		return
+	}
+	v.entryCount = -1
 	current := v
-	for _, pathFragment := range strings.Split(path, "/") {
-		if pathFragment == "" {
+	fragments := strings.Split(path, "/")
+	for _, pathFragment := range fragments[:len(fragments)-1] {
+		current = current.child(pathFragment)
+		if current == nil {
+			return /* we don't have that tree */
+		}
+		current.entryCount = -1
+	}
+}
+
+// update returns the id of the tree of the entries, which are all under
+// the prefix and sorted. Trees of valid nodes are reused, the others are
+// written to the odb and cached.
+func (v *TreeCache) update(odb *Odb, entries []*IndexEntry, prefix string) (*Oid, error) {
+	if v.entryCount >= 0 && v.oid != nil {
+		return v.oid, nil
+	}
+	var buffer bytes.Buffer
+	children := make([]*TreeCache, 0, len(v.children))
+	for i := 0; i < len(entries); {
+		entry := entries[i]
+		name := entry.Path[len(prefix):]
+		slash := strings.IndexByte(name, '/')
+		if slash == -1 {
+			fmt.Fprintf(&buffer, "%o %s", int(entry.Mode), name)
+			buffer.WriteByte(0)
+			buffer.Write(entry.Id[:])
+			i++
 			continue
 		}
-		for _, child := range current.children {
-			if child.name == pathFragment {
-				current = child
-				current.entryCount--
-				continue
+		name = name[:slash]
+		childPrefix := prefix + name + "/"
+		child := v.child(name)
+		if child == nil {
+			child = &TreeCache{name: name, entryCount: -1}
+		}
+		var end int
+		if child.entryCount >= 0 && i+child.entryCount <= len(entries) {
+			// a valid tree covers the next entryCount entries
+			end = i + child.entryCount
+		} else {
+			child.entryCount = -1
+			end = i + 1
+			for end < len(entries) && strings.HasPrefix(entries[end].Path, childPrefix) {
+				end++
 			}
 		}
-		return /* we don't have that tree */
+		oid, err := child.update(odb, entries[i:end], childPrefix)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buffer, "%o %s", int(FilemodeTree), name)
+		buffer.WriteByte(0)
+		buffer.Write(oid[:])
+		children = append(children, child)
+		i = end
+	}
+	if err := odb.checkNewObject(buffer.Bytes(), ObjectTree); err != nil {
+		return nil, err
+	}
+	oid, err := odb.Write(buffer.Bytes(), ObjectTree)
+	if err != nil {
+		return nil, err
 	}
+	v.children = children
+	v.entryCount = len(entries)
+	v.oid = oid
+	return oid, nil
 }
 
 func readTreeInternal(buffer []byte, offset, bufferEnd int) (*TreeCache, int, error) {
 	nameEnd := findChar(buffer, 0, offset, bufferEnd)
-	if nameEnd == -1 || bufferEnd-nameEnd < 8 {
+	if nameEnd == -1 || bufferEnd-nameEnd < 5 {
 		return nil, offset, errors.New("Corrupted TREE extension in index")
 	}
 	name := string(buffer[offset:nameEnd])
 	offset = nameEnd + 1
-	entryCount, newOffset := strtol32(buffer, offset, bufferEnd, 10)
-	childCount, newOffset := strtol32(buffer, newOffset, bufferEnd, 10)
-	if entryCount == -1 || childCount == -1 {
+	lineEnd := findChar(buffer, '\n', offset, bufferEnd)
+	if lineEnd == -1 {
+		return nil, offset, errors.New("Corrupted TREE extension in index")
+	}
+	var entryCount, childCount int
+	_, err := fmt.Sscanf(string(buffer[offset:lineEnd]), "%d %d", &entryCount, &childCount)
+	if err != nil || entryCount < -1 || childCount < 0 {
 		return nil, offset, errors.New("Corrupted TREE extension in index")
 	}
 
 	cache := &TreeCache{
 		name:       name,
 		children:   make([]*TreeCache, childCount),
-		entryCount: int(entryCount),
+		entryCount: entryCount,
 	}
-	offset = newOffset
-	if entryCount > 0 {
+	offset = lineEnd + 1
+	if entryCount >= 0 {
 		if offset+GitOidRawSize > bufferEnd {
 			return nil, offset, errors.New("Corrupted TREE extension in index")
 		}
 		cache.oid = NewOidFromBytes(buffer[offset : offset+GitOidRawSize])
 		offset += GitOidRawSize
 	}
-	for i := 0; i < int(childCount); i++ {
+	for i := 0; i < childCount; i++ {
 		child, newOffset, err := readTreeInternal(buffer, offset, bufferEnd)
 		if err != nil {
 			return nil, offset, err
@@ -96,7 +169,7 @@ func readTreeInternal(buffer []byte, offset, bufferEnd int) (*TreeCache, int, er
 		offset = newOffset
 		cache.children[i] = child
 	}
-	return nil, offset, errors.New("Corrupted TREE extension in index")
+	return cache, offset, nil
 }
 
 func readTreeCache(buffer []byte, offset, extensionSize int) (*TreeCache, error) {
@@ -112,17 +185,11 @@ func readTreeCache(buffer []byte, offset, extensionSize int) (*TreeCache, error)
 
 func readTreeCacheFromTreeRecursive(tree *Tree, cache *TreeCache) error {
 	cache.oid = tree.Id()
-	treeCount := 0
+	cache.children = make([]*TreeCache, 0, len(tree.Entries))
+	cache.entryCount = 0
 	for _, entry := range tree.Entries {
-		if entry.Filemode == FilemodeTree {
-			treeCount++
-		}
-	}
-	cache.children = make([]*TreeCache, treeCount)
-	entryCount := 0
-	for i, entry := range tree.Entries {
 		if entry.Filemode != FilemodeTree {
-			entryCount++
+			cache.entryCount++
 			continue
 		}
 		childCache := &TreeCache{
@@ -137,12 +204,16 @@ func readTreeCacheFromTreeRecursive(tree *Tree, cache *TreeCache) error {
 			return err
 		}
 		cache.entryCount += childCache.entryCount
-		cache.children[i] = childCache
+		cache.children = append(cache.children, childCache)
 	}
 	return nil
 }
 
-func createTreeCacheFromTree(tree *Tree) error {
+func createTreeCacheFromTree(tree *Tree) (*TreeCache, error) {
 	cache := &TreeCache{}
-	return readTreeCacheFromTreeRecursive(tree, cache)
+	err := readTreeCacheFromTreeRecursive(tree, cache)
+	if err != nil {
+		return nil, err
+	}
+	return cache, nil
 }
@@ -71,3 +71,36 @@ func calcHash(buffer []byte) *Oid {
 	copy(oid[:], sha1Hash[:])
 	return oid
 }
+
+// decodeVarint reads the offset encoding of git that is used for ofs-delta
+// and index v4 paths. It returns -1 as the offset if the buffer ends.
+func decodeVarint(buffer []byte, offset, bound int) (uint64, int) {
+	if offset >= bound {
+		return 0, -1
+	}
+	c := buffer[offset]
+	offset++
+	value := uint64(c & 127)
+	for c&128 != 0 {
+		if offset >= bound || value >= 1<<56 {
+			return 0, -1
+		}
+		value++
+		c = buffer[offset]
+		offset++
+		value = (value << 7) + uint64(c&127)
+	}
+	return value, offset
+}
+
+func encodeVarint(value uint64) []byte {
+	var varint [16]byte
+	pos := len(varint) - 1
+	varint[pos] = byte(value & 127)
+	for value >>= 7; value != 0; value >>= 7 {
+		value--
+		pos--
+		varint[pos] = 128 | byte(value&127)
+	}
+	return varint[pos:]
+}